@@ -0,0 +1,148 @@
+// Command banksim serves the bank simulator, in place of the mountebank
+// imposter in docker-compose.yml:
+//
+//	go run ./cmd/banksim -addr :8081 -latency 50ms-200ms -error-rate 0.05
+//
+// or, to rehearse incidents, with the scenarios in a file, switching
+// between them at runtime through the control API under /_simulator:
+//
+//	go run ./cmd/banksim -scenarios scenarios.example.yaml -scenario flaky
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+)
+
+func main() {
+	fs := flag.NewFlagSet("banksim", flag.ContinueOnError)
+	addr := fs.String("addr", ":8081", "address to listen on")
+	latency := fs.String("latency", "0", "delay before every answer, a duration or a min-max range such as 50ms-200ms")
+	errorRate := fs.Float64("error-rate", 0, "fraction of requests, between 0 and 1, answered with -error-status")
+	errorStatus := fs.Int("error-status", http.StatusInternalServerError, "HTTP status of injected errors")
+	seed := fs.Int64("seed", 0, "seed for latency and faults, for repeatable runs (default random)")
+	scenarioFile := fs.String("scenarios", "", "YAML file of scenarios to define")
+	scenario := fs.String("scenario", "", "scenario to start in (default the file's active scenario, or the -latency and -error-rate settings)")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+
+	minLatency, maxLatency, err := parseLatency(*latency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -latency: %v\n", err)
+		os.Exit(2)
+	}
+	if *errorRate < 0 || *errorRate > 1 {
+		fmt.Fprintln(os.Stderr, "-error-rate must be between 0 and 1")
+		os.Exit(2)
+	}
+
+	opts := []banksim.Option{
+		banksim.WithLatency(minLatency, maxLatency),
+		banksim.WithErrorRate(*errorRate, *errorStatus),
+	}
+	if *seed != 0 {
+		opts = append(opts, banksim.WithSeed(*seed))
+	}
+
+	sim := banksim.New(opts...)
+	if err := loadScenarios(sim, *scenarioFile, *scenario); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := run(*addr, sim); err != nil {
+		slog.Error("bank simulator failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// loadScenarios defines the scenarios in path, if any, and activates the
+// one asked for
+func loadScenarios(sim *banksim.Simulator, path, active string) error {
+	if path != "" {
+		file, err := banksim.LoadScenarioFile(path)
+		if err != nil {
+			return err
+		}
+		for _, sc := range file.Scenarios {
+			if err := sim.Define(sc); err != nil {
+				return err
+			}
+		}
+		if active == "" {
+			active = file.Active
+		}
+	}
+
+	if active == "" {
+		return nil
+	}
+	if err := sim.Activate(active); err != nil {
+		return err
+	}
+	slog.Info("scenario active", "scenario", active)
+	return nil
+}
+
+// parseLatency reads "100ms" as a fixed delay and "50ms-200ms" as a range
+func parseLatency(s string) (time.Duration, time.Duration, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	min, err := time.ParseDuration(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	max, err := time.ParseDuration(hi)
+	if err != nil {
+		return 0, 0, err
+	}
+	if max < min {
+		return 0, 0, fmt.Errorf("%s is shorter than %s", hi, lo)
+	}
+	return min, max, nil
+}
+
+func run(addr string, handler http.Handler) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("bank simulator listening", "addr", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down bank simulator")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
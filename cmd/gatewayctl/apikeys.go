@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"gopkg.in/yaml.v3"
+)
+
+// API keys are configured as merchants' SHA-256 hashes in the gateway's
+// config file, which the gateway reads again on SIGHUP. These commands edit
+// that file; the keys themselves are only ever shown once.
+
+// minHashPrefix is the shortest hash prefix revoke accepts, so a typo does
+// not match a key by chance
+const minHashPrefix = 8
+
+const reloadHint = "send SIGHUP to the gateway to apply the change"
+
+func apiKeysGenerate(ctx context.Context, e *env, args []string) error {
+	if _, err := parse(e.flagSet("apikeys generate", ""), args, 0, 0); err != nil {
+		return err
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+	return e.renderKey("", key)
+}
+
+func apiKeysHash(ctx context.Context, e *env, args []string) error {
+	if _, err := parse(e.flagSet("apikeys hash", ""), args, 0, 0); err != nil {
+		return err
+	}
+
+	// Read from stdin so the key stays out of shell history
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	key := strings.TrimRight(line, "\r\n")
+	if key == "" {
+		if err == nil {
+			err = errors.New("no API key on stdin")
+		}
+		return fmt.Errorf("failed to read API key: %w", err)
+	}
+
+	fmt.Fprintln(e.stdout, merchant.HashAPIKey(key))
+	return nil
+}
+
+func apiKeysList(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("apikeys list", "-config FILE")
+	path := fs.String("config", os.Getenv("GATEWAY_CONFIG"), "gateway config file")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *path == "" {
+		return errors.New("-config is required")
+	}
+
+	cfg := config.Default()
+	if err := config.LoadFile(cfg, *path); err != nil {
+		return err
+	}
+
+	type merchantKeys struct {
+		ID           string   `json:"id"`
+		APIKeySHA256 []string `json:"api_key_sha256"`
+	}
+	list := []merchantKeys{}
+	t := table{header: []string{"MERCHANT", "KEY SHA-256"}}
+	for _, m := range cfg.Merchants {
+		list = append(list, merchantKeys{ID: m.ID, APIKeySHA256: m.APIKeySHA256})
+		for _, hash := range m.APIKeySHA256 {
+			t.add(m.ID, hash)
+		}
+	}
+	return e.render(list, t)
+}
+
+func apiKeysAdd(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("apikeys add", "-config FILE -merchant ID")
+	path := fs.String("config", os.Getenv("GATEWAY_CONFIG"), "gateway config file to edit")
+	merchantID := fs.String("merchant", "", "merchant to add the key to, added to the file if it is not there")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if *path == "" || *merchantID == "" {
+		return errors.New("-config and -merchant are required")
+	}
+
+	doc, err := readConfigNode(*path)
+	if err != nil {
+		return err
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		return err
+	}
+
+	merchants := mappingValue(doc.Content[0], "merchants", yaml.SequenceNode)
+	merchants.Style = 0 // block style, even if the file had merchants: []
+	m := findMerchant(merchants, *merchantID)
+	if m == nil {
+		m = &yaml.Node{Kind: yaml.MappingNode}
+		m.Content = append(m.Content, scalar("id"), scalar(*merchantID))
+		merchants.Content = append(merchants.Content, m)
+		fmt.Fprintf(e.stderr, "added merchant %s with the default limits\n", *merchantID)
+	}
+	hashes := mappingValue(m, "api_key_sha256", yaml.SequenceNode)
+	hashes.Content = append(hashes.Content, scalar(merchant.HashAPIKey(key)))
+
+	if err := writeConfigNode(*path, doc); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(e.stderr, "the key is only shown now; "+reloadHint)
+	return e.renderKey(*merchantID, key)
+}
+
+func apiKeysRevoke(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("apikeys revoke", "-config FILE -merchant ID <hash-prefix>")
+	path := fs.String("config", os.Getenv("GATEWAY_CONFIG"), "gateway config file to edit")
+	merchantID := fs.String("merchant", "", "merchant the key belongs to")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *path == "" || *merchantID == "" {
+		return errors.New("-config and -merchant are required")
+	}
+	prefix := strings.ToLower(args[0])
+	if len(prefix) < minHashPrefix {
+		return fmt.Errorf("give at least %d characters of the key's hash", minHashPrefix)
+	}
+
+	doc, err := readConfigNode(*path)
+	if err != nil {
+		return err
+	}
+
+	m := findMerchant(mappingValue(doc.Content[0], "merchants", yaml.SequenceNode), *merchantID)
+	if m == nil {
+		return fmt.Errorf("merchant %s is not in %s", *merchantID, *path)
+	}
+	hashes := mappingValue(m, "api_key_sha256", yaml.SequenceNode)
+
+	match := -1
+	for i, h := range hashes.Content {
+		if !strings.HasPrefix(h.Value, prefix) {
+			continue
+		}
+		if match >= 0 {
+			return fmt.Errorf("%s matches more than one of %s's keys, give more of the hash", prefix, *merchantID)
+		}
+		match = i
+	}
+	if match < 0 {
+		return fmt.Errorf("%s has no key whose hash starts with %s", *merchantID, prefix)
+	}
+	if len(hashes.Content) == 1 {
+		return fmt.Errorf("that is %s's only key; add another first, or remove the merchant from the config", *merchantID)
+	}
+
+	revoked := hashes.Content[match].Value
+	hashes.Content = append(hashes.Content[:match], hashes.Content[match+1:]...)
+
+	if err := writeConfigNode(*path, doc); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.stderr, "revoked %s's key %s; %s\n", *merchantID, revoked, reloadHint)
+	return nil
+}
+
+// renderKey shows a new key, its hash and, for -output table, the config
+// that configures it
+func (e *env) renderKey(merchantID, key string) error {
+	hash := merchant.HashAPIKey(key)
+	v := struct {
+		Merchant     string `json:"merchant,omitempty"`
+		APIKey       string `json:"api_key"`
+		APIKeySHA256 string `json:"api_key_sha256"`
+	}{merchantID, key, hash}
+
+	return e.render(v, details(
+		"merchant", merchantID,
+		"api_key", key,
+		"api_key_sha256", hash,
+	))
+}
+
+// generateAPIKey returns a random key with 256 bits of entropy
+func generateAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return "sk_" + hex.EncodeToString(b), nil
+}
+
+// readConfigNode parses the config file as a YAML tree, so it can be edited
+// and written back with its comments and layout
+func readConfigNode(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		// An empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s is not a YAML mapping", path)
+	}
+	return &doc, nil
+}
+
+// writeConfigNode replaces the config file with doc, once the gateway would
+// accept it. The file is replaced atomically and keeps its permissions.
+func writeConfigNode(path string, doc *yaml.Node) error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	enc.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	cfg := config.Default()
+	if err := config.LoadFile(cfg, tmp.Name()); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("not changing %s, the result would be invalid: %w", path, err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// mappingValue returns the value of key in a mapping node, adding an empty
+// one of kind if the key is missing
+func mappingValue(mapping *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			v := mapping.Content[i+1]
+			if v.Kind == yaml.ScalarNode && v.Tag == "!!null" {
+				*v = yaml.Node{Kind: kind}
+			}
+			return v
+		}
+	}
+
+	v := &yaml.Node{Kind: kind}
+	mapping.Content = append(mapping.Content, scalar(key), v)
+	return v
+}
+
+// findMerchant returns the merchant with id in the merchants sequence
+func findMerchant(merchants *yaml.Node, id string) *yaml.Node {
+	for _, m := range merchants.Content {
+		if m.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 0; i+1 < len(m.Content); i += 2 {
+			if m.Content[i].Value == "id" && m.Content[i+1].Value == id {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+func scalar(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+)
+
+func blocklistAdd(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("blocklist add", "[-reason R] [-token T]")
+	reason := fs.String("reason", "", "why the card is blocked")
+	token := fs.String("token", "", "block the card behind this vault token instead of reading a card number from stdin")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	req := &gatewayclient.BlockCardRequest{Reason: *reason}
+	if *token != "" {
+		req.Source = &gatewayclient.PaymentSource{Token: *token}
+	} else {
+		// Read from stdin so the card number stays out of shell history
+		line, err := bufio.NewReader(e.stdin).ReadString('\n')
+		req.CardNumber = strings.TrimSpace(line)
+		if req.CardNumber == "" {
+			if err == nil {
+				err = errors.New("no card number on stdin")
+			}
+			return fmt.Errorf("failed to read card number: %w", err)
+		}
+	}
+
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	card, err := c.BlockCard(ctx, req)
+	if err != nil {
+		return err
+	}
+	return e.render(card, details(
+		"id", card.ID,
+		"card", "**** "+card.CardNumberLastFour,
+		"reason", card.Reason,
+		"created_at", formatTime(card.CreatedAt),
+	))
+}
+
+func blocklistList(ctx context.Context, e *env, args []string) error {
+	if _, err := parse(e.flagSet("blocklist list", ""), args, 0, 0); err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	cards, err := c.ListBlockedCards(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"ID", "CARD", "REASON", "CREATED AT"}}
+	for _, card := range cards {
+		t.add(card.ID, "**** "+card.CardNumberLastFour, orDash(card.Reason), formatTime(card.CreatedAt))
+	}
+	return e.render(cards, t)
+}
+
+func blocklistRemove(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("blocklist remove", "<id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	if err := c.UnblockCard(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "unblocked card %s\n", args[0])
+	return nil
+}
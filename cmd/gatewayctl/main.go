@@ -0,0 +1,254 @@
+// Command gatewayctl lets operators inspect and manage the gateway without
+// hand-written curl commands:
+//
+//	gatewayctl payments get 550e8400-e29b-41d4-a716-446655440000
+//	gatewayctl -output json payments list -status Declined -all
+//	gatewayctl payments refund -amount 40 550e8400-e29b-41d4-a716-446655440000
+//	gatewayctl webhooks replay -endpoint 0b6f7c1e-9a3d-4f2b-8e51-2c7d9a4b6e10
+//	gatewayctl blocklist add -reason chargeback < card-number.txt
+//	gatewayctl apikeys add -config /etc/payment-gateway/config.yaml -merchant merchant-1
+//	gatewayctl reports export -format csv -file payments.csv
+//
+// It calls the API at -url. With -storage-dir it opens the file storage
+// backend directly instead, for webhook commands and for resolving parked
+// payments and subscription renewals while the gateway is stopped.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+)
+
+// command is one gatewayctl command, such as "payments get"
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, e *env, args []string) error
+}
+
+// commands lists every command, by group
+var commands = []command{
+	{"payments get", "<id>", "show a payment", paymentsGet},
+	{"payments list", "[-status S] [-limit N] [-starting-after ID] [-all]", "list payments, newest first", paymentsList},
+	{"payments events", "<id>", "show a payment's history", paymentsEvents},
+	{"payments capture", "[-amount N] <id>", "take an authorized payment from the card", paymentsCapture},
+	{"payments void", "<id>", "release an authorized payment without taking it", paymentsVoid},
+	{"payments refund", "[-amount N] <id>", "give a captured payment back to the card", paymentsRefund},
+	{"payments parked", "", "list queued payments the bank gave no outcome for (-storage-dir)", paymentsParked},
+	{"payments resolve", "-status S [-network-transaction-id ID] <id>", "give a parked payment its outcome, or send it again (-storage-dir)", paymentsResolve},
+
+	{"subscriptions resolve", "-charged|-not-charged <id>", "settle a renewal the bank gave no outcome for (-storage-dir)", subscriptionsResolve},
+
+	{"webhooks create", "[-events a,b] <url>", "register a webhook endpoint and show its secret", webhooksCreate},
+	{"webhooks get", "<endpoint-id>", "show a webhook endpoint", webhooksGet},
+	{"webhooks delete", "<endpoint-id>", "stop deliveries to a webhook endpoint", webhooksDelete},
+	{"webhooks deliveries", "[-status S] <endpoint-id>", "list an endpoint's deliveries, newest first", webhooksDeliveries},
+	{"webhooks delivery", "<delivery-id>", "show a delivery and its attempts", webhooksDelivery},
+	{"webhooks replay", "<delivery-id>... | -endpoint ID [-status S]", "send deliveries again, by ID or all of an endpoint's dead ones", webhooksReplay},
+
+	{"blocklist add", "[-reason R] [-token T]", "block the card number read from stdin, or a vault token", blocklistAdd},
+	{"blocklist list", "", "list blocked cards, newest first", blocklistList},
+	{"blocklist remove", "<id>", "take payments from a blocked card again", blocklistRemove},
+
+	{"apikeys generate", "", "print a new API key and its SHA-256", apiKeysGenerate},
+	{"apikeys hash", "", "print the SHA-256 of the API key read from stdin", apiKeysHash},
+	{"apikeys list", "-config FILE", "list merchants and their API key hashes", apiKeysList},
+	{"apikeys add", "-config FILE -merchant ID", "add a new API key for a merchant to the config file", apiKeysAdd},
+	{"apikeys revoke", "-config FILE -merchant ID <hash-prefix>", "remove an API key from the config file", apiKeysRevoke},
+
+	{"reports export", "[-status S] [-format csv|json] [-file F]", "export every payment", reportsExport},
+	{"reports summary", "[-status S]", "count payments and sum amounts by status and currency", reportsSummary},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "gatewayctl:", err)
+		os.Exit(1)
+	}
+}
+
+// errUsage means the command line was wrong; the problem has already been
+// printed
+var errUsage = errors.New("usage error")
+
+// run parses the global flags, picks the command and runs it
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("gatewayctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	baseURL := fs.String("url", envOr("GATEWAY_URL", "http://localhost:8090"), "base URL of the gateway API")
+	apiKey := fs.String("api-key", os.Getenv("GATEWAY_API_KEY"), "API key to call the gateway with")
+	storageDir := fs.String("storage-dir", "", "open the file storage backend in this directory instead of calling the API; the gateway must be stopped")
+	output := fs.String("output", formatTable, "output format: table or json")
+	timeout := fs.Duration("timeout", 30*time.Second, "give up on a command after this long")
+	fs.Usage = func() { usage(fs) }
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if *output != formatTable && *output != formatJSON {
+		fmt.Fprintf(stderr, "-output must be %s or %s\n", formatTable, formatJSON)
+		return errUsage
+	}
+
+	cmd, cmdArgs, ok := findCommand(fs.Args())
+	if !ok {
+		usage(fs)
+		return errUsage
+	}
+
+	e := &env{
+		baseURL:    *baseURL,
+		apiKey:     *apiKey,
+		storageDir: *storageDir,
+		output:     *output,
+		stdin:      stdin,
+		stdout:     stdout,
+		stderr:     stderr,
+	}
+	defer e.close()
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	return cmd.run(ctx, e, cmdArgs)
+}
+
+// findCommand matches the first two arguments to a command
+func findCommand(args []string) (command, []string, bool) {
+	if len(args) < 2 {
+		return command{}, nil, false
+	}
+	name := args[0] + " " + args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, args[2:], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "Usage: gatewayctl [flags] <command> [command flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n", cmd.name, cmd.args)
+		fmt.Fprintf(w, "        %s\n", cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Flags:")
+	fs.PrintDefaults()
+}
+
+// env is what commands run with
+type env struct {
+	baseURL    string
+	apiKey     string
+	storageDir string
+	output     string
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+
+	client  *gatewayclient.Client
+	closers []io.Closer
+}
+
+// gateway returns a client for the API, or for the storage backend with
+// -storage-dir
+func (e *env) gateway() (*gatewayclient.Client, error) {
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	var err error
+	if e.storageDir != "" {
+		e.client, err = e.openStorage()
+	} else {
+		e.client, err = gatewayclient.New(e.baseURL, gatewayclient.WithAPIKey(e.apiKey))
+	}
+	return e.client, err
+}
+
+// close closes what the command opened, in reverse
+func (e *env) close() {
+	for i := len(e.closers) - 1; i >= 0; i-- {
+		e.closers[i].Close()
+	}
+}
+
+// flagSet returns the flag set for a command, printing its usage on errors
+func (e *env) flagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("gatewayctl "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gatewayctl %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses a command's flags and checks it got between min and max
+// arguments; max < 0 allows any number
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	if fs.NArg() < min || max >= 0 && fs.NArg() > max {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// statusFlag checks a -status value against the values the API knows,
+// matching case-insensitively
+func statusFlag(value string, valid ...string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	for _, v := range valid {
+		if strings.EqualFold(value, v) {
+			return v, nil
+		}
+	}
+	sorted := append([]string(nil), valid...)
+	sort.Strings(sorted)
+	return "", fmt.Errorf("-status must be one of %s", strings.Join(sorted, ", "))
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
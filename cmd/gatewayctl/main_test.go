@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatewayctl runs the command line and returns its stdout and stderr
+func gatewayctl(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// startGateway serves the gateway's real router, backed by the bank
+// simulator, and returns it with a client for seeding it. Both the client
+// and gatewayctl call it as the same merchant.
+func startGateway(t *testing.T, cfg *config.Config) (*api.Api, string, *gatewayclient.Client) {
+	t.Helper()
+	bank := httptest.NewServer(banksim.New())
+	t.Cleanup(bank.Close)
+	cfg.Bank.URL = bank.URL
+	cfg.Merchants = []config.Merchant{{ID: "merchant-1", APIKeySHA256: []string{merchant.HashAPIKey(apitest.APIKey)}}}
+	t.Setenv("GATEWAY_API_KEY", apitest.APIKey)
+	// A test that seeded the storage first has already set the key
+	if os.Getenv("GATEWAY_MASTER_KEY_V1") == "" {
+		setMasterKey(t)
+	}
+
+	gateway, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	server := httptest.NewServer(gateway.Router())
+	t.Cleanup(server.Close)
+
+	c, err := gatewayclient.New(server.URL, gatewayclient.WithAPIKey(apitest.APIKey))
+	require.NoError(t, err)
+	return gateway, server.URL, c
+}
+
+// setMasterKey gives the gateway and gatewayctl a new master key
+func setMasterKey(t *testing.T) {
+	t.Helper()
+	key, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	t.Setenv("GATEWAY_MASTER_KEY_V1", base64.StdEncoding.EncodeToString(key))
+}
+
+func createPayment(t *testing.T, c *gatewayclient.Client, cardNumber string, amount int) *gatewayclient.Payment {
+	t.Helper()
+	p, err := c.CreatePayment(context.Background(), &gatewayclient.PaymentRequest{
+		CardNumber:  cardNumber,
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      amount,
+		CVV:         "123",
+	})
+	require.NoError(t, err)
+	return p
+}
+
+func TestRun_Usage(t *testing.T) {
+	_, stderr, err := gatewayctl(t, "", "payments", "settle", "payment-1")
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, stderr, "Usage: gatewayctl")
+
+	_, stderr, err = gatewayctl(t, "", "payments", "get")
+	assert.ErrorIs(t, err, errUsage)
+	assert.Contains(t, stderr, "Usage: gatewayctl payments get <id>")
+
+	_, _, err = gatewayctl(t, "", "-output", "yaml", "payments", "get", "payment-1")
+	assert.ErrorIs(t, err, errUsage)
+}
+
+func TestPayments(t *testing.T) {
+	_, url, c := startGateway(t, config.Default())
+	authorized := createPayment(t, c, "2222405343248877", 100)
+	declined := createPayment(t, c, "2222405343248878", 250)
+
+	stdout, _, err := gatewayctl(t, "", "-url", url, "payments", "get", authorized.ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, authorized.ID)
+	assert.Contains(t, stdout, "Authorized")
+	assert.Contains(t, stdout, "**** 8877")
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "-output", "json", "payments", "list", "-status", "declined")
+	require.NoError(t, err)
+	var list gatewayclient.PaymentList
+	require.NoError(t, json.Unmarshal([]byte(stdout), &list))
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, declined.ID, list.Data[0].ID)
+
+	stdout, stderr, err := gatewayctl(t, "", "-url", url, "payments", "list", "-limit", "1")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, declined.ID)
+	assert.NotContains(t, stdout, authorized.ID)
+	assert.Contains(t, stderr, "-starting-after "+declined.ID)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "payments", "list", "-limit", "1", "-all")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, authorized.ID)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "payments", "events", authorized.ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "payment.requested")
+	assert.Contains(t, stdout, "payment.authorized")
+
+	_, _, err = gatewayctl(t, "", "-url", url, "payments", "get", "missing")
+	assert.ErrorIs(t, err, gatewayclient.ErrNotFound)
+
+	_, _, err = gatewayctl(t, "", "-url", url, "payments", "list", "-status", "Settled")
+	assert.ErrorContains(t, err, "-status must be one of")
+}
+
+func TestPayments_CaptureVoidAndRefund(t *testing.T) {
+	_, url, c := startGateway(t, config.Default())
+	captured := createPayment(t, c, "2222405343248877", 100)
+	voided := createPayment(t, c, "2222405343248877", 250)
+
+	stdout, _, err := gatewayctl(t, "", "-url", url, "payments", "capture", captured.ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Captured")
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "-output", "json", "payments", "refund", "-amount", "40", captured.ID)
+	require.NoError(t, err)
+	var refunded gatewayclient.Payment
+	require.NoError(t, json.Unmarshal([]byte(stdout), &refunded))
+	assert.Equal(t, gatewayclient.StatusRefunded, refunded.Status)
+	assert.Equal(t, 100, refunded.CapturedAmount)
+	assert.Equal(t, 40, refunded.RefundedAmount)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "payments", "void", voided.ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Voided")
+
+	_, _, err = gatewayctl(t, "", "-url", url, "payments", "void", captured.ID)
+	assert.ErrorIs(t, err, gatewayclient.ErrConflict)
+}
+
+func TestReports(t *testing.T) {
+	_, url, c := startGateway(t, config.Default())
+	first := createPayment(t, c, "2222405343248877", 100)
+	createPayment(t, c, "2222405343248877", 200)
+	createPayment(t, c, "2222405343248878", 300)
+
+	file := filepath.Join(t.TempDir(), "payments.csv")
+	_, stderr, err := gatewayctl(t, "", "-url", url, "reports", "export", "-file", file)
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "exported 3 payments")
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, exportColumns, records[0])
+	assert.Equal(t, first.ID, records[3][0], "newest first")
+	assert.Equal(t, "Authorized", records[3][1])
+
+	_, _, err = gatewayctl(t, "", "-url", url, "reports", "export", "-file", file)
+	assert.Error(t, err, "an existing file is not overwritten")
+
+	stdout, _, err := gatewayctl(t, "", "-url", url, "reports", "export", "-format", "json", "-status", "Declined")
+	require.NoError(t, err)
+	var exported []gatewayclient.Payment
+	require.NoError(t, json.Unmarshal([]byte(stdout), &exported))
+	require.Len(t, exported, 1)
+	assert.Equal(t, 300, exported[0].Amount)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "-output", "json", "reports", "summary")
+	require.NoError(t, err)
+	var summary []summaryRow
+	require.NoError(t, json.Unmarshal([]byte(stdout), &summary))
+	assert.Equal(t, []summaryRow{
+		{Status: "Authorized", Currency: "GBP", Count: 2, Amount: 300},
+		{Status: "Declined", Currency: "GBP", Count: 1, Amount: 300},
+	}, summary)
+}
+
+// TestWebhooks replays a delivery through the API, and again straight from
+// the storage directory
+func TestWebhooks(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: dir}
+	// The receiver listens on loopback
+	cfg.Webhooks.AllowPrivateNetworks = true
+	gateway, url, c := startGateway(t, cfg)
+	ctx := context.Background()
+
+	stdout, _, err := gatewayctl(t, "", "-url", url, "-output", "json", "webhooks", "create", receiver.URL)
+	require.NoError(t, err)
+	var endpoint gatewayclient.WebhookEndpoint
+	require.NoError(t, json.Unmarshal([]byte(stdout), &endpoint))
+	assert.NotEmpty(t, endpoint.Secret)
+
+	createPayment(t, c, "2222405343248877", 100)
+	require.Equal(t, 1, gateway.Dispatcher().RunOnce(ctx))
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "-output", "json", "webhooks", "deliveries", endpoint.ID)
+	require.NoError(t, err)
+	var deliveries []gatewayclient.WebhookDelivery
+	require.NoError(t, json.Unmarshal([]byte(stdout), &deliveries))
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, gatewayclient.DeliverySucceeded, deliveries[0].Status)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "webhooks", "replay", deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "queued")
+	stdout, _, err = gatewayctl(t, "", "-url", url, "webhooks", "replay", deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "skipped, already queued")
+
+	require.Equal(t, 1, gateway.Dispatcher().RunOnce(ctx))
+
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "webhooks", "get", endpoint.ID)
+	assert.ErrorContains(t, err, "stop the gateway first")
+	require.NoError(t, gateway.Close())
+
+	// With the gateway stopped, the same commands work on its storage
+	stdout, stderr, err := gatewayctl(t, "", "-storage-dir", dir, "webhooks", "replay", "-endpoint", endpoint.ID, "-status", "succeeded")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, deliveries[0].ID)
+	assert.Contains(t, stderr, "sent once the gateway is started")
+
+	stdout, _, err = gatewayctl(t, "", "-storage-dir", dir, "webhooks", "delivery", deliveries[0].ID)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "pending")
+	assert.Contains(t, stdout, "204")
+
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "payments", "get", "payment-1")
+	assert.ErrorContains(t, err, "only webhooks and parked payments can be managed with -storage-dir")
+
+	_, _, err = gatewayctl(t, "", "-storage-dir", t.TempDir(), "webhooks", "get", endpoint.ID)
+	assert.ErrorContains(t, err, "no webhook journal")
+}
+
+func TestPaymentsResolve(t *testing.T) {
+	dir := t.TempDir()
+	setMasterKey(t)
+	keys, err := masterKeys()
+	require.NoError(t, err)
+
+	// The gateway stopped after parking a payment whose outcome the bank
+	// never gave
+	jobs, err := repository.OpenPaymentJobsRepository(filepath.Join(dir, repository.PaymentJobsJournalFile), envelope.New(keys))
+	require.NoError(t, err)
+	require.NoError(t, jobs.Add(&domain.Payment{
+		ID:         "payment-1",
+		MerchantID: "merchant-1",
+		Card:       domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency:   "GBP",
+		Amount:     100,
+		Status:     domain.StatusPending,
+		Initiator:  domain.InitiatorCustomer,
+	}))
+	require.NoError(t, jobs.Park("payment-1"))
+	require.NoError(t, jobs.Close())
+
+	_, _, err = gatewayctl(t, "", "payments", "parked")
+	assert.ErrorContains(t, err, "-storage-dir")
+
+	stdout, _, err := gatewayctl(t, "", "-storage-dir", dir, "-output", "json", "payments", "parked")
+	require.NoError(t, err)
+	var parked []parkedPayment
+	require.NoError(t, json.Unmarshal([]byte(stdout), &parked))
+	require.Len(t, parked, 1)
+	assert.Equal(t, "payment-1", parked[0].ID)
+	assert.Equal(t, "merchant-1", parked[0].MerchantID)
+	assert.NotContains(t, stdout, "2222405343248877")
+
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "payments", "resolve", "payment-1")
+	assert.ErrorIs(t, err, errUsage, "the outcome must be given")
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "payments", "resolve", "-status", "authorized", "missing")
+	assert.ErrorContains(t, err, "no parked payment missing")
+
+	_, stderr, err := gatewayctl(t, "", "-storage-dir", dir, "payments", "resolve", "-status", "authorized", "-network-transaction-id", "ntid-1", "payment-1")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "recorded as Authorized when the gateway starts")
+
+	// The gateway records the outcome when it starts, without calling the
+	// bank again
+	cfg := config.Default()
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: dir}
+	gateway, _, c := startGateway(t, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gateway.RunPaymentWorkers(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.Eventually(t, func() bool {
+		p, err := c.GetPayment(context.Background(), "payment-1")
+		return err == nil && p.Status == gatewayclient.StatusAuthorized
+	}, 5*time.Second, 10*time.Millisecond)
+	p, err := c.GetPayment(context.Background(), "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "ntid-1", p.NetworkTransactionID)
+}
+
+func TestSubscriptionsResolve(t *testing.T) {
+	dir := t.TempDir()
+	periodStart := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// The gateway stopped after a renewal whose call to the bank timed out
+	subscriptions, err := repository.OpenSubscriptionsRepository(filepath.Join(dir, repository.SubscriptionsJournalFile))
+	require.NoError(t, err)
+	plan := &domain.Plan{ID: "plan-1", MerchantID: "merchant-1", Name: "Plan", Amount: 1000, Currency: "GBP", Interval: domain.IntervalMonth, CreatedAt: periodStart}
+	require.NoError(t, subscriptions.SavePlan(plan))
+	require.NoError(t, subscriptions.SaveSubscription(&domain.Subscription{
+		ID:                 "sub-1",
+		PlanID:             "plan-1",
+		CardToken:          "tok_abc",
+		Status:             domain.SubscriptionReconciling,
+		MerchantID:         "merchant-1",
+		BillingAnchor:      periodStart,
+		CurrentPeriodStart: periodStart,
+		CurrentPeriodEnd:   plan.PeriodEnd(periodStart, 0),
+		NextChargeAt:       plan.PeriodEnd(periodStart, 0),
+		Charges: []domain.SubscriptionCharge{
+			{Amount: 1000, Currency: "GBP", Reason: domain.ChargeInitial, Status: domain.StatusAuthorized, Attempt: 1, CreatedAt: periodStart},
+			{Amount: 1000, Currency: "GBP", Reason: domain.ChargeRenewal, Status: domain.StatusRejected, Attempt: 1, Error: "context deadline exceeded"},
+		},
+		CreatedAt: periodStart,
+	}))
+	require.NoError(t, subscriptions.Close())
+
+	_, _, err = gatewayctl(t, "", "subscriptions", "resolve", "-charged", "sub-1")
+	assert.ErrorContains(t, err, "-storage-dir")
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "subscriptions", "resolve", "sub-1")
+	assert.ErrorIs(t, err, errUsage, "the outcome must be given")
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "subscriptions", "resolve", "-charged", "missing")
+	assert.ErrorContains(t, err, "no subscription missing")
+
+	_, stderr, err := gatewayctl(t, "", "-storage-dir", dir, "subscriptions", "resolve", "-charged", "sub-1")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "subscription sub-1 renewed until")
+
+	_, _, err = gatewayctl(t, "", "-storage-dir", dir, "subscriptions", "resolve", "-charged", "sub-1")
+	assert.ErrorContains(t, err, "has no renewal to reconcile")
+
+	subscriptions, err = repository.OpenSubscriptionsRepository(filepath.Join(dir, repository.SubscriptionsJournalFile))
+	require.NoError(t, err)
+	defer subscriptions.Close()
+	sub, err := subscriptions.FindSubscription("sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, sub.Status)
+	assert.Equal(t, 1, sub.Cycles)
+	assert.Equal(t, plan.PeriodEnd(periodStart, 0), sub.CurrentPeriodStart)
+	assert.Equal(t, domain.StatusAuthorized, sub.Charges[1].Status)
+}
+
+func TestBlocklist(t *testing.T) {
+	_, url, c := startGateway(t, config.Default())
+
+	stdout, _, err := gatewayctl(t, "2222405343248877\n", "-url", url, "-output", "json", "blocklist", "add", "-reason", "chargeback")
+	require.NoError(t, err)
+	var blocked gatewayclient.BlockedCard
+	require.NoError(t, json.Unmarshal([]byte(stdout), &blocked))
+	assert.Equal(t, "8877", blocked.CardNumberLastFour)
+	assert.NotContains(t, stdout, "2222405343248877")
+
+	_, err = c.CreatePayment(context.Background(), &gatewayclient.PaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	assert.ErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+
+	stdout, _, err = gatewayctl(t, "", "-url", url, "blocklist", "list")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, blocked.ID)
+	assert.Contains(t, stdout, "**** 8877")
+	assert.Contains(t, stdout, "chargeback")
+
+	_, stderr, err := gatewayctl(t, "", "-url", url, "blocklist", "remove", blocked.ID)
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "unblocked card "+blocked.ID)
+	createPayment(t, c, "2222405343248877", 100)
+
+	_, _, err = gatewayctl(t, "", "-url", url, "blocklist", "remove", blocked.ID)
+	assert.ErrorIs(t, err, gatewayclient.ErrNotFound)
+
+	_, _, err = gatewayctl(t, "", "-url", url, "blocklist", "add")
+	assert.ErrorContains(t, err, "failed to read card number")
+
+	_, _, err = gatewayctl(t, "", "-url", url, "blocklist", "add", "-token", "tok_unknown")
+	assert.ErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+}
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("# Gateway settings\nserver:\n  addr: \":8090\" # listen address\nmerchants: []\n"), 0o640))
+
+	type newKey struct {
+		Merchant     string `json:"merchant"`
+		APIKey       string `json:"api_key"`
+		APIKeySHA256 string `json:"api_key_sha256"`
+	}
+	add := func() newKey {
+		stdout, stderr, err := gatewayctl(t, "", "-output", "json", "apikeys", "add", "-config", path, "-merchant", "merchant-1")
+		require.NoError(t, err)
+		assert.Contains(t, stderr, "SIGHUP")
+		var key newKey
+		require.NoError(t, json.Unmarshal([]byte(stdout), &key))
+		assert.Equal(t, merchant.HashAPIKey(key.APIKey), key.APIKeySHA256)
+		return key
+	}
+	loadMerchants := func() []config.Merchant {
+		cfg := config.Default()
+		require.NoError(t, config.LoadFile(cfg, path))
+		require.NoError(t, cfg.Validate())
+		return cfg.Merchants
+	}
+
+	first := add()
+	merchants := loadMerchants()
+	require.Len(t, merchants, 1)
+	assert.Equal(t, "merchant-1", merchants[0].ID)
+	assert.Equal(t, []string{first.APIKeySHA256}, merchants[0].APIKeySHA256)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# listen address", "comments are kept")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	_, _, err = gatewayctl(t, "", "apikeys", "revoke", "-config", path, "-merchant", "merchant-1", first.APIKeySHA256[:12])
+	assert.ErrorContains(t, err, "only key")
+
+	second := add()
+	assert.Equal(t, []string{first.APIKeySHA256, second.APIKeySHA256}, loadMerchants()[0].APIKeySHA256)
+
+	stdout, _, err := gatewayctl(t, "", "apikeys", "list", "-config", path)
+	require.NoError(t, err)
+	assert.Contains(t, stdout, second.APIKeySHA256)
+
+	_, _, err = gatewayctl(t, "", "apikeys", "revoke", "-config", path, "-merchant", "merchant-1", first.APIKeySHA256[:4])
+	assert.ErrorContains(t, err, "at least 8 characters")
+
+	_, stderr, err := gatewayctl(t, "", "apikeys", "revoke", "-config", path, "-merchant", "merchant-1", first.APIKeySHA256[:12])
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "revoked merchant-1's key "+first.APIKeySHA256)
+	assert.Equal(t, []string{second.APIKeySHA256}, loadMerchants()[0].APIKeySHA256)
+
+	stdout, _, err = gatewayctl(t, second.APIKey+"\n", "apikeys", "hash")
+	require.NoError(t, err)
+	assert.Equal(t, second.APIKeySHA256+"\n", stdout)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+)
+
+var paymentStatuses = []string{
+	gatewayclient.StatusAuthorized,
+	gatewayclient.StatusDeclined,
+	gatewayclient.StatusRejected,
+	gatewayclient.StatusPending,
+	gatewayclient.StatusCaptured,
+	gatewayclient.StatusVoided,
+	gatewayclient.StatusRefunded,
+}
+
+func paymentsGet(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("payments get", "<id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	p, err := c.GetPayment(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return renderPayment(e, p)
+}
+
+func paymentsCapture(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("payments capture", "[-amount N] <id>")
+	amount := fs.Int("amount", 0, "amount to capture in minor units; the whole authorized amount when 0")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	p, err := c.CapturePayment(ctx, args[0], *amount)
+	if err != nil {
+		return err
+	}
+	return renderPayment(e, p)
+}
+
+func paymentsVoid(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("payments void", "<id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	p, err := c.VoidPayment(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return renderPayment(e, p)
+}
+
+func paymentsRefund(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("payments refund", "[-amount N] <id>")
+	amount := fs.Int("amount", 0, "amount to refund in minor units; all that is left when 0")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	p, err := c.RefundPayment(ctx, args[0], *amount)
+	if err != nil {
+		return err
+	}
+	return renderPayment(e, p)
+}
+
+func renderPayment(e *env, p *gatewayclient.Payment) error {
+	return e.render(p, details(
+		"id", p.ID,
+		"status", p.Status,
+		"amount", itoa(p.Amount),
+		"captured_amount", itoa(p.CapturedAmount),
+		"refunded_amount", itoa(p.RefundedAmount),
+		"currency", p.Currency,
+		"card", "**** "+p.CardNumberLastFour,
+		"expiry", fmt.Sprintf("%02d/%d", p.ExpiryMonth, p.ExpiryYear),
+		"initiator", p.Initiator,
+		"decline_reason", p.DeclineReason,
+		"network_transaction_id", p.NetworkTransactionID,
+		"request_id", p.RequestID,
+		"correlation_id", p.CorrelationID,
+	))
+}
+
+func paymentsList(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("payments list", "[-status S] [-limit N] [-starting-after ID] [-all]")
+	status := fs.String("status", "", "only list payments in this status")
+	limit := fs.Int("limit", 20, "payments per page, 1-100")
+	startingAfter := fs.String("starting-after", "", "list payments older than this one")
+	all := fs.Bool("all", false, "list every page, not just the first")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := statusFlag(*status, paymentStatuses...)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	params := gatewayclient.ListPaymentsParams{Status: s, StartingAfter: *startingAfter, Limit: *limit}
+
+	var list gatewayclient.PaymentList
+	if *all {
+		it := c.Payments(params)
+		for it.Next(ctx) {
+			list.Data = append(list.Data, it.Payment())
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	} else {
+		page, err := c.ListPayments(ctx, params)
+		if err != nil {
+			return err
+		}
+		list = *page
+	}
+	if list.Data == nil {
+		list.Data = []gatewayclient.Payment{}
+	}
+
+	t := table{header: []string{"ID", "STATUS", "AMOUNT", "CURRENCY", "CARD", "DECLINE REASON", "CORRELATION ID"}}
+	for _, p := range list.Data {
+		t.add(p.ID, p.Status, itoa(p.Amount), p.Currency, p.CardNumberLastFour, orDash(p.DeclineReason), orDash(p.CorrelationID))
+	}
+	if err := e.render(list, t); err != nil {
+		return err
+	}
+
+	if list.HasMore && e.output == formatTable {
+		fmt.Fprintf(e.stderr, "more payments: -starting-after %s\n", list.NextStartingAfter)
+	}
+	return nil
+}
+
+func paymentsEvents(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("payments events", "<id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	events, err := c.GetPaymentEvents(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"SEQ", "TYPE", "OCCURRED AT", "ACTOR", "REQUEST ID", "STATUS"}}
+	for _, ev := range events {
+		t.add(itoa(ev.Sequence), ev.Type, formatTime(ev.OccurredAt), ev.Actor, orDash(ev.RequestID), orDash(ev.Data.Status))
+	}
+	return e.render(events, t)
+}
+
+// parkedPayment is a queued payment the bank never gave an outcome for
+type parkedPayment struct {
+	ID            string `json:"id"`
+	MerchantID    string `json:"merchant_id"`
+	Amount        int    `json:"amount"`
+	Currency      string `json:"currency"`
+	RequestID     string `json:"request_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+func paymentsParked(ctx context.Context, e *env, args []string) error {
+	if _, err := parse(e.flagSet("payments parked", ""), args, 0, 0); err != nil {
+		return err
+	}
+	jobs, err := e.openPaymentJobs()
+	if err != nil {
+		return err
+	}
+
+	parked := []parkedPayment{}
+	t := table{header: []string{"ID", "MERCHANT", "AMOUNT", "CURRENCY", "REQUEST ID", "CORRELATION ID"}}
+	for _, p := range jobs.Parked() {
+		parked = append(parked, parkedPayment{
+			ID:            p.ID,
+			MerchantID:    p.MerchantID,
+			Amount:        p.Amount,
+			Currency:      p.Currency,
+			RequestID:     p.RequestID,
+			CorrelationID: p.CorrelationID,
+		})
+		t.add(p.ID, orDash(p.MerchantID), itoa(p.Amount), p.Currency, orDash(p.RequestID), orDash(p.CorrelationID))
+	}
+	return e.render(parked, t)
+}
+
+func paymentsResolve(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("payments resolve", "-status S [-network-transaction-id ID] <id>")
+	status := fs.String("status", "", "the outcome found with the bank: Authorized, Declined or Rejected, or Pending to send it again")
+	networkTransactionID := fs.String("network-transaction-id", "", "the bank's transaction ID of an authorized payment")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	s, err := statusFlag(*status, paymentStatuses...)
+	if err != nil {
+		return err
+	}
+	if s == "" {
+		fmt.Fprintln(e.stderr, "-status is required")
+		return errUsage
+	}
+	jobs, err := e.openPaymentJobs()
+	if err != nil {
+		return err
+	}
+
+	if err := jobs.Resolve(args[0], domain.PaymentStatus(s), *networkTransactionID); err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			return fmt.Errorf("no parked payment %s", args[0])
+		}
+		return err
+	}
+	if s == gatewayclient.StatusPending {
+		fmt.Fprintf(e.stderr, "payment %s will be sent to the bank again when the gateway starts\n", args[0])
+	} else {
+		fmt.Fprintf(e.stderr, "payment %s will be recorded as %s when the gateway starts\n", args[0], s)
+	}
+	return nil
+}
+
+// openPaymentJobs opens the payment queue's journal in the storage
+// directory. Parked payments are only resolved there, while the gateway is
+// stopped, so the gateway picks the outcome up when it starts.
+func (e *env) openPaymentJobs() (*repository.PaymentJobsRepository, error) {
+	if e.storageDir == "" {
+		return nil, errors.New("parked payments are only kept in the file storage backend; stop the gateway and pass -storage-dir")
+	}
+	path := filepath.Join(e.storageDir, repository.PaymentJobsJournalFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no payment job journal in %s: %w", e.storageDir, err)
+	}
+
+	// Held until gatewayctl exits, so the gateway cannot start meanwhile
+	lock, err := journal.LockDir(e.storageDir)
+	if errors.Is(err, journal.ErrLocked) {
+		return nil, fmt.Errorf("%w; stop the gateway first", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, lock)
+
+	keys, err := masterKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs, err := repository.OpenPaymentJobsRepository(path, envelope.New(keys))
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, jobs)
+	return jobs, nil
+}
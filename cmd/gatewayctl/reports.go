@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+)
+
+// pageSize is the page size reports fetch payments in, the most the API
+// allows
+const pageSize = 100
+
+var exportColumns = []string{
+	"id", "status", "amount", "currency", "card_number_last_four", "expiry_month", "expiry_year",
+	"initiator", "decline_reason", "network_transaction_id", "request_id", "correlation_id",
+}
+
+// reportsExport writes every payment as CSV or a JSON array, a page at a
+// time, so exports of any size use little memory
+func reportsExport(ctx context.Context, e *env, args []string) (err error) {
+	fs := e.flagSet("reports export", "[-status S] [-format csv|json] [-file F]")
+	status := fs.String("status", "", "only export payments in this status")
+	format := fs.String("format", "csv", "csv or json")
+	file := fs.String("file", "", "write to this file instead of stdout")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := statusFlag(*status, paymentStatuses...)
+	if err != nil {
+		return err
+	}
+	if *format != "csv" && *format != formatJSON {
+		return errors.New("-format must be csv or json")
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	out := e.stdout
+	if *file != "" {
+		f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		out = f
+	}
+
+	var w paymentWriter = newCSVPaymentWriter(out)
+	if *format == formatJSON {
+		w = &jsonPaymentWriter{w: out}
+	}
+
+	count := 0
+	it := c.Payments(gatewayclient.ListPaymentsParams{Status: s, Limit: pageSize})
+	for it.Next(ctx) {
+		if err := w.write(it.Payment()); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+	if err := w.close(); err != nil {
+		return err
+	}
+
+	if *file != "" {
+		fmt.Fprintf(e.stderr, "exported %d payments to %s\n", count, *file)
+	}
+	return nil
+}
+
+type paymentWriter interface {
+	write(p gatewayclient.Payment) error
+	close() error
+}
+
+type csvPaymentWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVPaymentWriter(w io.Writer) *csvPaymentWriter {
+	return &csvPaymentWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvPaymentWriter) write(p gatewayclient.Payment) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		p.ID, p.Status, itoa(p.Amount), p.Currency, p.CardNumberLastFour, itoa(p.ExpiryMonth), itoa(p.ExpiryYear),
+		p.Initiator, p.DeclineReason, p.NetworkTransactionID, p.RequestID, p.CorrelationID,
+	})
+}
+
+func (c *csvPaymentWriter) close() error {
+	if !c.header {
+		// An export with no payments still says what its columns are
+		if err := c.w.Write(exportColumns); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonPaymentWriter streams a JSON array, one payment per line
+type jsonPaymentWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonPaymentWriter) write(p gatewayclient.Payment) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.count == 0 {
+		sep = "[\n"
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonPaymentWriter) close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// summaryRow totals the payments in one status and currency
+type summaryRow struct {
+	Status   string `json:"status"`
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+	// Amount is in minor currency units
+	Amount int `json:"amount"`
+}
+
+func reportsSummary(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("reports summary", "[-status S]")
+	status := fs.String("status", "", "only count payments in this status")
+	if _, err := parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	s, err := statusFlag(*status, paymentStatuses...)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	totals := make(map[[2]string]*summaryRow)
+	it := c.Payments(gatewayclient.ListPaymentsParams{Status: s, Limit: pageSize})
+	for it.Next(ctx) {
+		p := it.Payment()
+		key := [2]string{p.Status, p.Currency}
+		row, ok := totals[key]
+		if !ok {
+			row = &summaryRow{Status: p.Status, Currency: p.Currency}
+			totals[key] = row
+		}
+		row.Count++
+		row.Amount += p.Amount
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	rows := make([]summaryRow, 0, len(totals))
+	for _, row := range totals {
+		rows = append(rows, *row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Status != rows[j].Status {
+			return rows[i].Status < rows[j].Status
+		}
+		return rows[i].Currency < rows[j].Currency
+	})
+
+	t := table{header: []string{"STATUS", "CURRENCY", "COUNT", "AMOUNT"}}
+	for _, row := range rows {
+		t.add(row.Status, row.Currency, itoa(row.Count), itoa(row.Amount))
+	}
+	return e.render(rows, t)
+}
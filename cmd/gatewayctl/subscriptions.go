@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/subscription"
+)
+
+func subscriptionsResolve(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("subscriptions resolve", "-charged|-not-charged <id>")
+	charged := fs.Bool("charged", false, "the bank charged the renewal, so the next period starts")
+	notCharged := fs.Bool("not-charged", false, "the bank has no record of the renewal, so it is charged again")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *charged == *notCharged {
+		fmt.Fprintln(e.stderr, "exactly one of -charged and -not-charged is required")
+		return errUsage
+	}
+	subscriptions, err := e.openSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	// Renewals are never charged here, so no payment processor is needed
+	service := subscription.NewService(nil, subscriptions)
+	sub, err := service.ResolveRenewal(args[0], *charged)
+	switch {
+	case errors.Is(err, domain.ErrSubscriptionNotFound):
+		return fmt.Errorf("no subscription %s", args[0])
+	case errors.Is(err, domain.ErrSubscriptionInvalidState):
+		return fmt.Errorf("subscription %s has no renewal to reconcile", args[0])
+	case err != nil:
+		return err
+	}
+
+	if *charged {
+		fmt.Fprintf(e.stderr, "subscription %s renewed until %s\n", sub.ID, formatTime(sub.CurrentPeriodEnd))
+	} else {
+		fmt.Fprintf(e.stderr, "subscription %s will be charged again when the gateway starts\n", sub.ID)
+	}
+	return nil
+}
+
+// openSubscriptions opens the subscriptions journal in the storage
+// directory, while the gateway is stopped, like openPaymentJobs
+func (e *env) openSubscriptions() (*repository.SubscriptionsRepository, error) {
+	if e.storageDir == "" {
+		return nil, errors.New("subscriptions are only resolved in the file storage backend; stop the gateway and pass -storage-dir")
+	}
+	path := filepath.Join(e.storageDir, repository.SubscriptionsJournalFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no subscriptions journal in %s: %w", e.storageDir, err)
+	}
+
+	// Held until gatewayctl exits, so the gateway cannot start meanwhile
+	lock, err := journal.LockDir(e.storageDir)
+	if errors.Is(err, journal.ErrLocked) {
+		return nil, fmt.Errorf("%w; stop the gateway first", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, lock)
+
+	subscriptions, err := repository.OpenSubscriptionsRepository(path)
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, subscriptions)
+	return subscriptions, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/handlers"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/webhook"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+	"github.com/go-chi/chi/v5"
+)
+
+var deliveryStatuses = []string{
+	gatewayclient.DeliveryPending,
+	gatewayclient.DeliverySucceeded,
+	gatewayclient.DeliveryDead,
+}
+
+func webhooksCreate(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("webhooks create", "[-events a,b] <url>")
+	events := fs.String("events", "", "comma-separated event types to receive (default all)")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	var eventTypes []string
+	if *events != "" {
+		eventTypes = strings.Split(*events, ",")
+	}
+	endpoint, err := c.CreateWebhookEndpoint(ctx, args[0], eventTypes)
+	if err != nil {
+		return err
+	}
+
+	if e.output == formatTable {
+		fmt.Fprintln(e.stderr, "the secret is only shown now; keep it to verify deliveries")
+	}
+	return e.render(endpoint, endpointDetails(endpoint))
+}
+
+func webhooksGet(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("webhooks get", "<endpoint-id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	endpoint, err := c.GetWebhookEndpoint(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return e.render(endpoint, endpointDetails(endpoint))
+}
+
+func webhooksDelete(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("webhooks delete", "<endpoint-id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	if err := c.DeleteWebhookEndpoint(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "deleted webhook endpoint %s\n", args[0])
+	return nil
+}
+
+func webhooksDeliveries(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("webhooks deliveries", "[-status S] <endpoint-id>")
+	status := fs.String("status", "", "only list deliveries in this status: pending, succeeded or dead")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	s, err := statusFlag(*status, deliveryStatuses...)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := c.ListWebhookDeliveries(ctx, args[0], s)
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"ID", "EVENT ID", "STATUS", "FAILURES", "LAST ATTEMPT", "CREATED AT"}}
+	for _, d := range deliveries {
+		t.add(d.ID, d.EventID, d.Status, itoa(d.Failures), orDash(lastAttempt(d)), formatTime(d.CreatedAt))
+	}
+	return e.render(deliveries, t)
+}
+
+func webhooksDelivery(ctx context.Context, e *env, args []string) error {
+	args, err := parse(e.flagSet("webhooks delivery", "<delivery-id>"), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	d, err := c.GetWebhookDelivery(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	t := table{header: []string{"AT", "STATUS CODE", "ERROR", "DURATION"}}
+	for _, a := range d.Attempts {
+		code := ""
+		if a.StatusCode != 0 {
+			code = itoa(a.StatusCode)
+		}
+		t.add(formatTime(a.At), orDash(code), orDash(a.Error), fmt.Sprintf("%dms", a.DurationMs))
+	}
+	if e.output == formatTable {
+		fmt.Fprintf(e.stdout, "delivery %s of event %s to endpoint %s: %s after %d failures\n\n",
+			d.ID, d.EventID, d.EndpointID, d.Status, d.Failures)
+	}
+	return e.render(d, t)
+}
+
+// webhooksReplay sends the given deliveries again, or every delivery of an
+// endpoint in a status, dead ones by default. Deliveries still queued are
+// skipped.
+func webhooksReplay(ctx context.Context, e *env, args []string) error {
+	fs := e.flagSet("webhooks replay", "<delivery-id>... | -endpoint ID [-status S]")
+	endpointID := fs.String("endpoint", "", "replay this endpoint's deliveries instead of the ones given")
+	status := fs.String("status", gatewayclient.DeliveryDead, "with -endpoint, replay deliveries in this status: succeeded or dead")
+	args, err := parse(fs, args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if (*endpointID == "") == (len(args) == 0) {
+		fs.Usage()
+		return errUsage
+	}
+	c, err := e.gateway()
+	if err != nil {
+		return err
+	}
+
+	ids := args
+	if *endpointID != "" {
+		s, err := statusFlag(*status, gatewayclient.DeliverySucceeded, gatewayclient.DeliveryDead)
+		if err != nil {
+			return err
+		}
+		deliveries, err := c.ListWebhookDeliveries(ctx, *endpointID, s)
+		if err != nil {
+			return err
+		}
+		for _, d := range deliveries {
+			ids = append(ids, d.ID)
+		}
+	}
+
+	replayed := []gatewayclient.WebhookDelivery{}
+	t := table{header: []string{"ID", "RESULT"}}
+	var failed error
+	for _, id := range ids {
+		d, err := c.RedeliverWebhook(ctx, id)
+		switch {
+		case err == nil:
+			replayed = append(replayed, *d)
+			t.add(id, "queued")
+		case errors.Is(err, gatewayclient.ErrConflict):
+			t.add(id, "skipped, already queued")
+		default:
+			t.add(id, err.Error())
+			failed = errors.Join(failed, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+
+	if err := e.render(replayed, t); err != nil {
+		return err
+	}
+	if e.storageDir != "" && len(replayed) > 0 {
+		fmt.Fprintln(e.stderr, "deliveries are sent once the gateway is started")
+	}
+	return failed
+}
+
+func endpointDetails(endpoint *gatewayclient.WebhookEndpoint) table {
+	events := strings.Join(endpoint.EventTypes, ",")
+	if events == "" {
+		events = "all"
+	}
+	return details(
+		"id", endpoint.ID,
+		"url", endpoint.URL,
+		"event_types", events,
+		"secret", endpoint.Secret,
+		"created_at", formatTime(endpoint.CreatedAt),
+	)
+}
+
+func lastAttempt(d gatewayclient.WebhookDelivery) string {
+	if len(d.Attempts) == 0 {
+		return ""
+	}
+	a := d.Attempts[len(d.Attempts)-1]
+	if a.Error != "" {
+		return a.Error
+	}
+	return fmt.Sprintf("status %d", a.StatusCode)
+}
+
+// openStorage opens the webhooks journal in the storage directory and
+// serves the gateway's own webhook handlers over it in process, so every
+// command goes through the same client and validation either way. Only the
+// webhook commands are served this way; the payments journal is only read
+// through the API, and parked payments have their own commands.
+//
+// Endpoint secrets are sealed with the gateway's master key, which is read
+// the same way the gateway reads it from the environment.
+func (e *env) openStorage() (*gatewayclient.Client, error) {
+	path := filepath.Join(e.storageDir, repository.WebhooksJournalFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no webhook journal in %s: %w", e.storageDir, err)
+	}
+
+	// Held until gatewayctl exits, so the gateway cannot start meanwhile
+	lock, err := journal.LockDir(e.storageDir)
+	if errors.Is(err, journal.ErrLocked) {
+		return nil, fmt.Errorf("%w; stop the gateway first", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, lock)
+
+	keys, err := masterKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := repository.OpenWebhooksRepository(path, envelope.New(keys))
+	if err != nil {
+		return nil, err
+	}
+	e.closers = append(e.closers, repo)
+
+	// The operator manages every merchant's endpoints
+	h := handlers.NewWebhooksHandler(webhook.NewService(repo, webhook.Unscoped()))
+	r := chi.NewRouter()
+	r.Post("/api/webhook-endpoints", h.PostEndpointHandler())
+	r.Get("/api/webhook-endpoints/{id}", h.GetEndpointHandler())
+	r.Delete("/api/webhook-endpoints/{id}", h.DeleteEndpointHandler())
+	r.Get("/api/webhook-endpoints/{id}/deliveries", h.ListDeliveriesHandler())
+	r.Get("/api/webhook-deliveries/{id}", h.GetDeliveryHandler())
+	r.Post("/api/webhook-deliveries/{id}/redeliver", h.RedeliverHandler())
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error: "only webhooks and parked payments can be managed with -storage-dir; use -url for the rest",
+		})
+	})
+
+	return gatewayclient.New("http://storage",
+		gatewayclient.WithHTTPClient(&http.Client{Transport: handlerTransport{r}}),
+		gatewayclient.WithRetry(1, 0),
+	)
+}
+
+// masterKeys reads the gateway's master keys from GATEWAY_MASTER_KEY_FILE or
+// GATEWAY_MASTER_KEY_V1, GATEWAY_MASTER_KEY_V2 and so on
+func masterKeys() (envelope.KeyProvider, error) {
+	if path := os.Getenv("GATEWAY_MASTER_KEY_FILE"); path != "" {
+		return envelope.NewFileKeyProvider(path)
+	}
+
+	keys, err := envelope.NewEnvKeyProvider("GATEWAY_MASTER_KEY")
+	if err != nil {
+		return nil, fmt.Errorf("%w: -storage-dir needs the gateway's master key in GATEWAY_MASTER_KEY_FILE or GATEWAY_MASTER_KEY_V1", err)
+	}
+	return keys, nil
+}
+
+// handlerTransport answers requests with a handler instead of the network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
@@ -18,9 +18,42 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/payments": {
+        "/api/blocked-cards": {
+            "get": {
+                "description": "List the cards the merchant has blocked, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocked-cards"
+                ],
+                "summary": "List blocked cards",
+                "responses": {
+                    "200": {
+                        "description": "Blocked cards",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.BlockedCardResponse"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
             "post": {
-                "description": "Process a payment through the payment gateway and return the result",
+                "description": "Refuse further payments from a card, given by its number or a vault token. Payments from a blocked card are Rejected with a 400. Blocking a card that is already blocked returns the existing entry.",
                 "consumes": [
                     "application/json"
                 ],
@@ -28,35 +61,41 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "blocked-cards"
                 ],
-                "summary": "Process a new payment",
+                "summary": "Block a card",
                 "parameters": [
                     {
-                        "description": "Payment details",
-                        "name": "payment",
+                        "description": "Card number or source.token, and why it is blocked",
+                        "name": "card",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.PostPaymentRequest"
+                            "$ref": "#/definitions/models.PostBlockedCardRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Payment processed successfully (Authorized or Declined)",
+                    "201": {
+                        "description": "Card blocked",
                         "schema": {
-                            "$ref": "#/definitions/models.PostPaymentResponse"
+                            "$ref": "#/definitions/models.BlockedCardResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid request or validation error (Rejected)",
+                        "description": "Invalid request, validation error or unknown token",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
-                    "502": {
-                        "description": "Bank service unavailable or error",
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -64,23 +103,136 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/payments/{id}": {
-            "get": {
-                "description": "Get details of a previously processed payment",
+        "/api/blocked-cards/{id}": {
+            "delete": {
+                "description": "Take payments from the card again. The blocklist's copy of the card number is crypto-shredded.",
+                "tags": [
+                    "blocked-cards"
+                ],
+                "summary": "Unblock a card",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Blocked card ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Card unblocked"
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Blocked card not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payment-batches": {
+            "post": {
+                "description": "Upload many payment requests at once, as a JSON array, NDJSON (one request per line) or CSV with a header row. CSV columns are the payment request fields, with nested ones flattened to source_token, stored_credential_usage, stored_credential_type and previous_network_transaction_id. Each item is validated like a single payment; invalid items are recorded as failed and the rest are sent to the bank in the background.",
                 "consumes": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/csv"
+                ],
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "payment-batches"
+                ],
+                "summary": "Submit a batch of payments",
+                "parameters": [
+                    {
+                        "description": "Payment requests",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.PostPaymentRequest"
+                            }
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this batch; retries with the same key return the first response instead of submitting it again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Batch accepted",
+                        "schema": {
+                            "$ref": "#/definitions/models.PaymentBatchResponse"
+                        },
+                        "headers": {
+                            "Location": {
+                                "type": "string",
+                                "description": "URL of the batch"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Empty or unreadable upload",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Upload too large or too many payments",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Content-Type",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payment-batches/{id}": {
+            "get": {
+                "description": "Get a batch's status and how many of its payments have been authorized, declined, failed or canceled so far",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "payments"
+                    "payment-batches"
                 ],
-                "summary": "Retrieve a payment by ID",
+                "summary": "Retrieve a payment batch",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Payment ID",
+                        "description": "Batch ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -88,13 +240,189 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Payment found",
+                        "description": "Batch found",
                         "schema": {
-                            "$ref": "#/definitions/models.GetPaymentResponse"
+                            "$ref": "#/definitions/models.PaymentBatchResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
                         }
                     },
                     "404": {
-                        "description": "Payment not found",
+                        "description": "Batch not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payment-batches/{id}/cancel": {
+            "post": {
+                "description": "Stop sending the batch's payments to the bank. Payments already sent keep their outcome; the rest are recorded as canceled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payment-batches"
+                ],
+                "summary": "Cancel a payment batch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Batch canceled",
+                        "schema": {
+                            "$ref": "#/definitions/models.PaymentBatchResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Batch not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Batch has already finished",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payment-batches/{id}/results": {
+            "get": {
+                "description": "One result per uploaded item, in upload order, as NDJSON or CSV. Items not processed yet are listed as pending.",
+                "produces": [
+                    "application/x-ndjson",
+                    "text/csv"
+                ],
+                "tags": [
+                    "payment-batches"
+                ],
+                "summary": "Download a payment batch's results",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "ndjson",
+                            "csv"
+                        ],
+                        "type": "string",
+                        "description": "Result format, defaults to ndjson or to the Accept header",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-item results",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.PaymentBatchItemResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown format",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Batch not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments": {
+            "get": {
+                "description": "List stored payments, newest first, one page at a time. Pass next_starting_after from a page as starting_after to get the next one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "List payments",
+                "parameters": [
+                    {
+                        "enum": [
+                            "Authorized",
+                            "Declined",
+                            "Rejected",
+                            "Pending",
+                            "Captured",
+                            "Voided",
+                            "Refunded"
+                        ],
+                        "type": "string",
+                        "description": "Only list payments in this status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID of the last payment on the previous page",
+                        "name": "starting_after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Payments per page, 1-100",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Page of payments",
+                        "schema": {
+                            "$ref": "#/definitions/models.PaymentListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid status, limit or starting_after",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -104,89 +432,2028 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
-                    }
+                    }
+                }
+            },
+            "post": {
+                "description": "Process a payment through the payment gateway and return the result. With Prefer: respond-async the payment is queued and returned as Pending straight away; poll its Location or subscribe to webhooks for the outcome.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Process a new payment",
+                "parameters": [
+                    {
+                        "description": "Payment details, either raw card details or source.token",
+                        "name": "payment",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PostPaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ID for this request; generated if missing or invalid",
+                        "name": "X-Request-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Merchant's own ID, stored on the payment and sent to the bank",
+                        "name": "X-Correlation-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "respond-async to queue the payment and return without waiting for the bank",
+                        "name": "Prefer",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this payment; retries with the same key return the first response instead of paying again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment processed successfully (Authorized or Declined)",
+                        "schema": {
+                            "$ref": "#/definitions/models.PostPaymentResponse"
+                        }
+                    },
+                    "202": {
+                        "description": "Payment queued (Pending)",
+                        "schema": {
+                            "$ref": "#/definitions/models.PostPaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request, unknown field, validation error or unknown token (Rejected)",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "A request with the same Idempotency-Key is still in progress, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Idempotency-Key was already used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit exceeded or too many payments in progress, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bank service unavailable or error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Bank is too slow to take the payment right now, or the asynchronous queue is full, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments/{id}": {
+            "get": {
+                "description": "Get details of a previously processed payment",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Retrieve a payment by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment found",
+                        "schema": {
+                            "$ref": "#/definitions/models.GetPaymentResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments/{id}/capture": {
+            "post": {
+                "description": "Capture all or part of an Authorized payment with the bank. Without an amount the whole authorized amount is captured.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Capture an authorized payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Amount to capture, defaults to the authorized amount",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.SettlePaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment after the bank agreed",
+                        "schema": {
+                            "$ref": "#/definitions/models.GetPaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid amount, or more than can be captured",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The payment is not Authorized, or another settlement of it is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit exceeded or too many payments in progress, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "The configured bank does not support settlement",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bank refused or failed the request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Bank is too slow to take the request right now, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments/{id}/events": {
+            "get": {
+                "description": "List every change made to a payment, oldest first, with who made it, when, and from which request. Events are append-only and the payment's current state is rebuilt from them.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Retrieve a payment's history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment history",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.PaymentEventResponse"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments/{id}/refund": {
+            "post": {
+                "description": "Refund all or part of a Captured payment with the bank. Without an amount whatever is left to refund is refunded; partial refunds can be repeated until the captured amount is used up.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Refund a captured payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Amount to refund, defaults to what is left to refund",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.SettlePaymentRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment after the bank agreed",
+                        "schema": {
+                            "$ref": "#/definitions/models.GetPaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid amount, or more than is left to refund",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The payment is not Captured or is already fully refunded, or another settlement of it is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit exceeded or too many payments in progress, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "The configured bank does not support settlement",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bank refused or failed the request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Bank is too slow to take the request right now, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/payments/{id}/void": {
+            "post": {
+                "description": "Release an Authorized payment that has not been captured, so the cardholder is never charged.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Void an authorized payment",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Payment ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Payment after the bank agreed",
+                        "schema": {
+                            "$ref": "#/definitions/models.GetPaymentResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Payment not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The payment is not Authorized, or another settlement of it is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Rate limit exceeded or too many payments in progress, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "The configured bank does not support settlement",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Bank refused or failed the request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Bank is too slow to take the request right now, see Retry-After",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/plans": {
+            "post": {
+                "description": "Create a plan that charges a fixed amount every interval",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Create a billing plan",
+                "parameters": [
+                    {
+                        "description": "Plan details",
+                        "name": "plan",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PostPlanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Plan created",
+                        "schema": {
+                            "$ref": "#/definitions/models.PlanResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request or validation error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/plans/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Retrieve a plan by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Plan ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Plan found",
+                        "schema": {
+                            "$ref": "#/definitions/models.PlanResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Plan not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions": {
+            "post": {
+                "description": "Charge the first period while the customer is present and start the subscription. Later periods are charged by the scheduler as merchant-initiated recurring payments.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Subscribe a stored card to a plan",
+                "parameters": [
+                    {
+                        "description": "Plan and card token",
+                        "name": "subscription",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PostSubscriptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Subscription started",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request, validation error or unknown token",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "402": {
+                        "description": "First payment was not authorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Plan not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions/{id}": {
+            "get": {
+                "description": "Get a subscription with its billing period and every charge attempt",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Retrieve a subscription by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscription found",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions/{id}/cancel": {
+            "post": {
+                "description": "Cancel now, or at the end of the paid period when at_period_end is set",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Cancel a subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Cancellation options",
+                        "name": "cancel",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.CancelSubscriptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscription canceled",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Subscription is already canceled",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions/{id}/change-plan": {
+            "post": {
+                "description": "Switch plans immediately. The unused part of the current period is prorated: an upgrade is charged now and a downgrade is credited against the next renewals.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Move a subscription to another plan",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New plan",
+                        "name": "plan",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ChangePlanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Plan changed",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request or plan in a different currency",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "402": {
+                        "description": "Proration payment was not authorized",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription or plan not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Subscription is not active",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions/{id}/pause": {
+            "post": {
+                "description": "Stop billing until the subscription is resumed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Pause a subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscription paused",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Subscription cannot be paused",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/subscriptions/{id}/resume": {
+            "post": {
+                "description": "Restart billing. A renewal that fell due while paused is charged on the next scheduler run.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "subscriptions"
+                ],
+                "summary": "Resume a paused subscription",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscription ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscription resumed",
+                        "schema": {
+                            "$ref": "#/definitions/models.SubscriptionResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Subscription not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Subscription is not paused",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tokens": {
+            "post": {
+                "description": "Encrypt the card number and expiry in the vault and return an opaque token that can be used as source.token when making payments. The CVV is never stored.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Store a card in the vault",
+                "parameters": [
+                    {
+                        "description": "Card details",
+                        "name": "card",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PostTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Card stored",
+                        "schema": {
+                            "$ref": "#/definitions/models.PostTokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request or validation error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tokens/{token}": {
+            "delete": {
+                "description": "Crypto-shred the card behind a token. The encryption key is destroyed so the card number can never be recovered and the token can no longer be used.",
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Delete a stored card",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Card token",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Card shredded"
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Token not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhook-deliveries/{id}": {
+            "get": {
+                "description": "Get a delivery with every attempt made to send it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Retrieve a webhook delivery",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Delivery ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Delivery found",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookDeliveryResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Delivery not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhook-deliveries/{id}/redeliver": {
+            "post": {
+                "description": "Queue a dead or already delivered event to be sent again straight away with a fresh set of retries",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Redeliver a webhook",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Delivery ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Delivery queued",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookDeliveryResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Delivery or endpoint not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Delivery is already queued",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhook-endpoints": {
+            "post": {
+                "description": "Register a URL to receive payment events. Every delivery is signed with HMAC-SHA256 in the Webhook-Signature header as \"t=\u003cunix seconds\u003e,v1=\u003chex\u003e\", computed over \"\u003ct\u003e.\u003craw body\u003e\" with the endpoint secret. The secret is only returned in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Register a webhook endpoint",
+                "parameters": [
+                    {
+                        "description": "Endpoint URL and event types",
+                        "name": "endpoint",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PostWebhookEndpointRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Endpoint registered",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookEndpointResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid URL or event type",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhook-endpoints/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Retrieve a webhook endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Endpoint ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Endpoint found",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookEndpointResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Endpoint not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Stop sending events to the endpoint. Deliveries still queued for it are dead-lettered.",
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook endpoint",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Endpoint ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Endpoint deleted"
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Endpoint not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhook-endpoints/{id}/deliveries": {
+            "get": {
+                "description": "List deliveries with their attempt log, newest first. Filter on status=dead to see the dead-letter queue.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "List an endpoint's deliveries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Endpoint ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "pending",
+                            "succeeded",
+                            "dead"
+                        ],
+                        "type": "string",
+                        "description": "Delivery status",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Deliveries",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.WebhookDeliveryResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Unknown status",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or unknown API key",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Endpoint not found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/live": {
+            "get": {
+                "description": "Reports that the process is up. No dependencies are checked, so a failing bank never gets the gateway restarted.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "The gateway is alive",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/ready": {
+            "get": {
+                "description": "Checks the repository, the bank (its circuit breaker and a lightweight probe) and the configuration, and reports each of them. Returns 503 when any is down, and while the gateway drains traffic before shutting down.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "Ready for traffic",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "A dependency is down or the gateway is shutting down",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.BlockedCardResponse": {
+            "type": "object",
+            "properties": {
+                "card_number_last_four": {
+                    "description": "Last 4 digits of the blocked card",
+                    "type": "string",
+                    "example": "8877"
+                },
+                "created_at": {
+                    "description": "When the card was blocked",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Unique blocklist entry ID",
+                    "type": "string",
+                    "example": "3e8c1a2b-7d4f-4b6e-9a05-1c2d3e4f5a6b"
+                },
+                "reason": {
+                    "description": "Why the card is blocked",
+                    "type": "string",
+                    "example": "chargeback"
+                }
+            }
+        },
+        "models.CancelSubscriptionRequest": {
+            "type": "object",
+            "properties": {
+                "at_period_end": {
+                    "description": "Keep the subscription until the paid period ends",
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "models.ChangePlanRequest": {
+            "type": "object",
+            "required": [
+                "plan_id"
+            ],
+            "properties": {
+                "plan_id": {
+                    "description": "Plan to move to",
+                    "type": "string",
+                    "example": "8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"
+                }
+            }
+        },
+        "models.ComponentHealth": {
+            "type": "object",
+            "properties": {
+                "duration_ms": {
+                    "description": "How long the check took",
+                    "type": "integer",
+                    "example": 3
+                },
+                "error": {
+                    "description": "Why the check failed",
+                    "type": "string",
+                    "example": "bank unreachable"
+                },
+                "status": {
+                    "description": "Whether the dependency is usable",
+                    "type": "string",
+                    "enum": [
+                        "up",
+                        "down"
+                    ],
+                    "example": "down"
+                }
+            }
+        },
+        "models.DeliveryAttemptResponse": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "description": "When the request was sent",
+                    "type": "string"
+                },
+                "duration_ms": {
+                    "description": "How long the request took",
+                    "type": "integer",
+                    "example": 84
+                },
+                "error": {
+                    "description": "Why the attempt failed",
+                    "type": "string",
+                    "example": "endpoint responded with status 500"
+                },
+                "status_code": {
+                    "description": "HTTP status returned by the endpoint",
+                    "type": "integer",
+                    "example": 500
+                }
+            }
+        },
+        "models.ErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "description": "Error message",
+                    "type": "string",
+                    "example": "card number must be between 14-19 digits"
+                },
+                "request_id": {
+                    "description": "ID of the failed request, also in the X-Request-ID header",
+                    "type": "string",
+                    "example": "0f8fad5b-d9cb-469f-a165-70867728950e"
+                }
+            }
+        },
+        "models.GetPaymentResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer",
+                    "example": 100
+                },
+                "captured_amount": {
+                    "description": "How much of the amount was taken from the card",
+                    "type": "integer",
+                    "example": 100
+                },
+                "card_number_last_four": {
+                    "type": "string",
+                    "example": "8877"
+                },
+                "correlation_id": {
+                    "description": "X-Correlation-ID sent when the payment was created",
+                    "type": "string",
+                    "example": "order-1234"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "GBP"
+                },
+                "decline_reason": {
+                    "description": "Why the bank declined the payment, when it said",
+                    "type": "string",
+                    "example": "insufficient_funds"
+                },
+                "expiry_month": {
+                    "type": "integer",
+                    "example": 12
+                },
+                "expiry_year": {
+                    "type": "integer",
+                    "example": 2026
+                },
+                "id": {
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "initiator": {
+                    "description": "Who initiated the payment",
+                    "type": "string",
+                    "example": "customer"
+                },
+                "network_transaction_id": {
+                    "description": "Reference for later merchant-initiated charges",
+                    "type": "string",
+                    "example": "a1b2c3d4"
+                },
+                "refunded_amount": {
+                    "description": "How much of the captured amount was given back",
+                    "type": "integer",
+                    "example": 40
+                },
+                "request_id": {
+                    "description": "ID of the request that created the payment",
+                    "type": "string",
+                    "example": "0f8fad5b-d9cb-469f-a165-70867728950e"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "Authorized",
+                        "Declined",
+                        "Rejected",
+                        "Pending",
+                        "Captured",
+                        "Voided",
+                        "Refunded"
+                    ],
+                    "example": "Authorized"
+                }
+            }
+        },
+        "models.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "components": {
+                    "description": "Status of each dependency, by name",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/models.ComponentHealth"
+                    }
+                },
+                "status": {
+                    "description": "Overall status; only up means ready for traffic",
+                    "type": "string",
+                    "enum": [
+                        "up",
+                        "down",
+                        "draining"
+                    ],
+                    "example": "up"
+                }
+            }
+        },
+        "models.PaymentBatchCounts": {
+            "type": "object",
+            "properties": {
+                "authorized": {
+                    "description": "Payments the bank authorized",
+                    "type": "integer",
+                    "example": 55
+                },
+                "canceled": {
+                    "description": "Payments never sent because the batch was canceled",
+                    "type": "integer",
+                    "example": 0
+                },
+                "declined": {
+                    "description": "Payments the bank declined",
+                    "type": "integer",
+                    "example": 3
+                },
+                "failed": {
+                    "description": "Invalid payments and payments the bank could not be reached for",
+                    "type": "integer",
+                    "example": 2
+                },
+                "pending": {
+                    "description": "Payments not processed yet",
+                    "type": "integer",
+                    "example": 40
+                },
+                "total": {
+                    "description": "Payments in the batch",
+                    "type": "integer",
+                    "example": 100
+                }
+            }
+        },
+        "models.PaymentBatchItemResponse": {
+            "type": "object",
+            "properties": {
+                "decline_reason": {
+                    "description": "Why the bank declined the payment, when it said",
+                    "type": "string",
+                    "example": "insufficient_funds"
+                },
+                "error": {
+                    "description": "Why the payment could not be made",
+                    "type": "string",
+                    "example": "card number must be between 14-19 digits"
+                },
+                "index": {
+                    "description": "Position of the payment in the upload, from 0",
+                    "type": "integer",
+                    "example": 0
+                },
+                "payment_id": {
+                    "description": "Payment created for the item",
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                },
+                "payment_status": {
+                    "description": "Bank's decision",
+                    "type": "string",
+                    "enum": [
+                        "Authorized",
+                        "Declined"
+                    ],
+                    "example": "Authorized"
+                },
+                "status": {
+                    "description": "Whether the payment was made",
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "succeeded",
+                        "failed",
+                        "canceled"
+                    ],
+                    "example": "succeeded"
+                }
+            }
+        },
+        "models.PaymentBatchResponse": {
+            "type": "object",
+            "properties": {
+                "completed_at": {
+                    "description": "When the batch's last payment was done",
+                    "type": "string"
+                },
+                "counts": {
+                    "description": "Progress so far",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.PaymentBatchCounts"
+                        }
+                    ]
+                },
+                "created_at": {
+                    "description": "When the batch was uploaded",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Unique batch ID",
+                    "type": "string",
+                    "example": "5b1f0c2e-7a4d-4e8b-9c3f-2d6a8e0b1c4f"
+                },
+                "status": {
+                    "description": "Batch status",
+                    "type": "string",
+                    "enum": [
+                        "processing",
+                        "completed",
+                        "canceled"
+                    ],
+                    "example": "processing"
+                }
+            }
+        },
+        "models.PaymentEventDataResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Amount in minor currency units",
+                    "type": "integer",
+                    "example": 100
+                },
+                "captured_amount": {
+                    "description": "How much of the amount was taken from the card",
+                    "type": "integer",
+                    "example": 100
+                },
+                "card_number_last_four": {
+                    "description": "Last four digits of the card number",
+                    "type": "string",
+                    "example": "8877"
+                },
+                "card_token": {
+                    "description": "Vault token the payment was made with",
+                    "type": "string",
+                    "example": "tok_3f9a2b"
+                },
+                "correlation_id": {
+                    "description": "X-Correlation-ID sent by the merchant",
+                    "type": "string",
+                    "example": "order-1234"
+                },
+                "currency": {
+                    "description": "Currency code",
+                    "type": "string",
+                    "example": "GBP"
+                },
+                "decline_reason": {
+                    "description": "Why the bank declined the payment",
+                    "type": "string",
+                    "example": "insufficient_funds"
+                },
+                "expiry_month": {
+                    "description": "Card expiry month",
+                    "type": "integer",
+                    "example": 4
+                },
+                "expiry_year": {
+                    "description": "Card expiry year",
+                    "type": "integer",
+                    "example": 2030
+                },
+                "initiator": {
+                    "description": "Who started the payment",
+                    "type": "string",
+                    "example": "customer"
+                },
+                "network_transaction_id": {
+                    "description": "Network transaction ID assigned by the bank",
+                    "type": "string",
+                    "example": "a1b2c3d4"
+                },
+                "refunded_amount": {
+                    "description": "How much of the captured amount was given back",
+                    "type": "integer",
+                    "example": 40
+                },
+                "status": {
+                    "description": "Status the payment moved to",
+                    "type": "string",
+                    "example": "Authorized"
+                },
+                "stored_credential": {
+                    "description": "Stored card agreement",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.StoredCredentialRequest"
+                        }
+                    ]
+                }
+            }
+        },
+        "models.PaymentEventResponse": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "description": "Who made the change",
+                    "type": "string",
+                    "example": "api_client:203.0.113.7"
+                },
+                "data": {
+                    "description": "What changed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.PaymentEventDataResponse"
+                        }
+                    ]
+                },
+                "id": {
+                    "description": "Unique event ID",
+                    "type": "string",
+                    "example": "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0"
+                },
+                "occurred_at": {
+                    "description": "When it happened",
+                    "type": "string"
+                },
+                "request_id": {
+                    "description": "Request that made the change",
+                    "type": "string",
+                    "example": "0f8fad5b-d9cb-469f-a165-70867728950e"
+                },
+                "sequence": {
+                    "description": "Position in the payment's history, from 1",
+                    "type": "integer",
+                    "example": 2
+                },
+                "type": {
+                    "description": "What happened",
+                    "type": "string",
+                    "example": "payment.authorized"
+                }
+            }
+        },
+        "models.PaymentListResponse": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "description": "Payments, newest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GetPaymentResponse"
+                    }
+                },
+                "has_more": {
+                    "description": "Whether there are older payments",
+                    "type": "boolean",
+                    "example": true
+                },
+                "next_starting_after": {
+                    "description": "Pass as starting_after to get the next page",
+                    "type": "string",
+                    "example": "550e8400-e29b-41d4-a716-446655440000"
+                }
+            }
+        },
+        "models.PaymentSource": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "description": "Token returned by POST /api/tokens",
+                    "type": "string",
+                    "example": "tok_4f1c2b8e9d0a4b6c8e2f1a3b5c7d9e0f"
+                }
+            }
+        },
+        "models.PlanResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Amount per period in minor currency units",
+                    "type": "integer",
+                    "example": 999
+                },
+                "created_at": {
+                    "description": "When the plan was created",
+                    "type": "string"
+                },
+                "currency": {
+                    "description": "Currency code",
+                    "type": "string",
+                    "example": "GBP"
+                },
+                "id": {
+                    "description": "Unique plan ID",
+                    "type": "string",
+                    "example": "8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"
+                },
+                "interval": {
+                    "description": "Billing interval unit",
+                    "type": "string",
+                    "example": "month"
+                },
+                "interval_count": {
+                    "description": "Number of intervals per period",
+                    "type": "integer",
+                    "example": 1
+                },
+                "name": {
+                    "description": "Plan name",
+                    "type": "string",
+                    "example": "Pro monthly"
+                }
+            }
+        },
+        "models.PostBlockedCardRequest": {
+            "type": "object",
+            "properties": {
+                "card_number": {
+                    "description": "Card number to block (14-19 digits, numeric only)",
+                    "type": "string",
+                    "maxLength": 19,
+                    "minLength": 14,
+                    "example": "2222405343248877"
+                },
+                "reason": {
+                    "description": "Why the card is blocked",
+                    "type": "string",
+                    "example": "chargeback"
+                },
+                "source": {
+                    "description": "Stored card to block instead of a card number",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.PaymentSource"
+                        }
+                    ]
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.ErrorResponse": {
+        },
+        "models.PostPaymentRequest": {
             "type": "object",
+            "required": [
+                "amount",
+                "currency"
+            ],
             "properties": {
-                "error": {
-                    "description": "Error message",
+                "amount": {
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 100
+                },
+                "card_number": {
                     "type": "string",
-                    "example": "card number must be between 14-19 digits"
+                    "maxLength": 19,
+                    "minLength": 14,
+                    "example": "2222405343248877"
+                },
+                "currency": {
+                    "type": "string",
+                    "enum": [
+                        "USD",
+                        "GBP",
+                        "EUR"
+                    ],
+                    "example": "GBP"
+                },
+                "cvv": {
+                    "type": "string",
+                    "maxLength": 4,
+                    "minLength": 3,
+                    "example": "123"
+                },
+                "expiry_month": {
+                    "type": "integer",
+                    "maximum": 12,
+                    "minimum": 1,
+                    "example": 12
+                },
+                "expiry_year": {
+                    "type": "integer",
+                    "example": 2026
+                },
+                "initiator": {
+                    "description": "Who initiated the payment, defaults to customer",
+                    "type": "string",
+                    "enum": [
+                        "customer",
+                        "merchant"
+                    ],
+                    "example": "customer"
+                },
+                "source": {
+                    "description": "Stored card to charge instead of raw card details",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.PaymentSource"
+                        }
+                    ]
+                },
+                "stored_credential": {
+                    "description": "Required for merchant-initiated payments",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.StoredCredentialRequest"
+                        }
+                    ]
                 }
             }
         },
-        "models.GetPaymentResponse": {
+        "models.PostPaymentResponse": {
             "type": "object",
             "properties": {
                 "amount": {
-                    "description": "Amount in minor currency units",
                     "type": "integer",
                     "example": 100
                 },
                 "card_number_last_four": {
-                    "description": "Last 4 digits of card",
                     "type": "string",
                     "example": "8877"
                 },
+                "correlation_id": {
+                    "description": "X-Correlation-ID sent when the payment was created",
+                    "type": "string",
+                    "example": "order-1234"
+                },
                 "currency": {
-                    "description": "Currency code",
                     "type": "string",
                     "example": "GBP"
                 },
+                "decline_reason": {
+                    "description": "Why the bank declined the payment, when it said",
+                    "type": "string",
+                    "example": "insufficient_funds"
+                },
                 "expiry_month": {
-                    "description": "Expiry month",
                     "type": "integer",
                     "example": 12
                 },
                 "expiry_year": {
-                    "description": "Expiry year",
                     "type": "integer",
                     "example": 2026
                 },
                 "id": {
-                    "description": "Unique payment ID",
                     "type": "string",
                     "example": "550e8400-e29b-41d4-a716-446655440000"
                 },
+                "network_transaction_id": {
+                    "description": "Reference for later merchant-initiated charges",
+                    "type": "string",
+                    "example": "a1b2c3d4"
+                },
+                "request_id": {
+                    "description": "ID of the request that created the payment",
+                    "type": "string",
+                    "example": "0f8fad5b-d9cb-469f-a165-70867728950e"
+                },
                 "status": {
-                    "description": "Payment status",
                     "type": "string",
                     "enum": [
                         "Authorized",
-                        "Declined"
+                        "Declined",
+                        "Rejected",
+                        "Pending"
                     ],
                     "example": "Authorized"
                 }
             }
         },
-        "models.PostPaymentRequest": {
+        "models.PostPlanRequest": {
             "type": "object",
             "required": [
                 "amount",
-                "card_number",
                 "currency",
-                "cvv",
-                "expiry_month",
-                "expiry_year"
+                "interval",
+                "name"
             ],
             "properties": {
                 "amount": {
-                    "description": "Amount in minor currency units (e.g., cents)",
+                    "description": "Amount per period in minor currency units",
                     "type": "integer",
                     "minimum": 1,
-                    "example": 100
-                },
-                "card_number": {
-                    "description": "Full card number (14-19 digits, numeric only)",
-                    "type": "string",
-                    "maxLength": 19,
-                    "minLength": 14,
-                    "example": "2222405343248877"
+                    "example": 999
                 },
                 "currency": {
                     "description": "Currency code (USD, GBP, or EUR)",
@@ -198,13 +2465,75 @@ const docTemplate = `{
                     ],
                     "example": "GBP"
                 },
+                "interval": {
+                    "description": "Billing interval unit",
+                    "type": "string",
+                    "enum": [
+                        "day",
+                        "week",
+                        "month",
+                        "year"
+                    ],
+                    "example": "month"
+                },
+                "interval_count": {
+                    "description": "Number of intervals per period, defaults to 1",
+                    "type": "integer",
+                    "minimum": 0,
+                    "example": 1
+                },
+                "name": {
+                    "description": "Plan name",
+                    "type": "string",
+                    "example": "Pro monthly"
+                }
+            }
+        },
+        "models.PostSubscriptionRequest": {
+            "type": "object",
+            "required": [
+                "cvv",
+                "plan_id",
+                "source"
+            ],
+            "properties": {
                 "cvv": {
-                    "description": "CVV (3-4 digits)",
+                    "description": "CVV for the first, customer-initiated charge",
                     "type": "string",
                     "maxLength": 4,
                     "minLength": 3,
                     "example": "123"
                 },
+                "plan_id": {
+                    "description": "Plan to subscribe to",
+                    "type": "string",
+                    "example": "8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"
+                },
+                "source": {
+                    "description": "Stored card to charge",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.PaymentSource"
+                        }
+                    ]
+                }
+            }
+        },
+        "models.PostTokenRequest": {
+            "type": "object",
+            "required": [
+                "card_number",
+                "expiry_month",
+                "expiry_year"
+            ],
+            "properties": {
+                "card_number": {
+                    "description": "Full card number (14-19 digits, numeric only)",
+                    "type": "string",
+                    "maxLength": 19,
+                    "minLength": 14,
+                    "example": "2222405343248877"
+                },
                 "expiry_month": {
                     "description": "Expiry month (1-12)",
                     "type": "integer",
@@ -219,24 +2548,14 @@ const docTemplate = `{
                 }
             }
         },
-        "models.PostPaymentResponse": {
+        "models.PostTokenResponse": {
             "type": "object",
             "properties": {
-                "amount": {
-                    "description": "Amount in minor currency units",
-                    "type": "integer",
-                    "example": 100
-                },
                 "card_number_last_four": {
                     "description": "Last 4 digits of card",
                     "type": "string",
                     "example": "8877"
                 },
-                "currency": {
-                    "description": "Currency code",
-                    "type": "string",
-                    "example": "GBP"
-                },
                 "expiry_month": {
                     "description": "Expiry month",
                     "type": "integer",
@@ -247,11 +2566,122 @@ const docTemplate = `{
                     "type": "integer",
                     "example": 2026
                 },
-                "id": {
-                    "description": "Unique payment ID",
+                "token": {
+                    "description": "Opaque token to use as source.token",
+                    "type": "string",
+                    "example": "tok_4f1c2b8e9d0a4b6c8e2f1a3b5c7d9e0f"
+                }
+            }
+        },
+        "models.PostWebhookEndpointRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "event_types": {
+                    "description": "Events to receive; all of them when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "payment.authorized",
+                        "payment.declined"
+                    ]
+                },
+                "url": {
+                    "description": "Where deliveries are POSTed",
+                    "type": "string",
+                    "example": "https://merchant.example.com/webhooks"
+                }
+            }
+        },
+        "models.SettlePaymentRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Amount in minor currency units; all that is left when omitted",
+                    "type": "integer",
+                    "minimum": 1,
+                    "example": 100
+                }
+            }
+        },
+        "models.StoredCredentialRequest": {
+            "type": "object",
+            "required": [
+                "type",
+                "usage"
+            ],
+            "properties": {
+                "previous_network_transaction_id": {
+                    "description": "Network transaction ID of the payment that set up the credential",
+                    "type": "string",
+                    "example": "a1b2c3d4"
+                },
+                "type": {
+                    "description": "Agreement the card is stored under",
+                    "type": "string",
+                    "enum": [
+                        "recurring",
+                        "unscheduled"
+                    ],
+                    "example": "recurring"
+                },
+                "usage": {
+                    "description": "First or subsequent use of the stored card",
+                    "type": "string",
+                    "enum": [
+                        "first",
+                        "subsequent"
+                    ],
+                    "example": "subsequent"
+                }
+            }
+        },
+        "models.SubscriptionChargeResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "description": "Amount in minor currency units",
+                    "type": "integer",
+                    "example": 999
+                },
+                "attempt": {
+                    "description": "Attempt number within the dunning cycle",
+                    "type": "integer",
+                    "example": 1
+                },
+                "created_at": {
+                    "description": "When the charge was attempted",
+                    "type": "string"
+                },
+                "currency": {
+                    "description": "Currency code",
+                    "type": "string",
+                    "example": "GBP"
+                },
+                "error": {
+                    "description": "Why the payment could not be made",
+                    "type": "string",
+                    "example": "bank service unavailable"
+                },
+                "payment_id": {
+                    "description": "Payment created for the charge",
                     "type": "string",
                     "example": "550e8400-e29b-41d4-a716-446655440000"
                 },
+                "reason": {
+                    "description": "Why the charge was made",
+                    "type": "string",
+                    "enum": [
+                        "initial",
+                        "renewal",
+                        "proration"
+                    ],
+                    "example": "renewal"
+                },
                 "status": {
                     "description": "Payment status",
                     "type": "string",
@@ -263,6 +2693,164 @@ const docTemplate = `{
                     "example": "Authorized"
                 }
             }
+        },
+        "models.SubscriptionResponse": {
+            "type": "object",
+            "properties": {
+                "cancel_at_period_end": {
+                    "description": "Whether the subscription ends with the current period",
+                    "type": "boolean",
+                    "example": false
+                },
+                "canceled_at": {
+                    "description": "When the subscription was canceled",
+                    "type": "string"
+                },
+                "charges": {
+                    "description": "Every charge attempt, oldest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.SubscriptionChargeResponse"
+                    }
+                },
+                "created_at": {
+                    "description": "When the subscription was created",
+                    "type": "string"
+                },
+                "credit": {
+                    "description": "Proration credit taken off the next renewals",
+                    "type": "integer",
+                    "example": 0
+                },
+                "current_period_end": {
+                    "description": "End of the paid period",
+                    "type": "string"
+                },
+                "current_period_start": {
+                    "description": "Start of the paid period",
+                    "type": "string"
+                },
+                "failed_attempts": {
+                    "description": "Consecutive failed renewals",
+                    "type": "integer",
+                    "example": 0
+                },
+                "id": {
+                    "description": "Unique subscription ID",
+                    "type": "string",
+                    "example": "1f0e3a4b-8c6d-4e2f-9a1b-3c5d7e9f0a2b"
+                },
+                "next_charge_at": {
+                    "description": "Next renewal or retry",
+                    "type": "string"
+                },
+                "paused_at": {
+                    "description": "When the subscription was paused",
+                    "type": "string"
+                },
+                "plan_id": {
+                    "description": "Current plan",
+                    "type": "string",
+                    "example": "8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"
+                },
+                "status": {
+                    "description": "Subscription status",
+                    "type": "string",
+                    "enum": [
+                        "active",
+                        "past_due",
+                        "paused",
+                        "canceled",
+                        "reconciling"
+                    ],
+                    "example": "active"
+                }
+            }
+        },
+        "models.WebhookDeliveryResponse": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "description": "Every attempt, oldest first",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DeliveryAttemptResponse"
+                    }
+                },
+                "created_at": {
+                    "description": "When the event was queued",
+                    "type": "string"
+                },
+                "endpoint_id": {
+                    "description": "Endpoint it is delivered to",
+                    "type": "string",
+                    "example": "0b6f7c1e-9a3d-4f2b-8e51-2c7d9a4b6e10"
+                },
+                "event_id": {
+                    "description": "Event being delivered",
+                    "type": "string",
+                    "example": "evt_7d9e0f1a-2b3c-4d5e-6f70-8192a3b4c5d6"
+                },
+                "failures": {
+                    "description": "Consecutive failed attempts",
+                    "type": "integer",
+                    "example": 2
+                },
+                "id": {
+                    "description": "Unique delivery ID",
+                    "type": "string",
+                    "example": "5c1d2e3f-4a5b-6c7d-8e9f-0a1b2c3d4e5f"
+                },
+                "next_attempt_at": {
+                    "description": "When a pending delivery is tried next",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Delivery status; dead deliveries are in the dead-letter queue",
+                    "type": "string",
+                    "enum": [
+                        "pending",
+                        "succeeded",
+                        "dead"
+                    ],
+                    "example": "pending"
+                }
+            }
+        },
+        "models.WebhookEndpointResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "description": "When the endpoint was registered",
+                    "type": "string"
+                },
+                "event_types": {
+                    "description": "Events the endpoint receives; all of them when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "payment.authorized",
+                        "payment.declined"
+                    ]
+                },
+                "id": {
+                    "description": "Unique endpoint ID",
+                    "type": "string",
+                    "example": "0b6f7c1e-9a3d-4f2b-8e51-2c7d9a4b6e10"
+                },
+                "secret": {
+                    "description": "HMAC-SHA256 signing secret, only returned when the endpoint is created",
+                    "type": "string",
+                    "example": "whsec_3f9a..."
+                },
+                "url": {
+                    "description": "Where deliveries are POSTed",
+                    "type": "string",
+                    "example": "https://merchant.example.com/webhooks"
+                }
+            }
         }
     }
 }`
@@ -274,7 +2862,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{"http"},
 	Title:            "Payment Gateway API",
-	Description:      "A payment gateway API that allows merchants to process card payments and retrieve payment details.\nThe gateway validates requests, communicates with an acquiring bank, and stores payment information.\n\n## Payment Status\n- **Authorized**: Payment was approved by the bank\n- **Declined**: Payment was declined by the bank\n- **Rejected**: Payment was rejected due to validation errors (never sent to bank)\n\n## Security\n- Only the last 4 digits of card numbers are stored and returned\n- CVV is never stored, only sent to the bank\n\n## Supported Currencies\nUSD, GBP, EUR",
+	Description:      "A payment gateway API that allows merchants to process card payments and retrieve payment details.\nThe gateway validates requests, communicates with an acquiring bank, and stores payment information.\n\n## Payment Status\n- **Authorized**: Payment was approved by the bank\n- **Declined**: Payment was declined by the bank\n- **Rejected**: Payment was rejected due to validation errors (never sent to bank)\n- **Captured**: An authorized payment was taken from the card\n- **Voided**: An authorized payment was released without being taken\n- **Refunded**: A captured payment was given back, in full or in part\n\n## Security\n- Only the last 4 digits of card numbers are returned\n- CVV is never stored, only sent to the bank\n- Cards saved with POST /api/tokens are encrypted in the vault and only ever referenced by an opaque token\n- Stored card numbers are envelope-encrypted with per-record keys and can be crypto-shredded\n- Every change to a payment is kept in an append-only audit log, see GET /api/payments/{id}/events\n- Request bodies are size-limited and fields the API does not know are rejected\n- HTTPS with optional mutual TLS; merchants with a client certificate are identified by it\n- Merchants authenticate with an API key in an `Authorization: Bearer` header; only its SHA-256 hash is configured\n- Card tokens, plans, subscriptions, blocked cards and webhook endpoints need an API key, and each merchant only ever sees its own; payments and batches made without a key are only seen by callers without one\n\n## Rate Limits\nRequests to /api are rate limited per API key, or per IP address for callers without one, with limits configurable per merchant. Every limited response carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; a request over the limit gets 429 with Retry-After. Each merchant may also only have a limited number of payments waiting on the bank at once; further payments get 429 without reaching the bank.\n\n## Load Shedding\nThe number of payments sent to the bank at once adapts to how quickly it answers. When the bank slows down, payments wait briefly for a slot and are otherwise turned away with 503 and Retry-After, without being sent to the bank, so they can be retried safely.\n\n## Webhooks\nRegister an endpoint with POST /api/webhook-endpoints to receive events about your payments. Each delivery is signed with HMAC-SHA256 and retried with exponential backoff until it succeeds or is dead-lettered.\n\n## Request IDs\nEvery response carries an X-Request-ID header, taken from the request or generated. It is included in error bodies and logs, stored on payments and sent to the bank. A merchant can also send an X-Correlation-ID of their own, which is stored and forwarded the same way.\n\n## Monitoring\nGET /metrics serves Prometheus metrics, including payment outcomes by status, currency and acquirer, bank latency and errors, and the state of the bank circuit breaker.\n\n## Health\nGET /health/live reports the process is up. GET /health/ready checks the repository, the bank and the configuration, and returns 503 when any is down. On shutdown readiness fails first, so load balancers drain traffic before the server stops listening.\n\n## Tracing\nRequests are traced with OpenTelemetry. Send a W3C traceparent header to join an existing trace; it is passed on to the acquiring bank. Spans carry the payment ID, status and currency, never card details.\n\n## Logging\nLogs are structured JSON. Card numbers, CVVs and API keys are masked before anything is written.\n\n## Supported Currencies\nUSD, GBP, EUR by default. The list is configurable.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -2,59 +2,512 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/async"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/batch"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/blocklist"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/health"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/idempotency"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/logging"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/metrics"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/ratelimit"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/service"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/subscription"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tlsconfig"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/vault"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/webhook"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
 )
 
+// keyRotationInterval is how often stored card data is checked for data keys
+// wrapped by an old master key version
+const keyRotationInterval = time.Hour
+
+// renewalInterval is how often the scheduler looks for subscriptions due
+// for renewal
+const renewalInterval = time.Minute
+
+// masterKeyEnvPrefix names the environment variables master keys are read
+// from when no key file is configured
+const masterKeyEnvPrefix = "GATEWAY_MASTER_KEY"
+
+// webhookDispatchInterval is how often the webhook outbox is checked for
+// deliveries that are due
+const webhookDispatchInterval = time.Second
+
 type Api struct {
-	router         *chi.Mux
-	paymentService *service.PaymentService
+	router              *chi.Mux
+	paymentService      *service.PaymentService
+	paymentWorkers      *async.Pool
+	subscriptionService *subscription.Service
+	batchService        *batch.Service
+	scheduler           *subscription.Scheduler
+	webhookService      *webhook.Service
+	dispatcher          *webhook.Dispatcher
+	vault               *vault.Vault
+	blocklist           *blocklist.Blocklist
+	keyRotator          *envelope.Rotator
+	metrics             *metrics.Metrics
+	health              *health.Health
+	bankClient          bankConfigurer
+	server              config.Server
+	grpcServer          *grpc.Server
+	grpcHealth          *grpchealth.Server
+	grpcAddr            string
+	batches             config.Batches
+	idempotencyKeys     idempotency.Store
+	tlsConfig           *tls.Config
+	merchants           *merchant.Registry
+	rateLimits          *atomic.Pointer[config.RateLimits]
+	rateLimiter         ratelimit.Limiter
+	storage             []io.Closer
+}
+
+type options struct {
+	clock       clock.Clock
+	rateLimiter ratelimit.Limiter
+	adapters    *client.AdapterRegistry
 }
 
-func New() *Api {
-	return NewWithBankURL("http://localhost:8081")
+// Option configures an Api
+type Option func(*options)
+
+// WithClock replaces the wall clock used for subscription billing, so tests
+// can move time forward
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithRateLimiter replaces the in-memory rate limiter, e.g. with one backed
+// by a store shared between gateway instances
+func WithRateLimiter(l ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.rateLimiter = l
+	}
 }
 
-func NewWithBankURL(bankURL string) *Api {
+// WithAdapters replaces the bank adapters bank.adapter is chosen from, so
+// an acquirer with its own protocol can be added
+func WithAdapters(r *client.AdapterRegistry) Option {
+	return func(o *options) {
+		o.adapters = r
+	}
+}
+
+// NewFromConfig builds an Api from a validated configuration
+func NewFromConfig(cfg *config.Config, opts ...Option) (_ *Api, err error) {
+	o := options{clock: clock.Real{}, adapters: client.DefaultAdapters()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.rateLimiter == nil {
+		o.rateLimiter = ratelimit.NewMemoryLimiter(ratelimit.WithClock(o.clock))
+	}
+
+	keyProvider, err := newKeyProvider(cfg.MasterKeys)
+	if err != nil {
+		return nil, err
+	}
+	cardEnvelope := envelope.New(keyProvider)
+
+	// Closed in reverse by Close, or here if the Api cannot be built
+	var storage []io.Closer
+	defer func() {
+		if err != nil {
+			closeAll(storage)
+		}
+	}()
+
+	storageLock, err := lockStorage(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if storageLock != nil {
+		storage = append(storage, storageLock)
+	}
+
+	webhookRepo, err := newWebhooksRepository(cfg.Storage, cardEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, webhookRepo)
+
+	paymentJobs, err := newPaymentJobsRepository(cfg.Storage, cardEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, paymentJobs)
+
+	subscriptionRepo, err := newSubscriptionsRepository(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, subscriptionRepo)
+
+	cardVault, err := newVault(cfg.Storage, cardEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, cardVault)
+
+	cardBlocklist, err := newBlocklist(cfg.Storage, cardEnvelope, cardVault, o.clock)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, cardBlocklist)
+
+	repo, err := newPaymentsRepository(cfg.Storage, cardEnvelope)
+	if err != nil {
+		return nil, err
+	}
+	storage = append(storage, repo)
+	warnUnpersisted(cfg.Storage)
+
+	domain.SetSupportedCurrencies(cfg.Currencies)
+
 	// Initialize dependencies from bottom up
-	repo := repository.NewPaymentsRepository()
-	bankClient := client.NewHTTPBankClient(bankURL)
-	paymentService := service.NewPaymentService(bankClient, repo)
+	gatewayMetrics := metrics.New()
+	restoreQueuedPayments(repo, paymentJobs)
+	bankTraffic, bankSettings, err := newBankTraffic(cfg.Bank, o.adapters, cardVault, gatewayMetrics)
+	if err != nil {
+		return nil, err
+	}
+	bankClient := client.NewCircuitBreaker(bankTraffic,
+		client.WithFailureThreshold(cfg.Bank.CircuitBreaker.FailureThreshold),
+		client.WithCooldown(cfg.Bank.CircuitBreaker.Cooldown),
+	)
+	// Outside the circuit breaker, so calls shed here do not count as bank
+	// failures
+	adaptiveLimiter := client.NewAdaptiveLimiter(bankClient,
+		client.WithLimits(cfg.Bank.Concurrency.InitialLimit, cfg.Bank.Concurrency.MinLimit, cfg.Bank.Concurrency.MaxLimit),
+		client.WithLatencyThreshold(cfg.Bank.Concurrency.LatencyThreshold),
+		client.WithBackoffRatio(cfg.Bank.Concurrency.BackoffRatio),
+		client.WithQueue(cfg.Bank.Concurrency.MaxQueueLength, cfg.Bank.Concurrency.MaxQueueWait),
+	)
+	gatewayMetrics.WatchRepositorySize(repo.Count)
+	gatewayMetrics.WatchCircuitBreaker(bankClient.State)
+	gatewayMetrics.WatchBankConcurrency(adaptiveLimiter)
+
+	rateLimits := &atomic.Pointer[config.RateLimits]{}
+	initialLimits := cfg.RateLimits
+	rateLimits.Store(&initialLimits)
+	// A merchant over its own cap is turned away before taking one of the
+	// shared slots
+	limitedBankClient := client.NewConcurrencyLimiter(adaptiveLimiter, bankCallPartition(rateLimits))
+
+	paymentWorkers := async.NewPool(paymentJobs,
+		async.WithWorkers(cfg.Async.Workers),
+		async.WithQueueDepth(cfg.Async.QueueDepth),
+		async.WithMaxAttempts(cfg.Async.MaxAttempts),
+	)
+	gatewayMetrics.WatchPaymentQueue(paymentWorkers.Len)
+
+	webhookService := webhook.NewService(webhookRepo, webhook.WithClock(o.clock))
+	paymentService := service.NewPaymentService(limitedBankClient, repo,
+		service.WithCardVault(cardVault),
+		service.WithEventPublisher(webhookService),
+		service.WithPaymentObserver(gatewayMetrics),
+		service.WithPaymentQueue(paymentWorkers),
+		service.WithBlocklist(cardBlocklist),
+	)
+	subscriptionService := subscription.NewService(
+		paymentService,
+		subscriptionRepo,
+		subscription.WithClock(o.clock),
+		subscription.WithDunningPolicy(subscription.DunningPolicy{
+			Retries:    cfg.Subscriptions.Dunning.Retries,
+			ErrorRetry: cfg.Subscriptions.Dunning.ErrorRetry,
+		}),
+	)
+	batchService := batch.NewService(
+		paymentService,
+		repository.NewBatchesRepository(),
+		batch.WithClock(o.clock),
+		batch.WithConcurrency(cfg.Batches.Concurrency),
+		batch.WithMaxItems(cfg.Batches.MaxItems),
+	)
+
+	dispatcher := webhook.NewDispatcher(webhookRepo, webhookDispatchInterval,
+		webhook.WithDispatcherClock(o.clock),
+		webhook.WithHTTPClient(webhook.NewHTTPClient(cfg.Webhooks.AllowPrivateNetworks)),
+	)
+
+	gatewayHealth := health.New()
+	gatewayHealth.Register("repository", health.CheckerFunc(func(ctx context.Context) error {
+		return errors.Join(repo.Ping(ctx), cardVault.Ping(ctx), webhookRepo.Ping(ctx), paymentJobs.Ping(ctx), subscriptionRepo.Ping(ctx), cardBlocklist.Ping(ctx))
+	}))
+	gatewayHealth.Register("bank", health.CheckerFunc(bankClient.Ping))
+	gatewayHealth.Register("config", health.CheckerFunc(func(ctx context.Context) error {
+		// Card data can be neither stored nor read without a master key
+		if _, err := keyProvider.CurrentKey(); err != nil {
+			return fmt.Errorf("master key unavailable: %w", err)
+		}
+		return nil
+	}))
 
 	a := &Api{
-		paymentService: paymentService,
+		paymentService:      paymentService,
+		paymentWorkers:      paymentWorkers,
+		subscriptionService: subscriptionService,
+		batchService:        batchService,
+		scheduler:           subscription.NewScheduler(subscriptionService, renewalInterval),
+		webhookService:      webhookService,
+		dispatcher:          dispatcher,
+		vault:               cardVault,
+		blocklist:           cardBlocklist,
+		keyRotator:          envelope.NewRotator(cardEnvelope, keyRotationInterval, cardVault, cardBlocklist, repo, paymentJobs, webhookRepo),
+		metrics:             gatewayMetrics,
+		health:              gatewayHealth,
+		bankClient:          bankSettings,
+		server:              cfg.Server,
+		grpcAddr:            cfg.GRPC.Addr,
+		batches:             cfg.Batches,
+		idempotencyKeys:     idempotency.NewMemoryStore(idempotency.WithClock(o.clock)),
+		merchants:           merchant.NewRegistry(merchantsFromConfig(cfg)),
+		rateLimits:          rateLimits,
+		rateLimiter:         o.rateLimiter,
+		storage:             storage,
 	}
+
+	if cfg.TLS.Enabled() {
+		if a.tlsConfig, err = tlsconfig.New(cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+
 	a.setupRouter()
+	a.setupGRPC()
 
-	return a
+	return a, nil
 }
 
-func (a *Api) Run(ctx context.Context, addr string) error {
+// Reload applies the settings that can change without a restart: the bank
+// timeout and retry policy, the supported currencies, rate limits and
+// merchants. See config.RestartRequired for the rest.
+func (a *Api) Reload(cfg *config.Config) {
+	a.bankClient.Configure(cfg.Bank.Timeout, retryPolicy(cfg.Bank.Retry))
+	domain.SetSupportedCurrencies(cfg.Currencies)
+	rateLimits := cfg.RateLimits
+	a.rateLimits.Store(&rateLimits)
+	a.merchants.Replace(merchantsFromConfig(cfg))
+}
+
+// merchantsFromConfig fills in the gateway-wide limits for merchants that
+// do not set their own
+func merchantsFromConfig(cfg *config.Config) []merchant.Merchant {
+	merchants := make([]merchant.Merchant, 0, len(cfg.Merchants))
+	for _, m := range cfg.Merchants {
+		rateLimit := m.RateLimit
+		if rateLimit.RequestsPerSecond == 0 {
+			rateLimit = cfg.RateLimits.PerAPIKey
+		}
+		maxConcurrent := m.MaxConcurrentBankCalls
+		if maxConcurrent == 0 {
+			maxConcurrent = cfg.RateLimits.MaxConcurrentBankCalls
+		}
+
+		merchants = append(merchants, merchant.Merchant{
+			ID:                     m.ID,
+			APIKeyHashes:           m.APIKeySHA256,
+			RequestsPerSecond:      rateLimit.RequestsPerSecond,
+			Burst:                  rateLimit.Burst,
+			MaxConcurrentBankCalls: maxConcurrent,
+		})
+	}
+	return merchants
+}
+
+// bankCallPartition gives every merchant its own allowance of bank calls.
+// Callers without an API key, including the subscription scheduler, share
+// one.
+func bankCallPartition(rateLimits *atomic.Pointer[config.RateLimits]) client.Partition {
+	return func(ctx context.Context) (string, int) {
+		if m, ok := merchant.FromContext(ctx); ok {
+			return "merchant:" + m.ID, m.MaxConcurrentBankCalls
+		}
+		return "anonymous", rateLimits.Load().MaxConcurrentBankCalls
+	}
+}
+
+// bankConfigurer is the bank client, whose timeout and retries can be
+// reloaded
+type bankConfigurer interface {
+	Configure(timeout time.Duration, retry client.RetryPolicy)
+}
+
+// newBankTraffic builds the client that talks to the bank in the
+// configured protocol, before any circuit breaking or load shedding
+func newBankTraffic(cfg config.Bank, adapters *client.AdapterRegistry, detokenizer client.Detokenizer, observer client.Observer) (client.BankClient, bankConfigurer, error) {
+	if cfg.Protocol == config.ProtocolISO8583 {
+		opts := []client.ISO8583Option{
+			client.WithISO8583Detokenizer(detokenizer),
+			client.WithISO8583Observer(observer),
+			client.WithISO8583Timeout(cfg.Timeout),
+			client.WithISO8583RetryPolicy(retryPolicy(cfg.Retry)),
+			client.WithTerminal(cfg.ISO8583.TerminalID, cfg.ISO8583.MerchantID),
+		}
+		if cfg.ISO8583.Financial {
+			opts = append(opts, client.WithFinancialMessages())
+		}
+		isoClient := client.NewISO8583Client(cfg.ISO8583.Addr, opts...)
+		return isoClient, isoClient, nil
+	}
+
+	adapter, err := adapters.New(cfg.Adapter, fieldMapping(cfg.Mapping))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpBankClient := client.NewHTTPBankClient(cfg.URL,
+		client.WithDetokenizer(detokenizer),
+		client.WithObserver(observer),
+		client.WithTimeout(cfg.Timeout),
+		client.WithRetryPolicy(retryPolicy(cfg.Retry)),
+		client.WithAdapter(adapter),
+	)
+	if cfg.RecordTo != "" {
+		slog.Warn("recording bank traffic", "path", cfg.RecordTo)
+		return client.NewRecorder(httpBankClient, client.WithCassetteFile(cfg.RecordTo)), httpBankClient, nil
+	}
+	return httpBankClient, httpBankClient, nil
+}
+
+func retryPolicy(r config.Retry) client.RetryPolicy {
+	return client.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: r.InitialBackoff,
+		MaxBackoff:     r.MaxBackoff,
+	}
+}
+
+func fieldMapping(m config.FieldMapping) client.FieldMapping {
+	return client.FieldMapping{
+		Path: m.Path,
+		Request: client.RequestMapping{
+			CardNumber:                   m.Request.CardNumber,
+			ExpiryDate:                   m.Request.ExpiryDate,
+			ExpiryFormat:                 m.Request.ExpiryFormat,
+			Currency:                     m.Request.Currency,
+			Amount:                       m.Request.Amount,
+			AmountFormat:                 m.Request.AmountFormat,
+			CVV:                          m.Request.CVV,
+			Initiator:                    m.Request.Initiator,
+			StoredCredentialUsage:        m.Request.StoredCredentialUsage,
+			StoredCredentialType:         m.Request.StoredCredentialType,
+			PreviousNetworkTransactionID: m.Request.PreviousNetworkTransactionID,
+		},
+		Response: client.ResponseMapping{
+			Authorized:           m.Response.Authorized,
+			ApprovedValue:        m.Response.ApprovedValue,
+			AuthorizationCode:    m.Response.AuthorizationCode,
+			NetworkTransactionID: m.Response.NetworkTransactionID,
+		},
+	}
+}
+
+func (a *Api) Run(ctx context.Context) error {
 	httpServer := &http.Server{
-		Addr:        addr,
-		Handler:     a.router,
-		BaseContext: func(_ net.Listener) context.Context { return ctx },
+		Addr:              a.server.Addr,
+		Handler:           a.router,
+		ReadHeaderTimeout: a.server.ReadHeaderTimeout,
+		ReadTimeout:       a.server.ReadTimeout,
+		WriteTimeout:      a.server.WriteTimeout,
+		IdleTimeout:       a.server.IdleTimeout,
+		TLSConfig:         a.tlsConfig,
+		// Requests still being served while draining must not be cancelled
+		// along with ctx
+		BaseContext: func(_ net.Listener) context.Context { return context.WithoutCancel(ctx) },
 	}
 
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
 		<-ctx.Done()
-		fmt.Printf("shutting down HTTP server\n")
-		return httpServer.Shutdown(ctx)
+
+		// Fail readiness first and keep serving while load balancers notice,
+		// so no new traffic is sent to a server that has stopped listening
+		a.health.Drain()
+		a.grpcHealth.Shutdown()
+		slog.InfoContext(ctx, "draining before shutdown", "delay", a.server.DrainDelay)
+		time.Sleep(a.server.DrainDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), a.server.ShutdownTimeout)
+		defer cancel()
+
+		var grpcStopped sync.WaitGroup
+		if a.grpcAddr != "" {
+			grpcStopped.Add(1)
+			go func() {
+				defer grpcStopped.Done()
+				slog.InfoContext(ctx, "shutting down gRPC server")
+				a.stopGRPC(shutdownCtx)
+			}()
+		}
+
+		slog.InfoContext(ctx, "shutting down HTTP server")
+		err := httpServer.Shutdown(shutdownCtx)
+		grpcStopped.Wait()
+		return err
+	})
+
+	if a.grpcAddr != "" {
+		g.Go(func() error {
+			return a.serveGRPC(ctx)
+		})
+	}
+
+	g.Go(func() error {
+		return a.keyRotator.Run(ctx)
+	})
+
+	g.Go(func() error {
+		return a.scheduler.Run(ctx)
+	})
+
+	g.Go(func() error {
+		return a.dispatcher.Run(ctx)
 	})
 
 	g.Go(func() error {
-		fmt.Printf("starting HTTP server on %s\n", addr)
-		err := httpServer.ListenAndServe()
+		return a.RunPaymentWorkers(ctx)
+	})
+
+	g.Go(func() error {
+		var err error
+		if a.tlsConfig != nil {
+			slog.InfoContext(ctx, "starting HTTPS server", "addr", a.server.Addr)
+			// The certificate comes from TLSConfig, which reloads it
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			slog.InfoContext(ctx, "starting HTTP server", "addr", a.server.Addr)
+			err = httpServer.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			return err
 		}
@@ -67,16 +520,241 @@ func (a *Api) Run(ctx context.Context, addr string) error {
 
 func (a *Api) setupRouter() {
 	a.router = chi.NewRouter()
-	a.router.Use(middleware.Logger)
-	a.router.Use(middleware.Recoverer) // Recover from panics
+	a.router.Use(limitBody(a.server.MaxBodyBytes, map[string]int64{
+		"/api/payment-batches": a.batches.MaxBodyBytes,
+	}))
+	a.router.Use(requestIDs)
+	a.router.Use(tracing.Middleware)
+	a.router.Use(a.metrics.Middleware)
+	a.router.Use(logging.Middleware)
+	a.router.Use(logging.Recoverer) // Recover from panics
 
 	a.router.Get("/ping", a.PingHandler())
+	a.router.Get("/health/live", a.LiveHandler())
+	a.router.Get("/health/ready", a.ReadyHandler())
+	a.router.Method(http.MethodGet, "/metrics", a.MetricsHandler())
 	a.router.Get("/swagger/*", a.SwaggerHandler())
 
-	a.router.Post("/api/payments", a.PostPaymentHandler())
-	a.router.Get("/api/payments/{id}", a.GetPaymentHandler())
+	// Merchants are rate limited and identified on the API alone, so
+	// probes and metrics scrapes are never turned away
+	a.router.Group(func(r chi.Router) {
+		r.Use(ratelimit.Middleware(a.rateLimiter, a.rateLimitRules, http.HandlerFunc(tooManyRequests)))
+		r.Use(authenticate(a.merchants))
+		r.Use(auditContext)
+
+		// Tokens, plans, subscriptions, blocked cards and webhook endpoints
+		// belong to the merchant that made them, so they are only served to
+		// a caller with an API key
+		owned := r.With(requireMerchant)
+		// Requests that create payments may carry an Idempotency-Key, so
+		// clients can retry them safely
+		idempotent := r.With(idempotency.Middleware(a.idempotencyKeys, idempotencyScope, writeError))
+
+		// Payments and batches can still be made without an API key. They
+		// are scoped all the same: callers without one only see those made
+		// without one.
+		idempotent.Post("/api/payments", a.PostPaymentHandler())
+		r.Get("/api/payments", a.ListPaymentsHandler())
+		r.Get("/api/payments/{id}", a.GetPaymentHandler())
+		r.Get("/api/payments/{id}/events", a.GetPaymentEventsHandler())
+		idempotent.Post("/api/payments/{id}/capture", a.CapturePaymentHandler())
+		idempotent.Post("/api/payments/{id}/void", a.VoidPaymentHandler())
+		idempotent.Post("/api/payments/{id}/refund", a.RefundPaymentHandler())
+
+		idempotent.Post("/api/payment-batches", a.PostPaymentBatchHandler())
+		r.Get("/api/payment-batches/{id}", a.GetPaymentBatchHandler())
+		r.Get("/api/payment-batches/{id}/results", a.GetPaymentBatchResultsHandler())
+		r.Post("/api/payment-batches/{id}/cancel", a.CancelPaymentBatchHandler())
+
+		owned.Post("/api/tokens", a.PostTokenHandler())
+		owned.Delete("/api/tokens/{token}", a.DeleteTokenHandler())
+
+		owned.Post("/api/plans", a.PostPlanHandler())
+		owned.Get("/api/plans/{id}", a.GetPlanHandler())
+
+		owned.Post("/api/subscriptions", a.PostSubscriptionHandler())
+		owned.Get("/api/subscriptions/{id}", a.GetSubscriptionHandler())
+		owned.Post("/api/subscriptions/{id}/pause", a.PauseSubscriptionHandler())
+		owned.Post("/api/subscriptions/{id}/resume", a.ResumeSubscriptionHandler())
+		owned.Post("/api/subscriptions/{id}/cancel", a.CancelSubscriptionHandler())
+		owned.Post("/api/subscriptions/{id}/change-plan", a.ChangePlanHandler())
+
+		owned.Post("/api/blocked-cards", a.PostBlockedCardHandler())
+		owned.Get("/api/blocked-cards", a.ListBlockedCardsHandler())
+		owned.Delete("/api/blocked-cards/{id}", a.DeleteBlockedCardHandler())
+
+		owned.Post("/api/webhook-endpoints", a.PostWebhookEndpointHandler())
+		owned.Get("/api/webhook-endpoints/{id}", a.GetWebhookEndpointHandler())
+		owned.Delete("/api/webhook-endpoints/{id}", a.DeleteWebhookEndpointHandler())
+		owned.Get("/api/webhook-endpoints/{id}/deliveries", a.ListWebhookDeliveriesHandler())
+		owned.Get("/api/webhook-deliveries/{id}", a.GetWebhookDeliveryHandler())
+		owned.Post("/api/webhook-deliveries/{id}/redeliver", a.RedeliverWebhookHandler())
+	})
 }
 
 func (a *Api) Router() *chi.Mux {
 	return a.router
 }
+
+// Scheduler exposes the renewal scheduler so tests can run it on demand
+func (a *Api) Scheduler() *subscription.Scheduler {
+	return a.scheduler
+}
+
+// RunPaymentWorkers sends queued asynchronous payments to the bank until
+// ctx is cancelled. Run starts it; tests call it directly.
+func (a *Api) RunPaymentWorkers(ctx context.Context) error {
+	return a.paymentWorkers.Run(ctx, a.paymentService)
+}
+
+// Dispatcher exposes the webhook dispatcher so tests can run it on demand
+func (a *Api) Dispatcher() *webhook.Dispatcher {
+	return a.dispatcher
+}
+
+// Close closes the storage and, with the file backend, releases the storage
+// directory for another process. Call it once Run has returned.
+func (a *Api) Close() error {
+	return closeAll(a.storage)
+}
+
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		errs = append(errs, closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// lockStorage creates the storage directory of the file backend and takes
+// its lock, so a second gateway, or gatewayctl, cannot append to the same
+// journals. It returns nil with the memory backend.
+func lockStorage(cfg config.Storage) (*journal.Lock, error) {
+	if cfg.Backend != config.StorageFile {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	lock, err := journal.LockDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock storage directory: %w", err)
+	}
+	return lock, nil
+}
+
+// newWebhooksRepository keeps the webhook outbox in a journal file with the
+// file storage backend, so queued deliveries survive restarts
+func newWebhooksRepository(cfg config.Storage, e *envelope.Envelope) (*repository.WebhooksRepository, error) {
+	if cfg.Backend == config.StorageFile {
+		return repository.OpenWebhooksRepository(filepath.Join(cfg.Dir, repository.WebhooksJournalFile), e)
+	}
+
+	return repository.NewWebhooksRepository(e), nil
+}
+
+// newPaymentJobsRepository keeps queued asynchronous payments in a journal
+// file with the file storage backend, so they are sent after a restart
+func newPaymentJobsRepository(cfg config.Storage, e *envelope.Envelope) (*repository.PaymentJobsRepository, error) {
+	if cfg.Backend == config.StorageFile {
+		return repository.OpenPaymentJobsRepository(filepath.Join(cfg.Dir, repository.PaymentJobsJournalFile), e)
+	}
+
+	return repository.NewPaymentJobsRepository(e), nil
+}
+
+// newPaymentsRepository keeps payments and their sealed card numbers in a
+// journal file with the file storage backend
+func newPaymentsRepository(cfg config.Storage, e *envelope.Envelope) (*repository.PaymentsRepository, error) {
+	if cfg.Backend == config.StorageFile {
+		return repository.OpenPaymentsRepository(filepath.Join(cfg.Dir, repository.PaymentsJournalFile), e)
+	}
+
+	return repository.NewPaymentsRepository(e), nil
+}
+
+// newVault keeps tokenized cards in a journal file with the file storage
+// backend, so tokens handed to merchants keep working after a restart
+func newVault(cfg config.Storage, e *envelope.Envelope) (*vault.Vault, error) {
+	if cfg.Backend == config.StorageFile {
+		return vault.OpenVault(filepath.Join(cfg.Dir, vault.JournalFile), e)
+	}
+
+	return vault.NewVault(e), nil
+}
+
+// newBlocklist keeps blocked cards in a journal file with the file storage
+// backend, so a merchant's blocks still apply after a restart
+func newBlocklist(cfg config.Storage, e *envelope.Envelope, cardVault *vault.Vault, c clock.Clock) (*blocklist.Blocklist, error) {
+	opts := []blocklist.Option{blocklist.WithVault(cardVault), blocklist.WithClock(c)}
+	if cfg.Backend == config.StorageFile {
+		return blocklist.OpenBlocklist(filepath.Join(cfg.Dir, blocklist.JournalFile), e, opts...)
+	}
+
+	return blocklist.NewBlocklist(e, opts...), nil
+}
+
+// warnUnpersisted says which state is lost on restart with the configured
+// storage backend
+func warnUnpersisted(cfg config.Storage) {
+	if cfg.Backend == config.StorageFile {
+		slog.Warn("payment batches and idempotency keys are only kept in memory and are lost on restart")
+		return
+	}
+
+	slog.Warn("memory storage backend configured, payments, tokenized cards, blocked cards, webhook outbox, queued payments, subscriptions, payment batches and idempotency keys are lost on restart")
+}
+
+// newSubscriptionsRepository keeps plans and subscriptions in a journal file
+// with the file storage backend, so renewals carry on after a restart
+func newSubscriptionsRepository(cfg config.Storage) (*repository.SubscriptionsRepository, error) {
+	if cfg.Backend == config.StorageFile {
+		return repository.OpenSubscriptionsRepository(filepath.Join(cfg.Dir, repository.SubscriptionsJournalFile))
+	}
+
+	return repository.NewSubscriptionsRepository(), nil
+}
+
+// restoreQueuedPayments stores the payments still queued from the last run.
+// With the file backend payments are journaled too, so this only changes
+// anything for a payment queued just before the gateway stopped, whose
+// pending record was never written; saving a payment already stored is a
+// no-op. Jobs whose card details cannot be read are left for the workers to
+// drop.
+func restoreQueuedPayments(repo *repository.PaymentsRepository, jobs *repository.PaymentJobsRepository) {
+	for _, id := range jobs.IDs() {
+		payment, err := jobs.Get(id)
+		if err != nil || payment == nil {
+			continue
+		}
+		ctx := audit.WithActor(context.Background(), async.WorkerActor)
+		ctx = audit.WithRequestID(ctx, payment.RequestID)
+		if err := repo.Save(ctx, payment); err != nil {
+			slog.Warn("failed to restore queued payment", "payment_id", id, "error", err)
+		}
+	}
+}
+
+// newKeyProvider picks the master key source for card data encryption: the
+// key file, keys in the environment, or a per-process key when explicitly
+// allowed for local development
+func newKeyProvider(cfg config.MasterKeys) (envelope.KeyProvider, error) {
+	if cfg.File != "" {
+		return envelope.NewFileKeyProvider(cfg.File)
+	}
+
+	provider, err := envelope.NewEnvKeyProvider(masterKeyEnvPrefix)
+	if err == nil {
+		return provider, nil
+	}
+	if !errors.Is(err, envelope.ErrNoMasterKey) {
+		return nil, err
+	}
+
+	if !cfg.Ephemeral {
+		return nil, fmt.Errorf("%w: set master_keys.file or %s_V1, or master_keys.ephemeral for local development", err, masterKeyEnvPrefix)
+	}
+	slog.Warn("using an ephemeral master key, stored card data will be unreadable after a restart")
+	return envelope.NewEphemeralKeyProvider()
+}
@@ -25,6 +25,36 @@ func (a *Api) PingHandler() http.HandlerFunc {
 	}
 }
 
+// LiveHandler godoc
+// @Summary Liveness probe
+// @Description Reports that the process is up. No dependencies are checked, so a failing bank never gets the gateway restarted.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.HealthResponse "The gateway is alive"
+// @Router /health/live [get]
+func (a *Api) LiveHandler() http.HandlerFunc {
+	h := handlers.NewHealthHandler(a.health)
+	return h.LiveHandler()
+}
+
+// ReadyHandler godoc
+// @Summary Readiness probe
+// @Description Checks the repository, the bank (its circuit breaker and a lightweight probe) and the configuration, and reports each of them. Returns 503 when any is down, and while the gateway drains traffic before shutting down.
+// @Tags health
+// @Produce json
+// @Success 200 {object} models.HealthResponse "Ready for traffic"
+// @Failure 503 {object} models.HealthResponse "A dependency is down or the gateway is shutting down"
+// @Router /health/ready [get]
+func (a *Api) ReadyHandler() http.HandlerFunc {
+	h := handlers.NewHealthHandler(a.health)
+	return h.ReadyHandler()
+}
+
+// MetricsHandler returns an http.Handler that serves Prometheus metrics.
+func (a *Api) MetricsHandler() http.Handler {
+	return a.metrics.Handler()
+}
+
 // SwaggerHandler returns an http.HandlerFunc that handles HTTP Swagger related requests.
 func (a *Api) SwaggerHandler() http.HandlerFunc {
 	return httpSwagger.Handler(
@@ -34,20 +64,53 @@ func (a *Api) SwaggerHandler() http.HandlerFunc {
 
 // PostPaymentHandler godoc
 // @Summary Process a new payment
-// @Description Process a payment through the payment gateway and return the result
+// @Description Process a payment through the payment gateway and return the result. With Prefer: respond-async the payment is queued and returned as Pending straight away; poll its Location or subscribe to webhooks for the outcome.
 // @Tags payments
 // @Accept json
 // @Produce json
-// @Param payment body models.PostPaymentRequest true "Payment details"
+// @Param payment body models.PostPaymentRequest true "Payment details, either raw card details or source.token"
+// @Param X-Request-ID header string false "ID for this request; generated if missing or invalid"
+// @Param X-Correlation-ID header string false "Merchant's own ID, stored on the payment and sent to the bank"
+// @Param Prefer header string false "respond-async to queue the payment and return without waiting for the bank"
+// @Param Idempotency-Key header string false "Unique key for this payment; retries with the same key return the first response instead of paying again"
+// @Header all {string} X-Request-ID "ID of this request"
+// @Header 202 {string} Location "URL to poll for the outcome"
+// @Header 202 {string} Preference-Applied "respond-async"
+// @Header all {string} Idempotent-Replayed "true when the response is replayed for a repeated Idempotency-Key"
 // @Success 200 {object} models.PostPaymentResponse "Payment processed successfully (Authorized or Declined)"
-// @Failure 400 {object} models.ErrorResponse "Invalid request or validation error (Rejected)"
+// @Success 202 {object} models.PostPaymentResponse "Payment queued (Pending)"
+// @Failure 400 {object} models.ErrorResponse "Invalid request, unknown field, validation error or unknown token (Rejected)"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 413 {object} models.ErrorResponse "Request body too large"
+// @Failure 429 {object} models.ErrorResponse "Rate limit exceeded or too many payments in progress, see Retry-After"
+// @Failure 409 {object} models.ErrorResponse "A request with the same Idempotency-Key is still in progress, see Retry-After"
+// @Failure 422 {object} models.ErrorResponse "Idempotency-Key was already used for a different request"
 // @Failure 502 {object} models.ErrorResponse "Bank service unavailable or error"
+// @Failure 503 {object} models.ErrorResponse "Bank is too slow to take the payment right now, or the asynchronous queue is full, see Retry-After"
 // @Router /api/payments [post]
 func (a *Api) PostPaymentHandler() http.HandlerFunc {
 	h := handlers.NewPaymentsHandler(a.paymentService)
 	return h.PostHandler()
 }
 
+// ListPaymentsHandler godoc
+// @Summary List payments
+// @Description List stored payments, newest first, one page at a time. Pass next_starting_after from a page as starting_after to get the next one.
+// @Tags payments
+// @Produce json
+// @Param status query string false "Only list payments in this status" Enums(Authorized,Declined,Rejected,Pending,Captured,Voided,Refunded)
+// @Param starting_after query string false "ID of the last payment on the previous page"
+// @Param limit query int false "Payments per page, 1-100" default(20)
+// @Success 200 {object} models.PaymentListResponse "Page of payments"
+// @Failure 400 {object} models.ErrorResponse "Invalid status, limit or starting_after"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/payments [get]
+func (a *Api) ListPaymentsHandler() http.HandlerFunc {
+	h := handlers.NewPaymentsHandler(a.paymentService)
+	return h.ListHandler()
+}
+
 // GetPaymentHandler godoc
 // @Summary Retrieve a payment by ID
 // @Description Get details of a previously processed payment
@@ -56,6 +119,7 @@ func (a *Api) PostPaymentHandler() http.HandlerFunc {
 // @Produce json
 // @Param id path string true "Payment ID"
 // @Success 200 {object} models.GetPaymentResponse "Payment found"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
 // @Failure 404 {object} models.ErrorResponse "Payment not found"
 // @Failure 500 {object} models.ErrorResponse "Internal server error"
 // @Router /api/payments/{id} [get]
@@ -63,3 +127,466 @@ func (a *Api) GetPaymentHandler() http.HandlerFunc {
 	h := handlers.NewPaymentsHandler(a.paymentService)
 	return h.GetHandler()
 }
+
+// GetPaymentEventsHandler godoc
+// @Summary Retrieve a payment's history
+// @Description List every change made to a payment, oldest first, with who made it, when, and from which request. Events are append-only and the payment's current state is rebuilt from them.
+// @Tags payments
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Success 200 {array} models.PaymentEventResponse "Payment history"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Payment not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/payments/{id}/events [get]
+func (a *Api) GetPaymentEventsHandler() http.HandlerFunc {
+	h := handlers.NewPaymentsHandler(a.paymentService)
+	return h.GetEventsHandler()
+}
+
+// CapturePaymentHandler godoc
+// @Summary Capture an authorized payment
+// @Description Capture all or part of an Authorized payment with the bank. Without an amount the whole authorized amount is captured.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param request body models.SettlePaymentRequest false "Amount to capture, defaults to the authorized amount"
+// @Param Idempotency-Key header string false "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again"
+// @Success 200 {object} models.GetPaymentResponse "Payment after the bank agreed"
+// @Failure 400 {object} models.ErrorResponse "Invalid amount, or more than can be captured"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Payment not found"
+// @Failure 409 {object} models.ErrorResponse "The payment is not Authorized, or another settlement of it is in progress"
+// @Failure 429 {object} models.ErrorResponse "Rate limit exceeded or too many payments in progress, see Retry-After"
+// @Failure 501 {object} models.ErrorResponse "The configured bank does not support settlement"
+// @Failure 502 {object} models.ErrorResponse "Bank refused or failed the request"
+// @Failure 503 {object} models.ErrorResponse "Bank is too slow to take the request right now, see Retry-After"
+// @Router /api/payments/{id}/capture [post]
+func (a *Api) CapturePaymentHandler() http.HandlerFunc {
+	h := handlers.NewPaymentsHandler(a.paymentService)
+	return h.CaptureHandler()
+}
+
+// VoidPaymentHandler godoc
+// @Summary Void an authorized payment
+// @Description Release an Authorized payment that has not been captured, so the cardholder is never charged.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param Idempotency-Key header string false "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again"
+// @Success 200 {object} models.GetPaymentResponse "Payment after the bank agreed"
+// @Failure 400 {object} models.ErrorResponse "Invalid request body"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Payment not found"
+// @Failure 409 {object} models.ErrorResponse "The payment is not Authorized, or another settlement of it is in progress"
+// @Failure 429 {object} models.ErrorResponse "Rate limit exceeded or too many payments in progress, see Retry-After"
+// @Failure 501 {object} models.ErrorResponse "The configured bank does not support settlement"
+// @Failure 502 {object} models.ErrorResponse "Bank refused or failed the request"
+// @Failure 503 {object} models.ErrorResponse "Bank is too slow to take the request right now, see Retry-After"
+// @Router /api/payments/{id}/void [post]
+func (a *Api) VoidPaymentHandler() http.HandlerFunc {
+	h := handlers.NewPaymentsHandler(a.paymentService)
+	return h.VoidHandler()
+}
+
+// RefundPaymentHandler godoc
+// @Summary Refund a captured payment
+// @Description Refund all or part of a Captured payment with the bank. Without an amount whatever is left to refund is refunded; partial refunds can be repeated until the captured amount is used up.
+// @Tags payments
+// @Accept json
+// @Produce json
+// @Param id path string true "Payment ID"
+// @Param request body models.SettlePaymentRequest false "Amount to refund, defaults to what is left to refund"
+// @Param Idempotency-Key header string false "Unique key for this request; retries with the same key return the first response instead of sending it to the bank again"
+// @Success 200 {object} models.GetPaymentResponse "Payment after the bank agreed"
+// @Failure 400 {object} models.ErrorResponse "Invalid amount, or more than is left to refund"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Payment not found"
+// @Failure 409 {object} models.ErrorResponse "The payment is not Captured or is already fully refunded, or another settlement of it is in progress"
+// @Failure 429 {object} models.ErrorResponse "Rate limit exceeded or too many payments in progress, see Retry-After"
+// @Failure 501 {object} models.ErrorResponse "The configured bank does not support settlement"
+// @Failure 502 {object} models.ErrorResponse "Bank refused or failed the request"
+// @Failure 503 {object} models.ErrorResponse "Bank is too slow to take the request right now, see Retry-After"
+// @Router /api/payments/{id}/refund [post]
+func (a *Api) RefundPaymentHandler() http.HandlerFunc {
+	h := handlers.NewPaymentsHandler(a.paymentService)
+	return h.RefundHandler()
+}
+
+// PostPaymentBatchHandler godoc
+// @Summary Submit a batch of payments
+// @Description Upload many payment requests at once, as a JSON array, NDJSON (one request per line) or CSV with a header row. CSV columns are the payment request fields, with nested ones flattened to source_token, stored_credential_usage, stored_credential_type and previous_network_transaction_id. Each item is validated like a single payment; invalid items are recorded as failed and the rest are sent to the bank in the background.
+// @Tags payment-batches
+// @Accept json,application/x-ndjson,text/csv
+// @Produce json
+// @Param batch body []models.PostPaymentRequest true "Payment requests"
+// @Param Idempotency-Key header string false "Unique key for this batch; retries with the same key return the first response instead of submitting it again"
+// @Success 202 {object} models.PaymentBatchResponse "Batch accepted"
+// @Header 202 {string} Location "URL of the batch"
+// @Failure 400 {object} models.ErrorResponse "Empty or unreadable upload"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 413 {object} models.ErrorResponse "Upload too large or too many payments"
+// @Failure 415 {object} models.ErrorResponse "Unsupported Content-Type"
+// @Router /api/payment-batches [post]
+func (a *Api) PostPaymentBatchHandler() http.HandlerFunc {
+	h := handlers.NewBatchesHandler(a.batchService)
+	return h.PostHandler()
+}
+
+// GetPaymentBatchHandler godoc
+// @Summary Retrieve a payment batch
+// @Description Get a batch's status and how many of its payments have been authorized, declined, failed or canceled so far
+// @Tags payment-batches
+// @Produce json
+// @Param id path string true "Batch ID"
+// @Success 200 {object} models.PaymentBatchResponse "Batch found"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Batch not found"
+// @Router /api/payment-batches/{id} [get]
+func (a *Api) GetPaymentBatchHandler() http.HandlerFunc {
+	h := handlers.NewBatchesHandler(a.batchService)
+	return h.GetHandler()
+}
+
+// GetPaymentBatchResultsHandler godoc
+// @Summary Download a payment batch's results
+// @Description One result per uploaded item, in upload order, as NDJSON or CSV. Items not processed yet are listed as pending.
+// @Tags payment-batches
+// @Produce application/x-ndjson,text/csv
+// @Param id path string true "Batch ID"
+// @Param format query string false "Result format, defaults to ndjson or to the Accept header" Enums(ndjson, csv)
+// @Success 200 {array} models.PaymentBatchItemResponse "Per-item results"
+// @Failure 400 {object} models.ErrorResponse "Unknown format"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Batch not found"
+// @Router /api/payment-batches/{id}/results [get]
+func (a *Api) GetPaymentBatchResultsHandler() http.HandlerFunc {
+	h := handlers.NewBatchesHandler(a.batchService)
+	return h.ResultsHandler()
+}
+
+// CancelPaymentBatchHandler godoc
+// @Summary Cancel a payment batch
+// @Description Stop sending the batch's payments to the bank. Payments already sent keep their outcome; the rest are recorded as canceled.
+// @Tags payment-batches
+// @Produce json
+// @Param id path string true "Batch ID"
+// @Success 200 {object} models.PaymentBatchResponse "Batch canceled"
+// @Failure 401 {object} models.ErrorResponse "Unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Batch not found"
+// @Failure 409 {object} models.ErrorResponse "Batch has already finished"
+// @Router /api/payment-batches/{id}/cancel [post]
+func (a *Api) CancelPaymentBatchHandler() http.HandlerFunc {
+	h := handlers.NewBatchesHandler(a.batchService)
+	return h.CancelHandler()
+}
+
+// PostTokenHandler godoc
+// @Summary Store a card in the vault
+// @Description Encrypt the card number and expiry in the vault and return an opaque token that can be used as source.token when making payments. The CVV is never stored.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param card body models.PostTokenRequest true "Card details"
+// @Success 201 {object} models.PostTokenResponse "Card stored"
+// @Failure 400 {object} models.ErrorResponse "Invalid request or validation error"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/tokens [post]
+func (a *Api) PostTokenHandler() http.HandlerFunc {
+	h := handlers.NewTokensHandler(a.vault)
+	return h.PostHandler()
+}
+
+// DeleteTokenHandler godoc
+// @Summary Delete a stored card
+// @Description Crypto-shred the card behind a token. The encryption key is destroyed so the card number can never be recovered and the token can no longer be used.
+// @Tags tokens
+// @Param token path string true "Card token"
+// @Success 204 "Card shredded"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Token not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/tokens/{token} [delete]
+func (a *Api) DeleteTokenHandler() http.HandlerFunc {
+	h := handlers.NewTokensHandler(a.vault)
+	return h.DeleteHandler()
+}
+
+// PostPlanHandler godoc
+// @Summary Create a billing plan
+// @Description Create a plan that charges a fixed amount every interval
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param plan body models.PostPlanRequest true "Plan details"
+// @Success 201 {object} models.PlanResponse "Plan created"
+// @Failure 400 {object} models.ErrorResponse "Invalid request or validation error"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/plans [post]
+func (a *Api) PostPlanHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.PostPlanHandler()
+}
+
+// GetPlanHandler godoc
+// @Summary Retrieve a plan by ID
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Plan ID"
+// @Success 200 {object} models.PlanResponse "Plan found"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Plan not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/plans/{id} [get]
+func (a *Api) GetPlanHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.GetPlanHandler()
+}
+
+// PostSubscriptionHandler godoc
+// @Summary Subscribe a stored card to a plan
+// @Description Charge the first period while the customer is present and start the subscription. Later periods are charged by the scheduler as merchant-initiated recurring payments.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param subscription body models.PostSubscriptionRequest true "Plan and card token"
+// @Success 201 {object} models.SubscriptionResponse "Subscription started"
+// @Failure 400 {object} models.ErrorResponse "Invalid request, validation error or unknown token"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 402 {object} models.ErrorResponse "First payment was not authorized"
+// @Failure 404 {object} models.ErrorResponse "Plan not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/subscriptions [post]
+func (a *Api) PostSubscriptionHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.PostSubscriptionHandler()
+}
+
+// GetSubscriptionHandler godoc
+// @Summary Retrieve a subscription by ID
+// @Description Get a subscription with its billing period and every charge attempt
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} models.SubscriptionResponse "Subscription found"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Subscription not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/subscriptions/{id} [get]
+func (a *Api) GetSubscriptionHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.GetSubscriptionHandler()
+}
+
+// PauseSubscriptionHandler godoc
+// @Summary Pause a subscription
+// @Description Stop billing until the subscription is resumed
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} models.SubscriptionResponse "Subscription paused"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Subscription not found"
+// @Failure 409 {object} models.ErrorResponse "Subscription cannot be paused"
+// @Router /api/subscriptions/{id}/pause [post]
+func (a *Api) PauseSubscriptionHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.PauseHandler()
+}
+
+// ResumeSubscriptionHandler godoc
+// @Summary Resume a paused subscription
+// @Description Restart billing. A renewal that fell due while paused is charged on the next scheduler run.
+// @Tags subscriptions
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} models.SubscriptionResponse "Subscription resumed"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Subscription not found"
+// @Failure 409 {object} models.ErrorResponse "Subscription is not paused"
+// @Router /api/subscriptions/{id}/resume [post]
+func (a *Api) ResumeSubscriptionHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.ResumeHandler()
+}
+
+// CancelSubscriptionHandler godoc
+// @Summary Cancel a subscription
+// @Description Cancel now, or at the end of the paid period when at_period_end is set
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Param cancel body models.CancelSubscriptionRequest false "Cancellation options"
+// @Success 200 {object} models.SubscriptionResponse "Subscription canceled"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Subscription not found"
+// @Failure 409 {object} models.ErrorResponse "Subscription is already canceled"
+// @Router /api/subscriptions/{id}/cancel [post]
+func (a *Api) CancelSubscriptionHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.CancelHandler()
+}
+
+// ChangePlanHandler godoc
+// @Summary Move a subscription to another plan
+// @Description Switch plans immediately. The unused part of the current period is prorated: an upgrade is charged now and a downgrade is credited against the next renewals.
+// @Tags subscriptions
+// @Accept json
+// @Produce json
+// @Param id path string true "Subscription ID"
+// @Param plan body models.ChangePlanRequest true "New plan"
+// @Success 200 {object} models.SubscriptionResponse "Plan changed"
+// @Failure 400 {object} models.ErrorResponse "Invalid request or plan in a different currency"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 402 {object} models.ErrorResponse "Proration payment was not authorized"
+// @Failure 404 {object} models.ErrorResponse "Subscription or plan not found"
+// @Failure 409 {object} models.ErrorResponse "Subscription is not active"
+// @Router /api/subscriptions/{id}/change-plan [post]
+func (a *Api) ChangePlanHandler() http.HandlerFunc {
+	h := handlers.NewSubscriptionsHandler(a.subscriptionService)
+	return h.ChangePlanHandler()
+}
+
+// PostBlockedCardHandler godoc
+// @Summary Block a card
+// @Description Refuse further payments from a card, given by its number or a vault token. Payments from a blocked card are Rejected with a 400. Blocking a card that is already blocked returns the existing entry.
+// @Tags blocked-cards
+// @Accept json
+// @Produce json
+// @Param card body models.PostBlockedCardRequest true "Card number or source.token, and why it is blocked"
+// @Success 201 {object} models.BlockedCardResponse "Card blocked"
+// @Failure 400 {object} models.ErrorResponse "Invalid request, validation error or unknown token"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/blocked-cards [post]
+func (a *Api) PostBlockedCardHandler() http.HandlerFunc {
+	h := handlers.NewBlocklistHandler(a.blocklist)
+	return h.PostHandler()
+}
+
+// ListBlockedCardsHandler godoc
+// @Summary List blocked cards
+// @Description List the cards the merchant has blocked, newest first
+// @Tags blocked-cards
+// @Produce json
+// @Success 200 {array} models.BlockedCardResponse "Blocked cards"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/blocked-cards [get]
+func (a *Api) ListBlockedCardsHandler() http.HandlerFunc {
+	h := handlers.NewBlocklistHandler(a.blocklist)
+	return h.ListHandler()
+}
+
+// DeleteBlockedCardHandler godoc
+// @Summary Unblock a card
+// @Description Take payments from the card again. The blocklist's copy of the card number is crypto-shredded.
+// @Tags blocked-cards
+// @Param id path string true "Blocked card ID"
+// @Success 204 "Card unblocked"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Blocked card not found"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/blocked-cards/{id} [delete]
+func (a *Api) DeleteBlockedCardHandler() http.HandlerFunc {
+	h := handlers.NewBlocklistHandler(a.blocklist)
+	return h.DeleteHandler()
+}
+
+// PostWebhookEndpointHandler godoc
+// @Summary Register a webhook endpoint
+// @Description Register a URL to receive payment events. Every delivery is signed with HMAC-SHA256 in the Webhook-Signature header as "t=<unix seconds>,v1=<hex>", computed over "<t>.<raw body>" with the endpoint secret. The secret is only returned in this response.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param endpoint body models.PostWebhookEndpointRequest true "Endpoint URL and event types"
+// @Success 201 {object} models.WebhookEndpointResponse "Endpoint registered"
+// @Failure 400 {object} models.ErrorResponse "Invalid URL or event type"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 500 {object} models.ErrorResponse "Internal server error"
+// @Router /api/webhook-endpoints [post]
+func (a *Api) PostWebhookEndpointHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.PostEndpointHandler()
+}
+
+// GetWebhookEndpointHandler godoc
+// @Summary Retrieve a webhook endpoint
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Success 200 {object} models.WebhookEndpointResponse "Endpoint found"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Endpoint not found"
+// @Router /api/webhook-endpoints/{id} [get]
+func (a *Api) GetWebhookEndpointHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.GetEndpointHandler()
+}
+
+// DeleteWebhookEndpointHandler godoc
+// @Summary Delete a webhook endpoint
+// @Description Stop sending events to the endpoint. Deliveries still queued for it are dead-lettered.
+// @Tags webhooks
+// @Param id path string true "Endpoint ID"
+// @Success 204 "Endpoint deleted"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Endpoint not found"
+// @Router /api/webhook-endpoints/{id} [delete]
+func (a *Api) DeleteWebhookEndpointHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.DeleteEndpointHandler()
+}
+
+// ListWebhookDeliveriesHandler godoc
+// @Summary List an endpoint's deliveries
+// @Description List deliveries with their attempt log, newest first. Filter on status=dead to see the dead-letter queue.
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Endpoint ID"
+// @Param status query string false "Delivery status" Enums(pending, succeeded, dead)
+// @Success 200 {array} models.WebhookDeliveryResponse "Deliveries"
+// @Failure 400 {object} models.ErrorResponse "Unknown status"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Endpoint not found"
+// @Router /api/webhook-endpoints/{id}/deliveries [get]
+func (a *Api) ListWebhookDeliveriesHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.ListDeliveriesHandler()
+}
+
+// GetWebhookDeliveryHandler godoc
+// @Summary Retrieve a webhook delivery
+// @Description Get a delivery with every attempt made to send it
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Delivery ID"
+// @Success 200 {object} models.WebhookDeliveryResponse "Delivery found"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Delivery not found"
+// @Router /api/webhook-deliveries/{id} [get]
+func (a *Api) GetWebhookDeliveryHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.GetDeliveryHandler()
+}
+
+// RedeliverWebhookHandler godoc
+// @Summary Redeliver a webhook
+// @Description Queue a dead or already delivered event to be sent again straight away with a fresh set of retries
+// @Tags webhooks
+// @Produce json
+// @Param id path string true "Delivery ID"
+// @Success 202 {object} models.WebhookDeliveryResponse "Delivery queued"
+// @Failure 401 {object} models.ErrorResponse "Missing or unknown API key"
+// @Failure 404 {object} models.ErrorResponse "Delivery or endpoint not found"
+// @Failure 409 {object} models.ErrorResponse "Delivery is already queued"
+// @Router /api/webhook-deliveries/{id}/redeliver [post]
+func (a *Api) RedeliverWebhookHandler() http.HandlerFunc {
+	h := handlers.NewWebhooksHandler(a.webhookService)
+	return h.RedeliverHandler()
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/grpcapi"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/ratelimit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewaypb"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// gRPC metadata keys are lower case, but otherwise match the REST API's
+// headers
+var (
+	requestIDKey     = strings.ToLower(audit.RequestIDHeader)
+	correlationIDKey = strings.ToLower(audit.CorrelationIDHeader)
+)
+
+// setupGRPC builds the gRPC server for the payments API, with the standard
+// health and reflection services alongside it
+func (a *Api) setupGRPC() {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+		grpc.ChainStreamInterceptor(a.streamInterceptor),
+	}
+	if a.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(a.tlsConfig)))
+	}
+
+	a.grpcServer = grpc.NewServer(opts...)
+	gatewaypb.RegisterPaymentsServer(a.grpcServer, grpcapi.NewPaymentsServer(a.paymentService))
+
+	a.grpcHealth = grpchealth.NewServer()
+	a.grpcHealth.SetServingStatus(gatewaypb.Payments_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(a.grpcServer, a.grpcHealth)
+
+	reflection.Register(a.grpcServer)
+}
+
+// GRPCServer exposes the gRPC server so tests can serve it on a listener of
+// their own
+func (a *Api) GRPCServer() *grpc.Server {
+	return a.grpcServer
+}
+
+// serveGRPC listens on the configured gRPC address until stopGRPC is called
+func (a *Api) serveGRPC(ctx context.Context) error {
+	lis, err := net.Listen("tcp", a.grpcAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+
+	slog.InfoContext(ctx, "starting gRPC server", "addr", a.grpcAddr, "tls", a.tlsConfig != nil)
+	// Stopped before it started, when the gateway shuts down straight away
+	if err := a.grpcServer.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// stopGRPC waits for calls in progress to finish, and cancels any still
+// running once ctx is done, such as payments being watched
+func (a *Api) stopGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		a.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		a.grpcServer.Stop()
+		<-stopped
+	}
+}
+
+func (a *Api) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = recovered(ctx, rec)
+		}
+		logCall(ctx, info.FullMethod, start, err)
+	}()
+
+	callCtx, err := a.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	// Logged with the caller's request ID
+	ctx = callCtx
+	return handler(ctx, req)
+}
+
+func (a *Api) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx := ss.Context()
+	start := time.Now()
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = recovered(ctx, rec)
+		}
+		logCall(ctx, info.FullMethod, start, err)
+	}()
+
+	callCtx, err := a.grpcContext(ctx, info.FullMethod)
+	if err != nil {
+		return err
+	}
+	ctx = callCtx
+	return handler(srv, contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcContext does for a call what the REST API's middleware does for a
+// request: it takes the caller's request and correlation IDs, rate limits
+// and authenticates it, and puts it on the context for the audit log.
+// Health checks and reflection are left alone, like the REST API's probes.
+func (a *Api) grpcContext(ctx context.Context, method string) (context.Context, error) {
+	if !strings.HasPrefix(method, "/"+gatewaypb.Payments_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	requestID := first(requestIDKey)
+	if !validID(requestID) {
+		requestID = uuid.New().String()
+	}
+	ctx = audit.WithRequestID(ctx, requestID)
+	header := metadata.Pairs(requestIDKey, requestID)
+	if correlationID := first(correlationIDKey); validID(correlationID) {
+		ctx = audit.WithCorrelationID(ctx, correlationID)
+		header.Append(correlationIDKey, correlationID)
+	}
+	// Sent ahead of any error, so failed calls carry the IDs too
+	if err := grpc.SetHeader(ctx, header); err != nil {
+		slog.WarnContext(ctx, "failed to set gRPC response headers", "error", err)
+	}
+
+	ip, tlsState := peerInfo(ctx)
+	authorization := first("authorization")
+
+	decision := ratelimit.Check(ctx, a.rateLimiter, a.rateLimitRulesFor(authorization, ip))
+	if decision != nil && !decision.Allowed {
+		delay := time.Duration(ratelimit.RetryAfterSeconds(*decision)) * time.Second
+		return nil, grpcapi.RetryableError(codes.ResourceExhausted, "Rate limit exceeded, retry later", delay)
+	}
+
+	if authorization != "" {
+		apiKey, _ := bearerToken(authorization)
+		m, ok := a.merchants.Authenticate(apiKey)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "Invalid API key")
+		}
+		ctx = merchant.WithMerchant(ctx, m)
+	}
+
+	return audit.WithActor(ctx, caller(ctx, ip, tlsState)), nil
+}
+
+// peerInfo is the caller's IP address and TLS connection, if any
+func peerInfo(ctx context.Context) (string, *tls.ConnectionState) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", nil
+	}
+
+	ip, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		ip = p.Addr.String()
+	}
+
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		return ip, &info.State
+	}
+	return ip, nil
+}
+
+// contextStream replaces a stream's context with one carrying the caller
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// recovered logs a panic in a call, like logging.Recoverer does for
+// requests, and fails the call with Internal
+func recovered(ctx context.Context, rec any) error {
+	slog.ErrorContext(ctx, "panic while serving request",
+		"panic", fmt.Sprint(rec),
+		"stack", string(debug.Stack()),
+	)
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// logCall logs one line per call, at the level logging.Middleware would
+// use for the matching HTTP status
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+
+	level := slog.LevelWarn
+	switch code {
+	case codes.OK:
+		level = slog.LevelInfo
+	case codes.Internal, codes.Unknown, codes.Unavailable, codes.DataLoss, codes.Unimplemented, codes.DeadlineExceeded:
+		level = slog.LevelError
+	}
+
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	slog.Default().LogAttrs(ctx, level, "call served",
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("duration", time.Since(start)),
+		slog.String("remote_addr", remoteAddr),
+	)
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/logging"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// idPattern limits the request and correlation IDs callers may send, so they
+// are safe to echo in headers, logs and the bank request
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,128}$`)
+
+// validID also turns away IDs that look like card data, which would
+// otherwise be stored on the payment and sent to the bank
+func validID(id string) bool {
+	return idPattern.MatchString(id) && logging.Redact(id) == id
+}
+
+// requestIDs accepts the caller's X-Request-ID, or makes one up if it is
+// missing or unusable, and the merchant's X-Correlation-ID. Both are echoed
+// on the response before the handler runs, so even errors carry them.
+func requestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(audit.RequestIDHeader)
+		if !validID(requestID) {
+			requestID = uuid.New().String()
+		}
+		w.Header().Set(audit.RequestIDHeader, requestID)
+		ctx := audit.WithRequestID(r.Context(), requestID)
+
+		if correlationID := r.Header.Get(audit.CorrelationIDHeader); validID(correlationID) {
+			w.Header().Set(audit.CorrelationIDHeader, correlationID)
+			ctx = audit.WithCorrelationID(ctx, correlationID)
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// auditContext puts the caller on the request context so the changes a
+// request makes are attributed to it in the audit log. Merchants that
+// authenticated with an API key are known by their ID, callers with a
+// verified client certificate by its common name, and others by their
+// address.
+func auditContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := audit.WithActor(r.Context(), caller(r.Context(), remoteIP(r), r.TLS))
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// caller is the actor a request is attributed to, given the address it
+// came from and its TLS connection, if any
+func caller(ctx context.Context, ip string, tlsState *tls.ConnectionState) audit.Actor {
+	id := ip
+	if m, ok := merchant.FromContext(ctx); ok {
+		id = "merchant:" + m.ID
+	} else if tlsState != nil && len(tlsState.VerifiedChains) > 0 {
+		id = "cert:" + tlsState.VerifiedChains[0][0].Subject.CommonName
+	}
+	return audit.Actor{Type: audit.ActorAPIClient, ID: id}
+}
+
+// idempotencyScope keeps each caller's idempotency keys apart, so one
+// caller's key never replays another's response
+func idempotencyScope(r *http.Request) string {
+	return audit.ActorFrom(r.Context()).String()
+}
+
+// limitBody stops reading request bodies after maxBytes, so a single
+// request cannot exhaust the gateway's memory. Upload paths get the limit
+// uploads gives them instead.
+func limitBody(maxBytes int64, uploads map[string]int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := maxBytes
+			if upload, ok := uploads[r.URL.Path]; ok {
+				limit = upload
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// bearerToken returns the API key in an Authorization header, if any
+func bearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authenticate identifies the merchant from the API key in an
+// "Authorization: Bearer" header. The header is optional, but a key the
+// gateway does not know is turned away rather than treated as anonymous.
+func authenticate(registry *merchant.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, _ := bearerToken(r.Header.Get("Authorization"))
+			m, ok := registry.Authenticate(apiKey)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="payment-gateway"`)
+				writeError(w, http.StatusUnauthorized, "Invalid API key")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(merchant.WithMerchant(r.Context(), m)))
+		})
+	}
+}
+
+// requireMerchant turns away requests authenticate did not identify a
+// merchant for, on routes whose resources belong to one
+func requireMerchant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := merchant.FromContext(r.Context()); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="payment-gateway"`)
+			writeError(w, http.StatusUnauthorized, "API key required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitRules counts a request with a known API key against that key's
+// bucket, and any other request against its IP address's. It runs before
+// authenticate, so guessing API keys is limited too.
+func (a *Api) rateLimitRules(r *http.Request) []ratelimit.Rule {
+	return a.rateLimitRulesFor(r.Header.Get("Authorization"), remoteIP(r))
+}
+
+// rateLimitRulesFor picks the bucket for a request with the given
+// Authorization header from the given IP address
+func (a *Api) rateLimitRulesFor(authorization, ip string) []ratelimit.Rule {
+	if apiKey, ok := bearerToken(authorization); ok {
+		if m, ok := a.merchants.Authenticate(apiKey); ok {
+			return []ratelimit.Rule{{
+				Key:   "api_key:" + merchant.HashAPIKey(apiKey),
+				Limit: ratelimit.Limit{Rate: m.RequestsPerSecond, Burst: m.Burst},
+			}}
+		}
+	}
+
+	perIP := a.rateLimits.Load().PerIP
+	return []ratelimit.Rule{{
+		Key:   "ip:" + ip,
+		Limit: ratelimit.Limit{Rate: perIP.RequestsPerSecond, Burst: perIP.Burst},
+	}}
+}
+
+// tooManyRequests answers a request over its rate limit. The ratelimit
+// middleware has already set Retry-After.
+func tooManyRequests(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusTooManyRequests, "Rate limit exceeded, retry later")
+}
+
+// writeError answers in the same shape as the handlers' errors, for
+// requests turned away before reaching one
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:     message,
+		RequestID: w.Header().Get(audit.RequestIDHeader),
+	})
+}
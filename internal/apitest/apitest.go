@@ -0,0 +1,34 @@
+// Package apitest builds an api.Api for tests, configured so nothing needs
+// setting up around it: an ephemeral master key, webhooks delivered to
+// local addresses and a single merchant let in with APIKey.
+package apitest
+
+import (
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+)
+
+// APIKey is the API key of the merchant NewWithBankURL configures
+const APIKey = "gw_test_key"
+
+// New builds an Api with the default configuration
+func New(opts ...api.Option) *api.Api {
+	return NewWithBankURL(config.Default().Bank.URL, opts...)
+}
+
+// NewWithBankURL builds an Api with the default configuration, talking to
+// the bank at bankURL
+func NewWithBankURL(bankURL string, opts ...api.Option) *api.Api {
+	cfg := config.Default()
+	cfg.Bank.URL = bankURL
+	cfg.MasterKeys.Ephemeral = true
+	cfg.Webhooks.AllowPrivateNetworks = true
+	cfg.Merchants = []config.Merchant{{ID: "merchant-test", APIKeySHA256: []string{merchant.HashAPIKey(APIKey)}}}
+
+	a, err := api.NewFromConfig(cfg, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
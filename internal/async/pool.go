@@ -0,0 +1,316 @@
+// Package async sends payments accepted with Prefer: respond-async to the
+// bank from a durable queue.
+package async
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+// WorkerActor is who payments sent by the workers are recorded as made by
+var WorkerActor = audit.Actor{Type: audit.ActorSystem, ID: "payment-worker"}
+
+// Jobs stores queued payments, card details included, until they have an
+// outcome
+type Jobs interface {
+	Add(payment *domain.Payment) error
+	Get(id string) (*domain.Payment, error)
+	Remove(id string) error
+	// Park sets a payment aside, card details included, until an operator
+	// has found its outcome with the bank. It is left out of IDs.
+	Park(id string) error
+	// IDs returns every queued payment, oldest first
+	IDs() []string
+}
+
+// Processor sends a queued payment to the bank and stores the outcome
+type Processor interface {
+	// ProcessQueuedPayment returns an error if the bank gave no outcome.
+	// Payments turned away before reaching the bank are tried again later.
+	ProcessQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	// RejectQueuedPayment gives up on a payment after its last attempt
+	RejectQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	// ResolveQueuedPayment stores the outcome an operator gave a parked
+	// payment, without sending it to the bank
+	ResolveQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+}
+
+// Pool is a fixed number of workers taking payments from a bounded queue.
+// Every queued payment is in Jobs, so jobs left over from a previous run
+// are picked up again when the pool is built.
+type Pool struct {
+	jobs        Jobs
+	workers     int
+	depth       int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	mu       sync.Mutex
+	queued   int
+	attempts map[string]int
+	ready    chan string
+}
+
+// Option configures a Pool
+type Option func(*Pool)
+
+// WithWorkers sets how many payments are sent to the bank at once
+func WithWorkers(n int) Option {
+	return func(p *Pool) {
+		p.workers = n
+	}
+}
+
+// WithQueueDepth sets how many payments may be queued, including the ones
+// being sent, before new ones are turned away
+func WithQueueDepth(n int) Option {
+	return func(p *Pool) {
+		p.depth = n
+	}
+}
+
+// WithMaxAttempts sets how many times a payment is sent before it is
+// rejected
+func WithMaxAttempts(n int) Option {
+	return func(p *Pool) {
+		p.maxAttempts = n
+	}
+}
+
+// WithBackoff spaces out attempts at a payment, starting at initial and
+// doubling up to maxDelay
+func WithBackoff(initial, maxDelay time.Duration) Option {
+	return func(p *Pool) {
+		p.backoff = initial
+		p.maxBackoff = maxDelay
+	}
+}
+
+func NewPool(jobs Jobs, opts ...Option) *Pool {
+	p := &Pool{
+		jobs:        jobs,
+		workers:     4,
+		depth:       1000,
+		maxAttempts: 5,
+		backoff:     time.Second,
+		maxBackoff:  time.Minute,
+		attempts:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	// Jobs from a previous run go first. There may be more of them than the
+	// queue now allows, so the channel is sized to hold them all.
+	pending := jobs.IDs()
+	p.ready = make(chan string, max(p.depth, len(pending)))
+	for _, id := range pending {
+		p.ready <- id
+	}
+	p.queued = len(pending)
+	if p.queued > 0 {
+		slog.Info("resuming queued payments", "count", p.queued)
+	}
+
+	return p
+}
+
+// Enqueue stores a payment for a worker to send. It returns
+// domain.ErrPaymentQueueFull if the queue is at its depth.
+//
+// store, if not nil, is called once the job is durable but before any
+// worker can see it, so the payment is recorded as pending before its
+// outcome is. If store fails the job is dropped and never sent.
+func (p *Pool) Enqueue(payment *domain.Payment, store func() error) error {
+	p.mu.Lock()
+	if p.queued >= p.depth {
+		p.mu.Unlock()
+		return domain.ErrPaymentQueueFull
+	}
+	// The slot is taken now so storing does not hold the lock
+	p.queued++
+	p.mu.Unlock()
+
+	if err := p.add(payment, store); err != nil {
+		p.mu.Lock()
+		p.queued--
+		p.mu.Unlock()
+		return err
+	}
+
+	// Never blocks: the channel holds at least as many IDs as are queued
+	p.ready <- payment.ID
+	return nil
+}
+
+// add journals a job and stores its payment, dropping the job again if the
+// payment cannot be stored
+func (p *Pool) add(payment *domain.Payment, store func() error) error {
+	if err := p.jobs.Add(payment); err != nil {
+		return err
+	}
+	if store == nil {
+		return nil
+	}
+
+	if err := store(); err != nil {
+		if removeErr := p.jobs.Remove(payment.ID); removeErr != nil {
+			// It is sent after a restart, which stores it again
+			slog.Error("failed to drop queued payment that could not be stored", "payment_id", payment.ID, "error", removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Len returns the number of payments queued or being sent
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
+
+// Run sends queued payments with processor until ctx is cancelled. Payments
+// already with the bank are allowed to finish; the rest stay queued for the
+// next run.
+func (p *Pool) Run(ctx context.Context, processor Processor) error {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-p.ready:
+					p.process(context.WithoutCancel(ctx), processor, id)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// process makes one attempt at a payment. Payments made by workers are
+// recorded as made by them, under the request that queued them.
+func (p *Pool) process(ctx context.Context, processor Processor, id string) {
+	payment, err := p.jobs.Get(id)
+	if err != nil {
+		// The card details cannot be read, e.g. because the master key
+		// changed, so no attempt will ever succeed
+		slog.ErrorContext(ctx, "dropping unreadable queued payment", "payment_id", id, "error", err)
+		p.finish(ctx, id)
+		return
+	}
+	if payment == nil {
+		p.finish(ctx, id)
+		return
+	}
+
+	ctx = audit.WithActor(ctx, WorkerActor)
+	ctx = audit.WithRequestID(ctx, payment.RequestID)
+	ctx = audit.WithCorrelationID(ctx, payment.CorrelationID)
+
+	// A parked payment an operator has resolved already has its outcome
+	switch payment.Status {
+	case domain.StatusAuthorized, domain.StatusDeclined, domain.StatusRejected:
+		if _, err := processor.ResolveQueuedPayment(ctx, payment); err != nil {
+			slog.ErrorContext(ctx, "failed to resolve queued payment", "payment_id", id, "status", payment.Status, "error", err)
+			time.AfterFunc(p.maxBackoff, func() { p.ready <- id })
+			return
+		}
+		p.finish(ctx, id)
+		return
+	}
+
+	p.mu.Lock()
+	p.attempts[id]++
+	attempts := p.attempts[id]
+	p.mu.Unlock()
+
+	if _, err := processor.ProcessQueuedPayment(ctx, payment); err != nil {
+		if client.OutcomeUnknown(err) {
+			// The bank may have authorized it, so it is neither sent again
+			// nor rejected. It stays pending until reconciled with the bank.
+			slog.ErrorContext(ctx, "queued payment outcome unknown, parking it for reconciliation",
+				"payment_id", id,
+				"attempt", attempts,
+				"error", err,
+			)
+			p.park(ctx, id)
+			return
+		}
+
+		if client.NotSent(err) && attempts < p.maxAttempts {
+			delay := p.delay(attempts)
+			slog.WarnContext(ctx, "queued payment failed, will retry",
+				"payment_id", id,
+				"attempt", attempts,
+				"retry_in", delay,
+				"error", err,
+			)
+			time.AfterFunc(delay, func() { p.ready <- id })
+			return
+		}
+
+		slog.ErrorContext(ctx, "queued payment failed, rejecting", "payment_id", id, "attempts", attempts, "error", err)
+		if _, err := processor.RejectQueuedPayment(ctx, payment); err != nil {
+			slog.ErrorContext(ctx, "failed to reject queued payment", "payment_id", id, "error", err)
+			time.AfterFunc(p.maxBackoff, func() { p.ready <- id })
+			return
+		}
+	}
+
+	p.finish(ctx, id)
+}
+
+// finish removes a payment that has an outcome from the queue
+func (p *Pool) finish(ctx context.Context, id string) {
+	if err := p.jobs.Remove(id); err != nil {
+		// It stays in the journal, so it is tried again after a restart
+		slog.ErrorContext(ctx, "failed to remove queued payment", "payment_id", id, "error", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.attempts, id)
+	p.queued--
+}
+
+// park takes a payment with an unknown outcome off the queue but keeps its
+// job. Until the job is parked it would be sent again after a restart, so
+// parking is retried rather than the payment.
+func (p *Pool) park(ctx context.Context, id string) {
+	if err := p.jobs.Park(id); err != nil {
+		slog.ErrorContext(ctx, "failed to park queued payment", "payment_id", id, "error", err)
+		time.AfterFunc(p.maxBackoff, func() { p.park(ctx, id) })
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.attempts, id)
+	p.queued--
+}
+
+// delay returns the wait after the given number of failed attempts
+func (p *Pool) delay(attempts int) time.Duration {
+	delay := p.backoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= p.maxBackoff {
+			return p.maxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,352 @@
+package async
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryJobs keeps jobs in insertion order
+type memoryJobs struct {
+	mu     sync.Mutex
+	jobs   map[string]domain.Payment
+	ids    []string
+	parked map[string]bool
+}
+
+func newMemoryJobs(ids ...string) *memoryJobs {
+	j := &memoryJobs{jobs: make(map[string]domain.Payment), parked: make(map[string]bool)}
+	for _, id := range ids {
+		j.Add(&domain.Payment{ID: id, Status: domain.StatusPending, RequestID: "req-" + id})
+	}
+	return j
+}
+
+func (j *memoryJobs) Add(payment *domain.Payment) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs[payment.ID] = *payment
+	j.ids = append(j.ids, payment.ID)
+	return nil
+}
+
+func (j *memoryJobs) Get(id string) (*domain.Payment, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p, ok := j.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (j *memoryJobs) Remove(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.jobs, id)
+	for i, queued := range j.ids {
+		if queued == id {
+			j.ids = append(j.ids[:i], j.ids[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (j *memoryJobs) Park(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.jobs[id]; !ok {
+		return domain.ErrPaymentNotFound
+	}
+	j.parked[id] = true
+	return nil
+}
+
+// resolve queues a parked job again with the given outcome, as an operator
+// would
+func (j *memoryJobs) resolve(id string, status domain.PaymentStatus) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.jobs[id]
+	p.Status = status
+	j.jobs[id] = p
+	delete(j.parked, id)
+}
+
+func (j *memoryJobs) isParked(id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.parked[id]
+}
+
+func (j *memoryJobs) IDs() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var ids []string
+	for _, id := range j.ids {
+		if !j.parked[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// scriptedProcessor fails each payment the given number of times before
+// authorizing it. Failures are errs[id], or domain.ErrBankOverloaded.
+type scriptedProcessor struct {
+	mu        sync.Mutex
+	failures  map[string]int
+	errs      map[string]error
+	attempts  map[string]int
+	outcomes  map[string]domain.PaymentStatus
+	actors    []audit.Actor
+	requestID map[string]string
+	block     chan struct{}
+}
+
+func newScriptedProcessor() *scriptedProcessor {
+	return &scriptedProcessor{
+		failures:  make(map[string]int),
+		errs:      make(map[string]error),
+		attempts:  make(map[string]int),
+		outcomes:  make(map[string]domain.PaymentStatus),
+		requestID: make(map[string]string),
+	}
+}
+
+func (p *scriptedProcessor) ProcessQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	if p.block != nil {
+		<-p.block
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts[payment.ID]++
+	p.actors = append(p.actors, audit.ActorFrom(ctx))
+	p.requestID[payment.ID] = audit.RequestID(ctx)
+	if p.attempts[payment.ID] <= p.failures[payment.ID] {
+		if err := p.errs[payment.ID]; err != nil {
+			return nil, err
+		}
+		return nil, domain.ErrBankOverloaded
+	}
+	payment.SetAuthorized()
+	p.outcomes[payment.ID] = payment.Status
+	return payment, nil
+}
+
+func (p *scriptedProcessor) RejectQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	payment.SetRejected()
+	p.outcomes[payment.ID] = payment.Status
+	return payment, nil
+}
+
+func (p *scriptedProcessor) ResolveQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.outcomes[payment.ID] = payment.Status
+	return payment, nil
+}
+
+func (p *scriptedProcessor) outcome(id string) domain.PaymentStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.outcomes[id]
+}
+
+func (p *scriptedProcessor) attemptsAt(id string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts[id]
+}
+
+// runPool runs the pool until the test ends
+func runPool(t *testing.T, pool *Pool, processor Processor) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx, processor)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestPool_ResumesQueuedJobs(t *testing.T) {
+	jobs := newMemoryJobs("payment-1", "payment-2")
+	processor := newScriptedProcessor()
+
+	pool := NewPool(jobs)
+	assert.Equal(t, 2, pool.Len())
+	runPool(t, pool, processor)
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.StatusAuthorized, processor.outcome("payment-1"))
+	assert.Equal(t, domain.StatusAuthorized, processor.outcome("payment-2"))
+	assert.Empty(t, jobs.IDs(), "finished jobs are removed")
+
+	assert.Equal(t, WorkerActor, processor.actors[0])
+	assert.Equal(t, "req-payment-1", processor.requestID["payment-1"], "recorded under the request that queued it")
+}
+
+func TestPool_QueueDepth(t *testing.T) {
+	processor := newScriptedProcessor()
+	processor.block = make(chan struct{})
+	pool := NewPool(newMemoryJobs(), WithWorkers(1), WithQueueDepth(2))
+	runPool(t, pool, processor)
+
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "payment-1"}, nil))
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "payment-2"}, nil))
+	assert.ErrorIs(t, pool.Enqueue(&domain.Payment{ID: "payment-3"}, nil), domain.ErrPaymentQueueFull,
+		"the payment being sent still counts")
+
+	close(processor.block)
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, pool.Enqueue(&domain.Payment{ID: "payment-3"}, nil))
+}
+
+func TestPool_DropsPaymentsThatCannotBeStored(t *testing.T) {
+	jobs := newMemoryJobs()
+	processor := newScriptedProcessor()
+	pool := NewPool(jobs, WithQueueDepth(1))
+	runPool(t, pool, processor)
+
+	err := pool.Enqueue(&domain.Payment{ID: "payment-1"}, func() error { return errors.New("disk full") })
+	assert.ErrorContains(t, err, "disk full")
+	assert.Equal(t, 0, pool.Len(), "the slot is given back")
+	assert.Empty(t, jobs.IDs())
+
+	stored := false
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "payment-2"}, func() error {
+		// No worker has the payment yet
+		time.Sleep(20 * time.Millisecond)
+		stored = processor.attemptsAt("payment-2") == 0
+		return nil
+	}))
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.True(t, stored, "stored before it was sent")
+	assert.Equal(t, 0, processor.attemptsAt("payment-1"))
+}
+
+func TestPool_RetriesThenRejects(t *testing.T) {
+	processor := newScriptedProcessor()
+	processor.failures["flaky"] = 2
+	processor.failures["down"] = 10
+
+	pool := NewPool(newMemoryJobs(), WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	runPool(t, pool, processor)
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "flaky"}, nil))
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "down"}, nil))
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.StatusAuthorized, processor.outcome("flaky"))
+	assert.Equal(t, 3, processor.attemptsAt("flaky"))
+	assert.Equal(t, domain.StatusRejected, processor.outcome("down"))
+	assert.Equal(t, 3, processor.attemptsAt("down"))
+}
+
+func TestPool_RetriesWhatNeverReachedTheBank(t *testing.T) {
+	processor := newScriptedProcessor()
+	notSent := map[string]error{
+		"circuit-open": client.ErrCircuitOpen,
+		"unavailable":  client.ErrBankUnavailable,
+		"dial":         &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+	}
+
+	pool := NewPool(newMemoryJobs(), WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	runPool(t, pool, processor)
+	for id, err := range notSent {
+		processor.failures[id] = 2
+		processor.errs[id] = fmt.Errorf("failed to process payment with bank: %w", err)
+		require.NoError(t, pool.Enqueue(&domain.Payment{ID: id}, nil))
+	}
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	for id := range notSent {
+		assert.Equal(t, domain.StatusAuthorized, processor.outcome(id), id)
+		assert.Equal(t, 3, processor.attemptsAt(id), id)
+	}
+}
+
+func TestPool_RejectsWithoutRetrying(t *testing.T) {
+	processor := newScriptedProcessor()
+	processor.failures["refused"] = 10
+	processor.errs["refused"] = errors.New("bank answered with a status the gateway does not know")
+
+	pool := NewPool(newMemoryJobs(), WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	runPool(t, pool, processor)
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "refused"}, nil))
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.StatusRejected, processor.outcome("refused"))
+	assert.Equal(t, 1, processor.attemptsAt("refused"), "only payments that never reached the bank are retried")
+}
+
+func TestPool_ParksUnknownOutcome(t *testing.T) {
+	jobs := newMemoryJobs()
+	processor := newScriptedProcessor()
+	processor.failures["timed-out"] = 10
+	processor.errs["timed-out"] = fmt.Errorf("failed to process payment with bank: %w", context.DeadlineExceeded)
+
+	pool := NewPool(jobs, WithMaxAttempts(3), WithBackoff(time.Millisecond, 5*time.Millisecond))
+	runPool(t, pool, processor)
+	require.NoError(t, pool.Enqueue(&domain.Payment{ID: "timed-out"}, nil))
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, processor.attemptsAt("timed-out"), "sending it again could charge the card twice")
+	assert.Empty(t, processor.outcome("timed-out"), "the bank may have authorized it, so it is not rejected")
+	assert.True(t, jobs.isParked("timed-out"), "the job is kept until the outcome is known")
+	assert.Empty(t, jobs.IDs())
+
+	// After a restart it is still not sent
+	restarted := NewPool(jobs)
+	assert.Equal(t, 0, restarted.Len())
+}
+
+func TestPool_ResolvesParkedPayments(t *testing.T) {
+	jobs := newMemoryJobs("authorized", "resent")
+	require.NoError(t, jobs.Park("authorized"))
+	require.NoError(t, jobs.Park("resent"))
+
+	// Reconciled with the bank while the gateway was down: one was
+	// authorized, the other never arrived
+	jobs.resolve("authorized", domain.StatusAuthorized)
+	jobs.resolve("resent", domain.StatusPending)
+
+	processor := newScriptedProcessor()
+	pool := NewPool(jobs)
+	assert.Equal(t, 2, pool.Len())
+	runPool(t, pool, processor)
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, domain.StatusAuthorized, processor.outcome("authorized"))
+	assert.Equal(t, 0, processor.attemptsAt("authorized"), "a resolved payment is not sent to the bank")
+	assert.Equal(t, domain.StatusAuthorized, processor.outcome("resent"))
+	assert.Equal(t, 1, processor.attemptsAt("resent"))
+	assert.Empty(t, jobs.IDs(), "resolved jobs are removed")
+}
+
+func TestPool_Delay(t *testing.T) {
+	pool := NewPool(newMemoryJobs(), WithBackoff(time.Second, 5*time.Second))
+
+	assert.Equal(t, time.Second, pool.delay(1))
+	assert.Equal(t, 2*time.Second, pool.delay(2))
+	assert.Equal(t, 4*time.Second, pool.delay(3))
+	assert.Equal(t, 5*time.Second, pool.delay(4))
+}
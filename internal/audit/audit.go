@@ -0,0 +1,79 @@
+// Package audit carries who made a change, and from which request, through
+// a context so stores can record it alongside the change
+package audit
+
+import (
+	"context"
+)
+
+const (
+	// RequestIDHeader carries the ID of a single request. It is accepted
+	// from callers, returned on every response and forwarded to the bank.
+	RequestIDHeader = "X-Request-ID"
+	// CorrelationIDHeader carries an ID the merchant chooses to tie several
+	// requests together
+	CorrelationIDHeader = "X-Correlation-ID"
+)
+
+type ActorType string
+
+const (
+	// ActorAPIClient is a caller of the HTTP API
+	ActorAPIClient ActorType = "api_client"
+	// ActorSystem is the gateway itself, e.g. the subscription scheduler
+	ActorSystem ActorType = "system"
+)
+
+// Actor is who made a change
+type Actor struct {
+	Type ActorType
+	ID   string
+}
+
+func (a Actor) String() string {
+	return string(a.Type) + ":" + a.ID
+}
+
+// Unknown is recorded when a change is made without an actor in context
+var Unknown = Actor{Type: ActorSystem, ID: "unknown"}
+
+type contextKey int
+
+const (
+	actorKey contextKey = iota
+	requestIDKey
+	correlationIDKey
+)
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFrom returns the actor in ctx, or Unknown
+func ActorFrom(ctx context.Context) Actor {
+	if actor, ok := ctx.Value(actorKey).(Actor); ok {
+		return actor
+	}
+	return Unknown
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID in ctx, or "" outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationID returns the merchant's correlation ID in ctx, or "" if the
+// merchant did not send one
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
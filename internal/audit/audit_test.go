@@ -0,0 +1,24 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+
+	assert.Equal(t, Unknown, ActorFrom(ctx))
+	assert.Empty(t, RequestID(ctx))
+	assert.Empty(t, CorrelationID(ctx))
+
+	ctx = WithActor(ctx, Actor{Type: ActorAPIClient, ID: "203.0.113.7"})
+	ctx = WithRequestID(ctx, "req-1")
+	ctx = WithCorrelationID(ctx, "order-42")
+
+	assert.Equal(t, "api_client:203.0.113.7", ActorFrom(ctx).String())
+	assert.Equal(t, "req-1", RequestID(ctx))
+	assert.Equal(t, "order-42", CorrelationID(ctx))
+}
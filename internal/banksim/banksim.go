@@ -0,0 +1,342 @@
+// Package banksim is an in-process stand-in for the acquiring bank. It
+// follows the same rules as the mountebank imposter in imposters/: a card
+// number ending in an odd digit is authorized, an even digit is declined,
+// 0 makes the bank unavailable and a request missing a field is rejected.
+// On top of that it can capture, void and refund authorizations, and play
+// out scenarios of latency, errors and outages to rehearse incidents, which
+// can be switched while it runs through a control API under /_simulator.
+package banksim
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// AuthorizationRequest is the body of POST /payments, as sent by the
+// gateway's bank client
+type AuthorizationRequest struct {
+	CardNumber string `json:"card_number"`
+	ExpiryDate string `json:"expiry_date"`
+	Currency   string `json:"currency"`
+	Amount     int    `json:"amount"`
+	CVV        string `json:"cvv"`
+	Initiator  string `json:"initiator,omitempty"`
+}
+
+type AuthorizationResponse struct {
+	Authorized        bool   `json:"authorized"`
+	AuthorizationCode string `json:"authorization_code"`
+	// NetworkTransactionID is the card scheme's reference for an authorized
+	// payment, which later merchant-initiated payments on the card quote
+	NetworkTransactionID string `json:"network_transaction_id,omitempty"`
+}
+
+// AmountRequest is the body of a capture or refund. Without an amount the
+// whole of what remains is captured or refunded.
+type AmountRequest struct {
+	Amount int `json:"amount,omitempty"`
+}
+
+// Status is where an authorization is in its lifecycle
+type Status string
+
+const (
+	StatusAuthorized Status = "authorized"
+	StatusCaptured   Status = "captured"
+	StatusVoided     Status = "voided"
+	StatusRefunded   Status = "refunded"
+)
+
+// Authorization is the bank's record of an authorized payment, returned by
+// the capture, void and refund endpoints
+type Authorization struct {
+	AuthorizationCode string `json:"authorization_code"`
+	Status            Status `json:"status"`
+	Currency          string `json:"currency"`
+	Amount            int    `json:"amount"`
+	CapturedAmount    int    `json:"captured_amount"`
+	RefundedAmount    int    `json:"refunded_amount"`
+}
+
+// ErrorResponse is what the simulator answers with when it turns a request
+// away, in the imposter's format
+type ErrorResponse struct {
+	ErrorMessage string `json:"error_message"`
+}
+
+const (
+	missingFieldsMessage = "Not all required properties were sent in the request"
+	unsupportedMessage   = "The request supplied is not supported by the simulator"
+)
+
+// Simulator is an http.Handler playing the acquiring bank
+type Simulator struct {
+	router *chi.Mux
+	clock  clock.Clock
+
+	randMu sync.Mutex
+	rand   *rand.Rand
+
+	scenarioMu sync.RWMutex
+	scenarios  map[string]Scenario
+	active     string
+	activated  time.Time
+
+	mu             sync.Mutex
+	authorizations map[string]*Authorization
+}
+
+// Option configures a Simulator
+type Option func(*Simulator)
+
+// WithLatency delays every answer in the default scenario by a random
+// duration between min and max
+func WithLatency(min, max time.Duration) Option {
+	return func(s *Simulator) {
+		s.updateDefault(func(sc *Scenario) {
+			sc.Latency = Latency{Distribution: Uniform, Min: Duration(min), Max: Duration(max)}
+		})
+	}
+}
+
+// WithErrorRate answers the given fraction of requests in the default
+// scenario, between 0 and 1, with status instead of processing them
+func WithErrorRate(rate float64, status int) Option {
+	return func(s *Simulator) {
+		s.updateDefault(func(sc *Scenario) {
+			sc.Errors = Errors{Rate: rate, Statuses: []int{status}}
+		})
+	}
+}
+
+// WithSeed makes the latency and faults the same on every run
+func WithSeed(seed int64) Option {
+	return func(s *Simulator) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithClock sets the clock outage windows are timed with
+func WithClock(c clock.Clock) Option {
+	return func(s *Simulator) {
+		s.clock = c
+	}
+}
+
+func New(opts ...Option) *Simulator {
+	s := &Simulator{
+		clock:          clock.Real{},
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		scenarios:      map[string]Scenario{DefaultScenario: {Name: DefaultScenario}},
+		active:         DefaultScenario,
+		authorizations: make(map[string]*Authorization),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.activated = s.clock.Now()
+
+	s.router = chi.NewRouter()
+	s.router.Group(func(r chi.Router) {
+		r.Use(s.inject)
+		r.Post("/payments", s.authorize)
+		r.Post("/payments/{code}/capture", s.capture)
+		r.Post("/payments/{code}/void", s.void)
+		r.Post("/payments/{code}/refund", s.refund)
+	})
+	s.router.Route(controlPrefix, s.controlRoutes)
+	s.router.NotFound(unsupported)
+	s.router.MethodNotAllowed(unsupported)
+
+	return s
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Authorization returns the bank's record of an authorization
+func (s *Simulator) Authorization(code string) (Authorization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth, ok := s.authorizations[code]
+	if !ok {
+		return Authorization{}, false
+	}
+	return *auth, true
+}
+
+func (s *Simulator) authorize(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: missingFieldsMessage})
+		return
+	}
+
+	// CVVs are not sent for merchant-initiated payments on a stored card
+	required := []string{"card_number", "expiry_date", "currency", "amount"}
+	if fields["initiator"] != "merchant" {
+		required = append(required, "cvv")
+	}
+	for _, field := range required {
+		if !present(fields[field]) {
+			respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: missingFieldsMessage})
+			return
+		}
+	}
+
+	var req AuthorizationRequest
+	raw, _ := json.Marshal(fields)
+	if err := json.Unmarshal(raw, &req); err != nil {
+		respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: missingFieldsMessage})
+		return
+	}
+
+	switch last := req.CardNumber[len(req.CardNumber)-1]; {
+	case last == '0':
+		respond(w, http.StatusServiceUnavailable, struct{}{})
+	case strings.IndexByte("13579", last) >= 0:
+		code := uuid.New().String()
+		s.mu.Lock()
+		s.authorizations[code] = &Authorization{
+			AuthorizationCode: code,
+			Status:            StatusAuthorized,
+			Currency:          req.Currency,
+			Amount:            req.Amount,
+		}
+		s.mu.Unlock()
+		respond(w, http.StatusOK, AuthorizationResponse{
+			Authorized:           true,
+			AuthorizationCode:    code,
+			NetworkTransactionID: s.networkTransactionID(),
+		})
+	case strings.IndexByte("2468", last) >= 0:
+		respond(w, http.StatusOK, AuthorizationResponse{Authorized: false})
+	default:
+		// The imposter has no rule for anything else, so its default
+		// response applies
+		unsupported(w, r)
+	}
+}
+
+// networkTransactionID makes up a scheme reference: 15 digits, like a Visa
+// transaction identifier
+func (s *Simulator) networkTransactionID() string {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+
+	digits := make([]byte, 15)
+	for i := range digits {
+		digits[i] = byte('0' + s.rand.Intn(10))
+	}
+	return string(digits)
+}
+
+// present mirrors the imposter's exists predicate: a field is missing if it
+// is absent, null or an empty string
+func present(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case string:
+		return v != ""
+	default:
+		return true
+	}
+}
+
+func (s *Simulator) capture(w http.ResponseWriter, r *http.Request) {
+	s.update(w, r, func(auth *Authorization, amount int) (string, bool) {
+		if auth.Status != StatusAuthorized {
+			return "only an authorized payment can be captured", false
+		}
+		if amount == 0 {
+			amount = auth.Amount
+		}
+		if amount > auth.Amount {
+			return "cannot capture more than was authorized", false
+		}
+		auth.CapturedAmount = amount
+		auth.Status = StatusCaptured
+		return "", true
+	})
+}
+
+func (s *Simulator) void(w http.ResponseWriter, r *http.Request) {
+	s.update(w, r, func(auth *Authorization, _ int) (string, bool) {
+		if auth.Status != StatusAuthorized {
+			return "only an authorized payment can be voided", false
+		}
+		auth.Status = StatusVoided
+		return "", true
+	})
+}
+
+func (s *Simulator) refund(w http.ResponseWriter, r *http.Request) {
+	s.update(w, r, func(auth *Authorization, amount int) (string, bool) {
+		if auth.Status != StatusCaptured && auth.Status != StatusRefunded {
+			return "only a captured payment can be refunded", false
+		}
+		remaining := auth.CapturedAmount - auth.RefundedAmount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount > remaining {
+			return "cannot refund more than was captured", false
+		}
+		auth.RefundedAmount += amount
+		if auth.RefundedAmount == auth.CapturedAmount {
+			auth.Status = StatusRefunded
+		}
+		return "", true
+	})
+}
+
+// update applies change to the authorization named in the path, answering
+// 404 if there is none, 400 for a bad amount and 409 if change refuses
+func (s *Simulator) update(w http.ResponseWriter, r *http.Request, change func(auth *Authorization, amount int) (string, bool)) {
+	var req AmountRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount < 0 {
+			respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: "amount must be a positive integer"})
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth, ok := s.authorizations[chi.URLParam(r, "code")]
+	if !ok {
+		respond(w, http.StatusNotFound, ErrorResponse{ErrorMessage: "authorization not found"})
+		return
+	}
+
+	if message, ok := change(auth, req.Amount); !ok {
+		respond(w, http.StatusConflict, ErrorResponse{ErrorMessage: message})
+		return
+	}
+
+	respond(w, http.StatusOK, auth)
+}
+
+func unsupported(w http.ResponseWriter, _ *http.Request) {
+	// The imposter's default response uses a different key to its rules
+	respond(w, http.StatusBadRequest, map[string]string{"errorMessage": unsupportedMessage})
+}
+
+func respond(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,187 @@
+package banksim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func post(t *testing.T, handler http.Handler, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w
+}
+
+func authorize(t *testing.T, sim *Simulator, amount int) string {
+	t.Helper()
+	w := post(t, sim, "/payments", fmt.Sprintf(
+		`{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":%d,"cvv":"123"}`, amount))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp AuthorizationResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.True(t, resp.Authorized)
+	require.Len(t, resp.NetworkTransactionID, 15)
+	return resp.AuthorizationCode
+}
+
+func TestSimulator_AuthorizationRules(t *testing.T) {
+	sim := New()
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "odd last digit is authorized",
+			body:           `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"authorized":true`,
+		},
+		{
+			name:           "even last digit is declined",
+			body:           `{"card_number":"2222405343248112","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"authorized":false,"authorization_code":""}`,
+		},
+		{
+			name:           "zero makes the bank unavailable",
+			body:           `{"card_number":"2222405343248110","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{}`,
+		},
+		{
+			name:           "missing field",
+			body:           `{"card_number":"2222405343248877","expiry_date":"04/2030","amount":100,"cvv":"123"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   missingFieldsMessage,
+		},
+		{
+			name:           "empty field",
+			body:           `{"card_number":"2222405343248877","expiry_date":"","currency":"GBP","amount":100,"cvv":"123"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   missingFieldsMessage,
+		},
+		{
+			name:           "missing CVV",
+			body:           `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   missingFieldsMessage,
+		},
+		{
+			name:           "merchant-initiated payments need no CVV",
+			body:           `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100,"initiator":"merchant"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"authorized":true`,
+		},
+		{
+			name:           "not JSON",
+			body:           `card_number=2222405343248877`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   missingFieldsMessage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(t, sim, "/payments", tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestSimulator_UnsupportedRequests(t *testing.T) {
+	sim := New()
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/payments", nil),
+		httptest.NewRequest(http.MethodPost, "/elsewhere", nil),
+		httptest.NewRequest(http.MethodHead, "/", nil),
+	} {
+		w := httptest.NewRecorder()
+		sim.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusBadRequest, w.Code, r.Method+" "+r.URL.Path)
+	}
+}
+
+func TestSimulator_CaptureAndRefund(t *testing.T) {
+	sim := New()
+	code := authorize(t, sim, 1000)
+
+	// Refunds need a capture first
+	w := post(t, sim, "/payments/"+code+"/refund", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = post(t, sim, "/payments/"+code+"/capture", `{"amount":2000}`)
+	assert.Equal(t, http.StatusConflict, w.Code, "cannot capture more than was authorized")
+
+	w = post(t, sim, "/payments/"+code+"/capture", `{"amount":800}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var auth Authorization
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&auth))
+	assert.Equal(t, Authorization{AuthorizationCode: code, Status: StatusCaptured, Currency: "GBP", Amount: 1000, CapturedAmount: 800}, auth)
+
+	// Partial refunds add up to the captured amount
+	assert.Equal(t, http.StatusOK, post(t, sim, "/payments/"+code+"/refund", `{"amount":300}`).Code)
+	assert.Equal(t, http.StatusConflict, post(t, sim, "/payments/"+code+"/refund", `{"amount":600}`).Code)
+	assert.Equal(t, http.StatusOK, post(t, sim, "/payments/"+code+"/refund", "").Code)
+
+	auth, ok := sim.Authorization(code)
+	require.True(t, ok)
+	assert.Equal(t, StatusRefunded, auth.Status)
+	assert.Equal(t, 800, auth.RefundedAmount)
+
+	assert.Equal(t, http.StatusConflict, post(t, sim, "/payments/"+code+"/refund", `{"amount":1}`).Code)
+	assert.Equal(t, http.StatusConflict, post(t, sim, "/payments/"+code+"/void", "").Code)
+}
+
+func TestSimulator_Void(t *testing.T) {
+	sim := New()
+	code := authorize(t, sim, 1000)
+
+	assert.Equal(t, http.StatusOK, post(t, sim, "/payments/"+code+"/void", "").Code)
+	assert.Equal(t, http.StatusConflict, post(t, sim, "/payments/"+code+"/void", "").Code)
+	assert.Equal(t, http.StatusConflict, post(t, sim, "/payments/"+code+"/capture", "").Code)
+
+	assert.Equal(t, http.StatusNotFound, post(t, sim, "/payments/unknown/void", "").Code)
+	assert.Equal(t, http.StatusBadRequest, post(t, sim, "/payments/"+code+"/capture", `{"amount":-1}`).Code)
+}
+
+func TestSimulator_Latency(t *testing.T) {
+	sim := New(WithLatency(20*time.Millisecond, 40*time.Millisecond), WithSeed(1))
+
+	start := time.Now()
+	authorize(t, sim, 100)
+	took := time.Since(start)
+
+	assert.GreaterOrEqual(t, took, 20*time.Millisecond)
+	assert.Less(t, took, time.Second)
+}
+
+func TestSimulator_ErrorInjection(t *testing.T) {
+	sim := New(WithErrorRate(0.5, http.StatusBadGateway), WithSeed(1))
+
+	counts := make(map[int]int)
+	for i := 0; i < 200; i++ {
+		w := post(t, sim, "/payments", `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`)
+		counts[w.Code]++
+	}
+
+	assert.Equal(t, 200, counts[http.StatusOK]+counts[http.StatusBadGateway])
+	assert.InDelta(t, 100, counts[http.StatusBadGateway], 30)
+
+	always := New(WithErrorRate(1, http.StatusServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, post(t, always, "/payments", `{}`).Code)
+}
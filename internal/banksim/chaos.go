@@ -0,0 +1,211 @@
+package banksim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// fault is what the roll for a request decided to do to it
+type fault int
+
+const (
+	noFault fault = iota
+	errorFault
+	resetFault
+	malformedFault
+	slowDripFault
+)
+
+// inject makes the bank behave as the active scenario says, ahead of every
+// request
+func (s *Simulator) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc, elapsed := s.current()
+
+		for _, o := range sc.Outages {
+			if o.active(elapsed) {
+				slog.DebugContext(r.Context(), "bank outage", "scenario", sc.Name, "mode", o.Mode, "path", r.URL.Path)
+				outage(w, r, o)
+				return
+			}
+		}
+
+		delay, f, status := s.roll(sc)
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if f == noFault {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		slog.DebugContext(r.Context(), "injecting bank fault", "scenario", sc.Name, "fault", f.String(), "path", r.URL.Path)
+		if f == errorFault {
+			respond(w, status, struct{}{})
+			return
+		}
+
+		// The other faults mangle the real answer, so it is worked out first
+		rec := &recorder{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		switch f {
+		case resetFault:
+			resetMidResponse(w, rec)
+		case malformedFault:
+			rec.body.Truncate(rec.body.Len() / 2)
+			rec.writeTo(w)
+		case slowDripFault:
+			slowDrip(w, r, rec, sc.SlowDrip)
+		}
+	})
+}
+
+func (f fault) String() string {
+	return [...]string{"none", "error", "reset", "malformed", "slow_drip"}[f]
+}
+
+// roll draws the latency and fault for one request
+func (s *Simulator) roll(sc Scenario) (time.Duration, fault, int) {
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+
+	delay := sc.Latency.delay(s.rand)
+
+	// One roll across all the faults keeps them mutually exclusive
+	p := s.rand.Float64()
+	for _, c := range []struct {
+		rate  float64
+		fault fault
+	}{
+		{sc.Errors.Rate, errorFault},
+		{sc.Resets.Rate, resetFault},
+		{sc.Malformed.Rate, malformedFault},
+		{sc.SlowDrip.Rate, slowDripFault},
+	} {
+		if p < c.rate {
+			status := http.StatusInternalServerError
+			if n := len(sc.Errors.Statuses); n > 0 {
+				status = sc.Errors.Statuses[s.rand.Intn(n)]
+			}
+			return delay, c.fault, status
+		}
+		p -= c.rate
+	}
+	return delay, noFault, 0
+}
+
+func outage(w http.ResponseWriter, r *http.Request, o Outage) {
+	switch o.Mode {
+	case Reset:
+		reset(hijack(w))
+	case Hang:
+		// The server only notices the caller hanging up once the body has
+		// been read
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	default:
+		status := o.Status
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		respond(w, status, struct{}{})
+	}
+}
+
+// resetMidResponse promises the whole response but sends only the first
+// half of its body before resetting the connection
+func resetMidResponse(w http.ResponseWriter, rec *recorder) {
+	conn := hijack(w)
+	rec.header.Set("Content-Length", strconv.Itoa(rec.body.Len()))
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n", rec.status, http.StatusText(rec.status))
+	rec.header.Write(conn)
+	fmt.Fprint(conn, "\r\n")
+	conn.Write(rec.body.Bytes()[:rec.body.Len()/2])
+
+	reset(conn)
+}
+
+// slowDrip sends the body a few bytes at a time, giving up if the caller
+// does
+func slowDrip(w http.ResponseWriter, r *http.Request, rec *recorder, drip SlowDrip) {
+	chunk := drip.ChunkBytes
+	if chunk <= 0 {
+		chunk = 1
+	}
+
+	for k, v := range rec.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(rec.body.Len()))
+	w.WriteHeader(rec.status)
+
+	rc := http.NewResponseController(w)
+	body := rec.body.Bytes()
+	for len(body) > 0 {
+		n := min(chunk, len(body))
+		if _, err := w.Write(body[:n]); err != nil {
+			return
+		}
+		rc.Flush()
+		body = body[n:]
+
+		if len(body) > 0 {
+			select {
+			case <-time.After(time.Duration(drip.Interval)):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// hijack takes over the connection, or aborts the response where that is
+// not possible, which also leaves the caller without a complete answer
+func hijack(w http.ResponseWriter) net.Conn {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	return conn
+}
+
+// reset closes the connection with a TCP reset rather than an orderly
+// shutdown
+func reset(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// recorder keeps a response so a fault can send it differently
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return r.header }
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+func (r *recorder) WriteHeader(status int)      { r.status = status }
+
+func (r *recorder) writeTo(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(r.body.Len()))
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
@@ -0,0 +1,95 @@
+package banksim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const payment = `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`
+
+// serveScenario serves a simulator playing sc, over a real connection so
+// resets can be seen
+func serveScenario(t *testing.T, sc Scenario) string {
+	t.Helper()
+	sim := New()
+	require.NoError(t, sim.Define(sc))
+	require.NoError(t, sim.Activate(sc.Name))
+
+	server := httptest.NewServer(sim)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestSimulator_Malformed(t *testing.T) {
+	sim := New()
+	require.NoError(t, sim.Define(Scenario{Name: "malformed", Malformed: Fault{Rate: 1}}))
+	require.NoError(t, sim.Activate("malformed"))
+
+	w := post(t, sim, "/payments", payment)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, w.Header().Get("Content-Length"), "61")
+	assert.True(t, strings.HasPrefix(w.Body.String(), `{"authorized":true,`))
+	assert.False(t, json.Valid(w.Body.Bytes()))
+}
+
+func TestSimulator_SlowDrip(t *testing.T) {
+	url := serveScenario(t, Scenario{Name: "drip", SlowDrip: SlowDrip{Rate: 1, Interval: Duration(5 * time.Millisecond), ChunkBytes: 8}})
+
+	start := time.Now()
+	resp, err := http.Post(url+"/payments", "application/json", strings.NewReader(payment))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	headersAfter := time.Since(start)
+
+	var auth AuthorizationResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&auth))
+	assert.True(t, auth.Authorized)
+
+	// About 80 bytes in chunks of 8 is 9 waits after the headers
+	assert.GreaterOrEqual(t, time.Since(start)-headersAfter, 40*time.Millisecond)
+}
+
+func TestSimulator_ResetMidResponse(t *testing.T) {
+	url := serveScenario(t, Scenario{Name: "resets", Resets: Fault{Rate: 1}})
+
+	resp, err := http.Post(url+"/payments", "application/json", strings.NewReader(payment))
+	if err == nil {
+		// The headers made it, so the body is where it breaks
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		_, err = io.ReadAll(resp.Body)
+	}
+	assert.Error(t, err)
+}
+
+func TestSimulator_OutageModes(t *testing.T) {
+	t.Run("unavailable", func(t *testing.T) {
+		url := serveScenario(t, Scenario{Name: "down", Outages: []Outage{{}}})
+		resp, err := http.Post(url+"/payments", "application/json", strings.NewReader(payment))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		url := serveScenario(t, Scenario{Name: "down", Outages: []Outage{{Mode: Reset}}})
+		_, err := http.Post(url+"/payments", "application/json", strings.NewReader(payment))
+		assert.Error(t, err)
+	})
+
+	t.Run("hang", func(t *testing.T) {
+		url := serveScenario(t, Scenario{Name: "down", Outages: []Outage{{Mode: Hang}}})
+		c := &http.Client{Timeout: 50 * time.Millisecond}
+		_, err := c.Post(url+"/payments", "application/json", strings.NewReader(payment))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Timeout")
+	})
+}
@@ -0,0 +1,138 @@
+package banksim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// controlPrefix is where the control API lives. The gateway never calls
+// anything under it, so faults are not injected there.
+const controlPrefix = "/_simulator"
+
+// ErrUnknownScenario is returned when activating a scenario that was never
+// defined
+var ErrUnknownScenario = errors.New("unknown scenario")
+
+// ScenarioList is the answer to GET /_simulator/scenarios
+type ScenarioList struct {
+	Active      string     `json:"active"`
+	ActivatedAt time.Time  `json:"activated_at"`
+	Scenarios   []Scenario `json:"scenarios"`
+}
+
+// ActivateRequest is the body of PUT /_simulator/active
+type ActivateRequest struct {
+	Name string `json:"name"`
+}
+
+// Define adds a scenario, or replaces the one with the same name. Replacing
+// the active scenario takes effect at once, without restarting its outage
+// windows.
+func (s *Simulator) Define(sc Scenario) error {
+	if err := sc.Validate(); err != nil {
+		return err
+	}
+
+	s.scenarioMu.Lock()
+	defer s.scenarioMu.Unlock()
+	s.scenarios[sc.Name] = sc
+	return nil
+}
+
+// Activate switches to the named scenario. Its outage windows are timed
+// from now.
+func (s *Simulator) Activate(name string) error {
+	s.scenarioMu.Lock()
+	defer s.scenarioMu.Unlock()
+
+	if _, ok := s.scenarios[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownScenario, name)
+	}
+	s.active = name
+	s.activated = s.clock.Now()
+	return nil
+}
+
+// Scenarios lists the defined scenarios by name, and which is active
+func (s *Simulator) Scenarios() ScenarioList {
+	s.scenarioMu.RLock()
+	defer s.scenarioMu.RUnlock()
+
+	list := ScenarioList{Active: s.active, ActivatedAt: s.activated, Scenarios: make([]Scenario, 0, len(s.scenarios))}
+	for _, sc := range s.scenarios {
+		list.Scenarios = append(list.Scenarios, sc)
+	}
+	sort.Slice(list.Scenarios, func(i, j int) bool { return list.Scenarios[i].Name < list.Scenarios[j].Name })
+	return list
+}
+
+// current returns the active scenario and how long it has been active
+func (s *Simulator) current() (Scenario, time.Duration) {
+	s.scenarioMu.RLock()
+	defer s.scenarioMu.RUnlock()
+	return s.scenarios[s.active], s.clock.Now().Sub(s.activated)
+}
+
+func (s *Simulator) updateDefault(change func(sc *Scenario)) {
+	sc := s.scenarios[DefaultScenario]
+	change(&sc)
+	s.scenarios[DefaultScenario] = sc
+}
+
+// controlRoutes serves the control API:
+//
+//	GET  /_simulator/scenarios         lists the scenarios and which is active
+//	PUT  /_simulator/scenarios/{name}  defines or replaces a scenario
+//	PUT  /_simulator/active            activates a scenario by name
+//	POST /_simulator/reset             goes back to the default scenario
+func (s *Simulator) controlRoutes(r chi.Router) {
+	r.Get("/scenarios", func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, s.Scenarios())
+	})
+
+	r.Put("/scenarios/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var sc Scenario
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sc); err != nil {
+			respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: "invalid scenario: " + err.Error()})
+			return
+		}
+		sc.Name = chi.URLParam(r, "name")
+
+		if err := s.Define(sc); err != nil {
+			respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: err.Error()})
+			return
+		}
+		respond(w, http.StatusOK, sc)
+	})
+
+	r.Put("/active", func(w http.ResponseWriter, r *http.Request) {
+		var req ActivateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, ErrorResponse{ErrorMessage: "invalid request: " + err.Error()})
+			return
+		}
+		s.activate(w, req.Name)
+	})
+
+	r.Post("/reset", func(w http.ResponseWriter, r *http.Request) {
+		s.activate(w, DefaultScenario)
+	})
+}
+
+func (s *Simulator) activate(w http.ResponseWriter, name string) {
+	if err := s.Activate(name); err != nil {
+		respond(w, http.StatusNotFound, ErrorResponse{ErrorMessage: err.Error()})
+		return
+	}
+
+	sc, _ := s.current()
+	respond(w, http.StatusOK, sc)
+}
@@ -0,0 +1,112 @@
+package banksim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func control(t *testing.T, sim *Simulator, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	sim.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestSimulator_ControlAPI(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	sim := New(WithClock(fakeClock))
+	payment := `{"card_number":"2222405343248877","expiry_date":"04/2030","currency":"GBP","amount":100,"cvv":"123"}`
+
+	w := control(t, sim, http.MethodPut, "/_simulator/scenarios/down",
+		`{"description":"down in a minute","outages":[{"start":"1m","duration":"30s","status":502}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = control(t, sim, http.MethodPut, "/_simulator/active", `{"name":"down"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var active Scenario
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&active))
+	assert.Equal(t, "down", active.Name)
+	assert.Equal(t, Duration(time.Minute), active.Outages[0].Start)
+
+	// The outage is timed from activation
+	assert.Equal(t, http.StatusOK, post(t, sim, "/payments", payment).Code)
+	fakeClock.Advance(time.Minute)
+	assert.Equal(t, http.StatusBadGateway, post(t, sim, "/payments", payment).Code)
+
+	// The control API itself is never down
+	w = control(t, sim, http.MethodGet, "/_simulator/scenarios", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list ScenarioList
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+	assert.Equal(t, "down", list.Active)
+	assert.Equal(t, fakeClock.Now().Add(-time.Minute), list.ActivatedAt)
+	require.Len(t, list.Scenarios, 2)
+	assert.Equal(t, DefaultScenario, list.Scenarios[0].Name)
+
+	fakeClock.Advance(30 * time.Second)
+	assert.Equal(t, http.StatusOK, post(t, sim, "/payments", payment).Code)
+
+	require.Equal(t, http.StatusOK, control(t, sim, http.MethodPost, "/_simulator/reset", "").Code)
+	assert.Equal(t, DefaultScenario, sim.Scenarios().Active)
+}
+
+func TestSimulator_ControlAPIErrors(t *testing.T) {
+	sim := New()
+
+	tests := []struct {
+		name           string
+		method, path   string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "unknown scenario",
+			method:         http.MethodPut,
+			path:           "/_simulator/active",
+			body:           `{"name":"missing"}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   `unknown scenario: \"missing\"`,
+		},
+		{
+			name:           "unknown field",
+			method:         http.MethodPut,
+			path:           "/_simulator/scenarios/x",
+			body:           `{"error_rate":0.5}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "unknown field",
+		},
+		{
+			name:           "invalid scenario",
+			method:         http.MethodPut,
+			path:           "/_simulator/scenarios/x",
+			body:           `{"resets":{"rate":1.5}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "resets.rate must be between 0 and 1",
+		},
+		{
+			name:           "bad duration",
+			method:         http.MethodPut,
+			path:           "/_simulator/scenarios/x",
+			body:           `{"latency":{"mean":100}}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid scenario",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := control(t, sim, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.expectedBody)
+		})
+	}
+}
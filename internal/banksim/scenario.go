@@ -0,0 +1,273 @@
+package banksim
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultScenario is the scenario a new Simulator starts in. Unless
+// WithLatency or WithErrorRate say otherwise it is a healthy bank.
+const DefaultScenario = "default"
+
+// Scenario describes how the bank misbehaves, to rehearse incidents. Each
+// request first checks for an outage, then waits out the latency and then
+// rolls once for the faults, whose rates must add up to at most 1.
+type Scenario struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	Latency Latency `yaml:"latency,omitempty" json:"latency"`
+	// Errors answers requests with a 5xx status instead of processing them
+	Errors Errors `yaml:"errors,omitempty" json:"errors"`
+	// Resets sends half the response and then resets the connection
+	Resets Fault `yaml:"resets,omitempty" json:"resets"`
+	// Malformed sends a response whose body is cut short, so it is not
+	// valid JSON
+	Malformed Fault `yaml:"malformed,omitempty" json:"malformed"`
+	// SlowDrip sends the response body a few bytes at a time
+	SlowDrip SlowDrip `yaml:"slow_drip,omitempty" json:"slow_drip"`
+
+	Outages []Outage `yaml:"outages,omitempty" json:"outages,omitempty"`
+}
+
+// Distribution is how latencies are spread
+type Distribution string
+
+const (
+	// Fixed waits Mean on every request
+	Fixed Distribution = "fixed"
+	// Uniform waits anything between Min and Max
+	Uniform Distribution = "uniform"
+	// Normal waits around Mean, with StdDev
+	Normal Distribution = "normal"
+	// Exponential mostly waits little, with a long tail averaging Mean
+	Exponential Distribution = "exponential"
+)
+
+// Latency delays every answer. Samples from the normal and exponential
+// distributions are kept between Min and Max; a Max of 0 leaves them
+// unbounded above.
+type Latency struct {
+	Distribution Distribution `yaml:"distribution,omitempty" json:"distribution,omitempty"`
+	Min          Duration     `yaml:"min,omitempty" json:"min,omitempty"`
+	Max          Duration     `yaml:"max,omitempty" json:"max,omitempty"`
+	Mean         Duration     `yaml:"mean,omitempty" json:"mean,omitempty"`
+	StdDev       Duration     `yaml:"stddev,omitempty" json:"stddev,omitempty"`
+}
+
+// Errors answers a fraction of requests with one of Statuses, picked at
+// random, or 500 if there are none
+type Errors struct {
+	Rate     float64 `yaml:"rate,omitempty" json:"rate,omitempty"`
+	Statuses []int   `yaml:"statuses,omitempty" json:"statuses,omitempty"`
+}
+
+// Fault happens to a fraction of requests, between 0 and 1
+type Fault struct {
+	Rate float64 `yaml:"rate,omitempty" json:"rate,omitempty"`
+}
+
+// SlowDrip sends a fraction of responses ChunkBytes at a time, waiting
+// Interval between chunks
+type SlowDrip struct {
+	Rate       float64  `yaml:"rate,omitempty" json:"rate,omitempty"`
+	Interval   Duration `yaml:"interval,omitempty" json:"interval,omitempty"`
+	ChunkBytes int      `yaml:"chunk_bytes,omitempty" json:"chunk_bytes,omitempty"`
+}
+
+// OutageMode is how the bank behaves while it is down
+type OutageMode string
+
+const (
+	// Unavailable answers every request with Status, 503 by default
+	Unavailable OutageMode = "unavailable"
+	// Reset closes the connection without answering
+	Reset OutageMode = "reset"
+	// Hang never answers, until the caller gives up
+	Hang OutageMode = "hang"
+)
+
+// Outage takes the bank down for Duration, starting Start after the
+// scenario is activated. With Every it recurs on that period; a Duration of
+// 0 lasts until another scenario is activated.
+type Outage struct {
+	Start    Duration   `yaml:"start,omitempty" json:"start,omitempty"`
+	Duration Duration   `yaml:"duration,omitempty" json:"duration,omitempty"`
+	Every    Duration   `yaml:"every,omitempty" json:"every,omitempty"`
+	Mode     OutageMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Status   int        `yaml:"status,omitempty" json:"status,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "250ms" in
+// scenario files and the control API
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Validate reports everything wrong with the scenario at once
+func (sc Scenario) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if sc.Name == "" {
+		fail("name is required")
+	}
+
+	l := sc.Latency
+	switch l.Distribution {
+	case "", Fixed, Uniform, Normal, Exponential:
+	default:
+		fail("latency.distribution must be one of fixed, uniform, normal or exponential")
+	}
+	if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0 {
+		fail("latency must not be negative")
+	}
+	if l.Max > 0 && l.Min > l.Max {
+		fail("latency.min must not be more than latency.max")
+	}
+
+	total := 0.0
+	for name, rate := range map[string]float64{
+		"errors.rate":    sc.Errors.Rate,
+		"resets.rate":    sc.Resets.Rate,
+		"malformed.rate": sc.Malformed.Rate,
+		"slow_drip.rate": sc.SlowDrip.Rate,
+	} {
+		if rate < 0 || rate > 1 {
+			fail("%s must be between 0 and 1", name)
+		}
+		total += rate
+	}
+	if total > 1 {
+		fail("fault rates must add up to at most 1, not %g", total)
+	}
+	for _, status := range sc.Errors.Statuses {
+		if status < 400 || status > 599 {
+			fail("errors.statuses must be HTTP error statuses, not %d", status)
+		}
+	}
+	if sc.SlowDrip.Rate > 0 && sc.SlowDrip.Interval <= 0 {
+		fail("slow_drip.interval is required")
+	}
+	if sc.SlowDrip.ChunkBytes < 0 {
+		fail("slow_drip.chunk_bytes must not be negative")
+	}
+
+	for i, o := range sc.Outages {
+		switch o.Mode {
+		case "", Unavailable, Reset, Hang:
+		default:
+			fail("outages[%d].mode must be one of unavailable, reset or hang", i)
+		}
+		if o.Start < 0 || o.Duration < 0 || o.Every < 0 {
+			fail("outages[%d] must not have negative times", i)
+		}
+		if o.Every > 0 && (o.Duration == 0 || o.Duration > o.Every) {
+			fail("outages[%d].duration must be set and no longer than every", i)
+		}
+		if o.Status != 0 && (o.Status < 400 || o.Status > 599) {
+			fail("outages[%d].status must be an HTTP error status", i)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid scenario %q: %w", sc.Name, errors.Join(errs...))
+	}
+	return nil
+}
+
+// delay draws a latency for one request
+func (l Latency) delay(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case Uniform:
+		d = time.Duration(l.Min)
+		if l.Max > l.Min {
+			d += time.Duration(r.Int63n(int64(l.Max - l.Min)))
+		}
+	case Normal:
+		d = time.Duration(l.Mean) + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	case Exponential:
+		d = time.Duration(r.ExpFloat64() * float64(l.Mean))
+	default:
+		d = time.Duration(l.Mean)
+	}
+
+	if d < time.Duration(l.Min) {
+		d = time.Duration(l.Min)
+	}
+	if l.Max > 0 && d > time.Duration(l.Max) {
+		d = time.Duration(l.Max)
+	}
+	return d
+}
+
+// active reports whether the outage is under way, elapsed after its
+// scenario was activated
+func (o Outage) active(elapsed time.Duration) bool {
+	since := elapsed - time.Duration(o.Start)
+	if since < 0 {
+		return false
+	}
+	if o.Every > 0 {
+		since %= time.Duration(o.Every)
+	}
+	return o.Duration == 0 || since < time.Duration(o.Duration)
+}
+
+// ScenarioFile is the format of a scenario file
+type ScenarioFile struct {
+	// Active is the scenario to start in, DefaultScenario if empty
+	Active    string     `yaml:"active"`
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+// LoadScenarioFile reads and validates a YAML scenario file
+func LoadScenarioFile(path string) (*ScenarioFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file ScenarioFile
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	names := make(map[string]bool)
+	for _, sc := range file.Scenarios {
+		if err := sc.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if names[sc.Name] {
+			return nil, fmt.Errorf("%s: scenario %q is defined twice", path, sc.Name)
+		}
+		names[sc.Name] = true
+	}
+	if file.Active != "" && file.Active != DefaultScenario && !names[file.Active] {
+		return nil, fmt.Errorf("%s: active scenario %q is not defined", path, file.Active)
+	}
+
+	return &file, nil
+}
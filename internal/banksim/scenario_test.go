@@ -0,0 +1,155 @@
+package banksim
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadScenarioFile_Example(t *testing.T) {
+	file, err := LoadScenarioFile(filepath.Join("..", "..", "scenarios.example.yaml"))
+	require.NoError(t, err)
+
+	assert.Equal(t, DefaultScenario, file.Active)
+	require.Len(t, file.Scenarios, 4)
+
+	flaky := file.Scenarios[1]
+	assert.Equal(t, "flaky", flaky.Name)
+	assert.Equal(t, Latency{Distribution: Normal, Mean: Duration(150 * time.Millisecond), StdDev: Duration(50 * time.Millisecond)}, flaky.Latency)
+	assert.Equal(t, Errors{Rate: 0.1, Statuses: []int{500, 502, 503}}, flaky.Errors)
+	assert.Equal(t, SlowDrip{Rate: 0.05, Interval: Duration(500 * time.Millisecond), ChunkBytes: 4}, flaky.SlowDrip)
+
+	outage := file.Scenarios[2]
+	assert.Equal(t, []Outage{{Start: Duration(time.Minute), Duration: Duration(30 * time.Second), Every: Duration(2 * time.Minute), Mode: Unavailable, Status: 503}}, outage.Outages)
+}
+
+func TestLoadScenarioFile_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		expected string
+	}{
+		{
+			name:     "unknown field",
+			contents: "scenarios:\n  - name: x\n    error_rate: 0.5\n",
+			expected: "field error_rate not found",
+		},
+		{
+			name:     "duplicate name",
+			contents: "scenarios:\n  - name: x\n  - name: x\n",
+			expected: `scenario "x" is defined twice`,
+		},
+		{
+			name:     "unknown active scenario",
+			contents: "active: y\nscenarios:\n  - name: x\n",
+			expected: `active scenario "y" is not defined`,
+		},
+		{
+			name:     "bad duration",
+			contents: "scenarios:\n  - name: x\n    latency:\n      mean: soon\n",
+			expected: "invalid duration",
+		},
+		{
+			name:     "invalid scenario",
+			contents: "scenarios:\n  - name: x\n    errors:\n      rate: 2\n",
+			expected: "errors.rate must be between 0 and 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenarios.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.contents), 0o600))
+
+			_, err := LoadScenarioFile(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestScenario_Validate(t *testing.T) {
+	assert.NoError(t, Scenario{Name: "healthy"}.Validate())
+
+	err := Scenario{
+		Latency:   Latency{Distribution: "lognormal", Min: Duration(time.Second), Max: Duration(time.Millisecond)},
+		Errors:    Errors{Rate: 0.6, Statuses: []int{200}},
+		Malformed: Fault{Rate: 0.6},
+		SlowDrip:  SlowDrip{Rate: -0.1},
+		Outages: []Outage{
+			{Mode: "flaky"},
+			{Every: Duration(time.Second), Duration: Duration(time.Minute)},
+			{Status: 302},
+		},
+	}.Validate()
+	require.Error(t, err)
+
+	for _, expected := range []string{
+		"name is required",
+		"latency.distribution must be one of",
+		"latency.min must not be more than latency.max",
+		"slow_drip.rate must be between 0 and 1",
+		"fault rates must add up to at most 1",
+		"errors.statuses must be HTTP error statuses, not 200",
+		"outages[0].mode must be one of",
+		"outages[1].duration must be set and no longer than every",
+		"outages[2].status must be an HTTP error status",
+	} {
+		assert.Contains(t, err.Error(), expected)
+	}
+}
+
+func TestLatency_Delay(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	ms := func(n int) Duration { return Duration(time.Duration(n) * time.Millisecond) }
+
+	tests := []struct {
+		name     string
+		latency  Latency
+		min, max time.Duration
+		mean     time.Duration
+	}{
+		{name: "none", latency: Latency{}, min: 0, max: 0, mean: 0},
+		{name: "fixed", latency: Latency{Distribution: Fixed, Mean: ms(100)}, min: 100 * time.Millisecond, max: 100 * time.Millisecond, mean: 100 * time.Millisecond},
+		{name: "uniform", latency: Latency{Distribution: Uniform, Min: ms(100), Max: ms(200)}, min: 100 * time.Millisecond, max: 200 * time.Millisecond, mean: 150 * time.Millisecond},
+		{name: "normal", latency: Latency{Distribution: Normal, Mean: ms(100), StdDev: ms(10)}, min: 50 * time.Millisecond, max: 150 * time.Millisecond, mean: 100 * time.Millisecond},
+		{name: "normal bounded", latency: Latency{Distribution: Normal, Mean: ms(100), StdDev: ms(100), Min: ms(90), Max: ms(110)}, min: 90 * time.Millisecond, max: 110 * time.Millisecond, mean: 100 * time.Millisecond},
+		{name: "exponential", latency: Latency{Distribution: Exponential, Mean: ms(100)}, min: 0, max: 2 * time.Second, mean: 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const samples = 2000
+			var total time.Duration
+			for i := 0; i < samples; i++ {
+				d := tt.latency.delay(r)
+				require.GreaterOrEqual(t, d, tt.min)
+				require.LessOrEqual(t, d, tt.max)
+				total += d
+			}
+			assert.InDelta(t, float64(tt.mean), float64(total/samples), float64(10*time.Millisecond))
+		})
+	}
+}
+
+func TestOutage_Active(t *testing.T) {
+	once := Outage{Start: Duration(time.Minute), Duration: Duration(30 * time.Second)}
+	assert.False(t, once.active(59*time.Second))
+	assert.True(t, once.active(time.Minute))
+	assert.True(t, once.active(89*time.Second))
+	assert.False(t, once.active(90*time.Second))
+
+	recurring := Outage{Start: Duration(time.Minute), Duration: Duration(30 * time.Second), Every: Duration(2 * time.Minute)}
+	assert.False(t, recurring.active(30*time.Second))
+	assert.True(t, recurring.active(3*time.Minute+10*time.Second))
+	assert.False(t, recurring.active(4*time.Minute))
+
+	forever := Outage{Start: Duration(time.Second)}
+	assert.False(t, forever.active(0))
+	assert.True(t, forever.active(time.Hour))
+}
@@ -0,0 +1,293 @@
+// Package batch processes payments uploaded together in the background,
+// recording the outcome of each one.
+package batch
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/google/uuid"
+)
+
+// PaymentProcessor creates payments. It is satisfied by service.PaymentService.
+type PaymentProcessor interface {
+	ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+}
+
+type Repository interface {
+	SaveBatch(batch *domain.PaymentBatch) error
+	FindBatch(id string) (*domain.PaymentBatch, error)
+	UpdateBatchItem(id string, item domain.BatchItem) error
+	UpdateBatchStatus(id string, status domain.BatchStatus, completedAt time.Time) error
+}
+
+type Service struct {
+	payments    PaymentProcessor
+	repository  Repository
+	clock       clock.Clock
+	concurrency int
+	maxItems    int
+	maxAttempts int
+	retryDelay  time.Duration
+
+	// running holds the cancel function of every batch still being
+	// processed
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithClock replaces the wall clock, mainly for tests
+func WithClock(c clock.Clock) Option {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithConcurrency sets how many of a batch's payments are with the bank at
+// once
+func WithConcurrency(n int) Option {
+	return func(s *Service) {
+		s.concurrency = n
+	}
+}
+
+// WithMaxItems sets how many payments a batch may hold
+func WithMaxItems(n int) Option {
+	return func(s *Service) {
+		s.maxItems = n
+	}
+}
+
+// WithRetry sets how many times a payment turned away before reaching the
+// bank is tried, waiting delay longer after each attempt
+func WithRetry(attempts int, delay time.Duration) Option {
+	return func(s *Service) {
+		s.maxAttempts = attempts
+		s.retryDelay = delay
+	}
+}
+
+func NewService(payments PaymentProcessor, repository Repository, opts ...Option) *Service {
+	s := &Service{
+		payments:    payments,
+		repository:  repository,
+		clock:       clock.Real{},
+		concurrency: 8,
+		maxItems:    10000,
+		maxAttempts: 5,
+		retryDelay:  200 * time.Millisecond,
+		running:     make(map[string]context.CancelFunc),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Submit stores a batch and starts processing it in the background. Entries
+// that are already invalid are recorded as failed without reaching the bank.
+// The payments are made with ctx's values, so they are attributed to the
+// request that uploaded them, but outlive it.
+func (s *Service) Submit(ctx context.Context, entries []domain.BatchEntry) (*domain.PaymentBatch, error) {
+	if len(entries) == 0 {
+		return nil, domain.ErrBatchEmpty
+	}
+	if len(entries) > s.maxItems {
+		return nil, fmt.Errorf("%w: at most %d are allowed", domain.ErrBatchTooLarge, s.maxItems)
+	}
+
+	batch := &domain.PaymentBatch{
+		ID:         uuid.New().String(),
+		MerchantID: merchant.IDFrom(ctx),
+		Status:     domain.BatchProcessing,
+		Items:      make([]domain.BatchItem, len(entries)),
+		CreatedAt:  s.clock.Now(),
+	}
+	for i, entry := range entries {
+		batch.Items[i] = domain.BatchItem{Index: i, Status: domain.BatchItemPending}
+		if entry.Err != nil {
+			batch.Items[i].Status = domain.BatchItemFailed
+			batch.Items[i].Err = entry.Err
+		}
+	}
+
+	if err := s.repository.SaveBatch(batch); err != nil {
+		return nil, fmt.Errorf("failed to save payment batch: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	s.mu.Lock()
+	s.running[batch.ID] = cancel
+	s.mu.Unlock()
+
+	slog.InfoContext(ctx, "payment batch accepted", "batch_id", batch.ID, "items", len(entries))
+	go s.run(runCtx, batch.ID, entries)
+
+	return batch, nil
+}
+
+// Get returns a batch uploaded by the merchant in ctx. Other merchants'
+// batches are reported as not found.
+func (s *Service) Get(ctx context.Context, id string) (*domain.PaymentBatch, error) {
+	batch, err := s.repository.FindBatch(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if batch == nil || batch.MerchantID != merchant.IDFrom(ctx) {
+		return nil, domain.ErrBatchNotFound
+	}
+
+	return batch, nil
+}
+
+// Cancel stops a batch sending any more payments. Payments already with the
+// bank are allowed to finish and keep their outcome.
+func (s *Service) Cancel(ctx context.Context, id string) (*domain.PaymentBatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch.IsFinished() {
+		return nil, domain.ErrBatchFinished
+	}
+
+	if cancel, ok := s.running[id]; ok {
+		cancel()
+	}
+	if err := s.repository.UpdateBatchStatus(id, domain.BatchCanceled, time.Time{}); err != nil {
+		return nil, fmt.Errorf("failed to save payment batch: %w", err)
+	}
+
+	return s.Get(ctx, id)
+}
+
+// run processes a batch's payments, at most concurrency at a time, until
+// they are all done or the batch is canceled
+func (s *Service) run(ctx context.Context, id string, entries []domain.BatchEntry) {
+	slots := make(chan struct{}, s.concurrency)
+	var wg sync.WaitGroup
+
+	for i, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			s.record(ctx, id, domain.BatchItem{Index: i, Status: domain.BatchItemCanceled})
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int, payment *domain.Payment) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			s.record(ctx, id, s.process(ctx, index, payment))
+		}(i, entry.Payment)
+	}
+
+	wg.Wait()
+	s.finish(ctx, id)
+}
+
+// process makes one payment of a batch. A payment turned away before
+// reaching the bank is tried again, since it is often the batch itself
+// that is filling the bank's capacity.
+func (s *Service) process(ctx context.Context, index int, payment *domain.Payment) domain.BatchItem {
+	for attempt := 1; ; attempt++ {
+		// Every attempt starts from the payment as uploaded, since a failed
+		// one has already been given an ID
+		p := *payment
+
+		// A payment sent to the bank is seen through even if the batch is
+		// canceled meanwhile
+		processed, err := s.payments.ProcessPayment(context.WithoutCancel(ctx), &p)
+		if err == nil {
+			return domain.BatchItem{
+				Index:         index,
+				Status:        domain.BatchItemSucceeded,
+				PaymentID:     processed.ID,
+				PaymentStatus: processed.Status,
+				DeclineReason: processed.DeclineReason,
+			}
+		}
+
+		if attempt >= s.maxAttempts || !client.NotSent(err) {
+			return domain.BatchItem{Index: index, Status: domain.BatchItemFailed, Err: err}
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * s.retryDelay):
+		case <-ctx.Done():
+			return domain.BatchItem{Index: index, Status: domain.BatchItemCanceled}
+		}
+	}
+}
+
+func (s *Service) record(ctx context.Context, id string, item domain.BatchItem) {
+	if err := s.repository.UpdateBatchItem(id, item); err != nil {
+		slog.ErrorContext(ctx, "failed to record payment batch item",
+			"batch_id", id,
+			"index", item.Index,
+			"payment_id", item.PaymentID,
+			"error", err,
+		)
+	}
+}
+
+// finish marks a batch that ran to the end as completed. A canceled batch
+// stays canceled, but is only given a completion time once its last
+// payment is done.
+func (s *Service) finish(ctx context.Context, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cancel, ok := s.running[id]; ok {
+		cancel()
+		delete(s.running, id)
+	}
+
+	batch, err := s.Get(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to finish payment batch", "batch_id", id, "error", err)
+		return
+	}
+
+	status := batch.Status
+	if status == domain.BatchProcessing {
+		status = domain.BatchCompleted
+	}
+	if err := s.repository.UpdateBatchStatus(id, status, s.clock.Now()); err != nil {
+		slog.ErrorContext(ctx, "failed to finish payment batch", "batch_id", id, "error", err)
+		return
+	}
+
+	counts := batch.Counts()
+	slog.InfoContext(ctx, "payment batch finished",
+		"batch_id", id,
+		"status", status,
+		"authorized", counts.Authorized,
+		"declined", counts.Declined,
+		"failed", counts.Failed,
+		"canceled", counts.Canceled,
+	)
+}
@@ -0,0 +1,229 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProcessor authorizes payments, declining those for declineAmount and
+// failing the first failures calls with err. If release is set, every call
+// waits for it.
+type fakeProcessor struct {
+	mu            sync.Mutex
+	calls         int
+	inFlight      int
+	maxInFlight   int
+	declineAmount int
+	failures      int
+	err           error
+	started       chan struct{}
+	release       chan struct{}
+}
+
+func (f *fakeProcessor) ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	f.mu.Lock()
+	f.calls++
+	call := f.calls
+	f.inFlight++
+	f.maxInFlight = max(f.maxInFlight, f.inFlight)
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	if f.started != nil {
+		f.started <- struct{}{}
+	}
+	if f.release != nil {
+		<-f.release
+	}
+
+	if call <= f.failures {
+		return nil, f.err
+	}
+
+	payment.ID = fmt.Sprintf("payment-%d", payment.Amount)
+	payment.Status = domain.StatusAuthorized
+	if payment.Amount == f.declineAmount {
+		payment.Status = domain.StatusDeclined
+		payment.DeclineReason = domain.DeclineInsufficientFunds
+	}
+	return payment, nil
+}
+
+var start = time.Date(2026, time.October, 1, 10, 0, 0, 0, time.UTC)
+
+func payments(amounts ...int) []domain.BatchEntry {
+	entries := make([]domain.BatchEntry, len(amounts))
+	for i, amount := range amounts {
+		entries[i] = domain.BatchEntry{Payment: &domain.Payment{Currency: "GBP", Amount: amount}}
+	}
+	return entries
+}
+
+func waitForBatch(t *testing.T, s *Service, id string) *domain.PaymentBatch {
+	t.Helper()
+	var batch *domain.PaymentBatch
+	require.Eventually(t, func() bool {
+		var err error
+		batch, err = s.Get(context.Background(), id)
+		require.NoError(t, err)
+		return !batch.CompletedAt.IsZero()
+	}, time.Second, 5*time.Millisecond)
+	return batch
+}
+
+func TestService_Submit(t *testing.T) {
+	processor := &fakeProcessor{declineAmount: 200}
+	s := NewService(processor, repository.NewBatchesRepository(),
+		WithClock(clock.NewFake(start)),
+		WithConcurrency(2),
+	)
+
+	entries := payments(100, 200, 300, 400, 500, 600)
+	entries[2] = domain.BatchEntry{Err: domain.ErrAmountInvalid}
+
+	batch, err := s.Submit(context.Background(), entries)
+	require.NoError(t, err)
+	assert.Equal(t, domain.BatchProcessing, batch.Status)
+	assert.Equal(t, start, batch.CreatedAt)
+
+	batch = waitForBatch(t, s, batch.ID)
+	assert.Equal(t, domain.BatchCompleted, batch.Status)
+	assert.Equal(t, start, batch.CompletedAt)
+	assert.Equal(t, domain.BatchCounts{Total: 6, Authorized: 4, Declined: 1, Failed: 1}, batch.Counts())
+
+	assert.Equal(t, domain.BatchItem{
+		Index:         1,
+		Status:        domain.BatchItemSucceeded,
+		PaymentID:     "payment-200",
+		PaymentStatus: domain.StatusDeclined,
+		DeclineReason: domain.DeclineInsufficientFunds,
+	}, batch.Items[1])
+	assert.Equal(t, domain.BatchItem{Index: 2, Status: domain.BatchItemFailed, Err: domain.ErrAmountInvalid}, batch.Items[2])
+
+	assert.Equal(t, 5, processor.calls, "invalid items never reach the bank")
+	assert.LessOrEqual(t, processor.maxInFlight, 2)
+}
+
+func TestService_MerchantScoped(t *testing.T) {
+	s := NewService(&fakeProcessor{}, repository.NewBatchesRepository(), WithClock(clock.NewFake(start)))
+	owner := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-1"})
+	other := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-2"})
+
+	batch, err := s.Submit(owner, payments(100))
+	require.NoError(t, err)
+	assert.Equal(t, "merchant-1", batch.MerchantID)
+
+	require.Eventually(t, func() bool {
+		b, err := s.Get(owner, batch.ID)
+		return err == nil && !b.CompletedAt.IsZero()
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = s.Get(other, batch.ID)
+	assert.ErrorIs(t, err, domain.ErrBatchNotFound)
+	_, err = s.Get(context.Background(), batch.ID)
+	assert.ErrorIs(t, err, domain.ErrBatchNotFound)
+	_, err = s.Cancel(other, batch.ID)
+	assert.ErrorIs(t, err, domain.ErrBatchNotFound)
+}
+
+func TestService_Submit_Limits(t *testing.T) {
+	s := NewService(&fakeProcessor{}, repository.NewBatchesRepository(), WithMaxItems(2))
+
+	_, err := s.Submit(context.Background(), nil)
+	assert.ErrorIs(t, err, domain.ErrBatchEmpty)
+
+	_, err = s.Submit(context.Background(), payments(100, 200, 300))
+	assert.ErrorIs(t, err, domain.ErrBatchTooLarge)
+}
+
+func TestService_RetriesShedPayments(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		status   domain.BatchItemStatus
+		calls    int
+	}{
+		{
+			name:     "shed then sent",
+			err:      fmt.Errorf("failed to process payment with bank: %w", domain.ErrConcurrencyLimitExceeded),
+			failures: 2,
+			status:   domain.BatchItemSucceeded,
+			calls:    3,
+		},
+		{
+			name:     "shed every time",
+			err:      domain.ErrBankOverloaded,
+			failures: 10,
+			status:   domain.BatchItemFailed,
+			calls:    3,
+		},
+		{
+			name:     "bank error",
+			err:      errors.New("bank service unavailable"),
+			failures: 1,
+			status:   domain.BatchItemFailed,
+			calls:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &fakeProcessor{err: tt.err, failures: tt.failures}
+			s := NewService(processor, repository.NewBatchesRepository(), WithRetry(3, time.Millisecond))
+
+			batch, err := s.Submit(context.Background(), payments(100))
+			require.NoError(t, err)
+
+			batch = waitForBatch(t, s, batch.ID)
+			assert.Equal(t, tt.status, batch.Items[0].Status)
+			assert.Equal(t, tt.calls, processor.calls)
+		})
+	}
+}
+
+func TestService_Cancel(t *testing.T) {
+	processor := &fakeProcessor{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	s := NewService(processor, repository.NewBatchesRepository(), WithConcurrency(1))
+
+	batch, err := s.Submit(context.Background(), payments(100, 200, 300))
+	require.NoError(t, err)
+	<-processor.started
+
+	canceled, err := s.Cancel(context.Background(), batch.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.BatchCanceled, canceled.Status)
+	assert.True(t, canceled.CompletedAt.IsZero(), "the first payment is still with the bank")
+
+	close(processor.release)
+	batch = waitForBatch(t, s, batch.ID)
+	assert.Equal(t, domain.BatchCanceled, batch.Status)
+	assert.Equal(t, domain.BatchCounts{Total: 3, Authorized: 1, Canceled: 2}, batch.Counts(),
+		"the payment already sent keeps its outcome")
+	assert.Equal(t, 1, processor.calls)
+
+	_, err = s.Cancel(context.Background(), batch.ID)
+	assert.ErrorIs(t, err, domain.ErrBatchFinished)
+
+	_, err = s.Cancel(context.Background(), "missing")
+	assert.ErrorIs(t, err, domain.ErrBatchNotFound)
+}
@@ -0,0 +1,371 @@
+// Package blocklist keeps the cards each merchant refuses payments from.
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/google/uuid"
+)
+
+// JournalFile is the name of the blocklist journal in the storage directory
+const JournalFile = "blocklist.journal"
+
+// Detokenizer resolves a merchant's vault token to the card behind it, so a
+// card can be blocked, or checked, by its token
+type Detokenizer interface {
+	Describe(merchantID, token string) (*domain.Card, error)
+	Detokenize(token string) (*domain.Card, error)
+}
+
+// record is a blocked card. The card number is sealed with its own data key
+// and found by decrypting the merchant's records with the same last four
+// digits.
+type record struct {
+	card   domain.BlockedCard
+	sealed *envelope.Sealed
+}
+
+// entry is one line of the blocklist journal: a blocked card, written when
+// it is blocked and again whenever its data key changes, or the ID of a
+// card that was unblocked
+type entry struct {
+	ID         string           `json:"id"`
+	MerchantID string           `json:"merchant_id,omitempty"`
+	LastFour   string           `json:"last_four,omitempty"`
+	Reason     string           `json:"reason,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Sealed     *envelope.Sealed `json:"sealed,omitempty"`
+	Removed    bool             `json:"removed,omitempty"`
+}
+
+// Opened with a journal file, every change is appended to the file before
+// the call returns so blocked cards stay blocked after a restart.
+//
+// In production, this would be backed by a database
+type Blocklist struct {
+	envelope *envelope.Envelope
+	vault    Detokenizer
+	clock    clock.Clock
+	records  map[string]*record
+	journal  *journal.Journal
+	mu       sync.RWMutex
+}
+
+// Option configures a Blocklist
+type Option func(*Blocklist)
+
+// WithVault lets cards be blocked and checked by their vault token
+func WithVault(vault Detokenizer) Option {
+	return func(b *Blocklist) {
+		b.vault = vault
+	}
+}
+
+// WithClock replaces the wall clock that dates blocked cards
+func WithClock(c clock.Clock) Option {
+	return func(b *Blocklist) {
+		b.clock = c
+	}
+}
+
+// NewBlocklist creates an empty blocklist that seals every card number with
+// its own data key
+func NewBlocklist(e *envelope.Envelope, opts ...Option) *Blocklist {
+	b := &Blocklist{
+		envelope: e,
+		clock:    clock.Real{},
+		records:  make(map[string]*record),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// OpenBlocklist loads the journal at path, creating it if needed
+func OpenBlocklist(path string, e *envelope.Envelope, opts ...Option) (*Blocklist, error) {
+	b := NewBlocklist(e, opts...)
+
+	replay := func(line []byte) error {
+		var ent entry
+		if err := json.Unmarshal(line, &ent); err != nil {
+			return err
+		}
+		if ent.Removed {
+			delete(b.records, ent.ID)
+			return nil
+		}
+		b.records[ent.ID] = ent.record()
+		return nil
+	}
+
+	j, err := journal.Open(path, "blocklist journal", replay, b.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	b.journal = j
+
+	return b, nil
+}
+
+func (b *Blocklist) Close() error {
+	if b.journal == nil {
+		return nil
+	}
+	return b.journal.Close()
+}
+
+// Ping reports whether the blocklist can be used. A journal must still be
+// the file at its path.
+func (b *Blocklist) Ping(ctx context.Context) error {
+	if b.journal == nil {
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.journal.Ping(ctx)
+}
+
+// Block stops the merchant in ctx taking payments from card, given by its
+// number or a vault token. Blocking a card that is already blocked returns
+// the existing entry.
+func (b *Blocklist) Block(ctx context.Context, card domain.Card, reason string) (*domain.BlockedCard, error) {
+	if err := card.ValidateForBlocking(); err != nil {
+		return nil, err
+	}
+
+	owner := merchant.IDFrom(ctx)
+	number, err := b.cardNumber(owner, card)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, err := b.findLocked(owner, number)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		blocked := existing.card
+		return &blocked, nil
+	}
+
+	rec := &record{card: domain.BlockedCard{
+		ID:         uuid.New().String(),
+		MerchantID: owner,
+		LastFour:   number[len(number)-4:],
+		Reason:     reason,
+		CreatedAt:  b.clock.Now().UTC(),
+	}}
+	// The ID is bound as additional data so a ciphertext cannot be swapped
+	// between records
+	rec.sealed, err = b.envelope.Seal([]byte(number), []byte(rec.card.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt card number: %w", err)
+	}
+
+	if err := b.append(rec.entry()); err != nil {
+		return nil, err
+	}
+	b.records[rec.card.ID] = rec
+
+	blocked := rec.card
+	return &blocked, nil
+}
+
+// List returns the cards the merchant in ctx has blocked, newest first
+func (b *Blocklist) List(ctx context.Context) ([]domain.BlockedCard, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	owner := merchant.IDFrom(ctx)
+	cards := []domain.BlockedCard{}
+	for _, rec := range b.records {
+		if rec.card.MerchantID == owner {
+			cards = append(cards, rec.card)
+		}
+	}
+
+	sort.Slice(cards, func(i, j int) bool {
+		if !cards[i].CreatedAt.Equal(cards[j].CreatedAt) {
+			return cards[i].CreatedAt.After(cards[j].CreatedAt)
+		}
+		return cards[i].ID < cards[j].ID
+	})
+	return cards, nil
+}
+
+// Unblock lets the merchant in ctx take payments from a card again. The
+// sealed card number is shredded and the journal rewritten without it.
+// Another merchant's blocked cards are reported as not found.
+func (b *Blocklist) Unblock(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rec, exists := b.records[id]
+	if !exists || rec.card.MerchantID != merchant.IDFrom(ctx) {
+		return domain.ErrBlockedCardNotFound
+	}
+
+	if err := b.append(entry{ID: id, Removed: true}); err != nil {
+		return err
+	}
+	delete(b.records, id)
+	rec.sealed.Shred()
+
+	if b.journal != nil {
+		if err := b.journal.Rewrite(b.snapshot); err != nil {
+			return fmt.Errorf("failed to remove card number on disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// Blocks reports whether the merchant refuses payments from card, given by
+// its number or a vault token
+func (b *Blocklist) Blocks(merchantID string, card domain.Card) (bool, error) {
+	number, err := b.cardNumber(merchantID, card)
+	if err != nil {
+		return false, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rec, err := b.findLocked(merchantID, number)
+	return rec != nil, err
+}
+
+// RewrapKeys moves every blocked card onto the current master key version
+func (b *Blocklist) RewrapKeys(e *envelope.Envelope) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := 0
+	for id, rec := range b.records {
+		sealed := *rec.sealed
+		ok, err := e.Rewrap(&sealed)
+		if err != nil {
+			return changed, fmt.Errorf("blocked card %s: %w", id, err)
+		}
+		if !ok {
+			continue
+		}
+
+		rewrapped := &record{card: rec.card, sealed: &sealed}
+		if err := b.append(rewrapped.entry()); err != nil {
+			return changed, err
+		}
+		b.records[id] = rewrapped
+		changed++
+	}
+
+	return changed, nil
+}
+
+// cardNumber returns the number of a card, resolving a token of merchantID
+// in the vault
+func (b *Blocklist) cardNumber(merchantID string, card domain.Card) (string, error) {
+	if !card.IsTokenized() {
+		return card.Number, nil
+	}
+	if b.vault == nil {
+		return "", domain.ErrTokenNotFound
+	}
+
+	// Another merchant's token is not found, as it is everywhere else
+	if _, err := b.vault.Describe(merchantID, card.Token); err != nil {
+		return "", err
+	}
+	stored, err := b.vault.Detokenize(card.Token)
+	if err != nil {
+		return "", err
+	}
+	return stored.Number, nil
+}
+
+// findLocked returns the merchant's record for number, or nil. It must be
+// called with mu held.
+func (b *Blocklist) findLocked(merchantID, number string) (*record, error) {
+	if len(number) < 4 {
+		return nil, nil
+	}
+	lastFour := number[len(number)-4:]
+
+	for id, rec := range b.records {
+		if rec.card.MerchantID != merchantID || rec.card.LastFour != lastFour {
+			continue
+		}
+
+		plaintext, err := b.envelope.Open(rec.sealed, []byte(id))
+		if err != nil {
+			if errors.Is(err, envelope.ErrShredded) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to decrypt blocked card %s: %w", id, err)
+		}
+		if string(plaintext) == number {
+			return rec, nil
+		}
+	}
+	return nil, nil
+}
+
+// append journals an entry, if there is a journal. It must be called with
+// mu held for writing.
+func (b *Blocklist) append(ent entry) error {
+	if b.journal == nil {
+		return nil
+	}
+	return b.journal.Append(ent)
+}
+
+// snapshot writes every blocked card
+func (b *Blocklist) snapshot(write func(entry any) error) error {
+	for _, rec := range b.records {
+		if err := write(rec.entry()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *record) entry() entry {
+	return entry{
+		ID:         r.card.ID,
+		MerchantID: r.card.MerchantID,
+		LastFour:   r.card.LastFour,
+		Reason:     r.card.Reason,
+		CreatedAt:  r.card.CreatedAt,
+		Sealed:     r.sealed,
+	}
+}
+
+func (e entry) record() *record {
+	return &record{
+		card: domain.BlockedCard{
+			ID:         e.ID,
+			MerchantID: e.MerchantID,
+			LastFour:   e.LastFour,
+			Reason:     e.Reason,
+			CreatedAt:  e.CreatedAt,
+		},
+		sealed: e.Sealed,
+	}
+}
@@ -0,0 +1,152 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/vault"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var merchantCtx = merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-1"})
+
+func newTestEnvelope(t *testing.T) *envelope.Envelope {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	return envelope.New(provider)
+}
+
+func TestBlocklist_BlockAndUnblock(t *testing.T) {
+	b := NewBlocklist(newTestEnvelope(t))
+
+	blocked, err := b.Block(merchantCtx, domain.Card{Number: "2222405343248877"}, "chargeback")
+	require.NoError(t, err)
+	assert.Equal(t, "merchant-1", blocked.MerchantID)
+	assert.Equal(t, "8877", blocked.LastFour)
+	assert.Equal(t, "chargeback", blocked.Reason)
+
+	again, err := b.Block(merchantCtx, domain.Card{Number: "2222405343248877"}, "again")
+	require.NoError(t, err)
+	assert.Equal(t, blocked.ID, again.ID, "a card is only blocked once")
+
+	ok, err := b.Blocks("merchant-1", domain.Card{Number: "2222405343248877"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Same last four digits, different card
+	ok, err = b.Blocks("merchant-1", domain.Card{Number: "4111111111118877"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	cards, err := b.List(merchantCtx)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.BlockedCard{*blocked}, cards)
+
+	require.NoError(t, b.Unblock(merchantCtx, blocked.ID))
+	ok, err = b.Blocks("merchant-1", domain.Card{Number: "2222405343248877"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.Equal(t, domain.ErrBlockedCardNotFound, b.Unblock(merchantCtx, blocked.ID))
+}
+
+func TestBlocklist_Validation(t *testing.T) {
+	b := NewBlocklist(newTestEnvelope(t))
+
+	_, err := b.Block(merchantCtx, domain.Card{Number: "1234"}, "")
+	assert.ErrorIs(t, err, domain.ErrCardNumberInvalid)
+
+	_, err = b.Block(merchantCtx, domain.Card{Number: "2222405343248877", Token: "tok_abc"}, "")
+	assert.ErrorIs(t, err, domain.ErrCardSourceConflict)
+
+	_, err = b.Block(merchantCtx, domain.Card{Token: "tok_abc"}, "")
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound, "tokens need a vault")
+}
+
+func TestBlocklist_Token(t *testing.T) {
+	e := newTestEnvelope(t)
+	v := vault.NewVault(e)
+	b := NewBlocklist(e, WithVault(v))
+
+	card, err := v.Tokenize("merchant-1", domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: time.Now().Year() + 1})
+	require.NoError(t, err)
+
+	_, err = b.Block(merchantCtx, domain.Card{Token: card.Token}, "")
+	require.NoError(t, err)
+
+	// Blocked by token, so the card number is blocked too, and the other way round
+	ok, err := b.Blocks("merchant-1", domain.Card{Number: "2222405343248877"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = b.Blocks("merchant-1", domain.Card{Token: card.Token})
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Another merchant's token is not found
+	otherCtx := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-2"})
+	_, err = b.Block(otherCtx, domain.Card{Token: card.Token}, "")
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound)
+}
+
+func TestBlocklist_MerchantScoped(t *testing.T) {
+	b := NewBlocklist(newTestEnvelope(t))
+	otherCtx := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-2"})
+
+	blocked, err := b.Block(merchantCtx, domain.Card{Number: "2222405343248877"}, "")
+	require.NoError(t, err)
+
+	ok, err := b.Blocks("merchant-2", domain.Card{Number: "2222405343248877"})
+	require.NoError(t, err)
+	assert.False(t, ok, "merchant-1's blocklist does not apply to merchant-2")
+
+	cards, err := b.List(otherCtx)
+	require.NoError(t, err)
+	assert.Empty(t, cards)
+
+	assert.Equal(t, domain.ErrBlockedCardNotFound, b.Unblock(otherCtx, blocked.ID))
+}
+
+func TestBlocklist_JournalSurvivesRestart(t *testing.T) {
+	e := newTestEnvelope(t)
+	path := filepath.Join(t.TempDir(), JournalFile)
+
+	b, err := OpenBlocklist(path, e)
+	require.NoError(t, err)
+
+	kept, err := b.Block(merchantCtx, domain.Card{Number: "2222405343248877"}, "chargeback")
+	require.NoError(t, err)
+	removed, err := b.Block(merchantCtx, domain.Card{Number: "4111111111111111"}, "")
+	require.NoError(t, err)
+	wrappedKey := base64.StdEncoding.EncodeToString(b.records[removed.ID].sealed.WrappedKey)
+	require.NoError(t, b.Unblock(merchantCtx, removed.ID))
+	require.NoError(t, b.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "2222405343248877")
+	assert.NotContains(t, string(data), wrappedKey, "an unblocked card's data key must not stay on disk")
+
+	reopened, err := OpenBlocklist(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	cards, err := reopened.List(merchantCtx)
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+	assert.Equal(t, kept.ID, cards[0].ID)
+	assert.Equal(t, "chargeback", cards[0].Reason)
+
+	ok, err := reopened.Blocks("merchant-1", domain.Card{Number: "2222405343248877"})
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, reopened.Ping(context.Background()))
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Adapter translates authorizations to and from one acquirer's wire
+// format, so HTTPBankClient can talk to acquirers other than the simulator
+type Adapter interface {
+	// AuthorizationPath is where authorizations are POSTed, relative to the
+	// bank URL
+	AuthorizationPath() string
+	// EncodeAuthorization writes the request body. The same authorization
+	// must always encode to the same bytes, so recorded traffic can be
+	// replayed.
+	EncodeAuthorization(auth *CardAuthorization) ([]byte, error)
+	// DecodeAuthorization reads the bank's answer. Answers that are not a
+	// decision fail with ErrBankRejected, ErrBankUnavailable or
+	// ErrBankUnexpectedResponse.
+	DecodeAuthorization(status int, body []byte) (*BankResponse, error)
+}
+
+// CardAuthorization is everything an acquirer may need to authorize a
+// payment, with tokenized cards already resolved
+type CardAuthorization struct {
+	CardNumber  string
+	ExpiryMonth int
+	ExpiryYear  int
+	CVV         string
+	Currency    string
+	// Amount is in minor units
+	Amount           int
+	Initiator        string
+	StoredCredential *BankStoredCredential
+}
+
+// SettlementAdapter is implemented by adapters whose acquirer can capture,
+// void and refund the authorizations it gave. HTTPBankClient answers
+// domain.ErrSettlementUnsupported for adapters that do not implement it.
+type SettlementAdapter interface {
+	// SettlementPath is where the settlement is POSTed, relative to the
+	// bank URL
+	SettlementPath(s *Settlement) string
+	EncodeSettlement(s *Settlement) ([]byte, error)
+	// DecodeSettlement reads the bank's answer. A bank that will not
+	// settle the authorization as asked fails with ErrSettlementRefused.
+	DecodeSettlement(status int, body []byte) error
+}
+
+// SettlementType is what the bank is asked to do with an authorization
+type SettlementType string
+
+const (
+	SettlementCapture SettlementType = "capture"
+	SettlementVoid    SettlementType = "void"
+	SettlementRefund  SettlementType = "refund"
+)
+
+// Settlement asks the bank to capture, void or refund an authorization
+type Settlement struct {
+	Type SettlementType
+	// AuthorizationCode is the bank's code for the authorization
+	AuthorizationCode string
+	// Amount is in minor units. Voids release the whole authorization, so
+	// they have none.
+	Amount int
+}
+
+// Names of the adapters in DefaultAdapters
+const (
+	AdapterSimulator = "simulator"
+	AdapterMapped    = "mapped"
+)
+
+// ErrUnknownAdapter is returned for an adapter name nothing is registered
+// under
+var ErrUnknownAdapter = errors.New("unknown bank adapter")
+
+// AdapterFactory builds an adapter from the acquirer's field mapping.
+// Adapters for a fixed format ignore the mapping.
+type AdapterFactory func(mapping FieldMapping) (Adapter, error)
+
+// AdapterRegistry holds the adapters the gateway can be configured with,
+// by name
+type AdapterRegistry struct {
+	mu        sync.RWMutex
+	factories map[string]AdapterFactory
+}
+
+func NewAdapterRegistry() *AdapterRegistry {
+	return &AdapterRegistry{factories: make(map[string]AdapterFactory)}
+}
+
+// DefaultAdapters returns a registry holding the simulator's format and the
+// configurable mapped adapter
+func DefaultAdapters() *AdapterRegistry {
+	r := NewAdapterRegistry()
+	r.Register(AdapterSimulator, func(FieldMapping) (Adapter, error) { return SimulatorAdapter{}, nil })
+	r.Register(AdapterMapped, func(m FieldMapping) (Adapter, error) { return NewMappedAdapter(m) })
+	return r
+}
+
+// Register adds an adapter under name, replacing any already there
+func (r *AdapterRegistry) Register(name string, factory AdapterFactory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = factory
+}
+
+// New builds the adapter registered under name
+func (r *AdapterRegistry) New(name string, mapping FieldMapping) (Adapter, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %v", ErrUnknownAdapter, name, r.Names())
+	}
+
+	adapter, err := factory(mapping)
+	if err != nil {
+		return nil, fmt.Errorf("bank adapter %q: %w", name, err)
+	}
+	return adapter, nil
+}
+
+// Names lists the registered adapters
+func (r *AdapterRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SimulatorAdapter speaks the bank simulator's format, BankRequest and
+// BankResponse
+type SimulatorAdapter struct{}
+
+func (SimulatorAdapter) AuthorizationPath() string {
+	return "/payments"
+}
+
+func (SimulatorAdapter) EncodeAuthorization(auth *CardAuthorization) ([]byte, error) {
+	return json.Marshal(&BankRequest{
+		CardNumber:       auth.CardNumber,
+		ExpiryDate:       fmt.Sprintf("%02d/%d", auth.ExpiryMonth, auth.ExpiryYear),
+		Currency:         auth.Currency,
+		Amount:           auth.Amount,
+		CVV:              auth.CVV,
+		Initiator:        auth.Initiator,
+		StoredCredential: auth.StoredCredential,
+	})
+}
+
+func (SimulatorAdapter) DecodeAuthorization(status int, body []byte) (*BankResponse, error) {
+	if status != http.StatusOK {
+		return nil, statusError(status, body)
+	}
+
+	var bankResp BankResponse
+	if err := json.Unmarshal(body, &bankResp); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal bank response: %v", ErrBankUnexpectedResponse, err)
+	}
+	return &bankResp, nil
+}
+
+// SettlementPath is /payments/{code}/capture, void or refund
+func (SimulatorAdapter) SettlementPath(s *Settlement) string {
+	return "/payments/" + url.PathEscape(s.AuthorizationCode) + "/" + string(s.Type)
+}
+
+func (SimulatorAdapter) EncodeSettlement(s *Settlement) ([]byte, error) {
+	return json.Marshal(&SettlementRequest{Amount: s.Amount})
+}
+
+func (SimulatorAdapter) DecodeSettlement(status int, body []byte) error {
+	switch status {
+	case http.StatusOK:
+		return nil
+	// The bank has no such authorization, or it is in no state to be
+	// settled as asked
+	case http.StatusNotFound, http.StatusConflict:
+		return fmt.Errorf("%w: %s", ErrSettlementRefused, quoteBody(body))
+	default:
+		return statusError(status, body)
+	}
+}
+
+// maxErrorBody is how much of a bank's answer an error quotes
+const maxErrorBody = 256
+
+// statusError is the error for a bank answer that is not a decision: 400
+// means the request was malformed, 503 that the bank could not take it
+func statusError(status int, body []byte) error {
+	switch status {
+	case http.StatusBadRequest:
+		return fmt.Errorf("%w: %s", ErrBankRejected, quoteBody(body))
+	case http.StatusServiceUnavailable:
+		return ErrBankUnavailable
+	default:
+		return fmt.Errorf("%w: %d - %s", ErrBankUnexpectedResponse, status, quoteBody(body))
+	}
+}
+
+// quoteBody is the start of a bank's answer, enough to tell what went wrong
+// without carrying a whole page, or whatever the bank echoed back, into
+// logs and spans
+func quoteBody(body []byte) string {
+	if len(body) <= maxErrorBody {
+		return string(body)
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(string(body[:maxErrorBody]), ""), len(body))
+}
@@ -0,0 +1,186 @@
+// Package adaptertest is the contract every client.Adapter must meet. An
+// adapter's tests call Run with the adapter and an Acquirer: the bank's
+// side of the same format, written separately from the adapter so the two
+// check each other.
+package adaptertest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Acquirer is the bank's side of an adapter's format
+type Acquirer interface {
+	// DecodeAuthorization reads a request body the way the acquirer would
+	DecodeAuthorization(body []byte) (*client.CardAuthorization, error)
+	// EncodeDecision writes the acquirer's answer to an authorization
+	EncodeDecision(resp *client.BankResponse) []byte
+}
+
+// authorizations cover every field an adapter must carry
+var authorizations = map[string]*client.CardAuthorization{
+	"customer initiated": {
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  2030,
+		CVV:         "123",
+		Currency:    "GBP",
+		Amount:      1050,
+	},
+	"merchant initiated without CVV": {
+		CardNumber:  "4111111111111111",
+		ExpiryMonth: 12,
+		ExpiryYear:  2031,
+		Currency:    "USD",
+		Amount:      1,
+		Initiator:   string(domain.InitiatorMerchant),
+		StoredCredential: &client.BankStoredCredential{
+			Usage:                        string(domain.StoredCredentialSubsequent),
+			Type:                         string(domain.StoredCredentialRecurring),
+			PreviousNetworkTransactionID: "ntid-123",
+		},
+	},
+	"large amount and leading zero CVV": {
+		CardNumber:  "5555555555554444",
+		ExpiryMonth: 1,
+		ExpiryYear:  2029,
+		CVV:         "012",
+		Currency:    "EUR",
+		Amount:      99999999,
+	},
+}
+
+// Run checks adapter against the contract
+func Run(t *testing.T, adapter client.Adapter, acquirer Acquirer) {
+	t.Run("authorization path", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(adapter.AuthorizationPath(), "/"), "path %q must start with /", adapter.AuthorizationPath())
+	})
+
+	t.Run("encodes every field", func(t *testing.T) {
+		for name, auth := range authorizations {
+			t.Run(name, func(t *testing.T) {
+				body, err := adapter.EncodeAuthorization(auth)
+				require.NoError(t, err)
+
+				got, err := acquirer.DecodeAuthorization(body)
+				require.NoError(t, err, "acquirer could not read %s", body)
+				assert.Equal(t, auth, got)
+
+				// Recorded traffic is only replayable if encoding is stable
+				again, err := adapter.EncodeAuthorization(auth)
+				require.NoError(t, err)
+				assert.Equal(t, string(body), string(again))
+			})
+		}
+	})
+
+	t.Run("decodes decisions", func(t *testing.T) {
+		for _, resp := range []*client.BankResponse{
+			{Authorized: true, AuthorizationCode: "auth-123", NetworkTransactionID: "ntid-456"},
+			{Authorized: true, AuthorizationCode: "auth-789"},
+			{Authorized: false},
+		} {
+			got, err := adapter.DecodeAuthorization(http.StatusOK, acquirer.EncodeDecision(resp))
+			require.NoError(t, err)
+			assert.Equal(t, resp, got)
+		}
+	})
+
+	t.Run("maps failures", func(t *testing.T) {
+		for _, tt := range []struct {
+			status   int
+			body     string
+			expected error
+		}{
+			{http.StatusBadRequest, `{"error":"missing field"}`, client.ErrBankRejected},
+			{http.StatusServiceUnavailable, `{}`, client.ErrBankUnavailable},
+			{http.StatusInternalServerError, `{}`, client.ErrBankUnexpectedResponse},
+			{http.StatusOK, `{"authorized":`, client.ErrBankUnexpectedResponse},
+			{http.StatusOK, `not json`, client.ErrBankUnexpectedResponse},
+		} {
+			resp, err := adapter.DecodeAuthorization(tt.status, []byte(tt.body))
+			assert.Nil(t, resp)
+			assert.ErrorIs(t, err, tt.expected, "%d %s", tt.status, tt.body)
+		}
+	})
+
+	t.Run("over HTTP", func(t *testing.T) {
+		bank := newBank(adapter, acquirer)
+		defer bank.Close()
+
+		bankClient := client.NewHTTPBankClient(bank.URL, client.WithAdapter(adapter))
+		for _, tt := range []struct {
+			amount   int
+			expected client.BankResponse
+		}{
+			{amount: 100, expected: client.BankResponse{Authorized: true, AuthorizationCode: "auth-GBP"}},
+			{amount: 9000, expected: client.BankResponse{Authorized: false}},
+		} {
+			resp, err := bankClient.ProcessPayment(context.Background(), &domain.Payment{
+				Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+				Currency: "GBP",
+				Amount:   tt.amount,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, *resp)
+		}
+	})
+	t.Run("keeps card data out of recordings", func(t *testing.T) {
+		bank := newBank(adapter, acquirer)
+		defer bank.Close()
+
+		recorder := client.NewRecorder(client.NewHTTPBankClient(bank.URL, client.WithAdapter(adapter)))
+		for _, auth := range authorizations {
+			_, err := recorder.ProcessPayment(context.Background(), &domain.Payment{
+				Card:     domain.Card{Number: auth.CardNumber, ExpiryMonth: auth.ExpiryMonth, ExpiryYear: auth.ExpiryYear, CVV: auth.CVV},
+				Currency: auth.Currency,
+				Amount:   auth.Amount,
+			})
+			require.NoError(t, err)
+		}
+
+		cassette, err := json.Marshal(recorder.Cassette())
+		require.NoError(t, err)
+		for _, auth := range authorizations {
+			assert.NotContains(t, string(cassette), auth.CardNumber)
+			if auth.CVV != "" {
+				assert.NotContains(t, string(cassette), `"`+auth.CVV+`"`)
+			}
+		}
+	})
+}
+
+// newBank serves the acquirer's side of the format, authorizing amounts
+// under 5000
+func newBank(adapter client.Adapter, acquirer Acquirer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != adapter.AuthorizationPath() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		auth, err := acquirer.DecodeAuthorization(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		authorized := auth.Amount < 5000
+		resp := &client.BankResponse{Authorized: authorized}
+		if authorized {
+			resp.AuthorizationCode = "auth-" + auth.Currency
+		}
+		w.Write(acquirer.EncodeDecision(resp))
+	}))
+}
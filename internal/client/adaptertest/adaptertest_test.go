@@ -0,0 +1,176 @@
+package adaptertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/stretchr/testify/require"
+)
+
+// simulatorBank reads the simulator's format as the bank does, without
+// going through client.BankRequest
+type simulatorBank struct{}
+
+func (simulatorBank) DecodeAuthorization(body []byte) (*client.CardAuthorization, error) {
+	var req struct {
+		CardNumber       string `json:"card_number"`
+		ExpiryDate       string `json:"expiry_date"`
+		Currency         string `json:"currency"`
+		Amount           int    `json:"amount"`
+		CVV              string `json:"cvv"`
+		Initiator        string `json:"initiator"`
+		StoredCredential *struct {
+			Usage                        string `json:"usage"`
+			Type                         string `json:"type"`
+			PreviousNetworkTransactionID string `json:"previous_network_transaction_id"`
+		} `json:"stored_credential"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	auth := &client.CardAuthorization{
+		CardNumber: req.CardNumber,
+		Currency:   req.Currency,
+		Amount:     req.Amount,
+		CVV:        req.CVV,
+		Initiator:  req.Initiator,
+	}
+	if _, err := fmt.Sscanf(req.ExpiryDate, "%02d/%04d", &auth.ExpiryMonth, &auth.ExpiryYear); err != nil {
+		return nil, fmt.Errorf("expiry_date %q: %w", req.ExpiryDate, err)
+	}
+	if sc := req.StoredCredential; sc != nil {
+		auth.StoredCredential = &client.BankStoredCredential{Usage: sc.Usage, Type: sc.Type, PreviousNetworkTransactionID: sc.PreviousNetworkTransactionID}
+	}
+	return auth, nil
+}
+
+func (simulatorBank) EncodeDecision(resp *client.BankResponse) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"authorized":             resp.Authorized,
+		"authorization_code":     resp.AuthorizationCode,
+		"network_transaction_id": resp.NetworkTransactionID,
+	})
+	return body
+}
+
+// secondAcquirer nests the card, sends minor units as a string with a two
+// digit expiry year and answers with ISO response codes
+type secondAcquirer struct{}
+
+var secondAcquirerMapping = client.FieldMapping{
+	Path: "/v2/authorizations",
+	Request: client.RequestMapping{
+		CardNumber:                   "card.pan",
+		ExpiryDate:                   "card.expiry",
+		ExpiryFormat:                 "MM/YY",
+		CVV:                          "card.cvc",
+		Currency:                     "amount.currency",
+		Amount:                       "amount.value",
+		AmountFormat:                 client.AmountString,
+		Initiator:                    "initiated_by",
+		StoredCredentialUsage:        "stored_credential.usage",
+		StoredCredentialType:         "stored_credential.type",
+		PreviousNetworkTransactionID: "stored_credential.previous_transaction_id",
+	},
+	Response: client.ResponseMapping{
+		Authorized:           "response_code",
+		ApprovedValue:        "00",
+		AuthorizationCode:    "approval_code",
+		NetworkTransactionID: "network.transaction_id",
+	},
+}
+
+func (secondAcquirer) DecodeAuthorization(body []byte) (*client.CardAuthorization, error) {
+	var req struct {
+		Card struct {
+			PAN    string `json:"pan"`
+			Expiry string `json:"expiry"`
+			CVC    string `json:"cvc"`
+		} `json:"card"`
+		Amount struct {
+			Value    string `json:"value"`
+			Currency string `json:"currency"`
+		} `json:"amount"`
+		InitiatedBy      string `json:"initiated_by"`
+		StoredCredential *struct {
+			Usage                 string `json:"usage"`
+			Type                  string `json:"type"`
+			PreviousTransactionID string `json:"previous_transaction_id"`
+		} `json:"stored_credential"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	amount, err := strconv.Atoi(req.Amount.Value)
+	if err != nil {
+		return nil, fmt.Errorf("amount.value %q: %w", req.Amount.Value, err)
+	}
+	auth := &client.CardAuthorization{
+		CardNumber: req.Card.PAN,
+		CVV:        req.Card.CVC,
+		Currency:   req.Amount.Currency,
+		Amount:     amount,
+		Initiator:  req.InitiatedBy,
+	}
+	if _, err := fmt.Sscanf(req.Card.Expiry, "%02d/%02d", &auth.ExpiryMonth, &auth.ExpiryYear); err != nil {
+		return nil, fmt.Errorf("card.expiry %q: %w", req.Card.Expiry, err)
+	}
+	auth.ExpiryYear += 2000
+	if sc := req.StoredCredential; sc != nil {
+		auth.StoredCredential = &client.BankStoredCredential{Usage: sc.Usage, Type: sc.Type, PreviousNetworkTransactionID: sc.PreviousTransactionID}
+	}
+	return auth, nil
+}
+
+func (secondAcquirer) EncodeDecision(resp *client.BankResponse) []byte {
+	answer := map[string]any{"response_code": "05"}
+	if resp.Authorized {
+		answer["response_code"] = "00"
+		answer["approval_code"] = resp.AuthorizationCode
+	}
+	if resp.NetworkTransactionID != "" {
+		answer["network"] = map[string]any{"transaction_id": resp.NetworkTransactionID}
+	}
+	body, _ := json.Marshal(answer)
+	return body
+}
+
+func TestSimulatorAdapter(t *testing.T) {
+	Run(t, client.SimulatorAdapter{}, simulatorBank{})
+}
+
+// TestMappedAdapter_SimulatorFormat checks a mapping can describe the
+// simulator's own format
+func TestMappedAdapter_SimulatorFormat(t *testing.T) {
+	adapter, err := client.NewMappedAdapter(client.FieldMapping{
+		Request: client.RequestMapping{
+			CardNumber:                   "card_number",
+			ExpiryDate:                   "expiry_date",
+			Currency:                     "currency",
+			Amount:                       "amount",
+			CVV:                          "cvv",
+			Initiator:                    "initiator",
+			StoredCredentialUsage:        "stored_credential.usage",
+			StoredCredentialType:         "stored_credential.type",
+			PreviousNetworkTransactionID: "stored_credential.previous_network_transaction_id",
+		},
+		Response: client.ResponseMapping{
+			Authorized:           "authorized",
+			AuthorizationCode:    "authorization_code",
+			NetworkTransactionID: "network_transaction_id",
+		},
+	})
+	require.NoError(t, err)
+	Run(t, adapter, simulatorBank{})
+}
+
+func TestMappedAdapter_SecondAcquirer(t *testing.T) {
+	adapter, err := client.DefaultAdapters().New(client.AdapterMapped, secondAcquirerMapping)
+	require.NoError(t, err)
+	Run(t, adapter, secondAcquirer{})
+}
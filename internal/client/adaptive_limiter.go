@@ -0,0 +1,254 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+const (
+	defaultInitialLimit     = 20
+	defaultMinLimit         = 2
+	defaultMaxLimit         = 200
+	defaultLatencyThreshold = 2 * time.Second
+	defaultBackoffRatio     = 0.75
+	defaultMaxQueueWait     = time.Second
+	defaultMaxQueueLength   = 100
+)
+
+// AdaptiveLimiter limits the calls in flight to the bank to what it can
+// currently handle, found by additive increase, multiplicative decrease:
+// the limit grows by one for about every limit calls that succeed quickly,
+// and is cut by the backoff ratio when a call fails in a way that suggests
+// the bank is struggling or takes longer than the latency threshold.
+//
+// Calls over the limit wait in a queue for at most the maximum wait. Calls
+// that cannot be served in time, or find the queue full, fail straight away
+// with domain.ErrBankOverloaded, so a slow bank sheds load instead of
+// piling up goroutines waiting on it.
+type AdaptiveLimiter struct {
+	next             BankClient
+	minLimit         float64
+	maxLimit         float64
+	latencyThreshold time.Duration
+	backoffRatio     float64
+	maxQueueWait     time.Duration
+	maxQueueLength   int
+
+	mu        sync.Mutex
+	limit     float64
+	inFlight  int
+	queue     []chan struct{}
+	shed      uint64
+	decreased time.Time
+}
+
+// AdaptiveLimiterOption configures an AdaptiveLimiter
+type AdaptiveLimiterOption func(*AdaptiveLimiter)
+
+// WithLimits sets the starting limit and the bounds it adapts within
+func WithLimits(initial, min, max int) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.limit = float64(initial)
+		l.minLimit = float64(min)
+		l.maxLimit = float64(max)
+	}
+}
+
+// WithLatencyThreshold sets how long a call may take before it counts as a
+// sign the bank is overloaded
+func WithLatencyThreshold(d time.Duration) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.latencyThreshold = d
+	}
+}
+
+// WithBackoffRatio sets what the limit is multiplied by when the bank
+// struggles
+func WithBackoffRatio(r float64) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.backoffRatio = r
+	}
+}
+
+// WithQueue sets how many calls may wait for a slot and for how long
+func WithQueue(maxLength int, maxWait time.Duration) AdaptiveLimiterOption {
+	return func(l *AdaptiveLimiter) {
+		l.maxQueueLength = maxLength
+		l.maxQueueWait = maxWait
+	}
+}
+
+func NewAdaptiveLimiter(next BankClient, opts ...AdaptiveLimiterOption) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		next:             next,
+		limit:            defaultInitialLimit,
+		minLimit:         defaultMinLimit,
+		maxLimit:         defaultMaxLimit,
+		latencyThreshold: defaultLatencyThreshold,
+		backoffRatio:     defaultBackoffRatio,
+		maxQueueWait:     defaultMaxQueueWait,
+		maxQueueLength:   defaultMaxQueueLength,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+func (l *AdaptiveLimiter) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	if err := l.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := l.next.ProcessPayment(ctx, payment)
+	l.release(start, err)
+
+	return resp, err
+}
+
+// Settle waits for a slot like a payment, and its answer moves the limit
+// the same way
+func (l *AdaptiveLimiter) Settle(ctx context.Context, settlement *Settlement) error {
+	settler, ok := l.next.(Settler)
+	if !ok {
+		return domain.ErrSettlementUnsupported
+	}
+
+	if err := l.acquire(ctx); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := settler.Settle(ctx, settlement)
+	l.release(start, err)
+
+	return err
+}
+
+// Limit returns the current limit on calls in flight
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the calls waiting on the bank
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the calls waiting for a slot
+func (l *AdaptiveLimiter) Queued() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// Shed returns how many calls have been turned away since the limiter was
+// created
+func (l *AdaptiveLimiter) Shed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.shed
+}
+
+// Ping asks the wrapped client if it can
+func (l *AdaptiveLimiter) Ping(ctx context.Context) error {
+	if p, ok := l.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (l *AdaptiveLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	// Calls already queued go first
+	if len(l.queue) == 0 && l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.queue) >= l.maxQueueLength {
+		l.shed++
+		l.mu.Unlock()
+		return domain.ErrBankOverloaded
+	}
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.maxQueueWait)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = domain.ErrBankOverloaded
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// A slot may have been handed over while giving up
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+
+	for i, waiting := range l.queue {
+		if waiting == ready {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			break
+		}
+	}
+	if errors.Is(err, domain.ErrBankOverloaded) {
+		l.shed++
+	}
+	return err
+}
+
+func (l *AdaptiveLimiter) release(start time.Time, err error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Only grow a limit that is being used, so a quiet spell does not leave
+	// it far above what the bank has shown it can take
+	saturated := float64(l.inFlight+len(l.queue)) >= l.limit/2
+	l.inFlight--
+
+	switch {
+	case isBankFailure(err), err == nil && now.Sub(start) > l.latencyThreshold:
+		// Calls sent before the last cut were sent under the old limit, so
+		// a slow bank cuts the limit once rather than once per call
+		if start.After(l.decreased) {
+			l.limit = math.Max(l.minLimit, l.limit*l.backoffRatio)
+			l.decreased = now
+		}
+	case err == nil && saturated:
+		l.limit = math.Min(l.maxLimit, l.limit+1/l.limit)
+	}
+
+	// Hand freed slots, and any the limit grew by, to the queue in order
+	for len(l.queue) > 0 && l.inFlight < int(l.limit) {
+		close(l.queue[0])
+		l.queue = l.queue[1:]
+		l.inFlight++
+	}
+}
@@ -0,0 +1,178 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowBank authorizes every payment after a delay
+type slowBank struct {
+	delay time.Duration
+}
+
+func (b slowBank) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	time.Sleep(b.delay)
+	return &BankResponse{Authorized: true}, nil
+}
+
+// startPayments sends n payments through l in the background and waits
+// until the bank has them all
+func startPayments(t *testing.T, l *AdaptiveLimiter, bank *blockingBank, n int) chan error {
+	done := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := l.ProcessPayment(context.Background(), &domain.Payment{})
+			done <- err
+		}()
+		<-bank.entered
+	}
+	return done
+}
+
+func TestAdaptiveLimiter_GrowsWhileFastAndSaturated(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(4, 1, 6))
+
+	// Rounds of as many calls as the limit allows
+	for round := 0; round < 10; round++ {
+		n := limiter.Limit()
+		done := startPayments(t, limiter, bank, n)
+		for i := 0; i < n; i++ {
+			bank.release <- struct{}{}
+			require.NoError(t, <-done)
+		}
+	}
+	assert.Equal(t, 6, limiter.Limit(), "the limit should grow to its maximum")
+
+	// One call at a time never uses half of the limit
+	quiet := NewAdaptiveLimiter(&scriptedBank{}, WithLimits(10, 1, 100))
+	for i := 0; i < 100; i++ {
+		_, err := quiet.ProcessPayment(context.Background(), &domain.Payment{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 10, quiet.Limit(), "a limit that is not used should not grow")
+}
+
+func TestAdaptiveLimiter_CutsOnceForSlowCalls(t *testing.T) {
+	limiter := NewAdaptiveLimiter(slowBank{delay: 20 * time.Millisecond},
+		WithLimits(8, 2, 8),
+		WithLatencyThreshold(10*time.Millisecond),
+		WithBackoffRatio(0.5),
+	)
+
+	// Eight slow calls sent together cut the limit once, not eight times
+	done := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+			done <- err
+		}()
+	}
+	for i := 0; i < 8; i++ {
+		require.NoError(t, <-done)
+	}
+	assert.Equal(t, 4, limiter.Limit())
+
+	// Later slow calls keep cutting it, down to the minimum
+	for i := 0; i < 3; i++ {
+		_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, limiter.Limit())
+}
+
+func TestAdaptiveLimiter_CutsOnBankFailure(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrBankUnavailable, ErrBankRejected}}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(10, 1, 10), WithBackoffRatio(0.5))
+
+	_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, ErrBankUnavailable)
+	assert.Equal(t, 5, limiter.Limit())
+
+	// A request the bank rejected says nothing about its load
+	_, err = limiter.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, ErrBankRejected)
+	assert.Equal(t, 5, limiter.Limit())
+}
+
+func TestAdaptiveLimiter_QueuesUpToMaxWait(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(1, 1, 1), WithQueue(10, time.Second))
+
+	first := startPayments(t, limiter, bank, 1)
+
+	// The second call waits for the first to finish
+	second := make(chan error, 1)
+	go func() {
+		_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+		second <- err
+	}()
+	require.Eventually(t, func() bool { return limiter.Queued() == 1 }, time.Second, time.Millisecond)
+
+	bank.release <- struct{}{}
+	require.NoError(t, <-first)
+	<-bank.entered
+	assert.Equal(t, 1, limiter.InFlight())
+	assert.Equal(t, 0, limiter.Queued())
+
+	bank.release <- struct{}{}
+	require.NoError(t, <-second)
+	assert.Equal(t, uint64(0), limiter.Shed())
+}
+
+func TestAdaptiveLimiter_ShedsWhenWaitRunsOut(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(1, 1, 1), WithQueue(10, 20*time.Millisecond))
+
+	done := startPayments(t, limiter, bank, 1)
+	defer func() {
+		close(bank.release)
+		require.NoError(t, <-done)
+	}()
+
+	start := time.Now()
+	_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, domain.ErrBankOverloaded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, uint64(1), limiter.Shed())
+	assert.Equal(t, 0, limiter.Queued())
+}
+
+func TestAdaptiveLimiter_ShedsWhenQueueIsFull(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(1, 1, 1), WithQueue(0, time.Minute))
+
+	done := startPayments(t, limiter, bank, 1)
+	defer func() {
+		close(bank.release)
+		require.NoError(t, <-done)
+	}()
+
+	start := time.Now()
+	_, err := limiter.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, domain.ErrBankOverloaded)
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "a full queue should shed without waiting")
+}
+
+func TestAdaptiveLimiter_CallerGivesUpWhileQueued(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewAdaptiveLimiter(bank, WithLimits(1, 1, 1), WithQueue(10, time.Minute))
+
+	done := startPayments(t, limiter, bank, 1)
+	defer func() {
+		close(bank.release)
+		require.NoError(t, <-done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := limiter.ProcessPayment(ctx, &domain.Payment{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, uint64(0), limiter.Shed(), "the caller gave up, nothing was shed")
+	assert.Equal(t, 0, limiter.Queued())
+}
@@ -2,105 +2,479 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // BankClient defines the interface for communicating with the acquiring bank
 type BankClient interface {
-	ProcessPayment(payment *domain.Payment) (*BankResponse, error)
+	ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error)
+}
+
+// Pinger is implemented by bank clients that can check the bank is
+// reachable without sending it a payment
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Settler is implemented by bank clients that can capture, void and refund
+// the authorizations the bank gave
+type Settler interface {
+	Settle(ctx context.Context, settlement *Settlement) error
+}
+
+var (
+	// ErrBankRejected means the bank refused the request as malformed
+	ErrBankRejected = errors.New("bank rejected request")
+	// ErrBankUnavailable means the bank could not take the request right now
+	ErrBankUnavailable = errors.New("bank service unavailable")
+	// ErrBankUnexpectedResponse means the bank answered with something the
+	// client does not understand
+	ErrBankUnexpectedResponse = errors.New("unexpected response from bank")
+	// ErrSettlementRefused means the bank would not capture, void or
+	// refund the authorization as asked
+	ErrSettlementRefused = errors.New("bank refused settlement")
+)
+
+// ErrorClass groups a bank call error into a small set of classes suitable
+// for metric labels and alerting: "timeout", "connection", "unavailable",
+// "rejected", "unexpected_response", "circuit_open", "concurrency_limit",
+// "overloaded" or "other". A nil error has class "none".
+func ErrorClass(err error) string {
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return "none"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, domain.ErrConcurrencyLimitExceeded):
+		return "concurrency_limit"
+	case errors.Is(err, domain.ErrBankOverloaded):
+		return "overloaded"
+	case errors.Is(err, ErrBankRejected), errors.Is(err, ErrSettlementRefused):
+		return "rejected"
+	case errors.Is(err, ErrBankUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrBankUnexpectedResponse):
+		return "unexpected_response"
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, new(*net.OpError)), errors.As(err, new(*url.Error)):
+		return "connection"
+	default:
+		return "other"
+	}
+}
+
+// OutcomeUnknown reports whether a payment may have reached the bank without
+// its answer reaching the gateway, e.g. because the call timed out or the
+// connection dropped after it was made. Sending such a payment again could
+// charge the card twice, so it has to be reconciled with the bank instead.
+func OutcomeUnknown(err error) bool {
+	var opErr *net.OpError
+	switch ErrorClass(err) {
+	case "timeout":
+		return true
+	case "connection":
+		// A connection that was never made never carried the payment
+		return !errors.As(err, &opErr) || opErr.Op != "dial"
+	default:
+		return false
+	}
+}
+
+// NotSent reports whether a payment was turned away before it reached the
+// bank: by the circuit breaker or a concurrency limit, by a bank that said
+// it could not take it, or because the connection was never made. Sending
+// it again cannot charge the card twice.
+func NotSent(err error) bool {
+	var opErr *net.OpError
+	return errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, domain.ErrConcurrencyLimitExceeded) ||
+		errors.Is(err, domain.ErrBankOverloaded) ||
+		errors.Is(err, ErrBankUnavailable) ||
+		errors.As(err, &opErr) && opErr.Op == "dial"
 }
 
 // BankRequest represents the request format expected by the bank simulator
 type BankRequest struct {
-	CardNumber string `json:"card_number"`
-	ExpiryDate string `json:"expiry_date"`
-	Currency   string `json:"currency"`
-	Amount     int    `json:"amount"`
-	CVV        string `json:"cvv"`
+	CardNumber       string                `json:"card_number"`
+	ExpiryDate       string                `json:"expiry_date"`
+	Currency         string                `json:"currency"`
+	Amount           int                   `json:"amount"`
+	CVV              string                `json:"cvv"`
+	Initiator        string                `json:"initiator,omitempty"`
+	StoredCredential *BankStoredCredential `json:"stored_credential,omitempty"`
+}
+
+// SettlementRequest is the body of a capture, void or refund sent to the
+// bank simulator. Without an amount the whole of what remains is captured
+// or refunded.
+type SettlementRequest struct {
+	Amount int `json:"amount,omitempty"`
+}
+
+// BankStoredCredential carries the stored credential flags to the bank
+type BankStoredCredential struct {
+	Usage                        string `json:"usage"`
+	Type                         string `json:"type"`
+	PreviousNetworkTransactionID string `json:"previous_network_transaction_id,omitempty"`
 }
 
 // BankResponse represents the response from the bank simulator
 type BankResponse struct {
-	Authorized        bool   `json:"authorized"`
-	AuthorizationCode string `json:"authorization_code"`
+	Authorized           bool   `json:"authorized"`
+	AuthorizationCode    string `json:"authorization_code"`
+	NetworkTransactionID string `json:"network_transaction_id,omitempty"`
+	// DeclineReason is set when the bank said why it declined
+	DeclineReason domain.DeclineReason `json:"decline_reason,omitempty"`
+}
+
+// Detokenizer resolves a vault token back to the full card details
+type Detokenizer interface {
+	Detokenize(token string) (*domain.Card, error)
+}
+
+// DefaultTimeout bounds a single request to the bank unless WithTimeout
+// says otherwise
+const DefaultTimeout = 10 * time.Second
+
+// RetryPolicy controls how often a payment is resent to the bank.
+//
+// Only failures where the bank cannot have processed the payment are
+// retried: the connection could not be made, or the bank answered 503.
+// Timeouts are never retried, since the bank may have authorized the
+// payment and a second attempt could charge the card twice.
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, so 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. It doubles on
+	// every further retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// backoff returns the wait after the given failed attempt, counting from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
 }
 
 // HTTPBankClient is an HTTP implementation of BankClient
 type HTTPBankClient struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL     string
+	transport   http.RoundTripper
+	httpClient  *http.Client
+	adapter     Adapter
+	detokenizer Detokenizer
+	observer    Observer
+
+	mu      sync.RWMutex // Guards timeout and retry, which can be reloaded
+	timeout time.Duration
+	retry   RetryPolicy
+}
+
+// Observer is told about every request sent to the bank, for example to
+// record metrics
+type Observer interface {
+	BankCallStarted()
+	BankCallFinished(duration time.Duration, err error)
+}
+
+// Option configures an HTTPBankClient
+type Option func(*HTTPBankClient)
+
+// WithDetokenizer lets the client send tokenized cards to the bank by
+// resolving them through the vault at request time
+func WithDetokenizer(d Detokenizer) Option {
+	return func(c *HTTPBankClient) {
+		c.detokenizer = d
+	}
+}
+
+// WithObserver reports every bank request to o
+func WithObserver(o Observer) Option {
+	return func(c *HTTPBankClient) {
+		c.observer = o
+	}
+}
+
+// WithTimeout bounds each request to the bank
+func WithTimeout(d time.Duration) Option {
+	return func(c *HTTPBankClient) {
+		c.timeout = d
+	}
+}
+
+// WithRetryPolicy retries payments the bank did not process. Without it
+// every payment is sent once.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *HTTPBankClient) {
+		c.retry = p
+	}
+}
+
+// WithAdapter speaks the acquirer's format through a. Without it the
+// client speaks the simulator's.
+func WithAdapter(a Adapter) Option {
+	return func(c *HTTPBankClient) {
+		c.adapter = a
+	}
+}
+
+// WithTransport sends requests to the bank through rt instead of the
+// default transport
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *HTTPBankClient) {
+		c.transport = rt
+	}
 }
 
 // NewHTTPBankClient creates a new HTTP bank client
-func NewHTTPBankClient(baseURL string) *HTTPBankClient {
-	return &HTTPBankClient{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+func NewHTTPBankClient(baseURL string, opts ...Option) *HTTPBankClient {
+	c := &HTTPBankClient{
+		baseURL:   baseURL,
+		transport: http.DefaultTransport,
+		adapter:   SimulatorAdapter{},
+		timeout:   DefaultTimeout,
+		retry:     RetryPolicy{MaxAttempts: 1},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.httpClient = &http.Client{Transport: tracing.Transport(c.transport)}
+	return c
+}
+
+// Configure replaces the timeout and retry policy. It is safe to call while
+// payments are in flight; they finish with the settings they started with.
+func (c *HTTPBankClient) Configure(timeout time.Duration, retry RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+	c.retry = retry
+}
+
+func (c *HTTPBankClient) settings() (time.Duration, RetryPolicy) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.timeout, c.retry
+}
+
+// ProcessPayment asks the bank to authorize the payment, retrying as the
+// retry policy allows. The trace in ctx is passed on to the bank in a
+// traceparent header.
+func (c *HTTPBankClient) ProcessPayment(ctx context.Context, payment *domain.Payment) (_ *BankResponse, err error) {
+	ctx, span := tracing.Start(ctx, "HTTPBankClient.ProcessPayment",
+		trace.WithAttributes(tracing.PaymentAttributes(payment)...))
+	defer func() { tracing.End(span, err) }()
+
+	auth, err := cardAuthorization(payment, c.detokenizer)
+	if err != nil {
+		return nil, err
 	}
+	// A Recorder or ReplayClient further up the chain takes these values
+	// out of what went over the wire, wherever the adapter put them
+	ctx = withCardData(ctx, auth)
+
+	jsonData, err := c.adapter.EncodeAuthorization(auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bank request: %w", err)
+	}
+
+	var bankResp *BankResponse
+	err = c.call(ctx, c.adapter.AuthorizationPath(), jsonData, func(status int, body []byte) (err error) {
+		bankResp, err = c.adapter.DecodeAuthorization(status, body)
+		return err
+	})
+	return bankResp, err
 }
 
-func (c *HTTPBankClient) ProcessPayment(payment *domain.Payment) (*BankResponse, error) {
-	bankReq := c.convertTobankRequest(payment)
+// Settle asks the bank to capture, void or refund an authorization it gave,
+// retrying as the retry policy allows. It fails with
+// domain.ErrSettlementUnsupported if the adapter cannot send settlements.
+func (c *HTTPBankClient) Settle(ctx context.Context, settlement *Settlement) (err error) {
+	ctx, span := tracing.Start(ctx, "HTTPBankClient.Settle")
+	defer func() { tracing.End(span, err) }()
+
+	adapter, ok := c.adapter.(SettlementAdapter)
+	if !ok {
+		return domain.ErrSettlementUnsupported
+	}
 
-	jsonData, err := json.Marshal(bankReq)
+	jsonData, err := adapter.EncodeSettlement(settlement)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal bank request: %w", err)
+		return fmt.Errorf("failed to encode bank request: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, c.baseURL+"/payments", bytes.NewBuffer(jsonData))
+	return c.call(ctx, adapter.SettlementPath(settlement), jsonData, adapter.DecodeSettlement)
+}
+
+// call POSTs body to path, retrying as the retry policy allows, and hands
+// the bank's answer to decode
+func (c *HTTPBankClient) call(ctx context.Context, path string, body []byte, decode func(status int, body []byte) error) error {
+	timeout, retry := c.settings()
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, timeout, path, body, decode)
+		if err == nil || attempt >= retry.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+
+		backoff := retry.backoff(attempt)
+		slog.InfoContext(ctx, "retrying bank call",
+			"attempt", attempt, "backoff", backoff, "error_class", ErrorClass(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// attempt sends the request to the bank once
+func (c *HTTPBankClient) attempt(ctx context.Context, timeout time.Duration, path string, body []byte, decode func(status int, body []byte) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+		return fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
+	// Lets the bank's records be matched to ours
+	if id := audit.RequestID(ctx); id != "" {
+		req.Header.Set(audit.RequestIDHeader, id)
+	}
+	if id := audit.CorrelationID(ctx); id != "" {
+		req.Header.Set(audit.CorrelationIDHeader, id)
+	}
+
+	if c.observer == nil {
+		return c.send(req, body, decode)
+	}
+
+	c.observer.BankCallStarted()
+	start := time.Now()
+	err = c.send(req, body, decode)
+	c.observer.BankCallFinished(time.Since(start), err)
+
+	return err
+}
+
+// isRetryable reports whether err shows the bank never processed the
+// payment, so sending it again cannot charge the card twice. Within a
+// single call that is a 503 or a connection that was never made.
+func isRetryable(err error) bool {
+	return NotSent(err)
+}
+
+// Ping checks the bank can be reached. Any HTTP answer will do, since the
+// bank has no health endpoint of its own.
+func (c *HTTPBankClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.baseURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request to bank: %w", err)
+		return fmt.Errorf("bank unreachable: %w", err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (c *HTTPBankClient) send(req *http.Request, reqBody []byte, decode func(status int, body []byte) error) error {
+	status, body, err := c.roundTrip(req)
+
+	// Lets a Recorder further up the chain see what went over the wire
+	if record := exchangeHookFrom(req.Context()); record != nil {
+		record(newExchange(req, reqBody, status, body, err))
+	}
+
+	if err != nil {
+		return err
+	}
+	return decode(status, body)
+}
+
+// roundTrip sends req and reads the whole answer
+func (c *HTTPBankClient) roundTrip(req *http.Request) (int, []byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to send request to bank: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return resp.StatusCode, body, fmt.Errorf("failed to read response body: %w", err)
 	}
+	return resp.StatusCode, body, nil
+}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var bankResp BankResponse
-		if err := json.Unmarshal(body, &bankResp); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal bank response: %w", err)
-		}
-		return &bankResp, nil
-
-	case http.StatusBadRequest:
-		return nil, fmt.Errorf("bank rejected request: %s", string(body))
+// cardAuthorization resolves the card and gathers what the bank needs
+func cardAuthorization(payment *domain.Payment, detokenizer Detokenizer) (*CardAuthorization, error) {
+	card := &payment.Card
 
-	case http.StatusServiceUnavailable:
-		return nil, fmt.Errorf("bank service unavailable")
+	// Tokenized cards are only turned back into a card number here, so the
+	// full number never lives on the payment itself
+	if card.IsTokenized() {
+		if detokenizer == nil {
+			return nil, fmt.Errorf("cannot send tokenized card to bank: no vault configured")
+		}
 
-	default:
-		return nil, fmt.Errorf("unexpected response from bank: %d - %s", resp.StatusCode, string(body))
+		stored, err := detokenizer.Detokenize(card.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to detokenize card: %w", err)
+		}
+		card = stored
 	}
-}
 
-func (c *HTTPBankClient) convertTobankRequest(payment *domain.Payment) *BankRequest {
-	// Format expiry date as MM/YYYY
-	expiryDate := fmt.Sprintf("%02d/%d", payment.Card.ExpiryMonth, payment.Card.ExpiryYear)
+	auth := &CardAuthorization{
+		CardNumber:  card.Number,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+		Currency:    payment.Currency,
+		Amount:      payment.Amount,
+		CVV:         payment.Card.CVV,
+		Initiator:   string(payment.Initiator),
+	}
 
-	return &BankRequest{
-		CardNumber: payment.Card.Number,
-		ExpiryDate: expiryDate,
-		Currency:   payment.Currency,
-		Amount:     payment.Amount,
-		CVV:        payment.Card.CVV,
+	if sc := payment.StoredCredential; sc != nil {
+		auth.StoredCredential = &BankStoredCredential{
+			Usage:                        string(sc.Usage),
+			Type:                         string(sc.Type),
+			PreviousNetworkTransactionID: sc.PreviousNetworkTransactionID,
+		}
 	}
+
+	return auth, nil
 }
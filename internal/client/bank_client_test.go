@@ -1,12 +1,19 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -52,7 +59,7 @@ func TestHTTPBankClient_ProcessPayment_Success_Authorized(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.NoError(t, err)
 	assert.True(t, resp.Authorized)
@@ -84,7 +91,7 @@ func TestHTTPBankClient_ProcessPayment_Success_Declined(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.NoError(t, err)
 	assert.False(t, resp.Authorized)
@@ -112,11 +119,31 @@ func TestHTTPBankClient_ProcessPayment_BadRequest(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "bank rejected request")
+	assert.ErrorIs(t, err, ErrBankRejected)
+	assert.Equal(t, "rejected", ErrorClass(err))
+}
+
+func TestHTTPBankClient_ProcessPayment_LongErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(strings.Repeat("x", 10000)))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPBankClient(server.URL).ProcessPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "1234567890123456", ExpiryMonth: 12, ExpiryYear: 2025, CVV: "123"},
+		Currency: "USD",
+		Amount:   1000,
+	})
+
+	require.ErrorIs(t, err, ErrBankUnexpectedResponse)
+	assert.Less(t, len(err.Error()), 400, "only the start of the body is quoted")
+	assert.Contains(t, err.Error(), "(10000 bytes)")
 }
 
 func TestHTTPBankClient_ProcessPayment_ServiceUnavailable(t *testing.T) {
@@ -139,11 +166,12 @@ func TestHTTPBankClient_ProcessPayment_ServiceUnavailable(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "bank service unavailable")
+	assert.Equal(t, "unavailable", ErrorClass(err))
 }
 
 func TestHTTPBankClient_ProcessPayment_InvalidJSON(t *testing.T) {
@@ -168,11 +196,28 @@ func TestHTTPBankClient_ProcessPayment_InvalidJSON(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "failed to unmarshal bank response")
+	assert.Equal(t, "unexpected_response", ErrorClass(err))
+}
+
+// encodeBankRequest builds the request the client would send the bank for
+// payment
+func encodeBankRequest(c *HTTPBankClient, payment *domain.Payment) (*BankRequest, error) {
+	auth, err := cardAuthorization(payment, c.detokenizer)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.adapter.EncodeAuthorization(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	var bankReq BankRequest
+	return &bankReq, json.Unmarshal(body, &bankReq)
 }
 
 func TestHTTPBankClient_ConvertToBankRequest(t *testing.T) {
@@ -189,7 +234,8 @@ func TestHTTPBankClient_ConvertToBankRequest(t *testing.T) {
 		Amount:   500,
 	}
 
-	bankReq := client.convertTobankRequest(payment)
+	bankReq, err := encodeBankRequest(client, payment)
+	require.NoError(t, err)
 
 	assert.Equal(t, "1234567890123456", bankReq.CardNumber)
 	assert.Equal(t, "04/2025", bankReq.ExpiryDate) // Month should be zero-padded
@@ -240,12 +286,102 @@ func TestHTTPBankClient_ExpiryDateFormatting(t *testing.T) {
 				Amount:   100,
 			}
 
-			bankReq := client.convertTobankRequest(payment)
+			bankReq, err := encodeBankRequest(client, payment)
+			require.NoError(t, err)
 			assert.Equal(t, tt.expected, bankReq.ExpiryDate)
 		})
 	}
 }
 
+func TestHTTPBankClient_ConvertToBankRequest_StoredCredential(t *testing.T) {
+	client := NewHTTPBankClient("http://localhost:8081")
+
+	payment := &domain.Payment{
+		Card: domain.Card{
+			Number:      "1234567890123456",
+			ExpiryMonth: 4,
+			ExpiryYear:  2025,
+		},
+		Currency:  "GBP",
+		Amount:    500,
+		Initiator: domain.InitiatorMerchant,
+		StoredCredential: &domain.StoredCredential{
+			Usage:                        domain.StoredCredentialSubsequent,
+			Type:                         domain.StoredCredentialRecurring,
+			PreviousNetworkTransactionID: "ntid-1",
+		},
+	}
+
+	bankReq, err := encodeBankRequest(client, payment)
+	require.NoError(t, err)
+
+	assert.Equal(t, "merchant", bankReq.Initiator)
+	assert.Empty(t, bankReq.CVV)
+	require.NotNil(t, bankReq.StoredCredential)
+	assert.Equal(t, "subsequent", bankReq.StoredCredential.Usage)
+	assert.Equal(t, "recurring", bankReq.StoredCredential.Type)
+	assert.Equal(t, "ntid-1", bankReq.StoredCredential.PreviousNetworkTransactionID)
+}
+
+type stubDetokenizer map[string]*domain.Card
+
+func (d stubDetokenizer) Detokenize(token string) (*domain.Card, error) {
+	card, ok := d[token]
+	if !ok {
+		return nil, domain.ErrTokenNotFound
+	}
+	return card, nil
+}
+
+func TestHTTPBankClient_ConvertToBankRequest_Tokenized(t *testing.T) {
+	vault := stubDetokenizer{
+		"tok_abc": {
+			Token:       "tok_abc",
+			Number:      "2222405343248877",
+			ExpiryMonth: 4,
+			ExpiryYear:  2030,
+			LastFour:    "8877",
+		},
+	}
+	client := NewHTTPBankClient("http://localhost:8081", WithDetokenizer(vault))
+
+	payment := &domain.Payment{
+		Card: domain.Card{
+			Token:       "tok_abc",
+			LastFour:    "8877",
+			ExpiryMonth: 4,
+			ExpiryYear:  2030,
+			CVV:         "123",
+		},
+		Currency: "GBP",
+		Amount:   500,
+	}
+
+	bankReq, err := encodeBankRequest(client, payment)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2222405343248877", bankReq.CardNumber)
+	assert.Equal(t, "04/2030", bankReq.ExpiryDate)
+	assert.Equal(t, "123", bankReq.CVV)
+	assert.Empty(t, payment.Card.Number) // The payment never gets the card number
+}
+
+func TestHTTPBankClient_ConvertToBankRequest_TokenErrors(t *testing.T) {
+	payment := &domain.Payment{
+		Card:     domain.Card{Token: "tok_missing", CVV: "123"},
+		Currency: "GBP",
+		Amount:   500,
+	}
+
+	_, err := encodeBankRequest(NewHTTPBankClient("http://localhost:8081"), payment)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no vault configured")
+
+	_, err = encodeBankRequest(NewHTTPBankClient("http://localhost:8081", WithDetokenizer(stubDetokenizer{})), payment)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, domain.ErrTokenNotFound)
+}
+
 func TestHTTPBankClient_Timeout(t *testing.T) {
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -267,9 +403,320 @@ func TestHTTPBankClient_Timeout(t *testing.T) {
 		Amount:   1000,
 	}
 
-	resp, err := client.ProcessPayment(payment)
+	resp, err := client.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "failed to send request to bank")
+	assert.Equal(t, "timeout", ErrorClass(err))
+}
+
+func TestHTTPBankClient_ForwardsRequestAndCorrelationIDs(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		json.NewEncoder(w).Encode(BankResponse{Authorized: true})
+	}))
+	defer server.Close()
+
+	ctx := audit.WithRequestID(context.Background(), "req-1")
+	ctx = audit.WithCorrelationID(ctx, "order-42")
+
+	_, err := NewHTTPBankClient(server.URL).ProcessPayment(ctx, &domain.Payment{
+		Card:     domain.Card{Number: "1234567890123456", ExpiryMonth: 12, ExpiryYear: 2025, CVV: "123"},
+		Currency: "USD",
+		Amount:   1000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "req-1", headers.Get("X-Request-ID"))
+	assert.Equal(t, "order-42", headers.Get("X-Correlation-ID"))
+}
+
+type recordingObserver struct {
+	started  int
+	finished []error
+}
+
+func (o *recordingObserver) BankCallStarted() {
+	o.started++
+}
+
+func (o *recordingObserver) BankCallFinished(_ time.Duration, err error) {
+	o.finished = append(o.finished, err)
+}
+
+func TestHTTPBankClient_Observer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	observer := &recordingObserver{}
+	client := NewHTTPBankClient(server.URL, WithObserver(observer))
+
+	payment := &domain.Payment{
+		Card: domain.Card{
+			Number:      "1234567890123456",
+			ExpiryMonth: 12,
+			ExpiryYear:  2025,
+			CVV:         "123",
+		},
+		Currency: "USD",
+		Amount:   1000,
+	}
+
+	_, err := client.ProcessPayment(context.Background(), payment)
+	require.Error(t, err)
+
+	assert.Equal(t, 1, observer.started)
+	require.Len(t, observer.finished, 1)
+	assert.ErrorIs(t, observer.finished[0], ErrBankUnavailable)
+}
+
+func TestErrorClass(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	_, err := NewHTTPBankClient(server.URL).ProcessPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "1234567890123456", ExpiryMonth: 12, ExpiryYear: 2025, CVV: "123"},
+		Currency: "USD",
+		Amount:   1000,
+	})
+	require.Error(t, err)
+
+	assert.Equal(t, "connection", ErrorClass(err))
+	assert.Equal(t, "none", ErrorClass(nil))
+	assert.Equal(t, "circuit_open", ErrorClass(ErrCircuitOpen))
+	assert.Equal(t, "concurrency_limit", ErrorClass(fmt.Errorf("wrapped: %w", domain.ErrConcurrencyLimitExceeded)))
+	assert.Equal(t, "overloaded", ErrorClass(domain.ErrBankOverloaded))
+	assert.Equal(t, "other", ErrorClass(errors.New("boom")))
+}
+
+func TestOutcomeUnknown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	_, err := NewHTTPBankClient(server.URL).ProcessPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "1234567890123456", ExpiryMonth: 12, ExpiryYear: 2025, CVV: "123"},
+		Currency: "USD",
+		Amount:   1000,
+	})
+	require.Error(t, err)
+
+	assert.False(t, OutcomeUnknown(err), "a refused connection never carried the payment")
+	assert.True(t, OutcomeUnknown(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)))
+	assert.True(t, OutcomeUnknown(&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}))
+	assert.False(t, OutcomeUnknown(domain.ErrBankOverloaded))
+	assert.False(t, OutcomeUnknown(ErrBankUnavailable))
+	assert.False(t, OutcomeUnknown(nil))
+}
+
+func TestNotSent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	_, err := NewHTTPBankClient(server.URL).ProcessPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "1234567890123456", ExpiryMonth: 12, ExpiryYear: 2025, CVV: "123"},
+		Currency: "USD",
+		Amount:   1000,
+	})
+	require.Error(t, err)
+
+	assert.True(t, NotSent(err), "a refused connection never carried the payment")
+	assert.True(t, NotSent(fmt.Errorf("wrapped: %w", ErrCircuitOpen)))
+	assert.True(t, NotSent(domain.ErrConcurrencyLimitExceeded))
+	assert.True(t, NotSent(domain.ErrBankOverloaded))
+	assert.True(t, NotSent(ErrBankUnavailable))
+	assert.False(t, NotSent(context.DeadlineExceeded))
+	assert.False(t, NotSent(&net.OpError{Op: "read", Err: errors.New("connection reset by peer")}))
+	assert.False(t, NotSent(ErrBankRejected))
+	assert.False(t, NotSent(nil))
+}
+
+func TestHTTPBankClient_Ping(t *testing.T) {
+	// Any answer means the bank is reachable, even an error status
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	client := NewHTTPBankClient(server.URL)
+	assert.NoError(t, client.Ping(context.Background()))
+
+	server.Close()
+	assert.ErrorContains(t, client.Ping(context.Background()), "bank unreachable")
+}
+
+func TestHTTPBankClient_WithTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewHTTPBankClient(server.URL, WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := client.ProcessPayment(context.Background(), &domain.Payment{Card: domain.Card{Number: "1234567890123456"}})
+	require.Error(t, err)
+	assert.Equal(t, "timeout", ErrorClass(err))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestHTTPBankClient_Retry(t *testing.T) {
+	retry := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	tests := []struct {
+		name          string
+		statuses      []int
+		expectedCalls int
+		expectError   error
+	}{
+		{
+			name:          "succeeds after the bank recovers",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			expectedCalls: 3,
+		},
+		{
+			name:          "gives up after max attempts",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK},
+			expectedCalls: 3,
+			expectError:   ErrBankUnavailable,
+		},
+		{
+			name:          "does not retry a rejection",
+			statuses:      []int{http.StatusBadRequest, http.StatusOK},
+			expectedCalls: 1,
+			expectError:   ErrBankRejected,
+		},
+		{
+			name:          "does not retry a response it cannot read",
+			statuses:      []int{http.StatusInternalServerError, http.StatusOK},
+			expectedCalls: 1,
+			expectError:   ErrBankUnexpectedResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[calls]
+				calls++
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					json.NewEncoder(w).Encode(BankResponse{Authorized: true})
+				}
+			}))
+			defer server.Close()
+
+			observer := &recordingObserver{}
+			client := NewHTTPBankClient(server.URL, WithRetryPolicy(retry), WithObserver(observer))
+
+			resp, err := client.ProcessPayment(context.Background(), &domain.Payment{Card: domain.Card{Number: "1234567890123456"}})
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, resp.Authorized)
+			}
+			assert.Equal(t, tt.expectedCalls, calls)
+			assert.Equal(t, tt.expectedCalls, observer.started, "every attempt is observed")
+		})
+	}
+}
+
+func TestHTTPBankClient_RetryConnectionRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	observer := &recordingObserver{}
+	client := NewHTTPBankClient(server.URL,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		WithObserver(observer),
+	)
+
+	_, err := client.ProcessPayment(context.Background(), &domain.Payment{Card: domain.Card{Number: "1234567890123456"}})
+	assert.Equal(t, "connection", ErrorClass(err))
+	assert.Equal(t, 2, observer.started)
+}
+
+func TestHTTPBankClient_Configure(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewHTTPBankClient(server.URL)
+	client.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.Equal(t, 1, calls)
+
+	client.Configure(time.Second, RetryPolicy{MaxAttempts: 2})
+	client.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, p.backoff(4))
+	assert.Equal(t, time.Second, p.backoff(5))
+	assert.Equal(t, time.Second, p.backoff(60))
+}
+
+func TestHTTPBankClient_Settle(t *testing.T) {
+	sim := banksim.New()
+	server := httptest.NewServer(sim)
+	defer server.Close()
+
+	client := NewHTTPBankClient(server.URL)
+	authorize := func() string {
+		resp, err := client.ProcessPayment(context.Background(), &domain.Payment{
+			Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+			Currency: "GBP",
+			Amount:   1000,
+		})
+		require.NoError(t, err)
+		require.True(t, resp.Authorized)
+		return resp.AuthorizationCode
+	}
+
+	code := authorize()
+	require.NoError(t, client.Settle(context.Background(), &Settlement{Type: SettlementCapture, AuthorizationCode: code, Amount: 600}))
+	require.NoError(t, client.Settle(context.Background(), &Settlement{Type: SettlementRefund, AuthorizationCode: code, Amount: 100}))
+
+	auth, ok := sim.Authorization(code)
+	require.True(t, ok)
+	assert.Equal(t, banksim.StatusCaptured, auth.Status)
+	assert.Equal(t, 600, auth.CapturedAmount)
+	assert.Equal(t, 100, auth.RefundedAmount)
+
+	err := client.Settle(context.Background(), &Settlement{Type: SettlementRefund, AuthorizationCode: code, Amount: 501})
+	assert.ErrorIs(t, err, ErrSettlementRefused)
+	assert.Contains(t, err.Error(), "cannot refund more than was captured")
+	assert.ErrorIs(t, client.Settle(context.Background(), &Settlement{Type: SettlementVoid, AuthorizationCode: code}), ErrSettlementRefused)
+	assert.ErrorIs(t, client.Settle(context.Background(), &Settlement{Type: SettlementVoid, AuthorizationCode: "missing"}), ErrSettlementRefused)
+
+	code = authorize()
+	require.NoError(t, client.Settle(context.Background(), &Settlement{Type: SettlementVoid, AuthorizationCode: code}))
+	auth, _ = sim.Authorization(code)
+	assert.Equal(t, banksim.StatusVoided, auth.Status)
+}
+
+func TestHTTPBankClient_Settle_Unsupported(t *testing.T) {
+	adapter, err := NewMappedAdapter(FieldMapping{
+		Request:  RequestMapping{CardNumber: "pan", ExpiryDate: "expiry", Currency: "currency", Amount: "amount"},
+		Response: ResponseMapping{Authorized: "approved"},
+	})
+	require.NoError(t, err)
+
+	client := NewHTTPBankClient("http://bank.invalid", WithAdapter(adapter))
+	err = client.Settle(context.Background(), &Settlement{Type: SettlementVoid, AuthorizationCode: "auth-1"})
+	assert.ErrorIs(t, err, domain.ErrSettlementUnsupported)
 }
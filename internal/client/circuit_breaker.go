@@ -0,0 +1,242 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+// ErrCircuitOpen is returned without calling the bank while the circuit
+// breaker is open
+var ErrCircuitOpen = errors.New("bank circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker
+type CircuitState int
+
+const (
+	// CircuitClosed passes every request to the bank
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single probe request through to see whether
+	// the bank has recovered
+	CircuitHalfOpen
+	// CircuitOpen fails every request without calling the bank
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half_open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultFailureThreshold = 5
+	defaultCooldown         = 30 * time.Second
+)
+
+// CircuitBreaker stops calling the bank after a run of consecutive failures,
+// so a struggling acquirer is not hammered and merchants get a fast error.
+// After the cooldown a single probe is let through; if it succeeds the
+// circuit closes again.
+//
+// Only errors that suggest the bank is unhealthy count as failures: timeouts,
+// connection errors, 503s and responses the client cannot understand.
+// Declines and requests the bank rejected as malformed show the bank is up.
+type CircuitBreaker struct {
+	next          BankClient
+	threshold     int
+	cooldown      time.Duration
+	clock         clock.Clock
+	onStateChange func(from, to CircuitState)
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// CircuitBreakerOption configures a CircuitBreaker
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold sets how many consecutive failures open the circuit
+func WithFailureThreshold(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.threshold = n
+	}
+}
+
+// WithCooldown sets how long the circuit stays open before probing the bank
+func WithCooldown(d time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.cooldown = d
+	}
+}
+
+// WithCircuitClock replaces the wall clock used to time the cooldown
+func WithCircuitClock(c clock.Clock) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.clock = c
+	}
+}
+
+// WithStateChangeHook calls fn on every state transition. fn is called with
+// the breaker's lock held and must not call back into it.
+func WithStateChangeHook(fn func(from, to CircuitState)) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.onStateChange = fn
+	}
+}
+
+func NewCircuitBreaker(next BankClient, opts ...CircuitBreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		next:      next,
+		threshold: defaultFailureThreshold,
+		cooldown:  defaultCooldown,
+		clock:     clock.Real{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+func (b *CircuitBreaker) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	if !b.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	resp, err := b.next.ProcessPayment(ctx, payment)
+	b.record(err)
+
+	return resp, err
+}
+
+// Settle is turned away while the circuit is open and counts towards
+// opening it, like a payment
+func (b *CircuitBreaker) Settle(ctx context.Context, settlement *Settlement) error {
+	settler, ok := b.next.(Settler)
+	if !ok {
+		return domain.ErrSettlementUnsupported
+	}
+
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+
+	err := settler.Settle(ctx, settlement)
+	b.record(err)
+
+	return err
+}
+
+// State returns the current state, moving an open circuit whose cooldown
+// has passed to half-open
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkCooldown()
+	return b.state
+}
+
+// Ping fails while the circuit is open, otherwise it asks the wrapped client
+// if it can. It does not count towards opening the circuit.
+func (b *CircuitBreaker) Ping(ctx context.Context) error {
+	if b.State() == CircuitOpen {
+		return ErrCircuitOpen
+	}
+
+	if p, ok := b.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.checkCooldown()
+
+	switch b.state {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// A request that started before the circuit opened says nothing about
+	// whether the cooldown has been long enough
+	if b.state == CircuitOpen {
+		return
+	}
+
+	if b.state == CircuitHalfOpen {
+		b.probing = false
+	}
+
+	if !isBankFailure(err) {
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+func (b *CircuitBreaker) checkCooldown() {
+	if b.state == CircuitOpen && !b.clock.Now().Before(b.openedAt.Add(b.cooldown)) {
+		b.setState(CircuitHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(to CircuitState) {
+	from := b.state
+	if from == to {
+		return
+	}
+
+	b.state = to
+	if b.onStateChange != nil {
+		b.onStateChange(from, to)
+	}
+}
+
+// isBankFailure reports whether err suggests the bank is unhealthy
+func isBankFailure(err error) bool {
+	switch ErrorClass(err) {
+	case "timeout", "connection", "unavailable", "unexpected_response":
+		return true
+	default:
+		return false
+	}
+}
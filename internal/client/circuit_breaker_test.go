@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedBank returns the next queued error on every call, or an
+// authorization once the queue is empty
+type scriptedBank struct {
+	errs  []error
+	calls int
+}
+
+func (b *scriptedBank) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	b.calls++
+	if len(b.errs) == 0 {
+		return &BankResponse{Authorized: true}, nil
+	}
+
+	err := b.errs[0]
+	b.errs = b.errs[1:]
+	return nil, err
+}
+
+// Settle takes the next queued error, like ProcessPayment
+func (b *scriptedBank) Settle(ctx context.Context, settlement *Settlement) error {
+	_, err := b.ProcessPayment(ctx, nil)
+	return err
+}
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrBankUnavailable, ErrBankUnavailable, ErrBankUnavailable}}
+	breaker := NewCircuitBreaker(bank, WithFailureThreshold(3))
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.ProcessPayment(context.Background(), &domain.Payment{})
+		assert.ErrorIs(t, err, ErrBankUnavailable)
+	}
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	_, err := breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 3, bank.calls, "an open circuit must not call the bank")
+}
+
+func TestCircuitBreaker_SuccessResetsFailureCount(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrBankUnavailable, ErrBankUnavailable}}
+	breaker := NewCircuitBreaker(bank, WithFailureThreshold(3))
+
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	_, err := breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	require.NoError(t, err)
+
+	bank.errs = []error{ErrBankUnavailable, ErrBankUnavailable}
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestCircuitBreaker_IgnoresErrorsThatShowTheBankIsUp(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrBankRejected, errors.New("failed to detokenize card")}}
+	breaker := NewCircuitBreaker(bank, WithFailureThreshold(1))
+
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestCircuitBreaker_HalfOpenProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		probeErr  error
+		wantState CircuitState
+	}{
+		{name: "probe succeeds", probeErr: nil, wantState: CircuitClosed},
+		{name: "probe fails", probeErr: ErrBankUnavailable, wantState: CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+			var transitions []string
+
+			bank := &scriptedBank{errs: []error{ErrBankUnavailable}}
+			breaker := NewCircuitBreaker(bank,
+				WithFailureThreshold(1),
+				WithCooldown(time.Minute),
+				WithCircuitClock(fakeClock),
+				WithStateChangeHook(func(from, to CircuitState) {
+					transitions = append(transitions, from.String()+"->"+to.String())
+				}),
+			)
+
+			breaker.ProcessPayment(context.Background(), &domain.Payment{})
+			require.Equal(t, CircuitOpen, breaker.State())
+
+			fakeClock.Advance(time.Minute)
+			assert.Equal(t, CircuitHalfOpen, breaker.State())
+
+			if tt.probeErr != nil {
+				bank.errs = []error{tt.probeErr}
+			}
+			breaker.ProcessPayment(context.Background(), &domain.Payment{})
+
+			assert.Equal(t, tt.wantState, breaker.State())
+			assert.Equal(t, []string{"closed->open", "open->half_open", "half_open->" + tt.wantState.String()}, transitions)
+		})
+	}
+}
+
+// blockingBank holds every call until release is closed
+type blockingBank struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBank) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	b.entered <- struct{}{}
+	<-b.release
+	return &BankResponse{Authorized: true}, nil
+}
+
+func TestCircuitBreaker_HalfOpenAllowsSingleProbe(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	failing := &scriptedBank{errs: []error{ErrBankUnavailable}}
+	breaker := NewCircuitBreaker(failing, WithFailureThreshold(1), WithCircuitClock(fakeClock))
+
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	fakeClock.Advance(defaultCooldown)
+
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	breaker.next = bank
+
+	done := make(chan error)
+	go func() {
+		_, err := breaker.ProcessPayment(context.Background(), &domain.Payment{})
+		done <- err
+	}()
+	<-bank.entered
+
+	_, err := breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, err, ErrCircuitOpen, "only one probe may be in flight")
+
+	close(bank.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+type pingingBank struct {
+	scriptedBank
+	err error
+}
+
+func (b *pingingBank) Ping(ctx context.Context) error {
+	return b.err
+}
+
+func TestCircuitBreaker_Ping(t *testing.T) {
+	bank := &pingingBank{}
+	breaker := NewCircuitBreaker(bank, WithFailureThreshold(1))
+	assert.NoError(t, breaker.Ping(context.Background()))
+
+	bank.err = errors.New("bank unreachable")
+	assert.ErrorContains(t, breaker.Ping(context.Background()), "bank unreachable")
+	assert.Equal(t, CircuitClosed, breaker.State(), "a failed ping must not open the circuit")
+
+	bank.err = nil
+	bank.errs = []error{ErrBankUnavailable}
+	breaker.ProcessPayment(context.Background(), &domain.Payment{})
+	assert.ErrorIs(t, breaker.Ping(context.Background()), ErrCircuitOpen)
+
+	// Clients that cannot ping are taken to be reachable while the circuit
+	// is closed
+	assert.NoError(t, NewCircuitBreaker(&scriptedBank{}).Ping(context.Background()))
+}
+
+func TestCircuitBreaker_Settle(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrSettlementRefused, ErrBankUnavailable, ErrBankUnavailable}}
+	breaker := NewCircuitBreaker(bank, WithFailureThreshold(2))
+
+	// A refusal is an answer, so it does not count as a failure
+	assert.ErrorIs(t, breaker.Settle(context.Background(), &Settlement{}), ErrSettlementRefused)
+	assert.ErrorIs(t, breaker.Settle(context.Background(), &Settlement{}), ErrBankUnavailable)
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.ErrorIs(t, breaker.Settle(context.Background(), &Settlement{}), ErrBankUnavailable)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	assert.ErrorIs(t, breaker.Settle(context.Background(), &Settlement{}), ErrCircuitOpen)
+	assert.Equal(t, 3, bank.calls)
+
+	assert.ErrorIs(t, NewCircuitBreaker(&blockingBank{}).Settle(context.Background(), &Settlement{}), domain.ErrSettlementUnsupported)
+}
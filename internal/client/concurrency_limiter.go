@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+// Partition names the pool a bank call counts against, e.g. its merchant,
+// and how many calls that pool may have in flight. A limit of zero or less
+// is no limit.
+type Partition func(ctx context.Context) (key string, limit int)
+
+// ConcurrencyLimiter caps the bank calls in flight per partition, so one
+// merchant's burst of payments cannot take every connection to the bank.
+// Calls over the cap fail straight away with
+// domain.ErrConcurrencyLimitExceeded rather than queueing.
+type ConcurrencyLimiter struct {
+	next      BankClient
+	partition Partition
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func NewConcurrencyLimiter(next BankClient, partition Partition) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		next:      next,
+		partition: partition,
+		inFlight:  make(map[string]int),
+	}
+}
+
+func (l *ConcurrencyLimiter) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	key, limit := l.partition(ctx)
+	if !l.acquire(key, limit) {
+		return nil, domain.ErrConcurrencyLimitExceeded
+	}
+	defer l.release(key)
+
+	return l.next.ProcessPayment(ctx, payment)
+}
+
+// Settle counts against the same limit as payments
+func (l *ConcurrencyLimiter) Settle(ctx context.Context, settlement *Settlement) error {
+	settler, ok := l.next.(Settler)
+	if !ok {
+		return domain.ErrSettlementUnsupported
+	}
+
+	key, limit := l.partition(ctx)
+	if !l.acquire(key, limit) {
+		return domain.ErrConcurrencyLimitExceeded
+	}
+	defer l.release(key)
+
+	return settler.Settle(ctx, settlement)
+}
+
+// InFlight returns the calls in flight for key
+func (l *ConcurrencyLimiter) InFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight[key]
+}
+
+// Ping asks the wrapped client if it can
+func (l *ConcurrencyLimiter) Ping(ctx context.Context) error {
+	if p, ok := l.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (l *ConcurrencyLimiter) acquire(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit > 0 && l.inFlight[key] >= limit {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop idle partitions so the map does not grow with every merchant
+	// ever seen
+	if l.inFlight[key]--; l.inFlight[key] <= 0 {
+		delete(l.inFlight, key)
+	}
+}
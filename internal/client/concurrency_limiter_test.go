@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type partitionKey struct{}
+
+// byContextKey partitions calls by the key in their context, allowing two
+// calls in flight for each
+func byContextKey(ctx context.Context) (string, int) {
+	key, _ := ctx.Value(partitionKey{}).(string)
+	if key == "unlimited" {
+		return key, 0
+	}
+	return key, 2
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewConcurrencyLimiter(bank, byContextKey)
+
+	merchantA := context.WithValue(context.Background(), partitionKey{}, "a")
+	merchantB := context.WithValue(context.Background(), partitionKey{}, "b")
+
+	done := make(chan error, 3)
+	for _, ctx := range []context.Context{merchantA, merchantA, merchantB} {
+		go func(ctx context.Context) {
+			_, err := limiter.ProcessPayment(ctx, &domain.Payment{})
+			done <- err
+		}(ctx)
+		<-bank.entered
+	}
+	assert.Equal(t, 2, limiter.InFlight("a"))
+	assert.Equal(t, 1, limiter.InFlight("b"))
+
+	// A is at its cap and is turned away without calling the bank
+	_, err := limiter.ProcessPayment(merchantA, &domain.Payment{})
+	assert.ErrorIs(t, err, domain.ErrConcurrencyLimitExceeded)
+
+	// B still has room
+	go func() {
+		_, err := limiter.ProcessPayment(merchantB, &domain.Payment{})
+		done <- err
+	}()
+	<-bank.entered
+
+	close(bank.release)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, <-done)
+	}
+	assert.Equal(t, 0, limiter.InFlight("a"))
+	assert.Empty(t, limiter.inFlight, "idle partitions should be dropped")
+}
+
+func TestConcurrencyLimiter_Unlimited(t *testing.T) {
+	bank := &blockingBank{entered: make(chan struct{}), release: make(chan struct{})}
+	limiter := NewConcurrencyLimiter(bank, byContextKey)
+	ctx := context.WithValue(context.Background(), partitionKey{}, "unlimited")
+
+	done := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := limiter.ProcessPayment(ctx, &domain.Payment{})
+			done <- err
+		}()
+		<-bank.entered
+	}
+
+	close(bank.release)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, <-done)
+	}
+}
+
+func TestConcurrencyLimiter_ReleasesOnError(t *testing.T) {
+	bank := &scriptedBank{errs: []error{ErrBankUnavailable, ErrBankUnavailable, ErrBankUnavailable}}
+	limiter := NewConcurrencyLimiter(bank, byContextKey)
+	ctx := context.WithValue(context.Background(), partitionKey{}, "a")
+
+	for i := 0; i < 3; i++ {
+		_, err := limiter.ProcessPayment(ctx, &domain.Payment{})
+		assert.ErrorIs(t, err, ErrBankUnavailable)
+	}
+	assert.Equal(t, 0, limiter.InFlight("a"))
+}
@@ -0,0 +1,524 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/iso8583"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// isoDeclineReasons maps the ISO 8583 response codes that decline a
+// payment to why. Any other code that is not an approval or an error below
+// is a plain do not honour.
+var isoDeclineReasons = map[string]domain.DeclineReason{
+	"04": domain.DeclineLostOrStolenCard,
+	"05": domain.DeclineDoNotHonour,
+	"12": domain.DeclineTransactionBlocked,
+	"13": domain.DeclineInvalidAmount,
+	"14": domain.DeclineInvalidCard,
+	"41": domain.DeclineLostOrStolenCard,
+	"43": domain.DeclineLostOrStolenCard,
+	"51": domain.DeclineInsufficientFunds,
+	"54": domain.DeclineExpiredCard,
+	"57": domain.DeclineTransactionBlocked,
+	"58": domain.DeclineTransactionBlocked,
+	"59": domain.DeclineSuspectedFraud,
+	"61": domain.DeclineLimitExceeded,
+	"62": domain.DeclineTransactionBlocked,
+	"65": domain.DeclineLimitExceeded,
+	"N7": domain.DeclineInvalidCVV,
+}
+
+// isoResponseError returns the error for a response code that is not a
+// decision on the payment
+func isoResponseError(code string) error {
+	switch code {
+	case "30":
+		return fmt.Errorf("%w: format error", ErrBankRejected)
+	case "91", "92", "96":
+		return fmt.Errorf("%w: response code %s", ErrBankUnavailable, code)
+	default:
+		return nil
+	}
+}
+
+// ISO8583Client is a BankClient for acquirers that speak ISO 8583 over TCP.
+// It keeps one connection open, multiplexing requests over it and matching
+// answers by STAN, and reverses any authorization it sent but got no answer
+// to, so a payment that timed out is never left charged.
+type ISO8583Client struct {
+	addr        string
+	dialer      *net.Dialer
+	detokenizer Detokenizer
+	observer    Observer
+	terminalID  string
+	merchantID  string
+	financial   bool
+	now         func() time.Time
+
+	stan atomic.Uint32
+
+	mu      sync.RWMutex // Guards timeout and retry, which can be reloaded
+	timeout time.Duration
+	retry   RetryPolicy
+
+	connMu sync.Mutex
+	conn   *isoConn
+}
+
+// ISO8583Option configures an ISO8583Client
+type ISO8583Option func(*ISO8583Client)
+
+// WithISO8583Detokenizer lets the client send tokenized cards by resolving
+// them through the vault
+func WithISO8583Detokenizer(d Detokenizer) ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.detokenizer = d
+	}
+}
+
+// WithISO8583Observer reports every request to o
+func WithISO8583Observer(o Observer) ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.observer = o
+	}
+}
+
+// WithISO8583Timeout bounds the wait for each answer
+func WithISO8583Timeout(d time.Duration) ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.timeout = d
+	}
+}
+
+// WithISO8583RetryPolicy retries payments the acquirer did not process
+func WithISO8583RetryPolicy(p RetryPolicy) ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.retry = p
+	}
+}
+
+// WithTerminal sets the card acceptor terminal and merchant IDs the
+// acquirer knows the gateway by
+func WithTerminal(terminalID, merchantID string) ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.terminalID = terminalID
+		c.merchantID = merchantID
+	}
+}
+
+// WithFinancialMessages sends 0200 financial requests, which authorize and
+// capture in one, instead of 0100 authorizations
+func WithFinancialMessages() ISO8583Option {
+	return func(c *ISO8583Client) {
+		c.financial = true
+	}
+}
+
+func NewISO8583Client(addr string, opts ...ISO8583Option) *ISO8583Client {
+	c := &ISO8583Client{
+		addr:       addr,
+		dialer:     &net.Dialer{},
+		terminalID: "GATEWAY1",
+		merchantID: "GATEWAY",
+		now:        time.Now,
+		timeout:    DefaultTimeout,
+		retry:      RetryPolicy{MaxAttempts: 1},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Configure replaces the timeout and retry policy. It is safe to call while
+// payments are in flight; they finish with the settings they started with.
+func (c *ISO8583Client) Configure(timeout time.Duration, retry RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+	c.retry = retry
+}
+
+func (c *ISO8583Client) settings() (time.Duration, RetryPolicy) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.timeout, c.retry
+}
+
+// ProcessPayment asks the acquirer to authorize the payment, retrying as
+// the retry policy allows
+func (c *ISO8583Client) ProcessPayment(ctx context.Context, payment *domain.Payment) (_ *BankResponse, err error) {
+	ctx, span := tracing.Start(ctx, "ISO8583Client.ProcessPayment",
+		trace.WithAttributes(tracing.PaymentAttributes(payment)...))
+	defer func() { tracing.End(span, err) }()
+
+	auth, err := cardAuthorization(payment, c.detokenizer)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, retry := c.settings()
+	for attempt := 1; ; attempt++ {
+		bankResp, err := c.attempt(ctx, timeout, auth)
+		if err == nil || attempt >= retry.MaxAttempts || !isRetryable(err) {
+			return bankResp, err
+		}
+
+		backoff := retry.backoff(attempt)
+		slog.InfoContext(ctx, "retrying bank call",
+			"attempt", attempt, "backoff", backoff, "error_class", ErrorClass(err))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// attempt sends the authorization once, with a STAN of its own
+func (c *ISO8583Client) attempt(ctx context.Context, timeout time.Duration, auth *CardAuthorization) (_ *BankResponse, err error) {
+	req, err := c.request(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.observer != nil {
+		c.observer.BankCallStarted()
+		start := time.Now()
+		defer func() { c.observer.BankCallFinished(time.Since(start), err) }()
+	}
+
+	resp, err := c.exchange(ctx, timeout, req)
+	if err != nil {
+		if errors.Is(err, errUnanswered) {
+			go c.reverse(context.WithoutCancel(ctx), timeout, req)
+		}
+		return nil, err
+	}
+
+	if want := req.Response().MTI; resp.MTI != want {
+		return nil, fmt.Errorf("%w: answered %s with %s", ErrBankUnexpectedResponse, req.MTI, resp.MTI)
+	}
+	return decodeISOResponse(resp)
+}
+
+// request builds the authorization or financial request for auth
+func (c *ISO8583Client) request(auth *CardAuthorization) (*iso8583.Message, error) {
+	currency, ok := iso8583.NumericCurrency(auth.Currency)
+	if !ok {
+		return nil, fmt.Errorf("%w: no ISO 4217 numeric code for %s", ErrBankRejected, auth.Currency)
+	}
+
+	mti := iso8583.MTIAuthorizationRequest
+	if c.financial {
+		mti = iso8583.MTIFinancialRequest
+	}
+
+	// Manually keyed for the cardholder, or a credential on file when the
+	// merchant charges a stored card
+	entryMode := "010"
+	additional := []iso8583.Subelement{{Tag: iso8583.TagCVV, Value: auth.CVV}}
+	if auth.Initiator != "" {
+		additional = append(additional, iso8583.Subelement{Tag: iso8583.TagInitiator, Value: auth.Initiator})
+	}
+	if auth.Initiator == string(domain.InitiatorMerchant) {
+		entryMode = "100"
+	}
+	if sc := auth.StoredCredential; sc != nil {
+		additional = append(additional,
+			iso8583.Subelement{Tag: iso8583.TagStoredCredentialUsage, Value: sc.Usage},
+			iso8583.Subelement{Tag: iso8583.TagStoredCredentialType, Value: sc.Type},
+			iso8583.Subelement{Tag: iso8583.TagPreviousTransactionID, Value: sc.PreviousNetworkTransactionID},
+		)
+	}
+
+	now := c.now().UTC()
+	stan := c.nextSTAN()
+	req := iso8583.NewMessage(mti).
+		Set(iso8583.FieldPAN, auth.CardNumber).
+		Set(iso8583.FieldProcessingCode, "000000").
+		Set(iso8583.FieldAmount, fmt.Sprintf("%012d", auth.Amount)).
+		Set(iso8583.FieldTransmissionTime, now.Format("0102150405")).
+		Set(iso8583.FieldSTAN, stan).
+		Set(iso8583.FieldExpiry, fmt.Sprintf("%02d%02d", auth.ExpiryYear%100, auth.ExpiryMonth)).
+		Set(iso8583.FieldPOSEntryMode, entryMode).
+		Set(iso8583.FieldRRN, fmt.Sprintf("%d%03d%02d%s", now.Year()%10, now.YearDay(), now.Hour(), stan)).
+		Set(iso8583.FieldTerminalID, fmt.Sprintf("%-8.8s", c.terminalID)).
+		Set(iso8583.FieldMerchantID, fmt.Sprintf("%-15.15s", c.merchantID)).
+		Set(iso8583.FieldAdditionalData, iso8583.PackSubelements(additional...)).
+		Set(iso8583.FieldCurrency, currency)
+	return req, nil
+}
+
+// nextSTAN numbers requests from 000001 to 999999, then starts again
+func (c *ISO8583Client) nextSTAN() string {
+	return fmt.Sprintf("%06d", (c.stan.Add(1)-1)%999999+1)
+}
+
+func decodeISOResponse(resp *iso8583.Message) (*BankResponse, error) {
+	code := resp.Get(iso8583.FieldResponseCode)
+	if code == "" {
+		return nil, fmt.Errorf("%w: no response code", ErrBankUnexpectedResponse)
+	}
+	if code == iso8583.ResponseApproved {
+		return &BankResponse{
+			Authorized:           true,
+			AuthorizationCode:    resp.Get(iso8583.FieldAuthorizationCode),
+			NetworkTransactionID: resp.Get(iso8583.FieldNetworkData),
+		}, nil
+	}
+	if err := isoResponseError(code); err != nil {
+		return nil, err
+	}
+
+	reason, ok := isoDeclineReasons[code]
+	if !ok {
+		reason = domain.DeclineDoNotHonour
+	}
+	return &BankResponse{DeclineReason: reason}, nil
+}
+
+// reverse tells the acquirer to undo req, whose answer never came. The
+// acquirer may have approved it, and the payment has already failed.
+func (c *ISO8583Client) reverse(ctx context.Context, timeout time.Duration, original *iso8583.Message) {
+	now := c.now().UTC()
+	req := iso8583.NewMessage(iso8583.MTIReversalRequest).
+		Set(iso8583.FieldPAN, original.Get(iso8583.FieldPAN)).
+		Set(iso8583.FieldProcessingCode, original.Get(iso8583.FieldProcessingCode)).
+		Set(iso8583.FieldAmount, original.Get(iso8583.FieldAmount)).
+		Set(iso8583.FieldTransmissionTime, now.Format("0102150405")).
+		Set(iso8583.FieldSTAN, c.nextSTAN()).
+		Set(iso8583.FieldRRN, original.Get(iso8583.FieldRRN)).
+		Set(iso8583.FieldTerminalID, original.Get(iso8583.FieldTerminalID)).
+		Set(iso8583.FieldMerchantID, original.Get(iso8583.FieldMerchantID)).
+		Set(iso8583.FieldCurrency, original.Get(iso8583.FieldCurrency)).
+		// The original MTI, STAN and transmission time, then the acquiring
+		// and forwarding institutions, which the gateway does not use
+		Set(iso8583.FieldOriginalData, original.MTI+original.Get(iso8583.FieldSTAN)+original.Get(iso8583.FieldTransmissionTime)+fmt.Sprintf("%022d", 0))
+
+	resp, err := c.exchange(ctx, timeout, req)
+	if err == nil && resp.Get(iso8583.FieldResponseCode) != iso8583.ResponseApproved {
+		err = fmt.Errorf("%w: response code %s", ErrBankUnexpectedResponse, resp.Get(iso8583.FieldResponseCode))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "reversal failed, the payment may need reversing by hand",
+			"stan", original.Get(iso8583.FieldSTAN), "rrn", original.Get(iso8583.FieldRRN), "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "reversed unanswered payment",
+		"stan", original.Get(iso8583.FieldSTAN), "rrn", original.Get(iso8583.FieldRRN))
+}
+
+// Ping sends a network management echo
+func (c *ISO8583Client) Ping(ctx context.Context) error {
+	timeout, _ := c.settings()
+	req := iso8583.NewMessage(iso8583.MTINetworkRequest).
+		Set(iso8583.FieldTransmissionTime, c.now().UTC().Format("0102150405")).
+		Set(iso8583.FieldSTAN, c.nextSTAN()).
+		Set(iso8583.FieldNetworkManagementCode, "301")
+
+	resp, err := c.exchange(ctx, timeout, req)
+	if err != nil {
+		return fmt.Errorf("bank unreachable: %w", err)
+	}
+	if code := resp.Get(iso8583.FieldResponseCode); code != iso8583.ResponseApproved {
+		return fmt.Errorf("%w: echo answered with %s", ErrBankUnexpectedResponse, code)
+	}
+	return nil
+}
+
+// Close closes the connection to the acquirer. Requests waiting on it fail.
+func (c *ISO8583Client) Close() error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.close(net.ErrClosed)
+}
+
+// exchange sends req and waits for the answer with the same STAN
+func (c *ISO8583Client) exchange(ctx context.Context, timeout time.Duration, req *iso8583.Message) (*iso8583.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := req.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bank request: %w", err)
+	}
+
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn.exchange(ctx, req.Get(iso8583.FieldSTAN), data)
+}
+
+// connection returns the open connection, dialling a new one if there is
+// none or the last one failed
+func (c *ISO8583Client) connection(ctx context.Context) (*isoConn, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn != nil && c.conn.alive() {
+		return c.conn, nil
+	}
+
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to bank: %w", err)
+	}
+	c.conn = newISOConn(conn)
+	return c.conn, nil
+}
+
+// errUnanswered marks a request that was sent, at least in part, but not
+// answered, so the acquirer may have acted on it
+var errUnanswered = errors.New("request unanswered")
+
+// unansweredError keeps the underlying cause, such as a timeout or a lost
+// connection, visible to ErrorClass and isRetryable
+type unansweredError struct {
+	err error
+}
+
+func (e *unansweredError) Error() string { return e.err.Error() }
+
+func (e *unansweredError) Is(target error) bool { return target == errUnanswered }
+
+func (e *unansweredError) Unwrap() error { return e.err }
+
+// isoConn is one TCP connection to the acquirer. Any number of requests may
+// be waiting on it; a single reader hands each answer to the request with
+// its STAN.
+type isoConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan *iso8583.Message
+	err     error
+	done    chan struct{}
+}
+
+func newISOConn(conn net.Conn) *isoConn {
+	c := &isoConn{
+		conn:    conn,
+		pending: make(map[string]chan *iso8583.Message),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *isoConn) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *isoConn) exchange(ctx context.Context, stan string, data []byte) (*iso8583.Message, error) {
+	answer := make(chan *iso8583.Message, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	if _, taken := c.pending[stan]; taken {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("STAN %s is already waiting for an answer", stan)
+	}
+	c.pending[stan] = answer
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, stan)
+		c.mu.Unlock()
+	}()
+
+	c.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	}
+	err := iso8583.WriteFrame(c.conn, data)
+	c.writeMu.Unlock()
+	if err != nil {
+		// A frame cut short leaves the stream unreadable for the acquirer
+		c.close(err)
+		return nil, &unansweredError{fmt.Errorf("failed to send request to bank: %w", err)}
+	}
+
+	select {
+	case resp := <-answer:
+		return resp, nil
+	case <-c.done:
+		return nil, &unansweredError{c.err}
+	case <-ctx.Done():
+		return nil, &unansweredError{ctx.Err()}
+	}
+}
+
+func (c *isoConn) readLoop() {
+	for {
+		frame, err := iso8583.ReadFrame(c.conn)
+		if err != nil {
+			c.close(err)
+			return
+		}
+
+		msg, err := iso8583.Unpack(frame)
+		if err != nil {
+			// Framing is intact, so only this message is lost; its request
+			// times out
+			slog.Warn("unreadable message from bank", "error", err)
+			continue
+		}
+
+		stan := msg.Get(iso8583.FieldSTAN)
+		c.mu.Lock()
+		answer, ok := c.pending[stan]
+		delete(c.pending, stan)
+		c.mu.Unlock()
+
+		if !ok {
+			slog.Warn("bank answered a request nobody is waiting for", "mti", msg.MTI, "stan", stan)
+			continue
+		}
+		answer <- msg
+	}
+}
+
+// close fails every waiting request with a connection error wrapping
+// cause. Only the first call has any effect.
+func (c *isoConn) close(cause error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil
+	}
+
+	var opErr *net.OpError
+	if !errors.As(cause, &opErr) {
+		cause = &net.OpError{Op: "read", Net: "tcp", Addr: c.conn.RemoteAddr(), Err: cause}
+	}
+	c.err = fmt.Errorf("connection to bank lost: %w", cause)
+	close(c.done)
+	return c.conn.Close()
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/iso8583"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingListener counts the connections the client opens
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+// startISOHost serves a fake ISO 8583 acquirer for the test
+func startISOHost(t *testing.T, opts ...iso8583.HostOption) (*iso8583.Host, *countingListener) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	listener := &countingListener{Listener: l}
+	host := iso8583.NewHost(opts...)
+	go host.Serve(listener)
+	t.Cleanup(func() { host.Close() })
+	return host, listener
+}
+
+func newISOClient(t *testing.T, l net.Listener, opts ...ISO8583Option) *ISO8583Client {
+	t.Helper()
+	c := NewISO8583Client(l.Addr().String(), append([]ISO8583Option{WithISO8583Timeout(time.Second)}, opts...)...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// respondWith answers every request with code
+func respondWith(code string) iso8583.HostOption {
+	return iso8583.WithDecider(func(*iso8583.Message) iso8583.Decision {
+		return iso8583.Decision{ResponseCode: code}
+	})
+}
+
+func TestISO8583Client_Request(t *testing.T) {
+	host, l := startISOHost(t)
+	c := newISOClient(t, l, WithTerminal("TERM01", "MERCHANT-42"))
+
+	resp, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+	require.NoError(t, err)
+	assert.True(t, resp.Authorized)
+	assert.Len(t, resp.AuthorizationCode, 6)
+	assert.Len(t, resp.NetworkTransactionID, 15)
+
+	require.Len(t, host.Received(), 1)
+	req := host.Received()[0]
+	assert.Equal(t, iso8583.MTIAuthorizationRequest, req.MTI)
+	assert.Equal(t, "2222405343248877", req.Get(iso8583.FieldPAN))
+	assert.Equal(t, "000000001050", req.Get(iso8583.FieldAmount))
+	assert.Equal(t, "826", req.Get(iso8583.FieldCurrency))
+	assert.Equal(t, "3004", req.Get(iso8583.FieldExpiry))
+	assert.Equal(t, "010", req.Get(iso8583.FieldPOSEntryMode))
+	assert.Equal(t, "000001", req.Get(iso8583.FieldSTAN))
+	assert.Equal(t, "TERM01  ", req.Get(iso8583.FieldTerminalID))
+	assert.Equal(t, "MERCHANT-42    ", req.Get(iso8583.FieldMerchantID))
+	assert.Len(t, req.Get(iso8583.FieldRRN), 12)
+	data, err := iso8583.ParseSubelements(req.Get(iso8583.FieldAdditionalData))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{iso8583.TagCVV: "123"}, data)
+}
+
+func TestISO8583Client_MerchantInitiated(t *testing.T) {
+	host, l := startISOHost(t)
+	c := newISOClient(t, l, WithFinancialMessages())
+
+	payment := cardPayment("2222405343248877", "")
+	payment.Initiator = domain.InitiatorMerchant
+	payment.StoredCredential = &domain.StoredCredential{
+		Usage:                        domain.StoredCredentialSubsequent,
+		Type:                         domain.StoredCredentialRecurring,
+		PreviousNetworkTransactionID: "123456789012345",
+	}
+	_, err := c.ProcessPayment(context.Background(), payment)
+	require.NoError(t, err)
+
+	req := host.Received()[0]
+	assert.Equal(t, iso8583.MTIFinancialRequest, req.MTI)
+	assert.Equal(t, "100", req.Get(iso8583.FieldPOSEntryMode), "credential on file")
+	data, err := iso8583.ParseSubelements(req.Get(iso8583.FieldAdditionalData))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		iso8583.TagInitiator:             "merchant",
+		iso8583.TagStoredCredentialUsage: "subsequent",
+		iso8583.TagStoredCredentialType:  "recurring",
+		iso8583.TagPreviousTransactionID: "123456789012345",
+	}, data)
+}
+
+func TestISO8583Client_ResponseCodes(t *testing.T) {
+	tests := []struct {
+		code          string
+		expected      *BankResponse
+		expectedError error
+	}{
+		{code: "05", expected: &BankResponse{DeclineReason: domain.DeclineDoNotHonour}},
+		{code: "51", expected: &BankResponse{DeclineReason: domain.DeclineInsufficientFunds}},
+		{code: "54", expected: &BankResponse{DeclineReason: domain.DeclineExpiredCard}},
+		{code: "59", expected: &BankResponse{DeclineReason: domain.DeclineSuspectedFraud}},
+		{code: "N7", expected: &BankResponse{DeclineReason: domain.DeclineInvalidCVV}},
+		{code: "77", expected: &BankResponse{DeclineReason: domain.DeclineDoNotHonour}},
+		{code: "30", expectedError: ErrBankRejected},
+		{code: "91", expectedError: ErrBankUnavailable},
+		{code: "96", expectedError: ErrBankUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			_, l := startISOHost(t, respondWith(tt.code))
+			c := newISOClient(t, l)
+
+			resp, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, resp)
+		})
+	}
+}
+
+// TestISO8583Client_MatchesBySTAN sends payments that the host answers in
+// reverse order over one connection
+func TestISO8583Client_MatchesBySTAN(t *testing.T) {
+	const payments = 10
+	_, l := startISOHost(t, iso8583.WithDecider(func(req *iso8583.Message) iso8583.Decision {
+		amount, _ := strconv.Atoi(req.Get(iso8583.FieldAmount))
+		d := iso8583.Decision{ResponseCode: "51", Delay: time.Duration(payments-amount) * 10 * time.Millisecond}
+		if amount%2 == 1 {
+			d.ResponseCode = "00"
+		}
+		return d
+	}))
+	c := newISOClient(t, l)
+	require.NoError(t, c.Ping(context.Background()))
+
+	var wg sync.WaitGroup
+	for amount := 1; amount <= payments; amount++ {
+		wg.Add(1)
+		go func(amount int) {
+			defer wg.Done()
+			payment := cardPayment("2222405343248877", "123")
+			payment.Amount = amount
+
+			resp, err := c.ProcessPayment(context.Background(), payment)
+			if assert.NoError(t, err) {
+				assert.Equal(t, amount%2 == 1, resp.Authorized, "payment of %d", amount)
+			}
+		}(amount)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), l.accepted.Load(), "every payment shares the connection")
+}
+
+func TestISO8583Client_ReversesUnanswered(t *testing.T) {
+	host, l := startISOHost(t, iso8583.WithDecider(func(*iso8583.Message) iso8583.Decision {
+		return iso8583.Decision{Drop: true}
+	}))
+	c := newISOClient(t, l, WithISO8583Timeout(50*time.Millisecond))
+
+	_, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+	require.Error(t, err)
+	assert.Equal(t, "timeout", ErrorClass(err))
+
+	require.Eventually(t, func() bool { return len(host.Received()) == 2 }, time.Second, 10*time.Millisecond)
+	original, reversal := host.Received()[0], host.Received()[1]
+	assert.Equal(t, iso8583.MTIReversalRequest, reversal.MTI)
+	assert.NotEqual(t, original.Get(iso8583.FieldSTAN), reversal.Get(iso8583.FieldSTAN))
+	assert.Equal(t, original.Get(iso8583.FieldRRN), reversal.Get(iso8583.FieldRRN))
+	assert.Equal(t, original.Get(iso8583.FieldAmount), reversal.Get(iso8583.FieldAmount))
+	assert.Equal(t,
+		"0100"+original.Get(iso8583.FieldSTAN)+original.Get(iso8583.FieldTransmissionTime)+"0000000000000000000000",
+		reversal.Get(iso8583.FieldOriginalData))
+}
+
+func TestISO8583Client_Reconnects(t *testing.T) {
+	host, l := startISOHost(t)
+	c := newISOClient(t, l)
+
+	_, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+	require.NoError(t, err)
+
+	host.DropConnections()
+	assert.Eventually(t, func() bool {
+		_, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(2), l.accepted.Load())
+}
+
+func TestISO8583Client_RetriesUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	_, l := startISOHost(t, iso8583.WithDecider(func(*iso8583.Message) iso8583.Decision {
+		if calls.Add(1) == 1 {
+			return iso8583.Decision{ResponseCode: "91"}
+		}
+		return iso8583.Decision{ResponseCode: "00"}
+	}))
+	c := newISOClient(t, l, WithISO8583RetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+
+	resp, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+	require.NoError(t, err)
+	assert.True(t, resp.Authorized)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestISO8583Client_Unreachable(t *testing.T) {
+	host, l := startISOHost(t)
+	c := newISOClient(t, l)
+	host.Close()
+
+	assert.Error(t, c.Ping(context.Background()))
+	_, err := c.ProcessPayment(context.Background(), cardPayment("2222405343248877", "123"))
+	assert.Equal(t, "connection", ErrorClass(err))
+}
+
+func TestISO8583Client_UnknownCurrency(t *testing.T) {
+	host, l := startISOHost(t)
+	c := newISOClient(t, l)
+
+	payment := cardPayment("2222405343248877", "123")
+	payment.Currency = "XTS"
+	_, err := c.ProcessPayment(context.Background(), payment)
+	assert.ErrorIs(t, err, ErrBankRejected)
+	assert.Empty(t, host.Received())
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldMapping describes an acquirer's JSON format for MappedAdapter
+type FieldMapping struct {
+	// Path authorizations are POSTed to, /payments if empty
+	Path     string
+	Request  RequestMapping
+	Response ResponseMapping
+}
+
+// RequestMapping names the field each value is sent in, as a dotted path
+// for nested objects such as "card.pan". A value with no field, or an
+// empty string value, is not sent. Fields are written in the order below.
+type RequestMapping struct {
+	CardNumber string
+	ExpiryDate string
+	// ExpiryFormat lays out the expiry date with MM, YY and YYYY, such as
+	// "MM/YY". MM/YYYY if empty.
+	ExpiryFormat string
+	Currency     string
+	Amount       string
+	// AmountFormat is "number", the default, or "string" for acquirers
+	// that take minor units as a string
+	AmountFormat                 string
+	CVV                          string
+	Initiator                    string
+	StoredCredentialUsage        string
+	StoredCredentialType         string
+	PreviousNetworkTransactionID string
+}
+
+// ResponseMapping names the fields of the acquirer's answer, as dotted
+// paths
+type ResponseMapping struct {
+	// Authorized is the decision. It must be a boolean unless
+	// ApprovedValue is set, in which case the payment is authorized when
+	// the field equals it, as with a response code of "00".
+	Authorized           string
+	ApprovedValue        string
+	AuthorizationCode    string
+	NetworkTransactionID string
+}
+
+// Amount formats accepted in RequestMapping.AmountFormat
+const (
+	AmountNumber = "number"
+	AmountString = "string"
+)
+
+// MappedAdapter speaks any JSON format that can be described with a
+// FieldMapping
+type MappedAdapter struct {
+	mapping FieldMapping
+}
+
+// NewMappedAdapter checks the mapping describes a usable format
+func NewMappedAdapter(m FieldMapping) (*MappedAdapter, error) {
+	if m.Path == "" {
+		m.Path = "/payments"
+	}
+	if m.Request.ExpiryFormat == "" {
+		m.Request.ExpiryFormat = "MM/YYYY"
+	}
+	if m.Request.AmountFormat == "" {
+		m.Request.AmountFormat = AmountNumber
+	}
+
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if !strings.HasPrefix(m.Path, "/") {
+		fail("path must start with /")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"request.card_number", m.Request.CardNumber},
+		{"request.expiry_date", m.Request.ExpiryDate},
+		{"request.currency", m.Request.Currency},
+		{"request.amount", m.Request.Amount},
+		{"response.authorized", m.Response.Authorized},
+	} {
+		if f.path == "" {
+			fail("%s is required", f.name)
+		}
+	}
+	if !strings.Contains(m.Request.ExpiryFormat, "MM") || !strings.Contains(m.Request.ExpiryFormat, "YY") {
+		fail("request.expiry_format must contain MM and YY or YYYY, got %q", m.Request.ExpiryFormat)
+	}
+	if m.Request.AmountFormat != AmountNumber && m.Request.AmountFormat != AmountString {
+		fail("request.amount_format must be %s or %s", AmountNumber, AmountString)
+	}
+
+	a := &MappedAdapter{mapping: m}
+	if len(errs) == 0 {
+		// Fields that clash, such as "card" and "card.pan", only show when
+		// a request is built
+		_, err := a.EncodeAuthorization(&CardAuthorization{
+			CardNumber: "4", CVV: "1", Currency: "X", Initiator: "merchant",
+			StoredCredential: &BankStoredCredential{Usage: "u", Type: "t", PreviousNetworkTransactionID: "p"},
+		})
+		if err != nil {
+			fail("request: %v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return a, nil
+}
+
+func (a *MappedAdapter) AuthorizationPath() string {
+	return a.mapping.Path
+}
+
+func (a *MappedAdapter) EncodeAuthorization(auth *CardAuthorization) ([]byte, error) {
+	m := a.mapping.Request
+
+	var amount any = auth.Amount
+	if m.AmountFormat == AmountString {
+		amount = strconv.Itoa(auth.Amount)
+	}
+
+	var sc BankStoredCredential
+	if auth.StoredCredential != nil {
+		sc = *auth.StoredCredential
+	}
+
+	body := &jsonObject{}
+	for _, f := range []struct {
+		path  string
+		value any
+	}{
+		{m.CardNumber, auth.CardNumber},
+		{m.ExpiryDate, formatExpiry(m.ExpiryFormat, auth.ExpiryMonth, auth.ExpiryYear)},
+		{m.Currency, auth.Currency},
+		{m.Amount, amount},
+		{m.CVV, auth.CVV},
+		{m.Initiator, auth.Initiator},
+		{m.StoredCredentialUsage, sc.Usage},
+		{m.StoredCredentialType, sc.Type},
+		{m.PreviousNetworkTransactionID, sc.PreviousNetworkTransactionID},
+	} {
+		if f.path == "" || f.value == "" {
+			continue
+		}
+		if err := body.set(strings.Split(f.path, "."), f.value); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(body)
+}
+
+func (a *MappedAdapter) DecodeAuthorization(status int, body []byte) (*BankResponse, error) {
+	if status < 200 || status > 299 {
+		return nil, statusError(status, body)
+	}
+
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal bank response: %v", ErrBankUnexpectedResponse, err)
+	}
+
+	m := a.mapping.Response
+	decision, ok := lookup(doc, m.Authorized)
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s in bank response", ErrBankUnexpectedResponse, m.Authorized)
+	}
+
+	var resp BankResponse
+	if m.ApprovedValue != "" {
+		resp.Authorized = scalarString(decision) == m.ApprovedValue
+	} else if resp.Authorized, ok = decision.(bool); !ok {
+		return nil, fmt.Errorf("%w: %s is not a boolean", ErrBankUnexpectedResponse, m.Authorized)
+	}
+
+	if v, ok := lookup(doc, m.AuthorizationCode); ok {
+		resp.AuthorizationCode = scalarString(v)
+	}
+	if v, ok := lookup(doc, m.NetworkTransactionID); ok {
+		resp.NetworkTransactionID = scalarString(v)
+	}
+	return &resp, nil
+}
+
+// formatExpiry lays out the expiry date, replacing YYYY before YY so the
+// four digit year is not mistaken for two two digit ones
+func formatExpiry(layout string, month, year int) string {
+	s := strings.Replace(layout, "YYYY", fmt.Sprintf("%04d", year), 1)
+	s = strings.Replace(s, "YY", fmt.Sprintf("%02d", year%100), 1)
+	return strings.Replace(s, "MM", fmt.Sprintf("%02d", month), 1)
+}
+
+// lookup follows a dotted path through decoded JSON
+func lookup(doc any, path string) (any, bool) {
+	if path == "" {
+		return nil, false
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if doc, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return doc, doc != nil
+}
+
+func scalarString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+// jsonObject is a JSON object that keeps its keys in the order they were
+// set, so a request always encodes to the same bytes in a readable order
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *jsonObject) set(path []string, value any) error {
+	if o.values == nil {
+		o.values = make(map[string]any)
+	}
+
+	key := path[0]
+	if key == "" {
+		return errors.New("field names must not be empty")
+	}
+	existing, exists := o.values[key]
+	if !exists {
+		o.keys = append(o.keys, key)
+	}
+
+	if len(path) == 1 {
+		if exists {
+			return fmt.Errorf("field %q is mapped twice", key)
+		}
+		o.values[key] = value
+		return nil
+	}
+
+	child, ok := existing.(*jsonObject)
+	if exists && !ok {
+		return fmt.Errorf("field %q is both a value and an object", key)
+	}
+	if !ok {
+		child = &jsonObject{}
+		o.values[key] = child
+	}
+	return child.set(path[1:], value)
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validMapping() FieldMapping {
+	return FieldMapping{
+		Request: RequestMapping{
+			CardNumber: "pan",
+			ExpiryDate: "expiry",
+			Currency:   "currency",
+			Amount:     "amount",
+			CVV:        "cvv",
+		},
+		Response: ResponseMapping{Authorized: "approved"},
+	}
+}
+
+func TestNewMappedAdapter_Validation(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(m *FieldMapping)
+		expected []string
+	}{
+		{
+			name:     "missing fields",
+			change:   func(m *FieldMapping) { m.Request.CardNumber, m.Response.Authorized = "", "" },
+			expected: []string{"request.card_number is required", "response.authorized is required"},
+		},
+		{
+			name:     "relative path",
+			change:   func(m *FieldMapping) { m.Path = "payments" },
+			expected: []string{"path must start with /"},
+		},
+		{
+			name:     "expiry without a year",
+			change:   func(m *FieldMapping) { m.Request.ExpiryFormat = "MM" },
+			expected: []string{"request.expiry_format must contain MM and YY or YYYY"},
+		},
+		{
+			name:     "unknown amount format",
+			change:   func(m *FieldMapping) { m.Request.AmountFormat = "decimal" },
+			expected: []string{"request.amount_format must be number or string"},
+		},
+		{
+			name:     "field mapped twice",
+			change:   func(m *FieldMapping) { m.Request.CVV = "pan" },
+			expected: []string{`field "pan" is mapped twice`},
+		},
+		{
+			name:     "field is a value and an object",
+			change:   func(m *FieldMapping) { m.Request.CVV = "pan.cvv" },
+			expected: []string{`field "pan" is both a value and an object`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validMapping()
+			tt.change(&m)
+
+			_, err := NewMappedAdapter(m)
+			require.Error(t, err)
+			for _, msg := range tt.expected {
+				assert.ErrorContains(t, err, msg)
+			}
+		})
+	}
+}
+
+func TestMappedAdapter_Encode(t *testing.T) {
+	m := validMapping()
+	m.Request.ExpiryFormat = "YYYYMM"
+	adapter, err := NewMappedAdapter(m)
+	require.NoError(t, err)
+	assert.Equal(t, "/payments", adapter.AuthorizationPath())
+
+	body, err := adapter.EncodeAuthorization(&CardAuthorization{
+		CardNumber: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, Currency: "GBP", Amount: 100,
+		Initiator: "merchant",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `{"pan":"2222405343248877","expiry":"203004","currency":"GBP","amount":100}`, string(body),
+		"fields are written in order and unmapped or empty ones are left out")
+}
+
+func TestMappedAdapter_DecodeNonBoolean(t *testing.T) {
+	adapter, err := NewMappedAdapter(validMapping())
+	require.NoError(t, err)
+
+	_, err = adapter.DecodeAuthorization(http.StatusOK, []byte(`{"approved":"yes"}`))
+	assert.ErrorIs(t, err, ErrBankUnexpectedResponse)
+	_, err = adapter.DecodeAuthorization(http.StatusOK, []byte(`{"result":true}`))
+	assert.ErrorIs(t, err, ErrBankUnexpectedResponse)
+
+	resp, err := adapter.DecodeAuthorization(http.StatusCreated, []byte(`{"approved":true}`))
+	require.NoError(t, err)
+	assert.True(t, resp.Authorized)
+}
+
+func TestAdapterRegistry(t *testing.T) {
+	registry := DefaultAdapters()
+	assert.Equal(t, []string{AdapterMapped, AdapterSimulator}, registry.Names())
+
+	adapter, err := registry.New(AdapterSimulator, FieldMapping{})
+	require.NoError(t, err)
+	assert.Equal(t, SimulatorAdapter{}, adapter)
+
+	_, err = registry.New("iso8583", FieldMapping{})
+	assert.ErrorIs(t, err, ErrUnknownAdapter)
+	assert.ErrorContains(t, err, "expected one of [mapped simulator]")
+
+	_, err = registry.New(AdapterMapped, FieldMapping{})
+	assert.ErrorContains(t, err, `bank adapter "mapped": request.card_number is required`)
+
+	registry.Register("custom", func(FieldMapping) (Adapter, error) { return SimulatorAdapter{}, nil })
+	assert.Contains(t, registry.Names(), "custom")
+}
@@ -0,0 +1,385 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+// CassetteVersion is the cassette format written by Recorder
+const CassetteVersion = 1
+
+// Cassette is a recording of the traffic between the gateway and the bank,
+// with card numbers and CVVs replaced by tokens, so it can be kept in the
+// repository and replayed in tests
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one payment sent to the bank: every attempt that went over
+// the wire, and what the client made of them
+type Interaction struct {
+	Exchanges []Exchange `json:"exchanges"`
+	Outcome   Outcome    `json:"outcome"`
+}
+
+// Exchange is one HTTP request to the bank and its answer, exactly as sent
+// and received apart from card data
+type Exchange struct {
+	Method       string         `json:"method"`
+	Path         string         `json:"path"`
+	RequestBody  string         `json:"request_body"`
+	Status       int            `json:"status,omitempty"`
+	ResponseBody string         `json:"response_body,omitempty"`
+	Error        *ExchangeError `json:"error,omitempty"`
+
+	// card is what the exchange must be sanitized of. It is never saved.
+	card cardData
+}
+
+// ExchangeError is a request that failed on the wire. With a Status the
+// answer started but its body could not be read.
+type ExchangeError struct {
+	Message string `json:"message"`
+	Timeout bool   `json:"timeout,omitempty"`
+	// Op is the failed network operation, such as "dial" or "read"
+	Op string `json:"op,omitempty"`
+}
+
+// Outcome is what the client returned for an interaction
+type Outcome struct {
+	Authorized        bool   `json:"authorized"`
+	AuthorizationCode string `json:"authorization_code,omitempty"`
+	// ErrorClass is ErrorClass of the error returned, empty on success
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+func outcomeOf(resp *BankResponse, err error) Outcome {
+	if err != nil {
+		return Outcome{ErrorClass: ErrorClass(err)}
+	}
+	return Outcome{Authorized: resp.Authorized, AuthorizationCode: resp.AuthorizationCode}
+}
+
+// LoadCassette reads a cassette written by Recorder
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	if c.Version != CassetteVersion {
+		return nil, fmt.Errorf("cassette %s has version %d, expected %d", path, c.Version, CassetteVersion)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette to path, replacing the file in one step so a
+// reader never sees half of it
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Payments rebuilds the payments recorded in the cassette, one per
+// interaction, for a test to send to a ReplayClient. Card numbers and CVVs
+// are made up, but stand for the same tokens when replayed.
+func (c *Cassette) Payments() ([]*domain.Payment, error) {
+	payments := make([]*domain.Payment, 0, len(c.Interactions))
+	for i, ix := range c.Interactions {
+		if len(ix.Exchanges) == 0 {
+			return nil, fmt.Errorf("interaction %d has no exchanges", i)
+		}
+
+		var req BankRequest
+		if err := json.Unmarshal([]byte(ix.Exchanges[0].RequestBody), &req); err != nil {
+			return nil, fmt.Errorf("interaction %d: %w", i, err)
+		}
+
+		p := &domain.Payment{
+			Card: domain.Card{
+				Number: detokenizeRecorded(req.CardNumber, panToken, "4%015d"),
+				CVV:    detokenizeRecorded(req.CVV, cvvToken, "%03d"),
+			},
+			Currency:  req.Currency,
+			Amount:    req.Amount,
+			Initiator: domain.Initiator(req.Initiator),
+		}
+		if _, err := fmt.Sscanf(req.ExpiryDate, "%d/%d", &p.Card.ExpiryMonth, &p.Card.ExpiryYear); err != nil {
+			return nil, fmt.Errorf("interaction %d: invalid expiry date %q", i, req.ExpiryDate)
+		}
+		if sc := req.StoredCredential; sc != nil {
+			p.StoredCredential = &domain.StoredCredential{
+				Usage:                        domain.StoredCredentialUsage(sc.Usage),
+				Type:                         domain.StoredCredentialType(sc.Type),
+				PreviousNetworkTransactionID: sc.PreviousNetworkTransactionID,
+			}
+		}
+		payments = append(payments, p)
+	}
+	return payments, nil
+}
+
+// exchangeHook is told about every request HTTPBankClient sends
+type exchangeHook func(Exchange)
+
+type exchangeHookKey struct{}
+
+// withExchangeHook adds hook to ctx, keeping any hook already there
+func withExchangeHook(ctx context.Context, hook exchangeHook) context.Context {
+	if outer := exchangeHookFrom(ctx); outer != nil {
+		inner := hook
+		hook = func(e Exchange) {
+			inner(e)
+			outer(e)
+		}
+	}
+	return context.WithValue(ctx, exchangeHookKey{}, hook)
+}
+
+func exchangeHookFrom(ctx context.Context) exchangeHook {
+	hook, _ := ctx.Value(exchangeHookKey{}).(exchangeHook)
+	return hook
+}
+
+// cardData is the card number and CVV of the payment being sent
+type cardData struct {
+	number string
+	cvv    string
+}
+
+type cardDataKey struct{}
+
+func withCardData(ctx context.Context, auth *CardAuthorization) context.Context {
+	return context.WithValue(ctx, cardDataKey{}, cardData{number: auth.CardNumber, cvv: auth.CVV})
+}
+
+func newExchange(req *http.Request, reqBody []byte, status int, body []byte, err error) Exchange {
+	e := Exchange{
+		Method:       req.Method,
+		Path:         req.URL.Path,
+		RequestBody:  string(reqBody),
+		Status:       status,
+		ResponseBody: string(body),
+	}
+	e.card, _ = req.Context().Value(cardDataKey{}).(cardData)
+	if err != nil {
+		e.Error = &ExchangeError{Message: err.Error()}
+		var netErr net.Error
+		e.Error.Timeout = errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			e.Error.Op = opErr.Op
+		}
+	}
+	return e
+}
+
+// Recorder is a BankClient that passes payments on to another, recording
+// what HTTPBankClient sends and receives for them into a Cassette. Card
+// numbers and CVVs never reach the cassette: each one is swapped for a
+// token, the same token every time the same value is seen.
+type Recorder struct {
+	next BankClient
+	path string
+
+	mu        sync.Mutex
+	sanitizer *sanitizer
+	cassette  Cassette
+}
+
+// RecorderOption configures a Recorder
+type RecorderOption func(*Recorder)
+
+// WithCassetteFile saves the cassette to path after every interaction,
+// replacing whatever the file held before
+func WithCassetteFile(path string) RecorderOption {
+	return func(r *Recorder) {
+		r.path = path
+	}
+}
+
+func NewRecorder(next BankClient, opts ...RecorderOption) *Recorder {
+	r := &Recorder{
+		next:      next,
+		sanitizer: newSanitizer(),
+		cassette:  Cassette{Version: CassetteVersion, Interactions: []Interaction{}},
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+func (r *Recorder) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	var exchanges []Exchange
+	ctx = withExchangeHook(ctx, func(e Exchange) {
+		exchanges = append(exchanges, e)
+	})
+
+	resp, err := r.next.ProcessPayment(ctx, payment)
+
+	// A payment that never reached the bank says nothing about it
+	if len(exchanges) > 0 {
+		r.record(ctx, exchanges, outcomeOf(resp, err))
+	}
+
+	return resp, err
+}
+
+func (r *Recorder) record(ctx context.Context, exchanges []Exchange, outcome Outcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range exchanges {
+		r.sanitizer.exchange(&exchanges[i])
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{Exchanges: exchanges, Outcome: outcome})
+
+	if r.path == "" {
+		return
+	}
+	if err := r.cassette.Save(r.path); err != nil {
+		slog.WarnContext(ctx, "failed to save bank cassette", "path", r.path, "error", err)
+	}
+}
+
+// Cassette returns a copy of what has been recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.cassette
+	c.Interactions = append([]Interaction(nil), r.cassette.Interactions...)
+	return &c
+}
+
+// Settle passes settlements on without recording them; cassettes only
+// hold authorizations
+func (r *Recorder) Settle(ctx context.Context, settlement *Settlement) error {
+	if s, ok := r.next.(Settler); ok {
+		return s.Settle(ctx, settlement)
+	}
+	return domain.ErrSettlementUnsupported
+}
+
+// Ping asks the wrapped client if it can
+func (r *Recorder) Ping(ctx context.Context) error {
+	if p, ok := r.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+const (
+	panToken = "pan_tok_"
+	cvvToken = "cvv_tok_"
+)
+
+// sanitizer swaps the card numbers and CVVs in bank requests for tokens
+// numbered in the order they are first seen, so the same traffic sent again
+// is sanitized to the same cassette
+type sanitizer struct {
+	tokens map[string]string
+	counts map[string]int
+}
+
+func newSanitizer() *sanitizer {
+	return &sanitizer{tokens: make(map[string]string), counts: make(map[string]int)}
+}
+
+// exchange sanitizes e in place of the card number and CVV that were sent,
+// wherever the adapter put them in the request and should the bank echo
+// them in its answer. The card number is also replaced where it appears
+// unquoted, e.g. inside a longer string; the CVV is too short for that to be
+// safe.
+func (s *sanitizer) exchange(e *Exchange) {
+	for _, field := range []struct {
+		value, prefix string
+		bare          bool
+	}{
+		{e.card.number, panToken, true},
+		{e.card.cvv, cvvToken, false},
+	} {
+		if field.value == "" {
+			continue
+		}
+		token := s.token(field.value, field.prefix)
+		replace := func(text string) string {
+			text = replaceQuoted(text, field.value, token)
+			if field.bare {
+				text = strings.ReplaceAll(text, field.value, token)
+			}
+			return text
+		}
+		e.RequestBody = replace(e.RequestBody)
+		e.ResponseBody = replace(e.ResponseBody)
+		if e.Error != nil {
+			e.Error.Message = replace(e.Error.Message)
+		}
+	}
+}
+
+func (s *sanitizer) token(value, prefix string) string {
+	if token, ok := s.tokens[value]; ok {
+		return token
+	}
+	s.counts[prefix]++
+	token := fmt.Sprintf("%s%d", prefix, s.counts[prefix])
+	s.tokens[value] = token
+	return token
+}
+
+// replaceQuoted replaces value where it appears as a JSON string
+func replaceQuoted(s, value, token string) string {
+	quote := func(v string) []byte {
+		b, _ := json.Marshal(v)
+		return b
+	}
+	return string(bytes.ReplaceAll([]byte(s), quote(value), quote(token)))
+}
+
+// detokenizeRecorded turns a token back into a made-up value of the same
+// kind, or returns value if it is not a token
+func detokenizeRecorded(value, prefix, format string) string {
+	var n int
+	if _, err := fmt.Sscanf(value, prefix+"%d", &n); err != nil {
+		return value
+	}
+	return fmt.Sprintf(format, n)
+}
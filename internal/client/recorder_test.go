@@ -0,0 +1,200 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cardPayment(number, cvv string) *domain.Payment {
+	return &domain.Payment{
+		Card:     domain.Card{Number: number, ExpiryMonth: 4, ExpiryYear: 2030, CVV: cvv},
+		Currency: "GBP",
+		Amount:   1050,
+	}
+}
+
+// record sends payments through a Recorder to the bank simulator playing
+// sc, and returns the cassette file it wrote
+func record(t *testing.T, sc banksim.Scenario, payments ...*domain.Payment) string {
+	t.Helper()
+	sim := banksim.New()
+	sc.Name = "recording"
+	require.NoError(t, sim.Define(sc))
+	require.NoError(t, sim.Activate(sc.Name))
+	bank := httptest.NewServer(sim)
+	t.Cleanup(bank.Close)
+
+	path := filepath.Join(t.TempDir(), "bank.cassette.json")
+	recorder := NewRecorder(
+		NewHTTPBankClient(bank.URL,
+			WithTimeout(100*time.Millisecond),
+			WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+		),
+		WithCassetteFile(path),
+	)
+	for _, p := range payments {
+		recorder.ProcessPayment(context.Background(), p)
+	}
+	require.Len(t, recorder.Cassette().Interactions, len(payments))
+	return path
+}
+
+// replay sends the payments in the cassette at path to a ReplayClient and
+// returns what each got back
+func replay(t *testing.T, path string) []Outcome {
+	t.Helper()
+	cassette, err := LoadCassette(path)
+	require.NoError(t, err)
+	payments, err := cassette.Payments()
+	require.NoError(t, err)
+
+	replayer := NewReplayClient(cassette, WithTimeout(100*time.Millisecond))
+	var outcomes []Outcome
+	for _, p := range payments {
+		resp, err := replayer.ProcessPayment(context.Background(), p)
+		require.NotErrorIs(t, err, ErrReplayMismatch)
+		outcomes = append(outcomes, outcomeOf(resp, err))
+	}
+
+	assert.Zero(t, replayer.Remaining())
+	_, err = replayer.ProcessPayment(context.Background(), payments[0])
+	assert.ErrorIs(t, err, ErrCassetteExhausted)
+	return outcomes
+}
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	path := record(t, banksim.Scenario{},
+		cardPayment("2222405343248877", "123"), // authorized
+		cardPayment("2222405343248112", "456"), // declined
+		cardPayment("2222405343248110", "123"), // unavailable, retried
+		cardPayment("2222405343248877", "789"), // same card again
+	)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	for _, secret := range []string{"2222405343248877", "2222405343248112", "2222405343248110", `"123"`, `"456"`, `"789"`} {
+		assert.NotContains(t, string(data), secret)
+	}
+
+	cassette, err := LoadCassette(path)
+	require.NoError(t, err)
+	require.Len(t, cassette.Interactions, 4)
+
+	first := cassette.Interactions[0]
+	require.Len(t, first.Exchanges, 1)
+	assert.Equal(t, "POST", first.Exchanges[0].Method)
+	assert.Equal(t, "/payments", first.Exchanges[0].Path)
+	assert.JSONEq(t, `{"card_number":"pan_tok_1","expiry_date":"04/2030","currency":"GBP","amount":1050,"cvv":"cvv_tok_1"}`, first.Exchanges[0].RequestBody)
+	assert.Equal(t, 200, first.Exchanges[0].Status)
+	assert.True(t, first.Outcome.Authorized)
+	assert.NotEmpty(t, first.Outcome.AuthorizationCode)
+
+	assert.Equal(t, Outcome{}, cassette.Interactions[1].Outcome)
+	assert.Len(t, cassette.Interactions[2].Exchanges, 3, "every attempt is recorded")
+	assert.Equal(t, Outcome{ErrorClass: "unavailable"}, cassette.Interactions[2].Outcome)
+	assert.Contains(t, cassette.Interactions[2].Exchanges[0].RequestBody, `"cvv":"cvv_tok_1"`, "the same CVV gets the same token")
+	assert.Contains(t, cassette.Interactions[3].Exchanges[0].RequestBody, `"card_number":"pan_tok_1"`, "the same card gets the same token")
+
+	outcomes := replay(t, path)
+	for i, ix := range cassette.Interactions {
+		assert.Equal(t, ix.Outcome, outcomes[i])
+	}
+}
+
+func TestRecorder_WireFailures(t *testing.T) {
+	payment := cardPayment("2222405343248877", "123")
+
+	tests := []struct {
+		name          string
+		scenario      banksim.Scenario
+		expectedClass string
+	}{
+		{name: "reset mid-response", scenario: banksim.Scenario{Resets: banksim.Fault{Rate: 1}}, expectedClass: "connection"},
+		{name: "timeout", scenario: banksim.Scenario{Outages: []banksim.Outage{{Mode: banksim.Hang}}}, expectedClass: "timeout"},
+		{name: "malformed JSON", scenario: banksim.Scenario{Malformed: banksim.Fault{Rate: 1}}, expectedClass: "unexpected_response"},
+		{name: "server error", scenario: banksim.Scenario{Errors: banksim.Errors{Rate: 1, Statuses: []int{502}}}, expectedClass: "unexpected_response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := record(t, tt.scenario, payment)
+
+			cassette, err := LoadCassette(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedClass, cassette.Interactions[0].Outcome.ErrorClass)
+
+			assert.Equal(t, []Outcome{{ErrorClass: tt.expectedClass}}, replay(t, path))
+		})
+	}
+}
+
+func TestReplayClient_Mismatch(t *testing.T) {
+	path := record(t, banksim.Scenario{}, cardPayment("2222405343248877", "123"))
+
+	tests := []struct {
+		name     string
+		change   func(ix *Interaction)
+		expected string
+	}{
+		{
+			name:     "request changed",
+			change:   func(ix *Interaction) { ix.Exchanges[0].RequestBody = `{"card_number":"pan_tok_1"}` },
+			expected: "sent POST /payments",
+		},
+		{
+			name:     "response read differently",
+			change:   func(ix *Interaction) { ix.Outcome.Authorized = false },
+			expected: "recorded {Authorized:false",
+		},
+		{
+			name: "fewer attempts",
+			change: func(ix *Interaction) {
+				ix.Exchanges[0].Status, ix.Exchanges[0].ResponseBody = 500, "{}"
+				ix.Exchanges = append(ix.Exchanges, ix.Exchanges[0])
+			},
+			expected: "sent 1 of 2 recorded requests",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cassette, err := LoadCassette(path)
+			require.NoError(t, err)
+			tt.change(&cassette.Interactions[0])
+
+			_, err = NewReplayClient(cassette).ProcessPayment(context.Background(), cardPayment("4000000000000001", "001"))
+			require.ErrorIs(t, err, ErrReplayMismatch)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+// TestReplayClient_Cassette replays traffic recorded from the bank, so a
+// change to the bank's format, or to how the client reads it, shows here
+func TestReplayClient_Cassette(t *testing.T) {
+	outcomes := replay(t, filepath.Join("testdata", "bank.cassette.json"))
+
+	assert.Equal(t, []Outcome{
+		{Authorized: true, AuthorizationCode: "0bb07405-6d44-48fb-a0a5-28f6a4c7a48f"},
+		{},
+		{ErrorClass: "unavailable"},
+		{ErrorClass: "rejected"},
+	}, outcomes)
+}
+
+func TestLoadCassette_Version(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":0,"interactions":[]}`), 0o600))
+
+	_, err := LoadCassette(path)
+	assert.ErrorContains(t, err, "has version 0, expected 1")
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+var (
+	// ErrReplayMismatch means the client no longer sends what the cassette
+	// recorded, or makes something different of the bank's answers
+	ErrReplayMismatch = errors.New("bank traffic does not match the cassette")
+	// ErrCassetteExhausted means every interaction in the cassette has
+	// already been replayed
+	ErrCassetteExhausted = errors.New("no more interactions in the cassette")
+)
+
+// ReplayClient is a BankClient that plays a cassette back, in the order it
+// was recorded. Each payment goes through a real HTTPBankClient whose
+// requests are answered from the cassette instead of the bank, so a change
+// to what the client sends, or to how it reads the bank's answers, fails
+// with ErrReplayMismatch.
+type ReplayClient struct {
+	http *HTTPBankClient
+
+	mu        sync.Mutex
+	cassette  *Cassette
+	next      int
+	sanitizer *sanitizer
+}
+
+// NewReplayClient plays cassette back. The options configure the
+// HTTPBankClient that does the work; its retry policy is set from the
+// cassette.
+func NewReplayClient(cassette *Cassette, opts ...Option) *ReplayClient {
+	c := &ReplayClient{cassette: cassette, sanitizer: newSanitizer()}
+	opts = append(opts, WithTransport(replayTransport{}))
+	c.http = NewHTTPBankClient("http://bank.replay.invalid", opts...)
+	return c
+}
+
+// Remaining is how many interactions have not been replayed yet
+func (c *ReplayClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.cassette.Interactions) - c.next
+}
+
+func (c *ReplayClient) ProcessPayment(ctx context.Context, payment *domain.Payment) (*BankResponse, error) {
+	// One at a time, so the interactions are played in order
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next >= len(c.cassette.Interactions) {
+		return nil, ErrCassetteExhausted
+	}
+	ix := c.cassette.Interactions[c.next]
+	n := c.next
+	c.next++
+
+	// The client may retry as often as it did when recording, and is
+	// caught out below if it now gives up sooner
+	timeout, retry := c.http.settings()
+	retry.MaxAttempts = len(ix.Exchanges)
+	retry.InitialBackoff, retry.MaxBackoff = 0, 0
+	c.http.Configure(timeout, retry)
+
+	cursor := &replayCursor{interaction: ix, sanitizer: c.sanitizer}
+	resp, err := c.http.ProcessPayment(context.WithValue(ctx, replayCursorKey{}, cursor), payment)
+
+	switch {
+	case cursor.mismatch != nil:
+		return nil, fmt.Errorf("%w: interaction %d: %v", ErrReplayMismatch, n, cursor.mismatch)
+	case cursor.sent < len(ix.Exchanges):
+		return nil, fmt.Errorf("%w: interaction %d: sent %d of %d recorded requests", ErrReplayMismatch, n, cursor.sent, len(ix.Exchanges))
+	}
+
+	outcome := outcomeOf(resp, err)
+	if outcome != ix.Outcome {
+		return nil, fmt.Errorf("%w: interaction %d: got %+v, recorded %+v", ErrReplayMismatch, n, outcome, ix.Outcome)
+	}
+
+	return resp, err
+}
+
+// Ping always succeeds; there is no bank to reach
+func (c *ReplayClient) Ping(context.Context) error {
+	return nil
+}
+
+// replayCursor tracks how far through an interaction a payment has got
+type replayCursor struct {
+	interaction Interaction
+	sanitizer   *sanitizer
+	sent        int
+	mismatch    error
+}
+
+type replayCursorKey struct{}
+
+// replayTransport answers requests from the interaction in their context
+type replayTransport struct{}
+
+func (replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cursor, _ := req.Context().Value(replayCursorKey{}).(*replayCursor)
+	if cursor == nil {
+		return nil, errors.New("replay client used without a cassette")
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if cursor.sent >= len(cursor.interaction.Exchanges) {
+		cursor.mismatch = fmt.Errorf("request %d was not recorded", cursor.sent+1)
+		return nil, cursor.mismatch
+	}
+	recorded := cursor.interaction.Exchanges[cursor.sent]
+	cursor.sent++
+
+	got := newExchange(req, body, 0, nil, nil)
+	cursor.sanitizer.exchange(&got)
+	if got.Method != recorded.Method || got.Path != recorded.Path || got.RequestBody != recorded.RequestBody {
+		cursor.mismatch = fmt.Errorf("sent %s %s %s, recorded %s %s %s",
+			got.Method, got.Path, got.RequestBody, recorded.Method, recorded.Path, recorded.RequestBody)
+		return nil, cursor.mismatch
+	}
+
+	if recorded.Error != nil && recorded.Status == 0 {
+		return nil, recorded.Error.err()
+	}
+
+	resp := &http.Response{
+		StatusCode: recorded.Status,
+		Status:     fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(recorded.ResponseBody)),
+		Request:    req,
+	}
+	if recorded.Error != nil {
+		// The answer broke off part way through the body
+		resp.Body = io.NopCloser(io.MultiReader(strings.NewReader(recorded.ResponseBody), errReader{recorded.Error.err()}))
+	}
+	return resp, nil
+}
+
+// err recreates the recorded error closely enough for the client to treat
+// it the same way
+func (e *ExchangeError) err() error {
+	err := errors.New(e.Message)
+	if e.Timeout {
+		err = fmt.Errorf("%s: %w", e.Message, context.DeadlineExceeded)
+	}
+	if e.Op != "" {
+		err = &net.OpError{Op: e.Op, Net: "tcp", Err: err}
+	}
+	return err
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
@@ -0,0 +1,690 @@
+// Package config loads the gateway's settings from a YAML file, GATEWAY_*
+// environment variables and command-line flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"gopkg.in/yaml.v3"
+)
+
+// Storage backends accepted in Storage.Backend
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
+// Protocols accepted in Bank.Protocol
+const (
+	ProtocolHTTP    = "http"
+	ProtocolISO8583 = "iso8583"
+)
+
+// Formats accepted in Logging.Format, matching the logging package
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Config is every setting the gateway reads at startup. Defaults are
+// overridden by the file, then the environment, then flags.
+//
+// Secrets such as master keys are deliberately not part of it: they are only
+// ever read from the environment or a key file, so they never end up in a
+// config file. Only where to find them is.
+type Config struct {
+	Server        Server        `yaml:"server"`
+	GRPC          GRPC          `yaml:"grpc"`
+	Bank          Bank          `yaml:"bank"`
+	Storage       Storage       `yaml:"storage"`
+	MasterKeys    MasterKeys    `yaml:"master_keys"`
+	Async         Async         `yaml:"async"`
+	Batches       Batches       `yaml:"batches"`
+	Subscriptions Subscriptions `yaml:"subscriptions"`
+	Webhooks      Webhooks      `yaml:"webhooks"`
+	Currencies    []string      `yaml:"currencies" env:"GATEWAY_CURRENCIES"`
+	TLS           TLS           `yaml:"tls"`
+	Logging       Logging       `yaml:"logging"`
+	RateLimits    RateLimits    `yaml:"rate_limits"`
+	Merchants     []Merchant    `yaml:"merchants"`
+}
+
+type Server struct {
+	Addr              string        `yaml:"addr" env:"GATEWAY_ADDR"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"GATEWAY_SERVER_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"GATEWAY_SERVER_READ_TIMEOUT"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"GATEWAY_SERVER_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"GATEWAY_SERVER_IDLE_TIMEOUT"`
+	// DrainDelay is how long readiness fails before the server stops
+	// accepting connections on shutdown
+	DrainDelay time.Duration `yaml:"drain_delay" env:"GATEWAY_SERVER_DRAIN_DELAY"`
+	// ShutdownTimeout bounds how long in-flight requests get to finish
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"GATEWAY_SERVER_SHUTDOWN_TIMEOUT"`
+	// MaxBodyBytes caps the size of a request body
+	MaxBodyBytes int64 `yaml:"max_body_bytes" env:"GATEWAY_SERVER_MAX_BODY_BYTES"`
+}
+
+// GRPC is where the gRPC API listens, alongside the REST API. It uses the
+// same TLS settings. An empty Addr turns it off.
+type GRPC struct {
+	Addr string `yaml:"addr" env:"GATEWAY_GRPC_ADDR"`
+}
+
+type Bank struct {
+	URL            string         `yaml:"url" env:"GATEWAY_BANK_URL"`
+	Timeout        time.Duration  `yaml:"timeout" env:"GATEWAY_BANK_TIMEOUT"`
+	Retry          Retry          `yaml:"retry"`
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+	Concurrency    Concurrency    `yaml:"concurrency"`
+	// RecordTo, if set, records all bank traffic with card data tokenized
+	// into a cassette at this path, to be replayed in tests
+	RecordTo string `yaml:"record_to" env:"GATEWAY_BANK_RECORD_TO"`
+	// Adapter names the acquirer format: simulator, or mapped to describe
+	// another acquirer's JSON with Mapping
+	Adapter string       `yaml:"adapter" env:"GATEWAY_BANK_ADAPTER"`
+	Mapping FieldMapping `yaml:"mapping"`
+	// Protocol is http, to reach the bank at URL, or iso8583 for an
+	// acquirer that takes ISO 8583 messages over TCP at ISO8583.Addr
+	Protocol string  `yaml:"protocol" env:"GATEWAY_BANK_PROTOCOL"`
+	ISO8583  ISO8583 `yaml:"iso8583"`
+}
+
+// ISO8583 is how the gateway connects to an ISO 8583 acquirer
+type ISO8583 struct {
+	Addr string `yaml:"addr" env:"GATEWAY_BANK_ISO8583_ADDR"`
+	// TerminalID and MerchantID are what the acquirer knows the gateway by,
+	// up to 8 and 15 characters
+	TerminalID string `yaml:"terminal_id" env:"GATEWAY_BANK_ISO8583_TERMINAL_ID"`
+	MerchantID string `yaml:"merchant_id" env:"GATEWAY_BANK_ISO8583_MERCHANT_ID"`
+	// Financial sends 0200 messages, which authorize and capture in one,
+	// instead of 0100 authorizations
+	Financial bool `yaml:"financial"`
+}
+
+// FieldMapping describes an acquirer's JSON format for the mapped adapter.
+// Fields are dotted paths, such as card.pan for a nested object.
+type FieldMapping struct {
+	Path     string          `yaml:"path"`
+	Request  RequestMapping  `yaml:"request"`
+	Response ResponseMapping `yaml:"response"`
+}
+
+type RequestMapping struct {
+	CardNumber string `yaml:"card_number"`
+	ExpiryDate string `yaml:"expiry_date"`
+	// ExpiryFormat lays out the expiry date with MM, YY and YYYY
+	ExpiryFormat string `yaml:"expiry_format"`
+	Currency     string `yaml:"currency"`
+	Amount       string `yaml:"amount"`
+	// AmountFormat is number or string
+	AmountFormat                 string `yaml:"amount_format"`
+	CVV                          string `yaml:"cvv"`
+	Initiator                    string `yaml:"initiator"`
+	StoredCredentialUsage        string `yaml:"stored_credential_usage"`
+	StoredCredentialType         string `yaml:"stored_credential_type"`
+	PreviousNetworkTransactionID string `yaml:"previous_network_transaction_id"`
+}
+
+type ResponseMapping struct {
+	Authorized string `yaml:"authorized"`
+	// ApprovedValue, if set, authorizes a payment when Authorized equals it
+	ApprovedValue        string `yaml:"approved_value"`
+	AuthorizationCode    string `yaml:"authorization_code"`
+	NetworkTransactionID string `yaml:"network_transaction_id"`
+}
+
+type Retry struct {
+	MaxAttempts    int           `yaml:"max_attempts" env:"GATEWAY_BANK_RETRY_MAX_ATTEMPTS"`
+	InitialBackoff time.Duration `yaml:"initial_backoff" env:"GATEWAY_BANK_RETRY_INITIAL_BACKOFF"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" env:"GATEWAY_BANK_RETRY_MAX_BACKOFF"`
+}
+
+type CircuitBreaker struct {
+	FailureThreshold int           `yaml:"failure_threshold" env:"GATEWAY_BANK_CIRCUIT_BREAKER_FAILURE_THRESHOLD"`
+	Cooldown         time.Duration `yaml:"cooldown" env:"GATEWAY_BANK_CIRCUIT_BREAKER_COOLDOWN"`
+}
+
+// Concurrency adapts how many calls may be waiting on the bank at once:
+// the limit starts at InitialLimit, grows while the bank answers within
+// LatencyThreshold and is multiplied by BackoffRatio when it does not.
+// Payments over the limit queue for at most MaxQueueWait, and are turned
+// away with a 503 if they cannot be sent by then or the queue is full.
+type Concurrency struct {
+	InitialLimit     int           `yaml:"initial_limit" env:"GATEWAY_BANK_CONCURRENCY_INITIAL_LIMIT"`
+	MinLimit         int           `yaml:"min_limit" env:"GATEWAY_BANK_CONCURRENCY_MIN_LIMIT"`
+	MaxLimit         int           `yaml:"max_limit" env:"GATEWAY_BANK_CONCURRENCY_MAX_LIMIT"`
+	LatencyThreshold time.Duration `yaml:"latency_threshold" env:"GATEWAY_BANK_CONCURRENCY_LATENCY_THRESHOLD"`
+	BackoffRatio     float64       `yaml:"backoff_ratio"`
+	MaxQueueLength   int           `yaml:"max_queue_length" env:"GATEWAY_BANK_CONCURRENCY_MAX_QUEUE_LENGTH"`
+	MaxQueueWait     time.Duration `yaml:"max_queue_wait" env:"GATEWAY_BANK_CONCURRENCY_MAX_QUEUE_WAIT"`
+}
+
+// Storage chooses where data that must survive a restart is kept. With the
+// file backend payments, tokenized cards, the blocklist, subscriptions, the
+// webhook outbox and queued asynchronous payments are journaled under Dir.
+// Payment batches and idempotency keys are only ever kept in memory.
+type Storage struct {
+	Backend string `yaml:"backend" env:"GATEWAY_STORAGE_BACKEND"`
+	Dir     string `yaml:"dir" env:"GATEWAY_STORAGE_DIR"`
+}
+
+// MasterKeys is where the master keys that encrypt card data come from: the
+// JSON key file at File, which is reloaded when it changes, or
+// GATEWAY_MASTER_KEY_V<version> environment variables. The gateway does not
+// start without one, unless Ephemeral generates a key for the process
+// instead. That is only for local development: card data stored with it
+// cannot be read after a restart.
+type MasterKeys struct {
+	File      string `yaml:"file" env:"GATEWAY_MASTER_KEY_FILE"`
+	Ephemeral bool   `yaml:"ephemeral" env:"GATEWAY_MASTER_KEY_EPHEMERAL"`
+}
+
+// Async sizes the worker pool that sends payments made with
+// Prefer: respond-async to the bank. Payments beyond QueueDepth are turned
+// away with a 503; a payment the bank cannot be reached for is rejected
+// after MaxAttempts.
+type Async struct {
+	Workers     int `yaml:"workers" env:"GATEWAY_ASYNC_WORKERS"`
+	QueueDepth  int `yaml:"queue_depth" env:"GATEWAY_ASYNC_QUEUE_DEPTH"`
+	MaxAttempts int `yaml:"max_attempts" env:"GATEWAY_ASYNC_MAX_ATTEMPTS"`
+}
+
+// Batches limits uploads to POST /api/payment-batches. Concurrency is how
+// many of a batch's payments are with the bank at once. MaxBodyBytes
+// replaces server.max_body_bytes for the upload, which is usually far
+// larger than a single payment.
+type Batches struct {
+	Concurrency  int   `yaml:"concurrency" env:"GATEWAY_BATCHES_CONCURRENCY"`
+	MaxItems     int   `yaml:"max_items" env:"GATEWAY_BATCHES_MAX_ITEMS"`
+	MaxBodyBytes int64 `yaml:"max_body_bytes" env:"GATEWAY_BATCHES_MAX_BODY_BYTES"`
+}
+
+// Subscriptions configures recurring billing. Dunning.Retries is how long
+// to wait before retrying a declined renewal after each consecutive
+// decline; once every retry has failed the subscription is canceled.
+// Dunning.ErrorRetry is how long to wait after a renewal that never reached
+// the bank, which does not count as a decline.
+type Subscriptions struct {
+	Dunning Dunning `yaml:"dunning"`
+}
+
+type Dunning struct {
+	Retries    []time.Duration `yaml:"retries"`
+	ErrorRetry time.Duration   `yaml:"error_retry"`
+}
+
+// Webhooks configures event deliveries. Endpoint URLs come from merchants,
+// so deliveries are never sent to loopback, private or link-local
+// addresses unless AllowPrivateNetworks is set, which is only meant for
+// local development.
+type Webhooks struct {
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" env:"GATEWAY_WEBHOOKS_ALLOW_PRIVATE_NETWORKS"`
+}
+
+// Client certificate policies accepted in TLS.ClientAuth
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// TLS serves HTTPS when both files are set. The files are watched, so a
+// renewed certificate is picked up without a restart.
+//
+// With ClientAuth optional, merchants may present a client certificate
+// signed by a CA in ClientCAFile and are identified by it; with require,
+// every caller must.
+type TLS struct {
+	CertFile     string `yaml:"cert_file" env:"GATEWAY_TLS_CERT_FILE"`
+	KeyFile      string `yaml:"key_file" env:"GATEWAY_TLS_KEY_FILE"`
+	ClientCAFile string `yaml:"client_ca_file" env:"GATEWAY_TLS_CLIENT_CA_FILE"`
+	ClientAuth   string `yaml:"client_auth" env:"GATEWAY_TLS_CLIENT_AUTH"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type Logging struct {
+	Level  string `yaml:"level" env:"GATEWAY_LOG_LEVEL"`
+	Format string `yaml:"format" env:"GATEWAY_LOG_FORMAT"`
+}
+
+// SlogLevel returns the configured level, or info if it does not parse.
+// Validate rejects levels that do not parse.
+func (l Logging) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// RateLimits are the limits for callers the gateway has no specific
+// settings for. Callers presenting an API key are limited per key, others
+// per IP address.
+type RateLimits struct {
+	PerIP     RateLimit `yaml:"per_ip"`
+	PerAPIKey RateLimit `yaml:"per_api_key"`
+	// MaxConcurrentBankCalls caps the payments each merchant may have
+	// waiting on the bank at once. Callers without an API key share one
+	// allowance.
+	MaxConcurrentBankCalls int `yaml:"max_concurrent_bank_calls" env:"GATEWAY_RATE_LIMITS_MAX_CONCURRENT_BANK_CALLS"`
+}
+
+// RateLimit is a token bucket: Burst requests at once, refilled at
+// RequestsPerSecond. A rate of zero turns the limit off.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// Merchant is a merchant allowed to call the API with any of the API keys
+// whose SHA-256 hashes are listed, so no key is written down in full. Zero
+// limits fall back to RateLimits.
+type Merchant struct {
+	ID                     string    `yaml:"id"`
+	APIKeySHA256           []string  `yaml:"api_key_sha256"`
+	RateLimit              RateLimit `yaml:"rate_limit"`
+	MaxConcurrentBankCalls int       `yaml:"max_concurrent_bank_calls"`
+}
+
+// Default returns the settings used when nothing else is configured. They
+// suit local development against the bank simulator.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              ":8090",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			DrainDelay:        5 * time.Second,
+			ShutdownTimeout:   30 * time.Second,
+			MaxBodyBytes:      1 << 20,
+		},
+		GRPC: GRPC{Addr: ":9090"},
+		Bank: Bank{
+			URL:      "http://localhost:8081",
+			Timeout:  10 * time.Second,
+			Adapter:  "simulator",
+			Protocol: ProtocolHTTP,
+			ISO8583: ISO8583{
+				TerminalID: "GATEWAY1",
+				MerchantID: "GATEWAY",
+			},
+			Retry: Retry{
+				MaxAttempts:    3,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+			},
+			CircuitBreaker: CircuitBreaker{
+				FailureThreshold: 5,
+				Cooldown:         30 * time.Second,
+			},
+			Concurrency: Concurrency{
+				InitialLimit:     20,
+				MinLimit:         2,
+				MaxLimit:         200,
+				LatencyThreshold: 2 * time.Second,
+				BackoffRatio:     0.75,
+				MaxQueueLength:   100,
+				MaxQueueWait:     time.Second,
+			},
+		},
+		Storage: Storage{Backend: StorageMemory},
+		Async:   Async{Workers: 4, QueueDepth: 1000, MaxAttempts: 5},
+		Batches: Batches{Concurrency: 8, MaxItems: 10000, MaxBodyBytes: 32 << 20},
+		Subscriptions: Subscriptions{
+			Dunning: Dunning{
+				Retries:    []time.Duration{24 * time.Hour, 72 * time.Hour, 168 * time.Hour},
+				ErrorRetry: time.Hour,
+			},
+		},
+		TLS:        TLS{ClientAuth: ClientAuthNone},
+		Currencies: append([]string(nil), domain.DefaultCurrencies...),
+		Logging:    Logging{Level: "info", Format: LogFormatJSON},
+		RateLimits: RateLimits{
+			PerIP:                  RateLimit{RequestsPerSecond: 50, Burst: 100},
+			PerAPIKey:              RateLimit{RequestsPerSecond: 100, Burst: 200},
+			MaxConcurrentBankCalls: 20,
+		},
+	}
+}
+
+// LoadFile overrides cfg with the settings in the YAML file at path. Keys
+// the gateway does not know are an error, so typos are not silently ignored.
+func LoadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks every setting, reporting all the problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr is required")
+	}
+	for _, t := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.drain_delay", c.Server.DrainDelay},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+	} {
+		if t.d < 0 {
+			fail("%s must not be negative", t.name)
+		}
+	}
+
+	if c.Server.MaxBodyBytes <= 0 {
+		fail("server.max_body_bytes must be positive")
+	}
+
+	if c.GRPC.Addr != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.Addr); err != nil {
+			fail("grpc.addr must be host:port, got %q", c.GRPC.Addr)
+		} else if c.GRPC.Addr == c.Server.Addr {
+			fail("grpc.addr must differ from server.addr")
+		}
+	}
+
+	if u, err := url.Parse(c.Bank.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("bank.url must be an http or https URL, got %q", c.Bank.URL)
+	}
+	if c.Bank.Timeout <= 0 {
+		fail("bank.timeout must be positive")
+	}
+	if c.Bank.Adapter == "" {
+		fail("bank.adapter is required")
+	}
+	switch c.Bank.Protocol {
+	case ProtocolHTTP:
+	case ProtocolISO8583:
+		if _, _, err := net.SplitHostPort(c.Bank.ISO8583.Addr); err != nil {
+			fail("bank.iso8583.addr must be host:port, got %q", c.Bank.ISO8583.Addr)
+		}
+		if n := len(c.Bank.ISO8583.TerminalID); n < 1 || n > 8 {
+			fail("bank.iso8583.terminal_id must be 1 to 8 characters")
+		}
+		if n := len(c.Bank.ISO8583.MerchantID); n < 1 || n > 15 {
+			fail("bank.iso8583.merchant_id must be 1 to 15 characters")
+		}
+		if c.Bank.RecordTo != "" {
+			fail("bank.record_to only records the %s protocol", ProtocolHTTP)
+		}
+	default:
+		fail("bank.protocol must be %s or %s, got %q", ProtocolHTTP, ProtocolISO8583, c.Bank.Protocol)
+	}
+	// A response cut off by the server's write timeout would lose the
+	// outcome of a payment the bank may have authorized
+	if c.Server.WriteTimeout > 0 && c.Server.WriteTimeout <= c.Bank.Timeout {
+		fail("server.write_timeout (%s) must be longer than bank.timeout (%s)", c.Server.WriteTimeout, c.Bank.Timeout)
+	}
+	if c.Bank.Retry.MaxAttempts < 1 {
+		fail("bank.retry.max_attempts must be at least 1")
+	}
+	if c.Bank.Retry.InitialBackoff < 0 || c.Bank.Retry.MaxBackoff < 0 {
+		fail("bank.retry backoffs must not be negative")
+	}
+	if c.Bank.Retry.MaxBackoff > 0 && c.Bank.Retry.MaxBackoff < c.Bank.Retry.InitialBackoff {
+		fail("bank.retry.max_backoff must not be shorter than bank.retry.initial_backoff")
+	}
+	if c.Bank.CircuitBreaker.FailureThreshold < 1 {
+		fail("bank.circuit_breaker.failure_threshold must be at least 1")
+	}
+	if c.Bank.CircuitBreaker.Cooldown <= 0 {
+		fail("bank.circuit_breaker.cooldown must be positive")
+	}
+	concurrency := c.Bank.Concurrency
+	if concurrency.MinLimit < 1 || concurrency.InitialLimit < concurrency.MinLimit || concurrency.MaxLimit < concurrency.InitialLimit {
+		fail("bank.concurrency limits must satisfy 1 <= min_limit <= initial_limit <= max_limit")
+	}
+	if concurrency.LatencyThreshold <= 0 {
+		fail("bank.concurrency.latency_threshold must be positive")
+	}
+	if concurrency.BackoffRatio <= 0 || concurrency.BackoffRatio >= 1 {
+		fail("bank.concurrency.backoff_ratio must be between 0 and 1")
+	}
+	if concurrency.MaxQueueLength < 0 || concurrency.MaxQueueWait < 0 {
+		fail("bank.concurrency queue settings must not be negative")
+	}
+
+	switch c.Storage.Backend {
+	case StorageMemory:
+	case StorageFile:
+		if c.Storage.Dir == "" {
+			fail("storage.dir is required for the file backend")
+		}
+	default:
+		fail("storage.backend must be %s or %s, got %q", StorageMemory, StorageFile, c.Storage.Backend)
+	}
+
+	if c.MasterKeys.Ephemeral {
+		if c.MasterKeys.File != "" {
+			fail("master_keys.file and master_keys.ephemeral must not be set together")
+		}
+		// Journaled card data would be unreadable after the restart it is
+		// kept for
+		if c.Storage.Backend == StorageFile {
+			fail("master_keys.ephemeral cannot be used with the %s storage backend", StorageFile)
+		}
+	}
+
+	if c.Async.Workers < 1 {
+		fail("async.workers must be at least 1")
+	}
+	if c.Async.QueueDepth < 1 {
+		fail("async.queue_depth must be at least 1")
+	}
+	if c.Async.MaxAttempts < 1 {
+		fail("async.max_attempts must be at least 1")
+	}
+
+	if c.Batches.Concurrency < 1 {
+		fail("batches.concurrency must be at least 1")
+	}
+	if c.Batches.MaxItems < 1 {
+		fail("batches.max_items must be at least 1")
+	}
+	if c.Batches.MaxBodyBytes <= 0 {
+		fail("batches.max_body_bytes must be positive")
+	}
+
+	for i, retry := range c.Subscriptions.Dunning.Retries {
+		if retry <= 0 {
+			fail("subscriptions.dunning.retries[%d] must be positive", i)
+		}
+	}
+	if c.Subscriptions.Dunning.ErrorRetry <= 0 {
+		fail("subscriptions.dunning.error_retry must be positive")
+	}
+
+	if len(c.Currencies) == 0 {
+		fail("currencies must list at least one currency")
+	}
+	for _, code := range c.Currencies {
+		if !isCurrencyCode(code) {
+			fail("currencies: %q is not a 3-letter ISO 4217 code", code)
+		}
+	}
+
+	if c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		fail("tls.cert_file and tls.key_file must be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case ClientAuthNone, "":
+	case ClientAuthOptional, ClientAuthRequire:
+		if !c.TLS.Enabled() {
+			fail("tls.client_auth %s needs tls.cert_file and tls.key_file", c.TLS.ClientAuth)
+		}
+		if c.TLS.ClientCAFile == "" {
+			fail("tls.client_auth %s needs tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		fail("tls.client_auth must be %s, %s or %s, got %q", ClientAuthNone, ClientAuthOptional, ClientAuthRequire, c.TLS.ClientAuth)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		fail("logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case LogFormatJSON, LogFormatText:
+	default:
+		fail("logging.format must be %s or %s, got %q", LogFormatJSON, LogFormatText, c.Logging.Format)
+	}
+
+	validateRateLimit := func(name string, l RateLimit) {
+		if l.RequestsPerSecond < 0 || l.Burst < 0 {
+			fail("%s must not be negative", name)
+		}
+		if l.RequestsPerSecond > 0 && l.Burst < 1 {
+			fail("%s.burst must be at least 1", name)
+		}
+	}
+	validateRateLimit("rate_limits.per_ip", c.RateLimits.PerIP)
+	validateRateLimit("rate_limits.per_api_key", c.RateLimits.PerAPIKey)
+	if c.RateLimits.MaxConcurrentBankCalls < 0 {
+		fail("rate_limits.max_concurrent_bank_calls must not be negative")
+	}
+
+	merchantIDs := make(map[string]bool)
+	keyOwners := make(map[string]string)
+	for i, m := range c.Merchants {
+		if m.ID == "" {
+			fail("merchants[%d].id is required", i)
+		} else if merchantIDs[m.ID] {
+			fail("merchants: %q is listed more than once", m.ID)
+		}
+		merchantIDs[m.ID] = true
+
+		if len(m.APIKeySHA256) == 0 {
+			fail("merchants[%d].api_key_sha256 must list at least one key hash", i)
+		}
+		for _, hash := range m.APIKeySHA256 {
+			if !isSHA256(hash) {
+				fail("merchants[%d].api_key_sha256: %q is not a hex SHA-256 hash", i, hash)
+			}
+			if owner, ok := keyOwners[hash]; ok && owner != m.ID {
+				fail("merchants[%d].api_key_sha256: key is already used by %q", i, owner)
+			}
+			keyOwners[hash] = m.ID
+		}
+		validateRateLimit(fmt.Sprintf("merchants[%d].rate_limit", i), m.RateLimit)
+		if m.MaxConcurrentBankCalls < 0 {
+			fail("merchants[%d].max_concurrent_bank_calls must not be negative", i)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isSHA256(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, r := range hash {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// RestartRequired lists the sections of next that differ from c but only
+// take effect on restart. Everything else is applied by a reload: the bank
+// timeout and retry policy, the supported currencies, the log level, rate
+// limits and merchants.
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	if c.Server != next.Server {
+		changed = append(changed, "server")
+	}
+	if c.GRPC != next.GRPC {
+		changed = append(changed, "grpc")
+	}
+	if c.Bank.URL != next.Bank.URL {
+		changed = append(changed, "bank.url")
+	}
+	if c.Bank.CircuitBreaker != next.Bank.CircuitBreaker {
+		changed = append(changed, "bank.circuit_breaker")
+	}
+	if c.Bank.Concurrency != next.Bank.Concurrency {
+		changed = append(changed, "bank.concurrency")
+	}
+	if c.Bank.RecordTo != next.Bank.RecordTo {
+		changed = append(changed, "bank.record_to")
+	}
+	if c.Bank.Adapter != next.Bank.Adapter || c.Bank.Mapping != next.Bank.Mapping {
+		changed = append(changed, "bank.adapter")
+	}
+	if c.Bank.Protocol != next.Bank.Protocol || c.Bank.ISO8583 != next.Bank.ISO8583 {
+		changed = append(changed, "bank.protocol")
+	}
+	if c.Storage != next.Storage {
+		changed = append(changed, "storage")
+	}
+	if c.MasterKeys != next.MasterKeys {
+		changed = append(changed, "master_keys")
+	}
+	if c.Async != next.Async {
+		changed = append(changed, "async")
+	}
+	if c.Batches != next.Batches {
+		changed = append(changed, "batches")
+	}
+	if !slices.Equal(c.Subscriptions.Dunning.Retries, next.Subscriptions.Dunning.Retries) ||
+		c.Subscriptions.Dunning.ErrorRetry != next.Subscriptions.Dunning.ErrorRetry {
+		changed = append(changed, "subscriptions")
+	}
+	if c.Webhooks != next.Webhooks {
+		changed = append(changed, "webhooks")
+	}
+	if c.TLS != next.TLS {
+		changed = append(changed, "tls")
+	}
+	if c.Logging.Format != next.Logging.Format {
+		changed = append(changed, "logging.format")
+	}
+	return changed
+}
@@ -0,0 +1,467 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// testKeyHash is the SHA-256 hash of gw_test_key
+const testKeyHash = "dd64f65d484c1e9f334aa12360b5cc4e19690e003743d31d2b4ee2bbc24010cc"
+
+func TestDefault_IsValid(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":9000"
+  write_timeout: 45s
+bank:
+  url: https://bank.example.com
+  timeout: 5s
+  retry:
+    max_attempts: 2
+currencies: [gbp, jpy]
+logging:
+  level: debug
+`)
+	t.Setenv("GATEWAY_CONFIG", path)
+	t.Setenv("GATEWAY_BANK_TIMEOUT", "7s")
+	t.Setenv("GATEWAY_LOG_LEVEL", "warn")
+
+	cfg, err := Load([]string{"-log-level", "error"})
+	require.NoError(t, err)
+
+	// From the file
+	assert.Equal(t, ":9000", cfg.Server.Addr)
+	assert.Equal(t, 45*time.Second, cfg.Server.WriteTimeout)
+	assert.Equal(t, "https://bank.example.com", cfg.Bank.URL)
+	assert.Equal(t, 2, cfg.Bank.Retry.MaxAttempts)
+	assert.Equal(t, []string{"GBP", "JPY"}, cfg.Currencies)
+	// From the environment, over the file
+	assert.Equal(t, 7*time.Second, cfg.Bank.Timeout)
+	// From flags, over the environment
+	assert.Equal(t, "error", cfg.Logging.Level)
+	// Defaults for everything else
+	assert.Equal(t, 60*time.Second, cfg.Server.IdleTimeout)
+	assert.Equal(t, StorageMemory, cfg.Storage.Backend)
+}
+
+func TestLoad_BankMapping(t *testing.T) {
+	path := writeConfigFile(t, `
+bank:
+  adapter: mapped
+  mapping:
+    path: /v2/authorizations
+    request:
+      card_number: card.pan
+      expiry_date: card.expiry
+      expiry_format: MM/YY
+      amount: amount.value
+      amount_format: string
+    response:
+      authorized: response_code
+      approved_value: "00"
+`)
+	t.Setenv("GATEWAY_CONFIG", path)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "mapped", cfg.Bank.Adapter)
+	assert.Equal(t, "/v2/authorizations", cfg.Bank.Mapping.Path)
+	assert.Equal(t, "card.pan", cfg.Bank.Mapping.Request.CardNumber)
+	assert.Equal(t, "MM/YY", cfg.Bank.Mapping.Request.ExpiryFormat)
+	assert.Equal(t, "string", cfg.Bank.Mapping.Request.AmountFormat)
+	assert.Equal(t, "00", cfg.Bank.Mapping.Response.ApprovedValue)
+}
+
+func TestLoad_FlagsAndEnv(t *testing.T) {
+	t.Setenv("GATEWAY_CURRENCIES", "usd, eur")
+	t.Setenv("GATEWAY_STORAGE_BACKEND", "file")
+	t.Setenv("GATEWAY_STORAGE_DIR", "/var/lib/gateway")
+	t.Setenv("GATEWAY_SERVER_MAX_BODY_BYTES", "4096")
+	t.Setenv("GATEWAY_MASTER_KEY_FILE", "/etc/gateway/keys.json")
+
+	cfg, err := Load([]string{"-addr", ":7000", "-grpc-addr", ":7001", "-bank-url", "http://bank:8080"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":7000", cfg.Server.Addr)
+	assert.Equal(t, ":7001", cfg.GRPC.Addr)
+	assert.Equal(t, "http://bank:8080", cfg.Bank.URL)
+	assert.Equal(t, []string{"USD", "EUR"}, cfg.Currencies)
+	assert.Equal(t, Storage{Backend: StorageFile, Dir: "/var/lib/gateway"}, cfg.Storage)
+	assert.Equal(t, int64(4096), cfg.Server.MaxBodyBytes)
+	assert.Equal(t, MasterKeys{File: "/etc/gateway/keys.json"}, cfg.MasterKeys)
+}
+
+func TestLoad_Merchants(t *testing.T) {
+	path := writeConfigFile(t, `
+rate_limits:
+  per_api_key: {requests_per_second: 10, burst: 20}
+merchants:
+  - id: merchant-1
+    api_key_sha256: [DD64F65D484C1E9F334AA12360B5CC4E19690E003743D31D2B4EE2BBC24010CC]
+    rate_limit: {requests_per_second: 500, burst: 1000}
+    max_concurrent_bank_calls: 50
+`)
+	t.Setenv("GATEWAY_RATE_LIMITS_MAX_CONCURRENT_BANK_CALLS", "5")
+
+	cfg, err := Load([]string{"-config", path})
+	require.NoError(t, err)
+
+	assert.Equal(t, RateLimit{RequestsPerSecond: 10, Burst: 20}, cfg.RateLimits.PerAPIKey)
+	assert.Equal(t, Default().RateLimits.PerIP, cfg.RateLimits.PerIP)
+	assert.Equal(t, 5, cfg.RateLimits.MaxConcurrentBankCalls)
+	assert.Equal(t, []Merchant{{
+		ID:                     "merchant-1",
+		APIKeySHA256:           []string{testKeyHash},
+		RateLimit:              RateLimit{RequestsPerSecond: 500, Burst: 1000},
+		MaxConcurrentBankCalls: 50,
+	}}, cfg.Merchants)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		env           map[string]string
+		args          []string
+		expectedError string
+	}{
+		{
+			name:          "unknown key in file",
+			file:          "bank:\n  ulr: http://bank\n",
+			expectedError: "field ulr not found",
+		},
+		{
+			name:          "missing file",
+			args:          []string{"-config", "/does/not/exist.yaml"},
+			expectedError: "failed to open config file",
+		},
+		{
+			name:          "bad duration in env",
+			env:           map[string]string{"GATEWAY_BANK_TIMEOUT": "ten seconds"},
+			expectedError: "invalid GATEWAY_BANK_TIMEOUT",
+		},
+		{
+			name:          "bad number in env",
+			env:           map[string]string{"GATEWAY_BANK_RETRY_MAX_ATTEMPTS": "many"},
+			expectedError: "invalid GATEWAY_BANK_RETRY_MAX_ATTEMPTS",
+		},
+		{
+			name:          "unknown flag",
+			args:          []string{"-bank", "http://bank"},
+			expectedError: "flag provided but not defined",
+		},
+		{
+			name:          "invalid value",
+			args:          []string{"-log-level", "loud"},
+			expectedError: "invalid configuration: logging.level",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.file != "" {
+				t.Setenv("GATEWAY_CONFIG", writeConfigFile(t, tt.file))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := Load(tt.args)
+			assert.ErrorContains(t, err, tt.expectedError)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		modify        func(c *Config)
+		expectedError string
+	}{
+		{
+			name:          "no address",
+			modify:        func(c *Config) { c.Server.Addr = "" },
+			expectedError: "server.addr is required",
+		},
+		{
+			name:          "negative timeout",
+			modify:        func(c *Config) { c.Server.IdleTimeout = -time.Second },
+			expectedError: "server.idle_timeout must not be negative",
+		},
+		{
+			name:          "gRPC address without a port",
+			modify:        func(c *Config) { c.GRPC.Addr = "localhost" },
+			expectedError: `grpc.addr must be host:port, got "localhost"`,
+		},
+		{
+			name:          "gRPC on the REST address",
+			modify:        func(c *Config) { c.GRPC.Addr = c.Server.Addr },
+			expectedError: "grpc.addr must differ from server.addr",
+		},
+		{
+			name:          "bank URL without scheme",
+			modify:        func(c *Config) { c.Bank.URL = "localhost:8081" },
+			expectedError: "bank.url must be an http or https URL",
+		},
+		{
+			name:          "write timeout shorter than the bank's",
+			modify:        func(c *Config) { c.Server.WriteTimeout = 5 * time.Second },
+			expectedError: "server.write_timeout (5s) must be longer than bank.timeout (10s)",
+		},
+		{
+			name:          "no bank adapter",
+			modify:        func(c *Config) { c.Bank.Adapter = "" },
+			expectedError: "bank.adapter is required",
+		},
+		{
+			name:          "unknown bank protocol",
+			modify:        func(c *Config) { c.Bank.Protocol = "soap" },
+			expectedError: `bank.protocol must be http or iso8583, got "soap"`,
+		},
+		{
+			name: "ISO 8583 without an address",
+			modify: func(c *Config) {
+				c.Bank.Protocol = ProtocolISO8583
+			},
+			expectedError: `bank.iso8583.addr must be host:port, got ""`,
+		},
+		{
+			name: "ISO 8583 terminal ID too long",
+			modify: func(c *Config) {
+				c.Bank.Protocol, c.Bank.ISO8583.Addr = ProtocolISO8583, "acquirer:5000"
+				c.Bank.ISO8583.TerminalID = "TERMINAL1"
+			},
+			expectedError: "bank.iso8583.terminal_id must be 1 to 8 characters",
+		},
+		{
+			name: "recording ISO 8583",
+			modify: func(c *Config) {
+				c.Bank.Protocol, c.Bank.ISO8583.Addr = ProtocolISO8583, "acquirer:5000"
+				c.Bank.RecordTo = "bank.cassette.json"
+			},
+			expectedError: "bank.record_to only records the http protocol",
+		},
+		{
+			name:          "no attempts",
+			modify:        func(c *Config) { c.Bank.Retry.MaxAttempts = 0 },
+			expectedError: "bank.retry.max_attempts must be at least 1",
+		},
+		{
+			name:          "max backoff below initial",
+			modify:        func(c *Config) { c.Bank.Retry.MaxBackoff = time.Millisecond },
+			expectedError: "bank.retry.max_backoff must not be shorter",
+		},
+		{
+			name:          "concurrency limits out of order",
+			modify:        func(c *Config) { c.Bank.Concurrency.MaxLimit = 10 },
+			expectedError: "bank.concurrency limits must satisfy 1 <= min_limit <= initial_limit <= max_limit",
+		},
+		{
+			name:          "backoff that does not back off",
+			modify:        func(c *Config) { c.Bank.Concurrency.BackoffRatio = 1 },
+			expectedError: "bank.concurrency.backoff_ratio must be between 0 and 1",
+		},
+		{
+			name:          "file backend without dir",
+			modify:        func(c *Config) { c.Storage.Backend = StorageFile },
+			expectedError: "storage.dir is required",
+		},
+		{
+			name:          "unknown backend",
+			modify:        func(c *Config) { c.Storage.Backend = "postgres" },
+			expectedError: "storage.backend must be memory or file",
+		},
+		{
+			name:          "ephemeral key with a key file",
+			modify:        func(c *Config) { c.MasterKeys = MasterKeys{File: "keys.json", Ephemeral: true} },
+			expectedError: "master_keys.file and master_keys.ephemeral must not be set together",
+		},
+		{
+			name: "ephemeral key with journals",
+			modify: func(c *Config) {
+				c.Storage = Storage{Backend: StorageFile, Dir: "/var/lib/gateway"}
+				c.MasterKeys.Ephemeral = true
+			},
+			expectedError: "master_keys.ephemeral cannot be used with the file storage backend",
+		},
+		{
+			name:          "no currencies",
+			modify:        func(c *Config) { c.Currencies = nil },
+			expectedError: "currencies must list at least one currency",
+		},
+		{
+			name:          "bad currency",
+			modify:        func(c *Config) { c.Currencies = []string{"POUND"} },
+			expectedError: `"POUND" is not a 3-letter ISO 4217 code`,
+		},
+		{
+			name:          "certificate without key",
+			modify:        func(c *Config) { c.TLS.CertFile = "cert.pem" },
+			expectedError: "tls.cert_file and tls.key_file must be set together",
+		},
+		{
+			name:          "no body limit",
+			modify:        func(c *Config) { c.Server.MaxBodyBytes = 0 },
+			expectedError: "server.max_body_bytes must be positive",
+		},
+		{
+			name: "client certificates without TLS",
+			modify: func(c *Config) {
+				c.TLS.ClientAuth = ClientAuthRequire
+				c.TLS.ClientCAFile = "ca.pem"
+			},
+			expectedError: "tls.client_auth require needs tls.cert_file and tls.key_file",
+		},
+		{
+			name: "client certificates without a CA",
+			modify: func(c *Config) {
+				c.TLS = TLS{CertFile: "cert.pem", KeyFile: "key.pem", ClientAuth: ClientAuthOptional}
+			},
+			expectedError: "tls.client_auth optional needs tls.client_ca_file",
+		},
+		{
+			name:          "unknown client auth",
+			modify:        func(c *Config) { c.TLS.ClientAuth = "sometimes" },
+			expectedError: "tls.client_auth must be none, optional or require",
+		},
+		{
+			name:          "unknown log format",
+			modify:        func(c *Config) { c.Logging.Format = "xml" },
+			expectedError: "logging.format must be json or text",
+		},
+		{
+			name:          "rate limit without burst",
+			modify:        func(c *Config) { c.RateLimits.PerIP = RateLimit{RequestsPerSecond: 10} },
+			expectedError: "rate_limits.per_ip.burst must be at least 1",
+		},
+		{
+			name:          "negative concurrency",
+			modify:        func(c *Config) { c.RateLimits.MaxConcurrentBankCalls = -1 },
+			expectedError: "rate_limits.max_concurrent_bank_calls must not be negative",
+		},
+		{
+			name:          "no async workers",
+			modify:        func(c *Config) { c.Async.Workers = 0 },
+			expectedError: "async.workers must be at least 1",
+		},
+		{
+			name:          "no async queue",
+			modify:        func(c *Config) { c.Async.QueueDepth = 0 },
+			expectedError: "async.queue_depth must be at least 1",
+		},
+		{
+			name:          "no batch concurrency",
+			modify:        func(c *Config) { c.Batches.Concurrency = 0 },
+			expectedError: "batches.concurrency must be at least 1",
+		},
+		{
+			name:          "no batch body limit",
+			modify:        func(c *Config) { c.Batches.MaxBodyBytes = 0 },
+			expectedError: "batches.max_body_bytes must be positive",
+		},
+		{
+			name:          "non-positive dunning retry",
+			modify:        func(c *Config) { c.Subscriptions.Dunning.Retries = []time.Duration{time.Hour, 0} },
+			expectedError: "subscriptions.dunning.retries[1] must be positive",
+		},
+		{
+			name:          "no dunning error retry",
+			modify:        func(c *Config) { c.Subscriptions.Dunning.ErrorRetry = 0 },
+			expectedError: "subscriptions.dunning.error_retry must be positive",
+		},
+		{
+			name:          "merchant without keys",
+			modify:        func(c *Config) { c.Merchants = []Merchant{{ID: "merchant-1"}} },
+			expectedError: "merchants[0].api_key_sha256 must list at least one key hash",
+		},
+		{
+			name: "merchant with a raw key",
+			modify: func(c *Config) {
+				c.Merchants = []Merchant{{ID: "merchant-1", APIKeySHA256: []string{"gw_live_abc"}}}
+			},
+			expectedError: "merchants[0].api_key_sha256: \"gw_live_abc\" is not a hex SHA-256 hash",
+		},
+		{
+			name: "merchants sharing a key",
+			modify: func(c *Config) {
+				c.Merchants = []Merchant{
+					{ID: "merchant-1", APIKeySHA256: []string{testKeyHash}},
+					{ID: "merchant-2", APIKeySHA256: []string{testKeyHash}},
+				}
+			},
+			expectedError: `merchants[1].api_key_sha256: key is already used by "merchant-1"`,
+		},
+		{
+			name: "duplicate merchant",
+			modify: func(c *Config) {
+				c.Merchants = []Merchant{
+					{ID: "merchant-1", APIKeySHA256: []string{testKeyHash}},
+					{ID: "merchant-1", APIKeySHA256: []string{strings.Repeat("0", 64)}},
+				}
+			},
+			expectedError: `merchants: "merchant-1" is listed more than once`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			assert.ErrorContains(t, cfg.Validate(), tt.expectedError)
+		})
+	}
+}
+
+func TestValidate_ReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Addr = ""
+	cfg.Bank.Retry.MaxAttempts = 0
+
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "server.addr")
+	assert.ErrorContains(t, err, "bank.retry.max_attempts")
+}
+
+func TestRestartRequired(t *testing.T) {
+	current := Default()
+
+	next := Default()
+	next.Bank.Timeout = 5 * time.Second
+	next.Bank.Retry.MaxAttempts = 1
+	next.Currencies = []string{"JPY"}
+	next.Logging.Level = "debug"
+	next.RateLimits.PerAPIKey.Burst = 1000
+	next.Merchants = []Merchant{{ID: "merchant-1", APIKeySHA256: []string{testKeyHash}}}
+	assert.Empty(t, current.RestartRequired(next), "reloadable settings need no restart")
+
+	next.Server.Addr = ":9000"
+	next.GRPC.Addr = ""
+	next.Bank.URL = "http://elsewhere"
+	next.Bank.RecordTo = "bank.cassette.json"
+	next.Bank.Mapping.Request.CardNumber = "card.pan"
+	next.Bank.ISO8583.Financial = true
+	next.MasterKeys.File = "keys.json"
+	next.Async.Workers = 16
+	next.Batches.MaxItems = 50
+	next.Subscriptions.Dunning.Retries = []time.Duration{time.Hour}
+	next.Webhooks.AllowPrivateNetworks = true
+	next.TLS.CertFile = "cert.pem"
+	assert.Equal(t, []string{"server", "grpc", "bank.url", "bank.record_to", "bank.adapter", "bank.protocol", "master_keys", "async", "batches", "subscriptions", "webhooks", "tls"}, current.RestartRequired(next))
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Load builds the configuration from the defaults, the YAML file named by
+// -config or GATEWAY_CONFIG, GATEWAY_* environment variables and the flags
+// in args, in that order, and validates the result.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("GATEWAY_CONFIG"), "path to a YAML config file")
+	addr := fs.String("addr", "", "address to listen on, e.g. :8090")
+	grpcAddr := fs.String("grpc-addr", "", "address for the gRPC API to listen on, e.g. :9090")
+	bankURL := fs.String("bank-url", "", "base URL of the acquiring bank")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "log format: json or text")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile != "" {
+		if err := LoadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(cfg).Elem()); err != nil {
+		return nil, err
+	}
+
+	// Only flags given on the command line override, so an empty default
+	// never hides a value from the file or the environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "grpc-addr":
+			cfg.GRPC.Addr = *grpcAddr
+		case "bank-url":
+			cfg.Bank.URL = *bankURL
+		case "log-level":
+			cfg.Logging.Level = *logLevel
+		case "log-format":
+			cfg.Logging.Format = *logFormat
+		}
+	})
+
+	cfg.normalize()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// normalize accepts the spellings people tend to use, e.g. lower-case
+// currency codes
+func (c *Config) normalize() {
+	for i, code := range c.Currencies {
+		c.Currencies[i] = strings.ToUpper(strings.TrimSpace(code))
+	}
+	c.Storage.Backend = strings.ToLower(c.Storage.Backend)
+	c.TLS.ClientAuth = strings.ToLower(c.TLS.ClientAuth)
+	c.Logging.Level = strings.ToLower(c.Logging.Level)
+	c.Logging.Format = strings.ToLower(c.Logging.Format)
+	for _, m := range c.Merchants {
+		for i, hash := range m.APIKeySHA256 {
+			m.APIKeySHA256[i] = strings.ToLower(strings.TrimSpace(hash))
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv sets every field with an env tag whose variable is set, walking
+// nested structs
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+
+		name, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			if field.Kind() == reflect.Struct {
+				if err := applyEnv(field); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.Kind() == reflect.Int, field.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package domain
+
+import "time"
+
+// BatchStatus is where a payment batch is in its processing
+type BatchStatus string
+
+const (
+	BatchProcessing BatchStatus = "processing"
+	BatchCompleted  BatchStatus = "completed"
+	// BatchCanceled batches stopped sending payments; the ones already
+	// with the bank still finish
+	BatchCanceled BatchStatus = "canceled"
+)
+
+// BatchItemStatus is the outcome of one payment in a batch
+type BatchItemStatus string
+
+const (
+	BatchItemPending BatchItemStatus = "pending"
+	// BatchItemSucceeded means the bank answered; PaymentStatus says
+	// whether it authorized the payment
+	BatchItemSucceeded BatchItemStatus = "succeeded"
+	// BatchItemFailed means the payment was invalid or never got an answer
+	// from the bank; Err says why
+	BatchItemFailed   BatchItemStatus = "failed"
+	BatchItemCanceled BatchItemStatus = "canceled"
+)
+
+// PaymentBatch is a set of payments submitted together and processed in
+// the background
+type PaymentBatch struct {
+	ID string
+	// MerchantID is the merchant that uploaded the batch, and the only one
+	// that can see it
+	MerchantID  string
+	Status      BatchStatus
+	Items       []BatchItem
+	CreatedAt   time.Time
+	CompletedAt time.Time
+}
+
+// BatchItem is the result of the payment at Index in the upload, counted
+// from 0
+type BatchItem struct {
+	Index         int
+	Status        BatchItemStatus
+	PaymentID     string
+	PaymentStatus PaymentStatus
+	DeclineReason DeclineReason
+	Err           error
+}
+
+// BatchEntry is one item of an upload: the payment it describes, or why it
+// does not describe a valid one
+type BatchEntry struct {
+	Payment *Payment
+	Err     error
+}
+
+// BatchCounts summarises a batch's items
+type BatchCounts struct {
+	Total      int
+	Pending    int
+	Authorized int
+	Declined   int
+	Failed     int
+	Canceled   int
+}
+
+func (b *PaymentBatch) Counts() BatchCounts {
+	counts := BatchCounts{Total: len(b.Items)}
+	for _, item := range b.Items {
+		switch item.Status {
+		case BatchItemPending:
+			counts.Pending++
+		case BatchItemSucceeded:
+			if item.PaymentStatus == StatusAuthorized {
+				counts.Authorized++
+			} else {
+				counts.Declined++
+			}
+		case BatchItemFailed:
+			counts.Failed++
+		case BatchItemCanceled:
+			counts.Canceled++
+		}
+	}
+	return counts
+}
+
+// IsFinished reports whether no more of the batch's payments will be sent
+func (b *PaymentBatch) IsFinished() bool {
+	return b.Status != BatchProcessing
+}
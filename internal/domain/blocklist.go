@@ -0,0 +1,14 @@
+package domain
+
+import "time"
+
+// BlockedCard is a card a merchant no longer takes payments from, e.g. after
+// a fraudulent chargeback. Payments from it are rejected before reaching the
+// bank. The card number itself is only held sealed by the blocklist.
+type BlockedCard struct {
+	ID         string
+	MerchantID string
+	LastFour   string
+	Reason     string
+	CreatedAt  time.Time
+}
@@ -2,17 +2,36 @@ package domain
 
 import (
 	"strconv"
+	"strings"
 	"time"
 )
 
+// TokenPrefix marks opaque card tokens issued by the vault
+const TokenPrefix = "tok_"
+
 type Card struct {
 	Number      string
 	ExpiryMonth int
 	ExpiryYear  int
 	CVV         string
+
+	// Token references card data held in the vault. Tokenized cards never carry
+	// the full card number; only LastFour and the expiry are exposed.
+	Token    string
+	LastFour string
 }
 
 func (c *Card) Validate() error {
+	return c.validate(true)
+}
+
+// validate checks the card. The CVV may only be omitted when cvvRequired is
+// false, and is still validated when present.
+func (c *Card) validate(cvvRequired bool) error {
+	if c.IsTokenized() {
+		return c.validateTokenized(cvvRequired)
+	}
+
 	if err := c.validateCardNumber(); err != nil {
 		return err
 	}
@@ -21,6 +40,10 @@ func (c *Card) Validate() error {
 		return err
 	}
 
+	if c.CVV == "" && !cvvRequired {
+		return nil
+	}
+
 	if err := c.validateCVV(); err != nil {
 		return err
 	}
@@ -28,6 +51,55 @@ func (c *Card) Validate() error {
 	return nil
 }
 
+// ValidateForTokenization checks the fields that are stored in the vault.
+// The CVV is never stored so it is not required here.
+func (c *Card) ValidateForTokenization() error {
+	if err := c.validateCardNumber(); err != nil {
+		return err
+	}
+
+	return c.validateExpiry()
+}
+
+// ValidateForBlocking checks a card to block, given either by its number or
+// by a vault token
+func (c *Card) ValidateForBlocking() error {
+	if c.IsTokenized() {
+		if c.Number != "" {
+			return ErrCardSourceConflict
+		}
+		return c.validateTokenized(false)
+	}
+
+	return c.validateCardNumber()
+}
+
+// IsTokenized reports whether the card references data held in the vault
+func (c *Card) IsTokenized() bool {
+	return c.Token != ""
+}
+
+// validateTokenized validates a card that references the vault.
+// The expiry is only known once the token has been resolved, so it is
+// checked on the second pass after the vault has filled it in.
+func (c *Card) validateTokenized(cvvRequired bool) error {
+	if !strings.HasPrefix(c.Token, TokenPrefix) || len(c.Token) == len(TokenPrefix) {
+		return ErrTokenInvalid
+	}
+
+	if c.ExpiryMonth != 0 || c.ExpiryYear != 0 {
+		if err := c.validateExpiry(); err != nil {
+			return err
+		}
+	}
+
+	if c.CVV == "" && !cvvRequired {
+		return nil
+	}
+
+	return c.validateCVV()
+}
+
 // validateCardNumber ensures card number meets requirements:
 func (c *Card) validateCardNumber() error {
 	if c.Number == "" {
@@ -97,6 +169,9 @@ func (c *Card) validateCVV() error {
 }
 
 func (c *Card) GetLastFourDigits() string {
+	if c.Number == "" {
+		return c.LastFour
+	}
 	if len(c.Number) < 4 {
 		return c.Number
 	}
@@ -319,3 +319,72 @@ func TestCard_GetLastFourDigits(t *testing.T) {
 		})
 	}
 }
+
+func TestCard_ValidateTokenized(t *testing.T) {
+	currentYear := time.Now().Year()
+
+	tests := []struct {
+		name        string
+		card        Card
+		expectError error
+	}{
+		{
+			name:        "unresolved token with CVV",
+			card:        Card{Token: "tok_abc", CVV: "123"},
+			expectError: nil,
+		},
+		{
+			name:        "token without prefix",
+			card:        Card{Token: "abc", CVV: "123"},
+			expectError: ErrTokenInvalid,
+		},
+		{
+			name:        "prefix only",
+			card:        Card{Token: "tok_", CVV: "123"},
+			expectError: ErrTokenInvalid,
+		},
+		{
+			name:        "missing CVV",
+			card:        Card{Token: "tok_abc"},
+			expectError: ErrCVVRequired,
+		},
+		{
+			name:        "resolved token with valid expiry",
+			card:        Card{Token: "tok_abc", CVV: "123", ExpiryMonth: 12, ExpiryYear: currentYear + 1},
+			expectError: nil,
+		},
+		{
+			name:        "resolved token that has expired",
+			card:        Card{Token: "tok_abc", CVV: "123", ExpiryMonth: 12, ExpiryYear: currentYear - 1},
+			expectError: ErrExpiryDateInPast,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.card.Validate()
+			if tt.expectError != nil {
+				assert.Equal(t, tt.expectError, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCard_ValidateForTokenization(t *testing.T) {
+	card := Card{
+		Number:      "1234567890123456",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+	}
+	assert.NoError(t, card.ValidateForTokenization()) // CVV is not required
+
+	card.Number = "123"
+	assert.Equal(t, ErrCardNumberInvalid, card.ValidateForTokenization())
+}
+
+func TestCard_GetLastFourDigits_Tokenized(t *testing.T) {
+	card := Card{Token: "tok_abc", LastFour: "8877"}
+	assert.Equal(t, "8877", card.GetLastFourDigits())
+}
@@ -5,23 +5,144 @@ import "errors"
 // Domain-specific errors for validation and business logic
 var (
 	// Card validation errors
-	ErrCardNumberRequired    = errors.New("card number is required")
-	ErrCardNumberInvalid     = errors.New("card number must be between 14-19 digits")
-	ErrCardNumberNotNumeric  = errors.New("card number must only contain numeric characters")
-	ErrCVVRequired           = errors.New("CVV is required")
-	ErrCVVInvalid            = errors.New("CVV must be 3-4 digits")
-	ErrCVVNotNumeric         = errors.New("CVV must only contain numeric characters")
-	ErrExpiryMonthRequired   = errors.New("expiry month is required")
-	ErrExpiryMonthInvalid    = errors.New("expiry month must be between 1-12")
-	ErrExpiryYearRequired    = errors.New("expiry year is required")
-	ErrExpiryDateInPast      = errors.New("expiry date must be in the future")
+	ErrCardNumberRequired   = errors.New("card number is required")
+	ErrCardNumberInvalid    = errors.New("card number must be between 14-19 digits")
+	ErrCardNumberNotNumeric = errors.New("card number must only contain numeric characters")
+	ErrCVVRequired          = errors.New("CVV is required")
+	ErrCVVInvalid           = errors.New("CVV must be 3-4 digits")
+	ErrCVVNotNumeric        = errors.New("CVV must only contain numeric characters")
+	ErrExpiryMonthRequired  = errors.New("expiry month is required")
+	ErrExpiryMonthInvalid   = errors.New("expiry month must be between 1-12")
+	ErrExpiryYearRequired   = errors.New("expiry year is required")
+	ErrExpiryDateInPast     = errors.New("expiry date must be in the future")
+	ErrTokenInvalid         = errors.New("card token is invalid")
+	ErrCardSourceConflict   = errors.New("provide either card details or a source token, not both")
 
 	// Payment validation errors
 	ErrCurrencyRequired = errors.New("currency is required")
-	ErrCurrencyInvalid  = errors.New("currency must be a valid 3-character ISO code (USD, GBP, EUR)")
+	ErrCurrencyInvalid  = errors.New("currency must be a valid 3-character ISO code the gateway supports")
 	ErrAmountRequired   = errors.New("amount is required")
 	ErrAmountInvalid    = errors.New("amount must be a positive integer")
 
+	// Listing errors
+	ErrPaymentCursorInvalid = errors.New("starting_after must be the ID of an existing payment")
+
+	// Stored credential errors
+	ErrInitiatorInvalid             = errors.New("initiator must be customer or merchant")
+	ErrStoredCredentialUsageInvalid = errors.New("stored credential usage must be first or subsequent")
+	ErrStoredCredentialTypeInvalid  = errors.New("stored credential type must be recurring or unscheduled")
+	ErrStoredCredentialRequired     = errors.New("merchant-initiated payments require a stored credential")
+	ErrMerchantInitiatedFirstUse    = errors.New("the first use of a stored credential must be customer-initiated")
+	ErrPreviousTransactionRequired  = errors.New("merchant-initiated payments require the previous network transaction ID")
+	ErrPreviousTransactionUnknown   = errors.New("previous network transaction ID must be that of an earlier authorized payment on the same card")
+
+	// Subscription errors
+	ErrPlanNameRequired    = errors.New("plan name is required")
+	ErrPlanIntervalInvalid = errors.New("plan interval must be day, week, month or year with a positive count")
+	ErrPlanCurrencyChange  = errors.New("cannot change to a plan in a different currency")
+	ErrCardTokenRequired   = errors.New("subscriptions require a stored card token")
+
+	// Capture, void and refund errors
+	ErrCaptureAmountInvalid = errors.New("cannot capture more than was authorized")
+	ErrRefundAmountInvalid  = errors.New("cannot refund more than was captured and not yet refunded")
+
+	// Webhook errors
+	ErrWebhookURLInvalid = errors.New("webhook URL must be an absolute http or https URL")
+	ErrEventTypeInvalid  = errors.New("unknown event type")
+
+	// Blocklist errors
+	ErrCardBlocked = errors.New("card is blocked")
+
+	// Batch errors
+	ErrBatchEmpty    = errors.New("payment batch must contain at least one payment")
+	ErrBatchTooLarge = errors.New("payment batch has too many payments")
+
 	// Business logic errors
 	ErrPaymentNotFound = errors.New("payment not found")
+	ErrTokenNotFound   = errors.New("card token not found")
+
+	ErrPaymentNotCapturable = errors.New("only an authorized payment can be captured")
+	ErrPaymentNotVoidable   = errors.New("only an authorized payment that has not been captured can be voided")
+	ErrPaymentNotRefundable = errors.New("only a captured payment that has not been fully refunded can be refunded")
+	// ErrPaymentSettling means the payment is already being captured,
+	// voided or refunded
+	ErrPaymentSettling = errors.New("another capture, void or refund of the payment is in progress")
+
+	ErrPlanNotFound              = errors.New("plan not found")
+	ErrSubscriptionNotFound      = errors.New("subscription not found")
+	ErrSubscriptionInvalidState  = errors.New("operation not allowed in the subscription's current state")
+	ErrSubscriptionPaymentFailed = errors.New("subscription payment was not authorized")
+
+	ErrWebhookEndpointNotFound = errors.New("webhook endpoint not found")
+	ErrDeliveryNotFound        = errors.New("webhook delivery not found")
+	ErrDeliveryPending         = errors.New("webhook delivery is already queued")
+	ErrDeliveryConflict        = errors.New("webhook delivery was changed concurrently")
+
+	ErrBlockedCardNotFound = errors.New("blocked card not found")
+
+	ErrBatchNotFound = errors.New("payment batch not found")
+	ErrBatchFinished = errors.New("payment batch has already finished")
+
+	// ErrConcurrencyLimitExceeded means the merchant already has as many
+	// payments waiting on the bank as it is allowed
+	ErrConcurrencyLimitExceeded = errors.New("too many payments in progress")
+	// ErrBankOverloaded means the payment was shed without being sent,
+	// because the bank could not take it in time
+	ErrBankOverloaded = errors.New("bank is overloaded")
+	// ErrSettlementUnsupported means the bank client, or the acquirer's
+	// adapter, cannot send captures, voids or refunds
+	ErrSettlementUnsupported = errors.New("the acquirer does not support capture, void or refund")
+	// ErrPaymentQueueFull means an asynchronous payment was turned away
+	// because the queue already holds as many as it may
+	ErrPaymentQueueFull = errors.New("payment queue is full")
 )
+
+// validationErrors are the errors caused by the merchant's input rather than
+// by the gateway or the bank
+var validationErrors = []error{
+	ErrCardNumberRequired,
+	ErrCardNumberInvalid,
+	ErrCardNumberNotNumeric,
+	ErrCVVRequired,
+	ErrCVVInvalid,
+	ErrCVVNotNumeric,
+	ErrExpiryMonthRequired,
+	ErrExpiryMonthInvalid,
+	ErrExpiryYearRequired,
+	ErrExpiryDateInPast,
+	ErrTokenInvalid,
+	ErrCardSourceConflict,
+	ErrCurrencyRequired,
+	ErrCurrencyInvalid,
+	ErrAmountRequired,
+	ErrAmountInvalid,
+	ErrPaymentCursorInvalid,
+	ErrInitiatorInvalid,
+	ErrStoredCredentialUsageInvalid,
+	ErrStoredCredentialTypeInvalid,
+	ErrStoredCredentialRequired,
+	ErrMerchantInitiatedFirstUse,
+	ErrPreviousTransactionRequired,
+	ErrPreviousTransactionUnknown,
+	ErrPlanNameRequired,
+	ErrPlanIntervalInvalid,
+	ErrPlanCurrencyChange,
+	ErrCardTokenRequired,
+	ErrCaptureAmountInvalid,
+	ErrRefundAmountInvalid,
+	ErrWebhookURLInvalid,
+	ErrEventTypeInvalid,
+	ErrBatchEmpty,
+	ErrTokenNotFound,
+	ErrCardBlocked,
+}
+
+// IsValidationError reports whether err was caused by invalid merchant input
+func IsValidationError(err error) bool {
+	for _, target := range validationErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
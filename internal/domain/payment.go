@@ -2,6 +2,7 @@ package domain
 
 import (
 	"strings"
+	"sync/atomic"
 )
 
 type PaymentStatus string
@@ -13,12 +14,61 @@ const (
 	StatusDeclined PaymentStatus = "Declined"
 	// StatusRejected means the payment was rejected due to validation errors
 	StatusRejected PaymentStatus = "Rejected"
+	// StatusPending means the payment was accepted for asynchronous
+	// processing and has not reached the bank yet
+	StatusPending PaymentStatus = "Pending"
+	// StatusCaptured means the authorized amount, or part of it, was taken
+	// from the card
+	StatusCaptured PaymentStatus = "Captured"
+	// StatusVoided means the authorization was released without taking
+	// anything from the card
+	StatusVoided PaymentStatus = "Voided"
+	// StatusRefunded means some or all of the captured amount was given
+	// back to the card
+	StatusRefunded PaymentStatus = "Refunded"
 )
 
-var supportedCurrencies = map[string]bool{
-	"USD": true,
-	"GBP": true,
-	"EUR": true,
+// DeclineReason says why the bank declined a payment, in terms a merchant
+// can act on
+type DeclineReason string
+
+const (
+	DeclineDoNotHonour        DeclineReason = "do_not_honour"
+	DeclineInsufficientFunds  DeclineReason = "insufficient_funds"
+	DeclineInvalidCard        DeclineReason = "invalid_card"
+	DeclineExpiredCard        DeclineReason = "expired_card"
+	DeclineInvalidCVV         DeclineReason = "invalid_cvv"
+	DeclineInvalidAmount      DeclineReason = "invalid_amount"
+	DeclineLostOrStolenCard   DeclineReason = "lost_or_stolen_card"
+	DeclineSuspectedFraud     DeclineReason = "suspected_fraud"
+	DeclineLimitExceeded      DeclineReason = "limit_exceeded"
+	DeclineTransactionBlocked DeclineReason = "transaction_not_permitted"
+)
+
+// DefaultCurrencies are the currencies accepted unless configured otherwise
+var DefaultCurrencies = []string{"USD", "GBP", "EUR"}
+
+// supportedCurrencies is swapped as a whole when the configuration is
+// reloaded, so validation never sees a half-updated set
+var supportedCurrencies atomic.Pointer[map[string]bool]
+
+func init() {
+	SetSupportedCurrencies(DefaultCurrencies)
+}
+
+// SetSupportedCurrencies replaces the currencies payments may be made in.
+// Codes are upper-cased.
+func SetSupportedCurrencies(codes []string) {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(code)] = true
+	}
+	supportedCurrencies.Store(&set)
+}
+
+// IsSupportedCurrency reports whether payments may be made in currency
+func IsSupportedCurrency(currency string) bool {
+	return (*supportedCurrencies.Load())[strings.ToUpper(currency)]
 }
 
 type Payment struct {
@@ -27,14 +77,44 @@ type Payment struct {
 	Currency string
 	Amount   int
 	Status   PaymentStatus
+
+	// MerchantID is the merchant that made the payment, and the only one
+	// that can see it
+	MerchantID string
+
+	Initiator        Initiator
+	StoredCredential *StoredCredential
+	// NetworkTransactionID is assigned by the bank and links later
+	// merchant-initiated charges back to this payment
+	NetworkTransactionID string
+	// DeclineReason is set on declined payments when the bank gave one
+	DeclineReason DeclineReason
+	// AuthorizationCode is the bank's code for an authorized payment, which
+	// captures, voids and refunds quote
+	AuthorizationCode string
+	// CapturedAmount is how much of Amount was taken from the card, and
+	// RefundedAmount how much of that was given back
+	CapturedAmount int
+	RefundedAmount int
+
+	// RequestID and CorrelationID identify the request that created the
+	// payment, so it can be matched to logs, the merchant's records and the
+	// bank's
+	RequestID     string
+	CorrelationID string
 }
 
-func NewPayment(card Card, currency string, amount int) (*Payment, error) {
+func NewPayment(card Card, currency string, amount int, opts ...PaymentOption) (*Payment, error) {
 	p := &Payment{
-		Card:     card,
-		Currency: currency,
-		Amount:   amount,
-		Status:   StatusRejected, // Default to rejected until validated
+		Card:      card,
+		Currency:  currency,
+		Amount:    amount,
+		Status:    StatusRejected, // Default to rejected until validated
+		Initiator: InitiatorCustomer,
+	}
+
+	for _, opt := range opts {
+		opt(p)
 	}
 
 	if err := p.Validate(); err != nil {
@@ -46,7 +126,13 @@ func NewPayment(card Card, currency string, amount int) (*Payment, error) {
 
 func (p *Payment) Validate() error {
 
-	if err := p.Card.Validate(); err != nil {
+	if err := p.validateStoredCredential(); err != nil {
+		return err
+	}
+
+	// The cardholder is not present for merchant-initiated charges, so there
+	// is no CVV to collect
+	if err := p.Card.validate(!p.IsMerchantInitiated()); err != nil {
 		return err
 	}
 
@@ -61,20 +147,53 @@ func (p *Payment) Validate() error {
 	return nil
 }
 
-// validateCurrency ensures currency meets requirements:
-func (p *Payment) validateCurrency() error {
-	if p.Currency == "" {
-		return ErrCurrencyRequired
+// IsMerchantInitiated reports whether the payment was made without the
+// cardholder present
+func (p *Payment) IsMerchantInitiated() bool {
+	return p.Initiator == InitiatorMerchant
+}
+
+// validateStoredCredential ensures merchant-initiated payments only charge a
+// card the customer has already agreed to store
+func (p *Payment) validateStoredCredential() error {
+	switch p.Initiator {
+	case "":
+		p.Initiator = InitiatorCustomer
+	case InitiatorCustomer, InitiatorMerchant:
+	default:
+		return ErrInitiatorInvalid
 	}
 
-	currency := strings.ToUpper(p.Currency)
+	if p.StoredCredential == nil {
+		if p.IsMerchantInitiated() {
+			return ErrStoredCredentialRequired
+		}
+		return nil
+	}
 
-	if len(currency) != 3 {
-		return ErrCurrencyInvalid
+	if err := p.StoredCredential.Validate(); err != nil {
+		return err
 	}
 
-	if !supportedCurrencies[currency] {
-		return ErrCurrencyInvalid
+	if p.IsMerchantInitiated() {
+		// The first use of a stored card must be customer-initiated
+		if p.StoredCredential.Usage != StoredCredentialSubsequent {
+			return ErrMerchantInitiatedFirstUse
+		}
+
+		if p.StoredCredential.PreviousNetworkTransactionID == "" {
+			return ErrPreviousTransactionRequired
+		}
+	}
+
+	return nil
+}
+
+// validateCurrency ensures currency meets requirements:
+func (p *Payment) validateCurrency() error {
+	currency, err := normalizeCurrency(p.Currency)
+	if err != nil {
+		return err
 	}
 
 	p.Currency = currency
@@ -82,6 +201,25 @@ func (p *Payment) validateCurrency() error {
 	return nil
 }
 
+// normalizeCurrency upper-cases a currency code and checks it is supported
+func normalizeCurrency(currency string) (string, error) {
+	if currency == "" {
+		return "", ErrCurrencyRequired
+	}
+
+	currency = strings.ToUpper(currency)
+
+	if len(currency) != 3 {
+		return "", ErrCurrencyInvalid
+	}
+
+	if !IsSupportedCurrency(currency) {
+		return "", ErrCurrencyInvalid
+	}
+
+	return currency, nil
+}
+
 // validateAmount ensures amount is valid:
 func (p *Payment) validateAmount() error {
 	if p.Amount <= 0 {
@@ -91,6 +229,73 @@ func (p *Payment) validateAmount() error {
 	return nil
 }
 
+// IsAuthorized reports whether the bank authorized the payment, whatever
+// has happened to the authorization since
+func (p *Payment) IsAuthorized() bool {
+	switch p.Status {
+	case StatusAuthorized, StatusCaptured, StatusVoided, StatusRefunded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Capture takes amount of the authorized amount from the card, or all of
+// it if amount is 0. Only one capture is made per payment.
+func (p *Payment) Capture(amount int) error {
+	if p.Status != StatusAuthorized {
+		return ErrPaymentNotCapturable
+	}
+
+	if amount == 0 {
+		amount = p.Amount
+	}
+	if amount < 0 {
+		return ErrAmountInvalid
+	}
+	if amount > p.Amount {
+		return ErrCaptureAmountInvalid
+	}
+
+	p.Status = StatusCaptured
+	p.CapturedAmount = amount
+	return nil
+}
+
+// Void releases an authorization that has not been captured
+func (p *Payment) Void() error {
+	if p.Status != StatusAuthorized {
+		return ErrPaymentNotVoidable
+	}
+
+	p.Status = StatusVoided
+	return nil
+}
+
+// Refund gives amount of the captured amount back to the card, or all that
+// has not been refunded yet if amount is 0. A payment can be refunded in
+// parts until the whole captured amount is given back.
+func (p *Payment) Refund(amount int) error {
+	remaining := p.CapturedAmount - p.RefundedAmount
+	if (p.Status != StatusCaptured && p.Status != StatusRefunded) || remaining == 0 {
+		return ErrPaymentNotRefundable
+	}
+
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount < 0 {
+		return ErrAmountInvalid
+	}
+	if amount > remaining {
+		return ErrRefundAmountInvalid
+	}
+
+	p.Status = StatusRefunded
+	p.RefundedAmount += amount
+	return nil
+}
+
 func (p *Payment) SetAuthorized() {
 	p.Status = StatusAuthorized
 }
@@ -102,3 +307,25 @@ func (p *Payment) SetDeclined() {
 func (p *Payment) SetRejected() {
 	p.Status = StatusRejected
 }
+
+func (p *Payment) SetPending() {
+	p.Status = StatusPending
+}
+
+// PaymentQuery selects a page of payments, newest first
+type PaymentQuery struct {
+	// MerchantID selects the payments made by that merchant
+	MerchantID string
+	// Status, if set, only selects payments in that status
+	Status PaymentStatus
+	// StartingAfter is the ID of the last payment on the previous page
+	StartingAfter string
+	Limit         int
+}
+
+// PaymentPage is one page of payments. HasMore says whether a query
+// starting after its last payment would find more.
+type PaymentPage struct {
+	Payments []*Payment
+	HasMore  bool
+}
@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// EventPaymentRequested records what the merchant asked for
+	EventPaymentRequested EventType = "payment.requested"
+	// EventPaymentRejected records a payment that never reached the bank
+	EventPaymentRejected EventType = "payment.rejected"
+	// EventPaymentPending records a payment queued for asynchronous
+	// processing
+	EventPaymentPending EventType = "payment.pending"
+	// EventCardShredded records that the card number was crypto-shredded
+	EventCardShredded EventType = "payment.card_shredded"
+)
+
+// PaymentEvent is an immutable record of one change to a payment. A
+// payment's current state is the result of replaying its events in
+// sequence order.
+type PaymentEvent struct {
+	ID        string
+	PaymentID string
+	// Sequence numbers a payment's events from 1 with no gaps
+	Sequence   int
+	Type       EventType
+	OccurredAt time.Time
+
+	// Actor and RequestID say who made the change and from which request
+	Actor     string
+	RequestID string
+
+	Data PaymentEventData
+}
+
+// PaymentEventData is what changed. Which fields are set depends on the
+// event type; it never holds the card number or CVV.
+type PaymentEventData struct {
+	// Set on EventPaymentRequested
+	MerchantID       string
+	CardToken        string
+	CardLastFour     string
+	ExpiryMonth      int
+	ExpiryYear       int
+	Currency         string
+	Amount           int
+	Initiator        Initiator
+	StoredCredential *StoredCredential
+	CorrelationID    string
+
+	// Set on status changes
+	Status               PaymentStatus
+	NetworkTransactionID string
+	DeclineReason        DeclineReason
+	AuthorizationCode    string
+	CapturedAmount       int
+	RefundedAmount       int
+}
+
+// statusEvents maps a payment status to the event that records reaching it
+var statusEvents = map[PaymentStatus]EventType{
+	StatusAuthorized: EventPaymentAuthorized,
+	StatusDeclined:   EventPaymentDeclined,
+	StatusRejected:   EventPaymentRejected,
+	StatusPending:    EventPaymentPending,
+	StatusCaptured:   EventPaymentCaptured,
+	StatusVoided:     EventPaymentVoided,
+	StatusRefunded:   EventPaymentRefunded,
+}
+
+// Changes returns the events that take a payment from previous to p.
+// previous is nil for a payment that has not been recorded yet. The events
+// have no ID, sequence or audit fields; the event store assigns those.
+func (p *Payment) Changes(previous *Payment) []PaymentEvent {
+	var events []PaymentEvent
+
+	if previous == nil {
+		var storedCredential *StoredCredential
+		if p.StoredCredential != nil {
+			sc := *p.StoredCredential
+			storedCredential = &sc
+		}
+
+		events = append(events, PaymentEvent{
+			Type: EventPaymentRequested,
+			Data: PaymentEventData{
+				MerchantID:       p.MerchantID,
+				CardToken:        p.Card.Token,
+				CardLastFour:     p.Card.GetLastFourDigits(),
+				ExpiryMonth:      p.Card.ExpiryMonth,
+				ExpiryYear:       p.Card.ExpiryYear,
+				Currency:         p.Currency,
+				Amount:           p.Amount,
+				Initiator:        p.Initiator,
+				StoredCredential: storedCredential,
+				CorrelationID:    p.CorrelationID,
+			},
+		})
+	}
+
+	// A payment refunded in parts stays refunded, so every refund after the
+	// first only shows in the refunded amount
+	if previous == nil || previous.Status != p.Status || previous.NetworkTransactionID != p.NetworkTransactionID ||
+		previous.CapturedAmount != p.CapturedAmount || previous.RefundedAmount != p.RefundedAmount {
+		eventType, ok := statusEvents[p.Status]
+		if !ok {
+			eventType = EventType("payment." + strings.ToLower(string(p.Status)))
+		}
+		events = append(events, PaymentEvent{
+			Type: eventType,
+			Data: PaymentEventData{
+				Status:               p.Status,
+				NetworkTransactionID: p.NetworkTransactionID,
+				DeclineReason:        p.DeclineReason,
+				AuthorizationCode:    p.AuthorizationCode,
+				CapturedAmount:       p.CapturedAmount,
+				RefundedAmount:       p.RefundedAmount,
+			},
+		})
+	}
+
+	for i := range events {
+		events[i].PaymentID = p.ID
+	}
+	return events
+}
+
+// ReplayPayment rebuilds a payment from its events. It returns nil when
+// there are none.
+func ReplayPayment(events []PaymentEvent) *Payment {
+	if len(events) == 0 {
+		return nil
+	}
+
+	p := &Payment{ID: events[0].PaymentID}
+	for _, e := range events {
+		p.apply(e)
+	}
+	return p
+}
+
+func (p *Payment) apply(e PaymentEvent) {
+	d := e.Data
+
+	switch e.Type {
+	case EventPaymentRequested:
+		p.MerchantID = d.MerchantID
+		p.Card = Card{
+			Token:       d.CardToken,
+			LastFour:    d.CardLastFour,
+			ExpiryMonth: d.ExpiryMonth,
+			ExpiryYear:  d.ExpiryYear,
+		}
+		p.Currency = d.Currency
+		p.Amount = d.Amount
+		p.Initiator = d.Initiator
+		p.StoredCredential = d.StoredCredential
+		p.RequestID = e.RequestID
+		p.CorrelationID = d.CorrelationID
+	case EventCardShredded:
+		// History only; the card details that are kept do not change
+	default:
+		if d.Status != "" {
+			p.Status = d.Status
+			p.NetworkTransactionID = d.NetworkTransactionID
+			p.DeclineReason = d.DeclineReason
+			p.AuthorizationCode = d.AuthorizationCode
+			p.CapturedAmount = d.CapturedAmount
+			p.RefundedAmount = d.RefundedAmount
+		}
+	}
+}
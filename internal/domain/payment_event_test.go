@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayment_Changes(t *testing.T) {
+	p := &Payment{
+		ID:        "payment-1",
+		Card:      Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency:  "GBP",
+		Amount:    100,
+		Status:    StatusAuthorized,
+		Initiator: InitiatorCustomer,
+	}
+
+	created := p.Changes(nil)
+	require.Len(t, created, 2)
+	assert.Equal(t, EventPaymentRequested, created[0].Type)
+	assert.Equal(t, "8877", created[0].Data.CardLastFour)
+	assert.Equal(t, EventPaymentAuthorized, created[1].Type)
+	assert.Equal(t, "payment-1", created[1].PaymentID)
+
+	previous := ReplayPayment(created)
+	assert.Empty(t, p.Changes(previous))
+
+	p.SetDeclined()
+	changed := p.Changes(previous)
+	require.Len(t, changed, 1)
+	assert.Equal(t, EventPaymentDeclined, changed[0].Type)
+	assert.Equal(t, StatusDeclined, changed[0].Data.Status)
+}
+
+func TestPayment_Changes_Settlements(t *testing.T) {
+	p := &Payment{ID: "payment-1", Currency: "GBP", Amount: 100, Status: StatusAuthorized, AuthorizationCode: "auth-1"}
+	history := p.Changes(nil)
+
+	require.NoError(t, p.Capture(0))
+	require.NoError(t, p.Refund(30))
+	history = append(history, p.Changes(ReplayPayment(history))...)
+
+	// The second refund leaves the payment refunded, but is still recorded
+	require.NoError(t, p.Refund(20))
+	refunded := p.Changes(ReplayPayment(history))
+	require.Len(t, refunded, 1)
+	assert.Equal(t, EventPaymentRefunded, refunded[0].Type)
+	history = append(history, refunded...)
+
+	replayed := ReplayPayment(history)
+	assert.Equal(t, StatusRefunded, replayed.Status)
+	assert.Equal(t, "auth-1", replayed.AuthorizationCode)
+	assert.Equal(t, 100, replayed.CapturedAmount)
+	assert.Equal(t, 50, replayed.RefundedAmount)
+}
+
+func TestReplayPayment(t *testing.T) {
+	assert.Nil(t, ReplayPayment(nil))
+
+	sc := &StoredCredential{Usage: StoredCredentialFirst, Type: StoredCredentialRecurring}
+	p := ReplayPayment([]PaymentEvent{
+		{PaymentID: "payment-1", Type: EventPaymentRequested, RequestID: "req-1", Data: PaymentEventData{
+			CardToken:        "tok_abc",
+			CardLastFour:     "8877",
+			ExpiryMonth:      4,
+			ExpiryYear:       2030,
+			Currency:         "GBP",
+			Amount:           100,
+			Initiator:        InitiatorCustomer,
+			StoredCredential: sc,
+			CorrelationID:    "order-42",
+		}},
+		{PaymentID: "payment-1", Type: EventPaymentAuthorized, Data: PaymentEventData{Status: StatusAuthorized, NetworkTransactionID: "ntid-1"}},
+		{PaymentID: "payment-1", Type: EventCardShredded},
+	})
+
+	assert.Equal(t, &Payment{
+		ID:                   "payment-1",
+		Card:                 Card{Token: "tok_abc", LastFour: "8877", ExpiryMonth: 4, ExpiryYear: 2030},
+		Currency:             "GBP",
+		Amount:               100,
+		Status:               StatusAuthorized,
+		Initiator:            InitiatorCustomer,
+		StoredCredential:     sc,
+		NetworkTransactionID: "ntid-1",
+		RequestID:            "req-1",
+		CorrelationID:        "order-42",
+	}, p)
+
+	declined := ReplayPayment([]PaymentEvent{
+		{PaymentID: "payment-2", Type: EventPaymentRequested, Data: PaymentEventData{Currency: "GBP", Amount: 100}},
+		{PaymentID: "payment-2", Type: EventPaymentDeclined, Data: PaymentEventData{Status: StatusDeclined, DeclineReason: DeclineExpiredCard}},
+	})
+	assert.Equal(t, StatusDeclined, declined.Status)
+	assert.Equal(t, DeclineExpiredCard, declined.DeclineReason)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPayment_ValidateCurrency(t *testing.T) {
@@ -84,6 +85,19 @@ func TestPayment_ValidateCurrency(t *testing.T) {
 	}
 }
 
+func TestSetSupportedCurrencies(t *testing.T) {
+	t.Cleanup(func() { SetSupportedCurrencies(DefaultCurrencies) })
+
+	SetSupportedCurrencies([]string{"jpy", "GBP"})
+
+	assert.True(t, IsSupportedCurrency("JPY"))
+	assert.True(t, IsSupportedCurrency("gbp"))
+	assert.False(t, IsSupportedCurrency("USD"))
+
+	payment := &Payment{Currency: "usd"}
+	assert.Equal(t, ErrCurrencyInvalid, payment.validateCurrency())
+}
+
 func TestPayment_ValidateAmount(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -305,3 +319,41 @@ func TestPayment_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestPayment_CaptureVoidRefund(t *testing.T) {
+	authorized := func() *Payment {
+		return &Payment{Currency: "GBP", Amount: 1000, Status: StatusAuthorized, AuthorizationCode: "auth-1"}
+	}
+
+	p := authorized()
+	assert.ErrorIs(t, p.Capture(1001), ErrCaptureAmountInvalid)
+	assert.ErrorIs(t, p.Refund(0), ErrPaymentNotRefundable)
+	require.NoError(t, p.Capture(600))
+	assert.Equal(t, StatusCaptured, p.Status)
+	assert.Equal(t, 600, p.CapturedAmount)
+	assert.ErrorIs(t, p.Capture(0), ErrPaymentNotCapturable)
+	assert.ErrorIs(t, p.Void(), ErrPaymentNotVoidable)
+
+	require.NoError(t, p.Refund(100))
+	assert.Equal(t, StatusRefunded, p.Status)
+	assert.Equal(t, 100, p.RefundedAmount)
+	assert.ErrorIs(t, p.Refund(501), ErrRefundAmountInvalid)
+	require.NoError(t, p.Refund(0))
+	assert.Equal(t, 600, p.RefundedAmount)
+	assert.ErrorIs(t, p.Refund(0), ErrPaymentNotRefundable)
+
+	p = authorized()
+	require.NoError(t, p.Capture(0))
+	assert.Equal(t, 1000, p.CapturedAmount)
+
+	p = authorized()
+	require.NoError(t, p.Void())
+	assert.Equal(t, StatusVoided, p.Status)
+	assert.True(t, p.IsAuthorized())
+	assert.ErrorIs(t, p.Capture(0), ErrPaymentNotCapturable)
+
+	declined := &Payment{Currency: "GBP", Amount: 1000, Status: StatusDeclined}
+	assert.False(t, declined.IsAuthorized())
+	assert.ErrorIs(t, declined.Capture(0), ErrPaymentNotCapturable)
+	assert.ErrorIs(t, declined.Void(), ErrPaymentNotVoidable)
+}
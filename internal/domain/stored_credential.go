@@ -0,0 +1,73 @@
+package domain
+
+// Initiator identifies who started a payment
+type Initiator string
+
+const (
+	// InitiatorCustomer means the cardholder is present, e.g. checking out
+	InitiatorCustomer Initiator = "customer"
+	// InitiatorMerchant means the merchant charges a stored card without the
+	// cardholder being present, e.g. a subscription renewal
+	InitiatorMerchant Initiator = "merchant"
+)
+
+// StoredCredentialUsage says whether a stored card is being used for the
+// first time or has been used before
+type StoredCredentialUsage string
+
+const (
+	StoredCredentialFirst      StoredCredentialUsage = "first"
+	StoredCredentialSubsequent StoredCredentialUsage = "subsequent"
+)
+
+// StoredCredentialType is the agreement under which a card is stored
+type StoredCredentialType string
+
+const (
+	// StoredCredentialRecurring is for charges on a fixed schedule
+	StoredCredentialRecurring StoredCredentialType = "recurring"
+	// StoredCredentialUnscheduled is for charges at irregular times, e.g. top-ups
+	StoredCredentialUnscheduled StoredCredentialType = "unscheduled"
+)
+
+// StoredCredential flags a payment made with, or setting up, a stored card.
+// PreviousNetworkTransactionID links a follow-up charge to the network
+// transaction ID of the payment that set the credential up.
+type StoredCredential struct {
+	Usage                        StoredCredentialUsage
+	Type                         StoredCredentialType
+	PreviousNetworkTransactionID string
+}
+
+func (sc *StoredCredential) Validate() error {
+	switch sc.Usage {
+	case StoredCredentialFirst, StoredCredentialSubsequent:
+	default:
+		return ErrStoredCredentialUsageInvalid
+	}
+
+	switch sc.Type {
+	case StoredCredentialRecurring, StoredCredentialUnscheduled:
+	default:
+		return ErrStoredCredentialTypeInvalid
+	}
+
+	return nil
+}
+
+// PaymentOption sets optional fields on a payment before it is validated
+type PaymentOption func(*Payment)
+
+// WithInitiator sets who initiated the payment
+func WithInitiator(initiator Initiator) PaymentOption {
+	return func(p *Payment) {
+		p.Initiator = initiator
+	}
+}
+
+// WithStoredCredential marks the payment as using a stored card
+func WithStoredCredential(sc *StoredCredential) PaymentOption {
+	return func(p *Payment) {
+		p.StoredCredential = sc
+	}
+}
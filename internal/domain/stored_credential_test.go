@@ -0,0 +1,156 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayment_ValidateStoredCredential(t *testing.T) {
+	tests := []struct {
+		name             string
+		initiator        Initiator
+		storedCredential *StoredCredential
+		expectError      error
+	}{
+		{
+			name:        "customer-initiated without stored credential",
+			initiator:   InitiatorCustomer,
+			expectError: nil,
+		},
+		{
+			name:        "empty initiator defaults to customer",
+			initiator:   "",
+			expectError: nil,
+		},
+		{
+			name:        "unknown initiator",
+			initiator:   "robot",
+			expectError: ErrInitiatorInvalid,
+		},
+		{
+			name:      "customer-initiated first use",
+			initiator: InitiatorCustomer,
+			storedCredential: &StoredCredential{
+				Usage: StoredCredentialFirst,
+				Type:  StoredCredentialRecurring,
+			},
+			expectError: nil,
+		},
+		{
+			name:      "merchant-initiated subsequent recurring",
+			initiator: InitiatorMerchant,
+			storedCredential: &StoredCredential{
+				Usage:                        StoredCredentialSubsequent,
+				Type:                         StoredCredentialRecurring,
+				PreviousNetworkTransactionID: "ntid-1",
+			},
+			expectError: nil,
+		},
+		{
+			name:      "merchant-initiated subsequent unscheduled",
+			initiator: InitiatorMerchant,
+			storedCredential: &StoredCredential{
+				Usage:                        StoredCredentialSubsequent,
+				Type:                         StoredCredentialUnscheduled,
+				PreviousNetworkTransactionID: "ntid-1",
+			},
+			expectError: nil,
+		},
+		{
+			name:        "merchant-initiated without stored credential",
+			initiator:   InitiatorMerchant,
+			expectError: ErrStoredCredentialRequired,
+		},
+		{
+			name:      "merchant-initiated first use",
+			initiator: InitiatorMerchant,
+			storedCredential: &StoredCredential{
+				Usage: StoredCredentialFirst,
+				Type:  StoredCredentialRecurring,
+			},
+			expectError: ErrMerchantInitiatedFirstUse,
+		},
+		{
+			name:      "merchant-initiated without previous transaction",
+			initiator: InitiatorMerchant,
+			storedCredential: &StoredCredential{
+				Usage: StoredCredentialSubsequent,
+				Type:  StoredCredentialRecurring,
+			},
+			expectError: ErrPreviousTransactionRequired,
+		},
+		{
+			name:      "invalid usage",
+			initiator: InitiatorCustomer,
+			storedCredential: &StoredCredential{
+				Usage: "sometimes",
+				Type:  StoredCredentialRecurring,
+			},
+			expectError: ErrStoredCredentialUsageInvalid,
+		},
+		{
+			name:      "invalid type",
+			initiator: InitiatorCustomer,
+			storedCredential: &StoredCredential{
+				Usage: StoredCredentialFirst,
+				Type:  "installment",
+			},
+			expectError: ErrStoredCredentialTypeInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := &Payment{
+				Initiator:        tt.initiator,
+				StoredCredential: tt.storedCredential,
+			}
+
+			err := payment.validateStoredCredential()
+			if tt.expectError != nil {
+				assert.Equal(t, tt.expectError, err)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, payment.Initiator)
+			}
+		})
+	}
+}
+
+func TestNewPayment_MerchantInitiatedCVV(t *testing.T) {
+	card := Card{
+		Number:      "1234567890123456",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+	}
+	storedCredential := &StoredCredential{
+		Usage:                        StoredCredentialSubsequent,
+		Type:                         StoredCredentialRecurring,
+		PreviousNetworkTransactionID: "ntid-1",
+	}
+
+	// CVV is not needed when the cardholder is not present
+	payment, err := NewPayment(card, "USD", 1000, WithInitiator(InitiatorMerchant), WithStoredCredential(storedCredential))
+	assert.NoError(t, err)
+	assert.True(t, payment.IsMerchantInitiated())
+
+	// but is still validated when supplied
+	card.CVV = "12"
+	_, err = NewPayment(card, "USD", 1000, WithInitiator(InitiatorMerchant), WithStoredCredential(storedCredential))
+	assert.Equal(t, ErrCVVInvalid, err)
+
+	// Customer-initiated payments with a stored card still need the CVV
+	card.CVV = ""
+	_, err = NewPayment(card, "USD", 1000, WithStoredCredential(&StoredCredential{
+		Usage: StoredCredentialSubsequent,
+		Type:  StoredCredentialUnscheduled,
+	}))
+	assert.Equal(t, ErrCVVRequired, err)
+
+	// Tokenized cards follow the same rule
+	tokenCard := Card{Token: "tok_abc"}
+	_, err = NewPayment(tokenCard, "USD", 1000, WithInitiator(InitiatorMerchant), WithStoredCredential(storedCredential))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,171 @@
+package domain
+
+import (
+	"time"
+)
+
+// Interval is the unit of a plan's billing period
+type Interval string
+
+const (
+	IntervalDay   Interval = "day"
+	IntervalWeek  Interval = "week"
+	IntervalMonth Interval = "month"
+	IntervalYear  Interval = "year"
+)
+
+// Plan is a price charged every IntervalCount intervals
+type Plan struct {
+	ID string
+	// MerchantID is the merchant that created the plan; only it can
+	// subscribe customers to it
+	MerchantID    string
+	Name          string
+	Amount        int
+	Currency      string
+	Interval      Interval
+	IntervalCount int
+	CreatedAt     time.Time
+}
+
+func (p *Plan) Validate() error {
+	if p.Name == "" {
+		return ErrPlanNameRequired
+	}
+
+	if p.Amount <= 0 {
+		return ErrAmountInvalid
+	}
+
+	currency, err := normalizeCurrency(p.Currency)
+	if err != nil {
+		return err
+	}
+	p.Currency = currency
+
+	switch p.Interval {
+	case IntervalDay, IntervalWeek, IntervalMonth, IntervalYear:
+	default:
+		return ErrPlanIntervalInvalid
+	}
+
+	if p.IntervalCount == 0 {
+		p.IntervalCount = 1
+	}
+	if p.IntervalCount < 0 {
+		return ErrPlanIntervalInvalid
+	}
+
+	return nil
+}
+
+// PeriodEnd returns the end of the n-th billing period counted from anchor.
+// Monthly and yearly periods keep the anchor's day of month, clamped to the
+// last day of shorter months, so a plan started on 31 January renews on
+// 28 February and then 31 March.
+func (p *Plan) PeriodEnd(anchor time.Time, n int) time.Time {
+	steps := n * p.IntervalCount
+
+	switch p.Interval {
+	case IntervalDay:
+		return anchor.AddDate(0, 0, steps)
+	case IntervalWeek:
+		return anchor.AddDate(0, 0, 7*steps)
+	case IntervalYear:
+		return addMonthsClamped(anchor, 12*steps)
+	default:
+		return addMonthsClamped(anchor, steps)
+	}
+}
+
+func addMonthsClamped(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+
+	lastDay := first.AddDate(0, 1, -1).Day()
+	if day > lastDay {
+		day = lastDay
+	}
+
+	return first.AddDate(0, 0, day-1)
+}
+
+type SubscriptionStatus string
+
+const (
+	// SubscriptionActive is charged on every renewal
+	SubscriptionActive SubscriptionStatus = "active"
+	// SubscriptionPastDue has a failed renewal that is being retried
+	SubscriptionPastDue SubscriptionStatus = "past_due"
+	// SubscriptionPaused is not charged until resumed
+	SubscriptionPaused SubscriptionStatus = "paused"
+	// SubscriptionCanceled is never charged again
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+	// SubscriptionReconciling has a renewal the bank gave no outcome for. It
+	// is not charged again until an operator has checked with the bank.
+	SubscriptionReconciling SubscriptionStatus = "reconciling"
+)
+
+// ChargeReason says why a subscription charge was made
+type ChargeReason string
+
+const (
+	ChargeInitial   ChargeReason = "initial"
+	ChargeRenewal   ChargeReason = "renewal"
+	ChargeProration ChargeReason = "proration"
+)
+
+// SubscriptionCharge records one attempt to charge a subscription
+type SubscriptionCharge struct {
+	PaymentID string
+	Amount    int
+	Currency  string
+	Reason    ChargeReason
+	Status    PaymentStatus
+	Attempt   int
+	Error     string
+	CreatedAt time.Time
+}
+
+// Subscription binds a customer's stored card to a plan.
+//
+// Periods are counted from BillingAnchor: the current period ends at
+// plan.PeriodEnd(BillingAnchor, Cycles). Changing plan re-anchors at the end
+// of the current period.
+type Subscription struct {
+	ID        string
+	PlanID    string
+	CardToken string
+	Status    SubscriptionStatus
+	// MerchantID is the merchant that subscribed the customer; renewals
+	// are charged on its behalf
+	MerchantID string
+
+	BillingAnchor      time.Time
+	Cycles             int
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   time.Time
+	NextChargeAt       time.Time
+
+	// FailedAttempts counts consecutive failed renewals for dunning
+	FailedAttempts int
+	// Credit is owed to the customer after a downgrade and is taken off the
+	// next renewals, in minor units
+	Credit int
+
+	CancelAtPeriodEnd bool
+	CanceledAt        *time.Time
+	PausedAt          *time.Time
+
+	// NetworkTransactionID of the initial customer-initiated payment, sent
+	// with every merchant-initiated renewal
+	NetworkTransactionID string
+
+	Charges   []SubscriptionCharge
+	CreatedAt time.Time
+}
+
+// IsBillable reports whether the scheduler should charge the subscription
+func (s *Subscription) IsBillable() bool {
+	return s.Status == SubscriptionActive || s.Status == SubscriptionPastDue
+}
@@ -0,0 +1,130 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		plan    Plan
+		wantErr error
+	}{
+		{
+			name: "valid monthly plan",
+			plan: Plan{Name: "Pro", Amount: 999, Currency: "gbp", Interval: IntervalMonth},
+		},
+		{
+			name:    "missing name",
+			plan:    Plan{Amount: 999, Currency: "GBP", Interval: IntervalMonth},
+			wantErr: ErrPlanNameRequired,
+		},
+		{
+			name:    "zero amount",
+			plan:    Plan{Name: "Pro", Currency: "GBP", Interval: IntervalMonth},
+			wantErr: ErrAmountInvalid,
+		},
+		{
+			name:    "unsupported currency",
+			plan:    Plan{Name: "Pro", Amount: 999, Currency: "JPY", Interval: IntervalMonth},
+			wantErr: ErrCurrencyInvalid,
+		},
+		{
+			name:    "unknown interval",
+			plan:    Plan{Name: "Pro", Amount: 999, Currency: "GBP", Interval: "fortnight"},
+			wantErr: ErrPlanIntervalInvalid,
+		},
+		{
+			name:    "negative interval count",
+			plan:    Plan{Name: "Pro", Amount: 999, Currency: "GBP", Interval: IntervalWeek, IntervalCount: -1},
+			wantErr: ErrPlanIntervalInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.Validate()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "GBP", tt.plan.Currency)
+			assert.Equal(t, 1, tt.plan.IntervalCount)
+		})
+	}
+}
+
+func TestPlan_PeriodEnd(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		plan   Plan
+		anchor time.Time
+		n      int
+		want   time.Time
+	}{
+		{
+			name:   "daily",
+			plan:   Plan{Interval: IntervalDay, IntervalCount: 1},
+			anchor: date(2026, time.March, 30),
+			n:      3,
+			want:   date(2026, time.April, 2),
+		},
+		{
+			name:   "every two weeks",
+			plan:   Plan{Interval: IntervalWeek, IntervalCount: 2},
+			anchor: date(2026, time.January, 1),
+			n:      1,
+			want:   date(2026, time.January, 15),
+		},
+		{
+			name:   "monthly clamps to the end of February",
+			plan:   Plan{Interval: IntervalMonth, IntervalCount: 1},
+			anchor: date(2026, time.January, 31),
+			n:      1,
+			want:   date(2026, time.February, 28),
+		},
+		{
+			name:   "monthly returns to the anchor day after a short month",
+			plan:   Plan{Interval: IntervalMonth, IntervalCount: 1},
+			anchor: date(2026, time.January, 31),
+			n:      2,
+			want:   date(2026, time.March, 31),
+		},
+		{
+			name:   "quarterly across a year end",
+			plan:   Plan{Interval: IntervalMonth, IntervalCount: 3},
+			anchor: date(2026, time.November, 30),
+			n:      1,
+			want:   date(2027, time.February, 28),
+		},
+		{
+			name:   "yearly from a leap day",
+			plan:   Plan{Interval: IntervalYear, IntervalCount: 1},
+			anchor: date(2028, time.February, 29),
+			n:      1,
+			want:   date(2029, time.February, 28),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.plan.PeriodEnd(tt.anchor, tt.n))
+		})
+	}
+}
+
+func TestSubscription_IsBillable(t *testing.T) {
+	assert.True(t, (&Subscription{Status: SubscriptionActive}).IsBillable())
+	assert.True(t, (&Subscription{Status: SubscriptionPastDue}).IsBillable())
+	assert.False(t, (&Subscription{Status: SubscriptionPaused}).IsBillable())
+	assert.False(t, (&Subscription{Status: SubscriptionCanceled}).IsBillable())
+}
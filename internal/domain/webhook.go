@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"net/url"
+	"sort"
+	"time"
+)
+
+// EventType names a payment lifecycle event that merchants can subscribe to
+type EventType string
+
+const (
+	EventPaymentAuthorized EventType = "payment.authorized"
+	EventPaymentDeclined   EventType = "payment.declined"
+	EventPaymentCaptured   EventType = "payment.captured"
+	EventPaymentVoided     EventType = "payment.voided"
+	EventPaymentRefunded   EventType = "payment.refunded"
+)
+
+var eventTypes = map[EventType]bool{
+	EventPaymentAuthorized: true,
+	EventPaymentDeclined:   true,
+	EventPaymentCaptured:   true,
+	EventPaymentVoided:     true,
+	EventPaymentRefunded:   true,
+	// Only asynchronous payments are stored as rejected, so only they
+	// announce it
+	EventPaymentRejected: true,
+}
+
+// WebhookEventTypes returns every event type an endpoint can subscribe to,
+// sorted
+func WebhookEventTypes() []EventType {
+	types := make([]EventType, 0, len(eventTypes))
+	for t := range eventTypes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// WebhookEndpoint is a merchant URL that receives signed event deliveries
+type WebhookEndpoint struct {
+	ID  string
+	URL string
+	// MerchantID is the merchant that registered the endpoint, the only
+	// one whose payment events it receives
+	MerchantID string
+	// Secret signs every delivery so the merchant can check it came from us
+	Secret string
+	// EventTypes the endpoint receives; empty means all of them
+	EventTypes []EventType
+	CreatedAt  time.Time
+}
+
+func (e *WebhookEndpoint) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrWebhookURLInvalid
+	}
+
+	for _, t := range e.EventTypes {
+		if !eventTypes[t] {
+			return ErrEventTypeInvalid
+		}
+	}
+
+	return nil
+}
+
+// Receives reports whether the endpoint is subscribed to events of type t
+func (e *WebhookEndpoint) Receives(t EventType) bool {
+	if len(e.EventTypes) == 0 {
+		return true
+	}
+	for _, et := range e.EventTypes {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is something that happened to a payment. Payload is the JSON body
+// delivered to webhook endpoints and never contains the card number.
+type Event struct {
+	ID        string
+	Type      EventType
+	PaymentID string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is waiting in the outbox for its next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded was acknowledged with a 2xx response
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead ran out of retries and sits in the dead-letter queue
+	// until it is redelivered by hand
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// DeliveryAttempt records one HTTP request to a webhook endpoint
+type DeliveryAttempt struct {
+	At         time.Time
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// WebhookDelivery is one event bound for one endpoint
+type WebhookDelivery struct {
+	ID         string
+	EventID    string
+	EndpointID string
+	Status     DeliveryStatus
+	// Failures counts consecutive failed attempts since the delivery was
+	// created or last redelivered and drives the backoff
+	Failures      int
+	NextAttemptAt time.Time
+	Attempts      []DeliveryAttempt
+	CreatedAt     time.Time
+	// Version counts the saves of the delivery, so a save based on a stale
+	// read is refused instead of overwriting a newer one
+	Version int
+}
@@ -0,0 +1,217 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var (
+	ErrShredded = errors.New("record has been crypto-shredded")
+	ErrDecrypt  = errors.New("failed to decrypt record")
+)
+
+// Sealed is a record encrypted with its own data key. The data key is stored
+// next to the ciphertext, wrapped by the master key identified by KeyVersion.
+// Destroying WrappedKey makes the ciphertext permanently unreadable.
+type Sealed struct {
+	KeyVersion int    `json:"key_version"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// IsShredded reports whether the data key has been destroyed
+func (s *Sealed) IsShredded() bool {
+	return len(s.WrappedKey) == 0
+}
+
+// Shred destroys the data key so the record can no longer be decrypted
+func (s *Sealed) Shred() {
+	for i := range s.WrappedKey {
+		s.WrappedKey[i] = 0
+	}
+	s.WrappedKey = nil
+	s.KeyVersion = 0
+}
+
+// Envelope encrypts records with per-record data keys wrapped by the master
+// keys of a KeyProvider
+type Envelope struct {
+	provider KeyProvider
+}
+
+func New(provider KeyProvider) *Envelope {
+	return &Envelope{provider: provider}
+}
+
+// Seal encrypts plaintext with a fresh data key. The additional data is
+// authenticated but not stored, so the same value must be passed to Open;
+// callers use the record ID to stop ciphertexts being swapped between records.
+func (e *Envelope) Seal(plaintext, additionalData []byte) (*Sealed, error) {
+	master, err := e.provider.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master key: %w", err)
+	}
+
+	dataKey, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer zero(dataKey)
+
+	nonce, ciphertext, err := encrypt(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapKey(master, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealed{
+		KeyVersion: master.Version,
+		WrappedKey: wrapped,
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// Open decrypts a sealed record
+func (e *Envelope) Open(s *Sealed, additionalData []byte) ([]byte, error) {
+	if s.IsShredded() {
+		return nil, ErrShredded
+	}
+
+	dataKey, err := e.unwrapKey(s)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(dataKey)
+
+	plaintext, err := decrypt(dataKey, s.Nonce, s.Ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
+
+// Rewrap re-encrypts the data key of s with the current master key. The
+// ciphertext is left untouched, so rotation never handles the plaintext.
+// It reports whether s was changed.
+func (e *Envelope) Rewrap(s *Sealed) (bool, error) {
+	if s.IsShredded() {
+		return false, nil
+	}
+
+	master, err := e.provider.CurrentKey()
+	if err != nil {
+		return false, fmt.Errorf("failed to get master key: %w", err)
+	}
+
+	if s.KeyVersion == master.Version {
+		return false, nil
+	}
+
+	dataKey, err := e.unwrapKey(s)
+	if err != nil {
+		return false, err
+	}
+	defer zero(dataKey)
+
+	wrapped, err := wrapKey(master, dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	s.WrappedKey = wrapped
+	s.KeyVersion = master.Version
+	return true, nil
+}
+
+func (e *Envelope) unwrapKey(s *Sealed) ([]byte, error) {
+	master, err := e.provider.Key(s.KeyVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get master key: %w", err)
+	}
+
+	if len(s.WrappedKey) <= nonceSize {
+		return nil, ErrDecrypt
+	}
+
+	// The key version is authenticated so a wrapped key cannot be replayed
+	// under another version
+	dataKey, err := decrypt(master.Key, s.WrappedKey[:nonceSize], s.WrappedKey[nonceSize:], versionAD(master.Version))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return dataKey, nil
+}
+
+func wrapKey(master *MasterKey, dataKey []byte) ([]byte, error) {
+	nonce, ciphertext, err := encrypt(master.Key, dataKey, versionAD(master.Version))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// nonceSize is the standard AES-GCM nonce size
+const nonceSize = 12
+
+func encrypt(key, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return nonce, aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != nonceSize {
+		return nil, ErrDecrypt
+	}
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func versionAD(version int) []byte {
+	return []byte("master-key-v" + strconv.Itoa(version))
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,161 @@
+package envelope
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProvider(t *testing.T, current int, versions ...int) (*StaticKeyProvider, map[int][]byte) {
+	keys := make(map[int][]byte)
+	for _, v := range versions {
+		key, err := GenerateKey()
+		require.NoError(t, err)
+		keys[v] = key
+	}
+
+	provider, err := NewStaticKeyProvider(current, keys)
+	require.NoError(t, err)
+	return provider, keys
+}
+
+func TestEnvelope_SealAndOpen(t *testing.T) {
+	provider, _ := newTestProvider(t, 1, 1)
+	e := New(provider)
+
+	sealed, err := e.Seal([]byte("2222405343248877"), []byte("record-1"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, sealed.KeyVersion)
+	assert.NotContains(t, string(sealed.Ciphertext), "2222405343248877")
+
+	plaintext, err := e.Open(sealed, []byte("record-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", string(plaintext))
+}
+
+func TestEnvelope_DataKeysArePerRecord(t *testing.T) {
+	provider, _ := newTestProvider(t, 1, 1)
+	e := New(provider)
+
+	first, err := e.Seal([]byte("same"), nil)
+	require.NoError(t, err)
+	second, err := e.Seal([]byte("same"), nil)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first.WrappedKey, second.WrappedKey)
+	assert.NotEqual(t, first.Ciphertext, second.Ciphertext)
+}
+
+func TestEnvelope_Open_WrongAdditionalData(t *testing.T) {
+	provider, _ := newTestProvider(t, 1, 1)
+	e := New(provider)
+
+	sealed, err := e.Seal([]byte("secret"), []byte("record-1"))
+	require.NoError(t, err)
+
+	_, err = e.Open(sealed, []byte("record-2"))
+	assert.Equal(t, ErrDecrypt, err)
+}
+
+func TestEnvelope_Shred(t *testing.T) {
+	provider, _ := newTestProvider(t, 1, 1)
+	e := New(provider)
+
+	sealed, err := e.Seal([]byte("secret"), nil)
+	require.NoError(t, err)
+
+	sealed.Shred()
+
+	assert.True(t, sealed.IsShredded())
+	_, err = e.Open(sealed, nil)
+	assert.Equal(t, ErrShredded, err)
+
+	changed, err := e.Rewrap(sealed)
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestEnvelope_Rewrap(t *testing.T) {
+	v1, keys := newTestProvider(t, 1, 1)
+	sealed, err := New(v1).Seal([]byte("secret"), nil)
+	require.NoError(t, err)
+	ciphertext := append([]byte(nil), sealed.Ciphertext...)
+
+	key2, err := GenerateKey()
+	require.NoError(t, err)
+	v2, err := NewStaticKeyProvider(2, map[int][]byte{1: keys[1], 2: key2})
+	require.NoError(t, err)
+	e := New(v2)
+
+	changed, err := e.Rewrap(sealed)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 2, sealed.KeyVersion)
+	assert.Equal(t, ciphertext, sealed.Ciphertext) // Only the data key is re-wrapped
+
+	changed, err = e.Rewrap(sealed)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// Readable with only the new version
+	v2only, err := NewStaticKeyProvider(2, map[int][]byte{2: key2})
+	require.NoError(t, err)
+	plaintext, err := New(v2only).Open(sealed, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+}
+
+func TestEnvelope_Open_UnknownVersion(t *testing.T) {
+	v1, _ := newTestProvider(t, 1, 1)
+	sealed, err := New(v1).Seal([]byte("secret"), nil)
+	require.NoError(t, err)
+
+	v2, _ := newTestProvider(t, 2, 2)
+	_, err = New(v2).Open(sealed, nil)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
+
+type countingStore struct {
+	sealed []*Sealed
+}
+
+func (s *countingStore) RewrapKeys(e *Envelope) (int, error) {
+	changed := 0
+	for _, sealed := range s.sealed {
+		ok, err := e.Rewrap(sealed)
+		if err != nil {
+			return changed, err
+		}
+		if ok {
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+func TestRotator_RotateOnce(t *testing.T) {
+	v1, keys := newTestProvider(t, 1, 1)
+	store := &countingStore{}
+	for i := 0; i < 3; i++ {
+		sealed, err := New(v1).Seal([]byte("secret"), nil)
+		require.NoError(t, err)
+		store.sealed = append(store.sealed, sealed)
+	}
+
+	key2, err := GenerateKey()
+	require.NoError(t, err)
+	v2, err := NewStaticKeyProvider(0, map[int][]byte{1: keys[1], 2: key2})
+	require.NoError(t, err)
+
+	rotator := NewRotator(New(v2), 0, store)
+
+	n, err := rotator.RotateOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	n, err = rotator.RotateOnce()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
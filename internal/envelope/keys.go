@@ -0,0 +1,285 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the length in bytes of master keys and data keys (AES-256)
+const KeySize = 32
+
+var (
+	ErrInvalidKey     = errors.New("master key must be 32 bytes")
+	ErrNoMasterKey    = errors.New("no master key configured")
+	ErrUnknownVersion = errors.New("unknown master key version")
+)
+
+// MasterKey is a versioned key-encryption key. It only ever wraps data keys;
+// records are never encrypted with it directly.
+type MasterKey struct {
+	Version int
+	Key     []byte
+}
+
+// KeyProvider supplies master keys. CurrentKey is used to wrap new data keys
+// and Key is used to unwrap data keys that were wrapped by an older version.
+type KeyProvider interface {
+	CurrentKey() (*MasterKey, error)
+	Key(version int) (*MasterKey, error)
+}
+
+// keySet is the versioned set of master keys shared by the providers
+type keySet struct {
+	current int
+	keys    map[int][]byte
+}
+
+func (s *keySet) currentKey() (*MasterKey, error) {
+	return s.key(s.current)
+}
+
+func (s *keySet) key(version int) (*MasterKey, error) {
+	key, ok := s.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return &MasterKey{Version: version, Key: key}, nil
+}
+
+// newKeySet validates the keys and picks the highest version as current when
+// current is zero
+func newKeySet(current int, keys map[int][]byte) (*keySet, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+
+	for version, key := range keys {
+		if version <= 0 {
+			return nil, fmt.Errorf("master key version must be positive, got %d", version)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("%w (version %d)", ErrInvalidKey, version)
+		}
+	}
+
+	if current == 0 {
+		for version := range keys {
+			if version > current {
+				current = version
+			}
+		}
+	}
+
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: current version %d", ErrUnknownVersion, current)
+	}
+
+	return &keySet{current: current, keys: keys}, nil
+}
+
+// StaticKeyProvider serves a fixed set of keys
+type StaticKeyProvider struct {
+	set *keySet
+}
+
+// NewStaticKeyProvider creates a provider from in-memory keys. A current
+// version of zero selects the highest version.
+func NewStaticKeyProvider(current int, keys map[int][]byte) (*StaticKeyProvider, error) {
+	set, err := newKeySet(current, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{set: set}, nil
+}
+
+// NewEphemeralKeyProvider generates a random master key that lives as long as
+// the process. It is only suitable when everything it protects is also kept in
+// memory.
+func NewEphemeralKeyProvider() (*StaticKeyProvider, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeyProvider(1, map[int][]byte{1: key})
+}
+
+func (p *StaticKeyProvider) CurrentKey() (*MasterKey, error) {
+	return p.set.currentKey()
+}
+
+func (p *StaticKeyProvider) Key(version int) (*MasterKey, error) {
+	return p.set.key(version)
+}
+
+// EnvKeyProvider reads base64 encoded master keys from environment variables
+// named <prefix>_V<version>, e.g. GATEWAY_MASTER_KEY_V1. The current version
+// is taken from <prefix>_VERSION, or the highest version present.
+type EnvKeyProvider struct {
+	set *keySet
+}
+
+// NewEnvKeyProvider loads the keys from the environment once. Rotating keys
+// means adding a new variable and restarting the process.
+func NewEnvKeyProvider(prefix string) (*EnvKeyProvider, error) {
+	keys := make(map[int][]byte)
+	versionPrefix := prefix + "_V"
+
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, versionPrefix) {
+			continue
+		}
+
+		version, err := strconv.Atoi(strings.TrimPrefix(name, versionPrefix))
+		if err != nil {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		keys[version] = key
+	}
+
+	current := 0
+	if value := os.Getenv(prefix + "_VERSION"); value != "" {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_VERSION: %w", prefix, err)
+		}
+		current = v
+	}
+
+	set, err := newKeySet(current, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvKeyProvider{set: set}, nil
+}
+
+func (p *EnvKeyProvider) CurrentKey() (*MasterKey, error) {
+	return p.set.currentKey()
+}
+
+func (p *EnvKeyProvider) Key(version int) (*MasterKey, error) {
+	return p.set.key(version)
+}
+
+// keyFile is the on-disk format read by FileKeyProvider
+type keyFile struct {
+	CurrentVersion int `json:"current_version"`
+	Keys           []struct {
+		Version int    `json:"version"`
+		Key     string `json:"key"` // base64
+	} `json:"keys"`
+}
+
+// FileKeyProvider reads master keys from a JSON file and reloads it when the
+// file changes, so a new key version can be rolled out without a restart.
+type FileKeyProvider struct {
+	path    string
+	set     *keySet
+	modTime time.Time
+	mu      sync.Mutex
+}
+
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	p := &FileKeyProvider{path: path}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *FileKeyProvider) CurrentKey() (*MasterKey, error) {
+	set, err := p.keys()
+	if err != nil {
+		return nil, err
+	}
+	return set.currentKey()
+}
+
+func (p *FileKeyProvider) Key(version int) (*MasterKey, error) {
+	set, err := p.keys()
+	if err != nil {
+		return nil, err
+	}
+	return set.key(version)
+}
+
+// keys returns the key set, reloading the file if it was modified. A file
+// that fails to load keeps the previous keys in service.
+func (p *FileKeyProvider) keys() (*keySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err == nil && !info.ModTime().Equal(p.modTime) {
+		if err := p.reloadLocked(); err != nil && p.set == nil {
+			return nil, err
+		}
+	}
+
+	return p.set, nil
+}
+
+func (p *FileKeyProvider) reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reloadLocked()
+}
+
+func (p *FileKeyProvider) reloadLocked() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat key file: %w", err)
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse key file: %w", err)
+	}
+
+	keys := make(map[int][]byte, len(file.Keys))
+	for _, k := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return fmt.Errorf("failed to decode key version %d: %w", k.Version, err)
+		}
+		keys[k.Version] = key
+	}
+
+	set, err := newKeySet(file.CurrentVersion, keys)
+	if err != nil {
+		return err
+	}
+
+	p.set = set
+	p.modTime = info.ModTime()
+	return nil
+}
+
+// GenerateKey returns a random 32 byte key
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,119 @@
+package envelope
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStaticKeyProvider_Validation(t *testing.T) {
+	key, err := GenerateKey()
+	require.NoError(t, err)
+
+	_, err = NewStaticKeyProvider(0, nil)
+	assert.Equal(t, ErrNoMasterKey, err)
+
+	_, err = NewStaticKeyProvider(0, map[int][]byte{1: []byte("short")})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewStaticKeyProvider(2, map[int][]byte{1: key})
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	_, err = NewStaticKeyProvider(0, map[int][]byte{0: key})
+	assert.Error(t, err)
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	key1, err := GenerateKey()
+	require.NoError(t, err)
+	key2, err := GenerateKey()
+	require.NoError(t, err)
+
+	t.Setenv("TEST_MASTER_KEY_V1", base64.StdEncoding.EncodeToString(key1))
+	t.Setenv("TEST_MASTER_KEY_V2", base64.StdEncoding.EncodeToString(key2))
+
+	provider, err := NewEnvKeyProvider("TEST_MASTER_KEY")
+	require.NoError(t, err)
+
+	current, err := provider.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, 2, current.Version) // Highest version by default
+	assert.Equal(t, key2, current.Key)
+
+	t.Setenv("TEST_MASTER_KEY_VERSION", "1")
+	provider, err = NewEnvKeyProvider("TEST_MASTER_KEY")
+	require.NoError(t, err)
+
+	current, err = provider.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current.Version)
+
+	old, err := provider.Key(2)
+	require.NoError(t, err)
+	assert.Equal(t, key2, old.Key)
+}
+
+func TestEnvKeyProvider_NoKeys(t *testing.T) {
+	_, err := NewEnvKeyProvider("TEST_MISSING_MASTER_KEY")
+	assert.Equal(t, ErrNoMasterKey, err)
+}
+
+func writeKeyFile(t *testing.T, path string, current int, keys map[int][]byte) {
+	entries := ""
+	for version, key := range keys {
+		if entries != "" {
+			entries += ","
+		}
+		entries += fmt.Sprintf(`{"version":%d,"key":%q}`, version, base64.StdEncoding.EncodeToString(key))
+	}
+
+	data := fmt.Sprintf(`{"current_version":%d,"keys":[%s]}`, current, entries)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+}
+
+func TestFileKeyProvider_ReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+
+	key1, err := GenerateKey()
+	require.NoError(t, err)
+	writeKeyFile(t, path, 1, map[int][]byte{1: key1})
+
+	provider, err := NewFileKeyProvider(path)
+	require.NoError(t, err)
+
+	current, err := provider.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, 1, current.Version)
+
+	// Roll out version 2
+	key2, err := GenerateKey()
+	require.NoError(t, err)
+	writeKeyFile(t, path, 2, map[int][]byte{1: key1, 2: key2})
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+
+	current, err = provider.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, 2, current.Version)
+	assert.Equal(t, key2, current.Key)
+
+	// A broken file keeps the last good keys in service
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	later := future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	current, err = provider.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, 2, current.Version)
+}
+
+func TestFileKeyProvider_MissingFile(t *testing.T) {
+	_, err := NewFileKeyProvider(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package envelope
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Rewrapper is implemented by stores that hold sealed records. RewrapKeys
+// must re-wrap every record with the current master key and return how many
+// records were changed.
+type Rewrapper interface {
+	RewrapKeys(e *Envelope) (int, error)
+}
+
+// Rotator is the background job that moves records onto the current master
+// key version after a rotation
+type Rotator struct {
+	envelope *Envelope
+	stores   []Rewrapper
+	interval time.Duration
+}
+
+func NewRotator(e *Envelope, interval time.Duration, stores ...Rewrapper) *Rotator {
+	return &Rotator{
+		envelope: e,
+		stores:   stores,
+		interval: interval,
+	}
+}
+
+// RotateOnce re-wraps every store and returns the number of records changed
+func (r *Rotator) RotateOnce() (int, error) {
+	total := 0
+	for _, store := range r.stores {
+		n, err := store.RewrapKeys(r.envelope)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("failed to rewrap keys: %w", err)
+		}
+	}
+	return total, nil
+}
+
+// Run rotates on every tick until ctx is cancelled. Failures are retried on
+// the next tick since the old key versions stay readable in the meantime.
+func (r *Rotator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if n, err := r.RotateOnce(); err != nil {
+				slog.ErrorContext(ctx, "key rotation failed", "rewrapped", n, "error", err)
+			} else if n > 0 {
+				slog.InfoContext(ctx, "key rotation re-wrapped records", "rewrapped", n)
+			}
+		}
+	}
+}
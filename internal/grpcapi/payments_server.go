@@ -0,0 +1,297 @@
+// Package grpcapi serves the payments API over gRPC. It calls the same
+// payment service as the REST handlers and maps its errors to the status
+// codes matching theirs.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewaypb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type PaymentService interface {
+	ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	SubmitPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	GetPayment(ctx context.Context, id string) (*domain.Payment, error)
+	ListPayments(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error)
+}
+
+// Page sizes, as on the REST API
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// defaultWatchInterval is how often a watched payment is checked for a
+// change of status
+const defaultWatchInterval = 250 * time.Millisecond
+
+// retryDelay is how long callers turned away before reaching the bank are
+// told to wait, like the REST API's Retry-After
+const retryDelay = time.Second
+
+type PaymentsServer struct {
+	gatewaypb.UnimplementedPaymentsServer
+
+	paymentService PaymentService
+	watchInterval  time.Duration
+}
+
+// Option configures a PaymentsServer
+type Option func(*PaymentsServer)
+
+// WithWatchInterval sets how often WatchPayment checks the payment
+func WithWatchInterval(d time.Duration) Option {
+	return func(s *PaymentsServer) {
+		s.watchInterval = d
+	}
+}
+
+func NewPaymentsServer(paymentService PaymentService, opts ...Option) *PaymentsServer {
+	s := &PaymentsServer{
+		paymentService: paymentService,
+		watchInterval:  defaultWatchInterval,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *PaymentsServer) CreatePayment(ctx context.Context, req *gatewaypb.CreatePaymentRequest) (*gatewaypb.Payment, error) {
+	payment, err := toPostPaymentRequest(req).ToDomainPayment()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	process := s.paymentService.ProcessPayment
+	if req.GetRespondAsync() {
+		process = s.paymentService.SubmitPayment
+	}
+
+	processedPayment, err := process(ctx, payment)
+	if err != nil {
+		// Tokens are only resolved by the service, so some validation
+		// errors surface here rather than from ToDomainPayment
+		if domain.IsValidationError(err) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// These are turned away before reaching the bank, so they are safe
+		// to retry
+		if errors.Is(err, domain.ErrConcurrencyLimitExceeded) {
+			return nil, RetryableError(codes.ResourceExhausted, "Too many payments in progress, retry shortly", retryDelay)
+		}
+		if errors.Is(err, domain.ErrBankOverloaded) {
+			return nil, RetryableError(codes.Unavailable, "Bank is busy, retry shortly", retryDelay)
+		}
+		if errors.Is(err, domain.ErrPaymentQueueFull) {
+			return nil, RetryableError(codes.Unavailable, "Payment queue is full, retry shortly", retryDelay)
+		}
+
+		return nil, status.Error(codes.Unavailable, "Unable to process payment with bank")
+	}
+
+	return toPayment(processedPayment), nil
+}
+
+func (s *PaymentsServer) GetPayment(ctx context.Context, req *gatewaypb.GetPaymentRequest) (*gatewaypb.Payment, error) {
+	payment, err := s.getPayment(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return toPayment(payment), nil
+}
+
+func (s *PaymentsServer) ListPayments(ctx context.Context, req *gatewaypb.ListPaymentsRequest) (*gatewaypb.ListPaymentsResponse, error) {
+	query := domain.PaymentQuery{
+		StartingAfter: req.GetStartingAfter(),
+		Limit:         defaultPageSize,
+	}
+
+	if req.GetStatus() != gatewaypb.PaymentStatus_PAYMENT_STATUS_UNSPECIFIED {
+		paymentStatus, ok := fromPaymentStatus(req.GetStatus())
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "status must be Authorized, Declined, Rejected, Pending, Captured, Voided or Refunded")
+		}
+		query.Status = paymentStatus
+	}
+
+	if limit := req.GetLimit(); limit != 0 {
+		if limit < 1 || limit > maxPageSize {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+		}
+		query.Limit = int(limit)
+	}
+
+	page, err := s.paymentService.ListPayments(ctx, query)
+	if err != nil {
+		if domain.IsValidationError(err) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return nil, status.Error(codes.Internal, "Failed to list payments")
+	}
+
+	resp := &gatewaypb.ListPaymentsResponse{
+		Payments: make([]*gatewaypb.Payment, len(page.Payments)),
+		HasMore:  page.HasMore,
+	}
+	for i, payment := range page.Payments {
+		resp.Payments[i] = toPayment(payment)
+	}
+	if page.HasMore && len(page.Payments) > 0 {
+		resp.NextStartingAfter = page.Payments[len(page.Payments)-1].ID
+	}
+
+	return resp, nil
+}
+
+// WatchPayment sends the payment as it is, then again whenever its status
+// changes, until it has a final status or the caller goes away. Only
+// payments accepted with respond_async start out pending, so the stream of
+// any other payment ends after the first message.
+func (s *PaymentsServer) WatchPayment(req *gatewaypb.WatchPaymentRequest, stream gatewaypb.Payments_WatchPaymentServer) error {
+	ctx := stream.Context()
+
+	payment, err := s.getPayment(ctx, req.GetId())
+	if err != nil {
+		return err
+	}
+	if err := stream.Send(toPayment(payment)); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	for payment.Status == domain.StatusPending {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+
+		current, err := s.getPayment(ctx, req.GetId())
+		if err != nil {
+			return err
+		}
+		if current.Status == payment.Status {
+			continue
+		}
+
+		payment = current
+		if err := stream.Send(toPayment(payment)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *PaymentsServer) getPayment(ctx context.Context, id string) (*domain.Payment, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "Payment ID is required")
+	}
+
+	payment, err := s.paymentService.GetPayment(ctx, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			return nil, status.Error(codes.NotFound, "Payment not found")
+		}
+
+		return nil, status.Error(codes.Internal, "Failed to retrieve payment")
+	}
+
+	return payment, nil
+}
+
+// RetryableError is an error the caller may retry after delay, which is
+// sent in a RetryInfo detail as gRPC's equivalent of Retry-After
+func RetryableError(code codes.Code, message string, delay time.Duration) error {
+	st, err := status.New(code, message).WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(delay),
+	})
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
+
+// toPostPaymentRequest converts the request to the REST API's, so it goes
+// through exactly the same validation
+func toPostPaymentRequest(req *gatewaypb.CreatePaymentRequest) *models.PostPaymentRequest {
+	r := &models.PostPaymentRequest{
+		CardNumber:  req.GetCardNumber(),
+		ExpiryMonth: int(req.GetExpiryMonth()),
+		ExpiryYear:  int(req.GetExpiryYear()),
+		Currency:    req.GetCurrency(),
+		Amount:      int(req.GetAmount()),
+		CVV:         req.GetCvv(),
+		Initiator:   req.GetInitiator(),
+	}
+	if source := req.GetSource(); source != nil {
+		r.Source = &models.PaymentSource{Token: source.GetToken()}
+	}
+	if sc := req.GetStoredCredential(); sc != nil {
+		r.StoredCredential = &models.StoredCredentialRequest{
+			Usage:                        sc.GetUsage(),
+			Type:                         sc.GetType(),
+			PreviousNetworkTransactionID: sc.GetPreviousNetworkTransactionId(),
+		}
+	}
+	return r
+}
+
+func toPayment(payment *domain.Payment) *gatewaypb.Payment {
+	return &gatewaypb.Payment{
+		Id:                 payment.ID,
+		Status:             toPaymentStatus(payment.Status),
+		CardNumberLastFour: payment.Card.GetLastFourDigits(),
+		ExpiryMonth:        int32(payment.Card.ExpiryMonth),
+		ExpiryYear:         int32(payment.Card.ExpiryYear),
+		Currency:           payment.Currency,
+		Amount:             int64(payment.Amount),
+
+		Initiator:            string(payment.Initiator),
+		NetworkTransactionId: payment.NetworkTransactionID,
+		DeclineReason:        string(payment.DeclineReason),
+
+		RequestId:     payment.RequestID,
+		CorrelationId: payment.CorrelationID,
+	}
+}
+
+var paymentStatuses = map[domain.PaymentStatus]gatewaypb.PaymentStatus{
+	domain.StatusAuthorized: gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED,
+	domain.StatusDeclined:   gatewaypb.PaymentStatus_PAYMENT_STATUS_DECLINED,
+	domain.StatusRejected:   gatewaypb.PaymentStatus_PAYMENT_STATUS_REJECTED,
+	domain.StatusPending:    gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING,
+	domain.StatusCaptured:   gatewaypb.PaymentStatus_PAYMENT_STATUS_CAPTURED,
+	domain.StatusVoided:     gatewaypb.PaymentStatus_PAYMENT_STATUS_VOIDED,
+	domain.StatusRefunded:   gatewaypb.PaymentStatus_PAYMENT_STATUS_REFUNDED,
+}
+
+func toPaymentStatus(s domain.PaymentStatus) gatewaypb.PaymentStatus {
+	return paymentStatuses[s]
+}
+
+func fromPaymentStatus(s gatewaypb.PaymentStatus) (domain.PaymentStatus, bool) {
+	for domainStatus, pbStatus := range paymentStatuses {
+		if pbStatus == s {
+			return domainStatus, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,305 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewaypb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type MockPaymentService struct {
+	mock.Mock
+}
+
+func (m *MockPaymentService) ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	args := m.Called(payment)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) SubmitPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	args := m.Called(payment)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, id string) (*domain.Payment, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) ListPayments(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PaymentPage), args.Error(1)
+}
+
+// watchStream collects the payments WatchPayment sends
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *gatewaypb.Payment
+}
+
+func (s *watchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *watchStream) Send(p *gatewaypb.Payment) error {
+	s.sent <- p
+	return nil
+}
+
+func createRequest() *gatewaypb.CreatePaymentRequest {
+	return &gatewaypb.CreatePaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  int32(time.Now().Year() + 1),
+		Currency:    "GBP",
+		Amount:      100,
+		Cvv:         "123",
+	}
+}
+
+func storedPayment(id string, paymentStatus domain.PaymentStatus) *domain.Payment {
+	return &domain.Payment{
+		ID:       id,
+		Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 12, ExpiryYear: 2030},
+		Currency: "GBP",
+		Amount:   100,
+		Status:   paymentStatus,
+	}
+}
+
+func TestCreatePayment_Success(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ProcessPayment", mock.MatchedBy(func(p *domain.Payment) bool {
+		return p.Card.Number == "2222405343248877" && p.Amount == 100 && p.Currency == "GBP"
+	})).Return(storedPayment("payment-1", domain.StatusAuthorized), nil)
+
+	resp, err := NewPaymentsServer(mockService).CreatePayment(context.Background(), createRequest())
+
+	require.NoError(t, err)
+	assert.Equal(t, "payment-1", resp.GetId())
+	assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED, resp.GetStatus())
+	assert.Equal(t, "8877", resp.GetCardNumberLastFour())
+	mockService.AssertExpectations(t)
+}
+
+func TestCreatePayment_RespondAsync(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("SubmitPayment", mock.Anything).Return(storedPayment("payment-1", domain.StatusPending), nil)
+
+	req := createRequest()
+	req.RespondAsync = true
+	resp, err := NewPaymentsServer(mockService).CreatePayment(context.Background(), req)
+
+	require.NoError(t, err)
+	assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING, resp.GetStatus())
+	mockService.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+}
+
+func TestCreatePayment_ValidationError(t *testing.T) {
+	mockService := new(MockPaymentService)
+
+	req := createRequest()
+	req.CardNumber = "1234"
+	_, err := NewPaymentsServer(mockService).CreatePayment(context.Background(), req)
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, domain.ErrCardNumberInvalid.Error(), status.Convert(err).Message())
+	mockService.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+}
+
+func TestCreatePayment_ServiceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      codes.Code
+		message   string
+		retryable bool
+	}{
+		{"unknown token", domain.ErrTokenNotFound, codes.InvalidArgument, domain.ErrTokenNotFound.Error(), false},
+		{"merchant over its bank calls", domain.ErrConcurrencyLimitExceeded, codes.ResourceExhausted, "Too many payments in progress, retry shortly", true},
+		{"bank overloaded", domain.ErrBankOverloaded, codes.Unavailable, "Bank is busy, retry shortly", true},
+		{"queue full", domain.ErrPaymentQueueFull, codes.Unavailable, "Payment queue is full, retry shortly", true},
+		{"bank error", fmt.Errorf("failed to process payment with bank: %w", errors.New("connection refused")), codes.Unavailable, "Unable to process payment with bank", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			mockService.On("ProcessPayment", mock.Anything).Return(nil, tt.err)
+
+			_, err := NewPaymentsServer(mockService).CreatePayment(context.Background(), createRequest())
+
+			st := status.Convert(err)
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, tt.message, st.Message())
+
+			var retryInfo *errdetails.RetryInfo
+			for _, detail := range st.Details() {
+				if info, ok := detail.(*errdetails.RetryInfo); ok {
+					retryInfo = info
+				}
+			}
+			if tt.retryable {
+				require.NotNil(t, retryInfo)
+				assert.Equal(t, time.Second, retryInfo.GetRetryDelay().AsDuration())
+			} else {
+				assert.Nil(t, retryInfo)
+			}
+		})
+	}
+}
+
+func TestGetPayment(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("GetPayment", "payment-1").Return(storedPayment("payment-1", domain.StatusDeclined), nil)
+	mockService.On("GetPayment", "missing").Return(nil, domain.ErrPaymentNotFound)
+	mockService.On("GetPayment", "broken").Return(nil, errors.New("storage unavailable"))
+	server := NewPaymentsServer(mockService)
+	ctx := context.Background()
+
+	resp, err := server.GetPayment(ctx, &gatewaypb.GetPaymentRequest{Id: "payment-1"})
+	require.NoError(t, err)
+	assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_DECLINED, resp.GetStatus())
+
+	_, err = server.GetPayment(ctx, &gatewaypb.GetPaymentRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.GetPayment(ctx, &gatewaypb.GetPaymentRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.GetPayment(ctx, &gatewaypb.GetPaymentRequest{Id: "broken"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, "Failed to retrieve payment", status.Convert(err).Message())
+}
+
+func TestListPayments(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ListPayments", domain.PaymentQuery{Status: domain.StatusPending, StartingAfter: "payment-9", Limit: 2}).
+		Return(&domain.PaymentPage{
+			Payments: []*domain.Payment{storedPayment("payment-8", domain.StatusPending), storedPayment("payment-7", domain.StatusPending)},
+			HasMore:  true,
+		}, nil)
+	mockService.On("ListPayments", domain.PaymentQuery{Limit: defaultPageSize}).
+		Return(&domain.PaymentPage{}, nil)
+	server := NewPaymentsServer(mockService)
+	ctx := context.Background()
+
+	resp, err := server.ListPayments(ctx, &gatewaypb.ListPaymentsRequest{
+		Status:        gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING,
+		Limit:         2,
+		StartingAfter: "payment-9",
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.GetPayments(), 2)
+	assert.True(t, resp.GetHasMore())
+	assert.Equal(t, "payment-7", resp.GetNextStartingAfter())
+
+	resp, err = server.ListPayments(ctx, &gatewaypb.ListPaymentsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, resp.GetPayments())
+	assert.Empty(t, resp.GetNextStartingAfter())
+
+	for _, req := range []*gatewaypb.ListPaymentsRequest{
+		{Limit: -1},
+		{Limit: maxPageSize + 1},
+		{Status: gatewaypb.PaymentStatus(42)},
+	} {
+		_, err := server.ListPayments(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", req)
+	}
+}
+
+func TestListPayments_Errors(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ListPayments", domain.PaymentQuery{StartingAfter: "unknown", Limit: defaultPageSize}).
+		Return(nil, domain.ErrPaymentCursorInvalid)
+	mockService.On("ListPayments", domain.PaymentQuery{Limit: defaultPageSize}).
+		Return(nil, errors.New("storage unavailable"))
+	server := NewPaymentsServer(mockService)
+
+	_, err := server.ListPayments(context.Background(), &gatewaypb.ListPaymentsRequest{StartingAfter: "unknown"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.ListPayments(context.Background(), &gatewaypb.ListPaymentsRequest{})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestWatchPayment_UntilFinal(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("GetPayment", "payment-1").Return(storedPayment("payment-1", domain.StatusPending), nil).Times(3)
+	mockService.On("GetPayment", "payment-1").Return(storedPayment("payment-1", domain.StatusAuthorized), nil).Once()
+
+	stream := &watchStream{ctx: context.Background(), sent: make(chan *gatewaypb.Payment, 10)}
+	err := NewPaymentsServer(mockService, WithWatchInterval(time.Millisecond)).
+		WatchPayment(&gatewaypb.WatchPaymentRequest{Id: "payment-1"}, stream)
+
+	require.NoError(t, err)
+	close(stream.sent)
+	var statuses []gatewaypb.PaymentStatus
+	for p := range stream.sent {
+		statuses = append(statuses, p.GetStatus())
+	}
+	assert.Equal(t, []gatewaypb.PaymentStatus{
+		gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING,
+		gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED,
+	}, statuses, "unchanged payments are not sent again")
+	mockService.AssertExpectations(t)
+}
+
+func TestWatchPayment_CallerGoesAway(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("GetPayment", "payment-1").Return(storedPayment("payment-1", domain.StatusPending), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchStream{ctx: ctx, sent: make(chan *gatewaypb.Payment, 10)}
+	done := make(chan error, 1)
+	go func() {
+		done <- NewPaymentsServer(mockService, WithWatchInterval(time.Millisecond)).
+			WatchPayment(&gatewaypb.WatchPaymentRequest{Id: "payment-1"}, stream)
+	}()
+
+	<-stream.sent
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not end when the caller went away")
+	}
+}
+
+func TestWatchPayment_NotFound(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("GetPayment", "missing").Return(nil, domain.ErrPaymentNotFound)
+
+	stream := &watchStream{ctx: context.Background(), sent: make(chan *gatewaypb.Payment, 1)}
+	err := NewPaymentsServer(mockService).WatchPayment(&gatewaypb.WatchPaymentRequest{Id: "missing"}, stream)
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Empty(t, stream.sent)
+}
@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type BatchService interface {
+	Submit(ctx context.Context, entries []domain.BatchEntry) (*domain.PaymentBatch, error)
+	Get(ctx context.Context, id string) (*domain.PaymentBatch, error)
+	Cancel(ctx context.Context, id string) (*domain.PaymentBatch, error)
+}
+
+// Media types accepted for batch uploads and results
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// batchCSVColumns are the columns a CSV upload may have, in any order.
+// Nested request fields are flattened.
+var batchCSVColumns = map[string]func(req *models.PostPaymentRequest, value string) error{
+	"card_number": func(req *models.PostPaymentRequest, v string) error { req.CardNumber = v; return nil },
+	"expiry_month": func(req *models.PostPaymentRequest, v string) error {
+		return parseCSVInt("expiry_month", v, &req.ExpiryMonth)
+	},
+	"expiry_year": func(req *models.PostPaymentRequest, v string) error {
+		return parseCSVInt("expiry_year", v, &req.ExpiryYear)
+	},
+	"currency":  func(req *models.PostPaymentRequest, v string) error { req.Currency = v; return nil },
+	"amount":    func(req *models.PostPaymentRequest, v string) error { return parseCSVInt("amount", v, &req.Amount) },
+	"cvv":       func(req *models.PostPaymentRequest, v string) error { req.CVV = v; return nil },
+	"initiator": func(req *models.PostPaymentRequest, v string) error { req.Initiator = v; return nil },
+	"source_token": func(req *models.PostPaymentRequest, v string) error {
+		if v != "" {
+			req.Source = &models.PaymentSource{Token: v}
+		}
+		return nil
+	},
+	"stored_credential_usage": func(req *models.PostPaymentRequest, v string) error {
+		if v != "" {
+			storedCredential(req).Usage = v
+		}
+		return nil
+	},
+	"stored_credential_type": func(req *models.PostPaymentRequest, v string) error {
+		if v != "" {
+			storedCredential(req).Type = v
+		}
+		return nil
+	},
+	"previous_network_transaction_id": func(req *models.PostPaymentRequest, v string) error {
+		if v != "" {
+			storedCredential(req).PreviousNetworkTransactionID = v
+		}
+		return nil
+	},
+}
+
+// batchResultColumns is the header of CSV results
+var batchResultColumns = []string{"index", "status", "payment_id", "payment_status", "decline_reason", "error"}
+
+// invalidItemError is an upload item that could not be read as a payment
+// request
+type invalidItemError struct {
+	reason string
+}
+
+func (e *invalidItemError) Error() string {
+	return e.reason
+}
+
+// uploadError is an upload that cannot be read at all. It is reported for
+// the whole batch, with status.
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+type BatchesHandler struct {
+	batchService BatchService
+}
+
+func NewBatchesHandler(batchService BatchService) *BatchesHandler {
+	return &BatchesHandler{
+		batchService: batchService,
+	}
+}
+
+func (h *BatchesHandler) PostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		entries, err := readBatchUpload(r)
+		if err != nil {
+			var upload *uploadError
+			if errors.As(err, &upload) {
+				respondWithError(w, upload.status, upload.message)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		batch, err := h.batchService.Submit(r.Context(), entries)
+		if err != nil {
+			respondWithBatchError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", "/api/payment-batches/"+batch.ID)
+		respondWithJSON(w, http.StatusAccepted, models.FromDomainPaymentBatch(batch))
+	}
+}
+
+func (h *BatchesHandler) GetHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		batch, err := h.batchService.Get(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithBatchError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainPaymentBatch(batch))
+	}
+}
+
+func (h *BatchesHandler) CancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		batch, err := h.batchService.Cancel(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithBatchError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainPaymentBatch(batch))
+	}
+}
+
+// ResultsHandler writes one line per item, as NDJSON unless CSV is asked
+// for with ?format=csv or an Accept header
+func (h *BatchesHandler) ResultsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		format := r.URL.Query().Get("format")
+		switch format {
+		case "":
+			format = "ndjson"
+			if strings.Contains(r.Header.Get("Accept"), contentTypeCSV) {
+				format = "csv"
+			}
+		case "ndjson", "csv":
+		default:
+			respondWithError(w, http.StatusBadRequest, "Format must be ndjson or csv")
+			return
+		}
+
+		batch, err := h.batchService.Get(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithBatchError(w, err)
+			return
+		}
+
+		if format == "csv" {
+			writeBatchResultsCSV(w, batch)
+			return
+		}
+		writeBatchResultsNDJSON(w, batch)
+	}
+}
+
+func writeBatchResultsNDJSON(w http.ResponseWriter, batch *domain.PaymentBatch) {
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payment-batch-%s.ndjson"`, batch.ID))
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	for _, item := range batch.Items {
+		if err := encoder.Encode(models.FromDomainBatchItem(item, batchItemError(item.Err))); err != nil {
+			return
+		}
+	}
+}
+
+func writeBatchResultsCSV(w http.ResponseWriter, batch *domain.PaymentBatch) {
+	w.Header().Set("Content-Type", contentTypeCSV)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="payment-batch-%s.csv"`, batch.ID))
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	writer.Write(batchResultColumns)
+	for _, item := range batch.Items {
+		result := models.FromDomainBatchItem(item, batchItemError(item.Err))
+		writer.Write([]string{
+			strconv.Itoa(result.Index),
+			result.Status,
+			result.PaymentID,
+			result.PaymentStatus,
+			result.DeclineReason,
+			result.Error,
+		})
+	}
+	writer.Flush()
+}
+
+// readBatchUpload reads the payment requests in the body in the format its
+// Content-Type names, JSON if it names none. Items that are not valid
+// payments become entries with an error rather than failing the upload.
+func readBatchUpload(r *http.Request) ([]domain.BatchEntry, error) {
+	mediaType := contentTypeJSON
+	if header := r.Header.Get("Content-Type"); header != "" {
+		parsed, _, err := mime.ParseMediaType(header)
+		if err != nil {
+			return nil, &uploadError{http.StatusUnsupportedMediaType, "Content-Type must be application/json, application/x-ndjson or text/csv"}
+		}
+		mediaType = parsed
+	}
+
+	var (
+		entries []domain.BatchEntry
+		err     error
+	)
+	switch mediaType {
+	case contentTypeJSON:
+		entries, err = readBatchJSON(r.Body)
+	case contentTypeNDJSON, "application/ndjson":
+		entries, err = readBatchNDJSON(r.Body)
+	case contentTypeCSV:
+		entries, err = readBatchCSV(r.Body)
+	default:
+		return nil, &uploadError{http.StatusUnsupportedMediaType, "Content-Type must be application/json, application/x-ndjson or text/csv"}
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, &uploadError{http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body must not be larger than %d bytes", tooLarge.Limit)}
+	}
+	return entries, err
+}
+
+func readBatchJSON(body io.Reader) ([]domain.BatchEntry, error) {
+	var items []json.RawMessage
+	decoder := json.NewDecoder(body)
+	if err := decoder.Decode(&items); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, &uploadError{http.StatusBadRequest, "Invalid request body: expected a JSON array of payment requests"}
+	}
+	if decoder.More() {
+		return nil, &uploadError{http.StatusBadRequest, "Invalid request body: unexpected data after the JSON array"}
+	}
+
+	entries := make([]domain.BatchEntry, len(items))
+	for i, item := range items {
+		entries[i] = batchEntryFromJSON(item)
+	}
+	return entries, nil
+}
+
+// readBatchNDJSON reads one payment request per line, skipping blank lines
+func readBatchNDJSON(body io.Reader) ([]domain.BatchEntry, error) {
+	var entries []domain.BatchEntry
+
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			entries = append(entries, batchEntryFromJSON(line))
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func batchEntryFromJSON(data []byte) domain.BatchEntry {
+	var req models.PostPaymentRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&req); err != nil {
+		reason := "invalid payment request"
+		if strings.HasPrefix(err.Error(), "json: unknown field ") {
+			reason += ": unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+		}
+		return domain.BatchEntry{Err: &invalidItemError{reason}}
+	}
+
+	return batchEntry(&req)
+}
+
+// readBatchCSV reads a header row naming batchCSVColumns followed by one
+// payment request per row. Empty cells are left unset.
+func readBatchCSV(body io.Reader) ([]domain.BatchEntry, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, csvUploadError(err)
+	}
+
+	setters := make([]func(*models.PostPaymentRequest, string) error, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		setter, ok := batchCSVColumns[column]
+		if !ok {
+			return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Invalid CSV: unknown column %q", column)}
+		}
+		setters[i] = setter
+	}
+
+	var entries []domain.BatchEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, csvUploadError(err)
+		}
+
+		var req models.PostPaymentRequest
+		var invalid error
+		for i, value := range record {
+			if err := setters[i](&req, strings.TrimSpace(value)); err != nil && invalid == nil {
+				invalid = err
+			}
+		}
+		if invalid != nil {
+			entries = append(entries, domain.BatchEntry{Err: invalid})
+			continue
+		}
+		entries = append(entries, batchEntry(&req))
+	}
+}
+
+func csvUploadError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &uploadError{http.StatusBadRequest, fmt.Sprintf("Invalid CSV on line %d: %v", parseErr.Line, parseErr.Err)}
+	}
+	return err
+}
+
+func parseCSVInt(column, value string, dst *int) error {
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return &invalidItemError{column + " must be a whole number"}
+	}
+	*dst = n
+	return nil
+}
+
+func storedCredential(req *models.PostPaymentRequest) *models.StoredCredentialRequest {
+	if req.StoredCredential == nil {
+		req.StoredCredential = &models.StoredCredentialRequest{}
+	}
+	return req.StoredCredential
+}
+
+func batchEntry(req *models.PostPaymentRequest) domain.BatchEntry {
+	payment, err := req.ToDomainPayment()
+	if err != nil {
+		return domain.BatchEntry{Err: err}
+	}
+	return domain.BatchEntry{Payment: payment}
+}
+
+// batchItemError is what the merchant is told about a failed item. Like a
+// single payment, the reason is only given when it was the merchant's input.
+func batchItemError(err error) string {
+	var invalid *invalidItemError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &invalid), domain.IsValidationError(err):
+		return err.Error()
+	case errors.Is(err, domain.ErrConcurrencyLimitExceeded):
+		return "Too many payments in progress"
+	case errors.Is(err, domain.ErrBankOverloaded):
+		return "Bank is busy"
+	default:
+		return "Unable to process payment with bank"
+	}
+}
+
+func respondWithBatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrBatchNotFound):
+		respondWithError(w, http.StatusNotFound, "Payment batch not found")
+	case errors.Is(err, domain.ErrBatchFinished):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrBatchTooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case domain.IsValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to process payment batch")
+	}
+}
@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBatchService struct {
+	mock.Mock
+}
+
+func (m *MockBatchService) Submit(ctx context.Context, entries []domain.BatchEntry) (*domain.PaymentBatch, error) {
+	return m.batch(m.Called(entries))
+}
+
+func (m *MockBatchService) Get(ctx context.Context, id string) (*domain.PaymentBatch, error) {
+	return m.batch(m.Called(id))
+}
+
+func (m *MockBatchService) Cancel(ctx context.Context, id string) (*domain.PaymentBatch, error) {
+	return m.batch(m.Called(id))
+}
+
+func (m *MockBatchService) batch(args mock.Arguments) (*domain.PaymentBatch, error) {
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PaymentBatch), args.Error(1)
+}
+
+func newBatchesRouter(h *BatchesHandler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/api/payment-batches", h.PostHandler())
+	r.Get("/api/payment-batches/{id}", h.GetHandler())
+	r.Get("/api/payment-batches/{id}/results", h.ResultsHandler())
+	r.Post("/api/payment-batches/{id}/cancel", h.CancelHandler())
+	return r
+}
+
+func testBatch() *domain.PaymentBatch {
+	return &domain.PaymentBatch{
+		ID:     "batch-1",
+		Status: domain.BatchCompleted,
+		Items: []domain.BatchItem{
+			{Index: 0, Status: domain.BatchItemSucceeded, PaymentID: "pay-1", PaymentStatus: domain.StatusAuthorized},
+			{Index: 1, Status: domain.BatchItemFailed, Err: domain.ErrAmountInvalid},
+			{Index: 2, Status: domain.BatchItemFailed, Err: &invalidItemError{"amount must be a whole number"}},
+			{Index: 3, Status: domain.BatchItemSucceeded, PaymentID: "pay-2", PaymentStatus: domain.StatusDeclined, DeclineReason: domain.DeclineDoNotHonour},
+			{Index: 4, Status: domain.BatchItemFailed, Err: domain.ErrBankOverloaded},
+		},
+		CreatedAt:   time.Date(2026, time.October, 1, 10, 0, 0, 0, time.UTC),
+		CompletedAt: time.Date(2026, time.October, 1, 10, 5, 0, 0, time.UTC),
+	}
+}
+
+func TestBatchesPostHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{
+			name:        "JSON array",
+			contentType: "application/json",
+			body: `[
+				{"card_number":"2222405343248877","expiry_month":4,"expiry_year":2030,"currency":"GBP","amount":100,"cvv":"123"},
+				{"card_number":"2222405343248877","expiry_month":4,"expiry_year":2030,"currency":"GBP","amount":0,"cvv":"123"},
+				{"card_number":"2222405343248877","colour":"blue"}
+			]`,
+		},
+		{
+			name:        "NDJSON",
+			contentType: "application/x-ndjson",
+			body: `{"card_number":"2222405343248877","expiry_month":4,"expiry_year":2030,"currency":"GBP","amount":100,"cvv":"123"}
+{"card_number":"2222405343248877","expiry_month":4,"expiry_year":2030,"currency":"GBP","amount":0,"cvv":"123"}
+
+{"card_number":"2222405343248877","colour":"blue"}`,
+		},
+		{
+			name:        "CSV",
+			contentType: "text/csv; charset=utf-8",
+			body: "card_number,expiry_month,expiry_year,currency,amount,cvv\n" +
+				"2222405343248877,4,2030,GBP,100,123\n" +
+				"2222405343248877,4,2030,GBP,0,123\n" +
+				"2222405343248877,4,2030,GBP,ten,123\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []domain.BatchEntry
+			mockService := new(MockBatchService)
+			mockService.On("Submit", mock.Anything).
+				Run(func(args mock.Arguments) { entries = args.Get(0).([]domain.BatchEntry) }).
+				Return(&domain.PaymentBatch{ID: "batch-1", Status: domain.BatchProcessing}, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusAccepted, w.Code)
+			assert.Equal(t, "/api/payment-batches/batch-1", w.Header().Get("Location"))
+
+			require.Len(t, entries, 3)
+			require.NoError(t, entries[0].Err)
+			assert.Equal(t, 100, entries[0].Payment.Amount)
+			assert.Equal(t, "2222405343248877", entries[0].Payment.Card.Number)
+			assert.ErrorIs(t, entries[1].Err, domain.ErrAmountInvalid)
+			assert.Error(t, entries[2].Err)
+			assert.Nil(t, entries[2].Payment)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBatchesPostHandler_CSVNestedFields(t *testing.T) {
+	var entries []domain.BatchEntry
+	mockService := new(MockBatchService)
+	mockService.On("Submit", mock.Anything).
+		Run(func(args mock.Arguments) { entries = args.Get(0).([]domain.BatchEntry) }).
+		Return(&domain.PaymentBatch{ID: "batch-1"}, nil)
+
+	body := "source_token,Currency,amount,initiator,stored_credential_usage,stored_credential_type,previous_network_transaction_id\n" +
+		"tok_abc,GBP,999,merchant,subsequent,recurring,ntid-1\n"
+	req := httptest.NewRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+
+	newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Len(t, entries, 1)
+	require.NoError(t, entries[0].Err)
+	payment := entries[0].Payment
+	assert.Equal(t, "tok_abc", payment.Card.Token)
+	assert.Equal(t, domain.InitiatorMerchant, payment.Initiator)
+	require.NotNil(t, payment.StoredCredential)
+	assert.Equal(t, "ntid-1", payment.StoredCredential.PreviousNetworkTransactionID)
+}
+
+func TestBatchesPostHandler_UnreadableUpload(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64
+		expectCode  int
+	}{
+		{name: "unsupported type", contentType: "application/xml", body: "<payments/>", expectCode: http.StatusUnsupportedMediaType},
+		{name: "not an array", contentType: "application/json", body: `{"amount":100}`, expectCode: http.StatusBadRequest},
+		{name: "unknown CSV column", contentType: "text/csv", body: "card_number,colour\n1,blue\n", expectCode: http.StatusBadRequest},
+		{name: "ragged CSV", contentType: "text/csv", body: "amount,currency\n100\n", expectCode: http.StatusBadRequest},
+		{name: "over the body limit", contentType: "application/x-ndjson", body: `{"amount":100}` + "\n" + `{"amount":200}`, limit: 16, expectCode: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockBatchService)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			if tt.limit > 0 {
+				req.Body = http.MaxBytesReader(w, req.Body, tt.limit)
+			}
+
+			newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectCode, w.Code)
+			mockService.AssertNotCalled(t, "Submit", mock.Anything)
+		})
+	}
+}
+
+func TestBatchesGetHandler(t *testing.T) {
+	mockService := new(MockBatchService)
+	mockService.On("Get", "batch-1").Return(testBatch(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/payment-batches/batch-1", nil)
+	w := httptest.NewRecorder()
+
+	newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.PaymentBatchResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "completed", response.Status)
+	assert.Equal(t, models.PaymentBatchCounts{Total: 5, Authorized: 1, Declined: 1, Failed: 3}, response.Counts)
+	require.NotNil(t, response.CompletedAt)
+}
+
+func TestBatchesResultsHandler(t *testing.T) {
+	t.Run("NDJSON", func(t *testing.T) {
+		mockService := new(MockBatchService)
+		mockService.On("Get", "batch-1").Return(testBatch(), nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/payment-batches/batch-1/results", nil)
+		w := httptest.NewRecorder()
+
+		newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		var results []models.PaymentBatchItemResponse
+		decoder := json.NewDecoder(w.Body)
+		for decoder.More() {
+			var result models.PaymentBatchItemResponse
+			require.NoError(t, decoder.Decode(&result))
+			results = append(results, result)
+		}
+		require.Len(t, results, 5)
+		assert.Equal(t, models.PaymentBatchItemResponse{Index: 0, Status: "succeeded", PaymentID: "pay-1", PaymentStatus: "Authorized"}, results[0])
+		assert.Equal(t, "amount must be a positive integer", results[1].Error)
+		assert.Equal(t, "amount must be a whole number", results[2].Error)
+		assert.Equal(t, "do_not_honour", results[3].DeclineReason)
+		assert.Equal(t, "Bank is busy", results[4].Error)
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		mockService := new(MockBatchService)
+		mockService.On("Get", "batch-1").Return(testBatch(), nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/payment-batches/batch-1/results", nil)
+		req.Header.Set("Accept", "text/csv")
+		w := httptest.NewRecorder()
+
+		newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		assert.Equal(t, "index,status,payment_id,payment_status,decline_reason,error\n"+
+			"0,succeeded,pay-1,Authorized,,\n"+
+			"1,failed,,,,amount must be a positive integer\n"+
+			"2,failed,,,,amount must be a whole number\n"+
+			"3,succeeded,pay-2,Declined,do_not_honour,\n"+
+			"4,failed,,,,Bank is busy\n", w.Body.String())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/payment-batches/batch-1/results?format=xml", nil)
+		w := httptest.NewRecorder()
+
+		newBatchesRouter(NewBatchesHandler(new(MockBatchService))).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestBatchesHandler_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		mockMethod string
+		mockArg    any
+		err        error
+		expectCode int
+	}{
+		{name: "empty upload", method: http.MethodPost, path: "/api/payment-batches", mockMethod: "Submit", mockArg: mock.Anything, err: domain.ErrBatchEmpty, expectCode: http.StatusBadRequest},
+		{name: "too many payments", method: http.MethodPost, path: "/api/payment-batches", mockMethod: "Submit", mockArg: mock.Anything, err: domain.ErrBatchTooLarge, expectCode: http.StatusRequestEntityTooLarge},
+		{name: "not found", method: http.MethodGet, path: "/api/payment-batches/batch-1", mockMethod: "Get", mockArg: "batch-1", err: domain.ErrBatchNotFound, expectCode: http.StatusNotFound},
+		{name: "already finished", method: http.MethodPost, path: "/api/payment-batches/batch-1/cancel", mockMethod: "Cancel", mockArg: "batch-1", err: domain.ErrBatchFinished, expectCode: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockBatchService)
+			mockService.On(tt.mockMethod, tt.mockArg).Return(nil, tt.err)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("[]"))
+			w := httptest.NewRecorder()
+
+			newBatchesRouter(NewBatchesHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectCode, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type Blocklist interface {
+	Block(ctx context.Context, card domain.Card, reason string) (*domain.BlockedCard, error)
+	List(ctx context.Context) ([]domain.BlockedCard, error)
+	Unblock(ctx context.Context, id string) error
+}
+
+type BlocklistHandler struct {
+	blocklist Blocklist
+}
+
+func NewBlocklistHandler(blocklist Blocklist) *BlocklistHandler {
+	return &BlocklistHandler{
+		blocklist: blocklist,
+	}
+}
+
+func (h *BlocklistHandler) PostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.PostBlockedCardRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		card, err := h.blocklist.Block(r.Context(), req.ToDomainCard(), req.Reason)
+		if err != nil {
+			respondWithBlocklistError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, models.FromDomainBlockedCard(card))
+	}
+}
+
+func (h *BlocklistHandler) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		cards, err := h.blocklist.List(r.Context())
+		if err != nil {
+			respondWithBlocklistError(w, err)
+			return
+		}
+
+		resp := make([]*models.BlockedCardResponse, len(cards))
+		for i := range cards {
+			resp[i] = models.FromDomainBlockedCard(&cards[i])
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (h *BlocklistHandler) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := h.blocklist.Unblock(r.Context(), chi.URLParam(r, "id")); err != nil {
+			respondWithBlocklistError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func respondWithBlocklistError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrBlockedCardNotFound):
+		respondWithError(w, http.StatusNotFound, "Blocked card not found")
+	case domain.IsValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to process blocklist request")
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockBlocklist struct {
+	mock.Mock
+}
+
+func (m *MockBlocklist) Block(ctx context.Context, card domain.Card, reason string) (*domain.BlockedCard, error) {
+	args := m.Called(card, reason)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.BlockedCard), args.Error(1)
+}
+
+func (m *MockBlocklist) List(ctx context.Context) ([]domain.BlockedCard, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.BlockedCard), args.Error(1)
+}
+
+func (m *MockBlocklist) Unblock(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func TestBlocklistPostHandler(t *testing.T) {
+	blocked := &domain.BlockedCard{
+		ID:        "blocked-1",
+		LastFour:  "8877",
+		Reason:    "chargeback",
+		CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		card       domain.Card
+		result     *domain.BlockedCard
+		err        error
+		wantStatus int
+	}{
+		{
+			name:       "card number",
+			body:       `{"card_number":"2222405343248877","reason":"chargeback"}`,
+			card:       domain.Card{Number: "2222405343248877"},
+			result:     blocked,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "token",
+			body:       `{"source":{"token":"tok_abc"},"reason":"chargeback"}`,
+			card:       domain.Card{Token: "tok_abc"},
+			result:     blocked,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "invalid card",
+			body:       `{"card_number":"1234","reason":"chargeback"}`,
+			card:       domain.Card{Number: "1234"},
+			err:        domain.ErrCardNumberInvalid,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "blocklist fails",
+			body:       `{"card_number":"2222405343248877","reason":"chargeback"}`,
+			card:       domain.Card{Number: "2222405343248877"},
+			err:        errors.New("disk full"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBlocklist := new(MockBlocklist)
+			if tt.result != nil {
+				mockBlocklist.On("Block", tt.card, "chargeback").Return(tt.result, nil)
+			} else {
+				mockBlocklist.On("Block", tt.card, "chargeback").Return(nil, tt.err)
+			}
+
+			handler := NewBlocklistHandler(mockBlocklist)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/blocked-cards", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			handler.PostHandler()(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.NotContains(t, w.Body.String(), "2222405343248877")
+			if tt.result != nil {
+				var response models.BlockedCardResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+				assert.Equal(t, "blocked-1", response.ID)
+				assert.Equal(t, "8877", response.CardNumberLastFour)
+				assert.Equal(t, "chargeback", response.Reason)
+			}
+			mockBlocklist.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBlocklistListHandler(t *testing.T) {
+	mockBlocklist := new(MockBlocklist)
+	mockBlocklist.On("List").Return([]domain.BlockedCard{
+		{ID: "blocked-2", LastFour: "1111"},
+		{ID: "blocked-1", LastFour: "8877", Reason: "chargeback"},
+	}, nil)
+
+	handler := NewBlocklistHandler(mockBlocklist)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/blocked-cards", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListHandler()(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.BlockedCardResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response, 2)
+	assert.Equal(t, "blocked-2", response[0].ID)
+	assert.Equal(t, "1111", response[0].CardNumberLastFour)
+	assert.Equal(t, "chargeback", response[1].Reason)
+}
+
+func TestBlocklistDeleteHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "unblocked", err: nil, wantStatus: http.StatusNoContent},
+		{name: "not found", err: domain.ErrBlockedCardNotFound, wantStatus: http.StatusNotFound},
+		{name: "blocklist fails", err: errors.New("disk full"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBlocklist := new(MockBlocklist)
+			mockBlocklist.On("Unblock", "blocked-1").Return(tt.err)
+
+			handler := NewBlocklistHandler(mockBlocklist)
+
+			r := chi.NewRouter()
+			r.Delete("/api/blocked-cards/{id}", handler.DeleteHandler())
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/blocked-cards/blocked-1", nil)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			mockBlocklist.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/health"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+)
+
+type HealthChecker interface {
+	Check(ctx context.Context) health.Report
+}
+
+type HealthHandler struct {
+	checker HealthChecker
+}
+
+func NewHealthHandler(checker HealthChecker) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+	}
+}
+
+// LiveHandler answers as long as the process can serve requests at all. It
+// checks no dependencies, so a failing bank never gets the gateway restarted.
+func (h *HealthHandler) LiveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, http.StatusOK, models.HealthResponse{Status: string(health.StatusUp)})
+	}
+}
+
+// ReadyHandler reports each dependency, with a 503 when any of them is down
+// or the gateway is draining
+func (h *HealthHandler) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := h.checker.Check(r.Context())
+
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+
+		// Probes must see the current state, not a cached one
+		w.Header().Set("Cache-Control", "no-store")
+		respondWithJSON(w, status, models.FromHealthReport(report))
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/health"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockHealthChecker struct {
+	mock.Mock
+}
+
+func (m *MockHealthChecker) Check(ctx context.Context) health.Report {
+	args := m.Called()
+	return args.Get(0).(health.Report)
+}
+
+func TestHealthHandler_Live(t *testing.T) {
+	checker := new(MockHealthChecker)
+	handler := NewHealthHandler(checker)
+
+	w := httptest.NewRecorder()
+	handler.LiveHandler()(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"up"}`, w.Body.String())
+	checker.AssertNotCalled(t, "Check")
+}
+
+func TestHealthHandler_Ready(t *testing.T) {
+	tests := []struct {
+		name           string
+		report         health.Report
+		expectedStatus int
+	}{
+		{
+			name: "all components up",
+			report: health.Report{Status: health.StatusUp, Components: []health.Component{
+				{Name: "bank", Status: health.StatusUp, Duration: 3 * time.Millisecond},
+			}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "component down",
+			report: health.Report{Status: health.StatusDown, Components: []health.Component{
+				{Name: "bank", Status: health.StatusDown, Error: "bank unreachable"},
+			}},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "draining",
+			report: health.Report{Status: health.StatusDraining, Components: []health.Component{
+				{Name: "bank", Status: health.StatusUp},
+			}},
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := new(MockHealthChecker)
+			checker.On("Check").Return(tt.report)
+			handler := NewHealthHandler(checker)
+
+			w := httptest.NewRecorder()
+			handler.ReadyHandler()(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+			var response models.HealthResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, string(tt.report.Status), response.Status)
+			component := tt.report.Components[0]
+			assert.Equal(t, models.ComponentHealth{
+				Status:     string(component.Status),
+				Error:      component.Error,
+				DurationMs: component.Duration.Milliseconds(),
+			}, response.Components[component.Name])
+		})
+	}
+}
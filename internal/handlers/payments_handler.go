@@ -1,9 +1,12 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
@@ -11,8 +14,14 @@ import (
 )
 
 type PaymentService interface {
-	ProcessPayment(payment *domain.Payment) (*domain.Payment, error)
-	GetPayment(id string) (*domain.Payment, error)
+	ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	SubmitPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+	GetPayment(ctx context.Context, id string) (*domain.Payment, error)
+	ListPayments(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error)
+	GetPaymentEvents(ctx context.Context, id string) ([]domain.PaymentEvent, error)
+	CapturePayment(ctx context.Context, id string, amount int) (*domain.Payment, error)
+	VoidPayment(ctx context.Context, id string) (*domain.Payment, error)
+	RefundPayment(ctx context.Context, id string, amount int) (*domain.Payment, error)
 }
 
 type PaymentsHandler struct {
@@ -29,27 +38,63 @@ func (h *PaymentsHandler) PostHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var req models.PostPaymentRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
 		payment, err := req.ToDomainPayment()
 		if err != nil {
-			h.respondWithError(w, http.StatusBadRequest, err.Error())
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		processedPayment, err := h.paymentService.ProcessPayment(payment)
+		respondAsync := prefersAsync(r)
+		process := h.paymentService.ProcessPayment
+		if respondAsync {
+			process = h.paymentService.SubmitPayment
+		}
+
+		processedPayment, err := process(r.Context(), payment)
 		if err != nil {
+			// Tokens are only resolved by the service, so some validation
+			// errors surface here rather than from ToDomainPayment
+			if domain.IsValidationError(err) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			// Both are turned away before reaching the bank, so they are
+			// safe to retry
+			if errors.Is(err, domain.ErrConcurrencyLimitExceeded) {
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusTooManyRequests, "Too many payments in progress, retry shortly")
+				return
+			}
+			if errors.Is(err, domain.ErrBankOverloaded) {
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusServiceUnavailable, "Bank is busy, retry shortly")
+				return
+			}
+			if errors.Is(err, domain.ErrPaymentQueueFull) {
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusServiceUnavailable, "Payment queue is full, retry shortly")
+				return
+			}
 
-			h.respondWithError(w, http.StatusBadGateway, "Unable to process payment with bank")
+			respondWithError(w, http.StatusBadGateway, "Unable to process payment with bank")
 			return
 		}
 
 		response := models.FromDomainPayment(processedPayment)
 
-		h.respondWithJSON(w, http.StatusOK, response)
+		if respondAsync {
+			// The outcome is fetched from the payment's URL or sent by webhook
+			w.Header().Set("Preference-Applied", "respond-async")
+			w.Header().Set("Location", "/api/payments/"+processedPayment.ID)
+			respondWithJSON(w, http.StatusAccepted, response)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, response)
 	}
 }
 
@@ -58,35 +103,175 @@ func (h *PaymentsHandler) GetHandler() http.HandlerFunc {
 
 		id := chi.URLParam(r, "id")
 		if id == "" {
-			h.respondWithError(w, http.StatusBadRequest, "Payment ID is required")
+			respondWithError(w, http.StatusBadRequest, "Payment ID is required")
 			return
 		}
 
-		payment, err := h.paymentService.GetPayment(id)
+		payment, err := h.paymentService.GetPayment(r.Context(), id)
 		if err != nil {
 			if errors.Is(err, domain.ErrPaymentNotFound) {
-				h.respondWithError(w, http.StatusNotFound, "Payment not found")
+				respondWithError(w, http.StatusNotFound, "Payment not found")
 				return
 			}
 
-			h.respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payment")
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payment")
 			return
 		}
 
 		response := models.ToGetPaymentResponse(payment)
 
-		h.respondWithJSON(w, http.StatusOK, response)
+		respondWithJSON(w, http.StatusOK, response)
+	}
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func (h *PaymentsHandler) ListHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		params := r.URL.Query()
+		query := domain.PaymentQuery{
+			Status:        domain.PaymentStatus(params.Get("status")),
+			StartingAfter: params.Get("starting_after"),
+			Limit:         defaultPageSize,
+		}
+
+		switch query.Status {
+		case "", domain.StatusAuthorized, domain.StatusDeclined, domain.StatusRejected, domain.StatusPending,
+			domain.StatusCaptured, domain.StatusVoided, domain.StatusRefunded:
+		default:
+			respondWithError(w, http.StatusBadRequest, "status must be Authorized, Declined, Rejected, Pending, Captured, Voided or Refunded")
+			return
+		}
+
+		if limit := params.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 || n > maxPageSize {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+				return
+			}
+			query.Limit = n
+		}
+
+		page, err := h.paymentService.ListPayments(r.Context(), query)
+		if err != nil {
+			if domain.IsValidationError(err) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			respondWithError(w, http.StatusInternalServerError, "Failed to list payments")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainPaymentPage(page))
+	}
+}
+
+func (h *PaymentsHandler) GetEventsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		id := chi.URLParam(r, "id")
+		if id == "" {
+			respondWithError(w, http.StatusBadRequest, "Payment ID is required")
+			return
+		}
+
+		events, err := h.paymentService.GetPaymentEvents(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, domain.ErrPaymentNotFound) {
+				respondWithError(w, http.StatusNotFound, "Payment not found")
+				return
+			}
+
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve payment events")
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainPaymentEvents(events))
 	}
 }
 
-func (h *PaymentsHandler) respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	if err := json.NewEncoder(w).Encode(payload); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+func (h *PaymentsHandler) CaptureHandler() http.HandlerFunc {
+	return h.settle(h.paymentService.CapturePayment)
+}
+
+func (h *PaymentsHandler) VoidHandler() http.HandlerFunc {
+	return h.settle(func(ctx context.Context, id string, _ int) (*domain.Payment, error) {
+		return h.paymentService.VoidPayment(ctx, id)
+	})
+}
+
+func (h *PaymentsHandler) RefundHandler() http.HandlerFunc {
+	return h.settle(h.paymentService.RefundPayment)
+}
+
+// settle serves a capture, void or refund of the payment in the path
+func (h *PaymentsHandler) settle(fn func(ctx context.Context, id string, amount int) (*domain.Payment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// The body is optional; without an amount all that is left is
+		// captured or refunded
+		var req models.SettlePaymentRequest
+		if r.ContentLength != 0 {
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+		}
+		if req.Amount < 0 {
+			respondWithError(w, http.StatusBadRequest, domain.ErrAmountInvalid.Error())
+			return
+		}
+
+		payment, err := fn(r.Context(), chi.URLParam(r, "id"), req.Amount)
+		if err != nil {
+			respondWithSettlementError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.ToGetPaymentResponse(payment))
 	}
 }
 
-func (h *PaymentsHandler) respondWithError(w http.ResponseWriter, statusCode int, message string) {
-	h.respondWithJSON(w, statusCode, models.ErrorResponse{Error: message})
+func respondWithSettlementError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrPaymentNotFound):
+		respondWithError(w, http.StatusNotFound, "Payment not found")
+	case domain.IsValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, domain.ErrPaymentNotCapturable),
+		errors.Is(err, domain.ErrPaymentNotVoidable),
+		errors.Is(err, domain.ErrPaymentNotRefundable),
+		errors.Is(err, domain.ErrPaymentSettling):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrSettlementUnsupported):
+		respondWithError(w, http.StatusNotImplemented, err.Error())
+	case errors.Is(err, domain.ErrConcurrencyLimitExceeded):
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusTooManyRequests, "Too many payments in progress, retry shortly")
+	case errors.Is(err, domain.ErrBankOverloaded):
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, "Bank is busy, retry shortly")
+	default:
+		respondWithError(w, http.StatusBadGateway, "Unable to settle payment with bank")
+	}
+}
+
+// prefersAsync reports whether the request carries Prefer: respond-async
+// (RFC 7240). Preferences are comma-separated and may have values or
+// parameters, which this one does not use.
+func prefersAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, _, _ := strings.Cut(preference, ";")
+			name, _, _ = strings.Cut(name, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
 }
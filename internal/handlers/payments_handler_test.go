@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,7 +24,7 @@ type MockPaymentService struct {
 	mock.Mock
 }
 
-func (m *MockPaymentService) ProcessPayment(payment *domain.Payment) (*domain.Payment, error) {
+func (m *MockPaymentService) ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
 	args := m.Called(payment)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -29,7 +32,47 @@ func (m *MockPaymentService) ProcessPayment(payment *domain.Payment) (*domain.Pa
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) GetPayment(id string) (*domain.Payment, error) {
+func (m *MockPaymentService) SubmitPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	args := m.Called(payment)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPayment(ctx context.Context, id string) (*domain.Payment, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) ListPayments(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PaymentPage), args.Error(1)
+}
+
+func (m *MockPaymentService) GetPaymentEvents(ctx context.Context, id string) ([]domain.PaymentEvent, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.PaymentEvent), args.Error(1)
+}
+
+func (m *MockPaymentService) CapturePayment(ctx context.Context, id string, amount int) (*domain.Payment, error) {
+	args := m.Called(id, amount)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) VoidPayment(ctx context.Context, id string) (*domain.Payment, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -37,6 +80,14 @@ func (m *MockPaymentService) GetPayment(id string) (*domain.Payment, error) {
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
+func (m *MockPaymentService) RefundPayment(ctx context.Context, id string, amount int) (*domain.Payment, error) {
+	args := m.Called(id, amount)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
 func TestPostHandler_Success(t *testing.T) {
 	mockService := new(MockPaymentService)
 	futureYear := time.Now().Year() + 1
@@ -134,6 +185,63 @@ func TestPostHandler_InvalidJSON(t *testing.T) {
 	assert.Equal(t, "Invalid request body", response.Error)
 }
 
+func TestPostHandler_RejectsMalformedBodies(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		maxBytes        int64
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{
+			name:            "unknown field",
+			body:            `{"card_number":"2222405343248877","amount":100,"amuont":100}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: `Invalid request body: unknown field "amuont"`,
+		},
+		{
+			name:            "unknown nested field",
+			body:            `{"source":{"token":"tok_abc","type":"card"}}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: `Invalid request body: unknown field "type"`,
+		},
+		{
+			name:            "trailing data",
+			body:            `{"amount":100}{"amount":200}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedMessage: "Invalid request body",
+		},
+		{
+			name:            "too large",
+			body:            `{"currency":"` + strings.Repeat("X", 100) + `"}`,
+			maxBytes:        64,
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedMessage: "Request body must not be larger than 64 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			handler := NewPaymentsHandler(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			if tt.maxBytes > 0 {
+				req.Body = http.MaxBytesReader(w, req.Body, tt.maxBytes)
+			}
+
+			handler.PostHandler()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var response models.ErrorResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, tt.expectedMessage, response.Error)
+			mockService.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+		})
+	}
+}
+
 func TestPostHandler_BankError(t *testing.T) {
 	mockService := new(MockPaymentService)
 	mockService.On("ProcessPayment", mock.AnythingOfType("*domain.Payment")).Return(nil, errors.New("bank communication error"))
@@ -166,6 +274,274 @@ func TestPostHandler_BankError(t *testing.T) {
 	mockService.AssertExpectations(t)
 }
 
+func TestPostHandler_ConcurrencyLimitExceeded(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ProcessPayment", mock.AnythingOfType("*domain.Payment")).
+		Return(nil, fmt.Errorf("failed to process payment with bank: %w", domain.ErrConcurrencyLimitExceeded))
+
+	handler := NewPaymentsHandler(mockService)
+
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	var response models.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "Too many payments in progress, retry shortly", response.Error)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestPostHandler_BankOverloaded(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ProcessPayment", mock.AnythingOfType("*domain.Payment")).
+		Return(nil, fmt.Errorf("failed to process payment with bank: %w", domain.ErrBankOverloaded))
+
+	handler := NewPaymentsHandler(mockService)
+
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body)))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	var response models.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "Bank is busy, retry shortly", response.Error)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestPostHandler_RespondAsync(t *testing.T) {
+	for _, prefer := range []string{"respond-async", "return=minimal, respond-async", "Respond-Async; wait=10"} {
+		t.Run(prefer, func(t *testing.T) {
+			mockService := new(MockPaymentService)
+			mockService.On("SubmitPayment", mock.AnythingOfType("*domain.Payment")).Return(&domain.Payment{
+				ID:       "payment-1",
+				Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 12, ExpiryYear: time.Now().Year() + 1},
+				Currency: "GBP",
+				Amount:   100,
+				Status:   domain.StatusPending,
+			}, nil)
+
+			handler := NewPaymentsHandler(mockService)
+
+			body, _ := json.Marshal(models.PostPaymentRequest{
+				CardNumber:  "2222405343248877",
+				ExpiryMonth: 12,
+				ExpiryYear:  time.Now().Year() + 1,
+				Currency:    "GBP",
+				Amount:      100,
+				CVV:         "123",
+			})
+			req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+			req.Header.Set("Prefer", prefer)
+			w := httptest.NewRecorder()
+
+			handler.PostHandler()(w, req)
+
+			assert.Equal(t, http.StatusAccepted, w.Code)
+			assert.Equal(t, "respond-async", w.Header().Get("Preference-Applied"))
+			assert.Equal(t, "/api/payments/payment-1", w.Header().Get("Location"))
+
+			var response models.PostPaymentResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, "Pending", response.Status)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPostHandler_QueueFull(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("SubmitPayment", mock.AnythingOfType("*domain.Payment")).Return(nil, domain.ErrPaymentQueueFull)
+
+	handler := NewPaymentsHandler(mockService)
+
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	mockService.AssertExpectations(t)
+}
+
+func TestPostHandler_Token(t *testing.T) {
+	mockService := new(MockPaymentService)
+	futureYear := time.Now().Year() + 1
+
+	mockService.On("ProcessPayment", mock.MatchedBy(func(p *domain.Payment) bool {
+		return p.Card.Token == "tok_abc" && p.Card.Number == "" && p.Card.CVV == "123"
+	})).Return(&domain.Payment{
+		ID: "generated-id-123",
+		Card: domain.Card{
+			Token:       "tok_abc",
+			LastFour:    "8877",
+			ExpiryMonth: 12,
+			ExpiryYear:  futureYear,
+		},
+		Currency: "GBP",
+		Amount:   100,
+		Status:   domain.StatusAuthorized,
+	}, nil)
+
+	handler := NewPaymentsHandler(mockService)
+
+	body := []byte(`{"source":{"token":"tok_abc"},"cvv":"123","currency":"GBP","amount":100}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.PostPaymentResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Authorized", response.Status)
+	assert.Equal(t, "8877", response.CardNumberLastFour)
+	assert.Equal(t, futureYear, response.ExpiryYear)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestPostHandler_MerchantInitiated(t *testing.T) {
+	mockService := new(MockPaymentService)
+	futureYear := time.Now().Year() + 1
+
+	mockService.On("ProcessPayment", mock.MatchedBy(func(p *domain.Payment) bool {
+		return p.IsMerchantInitiated() &&
+			p.Card.CVV == "" &&
+			p.StoredCredential.Usage == domain.StoredCredentialSubsequent &&
+			p.StoredCredential.Type == domain.StoredCredentialRecurring &&
+			p.StoredCredential.PreviousNetworkTransactionID == "ntid-1"
+	})).Return(&domain.Payment{
+		ID:                   "generated-id-123",
+		Card:                 domain.Card{Token: "tok_abc", LastFour: "8877", ExpiryMonth: 12, ExpiryYear: futureYear},
+		Currency:             "GBP",
+		Amount:               100,
+		Status:               domain.StatusAuthorized,
+		Initiator:            domain.InitiatorMerchant,
+		NetworkTransactionID: "ntid-2",
+	}, nil)
+
+	handler := NewPaymentsHandler(mockService)
+
+	body := []byte(`{
+		"source": {"token": "tok_abc"},
+		"currency": "GBP",
+		"amount": 100,
+		"initiator": "merchant",
+		"stored_credential": {"usage": "subsequent", "type": "recurring", "previous_network_transaction_id": "ntid-1"}
+	}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.PostPaymentResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, "ntid-2", response.NetworkTransactionID)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestPostHandler_MerchantInitiatedWithoutStoredCredential(t *testing.T) {
+	mockService := new(MockPaymentService)
+	handler := NewPaymentsHandler(mockService)
+
+	body := []byte(`{"source":{"token":"tok_abc"},"currency":"GBP","amount":100,"initiator":"merchant"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, domain.ErrStoredCredentialRequired.Error(), response.Error)
+
+	mockService.AssertNotCalled(t, "ProcessPayment")
+}
+
+func TestPostHandler_TokenAndCardDetails(t *testing.T) {
+	mockService := new(MockPaymentService)
+	handler := NewPaymentsHandler(mockService)
+
+	body := []byte(`{"source":{"token":"tok_abc"},"card_number":"2222405343248877","cvv":"123","currency":"GBP","amount":100}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, domain.ErrCardSourceConflict.Error(), response.Error)
+
+	mockService.AssertNotCalled(t, "ProcessPayment")
+}
+
+func TestPostHandler_UnknownToken(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ProcessPayment", mock.AnythingOfType("*domain.Payment")).Return(nil, domain.ErrTokenNotFound)
+
+	handler := NewPaymentsHandler(mockService)
+
+	body := []byte(`{"source":{"token":"tok_missing"},"cvv":"123","currency":"GBP","amount":100}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, "card token not found", response.Error)
+}
+
 func TestGetHandler_Success(t *testing.T) {
 	mockService := new(MockPaymentService)
 
@@ -254,3 +630,146 @@ func TestGetHandler_InternalError(t *testing.T) {
 
 	mockService.AssertExpectations(t)
 }
+
+func TestGetEventsHandler(t *testing.T) {
+	occurred := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	mockService := new(MockPaymentService)
+	mockService.On("GetPaymentEvents", "payment-1").Return([]domain.PaymentEvent{
+		{
+			ID:         "evt-1",
+			PaymentID:  "payment-1",
+			Sequence:   1,
+			Type:       domain.EventPaymentRequested,
+			OccurredAt: occurred,
+			Actor:      "api_client:203.0.113.7",
+			RequestID:  "req-1",
+			Data:       domain.PaymentEventData{CardLastFour: "8877", Currency: "GBP", Amount: 100},
+		},
+		{
+			ID:         "evt-2",
+			PaymentID:  "payment-1",
+			Sequence:   2,
+			Type:       domain.EventPaymentAuthorized,
+			OccurredAt: occurred,
+			Actor:      "api_client:203.0.113.7",
+			RequestID:  "req-1",
+			Data:       domain.PaymentEventData{Status: domain.StatusAuthorized},
+		},
+	}, nil)
+	mockService.On("GetPaymentEvents", "missing").Return(nil, domain.ErrPaymentNotFound)
+
+	r := chi.NewRouter()
+	r.Get("/api/payments/{id}/events", NewPaymentsHandler(mockService).GetEventsHandler())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/payments/payment-1/events", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.PaymentEventResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response, 2)
+	assert.Equal(t, "payment.requested", response[0].Type)
+	assert.Equal(t, "8877", response[0].Data.CardNumberLastFour)
+	assert.Equal(t, "req-1", response[0].RequestID)
+	assert.Equal(t, "Authorized", response[1].Data.Status)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/payments/missing/events", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestListHandler(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("ListPayments", domain.PaymentQuery{Limit: 20}).Return(&domain.PaymentPage{}, nil)
+	mockService.On("ListPayments", domain.PaymentQuery{Status: domain.StatusDeclined, StartingAfter: "payment-3", Limit: 2}).
+		Return(&domain.PaymentPage{
+			Payments: []*domain.Payment{
+				{ID: "payment-2", Status: domain.StatusDeclined, Card: domain.Card{LastFour: "8878"}},
+				{ID: "payment-1", Status: domain.StatusDeclined, Card: domain.Card{LastFour: "8878"}},
+			},
+			HasMore: true,
+		}, nil)
+	mockService.On("ListPayments", domain.PaymentQuery{StartingAfter: "missing", Limit: 20}).Return(nil, domain.ErrPaymentCursorInvalid)
+
+	r := chi.NewRouter()
+	r.Get("/api/payments", NewPaymentsHandler(mockService).ListHandler())
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/api/payments")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"data":[],"has_more":false}`, w.Body.String())
+
+	w = get("/api/payments?status=Declined&starting_after=payment-3&limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.PaymentListResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response.Data, 2)
+	assert.Equal(t, "payment-2", response.Data[0].ID)
+	assert.Equal(t, "8878", response.Data[0].CardNumberLastFour)
+	assert.True(t, response.HasMore)
+	assert.Equal(t, "payment-1", response.NextStartingAfter)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/payments?starting_after=missing").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/payments?status=Settled").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/payments?limit=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/payments?limit=101").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/payments?limit=ten").Code)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestSettleHandlers(t *testing.T) {
+	mockService := new(MockPaymentService)
+	mockService.On("CapturePayment", "payment-1", 0).Return(&domain.Payment{ID: "payment-1", Amount: 100, Status: domain.StatusCaptured, CapturedAmount: 100}, nil)
+	mockService.On("CapturePayment", "payment-2", 50).Return(nil, domain.ErrPaymentNotCapturable)
+	mockService.On("VoidPayment", "missing").Return(nil, domain.ErrPaymentNotFound)
+	mockService.On("VoidPayment", "payment-3").Return(nil, domain.ErrSettlementUnsupported)
+	mockService.On("RefundPayment", "payment-1", 40).Return(&domain.Payment{ID: "payment-1", Amount: 100, Status: domain.StatusRefunded, CapturedAmount: 100, RefundedAmount: 40}, nil)
+	mockService.On("RefundPayment", "payment-1", 500).Return(nil, domain.ErrRefundAmountInvalid)
+	mockService.On("RefundPayment", "payment-4", 0).Return(nil, errors.New("bank refused settlement"))
+
+	handler := NewPaymentsHandler(mockService)
+	r := chi.NewRouter()
+	r.Post("/api/payments/{id}/capture", handler.CaptureHandler())
+	r.Post("/api/payments/{id}/void", handler.VoidHandler())
+	r.Post("/api/payments/{id}/refund", handler.RefundHandler())
+
+	tests := []struct {
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"/api/payments/payment-1/capture", "", http.StatusOK},
+		{"/api/payments/payment-2/capture", `{"amount":50}`, http.StatusConflict},
+		{"/api/payments/payment-2/capture", `{"amount":-1}`, http.StatusBadRequest},
+		{"/api/payments/payment-2/capture", `{"amount":"all"}`, http.StatusBadRequest},
+		{"/api/payments/missing/void", "", http.StatusNotFound},
+		{"/api/payments/payment-3/void", "", http.StatusNotImplemented},
+		{"/api/payments/payment-1/refund", `{"amount":40}`, http.StatusOK},
+		{"/api/payments/payment-1/refund", `{"amount":500}`, http.StatusBadRequest},
+		{"/api/payments/payment-4/refund", "", http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.body, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/payments/payment-1/refund", strings.NewReader(`{"amount":40}`)))
+	var response models.GetPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "Refunded", response.Status)
+	assert.Equal(t, 100, response.CapturedAmount)
+	assert.Equal(t, 40, response.RefundedAmount)
+}
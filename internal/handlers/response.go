@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+)
+
+func respondWithJSON(w http.ResponseWriter, statusCode int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// respondWithError includes the request ID set on the response, so a
+// merchant reporting an error can quote it
+func respondWithError(w http.ResponseWriter, statusCode int, message string) {
+	respondWithJSON(w, statusCode, models.ErrorResponse{
+		Error:     message,
+		RequestID: w.Header().Get(audit.RequestIDHeader),
+	})
+}
+
+// decodeJSON reads the request body into dst, turning away fields the API
+// does not know and bodies over the size limit. On failure it writes the
+// error response itself and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after the JSON body")
+	}
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondWithError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body must not be larger than %d bytes", tooLarge.Limit))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		respondWithError(w, http.StatusBadRequest,
+			"Invalid request body: unknown field "+strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+	}
+	return false
+}
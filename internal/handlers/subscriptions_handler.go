@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type SubscriptionService interface {
+	CreatePlan(ctx context.Context, plan *domain.Plan) (*domain.Plan, error)
+	GetPlan(ctx context.Context, id string) (*domain.Plan, error)
+	Subscribe(ctx context.Context, planID, cardToken, cvv string) (*domain.Subscription, error)
+	GetSubscription(ctx context.Context, id string) (*domain.Subscription, error)
+	Pause(ctx context.Context, id string) (*domain.Subscription, error)
+	Resume(ctx context.Context, id string) (*domain.Subscription, error)
+	Cancel(ctx context.Context, id string, atPeriodEnd bool) (*domain.Subscription, error)
+	ChangePlan(ctx context.Context, id, planID string) (*domain.Subscription, error)
+}
+
+type SubscriptionsHandler struct {
+	subscriptionService SubscriptionService
+}
+
+func NewSubscriptionsHandler(subscriptionService SubscriptionService) *SubscriptionsHandler {
+	return &SubscriptionsHandler{
+		subscriptionService: subscriptionService,
+	}
+}
+
+func (h *SubscriptionsHandler) PostPlanHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.PostPlanRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		plan, err := h.subscriptionService.CreatePlan(r.Context(), req.ToDomainPlan())
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, models.FromDomainPlan(plan))
+	}
+}
+
+func (h *SubscriptionsHandler) GetPlanHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		plan, err := h.subscriptionService.GetPlan(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainPlan(plan))
+	}
+}
+
+func (h *SubscriptionsHandler) PostSubscriptionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.PostSubscriptionRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		token := ""
+		if req.Source != nil {
+			token = req.Source.Token
+		}
+
+		sub, err := h.subscriptionService.Subscribe(r.Context(), req.PlanID, token, req.CVV)
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, models.FromDomainSubscription(sub))
+	}
+}
+
+func (h *SubscriptionsHandler) GetSubscriptionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		sub, err := h.subscriptionService.GetSubscription(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainSubscription(sub))
+	}
+}
+
+func (h *SubscriptionsHandler) PauseHandler() http.HandlerFunc {
+	return h.transition(h.subscriptionService.Pause)
+}
+
+func (h *SubscriptionsHandler) ResumeHandler() http.HandlerFunc {
+	return h.transition(h.subscriptionService.Resume)
+}
+
+func (h *SubscriptionsHandler) CancelHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// The body is optional; an empty one cancels immediately
+		var req models.CancelSubscriptionRequest
+		if r.ContentLength != 0 {
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+		}
+
+		sub, err := h.subscriptionService.Cancel(r.Context(), chi.URLParam(r, "id"), req.AtPeriodEnd)
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainSubscription(sub))
+	}
+}
+
+func (h *SubscriptionsHandler) ChangePlanHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.ChangePlanRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		sub, err := h.subscriptionService.ChangePlan(r.Context(), chi.URLParam(r, "id"), req.PlanID)
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainSubscription(sub))
+	}
+}
+
+func (h *SubscriptionsHandler) transition(fn func(ctx context.Context, id string) (*domain.Subscription, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		sub, err := fn(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithSubscriptionError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainSubscription(sub))
+	}
+}
+
+func respondWithSubscriptionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrPlanNotFound):
+		respondWithError(w, http.StatusNotFound, "Plan not found")
+	case errors.Is(err, domain.ErrSubscriptionNotFound):
+		respondWithError(w, http.StatusNotFound, "Subscription not found")
+	case errors.Is(err, domain.ErrSubscriptionInvalidState):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, domain.ErrSubscriptionPaymentFailed):
+		respondWithError(w, http.StatusPaymentRequired, err.Error())
+	case domain.IsValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to process subscription")
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockSubscriptionService struct {
+	mock.Mock
+}
+
+func (m *MockSubscriptionService) CreatePlan(ctx context.Context, plan *domain.Plan) (*domain.Plan, error) {
+	args := m.Called(plan)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Plan), args.Error(1)
+}
+
+func (m *MockSubscriptionService) GetPlan(ctx context.Context, id string) (*domain.Plan, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Plan), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Subscribe(ctx context.Context, planID, cardToken, cvv string) (*domain.Subscription, error) {
+	return m.subscription(m.Called(planID, cardToken, cvv))
+}
+
+func (m *MockSubscriptionService) GetSubscription(ctx context.Context, id string) (*domain.Subscription, error) {
+	return m.subscription(m.Called(id))
+}
+
+func (m *MockSubscriptionService) Pause(ctx context.Context, id string) (*domain.Subscription, error) {
+	return m.subscription(m.Called(id))
+}
+
+func (m *MockSubscriptionService) Resume(ctx context.Context, id string) (*domain.Subscription, error) {
+	return m.subscription(m.Called(id))
+}
+
+func (m *MockSubscriptionService) Cancel(ctx context.Context, id string, atPeriodEnd bool) (*domain.Subscription, error) {
+	return m.subscription(m.Called(id, atPeriodEnd))
+}
+
+func (m *MockSubscriptionService) ChangePlan(ctx context.Context, id, planID string) (*domain.Subscription, error) {
+	return m.subscription(m.Called(id, planID))
+}
+
+func (m *MockSubscriptionService) subscription(args mock.Arguments) (*domain.Subscription, error) {
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Subscription), args.Error(1)
+}
+
+func newSubscriptionsRouter(h *SubscriptionsHandler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/api/plans", h.PostPlanHandler())
+	r.Get("/api/plans/{id}", h.GetPlanHandler())
+	r.Post("/api/subscriptions", h.PostSubscriptionHandler())
+	r.Get("/api/subscriptions/{id}", h.GetSubscriptionHandler())
+	r.Post("/api/subscriptions/{id}/pause", h.PauseHandler())
+	r.Post("/api/subscriptions/{id}/resume", h.ResumeHandler())
+	r.Post("/api/subscriptions/{id}/cancel", h.CancelHandler())
+	r.Post("/api/subscriptions/{id}/change-plan", h.ChangePlanHandler())
+	return r
+}
+
+func testSubscription() *domain.Subscription {
+	now := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	return &domain.Subscription{
+		ID:                 "sub-1",
+		PlanID:             "plan-1",
+		CardToken:          "tok_abc",
+		Status:             domain.SubscriptionActive,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   now.AddDate(0, 1, 0),
+		NextChargeAt:       now.AddDate(0, 1, 0),
+		Charges: []domain.SubscriptionCharge{
+			{PaymentID: "pay-1", Amount: 999, Currency: "GBP", Reason: domain.ChargeInitial, Status: domain.StatusAuthorized, Attempt: 1},
+		},
+		CreatedAt: now,
+	}
+}
+
+func TestSubscriptionsPostPlanHandler(t *testing.T) {
+	mockService := new(MockSubscriptionService)
+	mockService.On("CreatePlan", &domain.Plan{
+		Name:     "Pro",
+		Amount:   999,
+		Currency: "GBP",
+		Interval: domain.IntervalMonth,
+	}).Return(&domain.Plan{
+		ID:            "plan-1",
+		Name:          "Pro",
+		Amount:        999,
+		Currency:      "GBP",
+		Interval:      domain.IntervalMonth,
+		IntervalCount: 1,
+	}, nil)
+
+	body, _ := json.Marshal(models.PostPlanRequest{Name: "Pro", Amount: 999, Currency: "GBP", Interval: "month"})
+	req := httptest.NewRequest(http.MethodPost, "/api/plans", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	newSubscriptionsRouter(NewSubscriptionsHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.PlanResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "plan-1", response.ID)
+	assert.Equal(t, 1, response.IntervalCount)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestSubscriptionsPostSubscriptionHandler(t *testing.T) {
+	mockService := new(MockSubscriptionService)
+	mockService.On("Subscribe", "plan-1", "tok_abc", "123").Return(testSubscription(), nil)
+
+	body, _ := json.Marshal(models.PostSubscriptionRequest{
+		PlanID: "plan-1",
+		Source: &models.PaymentSource{Token: "tok_abc"},
+		CVV:    "123",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/subscriptions", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	newSubscriptionsRouter(NewSubscriptionsHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.SubscriptionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "sub-1", response.ID)
+	assert.Equal(t, "active", response.Status)
+	require.NotNil(t, response.NextChargeAt)
+	require.Len(t, response.Charges, 1)
+	assert.Equal(t, "initial", response.Charges[0].Reason)
+	assert.NotContains(t, w.Body.String(), "tok_abc")
+
+	mockService.AssertExpectations(t)
+}
+
+func TestSubscriptionsCancelHandler(t *testing.T) {
+	canceled := testSubscription()
+	canceled.Status = domain.SubscriptionCanceled
+
+	tests := []struct {
+		name        string
+		body        string
+		atPeriodEnd bool
+	}{
+		{name: "no body cancels now", body: "", atPeriodEnd: false},
+		{name: "at period end", body: `{"at_period_end":true}`, atPeriodEnd: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockSubscriptionService)
+			mockService.On("Cancel", "sub-1", tt.atPeriodEnd).Return(canceled, nil)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/subscriptions/sub-1/cancel", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			newSubscriptionsRouter(NewSubscriptionsHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSubscriptionsHandler_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		expectCode int
+	}{
+		{name: "subscription not found", err: domain.ErrSubscriptionNotFound, expectCode: http.StatusNotFound},
+		{name: "plan not found", err: domain.ErrPlanNotFound, expectCode: http.StatusNotFound},
+		{name: "invalid state", err: domain.ErrSubscriptionInvalidState, expectCode: http.StatusConflict},
+		{name: "payment failed", err: domain.ErrSubscriptionPaymentFailed, expectCode: http.StatusPaymentRequired},
+		{name: "validation error", err: domain.ErrPlanCurrencyChange, expectCode: http.StatusBadRequest},
+		{name: "unexpected error", err: errors.New("boom"), expectCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockSubscriptionService)
+			mockService.On("ChangePlan", "sub-1", "plan-2").Return(nil, tt.err)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/subscriptions/sub-1/change-plan", bytes.NewBufferString(`{"plan_id":"plan-2"}`))
+			w := httptest.NewRecorder()
+
+			newSubscriptionsRouter(NewSubscriptionsHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectCode, w.Code)
+			assert.NotContains(t, w.Body.String(), "boom")
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSubscriptionsPauseHandler(t *testing.T) {
+	paused := testSubscription()
+	paused.Status = domain.SubscriptionPaused
+
+	mockService := new(MockSubscriptionService)
+	mockService.On("Pause", "sub-1").Return(paused, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/subscriptions/sub-1/pause", nil)
+	w := httptest.NewRecorder()
+
+	newSubscriptionsRouter(NewSubscriptionsHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.SubscriptionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "paused", response.Status)
+	assert.Nil(t, response.NextChargeAt)
+}
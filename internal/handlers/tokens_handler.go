@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// CardVault stores cards for the merchant that tokenized them
+type CardVault interface {
+	Tokenize(merchantID string, card domain.Card) (*domain.Card, error)
+	Shred(merchantID, token string) error
+}
+
+type TokensHandler struct {
+	vault CardVault
+}
+
+func NewTokensHandler(vault CardVault) *TokensHandler {
+	return &TokensHandler{
+		vault: vault,
+	}
+}
+
+func (h *TokensHandler) PostHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.PostTokenRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		card, err := h.vault.Tokenize(merchant.IDFrom(r.Context()), req.ToDomainCard())
+		if err != nil {
+			if domain.IsValidationError(err) {
+				respondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			respondWithError(w, http.StatusInternalServerError, "Failed to store card")
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, models.FromDomainCard(card))
+	}
+}
+
+func (h *TokensHandler) DeleteHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		token := chi.URLParam(r, "token")
+		if token == "" {
+			respondWithError(w, http.StatusBadRequest, "Token is required")
+			return
+		}
+
+		if err := h.vault.Shred(merchant.IDFrom(r.Context()), token); err != nil {
+			if errors.Is(err, domain.ErrTokenNotFound) {
+				respondWithError(w, http.StatusNotFound, "Token not found")
+				return
+			}
+
+			respondWithError(w, http.StatusInternalServerError, "Failed to delete card")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockCardVault struct {
+	mock.Mock
+}
+
+func (m *MockCardVault) Tokenize(merchantID string, card domain.Card) (*domain.Card, error) {
+	args := m.Called(merchantID, card)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Card), args.Error(1)
+}
+
+func (m *MockCardVault) Shred(merchantID, token string) error {
+	args := m.Called(merchantID, token)
+	return args.Error(0)
+}
+
+func TestTokensPostHandler_Success(t *testing.T) {
+	mockVault := new(MockCardVault)
+	futureYear := time.Now().Year() + 1
+
+	mockVault.On("Tokenize", "merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  futureYear,
+	}).Return(&domain.Card{
+		Token:       "tok_abc",
+		LastFour:    "8877",
+		ExpiryMonth: 12,
+		ExpiryYear:  futureYear,
+	}, nil)
+
+	handler := NewTokensHandler(mockVault)
+
+	body, _ := json.Marshal(models.PostTokenRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 12,
+		ExpiryYear:  futureYear,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewBuffer(body))
+	req = req.WithContext(merchant.WithMerchant(req.Context(), merchant.Merchant{ID: "merchant-1"}))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.PostTokenResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+
+	assert.Equal(t, "tok_abc", response.Token)
+	assert.Equal(t, "8877", response.CardNumberLastFour)
+	assert.Equal(t, 12, response.ExpiryMonth)
+	assert.Equal(t, futureYear, response.ExpiryYear)
+	assert.NotContains(t, w.Body.String(), "2222405343248877")
+
+	mockVault.AssertExpectations(t)
+}
+
+func TestTokensPostHandler_ValidationError(t *testing.T) {
+	mockVault := new(MockCardVault)
+	mockVault.On("Tokenize", mock.Anything, mock.Anything).Return(nil, domain.ErrCardNumberInvalid)
+
+	handler := NewTokensHandler(mockVault)
+
+	body, _ := json.Marshal(models.PostTokenRequest{CardNumber: "123", ExpiryMonth: 12, ExpiryYear: 2030})
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response models.ErrorResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	assert.Equal(t, domain.ErrCardNumberInvalid.Error(), response.Error)
+}
+
+func TestTokensPostHandler_InvalidJSON(t *testing.T) {
+	mockVault := new(MockCardVault)
+	handler := NewTokensHandler(mockVault)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewBufferString("invalid json"))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockVault.AssertNotCalled(t, "Tokenize")
+}
+
+func TestTokensPostHandler_VaultError(t *testing.T) {
+	mockVault := new(MockCardVault)
+	mockVault.On("Tokenize", mock.Anything, mock.Anything).Return(nil, errors.New("encryption failed"))
+
+	handler := NewTokensHandler(mockVault)
+
+	body, _ := json.Marshal(models.PostTokenRequest{CardNumber: "2222405343248877", ExpiryMonth: 12, ExpiryYear: 2030})
+	req := httptest.NewRequest(http.MethodPost, "/api/tokens", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	handler.PostHandler()(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "encryption failed")
+}
+
+func TestTokensDeleteHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		shredErr   error
+		expectCode int
+	}{
+		{name: "shredded", shredErr: nil, expectCode: http.StatusNoContent},
+		{name: "unknown token", shredErr: domain.ErrTokenNotFound, expectCode: http.StatusNotFound},
+		{name: "vault error", shredErr: errors.New("boom"), expectCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockVault := new(MockCardVault)
+			mockVault.On("Shred", "merchant-1", "tok_abc").Return(tt.shredErr)
+
+			handler := NewTokensHandler(mockVault)
+
+			r := chi.NewRouter()
+			r.Delete("/api/tokens/{token}", handler.DeleteHandler())
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/tokens/tok_abc", nil)
+			req = req.WithContext(merchant.WithMerchant(req.Context(), merchant.Merchant{ID: "merchant-1"}))
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectCode, w.Code)
+			mockVault.AssertExpectations(t)
+		})
+	}
+}
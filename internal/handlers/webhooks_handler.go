@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+type WebhookService interface {
+	RegisterEndpoint(ctx context.Context, url string, eventTypes []domain.EventType) (*domain.WebhookEndpoint, error)
+	GetEndpoint(ctx context.Context, id string) (*domain.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id string) error
+	ListDeliveries(ctx context.Context, endpointID string, status domain.DeliveryStatus) ([]domain.WebhookDelivery, error)
+	GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error)
+	Redeliver(ctx context.Context, id string) (*domain.WebhookDelivery, error)
+}
+
+type WebhooksHandler struct {
+	webhookService WebhookService
+}
+
+func NewWebhooksHandler(webhookService WebhookService) *WebhooksHandler {
+	return &WebhooksHandler{
+		webhookService: webhookService,
+	}
+}
+
+func (h *WebhooksHandler) PostEndpointHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		var req models.PostWebhookEndpointRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		endpoint, err := h.webhookService.RegisterEndpoint(r.Context(), req.URL, req.DomainEventTypes())
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		resp := models.FromDomainWebhookEndpoint(endpoint)
+		resp.Secret = endpoint.Secret
+
+		respondWithJSON(w, http.StatusCreated, resp)
+	}
+}
+
+func (h *WebhooksHandler) GetEndpointHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		endpoint, err := h.webhookService.GetEndpoint(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainWebhookEndpoint(endpoint))
+	}
+}
+
+func (h *WebhooksHandler) DeleteEndpointHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		if err := h.webhookService.DeleteEndpoint(r.Context(), chi.URLParam(r, "id")); err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *WebhooksHandler) ListDeliveriesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		status := domain.DeliveryStatus(r.URL.Query().Get("status"))
+		switch status {
+		case "", domain.DeliveryPending, domain.DeliverySucceeded, domain.DeliveryDead:
+		default:
+			respondWithError(w, http.StatusBadRequest, "status must be pending, succeeded or dead")
+			return
+		}
+
+		deliveries, err := h.webhookService.ListDeliveries(r.Context(), chi.URLParam(r, "id"), status)
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		resp := make([]*models.WebhookDeliveryResponse, len(deliveries))
+		for i := range deliveries {
+			resp[i] = models.FromDomainWebhookDelivery(&deliveries[i])
+		}
+
+		respondWithJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (h *WebhooksHandler) GetDeliveryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		delivery, err := h.webhookService.GetDelivery(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusOK, models.FromDomainWebhookDelivery(delivery))
+	}
+}
+
+func (h *WebhooksHandler) RedeliverHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		delivery, err := h.webhookService.Redeliver(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			respondWithWebhookError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusAccepted, models.FromDomainWebhookDelivery(delivery))
+	}
+}
+
+func respondWithWebhookError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, domain.ErrWebhookEndpointNotFound):
+		respondWithError(w, http.StatusNotFound, "Webhook endpoint not found")
+	case errors.Is(err, domain.ErrDeliveryNotFound):
+		respondWithError(w, http.StatusNotFound, "Webhook delivery not found")
+	case errors.Is(err, domain.ErrDeliveryPending), errors.Is(err, domain.ErrDeliveryConflict):
+		respondWithError(w, http.StatusConflict, err.Error())
+	case domain.IsValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Failed to process webhook request")
+	}
+}
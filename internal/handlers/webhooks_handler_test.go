@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) RegisterEndpoint(ctx context.Context, url string, eventTypes []domain.EventType) (*domain.WebhookEndpoint, error) {
+	args := m.Called(url, eventTypes)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.WebhookEndpoint), args.Error(1)
+}
+
+func (m *MockWebhookService) GetEndpoint(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.WebhookEndpoint), args.Error(1)
+}
+
+func (m *MockWebhookService) DeleteEndpoint(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) ListDeliveries(ctx context.Context, endpointID string, status domain.DeliveryStatus) ([]domain.WebhookDelivery, error) {
+	args := m.Called(endpointID, status)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookService) GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.WebhookDelivery), args.Error(1)
+}
+
+func (m *MockWebhookService) Redeliver(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.WebhookDelivery), args.Error(1)
+}
+
+func newWebhooksRouter(h *WebhooksHandler) *chi.Mux {
+	r := chi.NewRouter()
+	r.Post("/api/webhook-endpoints", h.PostEndpointHandler())
+	r.Get("/api/webhook-endpoints/{id}", h.GetEndpointHandler())
+	r.Delete("/api/webhook-endpoints/{id}", h.DeleteEndpointHandler())
+	r.Get("/api/webhook-endpoints/{id}/deliveries", h.ListDeliveriesHandler())
+	r.Get("/api/webhook-deliveries/{id}", h.GetDeliveryHandler())
+	r.Post("/api/webhook-deliveries/{id}/redeliver", h.RedeliverHandler())
+	return r
+}
+
+func testEndpoint() *domain.WebhookEndpoint {
+	return &domain.WebhookEndpoint{
+		ID:         "ep-1",
+		URL:        "https://merchant.example.com/hooks",
+		Secret:     "whsec_abc",
+		EventTypes: []domain.EventType{domain.EventPaymentAuthorized},
+		CreatedAt:  time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestWebhooksPostEndpointHandler(t *testing.T) {
+	mockService := new(MockWebhookService)
+	mockService.On("RegisterEndpoint", "https://merchant.example.com/hooks", []domain.EventType{domain.EventPaymentAuthorized}).
+		Return(testEndpoint(), nil)
+
+	body, _ := json.Marshal(models.PostWebhookEndpointRequest{
+		URL:        "https://merchant.example.com/hooks",
+		EventTypes: []string{"payment.authorized"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/webhook-endpoints", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	newWebhooksRouter(NewWebhooksHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.WebhookEndpointResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "ep-1", response.ID)
+	assert.Equal(t, "whsec_abc", response.Secret)
+	assert.Equal(t, []string{"payment.authorized"}, response.EventTypes)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestWebhooksGetEndpointHandler_HidesSecret(t *testing.T) {
+	mockService := new(MockWebhookService)
+	mockService.On("GetEndpoint", "ep-1").Return(testEndpoint(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhook-endpoints/ep-1", nil)
+	w := httptest.NewRecorder()
+
+	newWebhooksRouter(NewWebhooksHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "whsec_abc")
+}
+
+func TestWebhooksListDeliveriesHandler(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	mockService := new(MockWebhookService)
+	mockService.On("ListDeliveries", "ep-1", domain.DeliveryDead).Return([]domain.WebhookDelivery{
+		{
+			ID:         "d-1",
+			EventID:    "evt_1",
+			EndpointID: "ep-1",
+			Status:     domain.DeliveryDead,
+			Failures:   12,
+			Attempts:   []domain.DeliveryAttempt{{At: now, StatusCode: 500, Error: "endpoint responded with status 500", Duration: 42 * time.Millisecond}},
+		},
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhook-endpoints/ep-1/deliveries?status=dead", nil)
+	w := httptest.NewRecorder()
+
+	newWebhooksRouter(NewWebhooksHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []models.WebhookDeliveryResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Len(t, response, 1)
+	assert.Equal(t, "dead", response[0].Status)
+	assert.Nil(t, response[0].NextAttemptAt)
+	require.Len(t, response[0].Attempts, 1)
+	assert.Equal(t, int64(42), response[0].Attempts[0].DurationMs)
+}
+
+func TestWebhooksListDeliveriesHandler_BadStatus(t *testing.T) {
+	mockService := new(MockWebhookService)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/webhook-endpoints/ep-1/deliveries?status=lost", nil)
+	w := httptest.NewRecorder()
+
+	newWebhooksRouter(NewWebhooksHandler(mockService)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertNotCalled(t, "ListDeliveries", mock.Anything, mock.Anything)
+}
+
+func TestWebhooksRedeliverHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		expectCode int
+	}{
+		{name: "queued", expectCode: http.StatusAccepted},
+		{name: "already pending", err: domain.ErrDeliveryPending, expectCode: http.StatusConflict},
+		{name: "changed concurrently", err: domain.ErrDeliveryConflict, expectCode: http.StatusConflict},
+		{name: "unknown delivery", err: domain.ErrDeliveryNotFound, expectCode: http.StatusNotFound},
+		{name: "endpoint deleted", err: domain.ErrWebhookEndpointNotFound, expectCode: http.StatusNotFound},
+		{name: "outbox error", err: errors.New("disk full"), expectCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockWebhookService)
+			if tt.err != nil {
+				mockService.On("Redeliver", "d-1").Return(nil, tt.err)
+			} else {
+				mockService.On("Redeliver", "d-1").Return(&domain.WebhookDelivery{ID: "d-1", Status: domain.DeliveryPending}, nil)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/webhook-deliveries/d-1/redeliver", nil)
+			w := httptest.NewRecorder()
+
+			newWebhooksRouter(NewWebhooksHandler(mockService)).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectCode, w.Code)
+			assert.NotContains(t, w.Body.String(), "disk full")
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestWebhooksDeleteEndpointHandler(t *testing.T) {
+	mockService := new(MockWebhookService)
+	mockService.On("DeleteEndpoint", "ep-1").Return(nil)
+	mockService.On("DeleteEndpoint", "ep-2").Return(domain.ErrWebhookEndpointNotFound)
+
+	router := newWebhooksRouter(NewWebhooksHandler(mockService))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/webhook-endpoints/ep-1", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/webhook-endpoints/ep-2", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,165 @@
+// Package health reports whether the gateway is alive and whether it is
+// ready to take traffic.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCheckTimeout bounds how long a single checker may take
+const DefaultCheckTimeout = 2 * time.Second
+
+type Status string
+
+const (
+	StatusUp       Status = "up"
+	StatusDown     Status = "down"
+	StatusDraining Status = "draining"
+)
+
+// Checker reports whether one dependency of the gateway is usable. A nil
+// error means it is.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Component is the result of one checker
+type Component struct {
+	Name     string
+	Status   Status
+	Error    string
+	Duration time.Duration
+}
+
+// Report is the readiness of the gateway and of each of its components
+type Report struct {
+	Status     Status
+	Components []Component
+}
+
+// Ready is true when the gateway should receive traffic
+func (r Report) Ready() bool {
+	return r.Status == StatusUp
+}
+
+type namedChecker struct {
+	name    string
+	checker Checker
+}
+
+// Health aggregates the registered checkers into a readiness report
+type Health struct {
+	checkers []namedChecker
+	timeout  time.Duration
+	draining atomic.Bool
+	mu       sync.RWMutex
+}
+
+type Option func(*Health)
+
+// WithCheckTimeout changes how long each checker may take before it is
+// reported down
+func WithCheckTimeout(d time.Duration) Option {
+	return func(h *Health) {
+		h.timeout = d
+	}
+}
+
+func New(opts ...Option) *Health {
+	h := &Health{timeout: DefaultCheckTimeout}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Register adds a checker under name. Readiness needs every registered
+// checker to pass.
+func (h *Health) Register(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers = append(h.checkers, namedChecker{name: name, checker: c})
+}
+
+// Drain marks the gateway as shutting down. Readiness fails from then on,
+// whatever the checkers say.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Draining reports whether Drain has been called
+func (h *Health) Draining() bool {
+	return h.draining.Load()
+}
+
+// Check runs every checker concurrently, each with its own timeout, and
+// reports the components sorted by name
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	checkers := append([]namedChecker(nil), h.checkers...)
+	h.mu.RUnlock()
+
+	components := make([]Component, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func(i int, c namedChecker) {
+			defer wg.Done()
+			components[i] = h.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+
+	report := Report{Status: StatusUp, Components: components}
+	for _, c := range components {
+		if c.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	if h.Draining() {
+		report.Status = StatusDraining
+	}
+
+	return report
+}
+
+func (h *Health) run(ctx context.Context, c namedChecker) Component {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		// The checker ignored its context, don't let it hold up readiness
+		err = ctx.Err()
+	}
+
+	component := Component{Name: c.name, Status: StatusUp, Duration: time.Since(start)}
+	if err != nil {
+		component.Status = StatusDown
+		component.Error = err.Error()
+	}
+	return component
+}
@@ -0,0 +1,75 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func up(ctx context.Context) error { return nil }
+
+func TestHealth_Check(t *testing.T) {
+	h := New()
+	h.Register("repository", CheckerFunc(up))
+	h.Register("bank", CheckerFunc(up))
+
+	report := h.Check(context.Background())
+	assert.True(t, report.Ready())
+	assert.Equal(t, StatusUp, report.Status)
+	require.Len(t, report.Components, 2)
+	assert.Equal(t, "bank", report.Components[0].Name)
+	assert.Equal(t, "repository", report.Components[1].Name)
+}
+
+func TestHealth_Check_ComponentDown(t *testing.T) {
+	h := New()
+	h.Register("repository", CheckerFunc(up))
+	h.Register("bank", CheckerFunc(func(ctx context.Context) error {
+		return errors.New("bank unreachable")
+	}))
+
+	report := h.Check(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, StatusDown, report.Status)
+	assert.Equal(t, Component{Name: "bank", Status: StatusDown, Error: "bank unreachable"}, withoutDuration(report.Components[0]))
+	assert.Equal(t, StatusUp, report.Components[1].Status)
+}
+
+func TestHealth_Check_Timeout(t *testing.T) {
+	h := New(WithCheckTimeout(20 * time.Millisecond))
+	block := make(chan struct{})
+	defer close(block)
+	h.Register("stuck", CheckerFunc(func(ctx context.Context) error {
+		<-block // ignores its context
+		return nil
+	}))
+
+	start := time.Now()
+	report := h.Check(context.Background())
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, StatusDown, report.Components[0].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Components[0].Error)
+}
+
+func TestHealth_Drain(t *testing.T) {
+	h := New()
+	h.Register("bank", CheckerFunc(up))
+	assert.False(t, h.Draining())
+
+	h.Drain()
+
+	report := h.Check(context.Background())
+	assert.True(t, h.Draining())
+	assert.False(t, report.Ready())
+	assert.Equal(t, StatusDraining, report.Status)
+	assert.Equal(t, StatusUp, report.Components[0].Status, "components are still reported while draining")
+}
+
+func withoutDuration(c Component) Component {
+	c.Duration = 0
+	return c
+}
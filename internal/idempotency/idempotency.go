@@ -0,0 +1,314 @@
+// Package idempotency lets callers retry requests that create something,
+// such as payments, without them taking effect twice. A request sent with an
+// Idempotency-Key header is processed once; retries with the same key get
+// the first response again.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+)
+
+const (
+	// Header carries the caller's key for a request
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed from an earlier request
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxKeyLength bounds the keys callers may send; UUIDs are far shorter
+const maxKeyLength = 255
+
+// replayedHeaders are the response headers a replay repeats. The rest, such
+// as X-Request-ID, describe the request being answered rather than the
+// first one.
+var replayedHeaders = []string{"Content-Type", "Location", "Preference-Applied"}
+
+// Response is a response stored to be replayed
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Record is what a store knows about a key. Response is nil while the first
+// request with the key is still being processed.
+type Record struct {
+	Fingerprint string
+	Response    *Response
+}
+
+// Store remembers the requests made with each key. Implementations may
+// keep them in memory or in a store shared by several gateway instances.
+type Store interface {
+	// Begin claims key for a request with fingerprint. If the key is
+	// already claimed it returns the existing record and false instead.
+	Begin(ctx context.Context, key, fingerprint string) (*Record, bool, error)
+	// Complete stores the response to the request that claimed key
+	Complete(ctx context.Context, key string, response *Response) error
+	// Release forgets key, so the request can be made again
+	Release(ctx context.Context, key string) error
+}
+
+// DefaultTTL is how long keys are remembered unless configured otherwise
+const DefaultTTL = 24 * time.Hour
+
+// defaultSweepInterval is how often expired keys are dropped
+const defaultSweepInterval = time.Minute
+
+type entry struct {
+	record  Record
+	expires time.Time
+}
+
+// MemoryStore keeps keys in memory, so retries must reach the same gateway
+// instance and keys are forgotten on restart
+type MemoryStore struct {
+	clock         clock.Clock
+	ttl           time.Duration
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// Option configures a MemoryStore
+type Option func(*MemoryStore)
+
+// WithClock replaces the wall clock used to expire keys
+func WithClock(c clock.Clock) Option {
+	return func(s *MemoryStore) {
+		s.clock = c
+	}
+}
+
+// WithTTL sets how long a key is remembered after it is first used
+func WithTTL(ttl time.Duration) Option {
+	return func(s *MemoryStore) {
+		s.ttl = ttl
+	}
+}
+
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	s := &MemoryStore{
+		clock:         clock.Real{},
+		ttl:           DefaultTTL,
+		sweepInterval: defaultSweepInterval,
+		entries:       make(map[string]*entry),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.lastSweep = s.clock.Now()
+	return s
+}
+
+func (s *MemoryStore) Begin(_ context.Context, key, fingerprint string) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	s.sweep(now)
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		record := e.record
+		return &record, false, nil
+	}
+
+	s.entries[key] = &entry{
+		record:  Record{Fingerprint: fingerprint},
+		expires: now.Add(s.ttl),
+	}
+	return nil, true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, response *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("idempotency key %q was not claimed", key)
+	}
+	e.record.Response = response
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// Len returns the number of keys held
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// sweep drops expired keys. It runs at most once per sweep interval, from
+// Begin, so no goroutine is needed.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Middleware processes each request with an Idempotency-Key once. Keys are
+// looked up within the scope returned for the request, typically the
+// caller, so callers cannot see each other's responses. A retry gets the
+// stored response; reusing a key for a different request, or while the
+// first request is in progress, is an error written with reject.
+//
+// Responses that say the request can be retried as is, 429 and 5xx, are
+// not stored, so the retry is processed.
+func Middleware(store Store, scope func(*http.Request) string, reject func(w http.ResponseWriter, statusCode int, message string)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxKeyLength {
+				reject(w, http.StatusBadRequest, fmt.Sprintf("%s must not be longer than %d characters", Header, maxKeyLength))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					reject(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body must not be larger than %d bytes", tooLarge.Limit))
+					return
+				}
+				reject(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			scopedKey := scope(r) + "|" + key
+			fingerprint := fingerprint(r, body)
+
+			record, claimed, err := store.Begin(ctx, scopedKey, fingerprint)
+			if err != nil {
+				// Letting the request through could repeat it
+				slog.ErrorContext(ctx, "idempotency store failed", "error", err)
+				w.Header().Set("Retry-After", "1")
+				reject(w, http.StatusServiceUnavailable, "Unable to check "+Header+", retry shortly")
+				return
+			}
+
+			if !claimed {
+				switch {
+				case record.Fingerprint != fingerprint:
+					reject(w, http.StatusUnprocessableEntity, Header+" was already used for a different request")
+				case record.Response == nil:
+					w.Header().Set("Retry-After", "1")
+					reject(w, http.StatusConflict, "A request with this "+Header+" is still in progress")
+				default:
+					replay(w, record.Response)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			completed := false
+			defer func() {
+				// Also reached if the handler panics
+				if !completed {
+					if err := store.Release(ctx, scopedKey); err != nil {
+						slog.ErrorContext(ctx, "failed to release idempotency key", "error", err)
+					}
+				}
+			}()
+
+			next.ServeHTTP(recorder, r)
+
+			if recorder.statusCode >= http.StatusInternalServerError || recorder.statusCode == http.StatusTooManyRequests {
+				return
+			}
+
+			response := &Response{
+				StatusCode: recorder.statusCode,
+				Header:     make(http.Header),
+				Body:       recorder.body.Bytes(),
+			}
+			for _, name := range replayedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					response.Header[name] = append([]string(nil), values...)
+				}
+			}
+			if err := store.Complete(ctx, scopedKey, response); err != nil {
+				slog.ErrorContext(ctx, "failed to store idempotent response", "error", err)
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// fingerprint identifies a request, so a key reused for another one can be
+// told apart from a retry
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, response *Response) {
+	for name, values := range response.Header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.Body)
+}
+
+// responseRecorder passes the response on while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		r.statusCode = statusCode
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
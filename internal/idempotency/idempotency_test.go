@@ -0,0 +1,173 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestMemoryStore(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	store := NewMemoryStore(WithClock(fakeClock), WithTTL(time.Hour))
+	ctx := context.Background()
+
+	record, claimed, err := store.Begin(ctx, "key", "fp-1")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	assert.Nil(t, record)
+
+	record, claimed, err = store.Begin(ctx, "key", "fp-1")
+	require.NoError(t, err)
+	assert.False(t, claimed)
+	assert.Equal(t, &Record{Fingerprint: "fp-1"}, record, "still in progress")
+
+	response := &Response{StatusCode: http.StatusOK, Body: []byte("{}")}
+	require.NoError(t, store.Complete(ctx, "key", response))
+	record, _, err = store.Begin(ctx, "key", "fp-1")
+	require.NoError(t, err)
+	assert.Equal(t, response, record.Response)
+
+	// Keys are forgotten once they expire
+	fakeClock.Advance(time.Hour)
+	_, claimed, err = store.Begin(ctx, "key", "fp-2")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	require.NoError(t, store.Release(ctx, "key"))
+	assert.Zero(t, store.Len())
+	assert.Error(t, store.Complete(ctx, "key", response))
+}
+
+// countingHandler creates a resource per request, answering with its
+// number, or fails with status if it is set
+type countingHandler struct {
+	calls  atomic.Int32
+	status int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.calls.Add(1)
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/things/%d", n))
+	w.Header().Set("X-Request-ID", fmt.Sprintf("req-%d", n))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"id":%d}`, n)
+}
+
+func reject(w http.ResponseWriter, statusCode int, message string) {
+	http.Error(w, message, statusCode)
+}
+
+func newMiddleware(store Store, next http.Handler) http.Handler {
+	scope := func(r *http.Request) string { return r.Header.Get("X-Caller") }
+	return Middleware(store, scope, reject)(next)
+}
+
+func send(h http.Handler, caller, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(body))
+	req.Header.Set("X-Caller", caller)
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_Replays(t *testing.T) {
+	next := &countingHandler{}
+	h := newMiddleware(NewMemoryStore(), next)
+
+	first := send(h, "merchant-1", "key-1", `{"amount":100}`)
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	retry := send(h, "merchant-1", "key-1", `{"amount":100}`)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, `{"id":1}`, retry.Body.String())
+	assert.Equal(t, "/things/1", retry.Header().Get("Location"))
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Empty(t, retry.Header().Get("X-Request-ID"), "belongs to the first request")
+	assert.Equal(t, int32(1), next.calls.Load())
+
+	// Keys are the caller's own, and requests without one are not tracked
+	assert.Equal(t, `{"id":2}`, send(h, "merchant-2", "key-1", `{"amount":100}`).Body.String())
+	assert.Equal(t, `{"id":3}`, send(h, "merchant-1", "", `{"amount":100}`).Body.String())
+	assert.Equal(t, `{"id":4}`, send(h, "merchant-1", "", `{"amount":100}`).Body.String())
+}
+
+func TestMiddleware_Misuse(t *testing.T) {
+	store := NewMemoryStore()
+	h := newMiddleware(store, &countingHandler{})
+
+	send(h, "merchant-1", "key-1", `{"amount":100}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, send(h, "merchant-1", "key-1", `{"amount":200}`).Code)
+
+	_, claimed, err := store.Begin(context.Background(), "merchant-1|key-2", fingerprint(httptest.NewRequest(http.MethodPost, "/things", nil), []byte(`{}`)))
+	require.NoError(t, err)
+	require.True(t, claimed)
+	inProgress := send(h, "merchant-1", "key-2", `{}`)
+	assert.Equal(t, http.StatusConflict, inProgress.Code)
+	assert.Equal(t, "1", inProgress.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusBadRequest, send(h, "merchant-1", strings.Repeat("k", 256), `{}`).Code)
+}
+
+func TestMiddleware_RetryableResponsesNotStored(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			next := &countingHandler{status: status}
+			store := NewMemoryStore()
+			h := newMiddleware(store, next)
+
+			assert.Equal(t, status, send(h, "merchant-1", "key-1", `{}`).Code)
+			assert.Equal(t, status, send(h, "merchant-1", "key-1", `{}`).Code)
+			assert.Equal(t, int32(2), next.calls.Load(), "the retry is processed")
+			assert.Zero(t, store.Len())
+		})
+	}
+}
+
+func TestMiddleware_ReleasesOnPanic(t *testing.T) {
+	store := NewMemoryStore()
+	h := newMiddleware(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	assert.Panics(t, func() { send(h, "merchant-1", "key-1", `{}`) })
+	assert.Zero(t, store.Len())
+}
+
+// failingStore cannot be reached
+type failingStore struct{}
+
+func (failingStore) Begin(context.Context, string, string) (*Record, bool, error) {
+	return nil, false, errors.New("store unreachable")
+}
+func (failingStore) Complete(context.Context, string, *Response) error { return nil }
+func (failingStore) Release(context.Context, string) error             { return nil }
+
+func TestMiddleware_StoreFailure(t *testing.T) {
+	next := &countingHandler{}
+	h := newMiddleware(failingStore{}, next)
+
+	w := send(h, "merchant-1", "key-1", `{}`)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Zero(t, next.calls.Load(), "the request might otherwise be repeated")
+}
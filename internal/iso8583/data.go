@@ -0,0 +1,67 @@
+package iso8583
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Tags of the subelements the gateway sends in FieldAdditionalData
+const (
+	TagCVV                   = "CV"
+	TagInitiator             = "IN"
+	TagStoredCredentialUsage = "SU"
+	TagStoredCredentialType  = "ST"
+	TagPreviousTransactionID = "TI"
+)
+
+// Subelement is one entry of FieldAdditionalData, packed as a two
+// character tag, a three digit length and the value
+type Subelement struct {
+	Tag   string
+	Value string
+}
+
+// PackSubelements packs subelements in order, skipping empty values
+func PackSubelements(elements ...Subelement) string {
+	var s []byte
+	for _, e := range elements {
+		if e.Value == "" {
+			continue
+		}
+		s = append(s, fmt.Sprintf("%-2.2s%03d%s", e.Tag, len(e.Value), e.Value)...)
+	}
+	return string(s)
+}
+
+// ParseSubelements reads FieldAdditionalData into values by tag
+func ParseSubelements(s string) (map[string]string, error) {
+	elements := make(map[string]string)
+	for len(s) > 0 {
+		if len(s) < 5 {
+			return nil, fmt.Errorf("%w: subelement %q is cut short", ErrMalformed, s)
+		}
+		n, err := strconv.Atoi(s[2:5])
+		if err != nil || 5+n > len(s) {
+			return nil, fmt.Errorf("%w: subelement %s has a bad length %q", ErrMalformed, s[:2], s[2:5])
+		}
+		elements[s[:2]] = s[5 : 5+n]
+		s = s[5+n:]
+	}
+	return elements, nil
+}
+
+// numericCurrencies maps ISO 4217 alphabetic codes to the numeric codes
+// FieldCurrency carries
+var numericCurrencies = map[string]string{
+	"AED": "784", "AUD": "036", "BRL": "986", "CAD": "124", "CHF": "756",
+	"CNY": "156", "CZK": "203", "DKK": "208", "EUR": "978", "GBP": "826",
+	"HKD": "344", "HUF": "348", "INR": "356", "JPY": "392", "MXN": "484",
+	"NOK": "578", "NZD": "554", "PLN": "985", "SAR": "682", "SEK": "752",
+	"SGD": "702", "USD": "840", "ZAR": "710",
+}
+
+// NumericCurrency returns the ISO 4217 numeric code for an alphabetic one
+func NumericCurrency(alpha string) (string, bool) {
+	code, ok := numericCurrencies[alpha]
+	return code, ok
+}
@@ -0,0 +1,43 @@
+package iso8583
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxFrameLength is the largest message a two byte length prefix can carry
+const MaxFrameLength = 1<<16 - 1
+
+// WriteFrame writes msg preceded by its length as two big-endian bytes, in
+// a single write so frames from concurrent writers holding a lock never
+// interleave
+func WriteFrame(w io.Writer, msg []byte) error {
+	if len(msg) > MaxFrameLength {
+		return fmt.Errorf("message of %d bytes is too long to frame", len(msg))
+	}
+
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads one length-prefixed message. It returns io.EOF only if
+// the stream ended cleanly between frames.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(header[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,250 @@
+package iso8583
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// Response codes the host answers with
+const (
+	ResponseApproved           = "00"
+	ResponseDoNotHonour        = "05"
+	ResponseFormatError        = "30"
+	ResponseInsufficientFunds  = "51"
+	ResponseIssuerUnavailable  = "91"
+	ResponseSystemMalfunction  = "96"
+	ResponseExpiredCard        = "54"
+	ResponseInvalidCard        = "14"
+	ResponseSuspectedFraud     = "59"
+	ResponseExceedsAmountLimit = "61"
+)
+
+// Decision is how the host answers a request
+type Decision struct {
+	ResponseCode string
+	// Delay holds the answer back, so answers can arrive out of order
+	Delay time.Duration
+	// Drop never answers, as if the acquirer lost the request
+	Drop bool
+}
+
+// Decider chooses how the host answers an authorization or financial
+// request
+type Decider func(req *Message) Decision
+
+// DefaultDecider follows the HTTP bank simulator's rules on the card
+// number's last digit: odd digits are approved, even ones declined and 0
+// finds the issuer unavailable
+func DefaultDecider(req *Message) Decision {
+	pan := req.Get(FieldPAN)
+	if pan == "" {
+		return Decision{ResponseCode: ResponseFormatError}
+	}
+	switch last := pan[len(pan)-1]; {
+	case last == '0':
+		return Decision{ResponseCode: ResponseIssuerUnavailable}
+	case (last-'0')%2 == 1:
+		return Decision{ResponseCode: ResponseApproved}
+	default:
+		return Decision{ResponseCode: ResponseDoNotHonour}
+	}
+}
+
+// Host is an in-process ISO 8583 acquirer for tests. It answers
+// authorization and financial requests as its Decider says, approves every
+// reversal and echo, and keeps every request it reads.
+type Host struct {
+	decide Decider
+
+	mu       sync.Mutex
+	received []*Message
+	conns    map[net.Conn]struct{}
+	closed   bool
+	listener net.Listener
+	wg       sync.WaitGroup
+}
+
+// HostOption configures a Host
+type HostOption func(*Host)
+
+// WithDecider replaces DefaultDecider
+func WithDecider(d Decider) HostOption {
+	return func(h *Host) {
+		h.decide = d
+	}
+}
+
+func NewHost(opts ...HostOption) *Host {
+	h := &Host{
+		decide: DefaultDecider,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Serve accepts connections on l until the host is closed
+func (h *Host) Serve(l net.Listener) error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		l.Close()
+		return net.ErrClosed
+	}
+	h.listener = l
+	h.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			h.mu.Lock()
+			closed := h.closed
+			h.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+
+		h.wg.Add(1)
+		go h.serveConn(conn)
+	}
+}
+
+// Received returns the requests read so far, in order
+func (h *Host) Received() []*Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*Message(nil), h.received...)
+}
+
+// DropConnections closes every open connection, as an acquirer restarting
+// would, but keeps accepting new ones
+func (h *Host) DropConnections() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for conn := range h.conns {
+		conn.Close()
+	}
+}
+
+// Close stops accepting connections, closes the open ones and waits for
+// their requests to finish
+func (h *Host) Close() error {
+	h.mu.Lock()
+	h.closed = true
+	var err error
+	if h.listener != nil {
+		err = h.listener.Close()
+	}
+	h.mu.Unlock()
+
+	h.DropConnections()
+	h.wg.Wait()
+	return err
+}
+
+func (h *Host) serveConn(conn net.Conn) {
+	defer h.wg.Done()
+	defer func() {
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+		conn.Close()
+	}()
+
+	var writeMu sync.Mutex
+	var requests sync.WaitGroup
+	defer requests.Wait()
+
+	for {
+		frame, err := ReadFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("iso8583 host: read failed", "error", err)
+			}
+			return
+		}
+
+		req, err := Unpack(frame)
+		if err != nil {
+			slog.Debug("iso8583 host: unreadable request", "error", err)
+			continue
+		}
+
+		h.mu.Lock()
+		h.received = append(h.received, req)
+		h.mu.Unlock()
+
+		// Each request is answered on its own, so a slow one does not hold
+		// up the rest
+		requests.Add(1)
+		go func() {
+			defer requests.Done()
+
+			resp, delay := h.answer(req)
+			if resp == nil {
+				return
+			}
+			time.Sleep(delay)
+
+			data, err := resp.Pack()
+			if err != nil {
+				slog.Debug("iso8583 host: cannot pack response", "error", err)
+				return
+			}
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			WriteFrame(conn, data)
+		}()
+	}
+}
+
+// answer builds the response to req and how long to wait before sending
+// it. A nil response is never sent.
+func (h *Host) answer(req *Message) (*Message, time.Duration) {
+	resp := req.Response()
+
+	switch req.MTI {
+	case MTIAuthorizationRequest, MTIFinancialRequest:
+		d := h.decide(req)
+		if d.Drop {
+			return nil, 0
+		}
+		resp.Set(FieldResponseCode, d.ResponseCode)
+		if d.ResponseCode == ResponseApproved {
+			resp.Set(FieldAuthorizationCode, randomCode("ABCDEFGHJKLMNPQRSTUVWXYZ0123456789", 6))
+			resp.Set(FieldNetworkData, randomCode("0123456789", 15))
+		}
+		return resp, d.Delay
+	case MTIReversalRequest, MTINetworkRequest:
+		return resp.Set(FieldResponseCode, ResponseApproved), 0
+	default:
+		return resp.Set(FieldResponseCode, ResponseFormatError), 0
+	}
+}
+
+func randomCode(alphabet string, n int) string {
+	code := make([]byte, n)
+	for i := range code {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			panic(fmt.Sprintf("iso8583 host: no randomness: %v", err))
+		}
+		code[i] = alphabet[j.Int64()]
+	}
+	return string(code)
+}
@@ -0,0 +1,230 @@
+// Package iso8583 packs and unpacks ISO 8583 (1987) messages: an ASCII
+// message type indicator, a binary bitmap and ASCII fields. Only the fields
+// the gateway exchanges with acquirers are defined.
+package iso8583
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Message type indicators
+const (
+	MTIAuthorizationRequest  = "0100"
+	MTIAuthorizationResponse = "0110"
+	MTIFinancialRequest      = "0200"
+	MTIFinancialResponse     = "0210"
+	MTIReversalRequest       = "0400"
+	MTIReversalResponse      = "0410"
+	MTINetworkRequest        = "0800"
+	MTINetworkResponse       = "0810"
+)
+
+// Fields the gateway uses
+const (
+	FieldPAN                   = 2
+	FieldProcessingCode        = 3
+	FieldAmount                = 4
+	FieldTransmissionTime      = 7
+	FieldSTAN                  = 11
+	FieldExpiry                = 14
+	FieldPOSEntryMode          = 22
+	FieldRRN                   = 37
+	FieldAuthorizationCode     = 38
+	FieldResponseCode          = 39
+	FieldTerminalID            = 41
+	FieldMerchantID            = 42
+	FieldAdditionalData        = 48
+	FieldCurrency              = 49
+	FieldNetworkData           = 63
+	FieldNetworkManagementCode = 70
+	FieldOriginalData          = 90
+)
+
+// ErrMalformed is returned for bytes that are not a message this package
+// can read
+var ErrMalformed = errors.New("malformed ISO 8583 message")
+
+// fieldSpec is how a field is laid out: fixed length, or up to length
+// characters after a prefix of prefix digits giving the actual length
+type fieldSpec struct {
+	length  int
+	prefix  int
+	numeric bool
+}
+
+var specs = map[int]fieldSpec{
+	FieldPAN:                   {length: 19, prefix: 2, numeric: true},
+	FieldProcessingCode:        {length: 6, numeric: true},
+	FieldAmount:                {length: 12, numeric: true},
+	FieldTransmissionTime:      {length: 10, numeric: true},
+	FieldSTAN:                  {length: 6, numeric: true},
+	FieldExpiry:                {length: 4, numeric: true},
+	FieldPOSEntryMode:          {length: 3, numeric: true},
+	FieldRRN:                   {length: 12},
+	FieldAuthorizationCode:     {length: 6},
+	FieldResponseCode:          {length: 2},
+	FieldTerminalID:            {length: 8},
+	FieldMerchantID:            {length: 15},
+	FieldAdditionalData:        {length: 999, prefix: 3},
+	FieldCurrency:              {length: 3, numeric: true},
+	FieldNetworkData:           {length: 999, prefix: 3},
+	FieldNetworkManagementCode: {length: 3, numeric: true},
+	FieldOriginalData:          {length: 42, numeric: true},
+}
+
+// Message is one ISO 8583 message
+type Message struct {
+	MTI    string
+	fields map[int]string
+}
+
+func NewMessage(mti string) *Message {
+	return &Message{MTI: mti, fields: make(map[int]string)}
+}
+
+// Set sets a field, or removes it if value is empty
+func (m *Message) Set(field int, value string) *Message {
+	if value == "" {
+		delete(m.fields, field)
+	} else {
+		m.fields[field] = value
+	}
+	return m
+}
+
+// Get returns a field, or "" if it is not present
+func (m *Message) Get(field int) string {
+	return m.fields[field]
+}
+
+// Fields lists the fields present, in order
+func (m *Message) Fields() []int {
+	fields := make([]int, 0, len(m.fields))
+	for f := range m.fields {
+		fields = append(fields, f)
+	}
+	sort.Ints(fields)
+	return fields
+}
+
+// Response starts the answer to a request: the MTI's function digit moves
+// from request to response and the fields identifying the request are
+// echoed
+func (m *Message) Response() *Message {
+	mti := []byte(m.MTI)
+	if len(mti) == 4 {
+		mti[2]++
+	}
+
+	resp := NewMessage(string(mti))
+	for _, f := range []int{FieldPAN, FieldProcessingCode, FieldAmount, FieldTransmissionTime, FieldSTAN, FieldRRN, FieldTerminalID, FieldMerchantID, FieldCurrency, FieldNetworkManagementCode, FieldOriginalData} {
+		resp.Set(f, m.Get(f))
+	}
+	return resp
+}
+
+// Pack encodes the message. Fixed length fields must already be padded.
+func (m *Message) Pack() ([]byte, error) {
+	if len(m.MTI) != 4 || !isDigits(m.MTI) {
+		return nil, fmt.Errorf("MTI %q is not four digits", m.MTI)
+	}
+
+	fields := m.Fields()
+	bitmap := make([]byte, 8)
+	if len(fields) > 0 && fields[len(fields)-1] > 64 {
+		bitmap = make([]byte, 16)
+		bitmap[0] |= 0x80
+	}
+
+	buf := append([]byte(m.MTI), bitmap...)
+	for _, f := range fields {
+		spec, ok := specs[f]
+		if !ok {
+			return nil, fmt.Errorf("field %d is not defined", f)
+		}
+
+		value := m.fields[f]
+		if spec.numeric && !isDigits(value) {
+			return nil, fmt.Errorf("field %d must be numeric", f)
+		}
+		if spec.prefix == 0 && len(value) != spec.length {
+			return nil, fmt.Errorf("field %d must be %d characters, got %d", f, spec.length, len(value))
+		}
+		if len(value) > spec.length {
+			return nil, fmt.Errorf("field %d must be at most %d characters, got %d", f, spec.length, len(value))
+		}
+
+		bitmap[(f-1)/8] |= 0x80 >> ((f - 1) % 8)
+		if spec.prefix > 0 {
+			buf = append(buf, fmt.Sprintf("%0*d", spec.prefix, len(value))...)
+		}
+		buf = append(buf, value...)
+	}
+
+	copy(buf[4:], bitmap)
+	return buf, nil
+}
+
+// Unpack decodes a message
+func Unpack(data []byte) (*Message, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("%w: %d bytes is too short", ErrMalformed, len(data))
+	}
+
+	m := NewMessage(string(data[:4]))
+	bitmap := data[4:12]
+	pos := 12
+	if bitmap[0]&0x80 != 0 {
+		if len(data) < 20 {
+			return nil, fmt.Errorf("%w: secondary bitmap is cut short", ErrMalformed)
+		}
+		bitmap = data[4:20]
+		pos = 20
+	}
+
+	for f := 2; f <= len(bitmap)*8; f++ {
+		if bitmap[(f-1)/8]&(0x80>>((f-1)%8)) == 0 {
+			continue
+		}
+		spec, ok := specs[f]
+		if !ok {
+			return nil, fmt.Errorf("%w: field %d is not defined", ErrMalformed, f)
+		}
+
+		length := spec.length
+		if spec.prefix > 0 {
+			if pos+spec.prefix > len(data) {
+				return nil, fmt.Errorf("%w: field %d is cut short", ErrMalformed, f)
+			}
+			n, err := strconv.Atoi(string(data[pos : pos+spec.prefix]))
+			if err != nil || n > spec.length {
+				return nil, fmt.Errorf("%w: field %d has a bad length %q", ErrMalformed, f, data[pos:pos+spec.prefix])
+			}
+			pos += spec.prefix
+			length = n
+		}
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("%w: field %d is cut short", ErrMalformed, f)
+		}
+
+		m.fields[f] = string(data[pos : pos+length])
+		pos += length
+	}
+
+	if pos != len(data) {
+		return nil, fmt.Errorf("%w: %d bytes left over", ErrMalformed, len(data)-pos)
+	}
+	return m, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
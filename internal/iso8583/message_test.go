@@ -0,0 +1,133 @@
+package iso8583
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_PackUnpack(t *testing.T) {
+	msg := NewMessage(MTIAuthorizationRequest).
+		Set(FieldPAN, "2222405343248877").
+		Set(FieldProcessingCode, "000000").
+		Set(FieldAmount, "000000001050").
+		Set(FieldSTAN, "000042").
+		Set(FieldTerminalID, "TERM0001").
+		Set(FieldAdditionalData, PackSubelements(Subelement{TagCVV, "123"})).
+		Set(FieldCurrency, "826")
+
+	data, err := msg.Pack()
+	require.NoError(t, err)
+	assert.Equal(t, "0100", string(data[:4]))
+	assert.Equal(t, []byte{0x70, 0x20, 0x00, 0x00, 0x00, 0x81, 0x80, 0x00}, data[4:12], "primary bitmap only")
+	assert.Equal(t, "162222405343248877000000000000001050000042TERM0001008CV003123826", string(data[12:]))
+
+	got, err := Unpack(data)
+	require.NoError(t, err)
+	assert.Equal(t, msg, got)
+}
+
+func TestMessage_SecondaryBitmap(t *testing.T) {
+	msg := NewMessage(MTINetworkRequest).
+		Set(FieldSTAN, "000001").
+		Set(FieldNetworkManagementCode, "301")
+
+	data, err := msg.Pack()
+	require.NoError(t, err)
+	require.Len(t, data, 4+16+6+3)
+	assert.Equal(t, byte(0x80), data[4]&0x80, "secondary bitmap flagged")
+
+	got, err := Unpack(data)
+	require.NoError(t, err)
+	assert.Equal(t, []int{FieldSTAN, FieldNetworkManagementCode}, got.Fields())
+	assert.Equal(t, "301", got.Get(FieldNetworkManagementCode))
+}
+
+func TestMessage_PackErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *Message
+		expected string
+	}{
+		{name: "bad MTI", msg: NewMessage("01"), expected: `MTI "01" is not four digits`},
+		{name: "short fixed field", msg: NewMessage("0100").Set(FieldSTAN, "42"), expected: "field 11 must be 6 characters, got 2"},
+		{name: "letters in a numeric field", msg: NewMessage("0100").Set(FieldAmount, "00000000010x"), expected: "field 4 must be numeric"},
+		{name: "variable field too long", msg: NewMessage("0100").Set(FieldPAN, "12345678901234567890"), expected: "field 2 must be at most 19 characters"},
+		{name: "undefined field", msg: NewMessage("0100").Set(64, "x"), expected: "field 64 is not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msg.Pack()
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestUnpack_Malformed(t *testing.T) {
+	valid, err := NewMessage(MTIAuthorizationResponse).Set(FieldSTAN, "000042").Set(FieldResponseCode, "00").Pack()
+	require.NoError(t, err)
+
+	for name, data := range map[string][]byte{
+		"too short":        valid[:8],
+		"field cut short":  valid[:len(valid)-1],
+		"bytes left over":  append(append([]byte(nil), valid...), '0'),
+		"undefined field":  append([]byte("0110"), 0, 0, 0, 0, 0, 0, 0, 1),
+		"secondary bitmap": append([]byte("0110"), 0x80, 0, 0, 0, 0, 0, 0, 0),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Unpack(data)
+			assert.ErrorIs(t, err, ErrMalformed)
+		})
+	}
+}
+
+func TestMessage_Response(t *testing.T) {
+	req := NewMessage(MTIFinancialRequest).
+		Set(FieldSTAN, "000042").
+		Set(FieldExpiry, "3004").
+		Set(FieldAdditionalData, "CV003123")
+
+	resp := req.Response()
+	assert.Equal(t, MTIFinancialResponse, resp.MTI)
+	assert.Equal(t, []int{FieldSTAN}, resp.Fields(), "only identifying fields are echoed")
+}
+
+func TestSubelements(t *testing.T) {
+	packed := PackSubelements(
+		Subelement{TagCVV, ""},
+		Subelement{TagInitiator, "merchant"},
+		Subelement{TagPreviousTransactionID, "ntid-1"},
+	)
+	assert.Equal(t, "IN008merchantTI006ntid-1", packed)
+
+	parsed, err := ParseSubelements(packed)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{TagInitiator: "merchant", TagPreviousTransactionID: "ntid-1"}, parsed)
+
+	_, err = ParseSubelements("CV009123")
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, []byte("first")))
+	require.NoError(t, WriteFrame(&buf, []byte("second")))
+	assert.Equal(t, []byte{0, 5}, buf.Bytes()[:2])
+
+	for _, expected := range []string{"first", "second"} {
+		frame, err := ReadFrame(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(frame))
+	}
+	_, err := ReadFrame(&buf)
+	assert.Equal(t, io.EOF, err, "clean end between frames")
+
+	_, err = ReadFrame(bytes.NewReader([]byte{0, 5, 'a'}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	assert.Error(t, WriteFrame(&buf, make([]byte, MaxFrameLength+1)))
+}
@@ -0,0 +1,169 @@
+// Package journal keeps a repository's changes in an append-only file, so
+// the repository can be rebuilt after a restart.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Journal is an append-only file of JSON entries, one per line, that
+// rebuilds a repository when replayed in order. Every append is synced
+// before it returns, so a change that was acknowledged survives a crash.
+// It is not safe for concurrent use; callers serialise their changes.
+type Journal struct {
+	name string
+	path string
+	file *os.File
+}
+
+// Open replays the journal at path, creating it if needed, passing every
+// line to replay. A last line replay cannot read is taken to be a torn
+// write and skipped; any other is an error. It then rewrites the journal
+// from the entries snapshot writes, so it only grows with changes made from
+// now on.
+// name describes the journal in errors, e.g. "subscription journal".
+func Open(path, name string, replay func(line []byte) error, snapshot func(write func(entry any) error) error) (*Journal, error) {
+	if err := replayJournal(path, name, replay); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{name: name, path: path}
+	if err := j.Rewrite(snapshot); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Rewrite replaces the journal with the entries snapshot writes, so entries
+// that are no longer needed, such as destroyed keys, are gone from disk
+func (j *Journal) Rewrite(snapshot func(write func(entry any) error) error) error {
+	if err := compactJournal(j.path, j.name, snapshot); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", j.name, err)
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	return nil
+}
+
+// Truncate empties the journal, once nothing in it is needed any more
+func (j *Journal) Truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to empty %s: %w", j.name, err)
+	}
+	return nil
+}
+
+// Append writes entry as the journal's next line and syncs it
+func (j *Journal) Append(entry any) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s entry: %w", j.name, err)
+	}
+
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write %s: %w", j.name, err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", j.name, err)
+	}
+	return nil
+}
+
+// Ping checks the journal is still open and is still the file at its path,
+// so changes are not being appended to a file that was deleted or replaced
+func (j *Journal) Ping(ctx context.Context) error {
+	open, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("%s unusable: %w", j.name, err)
+	}
+	onDisk, err := os.Stat(j.file.Name())
+	if err != nil {
+		return fmt.Errorf("%s unusable: %w", j.name, err)
+	}
+	if !os.SameFile(open, onDisk) {
+		return fmt.Errorf("%s %s was replaced", j.name, j.file.Name())
+	}
+	return nil
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
+
+func replayJournal(path, name string, replay func(line []byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	// A crash part way through a write leaves a torn last line, which is
+	// skipped since everything before it is intact. An unreadable line
+	// with more after it is corruption, and replaying past it would
+	// rebuild the repository wrongly.
+	var torn error
+	line := 0
+	for scanner.Scan() {
+		if torn != nil {
+			return fmt.Errorf("%s is corrupt at line %d: %w", name, line, torn)
+		}
+		line++
+		torn = replay(scanner.Bytes())
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if torn != nil {
+		slog.Warn("skipping torn last journal entry", "journal", name, "line", line, "error", torn)
+	}
+	return nil
+}
+
+// compactJournal rewrites the journal from snapshot and swaps it in
+// atomically
+func compactJournal(path, name string, snapshot func(write func(entry any) error) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact %s: %w", name, err)
+	}
+
+	enc := json.NewEncoder(f)
+	err = snapshot(func(entry any) error { return enc.Encode(entry) })
+
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LockFile is the name of the lock file in a storage directory
+const LockFile = ".lock"
+
+// ErrLocked means another process has the storage directory open
+var ErrLocked = errors.New("storage directory is in use by another process")
+
+// Lock holds a storage directory for one process, so two never append to
+// the same journals. It is released by Close, or when the process exits.
+type Lock struct {
+	file *os.File
+}
+
+// LockDir takes the lock on dir, which must exist. It returns ErrLocked
+// without waiting if another process holds it.
+func LockDir(dir string) (*Lock, error) {
+	f, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return &Lock{file: f}, nil
+}
+
+func (l *Lock) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package journal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := LockDir(dir)
+	require.NoError(t, err)
+
+	_, err = LockDir(dir)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, lock.Close())
+	again, err := LockDir(dir)
+	require.NoError(t, err)
+	assert.NoError(t, again.Close())
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useTestLogger(t *testing.T) func() string {
+	logger, buf := newTestLogger(t, Config{Level: slog.LevelDebug})
+
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return buf.String
+}
+
+func TestMiddleware_LogsRequest(t *testing.T) {
+	output := useTestLogger(t)
+
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("bad"))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/payments?card_number="+testPAN, nil))
+
+	out := output()
+	assert.Contains(t, out, `"level":"WARN"`)
+	assert.Contains(t, out, `"msg":"request served"`)
+	assert.Contains(t, out, `"status":400`)
+	assert.Contains(t, out, `"bytes":3`)
+	assert.NotContains(t, out, testPAN)
+}
+
+func TestRecoverer_LogsPanicWithoutCardData(t *testing.T) {
+	output := useTestLogger(t)
+
+	handler := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("cannot charge " + testPAN)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/payments", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	out := output()
+	assert.Contains(t, out, "panic while serving request")
+	assert.Contains(t, out, "cannot charge ************8877")
+	assert.NotContains(t, out, testPAN)
+}
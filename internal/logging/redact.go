@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+)
+
+// redacted replaces values that must never be logged
+const redacted = "[REDACTED]"
+
+var (
+	// panPattern matches 13 to 19 digits, optionally grouped by spaces or
+	// dashes as card numbers are often written. Any such run is treated as a
+	// card number; a false positive only costs a masked log value.
+	panPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+
+	// cvvPattern matches a CVV next to its name, as in JSON bodies, query
+	// strings or key=value text
+	cvvPattern = regexp.MustCompile(`(?i)("?\b(?:cvv2?|cvc2?|security_code)"?\s*[:=]\s*"?)\d{3,4}`)
+
+	// secretPattern matches API keys, webhook secrets and bearer tokens
+	secretPattern = regexp.MustCompile(`(?i)\b(?:[a-z]{2,6}_(?:live|test)_[a-z0-9]{8,}|whsec_[a-f0-9]{16,}|bearer\s+[a-z0-9._~+/-]{8,}=*)`)
+)
+
+// sensitiveKeys are attribute keys whose values are never logged, whatever
+// they look like
+var sensitiveKeys = map[string]bool{
+	"card_number":    true,
+	"cardnumber":     true,
+	"pan":            true,
+	"cvv":            true,
+	"cvc":            true,
+	"security_code":  true,
+	"api_key":        true,
+	"apikey":         true,
+	"authorization":  true,
+	"secret":         true,
+	"password":       true,
+	"x-api-key":      true,
+	"webhook_secret": true,
+}
+
+// Redact masks anything in s that looks like a card number, CVV or secret.
+// Card numbers keep their last four digits so support can still match them
+// against a merchant's records.
+func Redact(s string) string {
+	s = panPattern.ReplaceAllStringFunc(s, maskPAN)
+	s = cvvPattern.ReplaceAllString(s, "${1}***")
+	s = secretPattern.ReplaceAllString(s, redacted)
+	return s
+}
+
+// isSensitiveKey reports whether values logged under key are always hidden
+func isSensitiveKey(key string) bool {
+	return sensitiveKeys[strings.ToLower(key)]
+}
+
+func maskPAN(match string) string {
+	digits := make([]byte, 0, len(match))
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			digits = append(digits, match[i])
+		}
+	}
+
+	return strings.Repeat("*", len(digits)-4) + string(digits[len(digits)-4:])
+}
@@ -0,0 +1,80 @@
+// Package merchant identifies the merchant behind a request from its API
+// key and carries it through a context
+package merchant
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
+)
+
+// Merchant is a known caller of the API along with the limits that apply to
+// it. Limits are the effective ones, with gateway defaults already filled in.
+type Merchant struct {
+	ID string
+	// APIKeyHashes are hex SHA-256 hashes of the merchant's API keys, so
+	// the keys themselves are never configured or held in memory
+	APIKeyHashes []string
+	// RequestsPerSecond and Burst size the token bucket of each API key. A
+	// rate of zero means unlimited.
+	RequestsPerSecond float64
+	Burst             int
+	// MaxConcurrentBankCalls caps the merchant's payments waiting on the
+	// bank at any one time
+	MaxConcurrentBankCalls int
+}
+
+// HashAPIKey returns the hash under which an API key is configured
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// Registry finds merchants by API key. Its merchants can be replaced while
+// it is in use, e.g. on a config reload.
+type Registry struct {
+	byKeyHash atomic.Pointer[map[string]Merchant]
+}
+
+func NewRegistry(merchants []Merchant) *Registry {
+	r := &Registry{}
+	r.Replace(merchants)
+	return r
+}
+
+// Replace swaps every merchant for those given
+func (r *Registry) Replace(merchants []Merchant) {
+	byKeyHash := make(map[string]Merchant)
+	for _, m := range merchants {
+		for _, hash := range m.APIKeyHashes {
+			byKeyHash[hash] = m
+		}
+	}
+	r.byKeyHash.Store(&byKeyHash)
+}
+
+// Authenticate returns the merchant owning apiKey
+func (r *Registry) Authenticate(apiKey string) (Merchant, bool) {
+	m, ok := (*r.byKeyHash.Load())[HashAPIKey(apiKey)]
+	return m, ok
+}
+
+type contextKey struct{}
+
+func WithMerchant(ctx context.Context, m Merchant) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// FromContext returns the authenticated merchant in ctx, if there is one
+func FromContext(ctx context.Context) (Merchant, bool) {
+	m, ok := ctx.Value(contextKey{}).(Merchant)
+	return m, ok
+}
+
+// IDFrom returns the ID of the merchant in ctx, or empty for callers that
+// did not authenticate
+func IDFrom(ctx context.Context) string {
+	m, _ := FromContext(ctx)
+	return m.ID
+}
@@ -0,0 +1,58 @@
+package merchant
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAPIKey(t *testing.T) {
+	// printf %s gw_test_key | sha256sum
+	assert.Equal(t, "dd64f65d484c1e9f334aa12360b5cc4e19690e003743d31d2b4ee2bbc24010cc", HashAPIKey("gw_test_key"))
+}
+
+func TestRegistry_Authenticate(t *testing.T) {
+	registry := NewRegistry([]Merchant{
+		{ID: "merchant-1", APIKeyHashes: []string{HashAPIKey("key-1a"), HashAPIKey("key-1b")}, Burst: 10},
+		{ID: "merchant-2", APIKeyHashes: []string{HashAPIKey("key-2")}},
+	})
+
+	for key, expected := range map[string]string{"key-1a": "merchant-1", "key-1b": "merchant-1", "key-2": "merchant-2"} {
+		m, ok := registry.Authenticate(key)
+		require.True(t, ok, key)
+		assert.Equal(t, expected, m.ID)
+	}
+
+	_, ok := registry.Authenticate("unknown")
+	assert.False(t, ok)
+	_, ok = registry.Authenticate("")
+	assert.False(t, ok)
+}
+
+func TestRegistry_Replace(t *testing.T) {
+	registry := NewRegistry([]Merchant{{ID: "merchant-1", APIKeyHashes: []string{HashAPIKey("old")}, Burst: 10}})
+
+	registry.Replace([]Merchant{{ID: "merchant-1", APIKeyHashes: []string{HashAPIKey("new")}, Burst: 20}})
+
+	_, ok := registry.Authenticate("old")
+	assert.False(t, ok, "a removed key must stop working")
+	m, ok := registry.Authenticate("new")
+	require.True(t, ok)
+	assert.Equal(t, 20, m.Burst)
+}
+
+func TestFromContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	m, ok := FromContext(WithMerchant(context.Background(), Merchant{ID: "merchant-1"}))
+	require.True(t, ok)
+	assert.Equal(t, "merchant-1", m.ID)
+}
+
+func TestIDFrom(t *testing.T) {
+	assert.Empty(t, IDFrom(context.Background()))
+	assert.Equal(t, "merchant-1", IDFrom(WithMerchant(context.Background(), Merchant{ID: "merchant-1"})))
+}
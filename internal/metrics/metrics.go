@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+// unmatchedRoute labels requests that did not match any route, so probing
+// random paths cannot create unbounded label values
+const unmatchedRoute = "unmatched"
+
+// Metrics holds the gateway's Prometheus collectors. Each Metrics has its own
+// registry, so several can exist side by side in tests.
+type Metrics struct {
+	registry *prometheus.Registry
+	acquirer string
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	payments *prometheus.CounterVec
+
+	bankDuration *prometheus.HistogramVec
+	bankErrors   *prometheus.CounterVec
+	bankInFlight prometheus.Gauge
+}
+
+// Option configures Metrics
+type Option func(*Metrics)
+
+// WithAcquirer sets the acquirer label recorded on payment outcomes
+func WithAcquirer(name string) Option {
+	return func(m *Metrics) {
+		m.acquirer = name
+	}
+}
+
+func New(opts ...Option) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		acquirer: "simulator",
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+
+		payments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payments_total",
+			Help:      "Payments processed by the bank, by outcome status, currency and acquirer.",
+		}, []string{"status", "currency", "acquirer"}),
+
+		bankDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "bank_request_duration_seconds",
+			Help:      "Time taken by requests to the acquiring bank, by error class (\"none\" on success).",
+			Buckets:   []float64{0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}, []string{"error_class"}),
+		bankErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bank_errors_total",
+			Help:      "Failed requests to the acquiring bank, by error class.",
+		}, []string{"error_class"}),
+		bankInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bank_requests_in_flight",
+			Help:      "Requests to the acquiring bank waiting for a response.",
+		}),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.payments,
+		m.bankDuration,
+		m.bankErrors,
+		m.bankInFlight,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times every request. It labels requests with the
+// chi route pattern rather than the path, so payment IDs do not each get
+// their own series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		labels := prometheus.Labels{"route": route, "method": r.Method, "status": strconv.Itoa(status)}
+		m.httpRequests.With(labels).Inc()
+		m.httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObservePayment counts a payment outcome. The decline rate is
+// payments_total{status="Declined"} over all payments_total.
+func (m *Metrics) ObservePayment(payment *domain.Payment) {
+	m.payments.WithLabelValues(string(payment.Status), payment.Currency, m.acquirer).Inc()
+}
+
+// BankCallStarted implements client.Observer
+func (m *Metrics) BankCallStarted() {
+	m.bankInFlight.Inc()
+}
+
+// BankCallFinished implements client.Observer
+func (m *Metrics) BankCallFinished(duration time.Duration, err error) {
+	m.bankInFlight.Dec()
+
+	class := client.ErrorClass(err)
+	m.bankDuration.WithLabelValues(class).Observe(duration.Seconds())
+	if err != nil {
+		m.bankErrors.WithLabelValues(class).Inc()
+	}
+}
+
+// WatchRepositorySize reports the number of stored payments, read from size
+// on every scrape
+func (m *Metrics) WatchRepositorySize(size func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payments_stored",
+		Help:      "Payments held in the repository.",
+	}, func() float64 {
+		return float64(size())
+	}))
+}
+
+// WatchPaymentQueue reports the asynchronous payments queued or being sent,
+// read from length on every scrape
+func (m *Metrics) WatchPaymentQueue(length func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "payments_queued",
+		Help:      "Asynchronous payments waiting to be sent to the acquiring bank.",
+	}, func() float64 {
+		return float64(length())
+	}))
+}
+
+// WatchCircuitBreaker reports the bank circuit breaker's state, read from
+// state on every scrape: 0 closed, 1 half-open, 2 open
+func (m *Metrics) WatchCircuitBreaker(state func() client.CircuitState) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "bank_circuit_breaker_state",
+		Help:      "State of the bank circuit breaker: 0 closed, 1 half-open, 2 open.",
+	}, func() float64 {
+		return float64(state())
+	}))
+}
+
+// BankConcurrency is the adaptive limit on bank calls, see
+// client.AdaptiveLimiter
+type BankConcurrency interface {
+	Limit() int
+	Queued() int
+	Shed() uint64
+}
+
+// WatchBankConcurrency reports the adaptive limit on bank calls, the calls
+// queued for it and those shed, read from l on every scrape
+func (m *Metrics) WatchBankConcurrency(l BankConcurrency) {
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bank_concurrency_limit",
+			Help:      "Current adaptive limit on requests to the acquiring bank in flight.",
+		}, func() float64 {
+			return float64(l.Limit())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "bank_requests_queued",
+			Help:      "Payments waiting for a slot to call the acquiring bank.",
+		}, func() float64 {
+			return float64(l.Queued())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bank_requests_shed_total",
+			Help:      "Payments turned away because the acquiring bank could not take them in time.",
+		}, func() float64 {
+			return float64(l.Shed())
+		}),
+	)
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetrics_Middleware_LabelsByRoutePattern(t *testing.T) {
+	m := New()
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/api/payments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Post("/api/payments", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+
+	for _, path := range []string{"/api/payments/a", "/api/payments/b", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/payments", nil))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("/api/payments/{id}", "GET", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("/api/payments", "POST", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.httpRequests.WithLabelValues(unmatchedRoute, "GET", "404")))
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `gateway_http_request_duration_seconds_count{method="GET",route="/api/payments/{id}",status="404"} 2`)
+	assert.NotContains(t, body, "/api/payments/a")
+}
+
+func TestMetrics_ObservePayment(t *testing.T) {
+	m := New(WithAcquirer("test-bank"))
+
+	m.ObservePayment(&domain.Payment{Status: domain.StatusAuthorized, Currency: "GBP"})
+	m.ObservePayment(&domain.Payment{Status: domain.StatusDeclined, Currency: "GBP"})
+	m.ObservePayment(&domain.Payment{Status: domain.StatusDeclined, Currency: "GBP"})
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.payments.WithLabelValues("Authorized", "GBP", "test-bank")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.payments.WithLabelValues("Declined", "GBP", "test-bank")))
+}
+
+func TestMetrics_BankCalls(t *testing.T) {
+	m := New()
+
+	m.BankCallStarted()
+	m.BankCallStarted()
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.bankInFlight))
+
+	m.BankCallFinished(50*time.Millisecond, nil)
+	m.BankCallFinished(time.Second, client.ErrBankUnavailable)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.bankInFlight))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.bankErrors.WithLabelValues("unavailable")))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.bankErrors), "a success is not an error")
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `gateway_bank_request_duration_seconds_count{error_class="none"} 1`)
+	assert.Contains(t, body, `gateway_bank_request_duration_seconds_count{error_class="unavailable"} 1`)
+}
+
+func TestMetrics_Watchers(t *testing.T) {
+	m := New()
+
+	size := 3
+	queued := 2
+	state := client.CircuitOpen
+	m.WatchRepositorySize(func() int { return size })
+	m.WatchPaymentQueue(func() int { return queued })
+	m.WatchCircuitBreaker(func() client.CircuitState { return state })
+
+	body := scrape(t, m)
+	assert.Contains(t, body, "gateway_payments_stored 3")
+	assert.Contains(t, body, "gateway_payments_queued 2")
+	assert.Contains(t, body, "gateway_bank_circuit_breaker_state 2")
+
+	size = 4
+	state = client.CircuitClosed
+
+	body = scrape(t, m)
+	assert.Contains(t, body, "gateway_payments_stored 4")
+	assert.Contains(t, body, "gateway_bank_circuit_breaker_state 0")
+}
+
+type fixedConcurrency struct{}
+
+func (fixedConcurrency) Limit() int   { return 12 }
+func (fixedConcurrency) Queued() int  { return 3 }
+func (fixedConcurrency) Shed() uint64 { return 7 }
+
+func TestMetrics_WatchBankConcurrency(t *testing.T) {
+	m := New()
+	m.WatchBankConcurrency(fixedConcurrency{})
+
+	body := scrape(t, m)
+	assert.Contains(t, body, "gateway_bank_concurrency_limit 12")
+	assert.Contains(t, body, "gateway_bank_requests_queued 3")
+	assert.Contains(t, body, "gateway_bank_requests_shed_total 7")
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PaymentBatchCounts struct {
+	Total      int `json:"total" example:"100"`     // Payments in the batch
+	Pending    int `json:"pending" example:"40"`    // Payments not processed yet
+	Authorized int `json:"authorized" example:"55"` // Payments the bank authorized
+	Declined   int `json:"declined" example:"3"`    // Payments the bank declined
+	Failed     int `json:"failed" example:"2"`      // Invalid payments and payments the bank could not be reached for
+	Canceled   int `json:"canceled" example:"0"`    // Payments never sent because the batch was canceled
+}
+
+type PaymentBatchResponse struct {
+	ID          string             `json:"id" example:"5b1f0c2e-7a4d-4e8b-9c3f-2d6a8e0b1c4f"`                 // Unique batch ID
+	Status      string             `json:"status" example:"processing" enums:"processing,completed,canceled"` // Batch status
+	Counts      PaymentBatchCounts `json:"counts"`                                                            // Progress so far
+	CreatedAt   time.Time          `json:"created_at"`                                                        // When the batch was uploaded
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`                                            // When the batch's last payment was done
+}
+
+// PaymentBatchItemResponse is one line of a batch's results
+type PaymentBatchItemResponse struct {
+	Index         int    `json:"index" example:"0"`                                                         // Position of the payment in the upload, from 0
+	Status        string `json:"status" example:"succeeded" enums:"pending,succeeded,failed,canceled"`      // Whether the payment was made
+	PaymentID     string `json:"payment_id,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"`       // Payment created for the item
+	PaymentStatus string `json:"payment_status,omitempty" example:"Authorized" enums:"Authorized,Declined"` // Bank's decision
+	DeclineReason string `json:"decline_reason,omitempty" example:"insufficient_funds"`                     // Why the bank declined the payment, when it said
+	Error         string `json:"error,omitempty" example:"card number must be between 14-19 digits"`        // Why the payment could not be made
+}
+
+func FromDomainPaymentBatch(batch *domain.PaymentBatch) *PaymentBatchResponse {
+	counts := batch.Counts()
+
+	response := &PaymentBatchResponse{
+		ID:     batch.ID,
+		Status: string(batch.Status),
+		Counts: PaymentBatchCounts{
+			Total:      counts.Total,
+			Pending:    counts.Pending,
+			Authorized: counts.Authorized,
+			Declined:   counts.Declined,
+			Failed:     counts.Failed,
+			Canceled:   counts.Canceled,
+		},
+		CreatedAt: batch.CreatedAt,
+	}
+	if !batch.CompletedAt.IsZero() {
+		completedAt := batch.CompletedAt
+		response.CompletedAt = &completedAt
+	}
+
+	return response
+}
+
+// FromDomainBatchItem describes an item's result. errorMessage is what the
+// merchant is told about item.Err, which may not be safe to show as is.
+func FromDomainBatchItem(item domain.BatchItem, errorMessage string) PaymentBatchItemResponse {
+	return PaymentBatchItemResponse{
+		Index:         item.Index,
+		Status:        string(item.Status),
+		PaymentID:     item.PaymentID,
+		PaymentStatus: string(item.PaymentStatus),
+		DeclineReason: string(item.DeclineReason),
+		Error:         errorMessage,
+	}
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PostBlockedCardRequest struct {
+	CardNumber string         `json:"card_number,omitempty" example:"2222405343248877" validate:"required_without=Source,min=14,max=19,numeric"` // Card number to block (14-19 digits, numeric only)
+	Source     *PaymentSource `json:"source,omitempty"`                                                                                          // Stored card to block instead of a card number
+	Reason     string         `json:"reason,omitempty" example:"chargeback"`                                                                     // Why the card is blocked
+}
+
+type BlockedCardResponse struct {
+	ID                 string    `json:"id" example:"3e8c1a2b-7d4f-4b6e-9a05-1c2d3e4f5a6b"` // Unique blocklist entry ID
+	CardNumberLastFour string    `json:"card_number_last_four" example:"8877"`              // Last 4 digits of the blocked card
+	Reason             string    `json:"reason,omitempty" example:"chargeback"`             // Why the card is blocked
+	CreatedAt          time.Time `json:"created_at"`                                        // When the card was blocked
+}
+
+func (r *PostBlockedCardRequest) ToDomainCard() domain.Card {
+	card := domain.Card{Number: r.CardNumber}
+	if r.Source != nil {
+		card.Token = r.Source.Token
+	}
+	return card
+}
+
+func FromDomainBlockedCard(card *domain.BlockedCard) *BlockedCardResponse {
+	return &BlockedCardResponse{
+		ID:                 card.ID,
+		CardNumberLastFour: card.LastFour,
+		Reason:             card.Reason,
+		CreatedAt:          card.CreatedAt,
+	}
+}
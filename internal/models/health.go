@@ -0,0 +1,33 @@
+package models
+
+import (
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/health"
+)
+
+type HealthResponse struct {
+	Status     string                     `json:"status" example:"up" enums:"up,down,draining"` // Overall status; only up means ready for traffic
+	Components map[string]ComponentHealth `json:"components,omitempty"`                         // Status of each dependency, by name
+}
+
+type ComponentHealth struct {
+	Status     string `json:"status" example:"down" enums:"up,down"`      // Whether the dependency is usable
+	Error      string `json:"error,omitempty" example:"bank unreachable"` // Why the check failed
+	DurationMs int64  `json:"duration_ms" example:"3"`                    // How long the check took
+}
+
+func FromHealthReport(report health.Report) *HealthResponse {
+	response := &HealthResponse{
+		Status:     string(report.Status),
+		Components: make(map[string]ComponentHealth, len(report.Components)),
+	}
+
+	for _, c := range report.Components {
+		response.Components[c.Name] = ComponentHealth{
+			Status:     string(c.Status),
+			Error:      c.Error,
+			DurationMs: c.Duration.Milliseconds(),
+		}
+	}
+
+	return response
+}
@@ -5,39 +5,105 @@ import (
 )
 
 type PostPaymentRequest struct {
-	CardNumber  string `json:"card_number" example:"2222405343248877" validate:"required,min=14,max=19,numeric"`
-	ExpiryMonth int    `json:"expiry_month" example:"12" validate:"required,min=1,max=12"`
-	ExpiryYear  int    `json:"expiry_year" example:"2026" validate:"required"`
-	Currency    string `json:"currency" example:"GBP" validate:"required,len=3,oneof=USD GBP EUR"`
-	Amount      int    `json:"amount" example:"100" validate:"required,min=1"`
-	CVV         string `json:"cvv" example:"123" validate:"required,min=3,max=4,numeric"`
+	CardNumber  string         `json:"card_number,omitempty" example:"2222405343248877" validate:"required_without=Source,min=14,max=19,numeric"`
+	ExpiryMonth int            `json:"expiry_month,omitempty" example:"12" validate:"required_without=Source,min=1,max=12"`
+	ExpiryYear  int            `json:"expiry_year,omitempty" example:"2026" validate:"required_without=Source"`
+	Source      *PaymentSource `json:"source,omitempty"` // Stored card to charge instead of raw card details
+	Currency    string         `json:"currency" example:"GBP" validate:"required,len=3,oneof=USD GBP EUR"`
+	Amount      int            `json:"amount" example:"100" validate:"required,min=1"`
+	CVV         string         `json:"cvv,omitempty" example:"123" validate:"required_unless=Initiator merchant,min=3,max=4,numeric"`
+
+	Initiator        string                   `json:"initiator,omitempty" example:"customer" enums:"customer,merchant"` // Who initiated the payment, defaults to customer
+	StoredCredential *StoredCredentialRequest `json:"stored_credential,omitempty"`                                      // Required for merchant-initiated payments
+}
+
+type StoredCredentialRequest struct {
+	Usage                        string `json:"usage" example:"subsequent" enums:"first,subsequent" validate:"required"`    // First or subsequent use of the stored card
+	Type                         string `json:"type" example:"recurring" enums:"recurring,unscheduled" validate:"required"` // Agreement the card is stored under
+	PreviousNetworkTransactionID string `json:"previous_network_transaction_id,omitempty" example:"a1b2c3d4"`               // Network transaction ID of the payment that set up the credential
+}
+
+type PaymentSource struct {
+	Token string `json:"token" example:"tok_4f1c2b8e9d0a4b6c8e2f1a3b5c7d9e0f" validate:"required"` // Token returned by POST /api/tokens
 }
 
 type PostPaymentResponse struct {
 	ID                 string `json:"id" example:"550e8400-e29b-41d4-a716-446655440000"`
-	Status             string `json:"status" example:"Authorized" enums:"Authorized,Declined,Rejected"`
+	Status             string `json:"status" example:"Authorized" enums:"Authorized,Declined,Rejected,Pending"`
 	CardNumberLastFour string `json:"card_number_last_four" example:"8877"`
 	ExpiryMonth        int    `json:"expiry_month" example:"12"`
 	ExpiryYear         int    `json:"expiry_year" example:"2026"`
 	Currency           string `json:"currency" example:"GBP"`
 	Amount             int    `json:"amount" example:"100"`
+
+	NetworkTransactionID string `json:"network_transaction_id,omitempty" example:"a1b2c3d4"`   // Reference for later merchant-initiated charges
+	DeclineReason        string `json:"decline_reason,omitempty" example:"insufficient_funds"` // Why the bank declined the payment, when it said
+
+	RequestID     string `json:"request_id,omitempty" example:"0f8fad5b-d9cb-469f-a165-70867728950e"` // ID of the request that created the payment
+	CorrelationID string `json:"correlation_id,omitempty" example:"order-1234"`                       // X-Correlation-ID sent when the payment was created
 }
 
 type GetPaymentResponse struct {
 	ID                 string `json:"id" example:"550e8400-e29b-41d4-a716-446655440000"`
-	Status             string `json:"status" example:"Authorized" enums:"Authorized,Declined"`
+	Status             string `json:"status" example:"Authorized" enums:"Authorized,Declined,Rejected,Pending,Captured,Voided,Refunded"`
 	CardNumberLastFour string `json:"card_number_last_four" example:"8877"`
 	ExpiryMonth        int    `json:"expiry_month" example:"12"`
 	ExpiryYear         int    `json:"expiry_year" example:"2026"`
 	Currency           string `json:"currency" example:"GBP"`
 	Amount             int    `json:"amount" example:"100"`
+
+	Initiator            string `json:"initiator,omitempty" example:"customer"`                // Who initiated the payment
+	NetworkTransactionID string `json:"network_transaction_id,omitempty" example:"a1b2c3d4"`   // Reference for later merchant-initiated charges
+	DeclineReason        string `json:"decline_reason,omitempty" example:"insufficient_funds"` // Why the bank declined the payment, when it said
+	CapturedAmount       int    `json:"captured_amount,omitempty" example:"100"`               // How much of the amount was taken from the card
+	RefundedAmount       int    `json:"refunded_amount,omitempty" example:"40"`                // How much of the captured amount was given back
+
+	RequestID     string `json:"request_id,omitempty" example:"0f8fad5b-d9cb-469f-a165-70867728950e"` // ID of the request that created the payment
+	CorrelationID string `json:"correlation_id,omitempty" example:"order-1234"`                       // X-Correlation-ID sent when the payment was created
+}
+
+// SettlePaymentRequest is the body of a capture or refund
+type SettlePaymentRequest struct {
+	Amount int `json:"amount,omitempty" example:"100" validate:"min=1"` // Amount in minor currency units; all that is left when omitted
+}
+
+type PaymentListResponse struct {
+	Data              []*GetPaymentResponse `json:"data"`                                                                         // Payments, newest first
+	HasMore           bool                  `json:"has_more" example:"true"`                                                      // Whether there are older payments
+	NextStartingAfter string                `json:"next_starting_after,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"` // Pass as starting_after to get the next page
 }
 
 type ErrorResponse struct {
-	Error string `json:"error" example:"card number must be between 14-19 digits"` // Error message
+	Error     string `json:"error" example:"card number must be between 14-19 digits"`            // Error message
+	RequestID string `json:"request_id,omitempty" example:"0f8fad5b-d9cb-469f-a165-70867728950e"` // ID of the failed request, also in the X-Request-ID header
 }
 
 func (r *PostPaymentRequest) ToDomainPayment() (*domain.Payment, error) {
+	opts := []domain.PaymentOption{}
+	if r.Initiator != "" {
+		opts = append(opts, domain.WithInitiator(domain.Initiator(r.Initiator)))
+	}
+	if sc := r.StoredCredential; sc != nil {
+		opts = append(opts, domain.WithStoredCredential(&domain.StoredCredential{
+			Usage:                        domain.StoredCredentialUsage(sc.Usage),
+			Type:                         domain.StoredCredentialType(sc.Type),
+			PreviousNetworkTransactionID: sc.PreviousNetworkTransactionID,
+		}))
+	}
+
+	if r.Source != nil {
+		if r.CardNumber != "" || r.ExpiryMonth != 0 || r.ExpiryYear != 0 {
+			return nil, domain.ErrCardSourceConflict
+		}
+
+		card := domain.Card{
+			Token: r.Source.Token,
+			CVV:   r.CVV,
+		}
+
+		return domain.NewPayment(card, r.Currency, r.Amount, opts...)
+	}
+
 	card := domain.Card{
 		Number:      r.CardNumber,
 		ExpiryMonth: r.ExpiryMonth,
@@ -45,7 +111,7 @@ func (r *PostPaymentRequest) ToDomainPayment() (*domain.Payment, error) {
 		CVV:         r.CVV,
 	}
 
-	return domain.NewPayment(card, r.Currency, r.Amount)
+	return domain.NewPayment(card, r.Currency, r.Amount, opts...)
 }
 
 func FromDomainPayment(payment *domain.Payment) *PostPaymentResponse {
@@ -60,6 +126,12 @@ func FromDomainPayment(payment *domain.Payment) *PostPaymentResponse {
 		ExpiryYear:         payment.Card.ExpiryYear,
 		Currency:           payment.Currency,
 		Amount:             payment.Amount,
+
+		NetworkTransactionID: payment.NetworkTransactionID,
+		DeclineReason:        string(payment.DeclineReason),
+
+		RequestID:     payment.RequestID,
+		CorrelationID: payment.CorrelationID,
 	}
 }
 
@@ -74,5 +146,28 @@ func ToGetPaymentResponse(payment *domain.Payment) *GetPaymentResponse {
 		ExpiryYear:         payment.Card.ExpiryYear,
 		Currency:           payment.Currency,
 		Amount:             payment.Amount,
+
+		Initiator:            string(payment.Initiator),
+		NetworkTransactionID: payment.NetworkTransactionID,
+		DeclineReason:        string(payment.DeclineReason),
+		CapturedAmount:       payment.CapturedAmount,
+		RefundedAmount:       payment.RefundedAmount,
+
+		RequestID:     payment.RequestID,
+		CorrelationID: payment.CorrelationID,
+	}
+}
+
+func FromDomainPaymentPage(page *domain.PaymentPage) *PaymentListResponse {
+	resp := &PaymentListResponse{
+		Data:    make([]*GetPaymentResponse, len(page.Payments)),
+		HasMore: page.HasMore,
+	}
+	for i, payment := range page.Payments {
+		resp.Data[i] = ToGetPaymentResponse(payment)
+	}
+	if page.HasMore && len(page.Payments) > 0 {
+		resp.NextStartingAfter = page.Payments[len(page.Payments)-1].ID
 	}
+	return resp
 }
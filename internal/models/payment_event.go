@@ -0,0 +1,76 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PaymentEventResponse struct {
+	ID         string                   `json:"id" example:"9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0"`                   // Unique event ID
+	Sequence   int                      `json:"sequence" example:"2"`                                                // Position in the payment's history, from 1
+	Type       string                   `json:"type" example:"payment.authorized"`                                   // What happened
+	OccurredAt time.Time                `json:"occurred_at"`                                                         // When it happened
+	Actor      string                   `json:"actor" example:"api_client:203.0.113.7"`                              // Who made the change
+	RequestID  string                   `json:"request_id,omitempty" example:"0f8fad5b-d9cb-469f-a165-70867728950e"` // Request that made the change
+	Data       PaymentEventDataResponse `json:"data"`                                                                // What changed
+}
+
+type PaymentEventDataResponse struct {
+	CardToken            string                   `json:"card_token,omitempty" example:"tok_3f9a2b"`             // Vault token the payment was made with
+	CardNumberLastFour   string                   `json:"card_number_last_four,omitempty" example:"8877"`        // Last four digits of the card number
+	ExpiryMonth          int                      `json:"expiry_month,omitempty" example:"4"`                    // Card expiry month
+	ExpiryYear           int                      `json:"expiry_year,omitempty" example:"2030"`                  // Card expiry year
+	Currency             string                   `json:"currency,omitempty" example:"GBP"`                      // Currency code
+	Amount               int                      `json:"amount,omitempty" example:"100"`                        // Amount in minor currency units
+	Initiator            string                   `json:"initiator,omitempty" example:"customer"`                // Who started the payment
+	StoredCredential     *StoredCredentialRequest `json:"stored_credential,omitempty"`                           // Stored card agreement
+	Status               string                   `json:"status,omitempty" example:"Authorized"`                 // Status the payment moved to
+	NetworkTransactionID string                   `json:"network_transaction_id,omitempty" example:"a1b2c3d4"`   // Network transaction ID assigned by the bank
+	DeclineReason        string                   `json:"decline_reason,omitempty" example:"insufficient_funds"` // Why the bank declined the payment
+	CapturedAmount       int                      `json:"captured_amount,omitempty" example:"100"`               // How much of the amount was taken from the card
+	RefundedAmount       int                      `json:"refunded_amount,omitempty" example:"40"`                // How much of the captured amount was given back
+	CorrelationID        string                   `json:"correlation_id,omitempty" example:"order-1234"`         // X-Correlation-ID sent by the merchant
+}
+
+func FromDomainPaymentEvents(events []domain.PaymentEvent) []PaymentEventResponse {
+	resp := make([]PaymentEventResponse, len(events))
+
+	for i, e := range events {
+		d := e.Data
+		data := PaymentEventDataResponse{
+			CardToken:            d.CardToken,
+			CardNumberLastFour:   d.CardLastFour,
+			ExpiryMonth:          d.ExpiryMonth,
+			ExpiryYear:           d.ExpiryYear,
+			Currency:             d.Currency,
+			Amount:               d.Amount,
+			Initiator:            string(d.Initiator),
+			Status:               string(d.Status),
+			NetworkTransactionID: d.NetworkTransactionID,
+			DeclineReason:        string(d.DeclineReason),
+			CapturedAmount:       d.CapturedAmount,
+			RefundedAmount:       d.RefundedAmount,
+			CorrelationID:        d.CorrelationID,
+		}
+		if d.StoredCredential != nil {
+			data.StoredCredential = &StoredCredentialRequest{
+				Usage:                        string(d.StoredCredential.Usage),
+				Type:                         string(d.StoredCredential.Type),
+				PreviousNetworkTransactionID: d.StoredCredential.PreviousNetworkTransactionID,
+			}
+		}
+
+		resp[i] = PaymentEventResponse{
+			ID:         e.ID,
+			Sequence:   e.Sequence,
+			Type:       string(e.Type),
+			OccurredAt: e.OccurredAt,
+			Actor:      e.Actor,
+			RequestID:  e.RequestID,
+			Data:       data,
+		}
+	}
+
+	return resp
+}
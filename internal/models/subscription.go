@@ -0,0 +1,125 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PostPlanRequest struct {
+	Name          string `json:"name" example:"Pro monthly" validate:"required"`                           // Plan name
+	Amount        int    `json:"amount" example:"999" validate:"required,min=1"`                           // Amount per period in minor currency units
+	Currency      string `json:"currency" example:"GBP" validate:"required,len=3,oneof=USD GBP EUR"`       // Currency code (USD, GBP, or EUR)
+	Interval      string `json:"interval" example:"month" enums:"day,week,month,year" validate:"required"` // Billing interval unit
+	IntervalCount int    `json:"interval_count,omitempty" example:"1" validate:"min=0"`                    // Number of intervals per period, defaults to 1
+}
+
+type PlanResponse struct {
+	ID            string    `json:"id" example:"8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"` // Unique plan ID
+	Name          string    `json:"name" example:"Pro monthly"`                        // Plan name
+	Amount        int       `json:"amount" example:"999"`                              // Amount per period in minor currency units
+	Currency      string    `json:"currency" example:"GBP"`                            // Currency code
+	Interval      string    `json:"interval" example:"month"`                          // Billing interval unit
+	IntervalCount int       `json:"interval_count" example:"1"`                        // Number of intervals per period
+	CreatedAt     time.Time `json:"created_at"`                                        // When the plan was created
+}
+
+type PostSubscriptionRequest struct {
+	PlanID string         `json:"plan_id" example:"8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11" validate:"required"` // Plan to subscribe to
+	Source *PaymentSource `json:"source" validate:"required"`                                                 // Stored card to charge
+	CVV    string         `json:"cvv" example:"123" validate:"required,min=3,max=4,numeric"`                  // CVV for the first, customer-initiated charge
+}
+
+type CancelSubscriptionRequest struct {
+	AtPeriodEnd bool `json:"at_period_end" example:"true"` // Keep the subscription until the paid period ends
+}
+
+type ChangePlanRequest struct {
+	PlanID string `json:"plan_id" example:"8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11" validate:"required"` // Plan to move to
+}
+
+type SubscriptionChargeResponse struct {
+	PaymentID string    `json:"payment_id,omitempty" example:"550e8400-e29b-41d4-a716-446655440000"` // Payment created for the charge
+	Amount    int       `json:"amount" example:"999"`                                                // Amount in minor currency units
+	Currency  string    `json:"currency" example:"GBP"`                                              // Currency code
+	Reason    string    `json:"reason" example:"renewal" enums:"initial,renewal,proration"`          // Why the charge was made
+	Status    string    `json:"status" example:"Authorized" enums:"Authorized,Declined,Rejected"`    // Payment status
+	Attempt   int       `json:"attempt" example:"1"`                                                 // Attempt number within the dunning cycle
+	Error     string    `json:"error,omitempty" example:"bank service unavailable"`                  // Why the payment could not be made
+	CreatedAt time.Time `json:"created_at"`                                                          // When the charge was attempted
+}
+
+type SubscriptionResponse struct {
+	ID                 string                       `json:"id" example:"1f0e3a4b-8c6d-4e2f-9a1b-3c5d7e9f0a2b"`                           // Unique subscription ID
+	PlanID             string                       `json:"plan_id" example:"8a7c2c5e-3f0d-4a59-9d8b-6b1f7d2e4c11"`                      // Current plan
+	Status             string                       `json:"status" example:"active" enums:"active,past_due,paused,canceled,reconciling"` // Subscription status
+	CurrentPeriodStart time.Time                    `json:"current_period_start"`                                                        // Start of the paid period
+	CurrentPeriodEnd   time.Time                    `json:"current_period_end"`                                                          // End of the paid period
+	NextChargeAt       *time.Time                   `json:"next_charge_at,omitempty"`                                                    // Next renewal or retry
+	FailedAttempts     int                          `json:"failed_attempts" example:"0"`                                                 // Consecutive failed renewals
+	Credit             int                          `json:"credit" example:"0"`                                                          // Proration credit taken off the next renewals
+	CancelAtPeriodEnd  bool                         `json:"cancel_at_period_end" example:"false"`                                        // Whether the subscription ends with the current period
+	CanceledAt         *time.Time                   `json:"canceled_at,omitempty"`                                                       // When the subscription was canceled
+	PausedAt           *time.Time                   `json:"paused_at,omitempty"`                                                         // When the subscription was paused
+	Charges            []SubscriptionChargeResponse `json:"charges"`                                                                     // Every charge attempt, oldest first
+	CreatedAt          time.Time                    `json:"created_at"`                                                                  // When the subscription was created
+}
+
+func (r *PostPlanRequest) ToDomainPlan() *domain.Plan {
+	return &domain.Plan{
+		Name:          r.Name,
+		Amount:        r.Amount,
+		Currency:      r.Currency,
+		Interval:      domain.Interval(r.Interval),
+		IntervalCount: r.IntervalCount,
+	}
+}
+
+func FromDomainPlan(plan *domain.Plan) *PlanResponse {
+	return &PlanResponse{
+		ID:            plan.ID,
+		Name:          plan.Name,
+		Amount:        plan.Amount,
+		Currency:      plan.Currency,
+		Interval:      string(plan.Interval),
+		IntervalCount: plan.IntervalCount,
+		CreatedAt:     plan.CreatedAt,
+	}
+}
+
+func FromDomainSubscription(sub *domain.Subscription) *SubscriptionResponse {
+	resp := &SubscriptionResponse{
+		ID:                 sub.ID,
+		PlanID:             sub.PlanID,
+		Status:             string(sub.Status),
+		CurrentPeriodStart: sub.CurrentPeriodStart,
+		CurrentPeriodEnd:   sub.CurrentPeriodEnd,
+		FailedAttempts:     sub.FailedAttempts,
+		Credit:             sub.Credit,
+		CancelAtPeriodEnd:  sub.CancelAtPeriodEnd,
+		CanceledAt:         sub.CanceledAt,
+		PausedAt:           sub.PausedAt,
+		Charges:            make([]SubscriptionChargeResponse, 0, len(sub.Charges)),
+		CreatedAt:          sub.CreatedAt,
+	}
+
+	if sub.IsBillable() {
+		next := sub.NextChargeAt
+		resp.NextChargeAt = &next
+	}
+
+	for _, c := range sub.Charges {
+		resp.Charges = append(resp.Charges, SubscriptionChargeResponse{
+			PaymentID: c.PaymentID,
+			Amount:    c.Amount,
+			Currency:  c.Currency,
+			Reason:    string(c.Reason),
+			Status:    string(c.Status),
+			Attempt:   c.Attempt,
+			Error:     c.Error,
+			CreatedAt: c.CreatedAt,
+		})
+	}
+
+	return resp
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PostTokenRequest struct {
+	CardNumber  string `json:"card_number" example:"2222405343248877" validate:"required,min=14,max=19,numeric"` // Full card number (14-19 digits, numeric only)
+	ExpiryMonth int    `json:"expiry_month" example:"12" validate:"required,min=1,max=12"`                       // Expiry month (1-12)
+	ExpiryYear  int    `json:"expiry_year" example:"2026" validate:"required"`                                   // Expiry year (must be in future)
+}
+
+type PostTokenResponse struct {
+	Token              string `json:"token" example:"tok_4f1c2b8e9d0a4b6c8e2f1a3b5c7d9e0f"` // Opaque token to use as source.token
+	CardNumberLastFour string `json:"card_number_last_four" example:"8877"`                 // Last 4 digits of card
+	ExpiryMonth        int    `json:"expiry_month" example:"12"`                            // Expiry month
+	ExpiryYear         int    `json:"expiry_year" example:"2026"`                           // Expiry year
+}
+
+func (r *PostTokenRequest) ToDomainCard() domain.Card {
+	return domain.Card{
+		Number:      r.CardNumber,
+		ExpiryMonth: r.ExpiryMonth,
+		ExpiryYear:  r.ExpiryYear,
+	}
+}
+
+func FromDomainCard(card *domain.Card) *PostTokenResponse {
+	return &PostTokenResponse{
+		Token:              card.Token,
+		CardNumberLastFour: card.GetLastFourDigits(),
+		ExpiryMonth:        card.ExpiryMonth,
+		ExpiryYear:         card.ExpiryYear,
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+type PostWebhookEndpointRequest struct {
+	URL        string   `json:"url" example:"https://merchant.example.com/webhooks" validate:"required,url"` // Where deliveries are POSTed
+	EventTypes []string `json:"event_types,omitempty" example:"payment.authorized,payment.declined"`         // Events to receive; all of them when empty
+}
+
+type WebhookEndpointResponse struct {
+	ID         string    `json:"id" example:"0b6f7c1e-9a3d-4f2b-8e51-2c7d9a4b6e10"`         // Unique endpoint ID
+	URL        string    `json:"url" example:"https://merchant.example.com/webhooks"`       // Where deliveries are POSTed
+	EventTypes []string  `json:"event_types" example:"payment.authorized,payment.declined"` // Events the endpoint receives; all of them when empty
+	Secret     string    `json:"secret,omitempty" example:"whsec_3f9a..."`                  // HMAC-SHA256 signing secret, only returned when the endpoint is created
+	CreatedAt  time.Time `json:"created_at"`                                                // When the endpoint was registered
+}
+
+type DeliveryAttemptResponse struct {
+	At         time.Time `json:"at"`                                                           // When the request was sent
+	StatusCode int       `json:"status_code,omitempty" example:"500"`                          // HTTP status returned by the endpoint
+	Error      string    `json:"error,omitempty" example:"endpoint responded with status 500"` // Why the attempt failed
+	DurationMs int64     `json:"duration_ms" example:"84"`                                     // How long the request took
+}
+
+type WebhookDeliveryResponse struct {
+	ID            string                    `json:"id" example:"5c1d2e3f-4a5b-6c7d-8e9f-0a1b2c3d4e5f"`           // Unique delivery ID
+	EventID       string                    `json:"event_id" example:"evt_7d9e0f1a-2b3c-4d5e-6f70-8192a3b4c5d6"` // Event being delivered
+	EndpointID    string                    `json:"endpoint_id" example:"0b6f7c1e-9a3d-4f2b-8e51-2c7d9a4b6e10"`  // Endpoint it is delivered to
+	Status        string                    `json:"status" example:"pending" enums:"pending,succeeded,dead"`     // Delivery status; dead deliveries are in the dead-letter queue
+	Failures      int                       `json:"failures" example:"2"`                                        // Consecutive failed attempts
+	NextAttemptAt *time.Time                `json:"next_attempt_at,omitempty"`                                   // When a pending delivery is tried next
+	Attempts      []DeliveryAttemptResponse `json:"attempts"`                                                    // Every attempt, oldest first
+	CreatedAt     time.Time                 `json:"created_at"`                                                  // When the event was queued
+}
+
+func (r *PostWebhookEndpointRequest) DomainEventTypes() []domain.EventType {
+	types := make([]domain.EventType, len(r.EventTypes))
+	for i, t := range r.EventTypes {
+		types[i] = domain.EventType(t)
+	}
+	return types
+}
+
+// FromDomainWebhookEndpoint leaves out the secret, which is only shown once
+func FromDomainWebhookEndpoint(endpoint *domain.WebhookEndpoint) *WebhookEndpointResponse {
+	types := make([]string, len(endpoint.EventTypes))
+	for i, t := range endpoint.EventTypes {
+		types[i] = string(t)
+	}
+
+	return &WebhookEndpointResponse{
+		ID:         endpoint.ID,
+		URL:        endpoint.URL,
+		EventTypes: types,
+		CreatedAt:  endpoint.CreatedAt,
+	}
+}
+
+func FromDomainWebhookDelivery(delivery *domain.WebhookDelivery) *WebhookDeliveryResponse {
+	resp := &WebhookDeliveryResponse{
+		ID:         delivery.ID,
+		EventID:    delivery.EventID,
+		EndpointID: delivery.EndpointID,
+		Status:     string(delivery.Status),
+		Failures:   delivery.Failures,
+		Attempts:   make([]DeliveryAttemptResponse, 0, len(delivery.Attempts)),
+		CreatedAt:  delivery.CreatedAt,
+	}
+
+	if delivery.Status == domain.DeliveryPending {
+		next := delivery.NextAttemptAt
+		resp.NextAttemptAt = &next
+	}
+
+	for _, a := range delivery.Attempts {
+		resp.Attempts = append(resp.Attempts, DeliveryAttemptResponse{
+			At:         a.At,
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			DurationMs: a.Duration.Milliseconds(),
+		})
+	}
+
+	return resp
+}
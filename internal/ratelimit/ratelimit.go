@@ -0,0 +1,237 @@
+// Package ratelimit limits how often callers may use the API with token
+// buckets, and reports the limits to callers in RateLimit-* headers.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+)
+
+// Limit is a token bucket refilled at Rate tokens a second and holding at
+// most Burst. A Rate of zero or less is no limit at all.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Decision is a limiter's answer to a single request
+type Decision struct {
+	Allowed bool
+	// Limit is the bucket size and Remaining the whole tokens left in it
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is how long until the next request would be allowed. It is
+	// zero for an allowed request.
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket for key, creating it with limit if
+// it does not exist yet. Implementations may keep buckets in memory or in a
+// store shared by several gateway instances.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// defaultSweepInterval is how often buckets that have refilled are dropped
+const defaultSweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled
+	full time.Time
+}
+
+// MemoryLimiter keeps buckets in memory, so each gateway instance enforces
+// its limits on its own. Buckets that have refilled are dropped, since a
+// new one would behave the same.
+type MemoryLimiter struct {
+	clock         clock.Clock
+	sweepInterval time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Option configures a MemoryLimiter
+type Option func(*MemoryLimiter)
+
+// WithClock replaces the wall clock used to refill buckets
+func WithClock(c clock.Clock) Option {
+	return func(l *MemoryLimiter) {
+		l.clock = c
+	}
+}
+
+func NewMemoryLimiter(opts ...Option) *MemoryLimiter {
+	l := &MemoryLimiter{
+		clock:         clock.Real{},
+		sweepInterval: defaultSweepInterval,
+		buckets:       make(map[string]*bucket),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	l.lastSweep = l.clock.Now()
+	return l
+}
+
+func (l *MemoryLimiter) Allow(_ context.Context, key string, limit Limit) (Decision, error) {
+	if limit.Unlimited() {
+		return Decision{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	burst := float64(limit.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	// Refill for the time since the last request. A burst lowered by a
+	// reload takes effect straight away.
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	decision := Decision{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = seconds((burst - b.tokens) / limit.Rate)
+	b.full = now.Add(decision.Reset)
+
+	return decision, nil
+}
+
+// Len returns the number of buckets held
+func (l *MemoryLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// sweep drops the buckets that would be full by now. It runs at most once
+// per sweep interval, from Allow, so no goroutine is needed.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Rule is one limit a request counts against, e.g. its API key's
+type Rule struct {
+	Key   string
+	Limit Limit
+}
+
+// Middleware counts each request against every rule returned for it. The
+// most restrictive rule is reported in RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset; a request over any limit gets Retry-After and is
+// passed to rejected instead of next.
+//
+// If the limiter fails the request is let through, so a broken shared
+// backend does not take the gateway down with it.
+func Middleware(limiter Limiter, rules func(*http.Request) []Rule, rejected http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reported := Check(r.Context(), limiter, rules(r))
+			if reported == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(reported.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(reported.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reported.Reset)))
+
+			if !reported.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(RetryAfterSeconds(*reported)))
+				rejected.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Check counts a request against every rule and returns the most
+// restrictive decision, or nil if none of the rules limits it. Rules the
+// limiter fails to check are skipped.
+func Check(ctx context.Context, limiter Limiter, rules []Rule) *Decision {
+	var reported *Decision
+	for _, rule := range rules {
+		if rule.Limit.Unlimited() {
+			continue
+		}
+
+		decision, err := limiter.Allow(ctx, rule.Key, rule.Limit)
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limiter failed, letting the request through", "error", err)
+			continue
+		}
+
+		if reported == nil || moreRestrictive(decision, *reported) {
+			reported = &decision
+		}
+	}
+	return reported
+}
+
+// RetryAfterSeconds is how many whole seconds a caller turned away by d
+// should wait, at least one
+func RetryAfterSeconds(d Decision) int {
+	return max(1, ceilSeconds(d.RetryAfter))
+}
+
+// moreRestrictive orders denials first, then by the fewest requests left
+func moreRestrictive(a, b Decision) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
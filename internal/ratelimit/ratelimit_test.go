@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestMemoryLimiter_Allow(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	limiter := NewMemoryLimiter(WithClock(fakeClock))
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	// The burst is available straight away
+	for remaining := 2; remaining >= 0; remaining-- {
+		decision, err := limiter.Allow(ctx, "key", limit)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 3, decision.Limit)
+		assert.Equal(t, remaining, decision.Remaining)
+	}
+
+	decision, err := limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+	assert.Equal(t, 500*time.Millisecond, decision.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, decision.Reset)
+
+	// Other keys have buckets of their own
+	decision, err = limiter.Allow(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// Tokens come back at the rate
+	fakeClock.Advance(500 * time.Millisecond)
+	decision, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	// But never more than the burst
+	fakeClock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		decision, err = limiter.Allow(ctx, "key", limit)
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err = limiter.Allow(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestMemoryLimiter_Unlimited(t *testing.T) {
+	limiter := NewMemoryLimiter()
+
+	for i := 0; i < 100; i++ {
+		decision, err := limiter.Allow(context.Background(), "key", Limit{})
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	assert.Equal(t, 0, limiter.Len())
+}
+
+func TestMemoryLimiter_SweepsRefilledBuckets(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	limiter := NewMemoryLimiter(WithClock(fakeClock))
+	ctx := context.Background()
+
+	_, err := limiter.Allow(ctx, "fast", Limit{Rate: 10, Burst: 10})
+	require.NoError(t, err)
+	// Takes 1000s to refill
+	_, err = limiter.Allow(ctx, "slow", Limit{Rate: 0.001, Burst: 1})
+	require.NoError(t, err)
+
+	fakeClock.Advance(defaultSweepInterval)
+	_, err = limiter.Allow(ctx, "new", Limit{Rate: 10, Burst: 10})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, limiter.Len(), "only the refilled bucket should be dropped")
+
+	decision, err := limiter.Allow(ctx, "slow", Limit{Rate: 0.001, Burst: 1})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed, "a bucket still refilling must be kept")
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, Limit) (Decision, error) {
+	return Decision{}, errors.New("backend down")
+}
+
+func TestMiddleware(t *testing.T) {
+	fakeClock := clock.NewFake(start)
+	limiter := NewMemoryLimiter(WithClock(fakeClock))
+
+	rules := func(r *http.Request) []Rule {
+		return []Rule{
+			{Key: "ip:" + r.RemoteAddr, Limit: Limit{Rate: 1, Burst: 5}},
+			{Key: "key:" + r.Header.Get("X-Key"), Limit: Limit{Rate: 1, Burst: 2}},
+			{Key: "unlimited", Limit: Limit{}},
+		}
+	}
+	rejected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	handler := Middleware(limiter, rules, rejected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	request := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Key", "a")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// The key's bucket is the smaller, so it is the one reported
+	w := request()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, request().Code)
+
+	w = request()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	fakeClock.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, request().Code)
+}
+
+func TestMiddleware_FailsOpen(t *testing.T) {
+	rules := func(*http.Request) []Rule {
+		return []Rule{{Key: "key", Limit: Limit{Rate: 1, Burst: 1}}}
+	}
+	rejected := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	handler := Middleware(failingLimiter{}, rules, rejected)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+)
+
+// BatchesRepository holds payment batches and their per-item results.
+// In production, this would be replaced with a database implementation.
+// Records are copied in and out so callers never share state with the store.
+type BatchesRepository struct {
+	batches map[string]domain.PaymentBatch
+	mu      sync.RWMutex
+}
+
+func NewBatchesRepository() *BatchesRepository {
+	return &BatchesRepository{
+		batches: make(map[string]domain.PaymentBatch),
+	}
+}
+
+func (r *BatchesRepository) SaveBatch(batch *domain.PaymentBatch) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches[batch.ID] = copyBatch(batch)
+	return nil
+}
+
+func (r *BatchesRepository) FindBatch(id string) (*domain.PaymentBatch, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	batch, exists := r.batches[id]
+	if !exists {
+		return nil, nil
+	}
+
+	c := copyBatch(&batch)
+	return &c, nil
+}
+
+// UpdateBatchItem records the result of one item, leaving the rest of the
+// batch alone so concurrent items do not overwrite each other
+func (r *BatchesRepository) UpdateBatchItem(id string, item domain.BatchItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	batch, exists := r.batches[id]
+	if !exists {
+		return domain.ErrBatchNotFound
+	}
+	if item.Index < 0 || item.Index >= len(batch.Items) {
+		return fmt.Errorf("payment batch %s has no item %d", id, item.Index)
+	}
+
+	batch.Items[item.Index] = item
+	return nil
+}
+
+// UpdateBatchStatus changes the batch's status without touching its items.
+// A zero completedAt leaves CompletedAt as it was.
+func (r *BatchesRepository) UpdateBatchStatus(id string, status domain.BatchStatus, completedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	batch, exists := r.batches[id]
+	if !exists {
+		return domain.ErrBatchNotFound
+	}
+
+	batch.Status = status
+	if !completedAt.IsZero() {
+		batch.CompletedAt = completedAt
+	}
+	r.batches[id] = batch
+	return nil
+}
+
+func copyBatch(batch *domain.PaymentBatch) domain.PaymentBatch {
+	c := *batch
+	c.Items = append([]domain.BatchItem(nil), batch.Items...)
+	return c
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchesRepository_Batches(t *testing.T) {
+	repo := NewBatchesRepository()
+
+	batch := &domain.PaymentBatch{
+		ID:     "batch-1",
+		Status: domain.BatchProcessing,
+		Items: []domain.BatchItem{
+			{Index: 0, Status: domain.BatchItemPending},
+			{Index: 1, Status: domain.BatchItemPending},
+		},
+	}
+	require.NoError(t, repo.SaveBatch(batch))
+
+	// Changes after saving do not leak into the store
+	batch.Items[0].Status = domain.BatchItemFailed
+
+	require.NoError(t, repo.UpdateBatchItem("batch-1", domain.BatchItem{
+		Index:         1,
+		Status:        domain.BatchItemSucceeded,
+		PaymentID:     "payment-1",
+		PaymentStatus: domain.StatusAuthorized,
+	}))
+	completedAt := time.Date(2026, time.October, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.UpdateBatchStatus("batch-1", domain.BatchCompleted, completedAt))
+
+	found, err := repo.FindBatch("batch-1")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, domain.BatchCompleted, found.Status)
+	assert.Equal(t, completedAt, found.CompletedAt)
+	assert.Equal(t, domain.BatchItemPending, found.Items[0].Status)
+	assert.Equal(t, "payment-1", found.Items[1].PaymentID)
+
+	// Nor do changes to what was found
+	found.Items[1].Status = domain.BatchItemCanceled
+	again, err := repo.FindBatch("batch-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.BatchItemSucceeded, again.Items[1].Status)
+
+	missing, err := repo.FindBatch("batch-2")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+	assert.ErrorIs(t, repo.UpdateBatchStatus("batch-2", domain.BatchCanceled, time.Time{}), domain.ErrBatchNotFound)
+	assert.Error(t, repo.UpdateBatchItem("batch-1", domain.BatchItem{Index: 2}))
+}
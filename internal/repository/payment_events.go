@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/google/uuid"
+)
+
+// ErrVersionConflict is returned by Append when the stream has moved on
+// since the caller read it
+var ErrVersionConflict = errors.New("payment was changed concurrently")
+
+// PaymentEventStore is an append-only log of payment events. Events can be
+// added and read but never changed or removed.
+//
+// In production, this would be replaced with a database table that only
+// allows inserts.
+type PaymentEventStore struct {
+	streams map[string][]domain.PaymentEvent
+	// order holds stream IDs in the order they were started
+	order []string
+	clock clock.Clock
+	mu    sync.RWMutex
+}
+
+func NewPaymentEventStore(c clock.Clock) *PaymentEventStore {
+	return &PaymentEventStore{
+		streams: make(map[string][]domain.PaymentEvent),
+		clock:   c,
+	}
+}
+
+// Append adds events to the end of a payment's stream. expectedVersion is
+// the number of events the caller based its changes on. Each event is
+// stamped with an ID, its sequence number, the time, and the actor and
+// request ID from ctx.
+func (s *PaymentEventStore) Append(ctx context.Context, paymentID string, expectedVersion int, events []domain.PaymentEvent) ([]domain.PaymentEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	appended, err := s.stampLocked(ctx, paymentID, expectedVersion, events)
+	if err != nil {
+		return nil, err
+	}
+	s.restoreLocked(appended)
+
+	out := make([]domain.PaymentEvent, len(appended))
+	for i, e := range appended {
+		out[i] = copyPaymentEvent(e)
+	}
+	return out, nil
+}
+
+// stamp prepares events the way Append would, without storing them, so
+// they can be written somewhere durable first and then restored
+func (s *PaymentEventStore) stamp(ctx context.Context, paymentID string, expectedVersion int, events []domain.PaymentEvent) ([]domain.PaymentEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stampLocked(ctx, paymentID, expectedVersion, events)
+}
+
+// restore adds events that were already stamped, e.g. read back from a
+// journal
+func (s *PaymentEventStore) restore(events []domain.PaymentEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.restoreLocked(events)
+}
+
+func (s *PaymentEventStore) stampLocked(ctx context.Context, paymentID string, expectedVersion int, events []domain.PaymentEvent) ([]domain.PaymentEvent, error) {
+	if len(s.streams[paymentID]) != expectedVersion {
+		return nil, ErrVersionConflict
+	}
+
+	now := s.clock.Now().UTC()
+	actor := audit.ActorFrom(ctx).String()
+	requestID := audit.RequestID(ctx)
+
+	stamped := make([]domain.PaymentEvent, len(events))
+	for i, e := range events {
+		e.ID = uuid.New().String()
+		e.PaymentID = paymentID
+		e.Sequence = expectedVersion + i + 1
+		e.OccurredAt = now
+		e.Actor = actor
+		e.RequestID = requestID
+
+		stamped[i] = copyPaymentEvent(e)
+	}
+	return stamped, nil
+}
+
+func (s *PaymentEventStore) restoreLocked(events []domain.PaymentEvent) {
+	for _, e := range events {
+		stream := s.streams[e.PaymentID]
+		if len(stream) == 0 {
+			s.order = append(s.order, e.PaymentID)
+		}
+		s.streams[e.PaymentID] = append(stream, copyPaymentEvent(e))
+	}
+}
+
+// Load returns a payment's events in sequence order
+func (s *PaymentEventStore) Load(ctx context.Context, paymentID string) ([]domain.PaymentEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream := s.streams[paymentID]
+	out := make([]domain.PaymentEvent, len(stream))
+	for i, e := range stream {
+		out[i] = copyPaymentEvent(e)
+	}
+	return out, nil
+}
+
+// Count returns the number of streams in the store
+func (s *PaymentEventStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.streams)
+}
+
+// IDs returns the ID of every stream, oldest first
+func (s *PaymentEventStore) IDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]string(nil), s.order...)
+}
+
+// copyPaymentEvent makes sure no caller can reach into a stored event
+func copyPaymentEvent(e domain.PaymentEvent) domain.PaymentEvent {
+	if e.Data.StoredCredential != nil {
+		sc := *e.Data.StoredCredential
+		e.Data.StoredCredential = &sc
+	}
+	return e
+}
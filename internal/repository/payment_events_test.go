@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentEventStore_Append(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+	store := NewPaymentEventStore(clock.NewFake(now))
+	ctx := context.Background()
+
+	appended, err := store.Append(ctx, "payment-1", 0, []domain.PaymentEvent{
+		{Type: domain.EventPaymentRequested},
+		{Type: domain.EventPaymentAuthorized},
+	})
+	require.NoError(t, err)
+	require.Len(t, appended, 2)
+	assert.Equal(t, 1, appended[0].Sequence)
+	assert.Equal(t, 2, appended[1].Sequence)
+	assert.Equal(t, now, appended[0].OccurredAt)
+	assert.Equal(t, "system:unknown", appended[0].Actor)
+	assert.Equal(t, "payment-1", appended[1].PaymentID)
+
+	// A writer that has not seen the latest events is turned away
+	_, err = store.Append(ctx, "payment-1", 1, []domain.PaymentEvent{{Type: domain.EventPaymentDeclined}})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	events, err := store.Load(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, appended, events)
+
+	empty, err := store.Load(ctx, "payment-2")
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+}
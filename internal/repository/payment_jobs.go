@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+)
+
+// PaymentJobsJournalFile is the name of the payment queue's journal in the
+// storage directory
+const PaymentJobsJournalFile = "payment_jobs.journal"
+
+// PaymentJobsRepository holds asynchronous payments that have not been sent
+// to the bank yet. The bank needs the card number and CVV, so unlike stored
+// payments a job keeps both, envelope-encrypted, until it is removed.
+//
+// A job whose outcome the bank never gave is parked rather than removed. It
+// is not sent again until an operator has reconciled it with the bank and
+// resolved it.
+//
+// Opened with a journal file, every change is appended to the file and
+// synced before the call returns, so queued payments survive a restart. The
+// journal is emptied whenever the queue is, so card data does not linger on
+// disk after the jobs that needed it are done.
+type PaymentJobsRepository struct {
+	envelope *envelope.Envelope
+	jobs     map[string]*paymentJob
+	sequence int64
+	journal  *journal.Journal
+	mu       sync.RWMutex
+}
+
+// paymentJob is a queued payment with its card secrets sealed apart from it
+type paymentJob struct {
+	Sequence int64            `json:"sequence"`
+	Payment  domain.Payment   `json:"payment"`
+	Secrets  *envelope.Sealed `json:"secrets"`
+	Parked   bool             `json:"parked,omitempty"`
+}
+
+// cardSecrets is what a job seals: what the bank needs and a stored payment
+// never keeps
+type cardSecrets struct {
+	Number string `json:"number,omitempty"`
+	CVV    string `json:"cvv,omitempty"`
+}
+
+// jobEntry is one line of the journal. Replaying the entries in order
+// rebuilds the queue.
+type jobEntry struct {
+	Job     *paymentJob `json:"job,omitempty"`
+	Removed string      `json:"removed,omitempty"`
+}
+
+func NewPaymentJobsRepository(e *envelope.Envelope) *PaymentJobsRepository {
+	return &PaymentJobsRepository{
+		envelope: e,
+		jobs:     make(map[string]*paymentJob),
+	}
+}
+
+// OpenPaymentJobsRepository loads the journal at path, creating it if
+// needed. The journal is compacted on open so it only grows with new jobs.
+func OpenPaymentJobsRepository(path string, e *envelope.Envelope) (*PaymentJobsRepository, error) {
+	r := NewPaymentJobsRepository(e)
+
+	replay := func(line []byte) error {
+		var entry jobEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		r.applyInMemory(entry)
+		return nil
+	}
+	snapshot := func(write func(entry any) error) error {
+		for _, job := range r.jobs {
+			if err := write(jobEntry{Job: job}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	j, err := journal.Open(path, "payment job journal", replay, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	r.journal = j
+
+	return r, nil
+}
+
+func (r *PaymentJobsRepository) Close() error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Close()
+}
+
+// Ping checks the journal is still open and is still the file at its path
+func (r *PaymentJobsRepository) Ping(ctx context.Context) error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Ping(ctx)
+}
+
+// Add queues a payment. Its card number and CVV are sealed with the payment
+// ID, so they cannot be moved onto another job.
+func (r *PaymentJobsRepository) Add(payment *domain.Payment) error {
+	plaintext, err := json.Marshal(cardSecrets{Number: payment.Card.Number, CVV: payment.Card.CVV})
+	if err != nil {
+		return fmt.Errorf("failed to encode card details: %w", err)
+	}
+	sealed, err := r.envelope.Seal(plaintext, []byte(payment.ID))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt card details: %w", err)
+	}
+
+	job := &paymentJob{Payment: *payment, Secrets: sealed}
+	job.Payment.Card.Number = ""
+	job.Payment.Card.CVV = ""
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sequence++
+	job.Sequence = r.sequence
+	return r.apply(jobEntry{Job: job})
+}
+
+// Get returns the queued payment with its card number and CVV, or nil if
+// there is no such job
+func (r *PaymentJobsRepository) Get(id string) (*domain.Payment, error) {
+	r.mu.RLock()
+	job, exists := r.jobs[id]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, nil
+	}
+
+	plaintext, err := r.envelope.Open(job.Secrets, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt card details: %w", err)
+	}
+	var secrets cardSecrets
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to decode card details: %w", err)
+	}
+
+	payment := job.Payment
+	if job.Payment.StoredCredential != nil {
+		sc := *job.Payment.StoredCredential
+		payment.StoredCredential = &sc
+	}
+	payment.Card.Number = secrets.Number
+	payment.Card.CVV = secrets.CVV
+	return &payment, nil
+}
+
+// Remove drops a job once its payment has an outcome
+func (r *PaymentJobsRepository) Remove(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.jobs[id]; !exists {
+		return nil
+	}
+	if err := r.apply(jobEntry{Removed: id}); err != nil {
+		return err
+	}
+
+	// Nothing in the journal is needed any more, including the sealed card
+	// data of the jobs just done
+	if len(r.jobs) == 0 && r.journal != nil {
+		if err := r.journal.Truncate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Park sets aside a job whose payment may have reached the bank without an
+// answer coming back. It keeps its card details but is left out of IDs, so
+// it is never sent again by itself.
+func (r *PaymentJobsRepository) Park(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, exists := r.jobs[id]
+	if !exists {
+		return domain.ErrPaymentNotFound
+	}
+
+	parked := *job
+	parked.Parked = true
+	return r.apply(jobEntry{Job: &parked})
+}
+
+// Resolve queues a parked payment again with the outcome an operator found
+// by reconciling it with the bank: StatusAuthorized, StatusDeclined or
+// StatusRejected, which is stored and announced without calling the bank,
+// or StatusPending to send it again once the bank is known not to have it.
+func (r *PaymentJobsRepository) Resolve(id string, status domain.PaymentStatus, networkTransactionID string) error {
+	switch status {
+	case domain.StatusAuthorized, domain.StatusDeclined, domain.StatusRejected, domain.StatusPending:
+	default:
+		return fmt.Errorf("cannot resolve a payment as %q", status)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, exists := r.jobs[id]
+	if !exists || !job.Parked {
+		return domain.ErrPaymentNotFound
+	}
+
+	resolved := *job
+	resolved.Parked = false
+	resolved.Payment.Status = status
+	if status == domain.StatusAuthorized {
+		resolved.Payment.NetworkTransactionID = networkTransactionID
+	}
+	return r.apply(jobEntry{Job: &resolved})
+}
+
+// IDs returns the IDs of every queued payment, oldest first. Parked
+// payments are not queued.
+func (r *PaymentJobsRepository) IDs() []string {
+	jobs := r.sorted(false)
+
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.Payment.ID
+	}
+	return ids
+}
+
+// Parked returns every parked payment, oldest first, without its card
+// number and CVV
+func (r *PaymentJobsRepository) Parked() []domain.Payment {
+	jobs := r.sorted(true)
+
+	payments := make([]domain.Payment, len(jobs))
+	for i, job := range jobs {
+		payments[i] = job.Payment
+	}
+	return payments
+}
+
+// Count returns the number of queued payments
+func (r *PaymentJobsRepository) Count() int {
+	return len(r.sorted(false))
+}
+
+// sorted returns the parked or the queued jobs, oldest first
+func (r *PaymentJobsRepository) sorted(parked bool) []*paymentJob {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jobs := make([]*paymentJob, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		if job.Parked == parked {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Sequence < jobs[j].Sequence
+	})
+	return jobs
+}
+
+// RewrapKeys moves the card data of every queued payment onto the current
+// master key, journaling the jobs it changes
+func (r *PaymentJobsRepository) RewrapKeys(e *envelope.Envelope) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := 0
+	for id, job := range r.jobs {
+		rewrapped := *job
+		sealed := *job.Secrets
+		rewrapped.Secrets = &sealed
+
+		ok, err := e.Rewrap(rewrapped.Secrets)
+		if err != nil {
+			return changed, fmt.Errorf("payment job %s: %w", id, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := r.apply(jobEntry{Job: &rewrapped}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+// apply writes the entry to the journal, if there is one, and then to memory.
+// Callers hold the write lock.
+func (r *PaymentJobsRepository) apply(entry jobEntry) error {
+	if r.journal != nil {
+		if err := r.journal.Append(entry); err != nil {
+			return err
+		}
+	}
+
+	r.applyInMemory(entry)
+	return nil
+}
+
+func (r *PaymentJobsRepository) applyInMemory(entry jobEntry) {
+	if entry.Job != nil {
+		r.jobs[entry.Job.Payment.ID] = entry.Job
+		if entry.Job.Sequence > r.sequence {
+			r.sequence = entry.Job.Sequence
+		}
+	}
+
+	if entry.Removed != "" {
+		delete(r.jobs, entry.Removed)
+	}
+}
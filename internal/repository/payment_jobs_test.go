@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEnvelope(t *testing.T) *envelope.Envelope {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	return envelope.New(provider)
+}
+
+func pendingPayment(id string) *domain.Payment {
+	p := testPayment()
+	p.ID = id
+	p.Status = domain.StatusPending
+	return p
+}
+
+func TestPaymentJobsRepository_Queue(t *testing.T) {
+	repo := NewPaymentJobsRepository(newTestEnvelope(t))
+
+	require.NoError(t, repo.Add(pendingPayment("payment-2")))
+	require.NoError(t, repo.Add(pendingPayment("payment-1")))
+	assert.Equal(t, []string{"payment-2", "payment-1"}, repo.IDs(), "oldest first")
+
+	payment, err := repo.Get("payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, pendingPayment("payment-1"), payment, "card number and CVV are restored")
+
+	require.NoError(t, repo.Remove("payment-1"))
+	payment, err = repo.Get("payment-1")
+	require.NoError(t, err)
+	assert.Nil(t, payment)
+	assert.Equal(t, 1, repo.Count())
+}
+
+func TestPaymentJobsRepository_JournalSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payment_jobs.journal")
+	e := newTestEnvelope(t)
+
+	repo, err := OpenPaymentJobsRepository(path, e)
+	require.NoError(t, err)
+	require.NoError(t, repo.Add(pendingPayment("payment-1")))
+	require.NoError(t, repo.Add(pendingPayment("payment-2")))
+	require.NoError(t, repo.Remove("payment-1"))
+	require.NoError(t, repo.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "2222405343248877", "card number is sealed")
+	assert.NotContains(t, string(data), `"123"`, "CVV is sealed")
+
+	// Simulate a crash part way through the next write
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"job":{"sequence":3`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := OpenPaymentJobsRepository(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, []string{"payment-2"}, reopened.IDs())
+	payment, err := reopened.Get("payment-2")
+	require.NoError(t, err)
+	assert.Equal(t, pendingPayment("payment-2"), payment)
+
+	require.NoError(t, reopened.Add(pendingPayment("payment-3")))
+	assert.Equal(t, []string{"payment-2", "payment-3"}, reopened.IDs(), "sequence continues after a restart")
+}
+
+func TestPaymentJobsRepository_ParkAndResolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payment_jobs.journal")
+	e := newTestEnvelope(t)
+
+	repo, err := OpenPaymentJobsRepository(path, e)
+	require.NoError(t, err)
+	require.NoError(t, repo.Add(pendingPayment("payment-1")))
+	require.NoError(t, repo.Add(pendingPayment("payment-2")))
+	require.NoError(t, repo.Park("payment-1"))
+	assert.Equal(t, []string{"payment-2"}, repo.IDs(), "parked payments are not queued")
+	assert.Equal(t, 1, repo.Count())
+	assert.ErrorIs(t, repo.Resolve("payment-2", domain.StatusAuthorized, "ntid"), domain.ErrPaymentNotFound,
+		"only parked payments can be resolved")
+	require.NoError(t, repo.Close())
+
+	reopened, err := OpenPaymentJobsRepository(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	parked := reopened.Parked()
+	require.Len(t, parked, 1, "parked payments survive a restart")
+	assert.Equal(t, "payment-1", parked[0].ID)
+	assert.Empty(t, parked[0].Card.Number)
+
+	assert.Error(t, reopened.Resolve("payment-1", "Unknown", ""))
+	require.NoError(t, reopened.Resolve("payment-1", domain.StatusAuthorized, "ntid"))
+	assert.Empty(t, reopened.Parked())
+	assert.Equal(t, []string{"payment-1", "payment-2"}, reopened.IDs())
+
+	payment, err := reopened.Get("payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusAuthorized, payment.Status)
+	assert.Equal(t, "ntid", payment.NetworkTransactionID)
+	assert.Equal(t, "2222405343248877", payment.Card.Number, "card details are kept until the job is removed")
+}
+
+func TestPaymentJobsRepository_EmptiesJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payment_jobs.journal")
+
+	repo, err := OpenPaymentJobsRepository(path, newTestEnvelope(t))
+	require.NoError(t, err)
+	defer repo.Close()
+
+	require.NoError(t, repo.Add(pendingPayment("payment-1")))
+	require.NoError(t, repo.Remove("payment-1"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size(), "no sealed card data is left behind")
+
+	require.NoError(t, repo.Add(pendingPayment("payment-2")))
+	require.NoError(t, repo.Ping(context.Background()))
+}
+
+func TestPaymentJobsRepository_RewrapKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "payment_jobs.journal")
+	oldKey, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	newKey, err := envelope.GenerateKey()
+	require.NoError(t, err)
+
+	v1, err := envelope.NewStaticKeyProvider(1, map[int][]byte{1: oldKey})
+	require.NoError(t, err)
+	repo, err := OpenPaymentJobsRepository(path, envelope.New(v1))
+	require.NoError(t, err)
+	require.NoError(t, repo.Add(pendingPayment("payment-1")))
+
+	// Rotate to version 2 while keeping version 1 readable
+	v2, err := envelope.NewStaticKeyProvider(2, map[int][]byte{1: oldKey, 2: newKey})
+	require.NoError(t, err)
+	changed, err := repo.RewrapKeys(envelope.New(v2))
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	require.NoError(t, repo.Close())
+
+	// The rewrapped job is journaled, so version 1 can be retired
+	onlyV2, err := envelope.NewStaticKeyProvider(2, map[int][]byte{2: newKey})
+	require.NoError(t, err)
+	reopened, err := OpenPaymentJobsRepository(path, envelope.New(onlyV2))
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	payment, err := reopened.Get("payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", payment.Card.Number)
+}
@@ -1,39 +1,372 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// PaymentsRepository stores payments as streams of events, so every state a
+// payment has been in is kept. The card number is only kept
+// envelope-encrypted, outside the events, so it can be crypto-shredded; the
+// CVV is never stored.
+//
+// Opened with a journal file, every new event and sealed card is appended
+// to the file and synced before the call returns, so payments survive a
+// restart. Without one they only live in memory.
+//
 // In production, this would be replaced with a database implementation
 type PaymentsRepository struct {
-	payments map[string]*domain.Payment
-	mu       sync.RWMutex // Thread-safe for concurrent access
+	envelope *envelope.Envelope
+	events   *PaymentEventStore
+	cards    map[string]*envelope.Sealed
+	// byNetworkTransactionID indexes payment IDs by the reference the bank
+	// gave them, which follow-up merchant-initiated payments quote
+	byNetworkTransactionID map[string]string
+	journal                *journal.Journal
+	mu                     sync.RWMutex // Serialises writes so each save sees the latest state
 }
 
-func NewPaymentsRepository() *PaymentsRepository {
+// PaymentsJournalFile is the name of the payments journal in the storage
+// directory
+const PaymentsJournalFile = "payments.journal"
+
+// paymentEntry is one line of the payments journal: events added to a
+// payment's stream, and its sealed card number when it is first stored or
+// changes key
+type paymentEntry struct {
+	PaymentID string                `json:"payment_id"`
+	Events    []domain.PaymentEvent `json:"events,omitempty"`
+	Card      *envelope.Sealed      `json:"card,omitempty"`
+}
+
+func NewPaymentsRepository(e *envelope.Envelope) *PaymentsRepository {
 	return &PaymentsRepository{
-		payments: make(map[string]*domain.Payment),
+		envelope: e,
+		events:   NewPaymentEventStore(clock.Real{}),
+		cards:    make(map[string]*envelope.Sealed),
+
+		byNetworkTransactionID: make(map[string]string),
 	}
 }
 
-func (r *PaymentsRepository) Save(payment *domain.Payment) error {
+// OpenPaymentsRepository loads the journal at path, creating it if needed.
+// The journal is compacted on open so it only grows with new changes.
+func OpenPaymentsRepository(path string, e *envelope.Envelope) (*PaymentsRepository, error) {
+	r := NewPaymentsRepository(e)
+
+	replay := func(line []byte) error {
+		var entry paymentEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		r.applyInMemory(entry)
+		return nil
+	}
+
+	j, err := journal.Open(path, "payments journal", replay, r.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	r.journal = j
+
+	return r, nil
+}
+
+func (r *PaymentsRepository) Close() error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Close()
+}
+
+// Save records how the payment differs from its stored state as new events.
+// Saving an unchanged payment records nothing.
+func (r *PaymentsRepository) Save(ctx context.Context, payment *domain.Payment) (err error) {
+	ctx, span := tracing.Start(ctx, "PaymentsRepository.Save",
+		trace.WithAttributes(tracing.PaymentAttributes(payment)...))
+	defer func() { tracing.End(span, err) }()
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.payments[payment.ID] = payment
-	return nil
+	history, err := r.events.Load(ctx, payment.ID)
+	if err != nil {
+		return err
+	}
+
+	changes := payment.Changes(domain.ReplayPayment(history))
+	if len(changes) == 0 {
+		return nil
+	}
+
+	entry := paymentEntry{PaymentID: payment.ID}
+
+	// Saving a payment read back from the repository must not lose the card
+	if _, exists := r.cards[payment.ID]; !exists && payment.Card.Number != "" {
+		sealed, err := r.envelope.Seal([]byte(payment.Card.Number), []byte(payment.ID))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt card number: %w", err)
+		}
+		entry.Card = sealed
+	}
+
+	entry.Events, err = r.events.stamp(ctx, payment.ID, len(history), changes)
+	if err != nil {
+		return fmt.Errorf("failed to record payment events: %w", err)
+	}
+
+	return r.apply(entry)
 }
 
-func (r *PaymentsRepository) FindByID(id string) (*domain.Payment, error) {
+// FindByID rebuilds the payment from its events. It never has a card
+// number.
+func (r *PaymentsRepository) FindByID(ctx context.Context, id string) (_ *domain.Payment, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentsRepository.FindByID",
+		trace.WithAttributes(tracing.AttrPaymentID.String(id)))
+	defer func() { tracing.End(span, err) }()
+
+	history, err := r.events.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.ReplayPayment(history), nil
+}
+
+// FindByNetworkTransactionID returns the payment the bank gave the network
+// transaction ID, or nil if there is none
+func (r *PaymentsRepository) FindByNetworkTransactionID(ctx context.Context, networkTransactionID string) (*domain.Payment, error) {
+	r.mu.RLock()
+	id, ok := r.byNetworkTransactionID[networkTransactionID]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	return r.FindByID(ctx, id)
+}
+
+// List returns a page of query.MerchantID's payments, newest first. None of
+// them has a card number.
+func (r *PaymentsRepository) List(ctx context.Context, query domain.PaymentQuery) (_ *domain.PaymentPage, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentsRepository.List")
+	defer func() { tracing.End(span, err) }()
+
+	ids := r.events.IDs()
+
+	end := len(ids)
+	if query.StartingAfter != "" {
+		end = -1
+		for i, id := range ids {
+			if id == query.StartingAfter {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, domain.ErrPaymentCursorInvalid
+		}
+		// Another merchant's payment is no cursor, or its IDs could be
+		// probed
+		cursor, err := r.FindByID(ctx, query.StartingAfter)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.MerchantID != query.MerchantID {
+			return nil, domain.ErrPaymentCursorInvalid
+		}
+	}
+
+	page := &domain.PaymentPage{Payments: make([]*domain.Payment, 0, query.Limit)}
+	for i := end - 1; i >= 0; i-- {
+		history, err := r.events.Load(ctx, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		payment := domain.ReplayPayment(history)
+		if payment.MerchantID != query.MerchantID {
+			continue
+		}
+		if query.Status != "" && payment.Status != query.Status {
+			continue
+		}
+
+		if len(page.Payments) == query.Limit {
+			page.HasMore = true
+			break
+		}
+		page.Payments = append(page.Payments, payment)
+	}
+
+	return page, nil
+}
+
+// Events returns the full history of a payment, oldest first
+func (r *PaymentsRepository) Events(ctx context.Context, id string) (_ []domain.PaymentEvent, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentsRepository.Events",
+		trace.WithAttributes(tracing.AttrPaymentID.String(id)))
+	defer func() { tracing.End(span, err) }()
+
+	return r.events.Load(ctx, id)
+}
+
+// Count returns the number of stored payments
+func (r *PaymentsRepository) Count() int {
+	return r.events.Count()
+}
+
+// Ping reports whether the repository can be used. Payments held in memory
+// always can; a journal must still be the file at its path.
+func (r *PaymentsRepository) Ping(ctx context.Context) error {
+	if r.journal == nil {
+		return nil
+	}
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
+	return r.journal.Ping(ctx)
+}
 
-	payment, exists := r.payments[id]
-	if !exists {
-		return nil, nil
+// RevealCard decrypts the card number of a payment, e.g. for reconciliation
+// with the acquirer. It returns envelope.ErrShredded once the card has been
+// shredded.
+func (r *PaymentsRepository) RevealCard(ctx context.Context, id string) (*domain.Card, error) {
+	payment, err := r.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return nil, domain.ErrPaymentNotFound
+	}
+
+	card := payment.Card
+
+	// The card is opened under the lock, since ShredCard zeroes its data
+	// key in place
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sealed := r.cards[id]
+	if sealed == nil {
+		return &card, nil
+	}
+
+	number, err := r.envelope.Open(sealed, []byte(id))
+	if err != nil {
+		if errors.Is(err, envelope.ErrShredded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to decrypt card number: %w", err)
+	}
+
+	card.Number = string(number)
+	return &card, nil
+}
+
+// ShredCard destroys the data key of a payment's card number and records
+// that it did. The payment itself, including the last four digits, is kept.
+func (r *PaymentsRepository) ShredCard(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, err := r.events.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return domain.ErrPaymentNotFound
+	}
+
+	if sealed := r.cards[id]; sealed != nil {
+		sealed.Shred()
 	}
 
-	return payment, nil
+	events, err := r.events.stamp(ctx, id, len(history), []domain.PaymentEvent{{Type: domain.EventCardShredded}})
+	if err != nil {
+		return err
+	}
+	if err := r.apply(paymentEntry{PaymentID: id, Events: events}); err != nil {
+		return err
+	}
+
+	// The journal still holds the data key the card was sealed with, so it
+	// is rewritten without it
+	if r.journal != nil {
+		if err := r.journal.Rewrite(r.snapshot); err != nil {
+			return fmt.Errorf("failed to shred card number on disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// RewrapKeys moves every stored card number onto the current master key
+func (r *PaymentsRepository) RewrapKeys(e *envelope.Envelope) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := 0
+	for id, card := range r.cards {
+		sealed := *card
+		ok, err := e.Rewrap(&sealed)
+		if err != nil {
+			return changed, fmt.Errorf("payment %s: %w", id, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := r.apply(paymentEntry{PaymentID: id, Card: &sealed}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+// apply journals an entry, if there is a journal, then applies it in
+// memory. It must be called with mu held for writing.
+func (r *PaymentsRepository) apply(entry paymentEntry) error {
+	if r.journal != nil {
+		if err := r.journal.Append(entry); err != nil {
+			return err
+		}
+	}
+	r.applyInMemory(entry)
+	return nil
+}
+
+func (r *PaymentsRepository) applyInMemory(entry paymentEntry) {
+	r.events.restore(entry.Events)
+	if entry.Card != nil {
+		r.cards[entry.PaymentID] = entry.Card
+	}
+	for _, e := range entry.Events {
+		if e.Data.NetworkTransactionID != "" {
+			r.byNetworkTransactionID[e.Data.NetworkTransactionID] = entry.PaymentID
+		}
+	}
+}
+
+// snapshot writes one entry per payment, with its whole history and card
+func (r *PaymentsRepository) snapshot(write func(entry any) error) error {
+	for _, id := range r.events.IDs() {
+		events, err := r.events.Load(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		if err := write(paymentEntry{PaymentID: id, Events: events, Card: r.cards[id]}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,395 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepository(t *testing.T) *PaymentsRepository {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+
+	return NewPaymentsRepository(envelope.New(provider))
+}
+
+func testPayment() *domain.Payment {
+	return &domain.Payment{
+		ID: "payment-1",
+		Card: domain.Card{
+			Number:      "2222405343248877",
+			ExpiryMonth: 4,
+			ExpiryYear:  2030,
+			CVV:         "123",
+		},
+		Currency: "GBP",
+		Amount:   100,
+		Status:   domain.StatusAuthorized,
+	}
+}
+
+func TestPaymentsRepository_SaveAndFind(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	payment, err := repo.FindByID(ctx, "payment-1")
+	require.NoError(t, err)
+
+	assert.Equal(t, domain.StatusAuthorized, payment.Status)
+	assert.Equal(t, "8877", payment.Card.GetLastFourDigits())
+	assert.Equal(t, 4, payment.Card.ExpiryMonth)
+	assert.Empty(t, payment.Card.Number)
+	assert.Empty(t, payment.Card.CVV)
+}
+
+func TestPaymentsRepository_FindByID_NotFound(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	payment, err := repo.FindByID(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, payment)
+}
+
+func TestPaymentsRepository_FindByNetworkTransactionID(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	payment := testPayment()
+	payment.NetworkTransactionID = "483920175846392"
+	require.NoError(t, repo.Save(ctx, payment))
+
+	found, err := repo.FindByNetworkTransactionID(ctx, "483920175846392")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "payment-1", found.ID)
+
+	found, err = repo.FindByNetworkTransactionID(ctx, "000000000000000")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestPaymentsRepository_Count(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	assert.Equal(t, 0, repo.Count())
+
+	payment := testPayment()
+	require.NoError(t, repo.Save(ctx, payment))
+	payment.Status = domain.StatusDeclined
+	require.NoError(t, repo.Save(ctx, payment))
+	assert.Equal(t, 1, repo.Count(), "updates must not count as new payments")
+
+	second := testPayment()
+	second.ID = "payment-2"
+	require.NoError(t, repo.Save(ctx, second))
+	assert.Equal(t, 2, repo.Count())
+}
+
+func TestPaymentsRepository_NoPlaintextCardData(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	events, err := repo.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	for _, e := range events {
+		assert.NotContains(t, fmt.Sprintf("%+v", e), "2222405343248877")
+		// Only the data: the event's ID and timestamp may well contain 123
+		assert.NotContains(t, fmt.Sprintf("%+v", e.Data), "123")
+	}
+	assert.NotContains(t, string(repo.cards["payment-1"].Ciphertext), "2222405343248877")
+}
+
+func TestPaymentsRepository_RevealCard(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	card, err := repo.RevealCard(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", card.Number)
+	assert.Empty(t, card.CVV)
+
+	// Re-saving the payment read back from the repository keeps the card
+	payment, err := repo.FindByID(ctx, "payment-1")
+	require.NoError(t, err)
+	payment.SetDeclined()
+	require.NoError(t, repo.Save(ctx, payment))
+
+	card, err = repo.RevealCard(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", card.Number)
+
+	_, err = repo.RevealCard(ctx, "missing")
+	assert.Equal(t, domain.ErrPaymentNotFound, err)
+}
+
+func TestPaymentsRepository_ShredCard(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	require.NoError(t, repo.ShredCard(ctx, "payment-1"))
+
+	_, err := repo.RevealCard(ctx, "payment-1")
+	assert.Equal(t, envelope.ErrShredded, err)
+
+	payment, err := repo.FindByID(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "8877", payment.Card.GetLastFourDigits())
+
+	events, err := repo.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.EventCardShredded, events[len(events)-1].Type)
+
+	assert.Equal(t, domain.ErrPaymentNotFound, repo.ShredCard(ctx, "missing"))
+}
+
+func TestPaymentsRepository_ShredCardWhileRevealing(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	// Run with -race: a reveal must never read the data key while it is
+	// being zeroed
+	var wg, revealing sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		revealing.Add(1)
+		go func() {
+			defer wg.Done()
+			var once sync.Once
+			defer once.Do(revealing.Done)
+			for {
+				card, err := repo.RevealCard(ctx, "payment-1")
+				if err != nil {
+					assert.Equal(t, envelope.ErrShredded, err)
+					return
+				}
+				assert.Equal(t, "2222405343248877", card.Number)
+				once.Do(revealing.Done)
+			}
+		}()
+	}
+	revealing.Wait()
+	require.NoError(t, repo.ShredCard(ctx, "payment-1"))
+	wg.Wait()
+
+	_, err := repo.RevealCard(ctx, "payment-1")
+	assert.Equal(t, envelope.ErrShredded, err)
+}
+
+func TestPaymentsRepository_RewrapKeys(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+	require.NoError(t, repo.Save(ctx, testPayment()))
+
+	// Already on the current version
+	changed, err := repo.RewrapKeys(repo.envelope)
+	require.NoError(t, err)
+	assert.Equal(t, 0, changed)
+}
+
+func TestPaymentsRepository_RecordsHistory(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := audit.WithActor(context.Background(), audit.Actor{Type: audit.ActorAPIClient, ID: "203.0.113.7"})
+	ctx = audit.WithRequestID(ctx, "req-1")
+
+	payment := testPayment()
+	payment.Status = domain.StatusDeclined
+	require.NoError(t, repo.Save(ctx, payment))
+
+	// An unchanged save records nothing
+	require.NoError(t, repo.Save(ctx, payment))
+
+	payment.SetAuthorized()
+	payment.NetworkTransactionID = "ntid-1"
+	require.NoError(t, repo.Save(audit.WithRequestID(ctx, "req-2"), payment))
+
+	events, err := repo.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+
+	assert.Equal(t, domain.EventPaymentRequested, events[0].Type)
+	assert.Equal(t, "8877", events[0].Data.CardLastFour)
+	assert.Equal(t, 100, events[0].Data.Amount)
+	assert.Equal(t, domain.EventPaymentDeclined, events[1].Type)
+	assert.Equal(t, domain.EventPaymentAuthorized, events[2].Type)
+	assert.Equal(t, "ntid-1", events[2].Data.NetworkTransactionID)
+
+	for i, e := range events {
+		assert.Equal(t, i+1, e.Sequence)
+		assert.NotEmpty(t, e.ID)
+		assert.False(t, e.OccurredAt.IsZero())
+		assert.Equal(t, "api_client:203.0.113.7", e.Actor)
+	}
+	assert.Equal(t, "req-1", events[1].RequestID)
+	assert.Equal(t, "req-2", events[2].RequestID)
+
+	// The current state is rebuilt from the events
+	current, err := repo.FindByID(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusAuthorized, current.Status)
+	assert.Equal(t, "ntid-1", current.NetworkTransactionID)
+}
+
+func TestPaymentsRepository_EventsAreImmutable(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	payment := testPayment()
+	payment.StoredCredential = &domain.StoredCredential{Usage: domain.StoredCredentialFirst, Type: domain.StoredCredentialRecurring}
+	require.NoError(t, repo.Save(ctx, payment))
+
+	// Neither the saved payment nor a loaded event reaches into the store
+	payment.StoredCredential.Type = domain.StoredCredentialUnscheduled
+	events, err := repo.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	events[0].Data.StoredCredential.Usage = domain.StoredCredentialSubsequent
+	events[0].Data.Amount = 1
+
+	again, err := repo.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, 100, again[0].Data.Amount)
+	assert.Equal(t, domain.StoredCredentialFirst, again[0].Data.StoredCredential.Usage)
+	assert.Equal(t, domain.StoredCredentialRecurring, again[0].Data.StoredCredential.Type)
+}
+
+func TestPaymentsRepository_List(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		payment := testPayment()
+		payment.ID = fmt.Sprintf("payment-%d", i)
+		if i%2 == 0 {
+			payment.Status = domain.StatusDeclined
+		}
+		require.NoError(t, repo.Save(ctx, payment))
+	}
+
+	ids := func(page *domain.PaymentPage) []string {
+		var out []string
+		for _, p := range page.Payments {
+			out = append(out, p.ID)
+		}
+		return out
+	}
+
+	page, err := repo.List(ctx, domain.PaymentQuery{Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payment-5", "payment-4"}, ids(page), "newest first")
+	assert.True(t, page.HasMore)
+	assert.Empty(t, page.Payments[0].Card.Number)
+
+	page, err = repo.List(ctx, domain.PaymentQuery{StartingAfter: "payment-4", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payment-3", "payment-2"}, ids(page))
+	assert.True(t, page.HasMore)
+
+	page, err = repo.List(ctx, domain.PaymentQuery{StartingAfter: "payment-2", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payment-1"}, ids(page))
+	assert.False(t, page.HasMore)
+
+	page, err = repo.List(ctx, domain.PaymentQuery{Status: domain.StatusDeclined, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"payment-4", "payment-2"}, ids(page))
+	assert.False(t, page.HasMore, "no declined payment is older")
+
+	_, err = repo.List(ctx, domain.PaymentQuery{StartingAfter: "missing", Limit: 2})
+	assert.ErrorIs(t, err, domain.ErrPaymentCursorInvalid)
+}
+
+func TestPaymentsRepository_ListByMerchant(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	for i, merchantID := range []string{"merchant-a", "merchant-b", "merchant-a"} {
+		payment := testPayment()
+		payment.ID = fmt.Sprintf("payment-%d", i+1)
+		payment.MerchantID = merchantID
+		require.NoError(t, repo.Save(ctx, payment))
+	}
+
+	page, err := repo.List(ctx, domain.PaymentQuery{MerchantID: "merchant-a", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Payments, 2)
+	assert.Equal(t, "payment-3", page.Payments[0].ID)
+	assert.Equal(t, "payment-1", page.Payments[1].ID)
+
+	page, err = repo.List(ctx, domain.PaymentQuery{MerchantID: "merchant-c", Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, page.Payments)
+
+	_, err = repo.List(ctx, domain.PaymentQuery{MerchantID: "merchant-a", StartingAfter: "payment-2", Limit: 10})
+	assert.ErrorIs(t, err, domain.ErrPaymentCursorInvalid, "another merchant's payment is no cursor")
+}
+
+func TestPaymentsRepository_JournalSurvivesRestart(t *testing.T) {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	e := envelope.New(provider)
+	path := filepath.Join(t.TempDir(), PaymentsJournalFile)
+	ctx := context.Background()
+
+	repo, err := OpenPaymentsRepository(path, e)
+	require.NoError(t, err)
+
+	payment := testPayment()
+	payment.Status = domain.StatusPending
+	require.NoError(t, repo.Save(ctx, payment))
+	payment.NetworkTransactionID = "483920175846392"
+	payment.SetAuthorized()
+	require.NoError(t, repo.Save(ctx, payment))
+
+	shredded := testPayment()
+	shredded.ID = "payment-2"
+	require.NoError(t, repo.Save(ctx, shredded))
+	wrappedKey := base64.StdEncoding.EncodeToString(repo.cards["payment-2"].WrappedKey)
+	require.NoError(t, repo.ShredCard(ctx, "payment-2"))
+	require.NoError(t, repo.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), wrappedKey, "a shredded data key must not stay on disk")
+	assert.NotContains(t, string(data), "2222405343248877")
+
+	reopened, err := OpenPaymentsRepository(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, 2, reopened.Count())
+	found, err := reopened.FindByNetworkTransactionID(ctx, "483920175846392")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, domain.StatusAuthorized, found.Status)
+
+	events, err := reopened.Events(ctx, "payment-1")
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, domain.EventPaymentAuthorized, events[2].Type)
+
+	card, err := reopened.RevealCard(ctx, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", card.Number)
+
+	_, err = reopened.RevealCard(ctx, "payment-2")
+	assert.Equal(t, envelope.ErrShredded, err)
+	assert.NoError(t, reopened.Ping(ctx))
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+)
+
+// SubscriptionsJournalFile is the name of the subscriptions journal in the
+// storage directory
+const SubscriptionsJournalFile = "subscriptions.journal"
+
+// SubscriptionsRepository holds plans and subscriptions, including their
+// dunning state. Records are copied in and out so callers never share state
+// with the store.
+//
+// Opened with a journal file, every change is appended to the file and
+// synced before the call returns, so subscriptions keep renewing after a
+// restart. Without one it only lives in memory.
+type SubscriptionsRepository struct {
+	plans         map[string]domain.Plan
+	subscriptions map[string]domain.Subscription
+	journal       *journal.Journal
+	mu            sync.RWMutex
+}
+
+// subscriptionEntry is one line of the journal: the latest state of a plan
+// or a subscription
+type subscriptionEntry struct {
+	Plan         *domain.Plan         `json:"plan,omitempty"`
+	Subscription *domain.Subscription `json:"subscription,omitempty"`
+}
+
+func NewSubscriptionsRepository() *SubscriptionsRepository {
+	return &SubscriptionsRepository{
+		plans:         make(map[string]domain.Plan),
+		subscriptions: make(map[string]domain.Subscription),
+	}
+}
+
+// OpenSubscriptionsRepository loads the journal at path, creating it if
+// needed. The journal is compacted on open so it only grows with new
+// changes.
+func OpenSubscriptionsRepository(path string) (*SubscriptionsRepository, error) {
+	r := NewSubscriptionsRepository()
+
+	replay := func(line []byte) error {
+		var entry subscriptionEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		r.applyInMemory(entry)
+		return nil
+	}
+	snapshot := func(write func(entry any) error) error {
+		for id := range r.plans {
+			plan := r.plans[id]
+			if err := write(subscriptionEntry{Plan: &plan}); err != nil {
+				return err
+			}
+		}
+		for id := range r.subscriptions {
+			sub := r.subscriptions[id]
+			if err := write(subscriptionEntry{Subscription: &sub}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	j, err := journal.Open(path, "subscription journal", replay, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	r.journal = j
+
+	return r, nil
+}
+
+func (r *SubscriptionsRepository) Close() error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Close()
+}
+
+// Ping checks the journal, if there is one, can still be written
+func (r *SubscriptionsRepository) Ping(ctx context.Context) error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Ping(ctx)
+}
+
+func (r *SubscriptionsRepository) SavePlan(plan *domain.Plan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(subscriptionEntry{Plan: plan})
+}
+
+func (r *SubscriptionsRepository) FindPlan(id string) (*domain.Plan, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	plan, exists := r.plans[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &plan, nil
+}
+
+func (r *SubscriptionsRepository) SaveSubscription(sub *domain.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(subscriptionEntry{Subscription: sub})
+}
+
+func (r *SubscriptionsRepository) FindSubscription(id string) (*domain.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sub, exists := r.subscriptions[id]
+	if !exists {
+		return nil, nil
+	}
+
+	c := copySubscription(&sub)
+	return &c, nil
+}
+
+// FindDue returns the IDs of billable subscriptions whose next charge is at
+// or before now, oldest first
+func (r *SubscriptionsRepository) FindDue(now time.Time) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	due := make([]domain.Subscription, 0)
+	for _, sub := range r.subscriptions {
+		if sub.IsBillable() && !sub.NextChargeAt.After(now) {
+			due = append(due, sub)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextChargeAt.Before(due[j].NextChargeAt)
+	})
+
+	ids := make([]string, len(due))
+	for i, sub := range due {
+		ids[i] = sub.ID
+	}
+	return ids, nil
+}
+
+// apply writes the entry to the journal, if there is one, and then to memory.
+// Callers hold the write lock.
+func (r *SubscriptionsRepository) apply(entry subscriptionEntry) error {
+	if r.journal != nil {
+		if err := r.journal.Append(entry); err != nil {
+			return err
+		}
+	}
+
+	r.applyInMemory(entry)
+	return nil
+}
+
+func (r *SubscriptionsRepository) applyInMemory(entry subscriptionEntry) {
+	if entry.Plan != nil {
+		r.plans[entry.Plan.ID] = *entry.Plan
+	}
+	if entry.Subscription != nil {
+		r.subscriptions[entry.Subscription.ID] = copySubscription(entry.Subscription)
+	}
+}
+
+func copySubscription(sub *domain.Subscription) domain.Subscription {
+	c := *sub
+	c.Charges = append([]domain.SubscriptionCharge(nil), sub.Charges...)
+	if sub.CanceledAt != nil {
+		t := *sub.CanceledAt
+		c.CanceledAt = &t
+	}
+	if sub.PausedAt != nil {
+		t := *sub.PausedAt
+		c.PausedAt = &t
+	}
+	return c
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionsRepository_Plans(t *testing.T) {
+	repo := NewSubscriptionsRepository()
+
+	require.NoError(t, repo.SavePlan(&domain.Plan{ID: "plan-1", Name: "Pro", Amount: 999}))
+
+	plan, err := repo.FindPlan("plan-1")
+	require.NoError(t, err)
+	require.NotNil(t, plan)
+	assert.Equal(t, "Pro", plan.Name)
+
+	missing, err := repo.FindPlan("plan-2")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestSubscriptionsRepository_StoresCopies(t *testing.T) {
+	repo := NewSubscriptionsRepository()
+
+	sub := &domain.Subscription{
+		ID:      "sub-1",
+		Status:  domain.SubscriptionActive,
+		Charges: []domain.SubscriptionCharge{{Amount: 999, Status: domain.StatusAuthorized}},
+	}
+	require.NoError(t, repo.SaveSubscription(sub))
+
+	// Changes after saving do not leak into the store
+	sub.Status = domain.SubscriptionCanceled
+	sub.Charges[0].Amount = 1
+
+	found, err := repo.FindSubscription("sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, found.Status)
+	assert.Equal(t, 999, found.Charges[0].Amount)
+
+	// Nor do changes to a loaded copy
+	found.Charges = append(found.Charges, domain.SubscriptionCharge{Amount: 5})
+	again, err := repo.FindSubscription("sub-1")
+	require.NoError(t, err)
+	assert.Len(t, again.Charges, 1)
+
+	missing, err := repo.FindSubscription("sub-2")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestSubscriptionsRepository_FindDue(t *testing.T) {
+	repo := NewSubscriptionsRepository()
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	subs := []domain.Subscription{
+		{ID: "later", Status: domain.SubscriptionActive, NextChargeAt: now.Add(time.Hour)},
+		{ID: "due", Status: domain.SubscriptionActive, NextChargeAt: now},
+		{ID: "retry", Status: domain.SubscriptionPastDue, NextChargeAt: now.Add(-time.Hour)},
+		{ID: "paused", Status: domain.SubscriptionPaused, NextChargeAt: now.Add(-time.Hour)},
+		{ID: "canceled", Status: domain.SubscriptionCanceled, NextChargeAt: now.Add(-time.Hour)},
+	}
+	for i := range subs {
+		require.NoError(t, repo.SaveSubscription(&subs[i]))
+	}
+
+	ids, err := repo.FindDue(now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"retry", "due"}, ids)
+}
+
+func TestSubscriptionsRepository_JournalSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), SubscriptionsJournalFile)
+	now := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	repo, err := OpenSubscriptionsRepository(path)
+	require.NoError(t, err)
+	require.NoError(t, repo.SavePlan(&domain.Plan{ID: "plan-1", Name: "Pro", Amount: 999, Currency: "GBP", Interval: domain.IntervalMonth, IntervalCount: 1}))
+
+	sub := &domain.Subscription{
+		ID:           "sub-1",
+		PlanID:       "plan-1",
+		Status:       domain.SubscriptionActive,
+		NextChargeAt: now,
+	}
+	require.NoError(t, repo.SaveSubscription(sub))
+
+	// A declined renewal puts it into dunning
+	sub.Status = domain.SubscriptionPastDue
+	sub.FailedAttempts = 1
+	sub.NextChargeAt = now.Add(24 * time.Hour)
+	sub.Charges = append(sub.Charges, domain.SubscriptionCharge{Amount: 999, Status: domain.StatusDeclined, Attempt: 1})
+	require.NoError(t, repo.SaveSubscription(sub))
+	require.NoError(t, repo.Close())
+
+	reopened, err := OpenSubscriptionsRepository(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	plan, err := reopened.FindPlan("plan-1")
+	require.NoError(t, err)
+	require.NotNil(t, plan)
+	assert.Equal(t, "Pro", plan.Name)
+
+	restored, err := reopened.FindSubscription("sub-1")
+	require.NoError(t, err)
+	require.NotNil(t, restored)
+	assert.Equal(t, domain.SubscriptionPastDue, restored.Status)
+	assert.Equal(t, 1, restored.FailedAttempts)
+	assert.True(t, now.Add(24*time.Hour).Equal(restored.NextChargeAt))
+	require.Len(t, restored.Charges, 1)
+
+	ids, err := reopened.FindDue(now.Add(24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sub-1"}, ids)
+
+	// Compaction collapsed the updates
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+	assert.NoError(t, reopened.Ping(context.Background()))
+}
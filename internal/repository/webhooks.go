@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+)
+
+// WebhooksJournalFile is the name of the webhooks journal in the storage
+// directory
+const WebhooksJournalFile = "webhooks.journal"
+
+// WebhooksRepository holds webhook endpoints and the delivery outbox.
+// Endpoint signing secrets are kept envelope-encrypted, in memory and in the
+// journal alike, and only opened when an endpoint is looked up.
+//
+// Opened with a journal file, every change is appended to the file and
+// synced before the call returns, so queued deliveries survive a restart.
+// Without one it only lives in memory.
+type WebhooksRepository struct {
+	envelope   *envelope.Envelope
+	endpoints  map[string]domain.WebhookEndpoint
+	secrets    map[string]*envelope.Sealed
+	events     map[string]domain.Event
+	deliveries map[string]domain.WebhookDelivery
+	journal    *journal.Journal
+	mu         sync.RWMutex
+}
+
+// journalEntry is one line of the journal. Replaying the entries in order
+// rebuilds the repository. Endpoint never carries its secret, which is in
+// EndpointSecret.
+type journalEntry struct {
+	Endpoint        *domain.WebhookEndpoint  `json:"endpoint,omitempty"`
+	EndpointSecret  *envelope.Sealed         `json:"endpoint_secret,omitempty"`
+	DeletedEndpoint string                   `json:"deleted_endpoint,omitempty"`
+	Event           *domain.Event            `json:"event,omitempty"`
+	Deliveries      []domain.WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+func NewWebhooksRepository(e *envelope.Envelope) *WebhooksRepository {
+	return &WebhooksRepository{
+		envelope:   e,
+		endpoints:  make(map[string]domain.WebhookEndpoint),
+		secrets:    make(map[string]*envelope.Sealed),
+		events:     make(map[string]domain.Event),
+		deliveries: make(map[string]domain.WebhookDelivery),
+	}
+}
+
+// OpenWebhooksRepository loads the journal at path, creating it if needed.
+// The journal is compacted on open so it only grows with new changes.
+func OpenWebhooksRepository(path string, e *envelope.Envelope) (*WebhooksRepository, error) {
+	r := NewWebhooksRepository(e)
+
+	replay := func(line []byte) error {
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return err
+		}
+		// Journals written before secrets were sealed hold them in the
+		// clear; sealing them here lets compaction rewrite them
+		if entry.Endpoint != nil && entry.Endpoint.Secret != "" {
+			sealed, err := r.sealSecret(entry.Endpoint)
+			if err != nil {
+				return err
+			}
+			endpoint := *entry.Endpoint
+			endpoint.Secret = ""
+			entry.Endpoint, entry.EndpointSecret = &endpoint, sealed
+		}
+		r.applyInMemory(entry)
+		return nil
+	}
+	snapshot := func(write func(entry any) error) error {
+		for id := range r.endpoints {
+			e := r.endpoints[id]
+			if err := write(journalEntry{Endpoint: &e, EndpointSecret: r.secrets[id]}); err != nil {
+				return err
+			}
+		}
+		for id := range r.events {
+			e := r.events[id]
+			if err := write(journalEntry{Event: &e}); err != nil {
+				return err
+			}
+		}
+		for id := range r.deliveries {
+			if err := write(journalEntry{Deliveries: []domain.WebhookDelivery{r.deliveries[id]}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	j, err := journal.Open(path, "webhook journal", replay, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	r.journal = j
+
+	return r, nil
+}
+
+func (r *WebhooksRepository) Close() error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Close()
+}
+
+// Ping checks the journal is still open and is still the file at its path,
+// so changes are not being appended to a file that was deleted or replaced
+func (r *WebhooksRepository) Ping(ctx context.Context) error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Ping(ctx)
+}
+
+// SaveEndpoint stores an endpoint, sealing its secret with the endpoint ID
+// so it cannot be moved onto another endpoint
+func (r *WebhooksRepository) SaveEndpoint(endpoint *domain.WebhookEndpoint) error {
+	sealed, err := r.sealSecret(endpoint)
+	if err != nil {
+		return err
+	}
+	stored := *endpoint
+	stored.Secret = ""
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(journalEntry{Endpoint: &stored, EndpointSecret: sealed})
+}
+
+// FindEndpoint returns the endpoint with its secret, or nil if there is no
+// such endpoint
+func (r *WebhooksRepository) FindEndpoint(id string) (*domain.WebhookEndpoint, error) {
+	r.mu.RLock()
+	endpoint, exists := r.endpoints[id]
+	sealed := r.secrets[id]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, nil
+	}
+
+	if sealed != nil {
+		secret, err := r.envelope.Open(sealed, []byte(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt webhook secret: %w", err)
+		}
+		endpoint.Secret = string(secret)
+	}
+
+	return &endpoint, nil
+}
+
+// ListEndpoints returns every endpoint, oldest first, without their secrets
+func (r *WebhooksRepository) ListEndpoints() ([]domain.WebhookEndpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	endpoints := make([]domain.WebhookEndpoint, 0, len(r.endpoints))
+	for _, e := range r.endpoints {
+		endpoints = append(endpoints, e)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].CreatedAt.Before(endpoints[j].CreatedAt)
+	})
+	return endpoints, nil
+}
+
+func (r *WebhooksRepository) DeleteEndpoint(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(journalEntry{DeletedEndpoint: id})
+}
+
+// Enqueue stores an event together with its deliveries in one write, so an
+// event is never recorded without the deliveries that announce it
+func (r *WebhooksRepository) Enqueue(event *domain.Event, deliveries []domain.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.apply(journalEntry{Event: event, Deliveries: deliveries})
+}
+
+func (r *WebhooksRepository) FindEvent(id string) (*domain.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	event, exists := r.events[id]
+	if !exists {
+		return nil, nil
+	}
+
+	return &event, nil
+}
+
+// SaveDelivery stores a delivery read with FindDelivery, unless it has been
+// saved by someone else since, in which case it returns
+// domain.ErrDeliveryConflict. On success delivery.Version is moved on, so
+// the caller can save it again.
+func (r *WebhooksRepository) SaveDelivery(delivery *domain.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, exists := r.deliveries[delivery.ID]; exists && stored.Version != delivery.Version {
+		return domain.ErrDeliveryConflict
+	}
+
+	saved := *delivery
+	saved.Version++
+	if err := r.apply(journalEntry{Deliveries: []domain.WebhookDelivery{saved}}); err != nil {
+		return err
+	}
+
+	delivery.Version = saved.Version
+	return nil
+}
+
+func (r *WebhooksRepository) FindDelivery(id string) (*domain.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	delivery, exists := r.deliveries[id]
+	if !exists {
+		return nil, nil
+	}
+
+	c := copyDelivery(&delivery)
+	return &c, nil
+}
+
+// FindDueDeliveries returns the IDs of pending deliveries whose next attempt
+// is at or before now, oldest first
+func (r *WebhooksRepository) FindDueDeliveries(now time.Time) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	due := make([]domain.WebhookDelivery, 0)
+	for _, d := range r.deliveries {
+		if d.Status == domain.DeliveryPending && !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+
+	ids := make([]string, len(due))
+	for i, d := range due {
+		ids[i] = d.ID
+	}
+	return ids, nil
+}
+
+// ListDeliveries returns an endpoint's deliveries, newest first. An empty
+// status matches every delivery.
+func (r *WebhooksRepository) ListDeliveries(endpointID string, status domain.DeliveryStatus) ([]domain.WebhookDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := make([]domain.WebhookDelivery, 0)
+	for _, d := range r.deliveries {
+		if d.EndpointID != endpointID || (status != "" && d.Status != status) {
+			continue
+		}
+		deliveries = append(deliveries, copyDelivery(&d))
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+	return deliveries, nil
+}
+
+// RewrapKeys moves every endpoint secret onto the current master key,
+// journaling the endpoints it changes
+func (r *WebhooksRepository) RewrapKeys(e *envelope.Envelope) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := 0
+	for id, secret := range r.secrets {
+		sealed := *secret
+		ok, err := e.Rewrap(&sealed)
+		if err != nil {
+			return changed, fmt.Errorf("webhook endpoint %s: %w", id, err)
+		}
+		if !ok {
+			continue
+		}
+		endpoint := r.endpoints[id]
+		if err := r.apply(journalEntry{Endpoint: &endpoint, EndpointSecret: &sealed}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+func (r *WebhooksRepository) sealSecret(endpoint *domain.WebhookEndpoint) (*envelope.Sealed, error) {
+	sealed, err := r.envelope.Seal([]byte(endpoint.Secret), []byte(endpoint.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	return sealed, nil
+}
+
+// apply writes the entry to the journal, if there is one, and then to memory.
+// Callers hold the write lock.
+func (r *WebhooksRepository) apply(entry journalEntry) error {
+	if r.journal != nil {
+		if err := r.journal.Append(entry); err != nil {
+			return err
+		}
+	}
+
+	r.applyInMemory(entry)
+	return nil
+}
+
+func (r *WebhooksRepository) applyInMemory(entry journalEntry) {
+	if entry.Endpoint != nil {
+		e := *entry.Endpoint
+		e.EventTypes = append([]domain.EventType(nil), entry.Endpoint.EventTypes...)
+		r.endpoints[e.ID] = e
+		r.secrets[e.ID] = entry.EndpointSecret
+	}
+
+	if entry.DeletedEndpoint != "" {
+		delete(r.endpoints, entry.DeletedEndpoint)
+		delete(r.secrets, entry.DeletedEndpoint)
+	}
+
+	if entry.Event != nil {
+		r.events[entry.Event.ID] = *entry.Event
+	}
+
+	for i := range entry.Deliveries {
+		r.deliveries[entry.Deliveries[i].ID] = copyDelivery(&entry.Deliveries[i])
+	}
+}
+
+func copyDelivery(d *domain.WebhookDelivery) domain.WebhookDelivery {
+	c := *d
+	c.Attempts = append([]domain.DeliveryAttempt(nil), d.Attempts...)
+	return c
+}
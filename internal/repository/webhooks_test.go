@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedWebhooks(t *testing.T, repo *WebhooksRepository, now time.Time) {
+	require.NoError(t, repo.SaveEndpoint(&domain.WebhookEndpoint{ID: "ep-1", URL: "https://a.example.com", Secret: "whsec_1", CreatedAt: now}))
+
+	event := &domain.Event{ID: "evt_1", Type: domain.EventPaymentAuthorized, PaymentID: "payment-1", Payload: []byte(`{"id":"evt_1"}`), CreatedAt: now}
+	require.NoError(t, repo.Enqueue(event, []domain.WebhookDelivery{
+		{ID: "d-1", EventID: "evt_1", EndpointID: "ep-1", Status: domain.DeliveryPending, NextAttemptAt: now, CreatedAt: now},
+	}))
+}
+
+func TestWebhooksRepository_FindDueDeliveries(t *testing.T) {
+	repo := NewWebhooksRepository(newTestEnvelope(t))
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+	seedWebhooks(t, repo, now)
+
+	require.NoError(t, repo.SaveDelivery(&domain.WebhookDelivery{ID: "d-2", EndpointID: "ep-1", Status: domain.DeliveryPending, NextAttemptAt: now.Add(time.Minute)}))
+	require.NoError(t, repo.SaveDelivery(&domain.WebhookDelivery{ID: "d-3", EndpointID: "ep-1", Status: domain.DeliveryDead, NextAttemptAt: now.Add(-time.Minute)}))
+
+	ids, err := repo.FindDueDeliveries(now)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d-1"}, ids)
+
+	dead, err := repo.ListDeliveries("ep-1", domain.DeliveryDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "d-3", dead[0].ID)
+}
+
+func TestWebhooksRepository_JournalSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.journal")
+	e := newTestEnvelope(t)
+	now := time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	repo, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	seedWebhooks(t, repo, now)
+
+	delivery, err := repo.FindDelivery("d-1")
+	require.NoError(t, err)
+	delivery.Failures = 1
+	delivery.NextAttemptAt = now.Add(time.Minute)
+	delivery.Attempts = append(delivery.Attempts, domain.DeliveryAttempt{At: now, StatusCode: 500, Error: "boom"})
+	require.NoError(t, repo.SaveDelivery(delivery))
+	require.NoError(t, repo.Close())
+
+	// Simulate a crash part way through the next write
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"deliveries":[{"ID":"d-9"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	reopened, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	endpoint, err := reopened.FindEndpoint("ep-1")
+	require.NoError(t, err)
+	require.NotNil(t, endpoint)
+	assert.Equal(t, "whsec_1", endpoint.Secret)
+
+	event, err := reopened.FindEvent("evt_1")
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	assert.Equal(t, `{"id":"evt_1"}`, string(event.Payload))
+
+	restored, err := reopened.FindDelivery("d-1")
+	require.NoError(t, err)
+	require.NotNil(t, restored)
+	assert.Equal(t, domain.DeliveryPending, restored.Status)
+	assert.Equal(t, 1, restored.Failures)
+	assert.True(t, now.Add(time.Minute).Equal(restored.NextAttemptAt))
+	require.Len(t, restored.Attempts, 1)
+	assert.Equal(t, 500, restored.Attempts[0].StatusCode)
+
+	ids, err := reopened.FindDueDeliveries(now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d-1"}, ids)
+
+	// Compaction dropped the torn line and collapsed the updates
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "d-9")
+	assert.NotContains(t, string(data), "whsec_1", "endpoint secrets are sealed")
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestWebhooksRepository_SealsLegacySecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.journal")
+	e := newTestEnvelope(t)
+	legacy := `{"endpoint":{"ID":"ep-1","URL":"https://a.example.com","Secret":"whsec_1"}}` + "\n"
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0o600))
+
+	repo, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	defer repo.Close()
+
+	endpoint, err := repo.FindEndpoint("ep-1")
+	require.NoError(t, err)
+	require.NotNil(t, endpoint)
+	assert.Equal(t, "whsec_1", endpoint.Secret)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "whsec_1")
+}
+
+func TestWebhooksRepository_SaveDeliveryConflict(t *testing.T) {
+	repo := NewWebhooksRepository(newTestEnvelope(t))
+	seedWebhooks(t, repo, time.Now())
+
+	first, err := repo.FindDelivery("d-1")
+	require.NoError(t, err)
+	second, err := repo.FindDelivery("d-1")
+	require.NoError(t, err)
+
+	first.Status = domain.DeliverySucceeded
+	require.NoError(t, repo.SaveDelivery(first))
+	assert.ErrorIs(t, repo.SaveDelivery(second), domain.ErrDeliveryConflict, "a stale read cannot overwrite a newer save")
+
+	// The saved copy carries the new version, so it can be saved again
+	first.Failures = 1
+	require.NoError(t, repo.SaveDelivery(first))
+
+	stored, err := repo.FindDelivery("d-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliverySucceeded, stored.Status)
+	assert.Equal(t, 2, stored.Version)
+}
+
+func TestWebhooksRepository_RewrapKeys(t *testing.T) {
+	v1, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	v2, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	old, err := envelope.NewStaticKeyProvider(1, map[int][]byte{1: v1})
+	require.NoError(t, err)
+	rotated, err := envelope.NewStaticKeyProvider(2, map[int][]byte{1: v1, 2: v2})
+	require.NoError(t, err)
+	onlyV2, err := envelope.NewStaticKeyProvider(2, map[int][]byte{2: v2})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "webhooks.journal")
+	repo, err := OpenWebhooksRepository(path, envelope.New(old))
+	require.NoError(t, err)
+	seedWebhooks(t, repo, time.Now())
+
+	changed, err := repo.RewrapKeys(envelope.New(rotated))
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	require.NoError(t, repo.Close())
+
+	reopened, err := OpenWebhooksRepository(path, envelope.New(onlyV2))
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	endpoint, err := reopened.FindEndpoint("ep-1")
+	require.NoError(t, err)
+	assert.Equal(t, "whsec_1", endpoint.Secret)
+}
+
+func TestWebhooksRepository_DeleteEndpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.journal")
+	e := newTestEnvelope(t)
+
+	repo, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	seedWebhooks(t, repo, time.Now())
+	require.NoError(t, repo.DeleteEndpoint("ep-1"))
+	require.NoError(t, repo.Close())
+
+	reopened, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	endpoint, err := reopened.FindEndpoint("ep-1")
+	require.NoError(t, err)
+	assert.Nil(t, endpoint)
+}
+
+func TestWebhooksRepository_Ping(t *testing.T) {
+	assert.NoError(t, NewWebhooksRepository(newTestEnvelope(t)).Ping(context.Background()))
+
+	path := filepath.Join(t.TempDir(), "webhooks.journal")
+	e := newTestEnvelope(t)
+	repo, err := OpenWebhooksRepository(path, e)
+	require.NoError(t, err)
+	assert.NoError(t, repo.Ping(context.Background()))
+
+	require.NoError(t, os.Remove(path))
+	assert.Error(t, repo.Ping(context.Background()))
+
+	require.NoError(t, repo.Close())
+	assert.Error(t, repo.Ping(context.Background()))
+}
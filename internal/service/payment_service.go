@@ -1,67 +1,511 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
 	"github.com/google/uuid"
 )
 
 type PaymentRepository interface {
-	Save(payment *domain.Payment) error
-	FindByID(id string) (*domain.Payment, error)
+	Save(ctx context.Context, payment *domain.Payment) error
+	FindByID(ctx context.Context, id string) (*domain.Payment, error)
+	FindByNetworkTransactionID(ctx context.Context, networkTransactionID string) (*domain.Payment, error)
+	List(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error)
+	Events(ctx context.Context, id string) ([]domain.PaymentEvent, error)
+}
+
+// CardVault describes a merchant's tokenized cards without revealing the
+// card number
+type CardVault interface {
+	Describe(merchantID, token string) (*domain.Card, error)
+}
+
+// EventPublisher announces payment outcomes, for example to webhooks
+type EventPublisher interface {
+	PublishPaymentEvent(payment *domain.Payment) error
+}
+
+// PaymentQueue holds payments accepted for asynchronous processing until a
+// worker sends them to the bank
+type PaymentQueue interface {
+	// Enqueue calls store before a worker can pick the payment up, and
+	// drops it if store fails
+	Enqueue(payment *domain.Payment, store func() error) error
+}
+
+// PaymentObserver is told the outcome of every payment the bank answered,
+// for example to record metrics
+type PaymentObserver interface {
+	ObservePayment(payment *domain.Payment)
+}
+
+// Blocklist reports whether a merchant refuses payments from a card
+type Blocklist interface {
+	Blocks(merchantID string, card domain.Card) (bool, error)
 }
 
 type PaymentService struct {
 	bankClient client.BankClient
 	repository PaymentRepository
+	vault      CardVault
+	publisher  EventPublisher
+	observer   PaymentObserver
+	queue      PaymentQueue
+	blocklist  Blocklist
+
+	settlingMu sync.Mutex
+	// settling holds the IDs of payments being captured, voided or
+	// refunded, so two settlements of one payment never reach the bank
+	// together
+	settling map[string]bool
+}
+
+// Option configures a PaymentService
+type Option func(*PaymentService)
+
+// WithCardVault enables payments made with a vault token
+func WithCardVault(vault CardVault) Option {
+	return func(s *PaymentService) {
+		s.vault = vault
+	}
+}
+
+// WithEventPublisher publishes an event for every stored payment
+func WithEventPublisher(publisher EventPublisher) Option {
+	return func(s *PaymentService) {
+		s.publisher = publisher
+	}
+}
+
+// WithPaymentObserver reports the outcome of every payment to observer
+func WithPaymentObserver(observer PaymentObserver) Option {
+	return func(s *PaymentService) {
+		s.observer = observer
+	}
+}
+
+// WithPaymentQueue enables asynchronous payments through SubmitPayment
+func WithPaymentQueue(queue PaymentQueue) Option {
+	return func(s *PaymentService) {
+		s.queue = queue
+	}
+}
+
+// WithBlocklist refuses payments from cards the merchant has blocked
+func WithBlocklist(blocklist Blocklist) Option {
+	return func(s *PaymentService) {
+		s.blocklist = blocklist
+	}
 }
 
-func NewPaymentService(bankClient client.BankClient, repository PaymentRepository) *PaymentService {
-	return &PaymentService{
+func NewPaymentService(bankClient client.BankClient, repository PaymentRepository, opts ...Option) *PaymentService {
+	s := &PaymentService{
 		bankClient: bankClient,
 		repository: repository,
+		settling:   make(map[string]bool),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
-// 1. Validate the payment (already done in domain)
+// 1. Validate the payment (already done in domain, completed here for tokens)
 // 2. Call the bank to authorize
 // 3. Update payment status based on bank response
 // 4. Store the payment
-// 5. Return the payment
-func (s *PaymentService) ProcessPayment(payment *domain.Payment) (*domain.Payment, error) {
-	payment.ID = uuid.New().String()
+// 5. Publish the outcome
+// 6. Return the payment
+func (s *PaymentService) ProcessPayment(ctx context.Context, payment *domain.Payment) (_ *domain.Payment, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.ProcessPayment")
+	defer func() {
+		span.SetAttributes(tracing.PaymentAttributes(payment)...)
+		tracing.End(span, err)
+	}()
 
-	bankResp, err := s.bankClient.ProcessPayment(payment)
-	if err != nil {
+	if err := s.prepare(ctx, payment); err != nil {
+		return nil, err
+	}
+
+	if err := s.authorize(ctx, payment); err != nil {
 		// If bank is unavailable or returns an error, we don't store the payment
 		// This is a rejection at the bank level
-		return nil, fmt.Errorf("failed to process payment with bank: %w", err)
+		return nil, err
+	}
+
+	if err := s.repository.Save(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to save payment: %w", err)
+	}
+
+	s.announce(ctx, payment)
+
+	return payment, nil
+}
+
+// SubmitPayment accepts a payment for asynchronous processing. It is stored
+// as pending and queued, and the bank is called later by
+// ProcessQueuedPayment.
+func (s *PaymentService) SubmitPayment(ctx context.Context, payment *domain.Payment) (_ *domain.Payment, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.SubmitPayment")
+	defer func() {
+		span.SetAttributes(tracing.PaymentAttributes(payment)...)
+		tracing.End(span, err)
+	}()
+
+	if s.queue == nil {
+		return nil, errors.New("asynchronous payments are not enabled")
+	}
+
+	if err := s.prepare(ctx, payment); err != nil {
+		return nil, err
+	}
+	payment.SetPending()
+
+	// Stored as pending once the queue has taken it, so a payment turned
+	// away is never stored, but before a worker can store its outcome
+	store := func() error {
+		if err := s.repository.Save(ctx, payment); err != nil {
+			return fmt.Errorf("failed to save payment: %w", err)
+		}
+		return nil
+	}
+	if err := s.queue.Enqueue(payment, store); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "payment queued",
+		"payment_id", payment.ID,
+		"currency", payment.Currency,
+		"amount", payment.Amount,
+	)
+
+	return payment, nil
+}
+
+// ProcessQueuedPayment sends a payment accepted by SubmitPayment to the bank
+// and stores the outcome. A payment the bank refuses to consider is stored
+// as rejected; other bank errors are returned so the call can be retried.
+func (s *PaymentService) ProcessQueuedPayment(ctx context.Context, payment *domain.Payment) (_ *domain.Payment, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.ProcessQueuedPayment")
+	defer func() {
+		span.SetAttributes(tracing.PaymentAttributes(payment)...)
+		tracing.End(span, err)
+	}()
+
+	if err := s.authorize(ctx, payment); err != nil {
+		if !errors.Is(err, client.ErrBankRejected) {
+			return nil, err
+		}
+		payment.SetRejected()
+	}
+
+	// The bank has answered, so the outcome is final even if it cannot be
+	// stored; retrying would charge the card again
+	if err := s.repository.Save(ctx, payment); err != nil {
+		slog.ErrorContext(ctx, "failed to save queued payment", "payment_id", payment.ID, "status", payment.Status, "error", err)
+	}
+
+	s.announce(ctx, payment)
+
+	return payment, nil
+}
+
+// RejectQueuedPayment gives up on a queued payment the bank could not be
+// reached for
+func (s *PaymentService) RejectQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	payment.SetRejected()
+
+	if err := s.repository.Save(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to save payment: %w", err)
+	}
+
+	s.announce(ctx, payment)
+
+	return payment, nil
+}
+
+// ResolveQueuedPayment stores the outcome an operator found with the bank
+// for a queued payment whose outcome was unknown
+func (s *PaymentService) ResolveQueuedPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	if err := s.repository.Save(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to save payment: %w", err)
+	}
+
+	s.announce(ctx, payment)
+
+	return payment, nil
+}
+
+// prepare resolves the card token, checks the stored credential it is
+// charged under and the merchant's blocklist, and assigns the payment its
+// IDs and owner. The owner is the authenticated merchant; payments made
+// without one, e.g. subscription renewals, keep the owner they were given.
+func (s *PaymentService) prepare(ctx context.Context, payment *domain.Payment) error {
+	if m, ok := merchant.FromContext(ctx); ok {
+		payment.MerchantID = m.ID
+	}
+
+	if payment.Card.IsTokenized() {
+		if err := s.resolveToken(payment); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkPreviousTransaction(ctx, payment); err != nil {
+		return err
+	}
+
+	if s.blocklist != nil {
+		blocked, err := s.blocklist.Blocks(payment.MerchantID, payment.Card)
+		if err != nil {
+			return fmt.Errorf("failed to check blocklist: %w", err)
+		}
+		if blocked {
+			return domain.ErrCardBlocked
+		}
+	}
+
+	payment.ID = uuid.New().String()
+	payment.RequestID = audit.RequestID(ctx)
+	payment.CorrelationID = audit.CorrelationID(ctx)
+
+	return nil
+}
+
+// authorize asks the bank for a decision and records it on the payment
+func (s *PaymentService) authorize(ctx context.Context, payment *domain.Payment) error {
+	bankResp, err := s.bankClient.ProcessPayment(ctx, payment)
+	if err != nil {
+		slog.WarnContext(ctx, "bank call failed",
+			"payment_id", payment.ID,
+			"error_class", client.ErrorClass(err),
+			"error", err,
+		)
+		return fmt.Errorf("failed to process payment with bank: %w", err)
 	}
 
 	if bankResp.Authorized {
 		payment.SetAuthorized()
+		payment.AuthorizationCode = bankResp.AuthorizationCode
 	} else {
 		payment.SetDeclined()
+		payment.DeclineReason = bankResp.DeclineReason
 	}
 
-	if err := s.repository.Save(payment); err != nil {
-		return nil, fmt.Errorf("failed to save payment: %w", err)
+	payment.NetworkTransactionID = bankResp.NetworkTransactionID
+
+	if s.observer != nil {
+		s.observer.ObservePayment(payment)
+	}
+
+	return nil
+}
+
+// checkPreviousTransaction makes sure a payment on a stored card quotes the
+// network transaction ID of an earlier authorized payment by the same
+// merchant on the same card, so the acquirer is never sent a reference the
+// gateway did not get from it
+func (s *PaymentService) checkPreviousTransaction(ctx context.Context, payment *domain.Payment) error {
+	sc := payment.StoredCredential
+	if sc == nil || sc.PreviousNetworkTransactionID == "" {
+		return nil
+	}
+
+	previous, err := s.repository.FindByNetworkTransactionID(ctx, sc.PreviousNetworkTransactionID)
+	if err != nil {
+		return fmt.Errorf("failed to find previous transaction: %w", err)
+	}
+	if previous == nil || !previous.IsAuthorized() ||
+		previous.MerchantID != payment.MerchantID ||
+		previous.Card.GetLastFourDigits() != payment.Card.GetLastFourDigits() {
+		return domain.ErrPreviousTransactionUnknown
+	}
+
+	return nil
+}
+
+// CapturePayment takes amount of an authorized payment made by the merchant
+// in ctx from the card, or all of it if amount is 0
+func (s *PaymentService) CapturePayment(ctx context.Context, id string, amount int) (*domain.Payment, error) {
+	return s.settle(ctx, id, client.SettlementCapture, func(p *domain.Payment) (int, error) {
+		err := p.Capture(amount)
+		return p.CapturedAmount, err
+	})
+}
+
+// VoidPayment releases an authorized payment made by the merchant in ctx
+// that has not been captured
+func (s *PaymentService) VoidPayment(ctx context.Context, id string) (*domain.Payment, error) {
+	return s.settle(ctx, id, client.SettlementVoid, func(p *domain.Payment) (int, error) {
+		return 0, p.Void()
+	})
+}
+
+// RefundPayment gives amount of a captured payment made by the merchant in
+// ctx back to the card, or all that has not been refunded yet if amount is
+// 0
+func (s *PaymentService) RefundPayment(ctx context.Context, id string, amount int) (*domain.Payment, error) {
+	return s.settle(ctx, id, client.SettlementRefund, func(p *domain.Payment) (int, error) {
+		refunded := p.RefundedAmount
+		err := p.Refund(amount)
+		return p.RefundedAmount - refunded, err
+	})
+}
+
+// settle applies change to the payment, sends the bank the settlement it
+// calls for, and stores and publishes the payment once the bank has
+// agreed. change returns the amount the bank is asked to settle.
+func (s *PaymentService) settle(ctx context.Context, id string, settlementType client.SettlementType, change func(p *domain.Payment) (int, error)) (_ *domain.Payment, err error) {
+	ctx, span := tracing.Start(ctx, "PaymentService.Settle")
+	var payment *domain.Payment
+	defer func() {
+		if payment != nil {
+			span.SetAttributes(tracing.PaymentAttributes(payment)...)
+		}
+		tracing.End(span, err)
+	}()
+
+	settler, ok := s.bankClient.(client.Settler)
+	if !ok {
+		return nil, domain.ErrSettlementUnsupported
 	}
 
+	s.settlingMu.Lock()
+	if s.settling[id] {
+		s.settlingMu.Unlock()
+		return nil, domain.ErrPaymentSettling
+	}
+	s.settling[id] = true
+	s.settlingMu.Unlock()
+	defer func() {
+		s.settlingMu.Lock()
+		delete(s.settling, id)
+		s.settlingMu.Unlock()
+	}()
+
+	payment, err = s.GetPayment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := change(payment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = settler.Settle(ctx, &client.Settlement{
+		Type:              settlementType,
+		AuthorizationCode: payment.AuthorizationCode,
+		Amount:            amount,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "bank settlement failed",
+			"payment_id", payment.ID,
+			"settlement", settlementType,
+			"error_class", client.ErrorClass(err),
+			"error", err,
+		)
+		return nil, fmt.Errorf("failed to %s payment with bank: %w", settlementType, err)
+	}
+
+	// As with an authorization, the bank has acted, so the merchant is
+	// told it did even if the payment cannot be stored
+	if err := s.repository.Save(ctx, payment); err != nil {
+		slog.ErrorContext(ctx, "failed to save settled payment", "payment_id", payment.ID, "status", payment.Status, "error", err)
+	}
+
+	s.announce(ctx, payment)
+
 	return payment, nil
 }
 
-func (s *PaymentService) GetPayment(id string) (*domain.Payment, error) {
-	payment, err := s.repository.FindByID(id)
+// announce logs and publishes the outcome of a stored payment
+func (s *PaymentService) announce(ctx context.Context, payment *domain.Payment) {
+	slog.InfoContext(ctx, "payment processed",
+		"payment_id", payment.ID,
+		"status", payment.Status,
+		"currency", payment.Currency,
+		"amount", payment.Amount,
+	)
+
+	if s.publisher != nil {
+		// The payment already happened; a publishing failure must not make
+		// the merchant think it did not
+		if err := s.publisher.PublishPaymentEvent(payment); err != nil {
+			slog.ErrorContext(ctx, "failed to publish payment event", "payment_id", payment.ID, "error", err)
+		}
+	}
+}
+
+// resolveToken fills in the card details the vault is allowed to expose and
+// validates the expiry, which is unknown until the token is resolved. Only
+// tokens of the merchant the payment belongs to are resolved.
+func (s *PaymentService) resolveToken(payment *domain.Payment) error {
+	if s.vault == nil {
+		return domain.ErrTokenNotFound
+	}
+
+	stored, err := s.vault.Describe(payment.MerchantID, payment.Card.Token)
+	if err != nil {
+		return err
+	}
+
+	payment.Card.LastFour = stored.LastFour
+	payment.Card.ExpiryMonth = stored.ExpiryMonth
+	payment.Card.ExpiryYear = stored.ExpiryYear
+
+	return payment.Validate()
+}
+
+// GetPayment returns a payment made by the merchant in ctx. Other
+// merchants' payments are reported as not found, so their IDs cannot be
+// probed.
+func (s *PaymentService) GetPayment(ctx context.Context, id string) (*domain.Payment, error) {
+	payment, err := s.repository.FindByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if payment == nil {
+	if payment == nil || payment.MerchantID != merchant.IDFrom(ctx) {
 		return nil, domain.ErrPaymentNotFound
 	}
 
 	return payment, nil
 }
+
+// ListPayments returns a page of the payments made by the merchant in ctx,
+// newest first
+func (s *PaymentService) ListPayments(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error) {
+	query.MerchantID = merchant.IDFrom(ctx)
+	return s.repository.List(ctx, query)
+}
+
+// GetPaymentEvents returns every recorded change to a payment made by the
+// merchant in ctx, oldest first
+func (s *PaymentService) GetPaymentEvents(ctx context.Context, id string) ([]domain.PaymentEvent, error) {
+	if _, err := s.GetPayment(ctx, id); err != nil {
+		return nil, err
+	}
+
+	events, err := s.repository.Events(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return nil, domain.ErrPaymentNotFound
+	}
+
+	return events, nil
+}
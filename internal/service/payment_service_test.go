@@ -1,11 +1,17 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/async"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -15,7 +21,7 @@ type MockBankClient struct {
 	mock.Mock
 }
 
-func (m *MockBankClient) ProcessPayment(payment *domain.Payment) (*client.BankResponse, error) {
+func (m *MockBankClient) ProcessPayment(ctx context.Context, payment *domain.Payment) (*client.BankResponse, error) {
 	args := m.Called(payment)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -27,12 +33,12 @@ type MockPaymentRepository struct {
 	mock.Mock
 }
 
-func (m *MockPaymentRepository) Save(payment *domain.Payment) error {
+func (m *MockPaymentRepository) Save(ctx context.Context, payment *domain.Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
-func (m *MockPaymentRepository) FindByID(id string) (*domain.Payment, error) {
+func (m *MockPaymentRepository) FindByID(ctx context.Context, id string) (*domain.Payment, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -40,6 +46,39 @@ func (m *MockPaymentRepository) FindByID(id string) (*domain.Payment, error) {
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
+func (m *MockPaymentRepository) FindByNetworkTransactionID(ctx context.Context, networkTransactionID string) (*domain.Payment, error) {
+	args := m.Called(networkTransactionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepository) List(ctx context.Context, query domain.PaymentQuery) (*domain.PaymentPage, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PaymentPage), args.Error(1)
+}
+
+func (m *MockPaymentRepository) Events(ctx context.Context, id string) ([]domain.PaymentEvent, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.PaymentEvent), args.Error(1)
+}
+
+type MockEventPublisher struct {
+	mock.Mock
+}
+
+func (m *MockEventPublisher) PublishPaymentEvent(payment *domain.Payment) error {
+	args := m.Called(payment)
+	return args.Error(0)
+}
+
 func TestPaymentService_ProcessPayment_Authorized(t *testing.T) {
 
 	mockBank := new(MockBankClient)
@@ -66,7 +105,7 @@ func TestPaymentService_ProcessPayment_Authorized(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.ProcessPayment(payment)
+	result, err := service.ProcessPayment(context.Background(), payment)
 
 	require.NoError(t, err)
 	assert.NotNil(t, result)
@@ -77,6 +116,62 @@ func TestPaymentService_ProcessPayment_Authorized(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestPaymentService_ProcessPayment_NetworkTransactionID(t *testing.T) {
+	tests := []struct {
+		name         string
+		bankResponse *client.BankResponse
+		expected     string
+	}{
+		{
+			name: "returned by the bank",
+			bankResponse: &client.BankResponse{
+				Authorized:           true,
+				AuthorizationCode:    "auth-code-123",
+				NetworkTransactionID: "ntid-123",
+			},
+			expected: "ntid-123",
+		},
+		{
+			name: "not made up when the bank has none",
+			bankResponse: &client.BankResponse{
+				Authorized:        true,
+				AuthorizationCode: "auth-code-123",
+			},
+			expected: "",
+		},
+		{
+			name:         "declined without reference",
+			bankResponse: &client.BankResponse{Authorized: false},
+			expected:     "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBank := new(MockBankClient)
+			mockRepo := new(MockPaymentRepository)
+			mockBank.On("ProcessPayment", mock.Anything).Return(tt.bankResponse, nil)
+			mockRepo.On("Save", mock.Anything).Return(nil)
+
+			service := NewPaymentService(mockBank, mockRepo)
+
+			result, err := service.ProcessPayment(context.Background(), &domain.Payment{
+				Card: domain.Card{
+					Number:      "2222405343248877",
+					ExpiryMonth: 4,
+					ExpiryYear:  2025,
+					CVV:         "123",
+				},
+				Currency: "GBP",
+				Amount:   100,
+			})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result.NetworkTransactionID)
+		})
+	}
+}
+
 func TestPaymentService_ProcessPayment_Declined(t *testing.T) {
 
 	mockBank := new(MockBankClient)
@@ -97,18 +192,20 @@ func TestPaymentService_ProcessPayment_Declined(t *testing.T) {
 	mockBank.On("ProcessPayment", payment).Return(&client.BankResponse{
 		Authorized:        false,
 		AuthorizationCode: "",
+		DeclineReason:     domain.DeclineInsufficientFunds,
 	}, nil)
 
 	mockRepo.On("Save", payment).Return(nil)
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.ProcessPayment(payment)
+	result, err := service.ProcessPayment(context.Background(), payment)
 
 	require.NoError(t, err)
 	assert.NotNil(t, result)
 	assert.NotEmpty(t, result.ID)
 	assert.Equal(t, domain.StatusDeclined, result.Status)
+	assert.Equal(t, domain.DeclineInsufficientFunds, result.DeclineReason)
 
 	mockBank.AssertExpectations(t)
 	mockRepo.AssertExpectations(t)
@@ -136,7 +233,7 @@ func TestPaymentService_ProcessPayment_BankError(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.ProcessPayment(payment)
+	result, err := service.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, result)
@@ -171,7 +268,7 @@ func TestPaymentService_ProcessPayment_RepositoryError(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.ProcessPayment(payment)
+	result, err := service.ProcessPayment(context.Background(), payment)
 
 	require.Error(t, err)
 	assert.Nil(t, result)
@@ -203,7 +300,7 @@ func TestPaymentService_GetPayment_Found(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.GetPayment("test-payment-id")
+	result, err := service.GetPayment(context.Background(), "test-payment-id")
 
 	require.NoError(t, err)
 	assert.NotNil(t, result)
@@ -222,7 +319,7 @@ func TestPaymentService_GetPayment_NotFound(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.GetPayment("non-existent-id")
+	result, err := service.GetPayment(context.Background(), "non-existent-id")
 
 	require.Error(t, err)
 	assert.Nil(t, result)
@@ -239,7 +336,7 @@ func TestPaymentService_GetPayment_RepositoryError(t *testing.T) {
 
 	service := NewPaymentService(mockBank, mockRepo)
 
-	result, err := service.GetPayment("test-id")
+	result, err := service.GetPayment(context.Background(), "test-id")
 
 	require.Error(t, err)
 	assert.Nil(t, result)
@@ -283,10 +380,659 @@ func TestPaymentService_IDGeneration(t *testing.T) {
 		Amount:   100,
 	}
 
-	result1, _ := service.ProcessPayment(payment1)
-	result2, _ := service.ProcessPayment(payment2)
+	result1, _ := service.ProcessPayment(context.Background(), payment1)
+	result2, _ := service.ProcessPayment(context.Background(), payment2)
 
 	assert.NotEmpty(t, result1.ID)
 	assert.NotEmpty(t, result2.ID)
 	assert.NotEqual(t, result1.ID, result2.ID)
 }
+
+type MockCardVault struct {
+	mock.Mock
+}
+
+func (m *MockCardVault) Describe(merchantID, token string) (*domain.Card, error) {
+	args := m.Called(merchantID, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Card), args.Error(1)
+}
+
+func TestPaymentService_ProcessPayment_Tokenized(t *testing.T) {
+
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	mockVault := new(MockCardVault)
+
+	payment := &domain.Payment{
+		Card: domain.Card{
+			Token: "tok_abc",
+			CVV:   "123",
+		},
+		Currency: "GBP",
+		Amount:   100,
+		Status:   domain.StatusRejected,
+	}
+
+	mockVault.On("Describe", "merchant-a", "tok_abc").Return(&domain.Card{
+		Token:       "tok_abc",
+		LastFour:    "8877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	}, nil)
+	mockBank.On("ProcessPayment", payment).Return(&client.BankResponse{
+		Authorized:        true,
+		AuthorizationCode: "auth-code-123",
+	}, nil)
+	mockRepo.On("Save", payment).Return(nil)
+
+	service := NewPaymentService(mockBank, mockRepo, WithCardVault(mockVault))
+
+	result, err := service.ProcessPayment(merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-a"}), payment)
+
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusAuthorized, result.Status)
+	assert.Equal(t, "8877", result.Card.GetLastFourDigits())
+	assert.Equal(t, 4, result.Card.ExpiryMonth)
+	assert.Empty(t, result.Card.Number)
+
+	mockVault.AssertExpectations(t)
+	mockBank.AssertExpectations(t)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_ProcessPayment_TokenErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		vaultCard   *domain.Card
+		vaultErr    error
+		expectError error
+	}{
+		{
+			name:        "unknown token",
+			vaultErr:    domain.ErrTokenNotFound,
+			expectError: domain.ErrTokenNotFound,
+		},
+		{
+			name: "stored card has expired",
+			vaultCard: &domain.Card{
+				Token:       "tok_abc",
+				LastFour:    "8877",
+				ExpiryMonth: 4,
+				ExpiryYear:  time.Now().Year() - 1,
+			},
+			expectError: domain.ErrExpiryDateInPast,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBank := new(MockBankClient)
+			mockRepo := new(MockPaymentRepository)
+			mockVault := new(MockCardVault)
+
+			if tt.vaultCard != nil {
+				mockVault.On("Describe", "", "tok_abc").Return(tt.vaultCard, nil)
+			} else {
+				mockVault.On("Describe", "", "tok_abc").Return(nil, tt.vaultErr)
+			}
+
+			service := NewPaymentService(mockBank, mockRepo, WithCardVault(mockVault))
+
+			result, err := service.ProcessPayment(context.Background(), &domain.Payment{
+				Card:     domain.Card{Token: "tok_abc", CVV: "123"},
+				Currency: "GBP",
+				Amount:   100,
+			})
+
+			assert.Nil(t, result)
+			assert.Equal(t, tt.expectError, err)
+			assert.True(t, domain.IsValidationError(err))
+			mockBank.AssertNotCalled(t, "ProcessPayment")
+			mockRepo.AssertNotCalled(t, "Save")
+		})
+	}
+}
+
+func TestPaymentService_ProcessPayment_TokenWithoutVault(t *testing.T) {
+	service := NewPaymentService(new(MockBankClient), new(MockPaymentRepository))
+
+	result, err := service.ProcessPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Token: "tok_abc", CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	})
+
+	assert.Nil(t, result)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+}
+
+func TestPaymentService_ProcessPayment_PublishesEvent(t *testing.T) {
+	tests := []struct {
+		name       string
+		publishErr error
+	}{
+		{name: "published", publishErr: nil},
+		{name: "publishing fails", publishErr: errors.New("outbox unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBank := new(MockBankClient)
+			mockRepo := new(MockPaymentRepository)
+			mockPublisher := new(MockEventPublisher)
+
+			payment := &domain.Payment{
+				Card: domain.Card{
+					Number:      "2222405343248877",
+					ExpiryMonth: 4,
+					ExpiryYear:  time.Now().Year() + 1,
+					CVV:         "123",
+				},
+				Currency: "GBP",
+				Amount:   100,
+				Status:   domain.StatusRejected,
+			}
+
+			mockBank.On("ProcessPayment", payment).Return(&client.BankResponse{Authorized: true}, nil)
+			mockRepo.On("Save", payment).Return(nil)
+			mockPublisher.On("PublishPaymentEvent", payment).Return(tt.publishErr)
+
+			service := NewPaymentService(mockBank, mockRepo, WithEventPublisher(mockPublisher))
+
+			// The payment succeeds whether or not the event could be published
+			result, err := service.ProcessPayment(context.Background(), payment)
+			require.NoError(t, err)
+			assert.Equal(t, domain.StatusAuthorized, result.Status)
+
+			mockPublisher.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentService_ProcessPayment_BankErrorPublishesNothing(t *testing.T) {
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	mockPublisher := new(MockEventPublisher)
+
+	payment := &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248870", ExpiryMonth: 4, ExpiryYear: time.Now().Year() + 1, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	}
+
+	mockBank.On("ProcessPayment", payment).Return(nil, errors.New("bank service unavailable"))
+
+	service := NewPaymentService(mockBank, mockRepo, WithEventPublisher(mockPublisher))
+
+	_, err := service.ProcessPayment(context.Background(), payment)
+	require.Error(t, err)
+	mockPublisher.AssertNotCalled(t, "PublishPaymentEvent", mock.Anything)
+}
+
+type MockPaymentObserver struct {
+	mock.Mock
+}
+
+func (m *MockPaymentObserver) ObservePayment(payment *domain.Payment) {
+	m.Called(payment)
+}
+
+func TestPaymentService_ProcessPayment_ObservesOutcome(t *testing.T) {
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	mockObserver := new(MockPaymentObserver)
+
+	payment := &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248878", ExpiryMonth: 4, ExpiryYear: time.Now().Year() + 1, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	}
+
+	mockBank.On("ProcessPayment", payment).Return(&client.BankResponse{Authorized: false}, nil)
+	mockRepo.On("Save", payment).Return(nil)
+	mockObserver.On("ObservePayment", mock.MatchedBy(func(p *domain.Payment) bool {
+		return p.Status == domain.StatusDeclined
+	})).Return()
+
+	service := NewPaymentService(mockBank, mockRepo, WithPaymentObserver(mockObserver))
+
+	_, err := service.ProcessPayment(context.Background(), payment)
+	require.NoError(t, err)
+	mockObserver.AssertExpectations(t)
+}
+
+func TestPaymentService_ProcessPayment_BankErrorObservesNothing(t *testing.T) {
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	mockObserver := new(MockPaymentObserver)
+
+	payment := &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248870", ExpiryMonth: 4, ExpiryYear: time.Now().Year() + 1, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	}
+
+	mockBank.On("ProcessPayment", payment).Return(nil, client.ErrBankUnavailable)
+
+	service := NewPaymentService(mockBank, mockRepo, WithPaymentObserver(mockObserver))
+
+	_, err := service.ProcessPayment(context.Background(), payment)
+	require.Error(t, err)
+	mockObserver.AssertNotCalled(t, "ObservePayment", mock.Anything)
+}
+
+func TestPaymentService_GetPaymentEvents(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	events := []domain.PaymentEvent{
+		{PaymentID: "payment-1", Sequence: 1, Type: domain.EventPaymentRequested},
+		{PaymentID: "payment-1", Sequence: 2, Type: domain.EventPaymentAuthorized},
+	}
+	mockRepo.On("FindByID", "payment-1").Return(&domain.Payment{ID: "payment-1"}, nil)
+	mockRepo.On("FindByID", "missing").Return(nil, nil)
+	mockRepo.On("Events", "payment-1").Return(events, nil)
+
+	service := NewPaymentService(new(MockBankClient), mockRepo)
+
+	result, err := service.GetPaymentEvents(context.Background(), "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, events, result)
+
+	_, err = service.GetPaymentEvents(context.Background(), "missing")
+	assert.Equal(t, domain.ErrPaymentNotFound, err)
+}
+
+func TestPaymentService_MerchantScoped(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockRepo.On("FindByID", "payment-1").Return(&domain.Payment{ID: "payment-1", MerchantID: "merchant-a"}, nil)
+	mockRepo.On("List", domain.PaymentQuery{Limit: 10, MerchantID: "merchant-a"}).Return(&domain.PaymentPage{}, nil)
+
+	service := NewPaymentService(new(MockBankClient), mockRepo)
+	owner := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-a"})
+	other := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-b"})
+
+	payment, err := service.GetPayment(owner, "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "merchant-a", payment.MerchantID)
+
+	_, err = service.GetPayment(other, "payment-1")
+	assert.Equal(t, domain.ErrPaymentNotFound, err, "another merchant's payment is not found")
+
+	_, err = service.GetPaymentEvents(other, "payment-1")
+	assert.Equal(t, domain.ErrPaymentNotFound, err)
+
+	_, err = service.ListPayments(owner, domain.PaymentQuery{Limit: 10})
+	require.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+// recordingQueue remembers what was queued, or turns everything away
+type recordingQueue struct {
+	queued []domain.Payment
+	err    error
+}
+
+func (q *recordingQueue) Enqueue(payment *domain.Payment, store func() error) error {
+	if q.err != nil {
+		return q.err
+	}
+	if err := store(); err != nil {
+		return err
+	}
+	q.queued = append(q.queued, *payment)
+	return nil
+}
+
+func TestPaymentService_SubmitPayment(t *testing.T) {
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	queue := &recordingQueue{}
+
+	payment := &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	}
+	mockRepo.On("Save", payment).Return(nil)
+
+	service := NewPaymentService(mockBank, mockRepo, WithPaymentQueue(queue))
+
+	result, err := service.SubmitPayment(context.Background(), payment)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.ID)
+	assert.Equal(t, domain.StatusPending, result.Status)
+
+	require.Len(t, queue.queued, 1)
+	assert.Equal(t, result.ID, queue.queued[0].ID)
+	assert.Equal(t, "123", queue.queued[0].Card.CVV, "the worker needs the CVV")
+
+	mockBank.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestPaymentService_SubmitPayment_QueueFull(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewPaymentService(new(MockBankClient), mockRepo, WithPaymentQueue(&recordingQueue{err: domain.ErrPaymentQueueFull}))
+
+	_, err := service.SubmitPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	})
+	assert.ErrorIs(t, err, domain.ErrPaymentQueueFull)
+	mockRepo.AssertNotCalled(t, "Save", mock.Anything)
+}
+
+func TestPaymentService_SubmitPayment_SaveFails(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	mockRepo.On("Save", mock.Anything).Return(errors.New("disk full"))
+	queue := &recordingQueue{}
+	service := NewPaymentService(new(MockBankClient), mockRepo, WithPaymentQueue(queue))
+
+	_, err := service.SubmitPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	})
+	assert.ErrorContains(t, err, "disk full")
+	assert.Empty(t, queue.queued, "a payment the merchant is told failed is never sent")
+}
+
+// slowRepository takes a while to store pending payments, as a busy disk
+// would, so a worker can be done with the bank before the submit path has
+// stored the payment
+type slowRepository struct {
+	*repository.PaymentsRepository
+}
+
+func (r slowRepository) Save(ctx context.Context, payment *domain.Payment) error {
+	if payment.Status == domain.StatusPending {
+		time.Sleep(50 * time.Millisecond)
+	}
+	return r.PaymentsRepository.Save(ctx, payment)
+}
+
+func TestPaymentService_SubmitPayment_StoredBeforeWorkerSendsIt(t *testing.T) {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	e := envelope.New(provider)
+	repo := slowRepository{repository.NewPaymentsRepository(e)}
+	pool := async.NewPool(repository.NewPaymentJobsRepository(e))
+
+	mockBank := new(MockBankClient)
+	mockBank.On("ProcessPayment", mock.Anything).Return(&client.BankResponse{Authorized: true, AuthorizationCode: "auth-code-123"}, nil)
+	service := NewPaymentService(mockBank, repo, WithPaymentQueue(pool))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(ctx, service)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	submitted, err := service.SubmitPayment(context.Background(), &domain.Payment{
+		Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030, CVV: "123"},
+		Currency: "GBP",
+		Amount:   100,
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return pool.Len() == 0 }, time.Second, 5*time.Millisecond)
+	stored, err := repo.FindByID(context.Background(), submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusAuthorized, stored.Status, "the pending record never overwrites the outcome")
+
+	events, err := repo.Events(context.Background(), submitted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.EventPaymentAuthorized, events[len(events)-1].Type)
+}
+
+func TestPaymentService_ProcessQueuedPayment(t *testing.T) {
+	tests := []struct {
+		name           string
+		bankResp       *client.BankResponse
+		bankErr        error
+		expectedStatus domain.PaymentStatus
+		expectedError  bool
+	}{
+		{name: "authorized", bankResp: &client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"}, expectedStatus: domain.StatusAuthorized},
+		{name: "declined", bankResp: &client.BankResponse{DeclineReason: domain.DeclineDoNotHonour}, expectedStatus: domain.StatusDeclined},
+		{name: "rejected by the bank", bankErr: client.ErrBankRejected, expectedStatus: domain.StatusRejected},
+		{name: "bank unavailable", bankErr: client.ErrBankUnavailable, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payment := &domain.Payment{ID: "payment-1", Currency: "GBP", Amount: 100, Status: domain.StatusPending}
+
+			mockBank := new(MockBankClient)
+			mockBank.On("ProcessPayment", payment).Return(tt.bankResp, tt.bankErr)
+			mockRepo := new(MockPaymentRepository)
+			mockPublisher := new(MockEventPublisher)
+			if !tt.expectedError {
+				mockRepo.On("Save", payment).Return(nil)
+				mockPublisher.On("PublishPaymentEvent", payment).Return(nil)
+			}
+
+			service := NewPaymentService(mockBank, mockRepo, WithEventPublisher(mockPublisher))
+
+			result, err := service.ProcessQueuedPayment(context.Background(), payment)
+			if tt.expectedError {
+				assert.ErrorIs(t, err, tt.bankErr)
+				mockRepo.AssertNotCalled(t, "Save", mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "payment-1", result.ID, "keeps the ID it was queued with")
+			assert.Equal(t, tt.expectedStatus, result.Status)
+			mockRepo.AssertExpectations(t)
+			mockPublisher.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPaymentService_ProcessPayment_PreviousTransaction(t *testing.T) {
+	earlier := &domain.Payment{
+		ID:     "payment-1",
+		Card:   domain.Card{LastFour: "8877", ExpiryMonth: 4, ExpiryYear: 2030},
+		Status: domain.StatusAuthorized,
+	}
+	otherCard := &domain.Payment{
+		ID:     "payment-2",
+		Card:   domain.Card{LastFour: "1111", ExpiryMonth: 4, ExpiryYear: 2030},
+		Status: domain.StatusAuthorized,
+	}
+
+	tests := []struct {
+		name        string
+		previous    string
+		expectError error
+	}{
+		{name: "earlier payment on the card", previous: "ntid-1"},
+		{name: "unknown reference", previous: "ntid-made-up", expectError: domain.ErrPreviousTransactionUnknown},
+		{name: "payment on another card", previous: "ntid-2", expectError: domain.ErrPreviousTransactionUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockBank := new(MockBankClient)
+			mockRepo := new(MockPaymentRepository)
+			mockRepo.On("FindByNetworkTransactionID", "ntid-1").Return(earlier, nil)
+			mockRepo.On("FindByNetworkTransactionID", "ntid-2").Return(otherCard, nil)
+			mockRepo.On("FindByNetworkTransactionID", "ntid-made-up").Return(nil, nil)
+			mockBank.On("ProcessPayment", mock.Anything).Return(&client.BankResponse{Authorized: true}, nil)
+			mockRepo.On("Save", mock.Anything).Return(nil)
+
+			service := NewPaymentService(mockBank, mockRepo)
+
+			_, err := service.ProcessPayment(context.Background(), &domain.Payment{
+				Card:      domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030},
+				Currency:  "GBP",
+				Amount:    100,
+				Initiator: domain.InitiatorMerchant,
+				StoredCredential: &domain.StoredCredential{
+					Usage:                        domain.StoredCredentialSubsequent,
+					Type:                         domain.StoredCredentialRecurring,
+					PreviousNetworkTransactionID: tt.previous,
+				},
+			})
+
+			if tt.expectError != nil {
+				assert.ErrorIs(t, err, tt.expectError)
+				mockBank.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			mockBank.AssertExpectations(t)
+		})
+	}
+}
+
+// blockedNumbers is a blocklist of card numbers per merchant
+type blockedNumbers map[string]string
+
+func (b blockedNumbers) Blocks(merchantID string, card domain.Card) (bool, error) {
+	return b[merchantID] == card.Number, nil
+}
+
+func TestPaymentService_ProcessPayment_Blocklist(t *testing.T) {
+	blocklist := blockedNumbers{"merchant-a": "2222405343248877"}
+	newPayment := func() *domain.Payment {
+		return &domain.Payment{
+			Card:     domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: time.Now().Year() + 1, CVV: "123"},
+			Currency: "GBP",
+			Amount:   100,
+		}
+	}
+
+	mockBank := new(MockBankClient)
+	mockRepo := new(MockPaymentRepository)
+	service := NewPaymentService(mockBank, mockRepo, WithBlocklist(blocklist))
+
+	owner := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-a"})
+	_, err := service.ProcessPayment(owner, newPayment())
+	assert.Equal(t, domain.ErrCardBlocked, err)
+	assert.True(t, domain.IsValidationError(err))
+	mockBank.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	mockRepo.AssertNotCalled(t, "Save", mock.Anything)
+
+	// Another merchant can still take payments from the card
+	other := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-b"})
+	payment := newPayment()
+	mockBank.On("ProcessPayment", payment).Return(&client.BankResponse{Authorized: true}, nil)
+	mockRepo.On("Save", payment).Return(nil)
+
+	result, err := service.ProcessPayment(other, payment)
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusAuthorized, result.Status)
+}
+
+// MockSettlingBank is a bank client that can also capture, void and refund
+type MockSettlingBank struct {
+	MockBankClient
+}
+
+func (m *MockSettlingBank) Settle(ctx context.Context, settlement *client.Settlement) error {
+	return m.Called(*settlement).Error(0)
+}
+
+func TestPaymentService_CaptureVoidRefund(t *testing.T) {
+	mockBank := new(MockSettlingBank)
+	mockRepo := new(MockPaymentRepository)
+	mockPublisher := new(MockEventPublisher)
+	authorized := func() *domain.Payment {
+		return &domain.Payment{ID: "payment-1", Amount: 100, Status: domain.StatusAuthorized, AuthorizationCode: "auth-1"}
+	}
+	captured := &domain.Payment{ID: "payment-2", Amount: 100, Status: domain.StatusCaptured, AuthorizationCode: "auth-2", CapturedAmount: 80, RefundedAmount: 30}
+	mockRepo.On("FindByID", "payment-1").Return(authorized(), nil).Once()
+	mockRepo.On("FindByID", "payment-1").Return(authorized(), nil).Once()
+	mockRepo.On("FindByID", "payment-2").Return(captured, nil)
+	mockRepo.On("Save", mock.Anything).Return(nil)
+	mockPublisher.On("PublishPaymentEvent", mock.Anything).Return(nil)
+	mockBank.On("Settle", client.Settlement{Type: client.SettlementCapture, AuthorizationCode: "auth-1", Amount: 100}).Return(nil)
+	mockBank.On("Settle", client.Settlement{Type: client.SettlementVoid, AuthorizationCode: "auth-1"}).Return(nil)
+	mockBank.On("Settle", client.Settlement{Type: client.SettlementRefund, AuthorizationCode: "auth-2", Amount: 50}).Return(nil)
+
+	service := NewPaymentService(mockBank, mockRepo, WithEventPublisher(mockPublisher))
+
+	payment, err := service.CapturePayment(context.Background(), "payment-1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusCaptured, payment.Status)
+	assert.Equal(t, 100, payment.CapturedAmount)
+
+	payment, err = service.VoidPayment(context.Background(), "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusVoided, payment.Status)
+
+	// Only what has not been refunded yet is sent
+	payment, err = service.RefundPayment(context.Background(), "payment-2", 0)
+	require.NoError(t, err)
+	assert.Equal(t, domain.StatusRefunded, payment.Status)
+	assert.Equal(t, 80, payment.RefundedAmount)
+
+	mockBank.AssertExpectations(t)
+	mockRepo.AssertNumberOfCalls(t, "Save", 3)
+	mockPublisher.AssertNumberOfCalls(t, "PublishPaymentEvent", 3)
+}
+
+func TestPaymentService_CapturePayment_Refused(t *testing.T) {
+	mockBank := new(MockSettlingBank)
+	mockRepo := new(MockPaymentRepository)
+	mockRepo.On("FindByID", "payment-1").Return(&domain.Payment{ID: "payment-1", Amount: 100, Status: domain.StatusAuthorized, AuthorizationCode: "auth-1"}, nil)
+	mockRepo.On("FindByID", "payment-2").Return(&domain.Payment{ID: "payment-2", Amount: 100, Status: domain.StatusDeclined}, nil)
+	mockBank.On("Settle", mock.Anything).Return(client.ErrSettlementRefused)
+
+	service := NewPaymentService(mockBank, mockRepo)
+
+	_, err := service.CapturePayment(context.Background(), "payment-1", 0)
+	assert.ErrorIs(t, err, client.ErrSettlementRefused)
+
+	// The bank is not asked about a payment that cannot be captured
+	_, err = service.CapturePayment(context.Background(), "payment-2", 0)
+	assert.ErrorIs(t, err, domain.ErrPaymentNotCapturable)
+	mockBank.AssertNumberOfCalls(t, "Settle", 1)
+	mockRepo.AssertNotCalled(t, "Save", mock.Anything)
+
+	// Nor is another merchant's payment found
+	other := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-b"})
+	_, err = service.CapturePayment(other, "payment-1", 0)
+	assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+
+	// A bank client that cannot settle says so
+	_, err = NewPaymentService(new(MockBankClient), mockRepo).VoidPayment(context.Background(), "payment-1")
+	assert.ErrorIs(t, err, domain.ErrSettlementUnsupported)
+}
+
+func TestPaymentService_SettlementsOfOnePaymentDoNotOverlap(t *testing.T) {
+	mockBank := new(MockSettlingBank)
+	mockRepo := new(MockPaymentRepository)
+	mockRepo.On("FindByID", "payment-1").Return(&domain.Payment{ID: "payment-1", Amount: 100, Status: domain.StatusAuthorized, AuthorizationCode: "auth-1"}, nil)
+	mockRepo.On("Save", mock.Anything).Return(nil)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	mockBank.On("Settle", mock.Anything).Run(func(mock.Arguments) {
+		close(entered)
+		<-release
+	}).Return(nil).Once()
+
+	service := NewPaymentService(mockBank, mockRepo)
+	done := make(chan error)
+	go func() {
+		_, err := service.CapturePayment(context.Background(), "payment-1", 0)
+		done <- err
+	}()
+	<-entered
+
+	_, err := service.VoidPayment(context.Background(), "payment-1")
+	assert.ErrorIs(t, err, domain.ErrPaymentSettling)
+
+	close(release)
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,51 @@
+package subscription
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/audit"
+)
+
+var schedulerActor = audit.Actor{Type: audit.ActorSystem, ID: "subscription-scheduler"}
+
+// Scheduler renews due subscriptions on a fixed interval
+type Scheduler struct {
+	service  *Service
+	interval time.Duration
+}
+
+func NewScheduler(service *Service, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		service:  service,
+		interval: interval,
+	}
+}
+
+// Run renews due subscriptions on every tick until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce renews whatever is due right now. Payments it makes are recorded
+// as made by the scheduler.
+func (s *Scheduler) RunOnce(ctx context.Context) int {
+	ctx = audit.WithActor(ctx, schedulerActor)
+
+	n, err := s.service.RenewDue(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "subscription renewals failed", "error", err)
+	}
+	return n
+}
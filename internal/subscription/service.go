@@ -0,0 +1,607 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/google/uuid"
+)
+
+// PaymentProcessor creates payments. It is satisfied by service.PaymentService.
+type PaymentProcessor interface {
+	ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error)
+}
+
+type Repository interface {
+	SavePlan(plan *domain.Plan) error
+	FindPlan(id string) (*domain.Plan, error)
+	SaveSubscription(sub *domain.Subscription) error
+	FindSubscription(id string) (*domain.Subscription, error)
+	FindDue(now time.Time) ([]string, error)
+}
+
+// DunningPolicy says when to retry a declined renewal. Retries[i] is the wait
+// after the (i+1)-th consecutive decline; once all retries have failed the
+// subscription is canceled. A renewal that never reached the bank, say because
+// it could not be reached, is retried after ErrorRetry and is not counted.
+type DunningPolicy struct {
+	Retries    []time.Duration
+	ErrorRetry time.Duration
+}
+
+// DefaultDunningPolicy retries declines after one, three and seven days,
+// and bank failures after an hour
+var DefaultDunningPolicy = DunningPolicy{
+	Retries:    []time.Duration{24 * time.Hour, 72 * time.Hour, 168 * time.Hour},
+	ErrorRetry: time.Hour,
+}
+
+type Service struct {
+	payments   PaymentProcessor
+	repository Repository
+	clock      clock.Clock
+	dunning    DunningPolicy
+
+	// locks serialises changes to a subscription between the API and the
+	// scheduler. A lock is dropped once nobody holds or waits for it, so
+	// the map only holds subscriptions being changed right now.
+	locksMu sync.Mutex
+	locks   map[string]*subscriptionLock
+}
+
+type subscriptionLock struct {
+	sync.Mutex
+	// refs counts the callers holding or waiting for the lock, under
+	// Service.locksMu
+	refs int
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithClock replaces the wall clock, mainly for tests
+func WithClock(c clock.Clock) Option {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// WithDunningPolicy replaces DefaultDunningPolicy
+func WithDunningPolicy(policy DunningPolicy) Option {
+	return func(s *Service) {
+		s.dunning = policy
+	}
+}
+
+func NewService(payments PaymentProcessor, repository Repository, opts ...Option) *Service {
+	s := &Service{
+		payments:   payments,
+		repository: repository,
+		clock:      clock.Real{},
+		dunning:    DefaultDunningPolicy,
+		locks:      make(map[string]*subscriptionLock),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// CreatePlan stores a plan for the merchant in ctx
+func (s *Service) CreatePlan(ctx context.Context, plan *domain.Plan) (*domain.Plan, error) {
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+
+	plan.ID = uuid.New().String()
+	plan.MerchantID = merchant.IDFrom(ctx)
+	plan.CreatedAt = s.clock.Now()
+
+	if err := s.repository.SavePlan(plan); err != nil {
+		return nil, fmt.Errorf("failed to save plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+// GetPlan returns a plan of the merchant in ctx. Other merchants' plans are
+// reported as not found, so their IDs cannot be probed.
+func (s *Service) GetPlan(ctx context.Context, id string) (*domain.Plan, error) {
+	plan, err := s.findPlan(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.MerchantID != merchant.IDFrom(ctx) {
+		return nil, domain.ErrPlanNotFound
+	}
+
+	return plan, nil
+}
+
+// GetSubscription returns a subscription of the merchant in ctx. Other
+// merchants' subscriptions are reported as not found.
+func (s *Service) GetSubscription(ctx context.Context, id string) (*domain.Subscription, error) {
+	sub, err := s.findSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub.MerchantID != merchant.IDFrom(ctx) {
+		return nil, domain.ErrSubscriptionNotFound
+	}
+
+	return sub, nil
+}
+
+// findPlan returns a plan whatever merchant owns it, for the scheduler and
+// for plans already attached to a subscription
+func (s *Service) findPlan(id string) (*domain.Plan, error) {
+	plan, err := s.repository.FindPlan(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan == nil {
+		return nil, domain.ErrPlanNotFound
+	}
+
+	return plan, nil
+}
+
+func (s *Service) findSubscription(id string) (*domain.Subscription, error) {
+	sub, err := s.repository.FindSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub == nil {
+		return nil, domain.ErrSubscriptionNotFound
+	}
+
+	return sub, nil
+}
+
+// Subscribe charges the first period while the customer is present and
+// starts the subscription. Nothing is stored if that payment is not
+// authorized.
+func (s *Service) Subscribe(ctx context.Context, planID, cardToken, cvv string) (*domain.Subscription, error) {
+	if cardToken == "" {
+		return nil, domain.ErrCardTokenRequired
+	}
+
+	plan, err := s.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now()
+	sub := &domain.Subscription{
+		ID:         uuid.New().String(),
+		MerchantID: plan.MerchantID,
+		PlanID:     plan.ID,
+		CardToken:  cardToken,
+		CreatedAt:  now,
+	}
+
+	payment, err := domain.NewPayment(
+		domain.Card{Token: cardToken, CVV: cvv},
+		plan.Currency,
+		plan.Amount,
+		domain.WithStoredCredential(&domain.StoredCredential{
+			Usage: domain.StoredCredentialFirst,
+			Type:  domain.StoredCredentialRecurring,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	charge, err := s.charge(ctx, sub, payment, domain.ChargeInitial, now)
+	if domain.IsValidationError(err) {
+		return nil, err
+	}
+	if charge.Status != domain.StatusAuthorized {
+		return nil, domain.ErrSubscriptionPaymentFailed
+	}
+
+	sub.Status = domain.SubscriptionActive
+	sub.NetworkTransactionID = payment.NetworkTransactionID
+	sub.BillingAnchor = now
+	sub.Cycles = 1
+	sub.CurrentPeriodStart = now
+	sub.CurrentPeriodEnd = plan.PeriodEnd(now, 1)
+	sub.NextChargeAt = sub.CurrentPeriodEnd
+
+	if err := s.repository.SaveSubscription(sub); err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+// Pause stops renewals until Resume is called
+func (s *Service) Pause(ctx context.Context, id string) (*domain.Subscription, error) {
+	return s.updateOwned(ctx, id, func(sub *domain.Subscription, now time.Time) error {
+		if !sub.IsBillable() {
+			return domain.ErrSubscriptionInvalidState
+		}
+
+		sub.Status = domain.SubscriptionPaused
+		sub.PausedAt = &now
+		return nil
+	})
+}
+
+// Resume restarts renewals. A renewal that fell due while paused is charged
+// on the next scheduler run.
+func (s *Service) Resume(ctx context.Context, id string) (*domain.Subscription, error) {
+	return s.updateOwned(ctx, id, func(sub *domain.Subscription, now time.Time) error {
+		if sub.Status != domain.SubscriptionPaused {
+			return domain.ErrSubscriptionInvalidState
+		}
+
+		sub.Status = domain.SubscriptionActive
+		if sub.FailedAttempts > 0 {
+			sub.Status = domain.SubscriptionPastDue
+		}
+		sub.PausedAt = nil
+
+		if sub.NextChargeAt.Before(now) {
+			sub.NextChargeAt = now
+		}
+		return nil
+	})
+}
+
+// Cancel ends the subscription now, or at the end of the paid period
+func (s *Service) Cancel(ctx context.Context, id string, atPeriodEnd bool) (*domain.Subscription, error) {
+	return s.updateOwned(ctx, id, func(sub *domain.Subscription, now time.Time) error {
+		if sub.Status == domain.SubscriptionCanceled {
+			return domain.ErrSubscriptionInvalidState
+		}
+
+		if atPeriodEnd && sub.Status != domain.SubscriptionPaused {
+			sub.CancelAtPeriodEnd = true
+			return nil
+		}
+
+		sub.Status = domain.SubscriptionCanceled
+		sub.CanceledAt = &now
+		return nil
+	})
+}
+
+// ChangePlan moves an active subscription to another plan straight away.
+//
+// The rest of the current period is prorated: the customer is credited for
+// the unused time on the old plan and charged for the same time on the new
+// one. An upgrade is charged immediately; a downgrade leaves a credit that is
+// taken off the next renewals. The new plan's own schedule starts when the
+// current period ends.
+func (s *Service) ChangePlan(ctx context.Context, id, planID string) (*domain.Subscription, error) {
+	newPlan, err := s.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.updateOwned(ctx, id, func(sub *domain.Subscription, now time.Time) error {
+		if sub.Status != domain.SubscriptionActive || sub.CancelAtPeriodEnd {
+			return domain.ErrSubscriptionInvalidState
+		}
+
+		oldPlan, err := s.findPlan(sub.PlanID)
+		if err != nil {
+			return err
+		}
+
+		if oldPlan.Currency != newPlan.Currency {
+			return domain.ErrPlanCurrencyChange
+		}
+
+		amount := prorate(newPlan.Amount-oldPlan.Amount, sub.CurrentPeriodStart, sub.CurrentPeriodEnd, now)
+
+		if amount > 0 {
+			payment, err := s.merchantInitiatedPayment(sub, newPlan.Currency, amount, domain.StoredCredentialUnscheduled)
+			if err != nil {
+				return err
+			}
+
+			charge, err := s.charge(ctx, sub, payment, domain.ChargeProration, now)
+			if domain.IsValidationError(err) {
+				return err
+			}
+			if charge.Status != domain.StatusAuthorized {
+				// Keep the failed attempt on record but leave the plan as it was
+				if err := s.repository.SaveSubscription(sub); err != nil {
+					return fmt.Errorf("failed to save subscription: %w", err)
+				}
+				return domain.ErrSubscriptionPaymentFailed
+			}
+		} else {
+			sub.Credit -= amount
+		}
+
+		sub.PlanID = newPlan.ID
+		sub.BillingAnchor = sub.CurrentPeriodEnd
+		sub.Cycles = 0
+		return nil
+	})
+}
+
+// RenewDue charges every subscription that has fallen due and returns how
+// many were processed. Errors on one subscription do not stop the others.
+func (s *Service) RenewDue(ctx context.Context) (int, error) {
+	ids, err := s.repository.FindDue(s.clock.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to find due subscriptions: %w", err)
+	}
+
+	var errs []error
+	for _, id := range ids {
+		renew := func(sub *domain.Subscription, now time.Time) error {
+			return s.renew(ctx, sub, now)
+		}
+		if _, err := s.update(id, renew); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", id, err))
+		}
+	}
+
+	return len(ids), errors.Join(errs...)
+}
+
+func (s *Service) renew(ctx context.Context, sub *domain.Subscription, now time.Time) error {
+	// The scheduler's snapshot may be stale by the time the lock is held
+	if !sub.IsBillable() || sub.NextChargeAt.After(now) {
+		return nil
+	}
+
+	if sub.CancelAtPeriodEnd {
+		sub.Status = domain.SubscriptionCanceled
+		sub.CanceledAt = &now
+		return nil
+	}
+
+	plan, err := s.findPlan(sub.PlanID)
+	if err != nil {
+		return err
+	}
+
+	credit := min(sub.Credit, plan.Amount)
+	amount := plan.Amount - credit
+
+	if amount > 0 {
+		payment, err := s.merchantInitiatedPayment(sub, plan.Currency, amount, domain.StoredCredentialRecurring)
+		if err != nil {
+			return err
+		}
+
+		charge, err := s.charge(ctx, sub, payment, domain.ChargeRenewal, now)
+		switch {
+		case domain.IsValidationError(err):
+			// A card that can no longer be used, say because its token was
+			// shredded, is dunned like a decline so the subscription ends
+			s.scheduleRetry(sub, now)
+			return nil
+		case client.NotSent(err):
+			// The bank never saw the payment, so the card is not to blame
+			slog.WarnContext(ctx, "subscription renewal failed, will retry",
+				"subscription_id", sub.ID,
+				"retry_in", s.dunning.ErrorRetry,
+				"error", err,
+			)
+			sub.NextChargeAt = now.Add(s.dunning.ErrorRetry)
+			return nil
+		case client.OutcomeUnknown(err):
+			// The bank may have charged the card, so charging it again could
+			// take the money twice
+			slog.ErrorContext(ctx, "subscription renewal outcome unknown, holding it for reconciliation",
+				"subscription_id", sub.ID,
+				"error", err,
+			)
+			sub.Status = domain.SubscriptionReconciling
+			return nil
+		case err != nil:
+			// The bank turned the payment down without authorizing it
+			s.scheduleRetry(sub, now)
+			return nil
+		case charge.Status != domain.StatusAuthorized:
+			s.scheduleRetry(sub, now)
+			return nil
+		}
+	}
+
+	s.advance(sub, plan, credit)
+	return nil
+}
+
+// ResolveRenewal settles a renewal the bank gave no outcome for, once an
+// operator has checked with the bank. A renewal that was charged starts the
+// next period; one that was not is charged again by the next scheduler run
+// and does not count towards dunning.
+func (s *Service) ResolveRenewal(id string, charged bool) (*domain.Subscription, error) {
+	return s.update(id, func(sub *domain.Subscription, now time.Time) error {
+		if sub.Status != domain.SubscriptionReconciling {
+			return domain.ErrSubscriptionInvalidState
+		}
+
+		plan, err := s.findPlan(sub.PlanID)
+		if err != nil {
+			return err
+		}
+
+		last := &sub.Charges[len(sub.Charges)-1]
+		if charged {
+			last.Status = domain.StatusAuthorized
+			s.advance(sub, plan, min(sub.Credit, plan.Amount))
+			return nil
+		}
+
+		sub.Status = domain.SubscriptionActive
+		if sub.FailedAttempts > 0 {
+			sub.Status = domain.SubscriptionPastDue
+		}
+		sub.NextChargeAt = now
+		return nil
+	})
+}
+
+// advance starts the next period after a paid renewal
+func (s *Service) advance(sub *domain.Subscription, plan *domain.Plan, credit int) {
+	sub.Credit -= credit
+	sub.FailedAttempts = 0
+	sub.Status = domain.SubscriptionActive
+	sub.Cycles++
+	sub.CurrentPeriodStart = sub.CurrentPeriodEnd
+	sub.CurrentPeriodEnd = plan.PeriodEnd(sub.BillingAnchor, sub.Cycles)
+	sub.NextChargeAt = sub.CurrentPeriodEnd
+}
+
+// scheduleRetry applies the dunning policy after a declined renewal
+func (s *Service) scheduleRetry(sub *domain.Subscription, now time.Time) {
+	sub.FailedAttempts++
+
+	if sub.FailedAttempts > len(s.dunning.Retries) {
+		sub.Status = domain.SubscriptionCanceled
+		sub.CanceledAt = &now
+		return
+	}
+
+	sub.Status = domain.SubscriptionPastDue
+	sub.NextChargeAt = now.Add(s.dunning.Retries[sub.FailedAttempts-1])
+}
+
+// merchantInitiatedPayment charges the subscription's card on behalf of the
+// merchant that subscribed the customer, which also owns the initial
+// payment it refers back to
+func (s *Service) merchantInitiatedPayment(sub *domain.Subscription, currency string, amount int, credentialType domain.StoredCredentialType) (*domain.Payment, error) {
+	payment, err := domain.NewPayment(
+		domain.Card{Token: sub.CardToken},
+		currency,
+		amount,
+		domain.WithInitiator(domain.InitiatorMerchant),
+		domain.WithStoredCredential(&domain.StoredCredential{
+			Usage:                        domain.StoredCredentialSubsequent,
+			Type:                         credentialType,
+			PreviousNetworkTransactionID: sub.NetworkTransactionID,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	payment.MerchantID = sub.MerchantID
+	return payment, nil
+}
+
+// charge sends a payment and records the attempt on the subscription.
+// Every attempt is recorded, including ones that never reached the bank,
+// whose error is also returned. Declines are not errors.
+func (s *Service) charge(ctx context.Context, sub *domain.Subscription, payment *domain.Payment, reason domain.ChargeReason, now time.Time) (*domain.SubscriptionCharge, error) {
+	charge := domain.SubscriptionCharge{
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Reason:    reason,
+		Attempt:   sub.FailedAttempts + 1,
+		CreatedAt: now,
+	}
+
+	processed, err := s.payments.ProcessPayment(ctx, payment)
+	if err != nil {
+		charge.Status = domain.StatusRejected
+		charge.Error = err.Error()
+	} else {
+		charge.PaymentID = processed.ID
+		charge.Status = processed.Status
+	}
+
+	sub.Charges = append(sub.Charges, charge)
+	recorded := &sub.Charges[len(sub.Charges)-1]
+
+	return recorded, err
+}
+
+// update loads a subscription, applies fn under the subscription's lock and
+// saves the result if fn succeeds
+func (s *Service) update(id string, fn func(sub *domain.Subscription, now time.Time) error) (*domain.Subscription, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	sub, err := s.findSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fn(sub, s.clock.Now()); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.SaveSubscription(sub); err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+// updateOwned is update for a subscription of the merchant in ctx. Other
+// merchants' subscriptions are reported as not found.
+func (s *Service) updateOwned(ctx context.Context, id string, fn func(sub *domain.Subscription, now time.Time) error) (*domain.Subscription, error) {
+	return s.update(id, func(sub *domain.Subscription, now time.Time) error {
+		if sub.MerchantID != merchant.IDFrom(ctx) {
+			return domain.ErrSubscriptionNotFound
+		}
+		return fn(sub, now)
+	})
+}
+
+// lock takes the subscription's lock and returns a func that releases it
+func (s *Service) lock(id string) func() {
+	s.locksMu.Lock()
+	l, ok := s.locks[id]
+	if !ok {
+		l = &subscriptionLock{}
+		s.locks[id] = l
+	}
+	l.refs++
+	s.locksMu.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		s.locksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.locks, id)
+		}
+		s.locksMu.Unlock()
+	}
+}
+
+// prorate scales amount by the share of the period [start, end) left at now,
+// rounded to the nearest minor unit
+func prorate(amount int, start, end, now time.Time) int {
+	total := end.Sub(start)
+	remaining := end.Sub(now)
+
+	if total <= 0 || remaining <= 0 {
+		return 0
+	}
+	if remaining > total {
+		remaining = total
+	}
+
+	return int(math.Round(float64(amount) * float64(remaining) / float64(total)))
+}
@@ -0,0 +1,555 @@
+package subscription
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProcessor authorizes every payment unless told to decline or fail, and
+// remembers what it was asked to charge
+type fakeProcessor struct {
+	payments []*domain.Payment
+	status   domain.PaymentStatus
+	err      error
+}
+
+func (f *fakeProcessor) ProcessPayment(ctx context.Context, payment *domain.Payment) (*domain.Payment, error) {
+	if err := payment.Validate(); err != nil {
+		return nil, err
+	}
+	f.payments = append(f.payments, payment)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	payment.ID = fmt.Sprintf("payment-%d", len(f.payments))
+	payment.Status = domain.StatusAuthorized
+	if f.status != "" {
+		payment.Status = f.status
+	}
+	if payment.Status == domain.StatusAuthorized {
+		payment.NetworkTransactionID = "ntid-" + payment.ID
+	}
+	return payment, nil
+}
+
+func (f *fakeProcessor) last() *domain.Payment {
+	return f.payments[len(f.payments)-1]
+}
+
+var start = time.Date(2026, time.January, 31, 10, 0, 0, 0, time.UTC)
+
+// merchantCtx is the merchant the fixture's plans and subscriptions belong to
+var merchantCtx = merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-1"})
+
+type fixture struct {
+	service   *Service
+	processor *fakeProcessor
+	clock     *clock.Fake
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{
+		processor: &fakeProcessor{},
+		clock:     clock.NewFake(start),
+	}
+	f.service = NewService(f.processor, repository.NewSubscriptionsRepository(),
+		WithClock(f.clock),
+		WithDunningPolicy(DunningPolicy{Retries: []time.Duration{24 * time.Hour, 72 * time.Hour}, ErrorRetry: time.Hour}),
+	)
+	return f
+}
+
+func (f *fixture) plan(t *testing.T, amount int) *domain.Plan {
+	plan, err := f.service.CreatePlan(merchantCtx, &domain.Plan{
+		Name:     "Plan",
+		Amount:   amount,
+		Currency: "GBP",
+		Interval: domain.IntervalMonth,
+	})
+	require.NoError(t, err)
+	return plan
+}
+
+func (f *fixture) subscribe(t *testing.T, plan *domain.Plan) *domain.Subscription {
+	sub, err := f.service.Subscribe(merchantCtx, plan.ID, "tok_abc", "123")
+	require.NoError(t, err)
+	return sub
+}
+
+// advanceTo moves the clock and runs the scheduler once
+func (f *fixture) advanceTo(t *testing.T, at time.Time) {
+	f.clock.Set(at)
+	_, err := f.service.RenewDue(context.Background())
+	require.NoError(t, err)
+}
+
+func (f *fixture) get(t *testing.T, id string) *domain.Subscription {
+	sub, err := f.service.GetSubscription(merchantCtx, id)
+	require.NoError(t, err)
+	return sub
+}
+
+func TestService_CreatePlan_Invalid(t *testing.T) {
+	f := newFixture(t)
+
+	_, err := f.service.CreatePlan(merchantCtx, &domain.Plan{Name: "Plan", Amount: 100, Currency: "GBP", Interval: "hour"})
+	assert.ErrorIs(t, err, domain.ErrPlanIntervalInvalid)
+}
+
+func TestService_Subscribe(t *testing.T) {
+	f := newFixture(t)
+	plan := f.plan(t, 1000)
+
+	sub := f.subscribe(t, plan)
+
+	assert.Equal(t, domain.SubscriptionActive, sub.Status)
+	assert.Equal(t, start, sub.CurrentPeriodStart)
+	assert.Equal(t, time.Date(2026, time.February, 28, 10, 0, 0, 0, time.UTC), sub.CurrentPeriodEnd)
+	assert.Equal(t, sub.CurrentPeriodEnd, sub.NextChargeAt)
+	assert.Equal(t, "ntid-payment-1", sub.NetworkTransactionID)
+	require.Len(t, sub.Charges, 1)
+	assert.Equal(t, domain.ChargeInitial, sub.Charges[0].Reason)
+
+	first := f.processor.last()
+	assert.Equal(t, domain.InitiatorCustomer, first.Initiator)
+	assert.Equal(t, domain.StoredCredentialFirst, first.StoredCredential.Usage)
+	assert.Equal(t, "123", first.Card.CVV)
+}
+
+func TestService_Subscribe_Declined(t *testing.T) {
+	f := newFixture(t)
+	plan := f.plan(t, 1000)
+	f.processor.status = domain.StatusDeclined
+
+	_, err := f.service.Subscribe(merchantCtx, plan.ID, "tok_abc", "123")
+	assert.ErrorIs(t, err, domain.ErrSubscriptionPaymentFailed)
+}
+
+func TestService_Subscribe_Errors(t *testing.T) {
+	f := newFixture(t)
+	plan := f.plan(t, 1000)
+
+	_, err := f.service.Subscribe(merchantCtx, plan.ID, "", "123")
+	assert.ErrorIs(t, err, domain.ErrCardTokenRequired)
+
+	_, err = f.service.Subscribe(merchantCtx, "missing", "tok_abc", "123")
+	assert.ErrorIs(t, err, domain.ErrPlanNotFound)
+
+	_, err = f.service.Subscribe(merchantCtx, plan.ID, "tok_abc", "")
+	assert.ErrorIs(t, err, domain.ErrCVVRequired)
+}
+
+func TestService_MerchantScoped(t *testing.T) {
+	f := newFixture(t)
+	plan := f.plan(t, 1000)
+	sub := f.subscribe(t, plan)
+	assert.Equal(t, "merchant-1", plan.MerchantID)
+	assert.Equal(t, "merchant-1", sub.MerchantID)
+
+	other := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-2"})
+
+	_, err := f.service.GetPlan(other, plan.ID)
+	assert.ErrorIs(t, err, domain.ErrPlanNotFound)
+	_, err = f.service.Subscribe(other, plan.ID, "tok_abc", "123")
+	assert.ErrorIs(t, err, domain.ErrPlanNotFound)
+
+	_, err = f.service.GetSubscription(other, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+	_, err = f.service.Pause(other, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+	_, err = f.service.Resume(other, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+	_, err = f.service.Cancel(other, sub.ID, false)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+
+	otherPlan, err := f.service.CreatePlan(other, &domain.Plan{Name: "Other", Amount: 2000, Currency: "GBP", Interval: domain.IntervalMonth})
+	require.NoError(t, err)
+	_, err = f.service.ChangePlan(other, sub.ID, otherPlan.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+	_, err = f.service.ChangePlan(merchantCtx, sub.ID, otherPlan.ID)
+	assert.ErrorIs(t, err, domain.ErrPlanNotFound, "another merchant's plan cannot be moved to")
+
+	assert.Equal(t, domain.SubscriptionActive, f.get(t, sub.ID).Status)
+	assert.Len(t, f.processor.payments, 1)
+}
+
+func TestService_RenewDue(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	// Nothing is due before the period ends
+	f.advanceTo(t, start.Add(24*time.Hour))
+	assert.Len(t, f.get(t, sub.ID).Charges, 1)
+
+	f.advanceTo(t, time.Date(2026, time.February, 28, 10, 0, 0, 0, time.UTC))
+	f.advanceTo(t, time.Date(2026, time.March, 31, 10, 0, 0, 0, time.UTC))
+
+	renewed := f.get(t, sub.ID)
+	require.Len(t, renewed.Charges, 3)
+	assert.Equal(t, domain.ChargeRenewal, renewed.Charges[2].Reason)
+	assert.Equal(t, domain.StatusAuthorized, renewed.Charges[2].Status)
+
+	// Billing returns to the 31st after February
+	assert.Equal(t, time.Date(2026, time.March, 31, 10, 0, 0, 0, time.UTC), renewed.CurrentPeriodStart)
+	assert.Equal(t, time.Date(2026, time.April, 30, 10, 0, 0, 0, time.UTC), renewed.CurrentPeriodEnd)
+
+	mit := f.processor.last()
+	assert.Equal(t, domain.InitiatorMerchant, mit.Initiator)
+	assert.Equal(t, domain.StoredCredentialSubsequent, mit.StoredCredential.Usage)
+	assert.Equal(t, domain.StoredCredentialRecurring, mit.StoredCredential.Type)
+	assert.Equal(t, "ntid-payment-1", mit.StoredCredential.PreviousNetworkTransactionID)
+	assert.Empty(t, mit.Card.CVV)
+}
+
+func TestService_Dunning_RecoversAfterRetry(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	f.processor.status = domain.StatusDeclined
+	f.advanceTo(t, periodEnd)
+
+	pastDue := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionPastDue, pastDue.Status)
+	assert.Equal(t, 1, pastDue.FailedAttempts)
+	assert.Equal(t, periodEnd.Add(24*time.Hour), pastDue.NextChargeAt)
+
+	f.processor.status = ""
+	f.advanceTo(t, periodEnd.Add(24*time.Hour))
+
+	recovered := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionActive, recovered.Status)
+	assert.Equal(t, 0, recovered.FailedAttempts)
+	require.Len(t, recovered.Charges, 3)
+	assert.Equal(t, 2, recovered.Charges[2].Attempt)
+
+	// The period still runs from the original renewal date
+	assert.Equal(t, periodEnd, recovered.CurrentPeriodStart)
+}
+
+func TestService_Dunning_CancelsWhenRetriesExhausted(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	f.processor.status = domain.StatusDeclined
+	f.advanceTo(t, periodEnd)
+	f.advanceTo(t, periodEnd.Add(24*time.Hour))
+	f.advanceTo(t, periodEnd.Add(96*time.Hour))
+
+	canceled := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionCanceled, canceled.Status)
+	assert.Equal(t, periodEnd.Add(96*time.Hour), *canceled.CanceledAt)
+	require.Len(t, canceled.Charges, 4)
+	assert.Equal(t, domain.StatusDeclined, canceled.Charges[3].Status)
+
+	// Canceled subscriptions are never charged again
+	f.advanceTo(t, periodEnd.Add(365*24*time.Hour))
+	assert.Len(t, f.get(t, sub.ID).Charges, 4)
+}
+
+func TestService_Dunning_BankFailuresAreNotDeclines(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	// More failures than the dunning policy allows declines
+	f.processor.err = fmt.Errorf("failed to process payment with bank: %w", client.ErrCircuitOpen)
+	for i := 0; i < 4; i++ {
+		f.advanceTo(t, periodEnd.Add(time.Duration(i)*time.Hour))
+	}
+
+	waiting := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionActive, waiting.Status, "the card is not to blame")
+	assert.Equal(t, 0, waiting.FailedAttempts)
+	assert.Equal(t, periodEnd.Add(4*time.Hour), waiting.NextChargeAt, "retried after ErrorRetry")
+	require.Len(t, waiting.Charges, 5)
+	assert.Equal(t, domain.StatusRejected, waiting.Charges[4].Status)
+	assert.Contains(t, waiting.Charges[4].Error, "circuit breaker is open")
+
+	f.processor.err = nil
+	f.advanceTo(t, periodEnd.Add(4*time.Hour))
+
+	renewed := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionActive, renewed.Status)
+	assert.Equal(t, periodEnd, renewed.CurrentPeriodStart)
+	assert.Equal(t, domain.StatusAuthorized, renewed.Charges[5].Status)
+}
+
+func TestService_Dunning_UnknownOutcomeIsNotChargedAgain(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	// The bank authorized the renewal but its answer never arrived
+	f.processor.err = fmt.Errorf("failed to process payment with bank: %w", context.DeadlineExceeded)
+	for i := 0; i < 4; i++ {
+		f.advanceTo(t, periodEnd.Add(time.Duration(i)*24*time.Hour))
+	}
+
+	held := f.get(t, sub.ID)
+	assert.Len(t, f.processor.payments, 2, "the card is charged only once")
+	assert.Equal(t, domain.SubscriptionReconciling, held.Status)
+	assert.Equal(t, 0, held.FailedAttempts)
+	assert.Equal(t, sub.CurrentPeriodStart, held.CurrentPeriodStart)
+	require.Len(t, held.Charges, 2)
+	assert.Contains(t, held.Charges[1].Error, "deadline exceeded")
+
+	_, err := f.service.Pause(merchantCtx, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+
+	// The bank confirms it charged the card
+	resolved, err := f.service.ResolveRenewal(sub.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, resolved.Status)
+	assert.Equal(t, periodEnd, resolved.CurrentPeriodStart)
+	assert.Equal(t, domain.StatusAuthorized, resolved.Charges[1].Status)
+
+	_, err = f.service.ResolveRenewal(sub.ID, true)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+}
+
+func TestService_ResolveRenewal_NotCharged(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	f.processor.err = fmt.Errorf("failed to process payment with bank: %w", context.DeadlineExceeded)
+	f.advanceTo(t, periodEnd)
+
+	// The bank has no record of the renewal, so it is charged again
+	f.clock.Set(periodEnd.Add(time.Hour))
+	resolved, err := f.service.ResolveRenewal(sub.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, resolved.Status)
+	assert.Equal(t, 0, resolved.FailedAttempts, "not counted as a decline")
+
+	f.processor.err = nil
+	f.advanceTo(t, periodEnd.Add(time.Hour))
+
+	renewed := f.get(t, sub.ID)
+	assert.Len(t, f.processor.payments, 3)
+	assert.Equal(t, periodEnd, renewed.CurrentPeriodStart)
+	assert.Equal(t, domain.StatusAuthorized, renewed.Charges[2].Status)
+}
+
+func TestService_Dunning_BankRejectionIsDunned(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	f.processor.err = fmt.Errorf("failed to process payment with bank: %w", client.ErrBankRejected)
+	f.advanceTo(t, sub.CurrentPeriodEnd)
+
+	pastDue := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionPastDue, pastDue.Status)
+	assert.Equal(t, 1, pastDue.FailedAttempts)
+}
+
+func TestService_Dunning_ShreddedCard(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	f.processor.err = domain.ErrTokenNotFound
+	f.advanceTo(t, sub.CurrentPeriodEnd)
+
+	pastDue := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionPastDue, pastDue.Status)
+	require.Len(t, pastDue.Charges, 2)
+	assert.Equal(t, domain.StatusRejected, pastDue.Charges[1].Status)
+}
+
+func TestService_PauseAndResume(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+	periodEnd := sub.CurrentPeriodEnd
+
+	paused, err := f.service.Pause(merchantCtx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionPaused, paused.Status)
+
+	_, err = f.service.Pause(merchantCtx, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+
+	// No renewal while paused
+	f.advanceTo(t, periodEnd.Add(48*time.Hour))
+	assert.Len(t, f.get(t, sub.ID).Charges, 1)
+
+	resumed, err := f.service.Resume(merchantCtx, sub.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, resumed.Status)
+	assert.Nil(t, resumed.PausedAt)
+
+	// The missed renewal is charged on the next run
+	f.advanceTo(t, periodEnd.Add(48*time.Hour))
+	assert.Len(t, f.get(t, sub.ID).Charges, 2)
+
+	_, err = f.service.Resume(merchantCtx, sub.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+}
+
+func TestService_Cancel(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	canceled, err := f.service.Cancel(merchantCtx, sub.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionCanceled, canceled.Status)
+	assert.Equal(t, start, *canceled.CanceledAt)
+
+	_, err = f.service.Cancel(merchantCtx, sub.ID, false)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+
+	_, err = f.service.Cancel(merchantCtx, "missing", false)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionNotFound)
+}
+
+func TestService_LocksReleased(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	// Only one of the concurrent pauses finds the subscription active
+	var wg sync.WaitGroup
+	var paused atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.service.Pause(merchantCtx, sub.ID); err == nil {
+				paused.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), paused.Load())
+	assert.Empty(t, f.service.locks, "locks are dropped once released")
+}
+
+func TestService_CancelAtPeriodEnd(t *testing.T) {
+	f := newFixture(t)
+	sub := f.subscribe(t, f.plan(t, 1000))
+
+	pending, err := f.service.Cancel(merchantCtx, sub.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, domain.SubscriptionActive, pending.Status)
+	assert.True(t, pending.CancelAtPeriodEnd)
+
+	f.advanceTo(t, sub.CurrentPeriodEnd)
+
+	ended := f.get(t, sub.ID)
+	assert.Equal(t, domain.SubscriptionCanceled, ended.Status)
+	assert.Equal(t, sub.CurrentPeriodEnd, *ended.CanceledAt)
+	assert.Len(t, ended.Charges, 1)
+}
+
+func TestService_ChangePlan_Upgrade(t *testing.T) {
+	f := newFixture(t)
+	basic := f.plan(t, 1000)
+	pro := f.plan(t, 3000)
+
+	sub := f.subscribe(t, basic)
+
+	// Halfway through a 28-day period
+	f.clock.Set(start.Add(14 * 24 * time.Hour))
+
+	changed, err := f.service.ChangePlan(merchantCtx, sub.ID, pro.ID)
+	require.NoError(t, err)
+	assert.Equal(t, pro.ID, changed.PlanID)
+	require.Len(t, changed.Charges, 2)
+	assert.Equal(t, domain.ChargeProration, changed.Charges[1].Reason)
+	assert.Equal(t, 1000, changed.Charges[1].Amount)
+	assert.Equal(t, domain.StoredCredentialUnscheduled, f.processor.last().StoredCredential.Type)
+
+	// The next renewal is for the new plan at the original renewal date
+	f.advanceTo(t, sub.CurrentPeriodEnd)
+	renewed := f.get(t, sub.ID)
+	require.Len(t, renewed.Charges, 3)
+	assert.Equal(t, 3000, renewed.Charges[2].Amount)
+	assert.Equal(t, time.Date(2026, time.March, 28, 10, 0, 0, 0, time.UTC), renewed.CurrentPeriodEnd)
+}
+
+func TestService_ChangePlan_UpgradeDeclined(t *testing.T) {
+	f := newFixture(t)
+	basic := f.plan(t, 1000)
+	pro := f.plan(t, 3000)
+	sub := f.subscribe(t, basic)
+
+	f.processor.status = domain.StatusDeclined
+	_, err := f.service.ChangePlan(merchantCtx, sub.ID, pro.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionPaymentFailed)
+
+	unchanged := f.get(t, sub.ID)
+	assert.Equal(t, basic.ID, unchanged.PlanID)
+	require.Len(t, unchanged.Charges, 2)
+	assert.Equal(t, domain.StatusDeclined, unchanged.Charges[1].Status)
+}
+
+func TestService_ChangePlan_DowngradeCredit(t *testing.T) {
+	f := newFixture(t)
+	pro := f.plan(t, 3000)
+	basic := f.plan(t, 1000)
+	sub := f.subscribe(t, pro)
+
+	// Straight after subscribing nearly the whole difference is credited
+	changed, err := f.service.ChangePlan(merchantCtx, sub.ID, basic.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2000, changed.Credit)
+	assert.Len(t, changed.Charges, 1)
+
+	// The credit covers two renewals of the cheaper plan
+	f.advanceTo(t, sub.CurrentPeriodEnd)
+	first := f.get(t, sub.ID)
+	assert.Equal(t, 1000, first.Credit)
+	assert.Len(t, first.Charges, 1)
+
+	f.advanceTo(t, first.CurrentPeriodEnd)
+	f.advanceTo(t, f.get(t, sub.ID).CurrentPeriodEnd)
+
+	third := f.get(t, sub.ID)
+	assert.Equal(t, 0, third.Credit)
+	require.Len(t, third.Charges, 2)
+	assert.Equal(t, 1000, third.Charges[1].Amount)
+}
+
+func TestService_ChangePlan_Errors(t *testing.T) {
+	f := newFixture(t)
+	plan := f.plan(t, 1000)
+	sub := f.subscribe(t, plan)
+
+	euro, err := f.service.CreatePlan(merchantCtx, &domain.Plan{Name: "Euro", Amount: 1000, Currency: "EUR", Interval: domain.IntervalMonth})
+	require.NoError(t, err)
+
+	_, err = f.service.ChangePlan(merchantCtx, sub.ID, euro.ID)
+	assert.ErrorIs(t, err, domain.ErrPlanCurrencyChange)
+
+	_, err = f.service.ChangePlan(merchantCtx, sub.ID, "missing")
+	assert.ErrorIs(t, err, domain.ErrPlanNotFound)
+
+	_, err = f.service.Pause(merchantCtx, sub.ID)
+	require.NoError(t, err)
+	_, err = f.service.ChangePlan(merchantCtx, sub.ID, plan.ID)
+	assert.ErrorIs(t, err, domain.ErrSubscriptionInvalidState)
+}
+
+func TestProrate(t *testing.T) {
+	end := start.Add(30 * 24 * time.Hour)
+
+	assert.Equal(t, 1000, prorate(1000, start, end, start))
+	assert.Equal(t, 500, prorate(1000, start, end, start.Add(15*24*time.Hour)))
+	assert.Equal(t, -333, prorate(-1000, start, end, start.Add(20*24*time.Hour)))
+	assert.Equal(t, 0, prorate(1000, start, end, end))
+}
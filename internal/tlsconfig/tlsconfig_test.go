@@ -0,0 +1,111 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func leafCommonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+// touch moves a file's modification time on, since a rewrite within the
+// same clock tick would otherwise go unnoticed
+func touch(t *testing.T, path string, by time.Duration) {
+	at := time.Now().Add(by)
+	require.NoError(t, os.Chtimes(path, at, at))
+}
+
+func TestCertReloader_ReloadsChangedFiles(t *testing.T) {
+	ca := tlstest.NewCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.ServerCert(t, "first").WriteFiles(t, dir)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "first", leafCommonName(t, cert))
+
+	ca.ServerCert(t, "second").WriteFiles(t, dir)
+	touch(t, certFile, time.Minute)
+	touch(t, keyFile, time.Minute)
+
+	cert, err = reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "second", leafCommonName(t, cert))
+}
+
+func TestCertReloader_KeepsCertificateWhenReloadFails(t *testing.T) {
+	ca := tlstest.NewCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.ServerCert(t, "first").WriteFiles(t, dir)
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	// A certificate written before its key does not match the old key
+	require.NoError(t, os.WriteFile(certFile, ca.ServerCert(t, "second").CertPEM, 0o600))
+	touch(t, certFile, time.Minute)
+
+	cert, err := reloader.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "first", leafCommonName(t, cert))
+}
+
+func TestNewCertReloader_Errors(t *testing.T) {
+	_, err := NewCertReloader("/does/not/exist.pem", "/does/not/exist.key")
+	assert.ErrorContains(t, err, "failed to read TLS certificate")
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	_, err = NewCertReloader(certFile, keyFile)
+	assert.ErrorContains(t, err, "failed to load TLS certificate")
+}
+
+func TestNew_ClientAuth(t *testing.T) {
+	ca := tlstest.NewCA(t, "test CA")
+	dir := t.TempDir()
+	certFile, keyFile := ca.ServerCert(t, "gateway").WriteFiles(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.CertPEM, 0o600))
+
+	tests := []struct {
+		clientAuth string
+		expected   tls.ClientAuthType
+	}{
+		{clientAuth: config.ClientAuthNone, expected: tls.NoClientCert},
+		{clientAuth: config.ClientAuthOptional, expected: tls.VerifyClientCertIfGiven},
+		{clientAuth: config.ClientAuthRequire, expected: tls.RequireAndVerifyClientCert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientAuth, func(t *testing.T) {
+			tlsConfig, err := New(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: tt.clientAuth})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tlsConfig.ClientAuth)
+			assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		})
+	}
+
+	_, err := New(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile + ".missing", ClientAuth: config.ClientAuthRequire})
+	assert.ErrorContains(t, err, "failed to read client CA file")
+
+	_, err = New(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile, ClientAuth: config.ClientAuthRequire})
+	assert.ErrorContains(t, err, "client CA file contains no certificates")
+}
@@ -0,0 +1,216 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer every span in the gateway comes from
+const InstrumentationName = "github.com/cko-recruitment/payment-gateway-challenge-go"
+
+// Exporters accepted in Config.Exporter
+const (
+	// ExporterNone records no spans, though incoming trace context is still
+	// passed on to the bank
+	ExporterNone = "none"
+	// ExporterStdout writes spans to stdout as JSON
+	ExporterStdout = "stdout"
+	// ExporterFile writes spans to Config.File as JSON, one per line
+	ExporterFile = "file"
+	// ExporterOTLP sends spans to a collector over OTLP/HTTP. The endpoint and
+	// headers are read from the standard OTEL_EXPORTER_OTLP_* variables.
+	ExporterOTLP = "otlp"
+)
+
+// Attribute keys for payments. The card number is never recorded.
+const (
+	AttrPaymentID        = attribute.Key("payment.id")
+	AttrPaymentStatus    = attribute.Key("payment.status")
+	AttrPaymentCurrency  = attribute.Key("payment.currency")
+	AttrPaymentAmount    = attribute.Key("payment.amount")
+	AttrPaymentInitiator = attribute.Key("payment.initiator")
+)
+
+// Config chooses where spans are exported
+type Config struct {
+	Exporter       string
+	File           string
+	ServiceName    string
+	ServiceVersion string
+}
+
+// ConfigFromEnv reads the exporter from GATEWAY_TRACE_EXPORTER and, for the
+// file exporter, the path from GATEWAY_TRACE_FILE. Tracing is off by default.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Exporter:    os.Getenv("GATEWAY_TRACE_EXPORTER"),
+		File:        os.Getenv("GATEWAY_TRACE_FILE"),
+		ServiceName: "payment-gateway",
+	}
+	if cfg.Exporter == "" {
+		cfg.Exporter = ExporterNone
+	}
+	return cfg
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned function flushes buffered spans and must be
+// called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.Exporter == ExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(cfg.ServiceName),
+			semconv.ServiceVersion(cfg.ServiceVersion),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch cfg.Exporter {
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exporter, nil, err
+
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, nil, fmt.Errorf("trace exporter %q needs a file path", ExporterFile)
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exporter, f, nil
+
+	case ExporterOTLP:
+		exporter, err := otlptracehttp.New(ctx)
+		return exporter, nil, err
+
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+}
+
+// Start starts a span from the global tracer provider. The provider is looked
+// up on every call so one installed after start-up, e.g. by a test, is used.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(InstrumentationName).Start(ctx, name, opts...)
+}
+
+// PaymentAttributes describes a payment on a span without its card details
+func PaymentAttributes(payment *domain.Payment) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttrPaymentCurrency.String(payment.Currency),
+		AttrPaymentAmount.Int(payment.Amount),
+	}
+	if payment.ID != "" {
+		attrs = append(attrs, AttrPaymentID.String(payment.ID))
+	}
+	if payment.Status != "" {
+		attrs = append(attrs, AttrPaymentStatus.String(string(payment.Status)))
+	}
+	if payment.Initiator != "" {
+		attrs = append(attrs, AttrPaymentInitiator.String(string(payment.Initiator)))
+	}
+	return attrs
+}
+
+// End records err on span, if any, and ends it. Errors can quote what the
+// bank or the merchant sent, so spans only get their redacted text.
+func End(span trace.Span, err error) {
+	if err != nil {
+		message := logging.Redact(err.Error())
+		span.RecordError(errors.New(message))
+		span.SetStatus(codes.Error, message)
+	}
+	span.End()
+}
+
+// Middleware starts a server span for every request, continuing any trace
+// the caller sent in a traceparent header. Spans are named after the chi
+// route pattern, so payment IDs do not end up in span names.
+func Middleware(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+	})
+
+	return otelhttp.NewHandler(named, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method
+		}),
+	)
+}
+
+// Transport wraps base so every outbound request gets a client span and a
+// traceparent header
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
@@ -0,0 +1,145 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// useRecorder installs a tracer provider that keeps spans in memory
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return recorder
+}
+
+func TestPaymentAttributes_NeverIncludeCardDetails(t *testing.T) {
+	payment := &domain.Payment{
+		ID:       "payment-1",
+		Card:     domain.Card{Number: "2222405343248877", CVV: "123", ExpiryMonth: 4, ExpiryYear: 2030},
+		Currency: "GBP",
+		Amount:   100,
+		Status:   domain.StatusAuthorized,
+	}
+
+	attrs := PaymentAttributes(payment)
+
+	values := map[string]string{}
+	for _, attr := range attrs {
+		values[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, "payment-1", values["payment.id"])
+	assert.Equal(t, "Authorized", values["payment.status"])
+	assert.Equal(t, "GBP", values["payment.currency"])
+
+	for key, value := range values {
+		assert.NotContains(t, value, "2222405343248877", key)
+		assert.NotContains(t, value, "8877", key)
+	}
+}
+
+func TestEnd_RedactsError(t *testing.T) {
+	recorder := useRecorder(t)
+
+	_, span := Start(context.Background(), "test")
+	End(span, errors.New(`bank rejected request: {"card_number":"2222405343248877","cvv":"123"}`))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.NotContains(t, spans[0].Status().Description, "2222405343248877")
+	assert.Contains(t, spans[0].Status().Description, "8877")
+	assert.NotContains(t, spans[0].Status().Description, `"cvv":"123"`)
+	require.Len(t, spans[0].Events(), 1)
+	for _, attr := range spans[0].Events()[0].Attributes {
+		assert.NotContains(t, attr.Value.Emit(), "2222405343248877")
+	}
+}
+
+func TestMiddleware_NamesSpanAfterRouteAndContinuesTrace(t *testing.T) {
+	recorder := useRecorder(t)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/api/payments/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/payments/payment-1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /api/payments/{id}", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
+
+func TestTransport_PropagatesTraceContext(t *testing.T) {
+	recorder := useRecorder(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/payments", nil)
+	require.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	client := spans[0]
+	assert.Equal(t, "POST /payments", client.Name())
+	assert.Equal(t, parent.SpanContext().TraceID(), client.SpanContext().TraceID())
+	assert.Equal(t, "00-"+client.SpanContext().TraceID().String()+"-"+client.SpanContext().SpanID().String()+"-01", traceparent)
+}
+
+func TestSetup_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Config{Exporter: ExporterFile, File: path, ServiceName: "test"})
+	require.NoError(t, err)
+
+	_, span := Start(context.Background(), "PaymentService.ProcessPayment")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"PaymentService.ProcessPayment"`)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestSetup_Errors(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "jaeger"})
+	assert.ErrorContains(t, err, "unknown trace exporter")
+
+	_, err = Setup(context.Background(), Config{Exporter: ExporterFile})
+	assert.ErrorContains(t, err, "needs a file path")
+}
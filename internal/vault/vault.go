@@ -0,0 +1,313 @@
+package vault
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/journal"
+)
+
+// JournalFile is the name of the vault journal in the storage directory
+const JournalFile = "vault.journal"
+
+// storedCard is the sensitive part of a card that is encrypted at rest
+type storedCard struct {
+	Number      string `json:"number"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+}
+
+// record is what the vault keeps per token. Only the owner, the last four
+// digits and the expiry are held in the clear so that tokens can be described
+// without decrypting the card number.
+type record struct {
+	merchantID  string
+	lastFour    string
+	expiryMonth int
+	expiryYear  int
+	sealed      *envelope.Sealed
+}
+
+// entry is one line of the vault journal: a token's record, written when it
+// is created and again whenever its data key changes
+type entry struct {
+	Token       string           `json:"token"`
+	MerchantID  string           `json:"merchant_id,omitempty"`
+	LastFour    string           `json:"last_four"`
+	ExpiryMonth int              `json:"expiry_month"`
+	ExpiryYear  int              `json:"expiry_year"`
+	Sealed      *envelope.Sealed `json:"sealed"`
+}
+
+// Opened with a journal file, every record is appended to the file before
+// the call returns so tokens survive a restart.
+//
+// In production, this would be backed by an HSM and a database
+type Vault struct {
+	envelope *envelope.Envelope
+	records  map[string]*record
+	journal  *journal.Journal
+	mu       sync.RWMutex
+}
+
+// NewVault creates a vault that envelope-encrypts every card with its own
+// data key
+func NewVault(e *envelope.Envelope) *Vault {
+	return &Vault{
+		envelope: e,
+		records:  make(map[string]*record),
+	}
+}
+
+// OpenVault loads the journal at path, creating it if needed
+func OpenVault(path string, e *envelope.Envelope) (*Vault, error) {
+	v := NewVault(e)
+
+	replay := func(line []byte) error {
+		var ent entry
+		if err := json.Unmarshal(line, &ent); err != nil {
+			return err
+		}
+		v.records[ent.Token] = ent.record()
+		return nil
+	}
+
+	j, err := journal.Open(path, "vault journal", replay, v.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	v.journal = j
+
+	return v, nil
+}
+
+func (v *Vault) Close() error {
+	if v.journal == nil {
+		return nil
+	}
+	return v.journal.Close()
+}
+
+// Ping reports whether the vault can be used. A journal must still be the
+// file at its path.
+func (v *Vault) Ping(ctx context.Context) error {
+	if v.journal == nil {
+		return nil
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.journal.Ping(ctx)
+}
+
+// Tokenize stores the card number and expiry for merchantID and returns the
+// token metadata. The CVV is never stored.
+func (v *Vault) Tokenize(merchantID string, card domain.Card) (*domain.Card, error) {
+	if err := card.ValidateForTokenization(); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(storedCard{
+		Number:      card.Number,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal card: %w", err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// The token is bound as additional data so a ciphertext cannot be
+	// swapped between records
+	sealed, err := v.envelope.Seal(plaintext, []byte(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt card: %w", err)
+	}
+
+	rec := &record{
+		merchantID:  merchantID,
+		lastFour:    card.GetLastFourDigits(),
+		expiryMonth: card.ExpiryMonth,
+		expiryYear:  card.ExpiryYear,
+		sealed:      sealed,
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.append(token, rec); err != nil {
+		return nil, err
+	}
+	v.records[token] = rec
+
+	return rec.describe(token), nil
+}
+
+// Describe returns the non-sensitive details of a card tokenized by
+// merchantID. Other merchants' tokens are reported as not found, so they
+// cannot be probed.
+func (v *Vault) Describe(merchantID, token string) (*domain.Card, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	rec, exists := v.records[token]
+	if !exists || rec.merchantID != merchantID || rec.sealed.IsShredded() {
+		return nil, domain.ErrTokenNotFound
+	}
+
+	return rec.describe(token), nil
+}
+
+// Detokenize decrypts the card behind a token whatever merchant owns it. It
+// is only meant to be used once the token has been resolved with Describe,
+// e.g. when building the request to the bank.
+func (v *Vault) Detokenize(token string) (*domain.Card, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	rec, exists := v.records[token]
+	if !exists {
+		return nil, domain.ErrTokenNotFound
+	}
+
+	plaintext, err := v.envelope.Open(rec.sealed, []byte(token))
+	if err != nil {
+		if errors.Is(err, envelope.ErrShredded) {
+			return nil, domain.ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("failed to decrypt card: %w", err)
+	}
+
+	var stored storedCard
+	if err := json.Unmarshal(plaintext, &stored); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal card: %w", err)
+	}
+
+	card := rec.describe(token)
+	card.Number = stored.Number
+	card.ExpiryMonth = stored.ExpiryMonth
+	card.ExpiryYear = stored.ExpiryYear
+
+	return card, nil
+}
+
+// Shred destroys the data key of a token tokenized by merchantID. The card
+// number can never be recovered afterwards and the token stops working, but
+// the record is kept so earlier payments can still be traced to it.
+func (v *Vault) Shred(merchantID, token string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	rec, exists := v.records[token]
+	if !exists || rec.merchantID != merchantID {
+		return domain.ErrTokenNotFound
+	}
+
+	rec.sealed.Shred()
+
+	// The journal still holds the data key the card was sealed with, so it
+	// is rewritten without it
+	if v.journal != nil {
+		if err := v.journal.Rewrite(v.snapshot); err != nil {
+			return fmt.Errorf("failed to shred card on disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// RewrapKeys moves every card onto the current master key version
+func (v *Vault) RewrapKeys(e *envelope.Envelope) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	changed := 0
+	for token, rec := range v.records {
+		sealed := *rec.sealed
+		ok, err := e.Rewrap(&sealed)
+		if err != nil {
+			return changed, fmt.Errorf("token %s: %w", token, err)
+		}
+		if !ok {
+			continue
+		}
+
+		rewrapped := *rec
+		rewrapped.sealed = &sealed
+		if err := v.append(token, &rewrapped); err != nil {
+			return changed, err
+		}
+		v.records[token] = &rewrapped
+		changed++
+	}
+
+	return changed, nil
+}
+
+// append journals a record, if there is a journal. It must be called with
+// mu held for writing.
+func (v *Vault) append(token string, rec *record) error {
+	if v.journal == nil {
+		return nil
+	}
+	return v.journal.Append(rec.entry(token))
+}
+
+// snapshot writes the current record of every token
+func (v *Vault) snapshot(write func(entry any) error) error {
+	for token, rec := range v.records {
+		if err := write(rec.entry(token)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *record) entry(token string) entry {
+	return entry{
+		Token:       token,
+		MerchantID:  r.merchantID,
+		LastFour:    r.lastFour,
+		ExpiryMonth: r.expiryMonth,
+		ExpiryYear:  r.expiryYear,
+		Sealed:      r.sealed,
+	}
+}
+
+func (e entry) record() *record {
+	return &record{
+		merchantID:  e.MerchantID,
+		lastFour:    e.LastFour,
+		expiryMonth: e.ExpiryMonth,
+		expiryYear:  e.ExpiryYear,
+		sealed:      e.Sealed,
+	}
+}
+
+func (r *record) describe(token string) *domain.Card {
+	return &domain.Card{
+		Token:       token,
+		LastFour:    r.lastFour,
+		ExpiryMonth: r.expiryMonth,
+		ExpiryYear:  r.expiryYear,
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return domain.TokenPrefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestVault(t *testing.T) *Vault {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+
+	return NewVault(envelope.New(provider))
+}
+
+func TestVault_TokenizeAndDetokenize(t *testing.T) {
+	v := newTestVault(t)
+	futureYear := time.Now().Year() + 1
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  futureYear,
+		CVV:         "123",
+	})
+	require.NoError(t, err)
+
+	assert.True(t, card.IsTokenized())
+	assert.Empty(t, card.Number)
+	assert.Empty(t, card.CVV)
+	assert.Equal(t, "8877", card.GetLastFourDigits())
+	assert.Equal(t, 4, card.ExpiryMonth)
+	assert.Equal(t, futureYear, card.ExpiryYear)
+
+	described, err := v.Describe("merchant-1", card.Token)
+	require.NoError(t, err)
+	assert.Empty(t, described.Number)
+	assert.Equal(t, "8877", described.LastFour)
+
+	detokenized, err := v.Detokenize(card.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", detokenized.Number)
+	assert.Equal(t, 4, detokenized.ExpiryMonth)
+	assert.Equal(t, futureYear, detokenized.ExpiryYear)
+	assert.Empty(t, detokenized.CVV) // CVV is never stored
+}
+
+func TestVault_TokensAreUnique(t *testing.T) {
+	v := newTestVault(t)
+	card := domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	}
+
+	first, err := v.Tokenize("merchant-1", card)
+	require.NoError(t, err)
+	second, err := v.Tokenize("merchant-1", card)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first.Token, second.Token)
+}
+
+func TestVault_CardNumberIsEncrypted(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+	require.NoError(t, err)
+
+	rec := v.records[card.Token]
+	assert.NotContains(t, string(rec.sealed.Ciphertext), "2222405343248877")
+	assert.NotEmpty(t, rec.sealed.WrappedKey)
+}
+
+func TestVault_Tokenize_ValidationError(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "123",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+
+	assert.Nil(t, card)
+	assert.Equal(t, domain.ErrCardNumberInvalid, err)
+	assert.Empty(t, v.records)
+}
+
+func TestVault_UnknownToken(t *testing.T) {
+	v := newTestVault(t)
+
+	_, err := v.Describe("merchant-1", "tok_unknown")
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+
+	_, err = v.Detokenize("tok_unknown")
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+}
+
+func TestVault_Shred(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, v.Shred("merchant-1", card.Token))
+
+	_, err = v.Detokenize(card.Token)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+
+	_, err = v.Describe("merchant-1", card.Token)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+
+	assert.Equal(t, domain.ErrTokenNotFound, v.Shred("merchant-1", "tok_unknown"))
+}
+
+func TestVault_MerchantScoped(t *testing.T) {
+	v := newTestVault(t)
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+	require.NoError(t, err)
+
+	// Another merchant cannot tell the token exists, let alone shred it
+	_, err = v.Describe("merchant-2", card.Token)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+	assert.Equal(t, domain.ErrTokenNotFound, v.Shred("merchant-2", card.Token))
+
+	described, err := v.Describe("merchant-1", card.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "8877", described.LastFour)
+}
+
+func TestVault_RewrapKeys(t *testing.T) {
+	oldKey, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	newKey, err := envelope.GenerateKey()
+	require.NoError(t, err)
+
+	oldProvider, err := envelope.NewStaticKeyProvider(1, map[int][]byte{1: oldKey})
+	require.NoError(t, err)
+	v := NewVault(envelope.New(oldProvider))
+
+	card, err := v.Tokenize("merchant-1", domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+	require.NoError(t, err)
+
+	// Rotate to version 2 while keeping version 1 readable
+	rotated, err := envelope.NewStaticKeyProvider(2, map[int][]byte{1: oldKey, 2: newKey})
+	require.NoError(t, err)
+	v.envelope = envelope.New(rotated)
+
+	changed, err := v.RewrapKeys(v.envelope)
+	require.NoError(t, err)
+	assert.Equal(t, 1, changed)
+	assert.Equal(t, 2, v.records[card.Token].sealed.KeyVersion)
+
+	// Version 1 can now be retired
+	retired, err := envelope.NewStaticKeyProvider(2, map[int][]byte{2: newKey})
+	require.NoError(t, err)
+	v.envelope = envelope.New(retired)
+
+	detokenized, err := v.Detokenize(card.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", detokenized.Number)
+}
+
+func TestVault_JournalSurvivesRestart(t *testing.T) {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	e := envelope.New(provider)
+	path := filepath.Join(t.TempDir(), JournalFile)
+
+	v, err := OpenVault(path, e)
+	require.NoError(t, err)
+
+	card := domain.Card{
+		Number:      "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	}
+	kept, err := v.Tokenize("merchant-1", card)
+	require.NoError(t, err)
+	shredded, err := v.Tokenize("merchant-1", card)
+	require.NoError(t, err)
+	wrappedKey := base64.StdEncoding.EncodeToString(v.records[shredded.Token].sealed.WrappedKey)
+	require.NoError(t, v.Shred("merchant-1", shredded.Token))
+	require.NoError(t, v.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "2222405343248877")
+	assert.NotContains(t, string(data), wrappedKey, "a shredded data key must not stay on disk")
+
+	reopened, err := OpenVault(path, e)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	detokenized, err := reopened.Detokenize(kept.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "2222405343248877", detokenized.Number)
+	assert.Equal(t, "8877", detokenized.LastFour)
+
+	// The owner is kept too
+	_, err = reopened.Describe("merchant-2", kept.Token)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+
+	_, err = reopened.Detokenize(shredded.Token)
+	assert.Equal(t, domain.ErrTokenNotFound, err)
+	assert.NoError(t, reopened.Ping(context.Background()))
+}
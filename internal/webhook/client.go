@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned when an endpoint resolves to an address
+// the gateway must not call, such as its own network's
+var ErrForbiddenAddress = errors.New("webhook endpoint resolves to a private or local address")
+
+// carrierGradeNAT is shared address space (RFC 6598), private in all but name
+var carrierGradeNAT = netip.MustParsePrefix("100.64.0.0/10")
+
+// NewHTTPClient returns the client deliveries are sent with. Endpoint URLs
+// come from merchants, so unless allowPrivate is set it refuses to connect
+// to loopback, private, link-local and other non-public addresses. The
+// check runs on the address actually dialled, after DNS resolution, so a
+// hostname cannot be pointed at an internal service after it is
+// registered. Redirects are not followed: a 3xx fails the attempt like any
+// other non-2xx response.
+func NewHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !allowPrivate {
+		dialer.Control = refusePrivateAddresses
+	}
+
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			// A proxy would dial on our behalf, past the address check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// refusePrivateAddresses is a net.Dialer Control func, called with the
+// resolved address of every connection before it is made
+func refusePrivateAddresses(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, address)
+	}
+
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addrPort.Addr())
+	}
+	return nil
+}
+
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!carrierGradeNAT.Contains(addr)
+}
@@ -0,0 +1,256 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// EventTypeHeader and DeliveryIDHeader let receivers route and
+	// de-duplicate deliveries without parsing the body
+	EventTypeHeader  = "Webhook-Event-Type"
+	DeliveryIDHeader = "Webhook-Delivery-Id"
+
+	// maxConcurrentDeliveries stops one slow endpoint from holding up the
+	// rest of the outbox
+	maxConcurrentDeliveries = 8
+
+	// deliveryTimeout bounds one attempt. A delivery is claimed for twice
+	// as long before it is sent, so an overlapping run does not send it
+	// again, and a crash part way through only delays its retry.
+	deliveryTimeout = 10 * time.Second
+	claimDuration   = 2 * deliveryTimeout
+)
+
+// BackoffPolicy spaces out retries exponentially. The n-th failure waits
+// Initial * 2^(n-1), capped at Max. After MaxAttempts failures the delivery
+// is dead-lettered.
+type BackoffPolicy struct {
+	Initial     time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// DefaultBackoffPolicy retries for roughly six hours
+var DefaultBackoffPolicy = BackoffPolicy{
+	Initial:     30 * time.Second,
+	Max:         time.Hour,
+	MaxAttempts: 12,
+}
+
+// Delay returns the wait after the given number of consecutive failures
+func (p BackoffPolicy) Delay(failures int) time.Duration {
+	delay := p.Initial
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.Max {
+			return p.Max
+		}
+	}
+	return delay
+}
+
+// Dispatcher sends due deliveries from the outbox
+type Dispatcher struct {
+	repository Repository
+	httpClient *http.Client
+	clock      clock.Clock
+	backoff    BackoffPolicy
+	interval   time.Duration
+}
+
+// DispatcherOption configures a Dispatcher
+type DispatcherOption func(*Dispatcher)
+
+// WithHTTPClient replaces the default client, which times out after ten
+// seconds, does not follow redirects and refuses to connect to private
+// addresses (see NewHTTPClient)
+func WithHTTPClient(c *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.httpClient = c
+	}
+}
+
+// WithBackoffPolicy replaces DefaultBackoffPolicy
+func WithBackoffPolicy(policy BackoffPolicy) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.backoff = policy
+	}
+}
+
+// WithDispatcherClock replaces the wall clock, mainly for tests
+func WithDispatcherClock(c clock.Clock) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.clock = c
+	}
+}
+
+func NewDispatcher(repository Repository, interval time.Duration, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		repository: repository,
+		httpClient: NewHTTPClient(false),
+		clock:      clock.Real{},
+		backoff:    DefaultBackoffPolicy,
+		interval:   interval,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Run sends due deliveries on every tick until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			d.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce attempts every delivery that is due and returns how many it tried
+func (d *Dispatcher) RunOnce(ctx context.Context) int {
+	ids, err := d.repository.FindDueDeliveries(d.clock.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to find due webhook deliveries", "error", err)
+		return 0
+	}
+
+	var g errgroup.Group
+	g.SetLimit(maxConcurrentDeliveries)
+
+	for _, id := range ids {
+		id := id
+		g.Go(func() error {
+			if err := d.deliver(ctx, id); err != nil {
+				slog.WarnContext(ctx, "webhook delivery failed", "delivery_id", id, "error", err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return len(ids)
+}
+
+// deliver makes one attempt and records the outcome. The returned error is
+// about the outbox itself; failed attempts are recorded on the delivery.
+func (d *Dispatcher) deliver(ctx context.Context, id string) error {
+	delivery, err := d.repository.FindDelivery(id)
+	if err != nil {
+		return err
+	}
+	if delivery == nil || delivery.Status != domain.DeliveryPending {
+		return nil
+	}
+
+	// Claim the delivery, so a run overlapping this one skips it. Losing
+	// the claim means it was claimed or redelivered in the meantime.
+	now := d.clock.Now()
+	if delivery.NextAttemptAt.After(now) {
+		return nil
+	}
+	delivery.NextAttemptAt = now.Add(claimDuration)
+	if err := d.repository.SaveDelivery(delivery); err != nil {
+		if errors.Is(err, domain.ErrDeliveryConflict) {
+			return nil
+		}
+		return err
+	}
+
+	event, err := d.repository.FindEvent(delivery.EventID)
+	if err != nil {
+		return err
+	}
+	endpoint, err := d.repository.FindEndpoint(delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	var attempt domain.DeliveryAttempt
+	switch {
+	case event == nil:
+		attempt = domain.DeliveryAttempt{At: d.clock.Now(), Error: "event not found"}
+	case endpoint == nil:
+		attempt = domain.DeliveryAttempt{At: d.clock.Now(), Error: "endpoint was deleted"}
+	default:
+		attempt = d.send(ctx, endpoint, event, delivery.ID)
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = domain.DeliverySucceeded
+	case event == nil || endpoint == nil:
+		delivery.Status = domain.DeliveryDead
+	default:
+		delivery.Failures++
+		if delivery.Failures >= d.backoff.MaxAttempts {
+			delivery.Status = domain.DeliveryDead
+		} else {
+			delivery.NextAttemptAt = attempt.At.Add(d.backoff.Delay(delivery.Failures))
+		}
+	}
+
+	// Should anything else have saved the delivery since the claim, its
+	// newer state wins over this attempt's
+	if err := d.repository.SaveDelivery(delivery); err != nil {
+		if errors.Is(err, domain.ErrDeliveryConflict) {
+			slog.WarnContext(ctx, "webhook delivery changed while it was sent, keeping the newer state", "delivery_id", delivery.ID)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint *domain.WebhookEndpoint, event *domain.Event, deliveryID string) domain.DeliveryAttempt {
+	now := d.clock.Now()
+	attempt := domain.DeliveryAttempt{At: now}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(event.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, string(event.Type))
+	req.Header.Set(DeliveryIDHeader, deliveryID)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, now, event.Payload))
+
+	start := time.Now()
+	resp, err := d.httpClient.Do(req)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", resp.StatusCode)
+	}
+
+	return attempt
+}
@@ -0,0 +1,268 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type received struct {
+	body   []byte
+	header http.Header
+}
+
+// receiver is a merchant webhook endpoint that answers with the queued status
+// codes, then 200
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.requests = append(rc.requests, received{body: body, header: r.Header.Clone()})
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+type dispatcherFixture struct {
+	repo       *repository.WebhooksRepository
+	service    *Service
+	dispatcher *Dispatcher
+	clock      *clock.Fake
+	receiver   *receiver
+	endpoint   *domain.WebhookEndpoint
+}
+
+func newDispatcherFixture(t *testing.T, statuses ...int) *dispatcherFixture {
+	f := &dispatcherFixture{
+		repo:     newTestRepository(t),
+		clock:    clock.NewFake(start),
+		receiver: &receiver{statuses: statuses},
+	}
+
+	server := httptest.NewServer(f.receiver)
+	t.Cleanup(server.Close)
+
+	f.service = NewService(f.repo, WithClock(f.clock))
+	f.dispatcher = NewDispatcher(f.repo, time.Second,
+		WithDispatcherClock(f.clock),
+		WithBackoffPolicy(BackoffPolicy{Initial: time.Minute, Max: 4 * time.Minute, MaxAttempts: 4}),
+		// The receiver listens on loopback
+		WithHTTPClient(NewHTTPClient(true)),
+	)
+
+	endpoint, err := f.service.RegisterEndpoint(merchantCtx, server.URL, nil)
+	require.NoError(t, err)
+	f.endpoint = endpoint
+
+	return f
+}
+
+func (f *dispatcherFixture) delivery(t *testing.T) *domain.WebhookDelivery {
+	deliveries, err := f.service.ListDeliveries(merchantCtx, f.endpoint.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	return &deliveries[0]
+}
+
+func TestDispatcher_DeliversSignedEvent(t *testing.T) {
+	f := newDispatcherFixture(t)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	assert.Equal(t, 1, f.dispatcher.RunOnce(context.Background()))
+
+	require.Len(t, f.receiver.requests, 1)
+	req := f.receiver.requests[0]
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, "payment.authorized", req.header.Get(EventTypeHeader))
+	assert.Equal(t, f.delivery(t).ID, req.header.Get(DeliveryIDHeader))
+	assert.NoError(t, Verify(f.endpoint.Secret, req.header.Get(SignatureHeader), req.body, start, 5*time.Minute))
+	assert.NotContains(t, string(req.body), "2222405343248877")
+
+	delivery := f.delivery(t)
+	assert.Equal(t, domain.DeliverySucceeded, delivery.Status)
+	require.Len(t, delivery.Attempts, 1)
+	assert.Equal(t, http.StatusOK, delivery.Attempts[0].StatusCode)
+
+	// Nothing is sent twice
+	assert.Equal(t, 0, f.dispatcher.RunOnce(context.Background()))
+}
+
+func TestDispatcher_RetriesWithBackoff(t *testing.T) {
+	f := newDispatcherFixture(t, http.StatusInternalServerError, http.StatusServiceUnavailable)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusDeclined)))
+
+	f.dispatcher.RunOnce(context.Background())
+	first := f.delivery(t)
+	assert.Equal(t, domain.DeliveryPending, first.Status)
+	assert.Equal(t, 1, first.Failures)
+	assert.Equal(t, start.Add(time.Minute), first.NextAttemptAt)
+	assert.Equal(t, "endpoint responded with status 500", first.Attempts[0].Error)
+
+	// Not due yet
+	f.clock.Advance(30 * time.Second)
+	assert.Equal(t, 0, f.dispatcher.RunOnce(context.Background()))
+
+	f.clock.Set(start.Add(time.Minute))
+	f.dispatcher.RunOnce(context.Background())
+	second := f.delivery(t)
+	assert.Equal(t, 2, second.Failures)
+	assert.Equal(t, start.Add(3*time.Minute), second.NextAttemptAt)
+
+	f.clock.Set(start.Add(3 * time.Minute))
+	f.dispatcher.RunOnce(context.Background())
+	third := f.delivery(t)
+	assert.Equal(t, domain.DeliverySucceeded, third.Status)
+	assert.Len(t, third.Attempts, 3)
+	assert.Len(t, f.receiver.requests, 3)
+}
+
+func TestDispatcher_DeadLettersAndRedelivers(t *testing.T) {
+	f := newDispatcherFixture(t, 500, 500, 500, 500)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	for i := 0; i < 4; i++ {
+		f.dispatcher.RunOnce(context.Background())
+		f.clock.Advance(time.Hour)
+	}
+
+	dead, err := f.service.ListDeliveries(merchantCtx, f.endpoint.ID, domain.DeliveryDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Len(t, dead[0].Attempts, 4)
+
+	// The dead-letter queue is not retried on its own
+	assert.Equal(t, 0, f.dispatcher.RunOnce(context.Background()))
+
+	_, err = f.service.Redeliver(merchantCtx, dead[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, f.dispatcher.RunOnce(context.Background()))
+
+	delivery := f.delivery(t)
+	assert.Equal(t, domain.DeliverySucceeded, delivery.Status)
+	assert.Len(t, delivery.Attempts, 5)
+	assert.Len(t, f.receiver.requests, 5)
+}
+
+func TestDispatcher_DeletedEndpoint(t *testing.T) {
+	f := newDispatcherFixture(t)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+	id := f.delivery(t).ID
+
+	require.NoError(t, f.service.DeleteEndpoint(merchantCtx, f.endpoint.ID))
+	f.dispatcher.RunOnce(context.Background())
+
+	// The merchant can no longer see it, so it is read from the outbox
+	delivery, err := f.repo.FindDelivery(id)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliveryDead, delivery.Status)
+	assert.Equal(t, "endpoint was deleted", delivery.Attempts[0].Error)
+	assert.Empty(t, f.receiver.requests)
+}
+
+func TestDispatcher_UnreachableEndpoint(t *testing.T) {
+	f := newDispatcherFixture(t)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	endpoint, err := f.service.RegisterEndpoint(merchantCtx, url, nil)
+	require.NoError(t, err)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	f.dispatcher.RunOnce(context.Background())
+
+	deliveries, err := f.service.ListDeliveries(merchantCtx, endpoint.ID, domain.DeliveryPending)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 1, deliveries[0].Failures)
+	assert.Zero(t, deliveries[0].Attempts[0].StatusCode)
+	assert.NotEmpty(t, deliveries[0].Attempts[0].Error)
+}
+
+func TestDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	f := newDispatcherFixture(t)
+	f.dispatcher = NewDispatcher(f.repo, time.Second, WithDispatcherClock(f.clock))
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	f.dispatcher.RunOnce(context.Background())
+
+	delivery := f.delivery(t)
+	assert.Equal(t, domain.DeliveryPending, delivery.Status)
+	assert.Contains(t, delivery.Attempts[0].Error, ErrForbiddenAddress.Error())
+	assert.Empty(t, f.receiver.requests)
+}
+
+func TestDispatcher_DoesNotFollowRedirects(t *testing.T) {
+	f := newDispatcherFixture(t)
+
+	internal := httptest.NewServer(f.receiver)
+	t.Cleanup(internal.Close)
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	t.Cleanup(redirect.Close)
+
+	endpoint, err := f.service.RegisterEndpoint(merchantCtx, redirect.URL, nil)
+	require.NoError(t, err)
+	require.NoError(t, f.service.DeleteEndpoint(merchantCtx, f.endpoint.ID))
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	f.dispatcher.RunOnce(context.Background())
+
+	deliveries, err := f.service.ListDeliveries(merchantCtx, endpoint.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, http.StatusTemporaryRedirect, deliveries[0].Attempts[0].StatusCode)
+	assert.Equal(t, 1, deliveries[0].Failures)
+	assert.Empty(t, f.receiver.requests, "the redirect is not followed")
+}
+
+func TestDispatcher_SkipsClaimedDelivery(t *testing.T) {
+	f := newDispatcherFixture(t)
+	require.NoError(t, f.service.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	// Another run claimed it and is still sending
+	claimed := f.delivery(t)
+	claimed.NextAttemptAt = start.Add(claimDuration)
+	require.NoError(t, f.repo.SaveDelivery(claimed))
+
+	assert.NoError(t, f.dispatcher.deliver(context.Background(), claimed.ID))
+	assert.Empty(t, f.receiver.requests)
+
+	// A crashed run's claim runs out and the delivery is retried
+	f.clock.Advance(claimDuration)
+	assert.Equal(t, 1, f.dispatcher.RunOnce(context.Background()))
+	assert.Equal(t, domain.DeliverySucceeded, f.delivery(t).Status)
+}
+
+func TestBackoffPolicy_Delay(t *testing.T) {
+	p := BackoffPolicy{Initial: 30 * time.Second, Max: 5 * time.Minute}
+
+	assert.Equal(t, 30*time.Second, p.Delay(1))
+	assert.Equal(t, time.Minute, p.Delay(2))
+	assert.Equal(t, 4*time.Minute, p.Delay(4))
+	assert.Equal(t, 5*time.Minute, p.Delay(5))
+	assert.Equal(t, 5*time.Minute, p.Delay(40))
+}
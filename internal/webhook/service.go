@@ -0,0 +1,302 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/google/uuid"
+)
+
+type Repository interface {
+	SaveEndpoint(endpoint *domain.WebhookEndpoint) error
+	FindEndpoint(id string) (*domain.WebhookEndpoint, error)
+	ListEndpoints() ([]domain.WebhookEndpoint, error)
+	DeleteEndpoint(id string) error
+	Enqueue(event *domain.Event, deliveries []domain.WebhookDelivery) error
+	FindEvent(id string) (*domain.Event, error)
+	SaveDelivery(delivery *domain.WebhookDelivery) error
+	FindDelivery(id string) (*domain.WebhookDelivery, error)
+	FindDueDeliveries(now time.Time) ([]string, error)
+	ListDeliveries(endpointID string, status domain.DeliveryStatus) ([]domain.WebhookDelivery, error)
+}
+
+// eventPayload is the JSON body of every delivery
+type eventPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      paymentData `json:"data"`
+}
+
+// paymentData describes the payment an event is about. Like the API
+// responses, it only ever carries the last four digits of the card.
+type paymentData struct {
+	ID                   string `json:"id"`
+	Status               string `json:"status"`
+	CardNumberLastFour   string `json:"card_number_last_four"`
+	ExpiryMonth          int    `json:"expiry_month"`
+	ExpiryYear           int    `json:"expiry_year"`
+	Currency             string `json:"currency"`
+	Amount               int    `json:"amount"`
+	Initiator            string `json:"initiator,omitempty"`
+	NetworkTransactionID string `json:"network_transaction_id,omitempty"`
+	CapturedAmount       int    `json:"captured_amount,omitempty"`
+	RefundedAmount       int    `json:"refunded_amount,omitempty"`
+}
+
+// Service manages webhook endpoints and writes events to the outbox. The
+// Dispatcher delivers them. Each endpoint belongs to the merchant that
+// registered it and is only ever shown to that merchant.
+type Service struct {
+	repository Repository
+	clock      clock.Clock
+	unscoped   bool
+}
+
+// Option configures a Service
+type Option func(*Service)
+
+// WithClock replaces the wall clock, mainly for tests
+func WithClock(c clock.Clock) Option {
+	return func(s *Service) {
+		s.clock = c
+	}
+}
+
+// Unscoped lets callers manage every merchant's endpoints. It is for
+// operator tools working on the storage directory, never for the API.
+func Unscoped() Option {
+	return func(s *Service) {
+		s.unscoped = true
+	}
+}
+
+func NewService(repository Repository, opts ...Option) *Service {
+	s := &Service{
+		repository: repository,
+		clock:      clock.Real{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// RegisterEndpoint adds an endpoint for the merchant in ctx with a fresh
+// signing secret. The secret is only returned here.
+func (s *Service) RegisterEndpoint(ctx context.Context, url string, eventTypes []domain.EventType) (*domain.WebhookEndpoint, error) {
+	endpoint := &domain.WebhookEndpoint{
+		URL:        url,
+		MerchantID: merchant.IDFrom(ctx),
+		EventTypes: eventTypes,
+	}
+	if err := endpoint.Validate(); err != nil {
+		return nil, err
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.ID = uuid.New().String()
+	endpoint.Secret = secret
+	endpoint.CreatedAt = s.clock.Now()
+
+	if err := s.repository.SaveEndpoint(endpoint); err != nil {
+		return nil, fmt.Errorf("failed to save webhook endpoint: %w", err)
+	}
+
+	return endpoint, nil
+}
+
+// GetEndpoint returns an endpoint of the merchant in ctx. Other merchants'
+// endpoints are reported as not found.
+func (s *Service) GetEndpoint(ctx context.Context, id string) (*domain.WebhookEndpoint, error) {
+	endpoint, err := s.repository.FindEndpoint(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint == nil || (!s.unscoped && endpoint.MerchantID != merchant.IDFrom(ctx)) {
+		return nil, domain.ErrWebhookEndpointNotFound
+	}
+
+	return endpoint, nil
+}
+
+// DeleteEndpoint stops deliveries to an endpoint. Anything still queued for
+// it is dead-lettered by the Dispatcher.
+func (s *Service) DeleteEndpoint(ctx context.Context, id string) error {
+	if _, err := s.GetEndpoint(ctx, id); err != nil {
+		return err
+	}
+
+	return s.repository.DeleteEndpoint(id)
+}
+
+// ListDeliveries returns an endpoint's deliveries, newest first, optionally
+// filtered by status. Listing DeliveryDead shows the dead-letter queue.
+func (s *Service) ListDeliveries(ctx context.Context, endpointID string, status domain.DeliveryStatus) ([]domain.WebhookDelivery, error) {
+	if _, err := s.GetEndpoint(ctx, endpointID); err != nil {
+		return nil, err
+	}
+
+	return s.repository.ListDeliveries(endpointID, status)
+}
+
+// GetDelivery returns a delivery to an endpoint of the merchant in ctx
+func (s *Service) GetDelivery(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	delivery, err := s.repository.FindDelivery(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if delivery == nil {
+		return nil, domain.ErrDeliveryNotFound
+	}
+
+	// The endpoint decides who may see it, so once the endpoint is deleted
+	// only operators can
+	if !s.unscoped {
+		endpoint, err := s.repository.FindEndpoint(delivery.EndpointID)
+		if err != nil {
+			return nil, err
+		}
+		if endpoint == nil || endpoint.MerchantID != merchant.IDFrom(ctx) {
+			return nil, domain.ErrDeliveryNotFound
+		}
+	}
+
+	return delivery, nil
+}
+
+// Redeliver queues a dead or already delivered event to be sent again
+// straight away, with a fresh set of retries. The attempt log is kept.
+func (s *Service) Redeliver(ctx context.Context, id string) (*domain.WebhookDelivery, error) {
+	delivery, err := s.GetDelivery(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if delivery.Status == domain.DeliveryPending {
+		return nil, domain.ErrDeliveryPending
+	}
+
+	if _, err := s.GetEndpoint(ctx, delivery.EndpointID); err != nil {
+		return nil, err
+	}
+
+	delivery.Status = domain.DeliveryPending
+	delivery.Failures = 0
+	delivery.NextAttemptAt = s.clock.Now()
+
+	if err := s.repository.SaveDelivery(delivery); err != nil {
+		return nil, fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+// PublishPaymentEvent records the event for a payment's current status in
+// the outbox, with one delivery per subscribed endpoint of the payment's
+// merchant
+func (s *Service) PublishPaymentEvent(payment *domain.Payment) error {
+	var eventType domain.EventType
+	switch payment.Status {
+	case domain.StatusAuthorized:
+		eventType = domain.EventPaymentAuthorized
+	case domain.StatusDeclined:
+		eventType = domain.EventPaymentDeclined
+	case domain.StatusRejected:
+		eventType = domain.EventPaymentRejected
+	case domain.StatusCaptured:
+		eventType = domain.EventPaymentCaptured
+	case domain.StatusVoided:
+		eventType = domain.EventPaymentVoided
+	case domain.StatusRefunded:
+		eventType = domain.EventPaymentRefunded
+	default:
+		return nil
+	}
+
+	return s.Publish(eventType, payment)
+}
+
+// Publish records an event about payment in the outbox, for the endpoints
+// of the merchant that made it
+func (s *Service) Publish(eventType domain.EventType, payment *domain.Payment) error {
+	now := s.clock.Now()
+	event := &domain.Event{
+		ID:        "evt_" + uuid.New().String(),
+		Type:      eventType,
+		PaymentID: payment.ID,
+		CreatedAt: now,
+	}
+
+	payload, err := json.Marshal(eventPayload{
+		ID:        event.ID,
+		Type:      string(eventType),
+		CreatedAt: now,
+		Data: paymentData{
+			ID:                   payment.ID,
+			Status:               string(payment.Status),
+			CardNumberLastFour:   payment.Card.GetLastFourDigits(),
+			ExpiryMonth:          payment.Card.ExpiryMonth,
+			ExpiryYear:           payment.Card.ExpiryYear,
+			Currency:             payment.Currency,
+			Amount:               payment.Amount,
+			Initiator:            string(payment.Initiator),
+			NetworkTransactionID: payment.NetworkTransactionID,
+			CapturedAmount:       payment.CapturedAmount,
+			RefundedAmount:       payment.RefundedAmount,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	event.Payload = payload
+
+	endpoints, err := s.repository.ListEndpoints()
+	if err != nil {
+		return fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+
+	deliveries := make([]domain.WebhookDelivery, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.MerchantID != payment.MerchantID || !endpoint.Receives(eventType) {
+			continue
+		}
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			ID:            uuid.New().String(),
+			EventID:       event.ID,
+			EndpointID:    endpoint.ID,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if err := s.repository.Enqueue(event, deliveries); err != nil {
+		return fmt.Errorf("failed to enqueue event: %w", err)
+	}
+
+	return nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2026, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+// merchantCtx is a request from the merchant that makes testPayment
+var merchantCtx = merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-1"})
+
+func testPayment(status domain.PaymentStatus) *domain.Payment {
+	return &domain.Payment{
+		ID:         "payment-1",
+		MerchantID: "merchant-1",
+		Card: domain.Card{
+			Number:      "2222405343248877",
+			ExpiryMonth: 4,
+			ExpiryYear:  2030,
+			CVV:         "123",
+		},
+		Currency:  "GBP",
+		Amount:    100,
+		Status:    status,
+		Initiator: domain.InitiatorCustomer,
+	}
+}
+
+func newTestRepository(t *testing.T) *repository.WebhooksRepository {
+	provider, err := envelope.NewEphemeralKeyProvider()
+	require.NoError(t, err)
+	return repository.NewWebhooksRepository(envelope.New(provider))
+}
+
+func TestService_RegisterEndpoint(t *testing.T) {
+	svc := NewService(newTestRepository(t), WithClock(clock.NewFake(start)))
+
+	endpoint, err := svc.RegisterEndpoint(merchantCtx, "https://merchant.example.com/hooks", []domain.EventType{domain.EventPaymentAuthorized})
+	require.NoError(t, err)
+	assert.NotEmpty(t, endpoint.ID)
+	assert.Regexp(t, `^whsec_[0-9a-f]{64}$`, endpoint.Secret)
+	assert.Equal(t, start, endpoint.CreatedAt)
+
+	_, err = svc.RegisterEndpoint(merchantCtx, "ftp://merchant.example.com", nil)
+	assert.ErrorIs(t, err, domain.ErrWebhookURLInvalid)
+
+	_, err = svc.RegisterEndpoint(merchantCtx, "/relative", nil)
+	assert.ErrorIs(t, err, domain.ErrWebhookURLInvalid)
+
+	_, err = svc.RegisterEndpoint(merchantCtx, "https://merchant.example.com", []domain.EventType{"payment.exploded"})
+	assert.ErrorIs(t, err, domain.ErrEventTypeInvalid)
+}
+
+func TestService_PublishPaymentEvent(t *testing.T) {
+	repo := newTestRepository(t)
+	svc := NewService(repo, WithClock(clock.NewFake(start)))
+
+	all, err := svc.RegisterEndpoint(merchantCtx, "https://a.example.com", nil)
+	require.NoError(t, err)
+	declinesOnly, err := svc.RegisterEndpoint(merchantCtx, "https://b.example.com", []domain.EventType{domain.EventPaymentDeclined})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+
+	deliveries, err := svc.ListDeliveries(merchantCtx, all.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, domain.DeliveryPending, deliveries[0].Status)
+	assert.Equal(t, start, deliveries[0].NextAttemptAt)
+
+	none, err := svc.ListDeliveries(merchantCtx, declinesOnly.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	event, err := repo.FindEvent(deliveries[0].EventID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.EventPaymentAuthorized, event.Type)
+	assert.NotContains(t, string(event.Payload), "2222405343248877")
+	assert.NotContains(t, string(event.Payload), "123\"")
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(event.Payload, &payload))
+	assert.Equal(t, "payment.authorized", payload["type"])
+	data := payload["data"].(map[string]interface{})
+	assert.Equal(t, "payment-1", data["id"])
+	assert.Equal(t, "8877", data["card_number_last_four"])
+}
+
+func TestService_PublishPaymentEvent_Rejected(t *testing.T) {
+	repo := newTestRepository(t)
+	svc := NewService(repo)
+
+	endpoint, err := svc.RegisterEndpoint(merchantCtx, "https://a.example.com", []domain.EventType{domain.EventPaymentRejected})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.PublishPaymentEvent(testPayment(domain.StatusRejected)))
+
+	deliveries, err := svc.ListDeliveries(merchantCtx, endpoint.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	event, err := repo.FindEvent(deliveries[0].EventID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.EventPaymentRejected, event.Type)
+}
+
+func TestService_PublishPaymentEvent_IgnoresPending(t *testing.T) {
+	repo := newTestRepository(t)
+	svc := NewService(repo)
+
+	endpoint, err := svc.RegisterEndpoint(merchantCtx, "https://a.example.com", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.PublishPaymentEvent(testPayment(domain.StatusPending)))
+
+	deliveries, err := svc.ListDeliveries(merchantCtx, endpoint.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+}
+
+func TestService_Redeliver(t *testing.T) {
+	repo := newTestRepository(t)
+	fakeClock := clock.NewFake(start)
+	svc := NewService(repo, WithClock(fakeClock))
+
+	endpoint, err := svc.RegisterEndpoint(merchantCtx, "https://a.example.com", nil)
+	require.NoError(t, err)
+	require.NoError(t, svc.PublishPaymentEvent(testPayment(domain.StatusDeclined)))
+
+	deliveries, err := svc.ListDeliveries(merchantCtx, endpoint.ID, "")
+	require.NoError(t, err)
+	id := deliveries[0].ID
+
+	// Pending deliveries are already queued
+	_, err = svc.Redeliver(merchantCtx, id)
+	assert.ErrorIs(t, err, domain.ErrDeliveryPending)
+
+	dead := deliveries[0]
+	dead.Status = domain.DeliveryDead
+	dead.Failures = 5
+	dead.Attempts = []domain.DeliveryAttempt{{At: start, StatusCode: 500}}
+	require.NoError(t, repo.SaveDelivery(&dead))
+
+	fakeClock.Advance(time.Hour)
+	redelivered, err := svc.Redeliver(merchantCtx, id)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliveryPending, redelivered.Status)
+	assert.Equal(t, 0, redelivered.Failures)
+	assert.Equal(t, start.Add(time.Hour), redelivered.NextAttemptAt)
+	assert.Len(t, redelivered.Attempts, 1)
+
+	_, err = svc.Redeliver(merchantCtx, "missing")
+	assert.ErrorIs(t, err, domain.ErrDeliveryNotFound)
+}
+
+func TestService_MerchantScoped(t *testing.T) {
+	repo := newTestRepository(t)
+	svc := NewService(repo)
+	otherCtx := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-2"})
+
+	mine, err := svc.RegisterEndpoint(merchantCtx, "https://a.example.com", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "merchant-1", mine.MerchantID)
+	theirs, err := svc.RegisterEndpoint(otherCtx, "https://b.example.com", nil)
+	require.NoError(t, err)
+
+	// Only the payment's merchant is told about it
+	require.NoError(t, svc.PublishPaymentEvent(testPayment(domain.StatusAuthorized)))
+	deliveries, err := svc.ListDeliveries(merchantCtx, mine.ID, "")
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	none, err := svc.ListDeliveries(otherCtx, theirs.ID, "")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	// Another merchant's endpoints and deliveries are not found
+	_, err = svc.GetEndpoint(otherCtx, mine.ID)
+	assert.ErrorIs(t, err, domain.ErrWebhookEndpointNotFound)
+	_, err = svc.ListDeliveries(otherCtx, mine.ID, "")
+	assert.ErrorIs(t, err, domain.ErrWebhookEndpointNotFound)
+	_, err = svc.GetDelivery(otherCtx, deliveries[0].ID)
+	assert.ErrorIs(t, err, domain.ErrDeliveryNotFound)
+	_, err = svc.Redeliver(otherCtx, deliveries[0].ID)
+	assert.ErrorIs(t, err, domain.ErrDeliveryNotFound)
+	assert.ErrorIs(t, svc.DeleteEndpoint(otherCtx, mine.ID), domain.ErrWebhookEndpointNotFound)
+	_, err = svc.GetEndpoint(context.Background(), mine.ID)
+	assert.ErrorIs(t, err, domain.ErrWebhookEndpointNotFound)
+
+	// Operators see every merchant's
+	operator := NewService(repo, Unscoped())
+	_, err = operator.GetEndpoint(context.Background(), mine.ID)
+	assert.NoError(t, err)
+	_, err = operator.GetDelivery(context.Background(), deliveries[0].ID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	body := []byte(`{"id":"evt_1"}`)
+
+	header := Sign("whsec_test", now, body)
+	assert.Regexp(t, `^t=1800000000,v1=[0-9a-f]{64}$`, header)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", secret: "whsec_test", header: header, body: body, now: now},
+		{name: "within tolerance", secret: "whsec_test", header: header, body: body, now: now.Add(4 * time.Minute)},
+		{name: "tampered body", secret: "whsec_test", header: header, body: []byte(`{"id":"evt_2"}`), now: now, wantErr: ErrSignatureInvalid},
+		{name: "wrong secret", secret: "whsec_other", header: header, body: body, now: now, wantErr: ErrSignatureInvalid},
+		{name: "too old", secret: "whsec_test", header: header, body: body, now: now.Add(10 * time.Minute), wantErr: ErrSignatureExpired},
+		{name: "missing mac", secret: "whsec_test", header: "t=1800000000", body: body, now: now, wantErr: ErrSignatureMissing},
+		{name: "bad timestamp", secret: "whsec_test", header: "t=soon,v1=abc", body: body, now: now, wantErr: ErrSignatureMissing},
+		{name: "empty", secret: "whsec_test", header: "", body: body, now: now, wantErr: ErrSignatureMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tt.now, 5*time.Minute)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
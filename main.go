@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/cko-recruitment/payment-gateway-challenge-go/docs"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/logging"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tracing"
 )
 
 var (
@@ -26,13 +33,47 @@ var (
 //	@description	- **Authorized**: Payment was approved by the bank
 //	@description	- **Declined**: Payment was declined by the bank
 //	@description	- **Rejected**: Payment was rejected due to validation errors (never sent to bank)
+//	@description	- **Captured**: An authorized payment was taken from the card
+//	@description	- **Voided**: An authorized payment was released without being taken
+//	@description	- **Refunded**: A captured payment was given back, in full or in part
 //	@description
 //	@description	## Security
-//	@description	- Only the last 4 digits of card numbers are stored and returned
+//	@description	- Only the last 4 digits of card numbers are returned
 //	@description	- CVV is never stored, only sent to the bank
+//	@description	- Cards saved with POST /api/tokens are encrypted in the vault and only ever referenced by an opaque token
+//	@description	- Stored card numbers are envelope-encrypted with per-record keys and can be crypto-shredded
+//	@description	- Every change to a payment is kept in an append-only audit log, see GET /api/payments/{id}/events
+//	@description	- Request bodies are size-limited and fields the API does not know are rejected
+//	@description	- HTTPS with optional mutual TLS; merchants with a client certificate are identified by it
+//	@description	- Merchants authenticate with an API key in an `Authorization: Bearer` header; only its SHA-256 hash is configured
+//	@description	- Card tokens, plans, subscriptions, blocked cards and webhook endpoints need an API key, and each merchant only ever sees its own; payments and batches made without a key are only seen by callers without one
+//	@description
+//	@description	## Rate Limits
+//	@description	Requests to /api are rate limited per API key, or per IP address for callers without one, with limits configurable per merchant. Every limited response carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; a request over the limit gets 429 with Retry-After. Each merchant may also only have a limited number of payments waiting on the bank at once; further payments get 429 without reaching the bank.
+//	@description
+//	@description	## Load Shedding
+//	@description	The number of payments sent to the bank at once adapts to how quickly it answers. When the bank slows down, payments wait briefly for a slot and are otherwise turned away with 503 and Retry-After, without being sent to the bank, so they can be retried safely.
+//	@description
+//	@description	## Webhooks
+//	@description	Register an endpoint with POST /api/webhook-endpoints to receive events about your payments. Each delivery is signed with HMAC-SHA256 and retried with exponential backoff until it succeeds or is dead-lettered.
+//	@description
+//	@description	## Request IDs
+//	@description	Every response carries an X-Request-ID header, taken from the request or generated. It is included in error bodies and logs, stored on payments and sent to the bank. A merchant can also send an X-Correlation-ID of their own, which is stored and forwarded the same way.
+//	@description
+//	@description	## Monitoring
+//	@description	GET /metrics serves Prometheus metrics, including payment outcomes by status, currency and acquirer, bank latency and errors, and the state of the bank circuit breaker.
+//	@description
+//	@description	## Health
+//	@description	GET /health/live reports the process is up. GET /health/ready checks the repository, the bank and the configuration, and returns 503 when any is down. On shutdown readiness fails first, so load balancers drain traffic before the server stops listening.
+//	@description
+//	@description	## Tracing
+//	@description	Requests are traced with OpenTelemetry. Send a W3C traceparent header to join an existing trace; it is passed on to the acquiring bank. Spans carry the payment ID, status and currency, never card details.
+//	@description
+//	@description	## Logging
+//	@description	Logs are structured JSON. Card numbers, CVVs and API keys are masked before anything is written.
 //	@description
 //	@description	## Supported Currencies
-//	@description	USD, GBP, EUR
+//	@description	USD, GBP, EUR by default. The list is configurable.
 
 //	@contact.name	API Support
 //	@contact.url	https://github.com/cko-recruitment/payment-gateway-challenge-go
@@ -42,16 +83,81 @@ var (
 
 //	@schemes	http
 func main() {
-	fmt.Printf("version %s, commit %s, built at %s\n", version, commit, date)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := setupLogging(cfg.Logging); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	slog.Info("payment gateway starting", "version", version, "commit", commit, "built_at", date)
 	docs.SwaggerInfo.Version = version
 
-	err := run()
+	err = run(cfg)
 	if err != nil {
-		fmt.Printf("fatal API error: %v\n", err)
+		slog.Error("fatal API error", "error", err)
 	}
 }
 
-func run() error {
+// logLevel is shared by every logger so a reload can change it in place
+var logLevel = new(slog.LevelVar)
+
+// setupLogging makes a redacting JSON logger the default for the whole
+// process, so nothing logs card data by accident
+func setupLogging(cfg config.Logging) error {
+	logLevel.Set(cfg.SlogLevel())
+
+	logger, err := logging.New(os.Stdout, logging.Config{Level: logLevel, Format: cfg.Format})
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+	return nil
+}
+
+// reloadOnSIGHUP reads the configuration again on every SIGHUP and applies
+// the settings that can change while running. A configuration that fails to
+// load or validate is logged and the current settings are kept.
+func reloadOnSIGHUP(ctx context.Context, running *config.Config, gateway *api.Api) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		next, err := config.Load(os.Args[1:])
+		if err != nil {
+			slog.Error("config reload failed, keeping current settings", "error", err)
+			continue
+		}
+
+		if changed := running.RestartRequired(next); len(changed) > 0 {
+			slog.Warn("config changes need a restart to take effect", "sections", changed)
+		}
+
+		logLevel.Set(next.Logging.SlogLevel())
+		gateway.Reload(next)
+		// The next reload is compared with this one, so a section needing a
+		// restart is only reported when it changes
+		running = next
+		slog.Info("config reloaded")
+	}
+}
+
+func run(cfg *config.Config) error {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	go func() {
@@ -59,20 +165,42 @@ func run() error {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 		<-c
-		fmt.Printf("sigterm/interrupt signal\n")
+		slog.Info("sigterm/interrupt signal")
 		cancel()
 	}()
 
 	defer func() {
 		// recover after panic
 		if x := recover(); x != nil {
-			fmt.Printf("run time panic:\n%v\n", x)
+			slog.Error("run time panic", "panic", fmt.Sprint(x))
 			panic(x)
 		}
 	}()
 
-	api := api.New()
-	if err := api.Run(ctx, ":8090"); err != nil {
+	traceConfig := tracing.ConfigFromEnv()
+	traceConfig.ServiceVersion = version
+	shutdownTracing, err := tracing.Setup(ctx, traceConfig)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// The run context is cancelled by now, so flush with a fresh one
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	gateway, err := api.NewFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+	defer gateway.Close()
+
+	go reloadOnSIGHUP(ctx, cfg, gateway)
+
+	if err := gateway.Run(ctx); err != nil {
 		return err
 	}
 
@@ -0,0 +1,49 @@
+package gatewayclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// BlockCardRequest is a card to refuse payments from, given by its number or
+// a Source token
+type BlockCardRequest struct {
+	CardNumber string         `json:"card_number,omitempty"`
+	Source     *PaymentSource `json:"source,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+}
+
+// BlockedCard is a card the merchant no longer takes payments from.
+// Payments from it fail with ErrInvalidRequest.
+type BlockedCard struct {
+	ID                 string    `json:"id"`
+	CardNumberLastFour string    `json:"card_number_last_four"`
+	Reason             string    `json:"reason,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// BlockCard stops payments from a card. Blocking a card that is already
+// blocked returns the existing entry.
+func (c *Client) BlockCard(ctx context.Context, req *BlockCardRequest, opts ...CallOption) (*BlockedCard, error) {
+	var card BlockedCard
+	if err := c.do(ctx, http.MethodPost, "/api/blocked-cards", nil, req, &card, opts); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// ListBlockedCards returns the merchant's blocked cards, newest first
+func (c *Client) ListBlockedCards(ctx context.Context) ([]BlockedCard, error) {
+	var cards []BlockedCard
+	if err := c.do(ctx, http.MethodGet, "/api/blocked-cards", nil, nil, &cards, nil); err != nil {
+		return nil, err
+	}
+	return cards, nil
+}
+
+// UnblockCard takes payments from a blocked card again
+func (c *Client) UnblockCard(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/blocked-cards/"+url.PathEscape(id), nil, nil, nil, nil)
+}
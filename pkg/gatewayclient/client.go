@@ -0,0 +1,282 @@
+// Package gatewayclient is a Go client for the payment gateway API.
+//
+// Requests that create something are sent with an Idempotency-Key, generated
+// unless the caller gives one, and retried with the same key when the
+// gateway is unreachable or asks to be called again later, so a retry never
+// takes a payment twice. Failed calls return an *Error that matches the
+// sentinel errors below with errors.Is.
+package gatewayclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// IdempotencyKeyHeader carries the key that makes a retried request
+	// take effect once
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// ReplayedHeader is set by the gateway on responses repeated for a key
+	// it has already seen
+	ReplayedHeader = "Idempotent-Replayed"
+	// CorrelationIDHeader carries the merchant's own ID for a request
+	CorrelationIDHeader = "X-Correlation-ID"
+	// RequestIDHeader carries the gateway's ID for a request
+	RequestIDHeader = "X-Request-ID"
+)
+
+const (
+	defaultMaxAttempts = 3
+	defaultBackoff     = 250 * time.Millisecond
+	// maxRetryDelay bounds both backoff and Retry-After
+	maxRetryDelay = 30 * time.Second
+	// maxErrorBody bounds how much of an error response is read
+	maxErrorBody = 64 << 10
+)
+
+// Client calls the gateway API. It is safe for concurrent use.
+type Client struct {
+	baseURL     *url.URL
+	apiKey      string
+	httpClient  *http.Client
+	userAgent   string
+	maxAttempts int
+	backoff     time.Duration
+	newKey      func() string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token. Without one the client calls the
+// gateway anonymously.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithHTTPClient replaces http.DefaultClient, for example to set timeouts
+// or client certificates
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetry sets how many times a call is attempted in all and the delay
+// before the first retry, which doubles after each one. A Retry-After sent
+// by the gateway is used instead when present. maxAttempts of 1 disables
+// retries.
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxAttempts = max(maxAttempts, 1)
+		c.backoff = backoff
+	}
+}
+
+// WithUserAgent sets the User-Agent sent with each request
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New returns a client for the gateway at baseURL, such as
+// "https://gateway.example.com"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: must be an absolute http or https URL", baseURL)
+	}
+
+	c := &Client{
+		baseURL:     u,
+		httpClient:  http.DefaultClient,
+		userAgent:   "gatewayclient-go",
+		maxAttempts: defaultMaxAttempts,
+		backoff:     defaultBackoff,
+		newKey:      uuid.NewString,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// CallOption configures a single call
+type CallOption func(*callOptions)
+
+type callOptions struct {
+	idempotencyKey string
+	correlationID  string
+	respondAsync   bool
+}
+
+// IdempotencyKey sends key instead of a generated one, so a call repeated
+// after the process restarts is still recognised
+func IdempotencyKey(key string) CallOption {
+	return func(o *callOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// CorrelationID sends the merchant's own ID for the call, which the gateway
+// stores on the payment and passes to the bank
+func CorrelationID(id string) CallOption {
+	return func(o *callOptions) {
+		o.correlationID = id
+	}
+}
+
+// RespondAsync asks the gateway to queue a payment and return it as Pending
+// without waiting for the bank
+func RespondAsync() CallOption {
+	return func(o *callOptions) {
+		o.respondAsync = true
+	}
+}
+
+// do sends a request, retrying it while the gateway says it may be, and
+// decodes a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out any, opts []CallOption) error {
+	var o callOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	// The key is fixed before the first attempt, so every retry of the
+	// call is recognised as the same request
+	if method == http.MethodPost && o.idempotencyKey == "" {
+		o.idempotencyKey = c.newKey()
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	for attempt := 1; ; attempt++ {
+		delay, err := c.attempt(ctx, method, u.String(), body, out, o)
+		if delay < 0 || attempt >= c.maxAttempts {
+			return err
+		}
+		if delay == 0 {
+			delay = c.retryDelay(attempt)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request once. It returns how long to wait before
+// retrying it, zero to back off as usual, or a negative delay if it must
+// not be retried.
+func (c *Client) attempt(ctx context.Context, method, target string, body []byte, out any, o callOptions) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return -1, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if o.idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, o.idempotencyKey)
+	}
+	if o.correlationID != "" {
+		req.Header.Set(CorrelationIDHeader, o.correlationID)
+	}
+	if o.respondAsync {
+		req.Header.Set("Prefer", "respond-async")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, err
+		}
+		// The request may or may not have reached the gateway; only one
+		// carrying an idempotency key, or one that changes nothing, is safe
+		// to send again
+		if method != http.MethodGet && o.idempotencyKey == "" {
+			return -1, err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := decodeError(resp)
+		if !apiErr.retryable() {
+			return -1, apiErr
+		}
+		return apiErr.RetryAfter, apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return -1, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return -1, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return -1, nil
+}
+
+// retryDelay is the backoff before retry number attempt
+func (c *Client) retryDelay(attempt int) time.Duration {
+	if c.backoff <= 0 {
+		return 0
+	}
+	delay := c.backoff << (attempt - 1)
+	if delay <= 0 || delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+// parseRetryAfter reads a Retry-After given in seconds, the only form the
+// gateway sends
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+}
@@ -0,0 +1,462 @@
+package gatewayclient_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/repository"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/webhook"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewayclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIKey = "sk_test_merchant_1"
+
+// startGateway serves the gateway's real router, backed by the bank
+// simulator, with one merchant holding testAPIKey
+func startGateway(t *testing.T) (*api.Api, string) {
+	t.Helper()
+	bank := httptest.NewServer(banksim.New())
+	t.Cleanup(bank.Close)
+
+	cfg := config.Default()
+	cfg.Bank.URL = bank.URL
+	cfg.MasterKeys.Ephemeral = true
+	// Webhook receivers in these tests listen on loopback
+	cfg.Webhooks.AllowPrivateNetworks = true
+	cfg.Merchants = []config.Merchant{{
+		ID:           "merchant-1",
+		APIKeySHA256: []string{merchant.HashAPIKey(testAPIKey)},
+	}}
+	gateway, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(gateway.Router())
+	t.Cleanup(server.Close)
+	return gateway, server.URL
+}
+
+func newClient(t *testing.T, baseURL string, opts ...gatewayclient.Option) *gatewayclient.Client {
+	t.Helper()
+	c, err := gatewayclient.New(baseURL, append([]gatewayclient.Option{
+		gatewayclient.WithAPIKey(testAPIKey),
+		gatewayclient.WithRetry(3, 0),
+	}, opts...)...)
+	require.NoError(t, err)
+	return c
+}
+
+func paymentRequest(cardNumber string) *gatewayclient.PaymentRequest {
+	return &gatewayclient.PaymentRequest{
+		CardNumber:  cardNumber,
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	}
+}
+
+// recordingTransport records the idempotency key of each request. While
+// dropResponses is above zero it sends the request on but reports the
+// connection lost, as if the response never arrived.
+type recordingTransport struct {
+	mu            sync.Mutex
+	keys          []string
+	dropResponses int
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.keys = append(rt.keys, req.Header.Get(gatewayclient.IdempotencyKeyHeader))
+	drop := rt.dropResponses > 0
+	rt.dropResponses--
+	rt.mu.Unlock()
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || !drop {
+		return resp, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, errors.New("connection reset by peer")
+}
+
+func (rt *recordingTransport) sentKeys() []string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return append([]string(nil), rt.keys...)
+}
+
+func TestNew_InvalidBaseURL(t *testing.T) {
+	_, err := gatewayclient.New("gateway.example.com")
+	assert.Error(t, err)
+}
+
+func TestClient_CreateAndGetPayment(t *testing.T) {
+	_, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	created, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"), gatewayclient.CorrelationID("order-1"))
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusAuthorized, created.Status)
+	assert.Equal(t, "8877", created.CardNumberLastFour)
+	assert.Equal(t, "order-1", created.CorrelationID)
+
+	payment, err := c.GetPayment(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, payment.ID)
+	assert.Equal(t, gatewayclient.StatusAuthorized, payment.Status)
+	assert.Equal(t, "customer", payment.Initiator)
+
+	declined, err := c.CreatePayment(ctx, paymentRequest("2222405343248878"))
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusDeclined, declined.Status)
+}
+
+func TestClient_CaptureVoidAndRefund(t *testing.T) {
+	_, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	created, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+
+	captured, err := c.CapturePayment(ctx, created.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusCaptured, captured.Status)
+	assert.Equal(t, 100, captured.CapturedAmount)
+
+	refunded, err := c.RefundPayment(ctx, created.ID, 40)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusRefunded, refunded.Status)
+	assert.Equal(t, 40, refunded.RefundedAmount)
+
+	_, err = c.RefundPayment(ctx, created.ID, 61)
+	assert.ErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+	refunded, err = c.RefundPayment(ctx, created.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 100, refunded.RefundedAmount)
+
+	_, err = c.VoidPayment(ctx, created.ID)
+	assert.ErrorIs(t, err, gatewayclient.ErrConflict)
+
+	other, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+	voided, err := c.VoidPayment(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusVoided, voided.Status)
+
+	events, err := c.GetPaymentEvents(ctx, created.ID)
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{"payment.requested", "payment.authorized", "payment.captured", "payment.refunded", "payment.refunded"}, types)
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	_, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	req := paymentRequest("1234")
+	_, err := c.CreatePayment(ctx, req)
+	require.ErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+	var apiErr *gatewayclient.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	assert.Equal(t, "card number must be between 14-19 digits", apiErr.Message)
+	assert.NotEmpty(t, apiErr.RequestID)
+
+	_, err = c.GetPayment(ctx, "missing")
+	assert.ErrorIs(t, err, gatewayclient.ErrNotFound)
+	assert.NotErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+
+	unknown := newClient(t, baseURL, gatewayclient.WithAPIKey("sk_test_unknown"))
+	_, err = unknown.GetPayment(ctx, "missing")
+	assert.ErrorIs(t, err, gatewayclient.ErrUnauthorized)
+
+	_, err = c.CreatePayment(ctx, paymentRequest("2222405343248877"), gatewayclient.IdempotencyKey("order-2"))
+	require.NoError(t, err)
+	_, err = c.CreatePayment(ctx, paymentRequest("2222405343248879"), gatewayclient.IdempotencyKey("order-2"))
+	assert.ErrorIs(t, err, gatewayclient.ErrIdempotencyKeyReused)
+}
+
+func TestClient_RetryReusesIdempotencyKey(t *testing.T) {
+	_, baseURL := startGateway(t)
+	transport := &recordingTransport{dropResponses: 1}
+	c := newClient(t, baseURL, gatewayclient.WithHTTPClient(&http.Client{Transport: transport}))
+	ctx := context.Background()
+
+	payment, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+
+	keys := transport.sentKeys()
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1], "the retry is sent with the same key")
+
+	list, err := c.ListPayments(ctx, gatewayclient.ListPaymentsParams{})
+	require.NoError(t, err)
+	require.Len(t, list.Data, 1, "the payment was only taken once")
+	assert.Equal(t, payment.ID, list.Data[0].ID, "the retry got the first response")
+
+	_, err = c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+	assert.NotEqual(t, keys[0], transport.sentKeys()[2], "each call gets its own key")
+}
+
+func TestClient_RetriesUntilAttemptsRunOut(t *testing.T) {
+	_, baseURL := startGateway(t)
+	transport := &recordingTransport{}
+	c := newClient(t, baseURL, gatewayclient.WithHTTPClient(&http.Client{Transport: transport}))
+
+	// The bank simulator is unavailable for cards ending in 0
+	_, err := c.CreatePayment(context.Background(), paymentRequest("2222405343248870"))
+	assert.ErrorIs(t, err, gatewayclient.ErrBankUnavailable)
+
+	keys := transport.sentKeys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, keys[0], keys[2])
+}
+
+func TestClient_RetryHonoursRetryAfter(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error":"Rate limit exceeded"}`)
+			return
+		}
+		io.WriteString(w, `{"id":"payment-1","status":"Authorized"}`)
+	}))
+	defer server.Close()
+
+	payment, err := newClient(t, server.URL).GetPayment(context.Background(), "payment-1")
+	require.NoError(t, err)
+	assert.Equal(t, "payment-1", payment.ID)
+
+	require.Len(t, attempts, 2)
+	assert.GreaterOrEqual(t, attempts[1].Sub(attempts[0]), time.Second)
+}
+
+func TestClient_ErrorWithoutGatewayBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream timed out", http.StatusGatewayTimeout)
+	}))
+	defer server.Close()
+
+	_, err := newClient(t, server.URL, gatewayclient.WithRetry(1, 0)).GetPayment(context.Background(), "payment-1")
+	var apiErr *gatewayclient.Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusGatewayTimeout, apiErr.StatusCode)
+	assert.Equal(t, "Gateway Timeout", apiErr.Message)
+}
+
+func TestClient_PaymentsIterator(t *testing.T) {
+	_, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	var created []string
+	for _, card := range []string{"2222405343248877", "2222405343248878", "2222405343248877", "2222405343248878", "2222405343248877"} {
+		payment, err := c.CreatePayment(ctx, paymentRequest(card))
+		require.NoError(t, err)
+		created = append(created, payment.ID)
+	}
+
+	var listed []string
+	it := c.Payments(gatewayclient.ListPaymentsParams{Limit: 2})
+	for it.Next(ctx) {
+		listed = append(listed, it.Payment().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{created[4], created[3], created[2], created[1], created[0]}, listed, "newest first")
+
+	listed = nil
+	it = c.Payments(gatewayclient.ListPaymentsParams{Status: gatewayclient.StatusDeclined, Limit: 1})
+	for it.Next(ctx) {
+		listed = append(listed, it.Payment().ID)
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{created[3], created[1]}, listed)
+
+	it = c.Payments(gatewayclient.ListPaymentsParams{Limit: 1000})
+	assert.False(t, it.Next(ctx))
+	assert.ErrorIs(t, it.Err(), gatewayclient.ErrInvalidRequest)
+}
+
+func TestClient_BlockCard(t *testing.T) {
+	_, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	blocked, err := c.BlockCard(ctx, &gatewayclient.BlockCardRequest{CardNumber: "2222405343248877", Reason: "chargeback"})
+	require.NoError(t, err)
+	assert.Equal(t, "8877", blocked.CardNumberLastFour)
+
+	_, err = c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	assert.ErrorIs(t, err, gatewayclient.ErrInvalidRequest)
+
+	cards, err := c.ListBlockedCards(ctx)
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+	assert.Equal(t, blocked.ID, cards[0].ID)
+	assert.Equal(t, "chargeback", cards[0].Reason)
+
+	require.NoError(t, c.UnblockCard(ctx, blocked.ID))
+	assert.ErrorIs(t, c.UnblockCard(ctx, blocked.ID), gatewayclient.ErrNotFound)
+
+	payment, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+	assert.Equal(t, gatewayclient.StatusAuthorized, payment.Status)
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1","type":"payment.authorized"}`)
+	header := webhook.Sign("whsec_test", now, body)
+
+	assert.NoError(t, gatewayclient.VerifySignature("whsec_test", header, body, now.Add(time.Minute), gatewayclient.DefaultTolerance))
+	assert.ErrorIs(t, gatewayclient.VerifySignature("whsec_other", header, body, now, gatewayclient.DefaultTolerance), gatewayclient.ErrSignatureInvalid)
+	assert.ErrorIs(t, gatewayclient.VerifySignature("whsec_test", header, []byte(`{}`), now, gatewayclient.DefaultTolerance), gatewayclient.ErrSignatureInvalid)
+	assert.ErrorIs(t, gatewayclient.VerifySignature("whsec_test", header, body, now.Add(time.Hour), gatewayclient.DefaultTolerance), gatewayclient.ErrSignatureExpired)
+	assert.ErrorIs(t, gatewayclient.VerifySignature("whsec_test", "v1=abc", body, now, gatewayclient.DefaultTolerance), gatewayclient.ErrSignatureMissing)
+}
+
+// TestWebhookContract signs every event type the gateway publishes the way
+// its dispatcher does, and checks the SDK knows the type, verifies the
+// signature and decodes the event
+func TestWebhookContract(t *testing.T) {
+	known := map[string]bool{
+		gatewayclient.EventPaymentAuthorized: true,
+		gatewayclient.EventPaymentDeclined:   true,
+		gatewayclient.EventPaymentRejected:   true,
+		gatewayclient.EventPaymentCaptured:   true,
+		gatewayclient.EventPaymentVoided:     true,
+		gatewayclient.EventPaymentRefunded:   true,
+	}
+
+	ctx := merchant.WithMerchant(context.Background(), merchant.Merchant{ID: "merchant-1"})
+	payment := &domain.Payment{
+		ID:         "payment-1",
+		MerchantID: "merchant-1",
+		Card:       domain.Card{Number: "2222405343248877", ExpiryMonth: 4, ExpiryYear: 2030},
+		Currency:   "GBP",
+		Amount:     100,
+		Status:     domain.StatusAuthorized,
+	}
+
+	for _, eventType := range domain.WebhookEventTypes() {
+		t.Run(string(eventType), func(t *testing.T) {
+			assert.True(t, known[string(eventType)], "the SDK has no constant for %s", eventType)
+
+			provider, err := envelope.NewEphemeralKeyProvider()
+			require.NoError(t, err)
+			repo := repository.NewWebhooksRepository(envelope.New(provider))
+			svc := webhook.NewService(repo)
+			endpoint, err := svc.RegisterEndpoint(ctx, "https://merchant.example.com/hooks", nil)
+			require.NoError(t, err)
+
+			require.NoError(t, svc.Publish(eventType, payment))
+			deliveries, err := svc.ListDeliveries(ctx, endpoint.ID, "")
+			require.NoError(t, err)
+			require.Len(t, deliveries, 1)
+			event, err := repo.FindEvent(deliveries[0].EventID)
+			require.NoError(t, err)
+			body := event.Payload
+
+			now := time.Now()
+			header := webhook.Sign(endpoint.Secret, now, body)
+
+			parsed, err := gatewayclient.ParseWebhook(endpoint.Secret, header, body)
+			require.NoError(t, err)
+			assert.Equal(t, string(eventType), parsed.Type)
+			assert.Equal(t, event.ID, parsed.ID)
+			assert.Equal(t, "payment-1", parsed.Data.ID)
+			assert.Equal(t, "8877", parsed.Data.CardNumberLastFour)
+
+			tampered := bytes.Replace(body, []byte(`"amount":100`), []byte(`"amount":1`), 1)
+			require.NotEqual(t, body, tampered)
+			_, err = gatewayclient.ParseWebhook(endpoint.Secret, header, tampered)
+			assert.ErrorIs(t, err, gatewayclient.ErrSignatureInvalid)
+
+			_, err = gatewayclient.ParseWebhook("whsec_other", header, body)
+			assert.ErrorIs(t, err, gatewayclient.ErrSignatureInvalid)
+
+			expired := webhook.Sign(endpoint.Secret, now.Add(-gatewayclient.DefaultTolerance-time.Minute), body)
+			_, err = gatewayclient.ParseWebhook(endpoint.Secret, expired, body)
+			assert.ErrorIs(t, err, gatewayclient.ErrSignatureExpired)
+		})
+	}
+}
+
+func TestParseWebhook_Delivery(t *testing.T) {
+	gateway, baseURL := startGateway(t)
+	c := newClient(t, baseURL)
+	ctx := context.Background()
+
+	var (
+		mu     sync.Mutex
+		secret string
+		events []*gatewayclient.WebhookEvent
+		errs   []error
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		event, err := gatewayclient.ParseWebhook(secret, r.Header.Get(gatewayclient.SignatureHeader), body)
+		if err != nil {
+			errs = append(errs, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	endpoint, err := c.CreateWebhookEndpoint(ctx, receiver.URL, []string{gatewayclient.EventPaymentAuthorized})
+	require.NoError(t, err)
+	require.NotEmpty(t, endpoint.Secret)
+	mu.Lock()
+	secret = endpoint.Secret
+	mu.Unlock()
+
+	payment, err := c.CreatePayment(ctx, paymentRequest("2222405343248877"))
+	require.NoError(t, err)
+	require.Equal(t, 1, gateway.Dispatcher().RunOnce(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, errs)
+	require.Len(t, events, 1)
+	assert.Equal(t, gatewayclient.EventPaymentAuthorized, events[0].Type)
+	assert.Equal(t, payment.ID, events[0].Data.ID)
+	assert.Equal(t, gatewayclient.StatusAuthorized, events[0].Data.Status)
+}
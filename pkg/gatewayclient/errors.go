@@ -0,0 +1,121 @@
+package gatewayclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Sentinel errors an *Error matches with errors.Is, by status code
+var (
+	// ErrInvalidRequest means the gateway refused the request as invalid,
+	// for example a card number of the wrong length
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrUnauthorized means the API key is unknown
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound means the resource does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the resource is in a state that does not allow the
+	// call, or a request with the same idempotency key is still in progress
+	ErrConflict = errors.New("conflict")
+	// ErrTooLarge means the request body is over the gateway's limit
+	ErrTooLarge = errors.New("request too large")
+	// ErrIdempotencyKeyReused means the idempotency key was already used for
+	// a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused")
+	// ErrRateLimited means the caller sent too many requests, or has too
+	// many payments in progress
+	ErrRateLimited = errors.New("rate limited")
+	// ErrBankUnavailable means the gateway could not get an answer from the
+	// bank
+	ErrBankUnavailable = errors.New("bank unavailable")
+	// ErrUnavailable means the gateway cannot take the request right now
+	ErrUnavailable = errors.New("gateway unavailable")
+)
+
+// Error is a response from the gateway with an error status
+type Error struct {
+	StatusCode int
+	// Message is the gateway's explanation
+	Message string
+	// RequestID identifies the request in the gateway's logs
+	RequestID string
+	// RetryAfter is how long the gateway asked to wait before retrying, or
+	// zero if it did not say
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("gateway returned %d: %s (request %s)", e.StatusCode, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("gateway returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel error for the status code
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusRequestEntityTooLarge:
+		return target == ErrTooLarge
+	case http.StatusUnprocessableEntity:
+		return target == ErrIdempotencyKeyReused
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusBadGateway:
+		return target == ErrBankUnavailable
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return false
+}
+
+// retryable reports whether the same request may succeed if sent again.
+// Conflicts are only retried when the gateway says when to, which it does
+// for a request with the same idempotency key still in progress. Nor is
+// something the gateway does not implement.
+func (e *Error) retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusNotImplemented:
+		return false
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode >= http.StatusInternalServerError:
+		return true
+	case e.StatusCode == http.StatusConflict:
+		return e.RetryAfter > 0
+	}
+	return false
+}
+
+// decodeError reads the gateway's error body, falling back to the status
+// text for responses that did not come from the gateway itself
+func decodeError(resp *http.Response) *Error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get(RequestIDHeader),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	var body struct {
+		Error     string `json:"error"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&body); err == nil && body.Error != "" {
+		apiErr.Message = body.Error
+		if body.RequestID != "" {
+			apiErr.RequestID = body.RequestID
+		}
+	} else {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
@@ -0,0 +1,271 @@
+package gatewayclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Payment statuses
+const (
+	StatusAuthorized = "Authorized"
+	StatusDeclined   = "Declined"
+	StatusRejected   = "Rejected"
+	StatusPending    = "Pending"
+	StatusCaptured   = "Captured"
+	StatusVoided     = "Voided"
+	StatusRefunded   = "Refunded"
+)
+
+// PaymentRequest is a payment to process: either raw card details or a
+// Source token, the amount and, for merchant-initiated payments, the stored
+// credential it relies on
+type PaymentRequest struct {
+	CardNumber  string         `json:"card_number,omitempty"`
+	ExpiryMonth int            `json:"expiry_month,omitempty"`
+	ExpiryYear  int            `json:"expiry_year,omitempty"`
+	Source      *PaymentSource `json:"source,omitempty"`
+	Currency    string         `json:"currency"`
+	// Amount is in minor currency units, such as pence
+	Amount int    `json:"amount"`
+	CVV    string `json:"cvv,omitempty"`
+
+	// Initiator is customer, the default, or merchant
+	Initiator        string            `json:"initiator,omitempty"`
+	StoredCredential *StoredCredential `json:"stored_credential,omitempty"`
+}
+
+// PaymentSource is a stored card to charge instead of raw card details
+type PaymentSource struct {
+	Token string `json:"token"`
+}
+
+// StoredCredential describes the agreement a stored card is charged under
+type StoredCredential struct {
+	// Usage is first or subsequent
+	Usage string `json:"usage"`
+	// Type is recurring or unscheduled
+	Type                         string `json:"type"`
+	PreviousNetworkTransactionID string `json:"previous_network_transaction_id,omitempty"`
+}
+
+// Payment is a processed payment. Only the last four digits of its card are
+// ever returned.
+type Payment struct {
+	ID                 string `json:"id"`
+	Status             string `json:"status"`
+	CardNumberLastFour string `json:"card_number_last_four"`
+	ExpiryMonth        int    `json:"expiry_month"`
+	ExpiryYear         int    `json:"expiry_year"`
+	Currency           string `json:"currency"`
+	Amount             int    `json:"amount"`
+
+	Initiator            string `json:"initiator,omitempty"`
+	NetworkTransactionID string `json:"network_transaction_id,omitempty"`
+	DeclineReason        string `json:"decline_reason,omitempty"`
+	// CapturedAmount is how much of Amount was taken from the card, and
+	// RefundedAmount how much of that was given back
+	CapturedAmount int `json:"captured_amount,omitempty"`
+	RefundedAmount int `json:"refunded_amount,omitempty"`
+
+	RequestID     string `json:"request_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// PaymentEvent is one change to a payment, with who made it and from which
+// request
+type PaymentEvent struct {
+	ID         string           `json:"id"`
+	Sequence   int              `json:"sequence"`
+	Type       string           `json:"type"`
+	OccurredAt time.Time        `json:"occurred_at"`
+	Actor      string           `json:"actor"`
+	RequestID  string           `json:"request_id,omitempty"`
+	Data       PaymentEventData `json:"data"`
+}
+
+// PaymentEventData is what changed. Which fields are set depends on the
+// event type.
+type PaymentEventData struct {
+	CardToken          string            `json:"card_token,omitempty"`
+	CardNumberLastFour string            `json:"card_number_last_four,omitempty"`
+	ExpiryMonth        int               `json:"expiry_month,omitempty"`
+	ExpiryYear         int               `json:"expiry_year,omitempty"`
+	Currency           string            `json:"currency,omitempty"`
+	Amount             int               `json:"amount,omitempty"`
+	Initiator          string            `json:"initiator,omitempty"`
+	StoredCredential   *StoredCredential `json:"stored_credential,omitempty"`
+	CorrelationID      string            `json:"correlation_id,omitempty"`
+
+	Status               string `json:"status,omitempty"`
+	NetworkTransactionID string `json:"network_transaction_id,omitempty"`
+	DeclineReason        string `json:"decline_reason,omitempty"`
+	CapturedAmount       int    `json:"captured_amount,omitempty"`
+	RefundedAmount       int    `json:"refunded_amount,omitempty"`
+}
+
+// ListPaymentsParams selects a page of payments, newest first
+type ListPaymentsParams struct {
+	// Status, if set, only lists payments in that status
+	Status string
+	// StartingAfter is the ID of the last payment on the previous page
+	StartingAfter string
+	// Limit is the page size; the gateway's default is used when zero
+	Limit int
+}
+
+func (p ListPaymentsParams) values() url.Values {
+	v := url.Values{}
+	if p.Status != "" {
+		v.Set("status", p.Status)
+	}
+	if p.StartingAfter != "" {
+		v.Set("starting_after", p.StartingAfter)
+	}
+	if p.Limit > 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	return v
+}
+
+// PaymentList is one page of payments
+type PaymentList struct {
+	Data    []Payment `json:"data"`
+	HasMore bool      `json:"has_more"`
+	// NextStartingAfter is the StartingAfter for the next page, when there
+	// is one
+	NextStartingAfter string `json:"next_starting_after,omitempty"`
+}
+
+// CreatePayment sends a payment to the bank and returns the outcome. With
+// RespondAsync it returns the payment as Pending instead; get it again, or
+// subscribe to webhooks, for the outcome.
+func (c *Client) CreatePayment(ctx context.Context, req *PaymentRequest, opts ...CallOption) (*Payment, error) {
+	var payment Payment
+	if err := c.do(ctx, http.MethodPost, "/api/payments", nil, req, &payment, opts); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetPayment returns a payment by ID
+func (c *Client) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	var payment Payment
+	if err := c.do(ctx, http.MethodGet, "/api/payments/"+url.PathEscape(id), nil, nil, &payment, nil); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetPaymentEvents returns every change made to a payment, oldest first
+func (c *Client) GetPaymentEvents(ctx context.Context, id string) ([]PaymentEvent, error) {
+	var events []PaymentEvent
+	if err := c.do(ctx, http.MethodGet, "/api/payments/"+url.PathEscape(id)+"/events", nil, nil, &events, nil); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// CapturePayment takes amount of an Authorized payment from the card, or
+// the whole authorized amount if amount is 0
+func (c *Client) CapturePayment(ctx context.Context, id string, amount int, opts ...CallOption) (*Payment, error) {
+	return c.settle(ctx, id, "capture", &settleRequest{Amount: amount}, opts)
+}
+
+// VoidPayment releases an Authorized payment that has not been captured
+func (c *Client) VoidPayment(ctx context.Context, id string, opts ...CallOption) (*Payment, error) {
+	return c.settle(ctx, id, "void", nil, opts)
+}
+
+// RefundPayment gives amount of a Captured payment back to the card, or all
+// that has not been refunded yet if amount is 0. A payment can be refunded
+// in parts.
+func (c *Client) RefundPayment(ctx context.Context, id string, amount int, opts ...CallOption) (*Payment, error) {
+	return c.settle(ctx, id, "refund", &settleRequest{Amount: amount}, opts)
+}
+
+type settleRequest struct {
+	Amount int `json:"amount,omitempty"`
+}
+
+func (c *Client) settle(ctx context.Context, id, action string, req *settleRequest, opts []CallOption) (*Payment, error) {
+	var in any
+	if req != nil {
+		in = req
+	}
+	var payment Payment
+	if err := c.do(ctx, http.MethodPost, "/api/payments/"+url.PathEscape(id)+"/"+action, nil, in, &payment, opts); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ListPayments returns one page of payments. Payments walks every page.
+func (c *Client) ListPayments(ctx context.Context, params ListPaymentsParams) (*PaymentList, error) {
+	var list PaymentList
+	if err := c.do(ctx, http.MethodGet, "/api/payments", params.values(), nil, &list, nil); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Payments returns an iterator over every payment params selects, fetching
+// pages as it goes:
+//
+//	it := client.Payments(gatewayclient.ListPaymentsParams{Status: gatewayclient.StatusDeclined})
+//	for it.Next(ctx) {
+//		payment := it.Payment()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+func (c *Client) Payments(params ListPaymentsParams) *PaymentIterator {
+	return &PaymentIterator{client: c, params: params, more: true}
+}
+
+// PaymentIterator walks a list of payments page by page
+type PaymentIterator struct {
+	client  *Client
+	params  ListPaymentsParams
+	page    []Payment
+	current Payment
+	more    bool
+	err     error
+}
+
+// Next advances to the next payment, fetching the next page when needed.
+// It returns false when there are no more payments or a page could not be
+// fetched; Err says which.
+func (it *PaymentIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if !it.more || it.err != nil {
+			return false
+		}
+
+		list, err := it.client.ListPayments(ctx, it.params)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page = list.Data
+		it.more = list.HasMore && list.NextStartingAfter != ""
+		it.params.StartingAfter = list.NextStartingAfter
+	}
+
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Payment returns the payment Next advanced to
+func (it *PaymentIterator) Payment() Payment {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *PaymentIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,208 @@
+package gatewayclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries a webhook delivery's signature, in the form
+// "t=<unix seconds>,v1=<hex HMAC-SHA256>". The HMAC covers the timestamp, a
+// dot and the raw request body.
+const SignatureHeader = "Webhook-Signature"
+
+// DefaultTolerance is how far a delivery's timestamp may be from now for
+// ParseWebhook to accept it, so a captured delivery cannot be replayed later
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrSignatureMissing = errors.New("webhook signature is missing or malformed")
+	ErrSignatureInvalid = errors.New("webhook signature does not match")
+	ErrSignatureExpired = errors.New("webhook signature timestamp is outside the tolerance")
+)
+
+// Webhook event types
+const (
+	EventPaymentAuthorized = "payment.authorized"
+	EventPaymentDeclined   = "payment.declined"
+	EventPaymentRejected   = "payment.rejected"
+	EventPaymentCaptured   = "payment.captured"
+	EventPaymentVoided     = "payment.voided"
+	EventPaymentRefunded   = "payment.refunded"
+)
+
+// WebhookEvent is the body of a webhook delivery
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// Data is the payment as it was when the event happened
+	Data Payment `json:"data"`
+}
+
+// WebhookEndpoint is a URL the gateway delivers events to
+type WebhookEndpoint struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Secret signs the endpoint's deliveries. It is only returned when the
+	// endpoint is created.
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateWebhookEndpoint registers url to receive eventTypes, or every event
+// if none are given. Keep the returned Secret to verify deliveries with.
+func (c *Client) CreateWebhookEndpoint(ctx context.Context, url string, eventTypes []string, opts ...CallOption) (*WebhookEndpoint, error) {
+	req := struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types,omitempty"`
+	}{URL: url, EventTypes: eventTypes}
+
+	var endpoint WebhookEndpoint
+	if err := c.do(ctx, http.MethodPost, "/api/webhook-endpoints", nil, req, &endpoint, opts); err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// GetWebhookEndpoint returns an endpoint by ID, without its secret
+func (c *Client) GetWebhookEndpoint(ctx context.Context, id string) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	if err := c.do(ctx, http.MethodGet, "/api/webhook-endpoints/"+url.PathEscape(id), nil, nil, &endpoint, nil); err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// DeleteWebhookEndpoint stops deliveries to an endpoint
+func (c *Client) DeleteWebhookEndpoint(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/webhook-endpoints/"+url.PathEscape(id), nil, nil, nil, nil)
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// DeliveryDead means the delivery ran out of retries and is in the
+	// dead-letter queue
+	DeliveryDead = "dead"
+)
+
+// WebhookDelivery is one event being sent to one endpoint
+type WebhookDelivery struct {
+	ID            string            `json:"id"`
+	EventID       string            `json:"event_id"`
+	EndpointID    string            `json:"endpoint_id"`
+	Status        string            `json:"status"`
+	Failures      int               `json:"failures"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// DeliveryAttempt is one try at sending a delivery
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// ListWebhookDeliveries returns an endpoint's deliveries, newest first,
+// only those in status if it is not empty
+func (c *Client) ListWebhookDeliveries(ctx context.Context, endpointID, status string) ([]WebhookDelivery, error) {
+	query := url.Values{}
+	if status != "" {
+		query.Set("status", status)
+	}
+
+	var deliveries []WebhookDelivery
+	if err := c.do(ctx, http.MethodGet, "/api/webhook-endpoints/"+url.PathEscape(endpointID)+"/deliveries", query, nil, &deliveries, nil); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDelivery returns a delivery with every attempt made to send it
+func (c *Client) GetWebhookDelivery(ctx context.Context, id string) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := c.do(ctx, http.MethodGet, "/api/webhook-deliveries/"+url.PathEscape(id), nil, nil, &delivery, nil); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// RedeliverWebhook queues a dead or already delivered event to be sent
+// again straight away. A delivery still queued is an ErrConflict.
+func (c *Client) RedeliverWebhook(ctx context.Context, id string) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := c.do(ctx, http.MethodPost, "/api/webhook-deliveries/"+url.PathEscape(id)+"/redeliver", nil, nil, &delivery, nil); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ParseWebhook verifies a delivery's SignatureHeader against its raw body,
+// allowing DefaultTolerance of clock difference, and decodes the event.
+// Pass the body exactly as received; re-encoding it breaks the signature.
+func ParseWebhook(secret, header string, body []byte) (*WebhookEvent, error) {
+	if err := VerifySignature(secret, header, body, time.Now(), DefaultTolerance); err != nil {
+		return nil, err
+	}
+
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook event: %w", err)
+	}
+	return &event, nil
+}
+
+// VerifySignature checks a SignatureHeader value against body. Signatures
+// older or newer than tolerance relative to now are rejected.
+func VerifySignature(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, mac string
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			mac = v
+		}
+	}
+	if ts == "" || mac == "" {
+		return ErrSignatureMissing
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrSignatureMissing
+	}
+
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	if !hmac.Equal([]byte(mac), []byte(hex.EncodeToString(h.Sum(nil)))) {
+		return ErrSignatureInvalid
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAdapterFlow points the gateway at an acquirer with its own JSON
+// format, described entirely in configuration
+func TestAdapterFlow(t *testing.T) {
+	var received map[string]any
+	acquirer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v2/authorizations", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"result":{"code":"00","approval":"A1B2C3"}}`))
+	}))
+	defer acquirer.Close()
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = acquirer.URL
+	cfg.Bank.Adapter = client.AdapterMapped
+	cfg.Bank.Mapping = config.FieldMapping{
+		Path: "/v2/authorizations",
+		Request: config.RequestMapping{
+			CardNumber:   "card.pan",
+			ExpiryDate:   "card.expiry",
+			ExpiryFormat: "MM/YY",
+			CVV:          "card.cvc",
+			Currency:     "amount.currency",
+			Amount:       "amount.value",
+			AmountFormat: client.AmountString,
+		},
+		Response: config.ResponseMapping{
+			Authorized:        "result.code",
+			ApprovedValue:     "00",
+			AuthorizationCode: "result.approval",
+		},
+	}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t))))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status":"Authorized"`)
+
+	assert.Equal(t, map[string]any{
+		"card":   map[string]any{"pan": "2222405343248877", "expiry": fmt.Sprintf("04/%02d", (time.Now().Year()+1)%100), "cvc": "123"},
+		"amount": map[string]any{"currency": "GBP", "value": "100"},
+	}, received)
+}
+
+func TestAdapterFlow_UnknownAdapter(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.Adapter = "iso8583"
+
+	_, err := api.NewFromConfig(cfg)
+	assert.ErrorIs(t, err, client.ErrUnknownAdapter)
+}
@@ -0,0 +1,206 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAsyncPaymentFlow queues a payment with Prefer: respond-async, restarts
+// the gateway before any worker has sent it, and checks the restarted
+// gateway sends it, stores the outcome and announces it by webhook
+func TestAsyncPaymentFlow(t *testing.T) {
+	// The queued card details must be readable after the restart
+	key, err := envelope.GenerateKey()
+	require.NoError(t, err)
+	t.Setenv("GATEWAY_MASTER_KEY_V1", base64.StdEncoding.EncodeToString(key))
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event struct {
+			Type string `json:"type"`
+			Data struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"data"`
+		}
+		json.Unmarshal(body, &event)
+
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event.Type+" "+event.Data.ID+" "+event.Data.Status)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: filepath.Join(t.TempDir(), "data")}
+	// The receiver listens on loopback
+	cfg.Webhooks.AllowPrivateNetworks = true
+
+	first, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, doJSON(t, first, http.MethodPost, "/api/webhook-endpoints",
+		models.PostWebhookEndpointRequest{URL: receiver.URL}, nil))
+
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	first.Router().ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "respond-async", w.Header().Get("Preference-Applied"))
+	var queued models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&queued))
+	assert.Equal(t, "Pending", queued.Status)
+	assert.Equal(t, "/api/payments/"+queued.ID, w.Header().Get("Location"))
+
+	// Restart before the first gateway's workers ever ran
+	_, err = api.NewFromConfig(cfg)
+	assert.ErrorContains(t, err, "in use by another process")
+	require.NoError(t, first.Close())
+	second, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	defer second.Close()
+
+	var pending models.GetPaymentResponse
+	require.Equal(t, http.StatusOK, doJSON(t, second, http.MethodGet, "/api/payments/"+queued.ID, nil, &pending))
+	assert.Equal(t, "Pending", pending.Status, "restored from the queue")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- second.RunPaymentWorkers(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	var processed models.GetPaymentResponse
+	require.Eventually(t, func() bool {
+		doJSON(t, second, http.MethodGet, "/api/payments/"+queued.ID, nil, &processed)
+		return processed.Status != "Pending"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Authorized", processed.Status)
+	assert.Equal(t, queued.CardNumberLastFour, processed.CardNumberLastFour)
+
+	var history []models.PaymentEventResponse
+	require.Equal(t, http.StatusOK, doJSON(t, second, http.MethodGet, "/api/payments/"+queued.ID+"/events", nil, &history))
+	var types []string
+	for _, e := range history {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{"payment.requested", "payment.pending", "payment.authorized"}, types)
+
+	assert.Equal(t, 1, second.Dispatcher().RunOnce(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"payment.authorized " + queued.ID + " Authorized"}, events)
+}
+
+// TestAsyncPaymentFlow_QueueFull turns payments away once the queue is at
+// its depth and no worker is taking them
+func TestAsyncPaymentFlow_QueueFull(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Async.QueueDepth = 1
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	post := func() *httptest.ResponseRecorder {
+		req := newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t)))
+		req.Header.Set("Prefer", "respond-async")
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusAccepted, post().Code)
+	w := post()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+// TestAsyncPaymentFlow_Rejected gives up on a queued payment the bank could
+// not be reached for and checks the rejection is announced by webhook
+func TestAsyncPaymentFlow_Rejected(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event struct {
+			Type string `json:"type"`
+		}
+		json.NewDecoder(r.Body).Decode(&event)
+
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event.Type)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	bank := httptest.NewServer(http.NotFoundHandler())
+	bank.Close()
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = bank.URL
+	cfg.Async.MaxAttempts = 1
+	cfg.Webhooks.AllowPrivateNetworks = true
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, doJSON(t, testAPI, http.MethodPost, "/api/webhook-endpoints",
+		models.PostWebhookEndpointRequest{URL: receiver.URL}, nil))
+
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t)))
+	req.Header.Set("Prefer", "respond-async")
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var queued models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&queued))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- testAPI.RunPaymentWorkers(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	var processed models.GetPaymentResponse
+	require.Eventually(t, func() bool {
+		doJSON(t, testAPI, http.MethodGet, "/api/payments/"+queued.ID, nil, &processed)
+		return processed.Status != "Pending"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Rejected", processed.Status)
+
+	assert.Equal(t, 1, testAPI.Dispatcher().RunOnce(context.Background()))
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"payment.rejected"}, events)
+}
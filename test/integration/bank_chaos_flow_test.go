@@ -0,0 +1,237 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chaosBank serves sim, counting the payments that reach it
+func chaosBank(t *testing.T, sim *banksim.Simulator) (string, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/payments" {
+			calls.Add(1)
+		}
+		sim.ServeHTTP(w, r)
+	}))
+	t.Cleanup(bank.Close)
+	return bank.URL, &calls
+}
+
+func chaosPayment() *domain.Payment {
+	return &domain.Payment{
+		Card: domain.Card{
+			Number:      "2222405343248877", // Ends in 7 (odd) - will be authorized
+			ExpiryMonth: 4,
+			ExpiryYear:  time.Now().Year() + 1,
+			CVV:         "123",
+		},
+		Currency: "GBP",
+		Amount:   100,
+	}
+}
+
+// TestBankChaosFlow runs the bank client against each kind of misbehaving
+// bank and checks how it times out, retries and classifies the failure.
+// Only failures where the bank cannot have processed the payment may be
+// retried.
+func TestBankChaosFlow(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	retry := client.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	ms := func(n int) banksim.Duration { return banksim.Duration(time.Duration(n) * time.Millisecond) }
+
+	tests := []struct {
+		name          string
+		scenario      banksim.Scenario
+		expectedErr   error
+		expectedClass string
+		expectedCalls int32
+	}{
+		{
+			name:          "latency within the timeout",
+			scenario:      banksim.Scenario{Latency: banksim.Latency{Distribution: banksim.Normal, Mean: ms(50), StdDev: ms(20), Max: ms(100)}},
+			expectedClass: "none",
+			expectedCalls: 1,
+		},
+		{
+			name:          "latency beyond the timeout is not retried",
+			scenario:      banksim.Scenario{Latency: banksim.Latency{Distribution: banksim.Uniform, Min: ms(400), Max: ms(500)}},
+			expectedErr:   context.DeadlineExceeded,
+			expectedClass: "timeout",
+			expectedCalls: 1,
+		},
+		{
+			name:          "503 is retried",
+			scenario:      banksim.Scenario{Errors: banksim.Errors{Rate: 1, Statuses: []int{http.StatusServiceUnavailable}}},
+			expectedErr:   client.ErrBankUnavailable,
+			expectedClass: "unavailable",
+			expectedCalls: 3,
+		},
+		{
+			name:          "other 5xx is not retried",
+			scenario:      banksim.Scenario{Errors: banksim.Errors{Rate: 1, Statuses: []int{http.StatusInternalServerError}}},
+			expectedErr:   client.ErrBankUnexpectedResponse,
+			expectedClass: "unexpected_response",
+			expectedCalls: 1,
+		},
+		{
+			name:          "connection reset mid-response is not retried",
+			scenario:      banksim.Scenario{Resets: banksim.Fault{Rate: 1}},
+			expectedClass: "connection",
+			expectedCalls: 1,
+		},
+		{
+			name:          "malformed JSON",
+			scenario:      banksim.Scenario{Malformed: banksim.Fault{Rate: 1}},
+			expectedErr:   client.ErrBankUnexpectedResponse,
+			expectedClass: "unexpected_response",
+			expectedCalls: 1,
+		},
+		{
+			name:          "slow drip within the timeout",
+			scenario:      banksim.Scenario{SlowDrip: banksim.SlowDrip{Rate: 1, Interval: ms(5), ChunkBytes: 16}},
+			expectedClass: "none",
+			expectedCalls: 1,
+		},
+		{
+			name:          "slow drip beyond the timeout",
+			scenario:      banksim.Scenario{SlowDrip: banksim.SlowDrip{Rate: 1, Interval: ms(10), ChunkBytes: 1}},
+			expectedErr:   context.DeadlineExceeded,
+			expectedClass: "timeout",
+			expectedCalls: 1,
+		},
+		{
+			name:          "outage answering 503 is retried",
+			scenario:      banksim.Scenario{Outages: []banksim.Outage{{Mode: banksim.Unavailable}}},
+			expectedErr:   client.ErrBankUnavailable,
+			expectedClass: "unavailable",
+			expectedCalls: 3,
+		},
+		{
+			name:          "outage resetting connections is not retried",
+			scenario:      banksim.Scenario{Outages: []banksim.Outage{{Mode: banksim.Reset}}},
+			expectedClass: "connection",
+			expectedCalls: 1,
+		},
+		{
+			name:          "outage that never answers times out",
+			scenario:      banksim.Scenario{Outages: []banksim.Outage{{Mode: banksim.Hang}}},
+			expectedErr:   context.DeadlineExceeded,
+			expectedClass: "timeout",
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := banksim.New()
+			tt.scenario.Name = "chaos"
+			require.NoError(t, sim.Define(tt.scenario))
+			require.NoError(t, sim.Activate("chaos"))
+			url, calls := chaosBank(t, sim)
+
+			bank := client.NewHTTPBankClient(url, client.WithTimeout(timeout), client.WithRetryPolicy(retry))
+			start := time.Now()
+			resp, err := bank.ProcessPayment(context.Background(), chaosPayment())
+			took := time.Since(start)
+
+			assert.Equal(t, tt.expectedClass, client.ErrorClass(err), "error: %v", err)
+			if tt.expectedClass == "none" {
+				require.NotNil(t, resp)
+				assert.True(t, resp.Authorized)
+			} else {
+				assert.Nil(t, resp)
+			}
+			if tt.expectedErr != nil {
+				assert.True(t, errors.Is(err, tt.expectedErr), "expected %v, got %v", tt.expectedErr, err)
+			}
+			assert.Equal(t, tt.expectedCalls, calls.Load())
+			assert.Less(t, took, time.Duration(tt.expectedCalls)*timeout+time.Second, "every attempt must respect the timeout")
+		})
+	}
+}
+
+// TestBankChaosFlow_OutageWindow checks payments fail only while a timed
+// outage lasts
+func TestBankChaosFlow_OutageWindow(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	sim := banksim.New(banksim.WithClock(fakeClock))
+	require.NoError(t, sim.Define(banksim.Scenario{
+		Name: "window",
+		Outages: []banksim.Outage{{
+			Start:    banksim.Duration(time.Minute),
+			Duration: banksim.Duration(30 * time.Second),
+			Every:    banksim.Duration(2 * time.Minute),
+		}},
+	}))
+	require.NoError(t, sim.Activate("window"))
+	url, _ := chaosBank(t, sim)
+	bank := client.NewHTTPBankClient(url, client.WithTimeout(time.Second))
+
+	for _, step := range []struct {
+		advance       time.Duration
+		expectedClass string
+	}{
+		{0, "none"},
+		{time.Minute, "unavailable"},
+		{29 * time.Second, "unavailable"},
+		{time.Second, "none"},
+		{90 * time.Second, "unavailable"},
+	} {
+		fakeClock.Advance(step.advance)
+		_, err := bank.ProcessPayment(context.Background(), chaosPayment())
+		assert.Equal(t, step.expectedClass, client.ErrorClass(err), "at %s", fakeClock.Now().Format(time.TimeOnly))
+	}
+}
+
+// TestBankChaosFlow_ControlAPI switches the bank's scenario while the
+// gateway is running and checks how each failure reaches the merchant
+func TestBankChaosFlow_ControlAPI(t *testing.T) {
+	url, _ := chaosBank(t, banksim.New())
+	testAPI := apitest.NewWithBankURL(url)
+
+	control := func(method, path, body string) {
+		t.Helper()
+		req, err := http.NewRequest(method, url+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, method+" "+path)
+	}
+	pay := func() int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t))))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, pay())
+
+	control(http.MethodPut, "/_simulator/scenarios/broken", `{"errors":{"rate":1,"statuses":[500]}}`)
+	control(http.MethodPut, "/_simulator/scenarios/garbled", `{"malformed":{"rate":1}}`)
+
+	for _, scenario := range []string{"broken", "garbled"} {
+		control(http.MethodPut, "/_simulator/active", `{"name":"`+scenario+`"}`)
+		assert.Equal(t, http.StatusBadGateway, pay(), scenario)
+	}
+
+	control(http.MethodPost, "/_simulator/reset", "")
+	assert.Equal(t, http.StatusOK, pay())
+}
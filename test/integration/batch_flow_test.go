@@ -0,0 +1,121 @@
+package integration
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBatchFlow uploads a CSV of payments larger than the usual body limit,
+// waits for the bank to answer them all and downloads the results
+func TestBatchFlow(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Server.MaxBodyBytes = 128
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	year := time.Now().Year() + 1
+	upload := "card_number,expiry_month,expiry_year,currency,amount,cvv\n" +
+		fmt.Sprintf("2222405343248877,4,%d,GBP,100,123\n", year) + // authorized
+		fmt.Sprintf("2222405343248878,4,%d,GBP,200,123\n", year) + // declined
+		fmt.Sprintf("2222405343248877,4,%d,GBP,300,12\n", year) + // invalid CVV
+		fmt.Sprintf("2222405343248870,4,%d,GBP,400,123\n", year) // bank unavailable
+	require.Greater(t, len(upload), 128)
+
+	req := newRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(upload))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var accepted models.PaymentBatchResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&accepted))
+	assert.Equal(t, "/api/payment-batches/"+accepted.ID, w.Header().Get("Location"))
+	assert.Equal(t, 4, accepted.Counts.Total)
+
+	var batch models.PaymentBatchResponse
+	require.Eventually(t, func() bool {
+		doJSON(t, testAPI, http.MethodGet, "/api/payment-batches/"+accepted.ID, nil, &batch)
+		return batch.Status == "completed"
+	}, 10*time.Second, 20*time.Millisecond)
+	assert.Equal(t, models.PaymentBatchCounts{Total: 4, Authorized: 1, Declined: 1, Failed: 2}, batch.Counts)
+	assert.NotNil(t, batch.CompletedAt)
+
+	w = httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodGet, "/api/payment-batches/"+accepted.ID+"/results", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var results []models.PaymentBatchItemResponse
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var result models.PaymentBatchItemResponse
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+		results = append(results, result)
+	}
+	require.Len(t, results, 4)
+	assert.Equal(t, "Authorized", results[0].PaymentStatus)
+	assert.Equal(t, "Declined", results[1].PaymentStatus)
+	assert.Equal(t, "CVV must be 3-4 digits", results[2].Error)
+	assert.Equal(t, "Unable to process payment with bank", results[3].Error)
+
+	// Each payment made is a payment like any other
+	var payment models.GetPaymentResponse
+	require.Equal(t, http.StatusOK, doJSON(t, testAPI, http.MethodGet, "/api/payments/"+results[0].PaymentID, nil, &payment))
+	assert.Equal(t, "Authorized", payment.Status)
+	assert.Equal(t, 100, payment.Amount)
+
+	w = httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodGet, "/api/payment-batches/"+accepted.ID+"/results?format=csv", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "index,status,payment_id,payment_status,decline_reason,error", lines[0])
+	assert.Equal(t, "2,failed,,,,CVV must be 3-4 digits", lines[3])
+
+	assert.Equal(t, http.StatusConflict, doJSON(t, testAPI, http.MethodPost, "/api/payment-batches/"+accepted.ID+"/cancel", nil, nil))
+
+	// Only the batch upload gets the larger limit
+	w = httptest.NewRecorder()
+	padded := strings.Repeat(" ", len(upload)) + string(paymentRequestBody(t))
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", strings.NewReader(padded)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+// TestBatchFlow_Limits turns away uploads with more payments or bytes than
+// the batch limits allow
+func TestBatchFlow_Limits(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Batches.MaxItems = 2
+	cfg.Batches.MaxBodyBytes = 1024
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	post := func(body string) int {
+		req := newRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	line := string(paymentRequestBody(t)) + "\n"
+	assert.Equal(t, http.StatusBadRequest, post(""))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.Repeat(line, 3)), "too many payments")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(strings.Repeat(" ", 2048)+line), "too many bytes")
+	assert.Equal(t, http.StatusAccepted, post(strings.Repeat(line, 2)))
+}
@@ -0,0 +1,107 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/domain"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postPayment(t *testing.T, testAPI *api.Api, currency string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    currency,
+		Amount:      100,
+		CVV:         "123",
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body)))
+	return w
+}
+
+// TestConfigFlow_Reload checks the settings that can change at runtime take
+// effect without rebuilding the Api
+func TestConfigFlow_Reload(t *testing.T) {
+	t.Cleanup(func() { domain.SetSupportedCurrencies(domain.DefaultCurrencies) })
+
+	// The bank is unavailable on every other call
+	var calls atomic.Int32
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = bank.URL
+	cfg.Bank.Retry.MaxAttempts = 1
+	cfg.Currencies = []string{"JPY"}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, postPayment(t, testAPI, "GBP").Code)
+	assert.Equal(t, http.StatusBadGateway, postPayment(t, testAPI, "JPY").Code)
+
+	reloaded := config.Default()
+	reloaded.Merchants = []config.Merchant{testMerchant()}
+	reloaded.Bank.URL = bank.URL
+	reloaded.Bank.Retry = config.Retry{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	reloaded.Currencies = []string{"GBP"}
+	testAPI.Reload(reloaded)
+
+	// The first attempt gets a 503 and the retry is authorized
+	calls.Store(0)
+	w := postPayment(t, testAPI, "GBP")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, http.StatusBadRequest, postPayment(t, testAPI, "JPY").Code)
+}
+
+func TestConfigFlow_FileStorage(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+
+	cfg := config.Default()
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: dir}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	defer testAPI.Close()
+
+	_, err = os.Stat(filepath.Join(dir, "webhooks.journal"))
+	assert.NoError(t, err)
+}
+
+func TestConfigFlow_MasterKeyRequired(t *testing.T) {
+	// Restored by t.Setenv once the test ends
+	t.Setenv("GATEWAY_MASTER_KEY_V1", "")
+	os.Unsetenv("GATEWAY_MASTER_KEY_V1")
+
+	_, err := api.NewFromConfig(config.Default())
+	assert.ErrorIs(t, err, envelope.ErrNoMasterKey)
+
+	cfg := config.Default()
+	cfg.MasterKeys.Ephemeral = true
+	_, err = api.NewFromConfig(cfg)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,287 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/pkg/gatewaypb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+func dialGRPC(t *testing.T, addr string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func withAPIKey(ctx context.Context, apiKey string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+apiKey)
+}
+
+// TestGRPCFlow_MatchesREST makes the same calls over both APIs of a running
+// gateway and checks each fails with the gRPC code matching the REST status
+func TestGRPCFlow_MatchesREST(t *testing.T) {
+	const apiKey = "sk_test_grpc"
+	cfg := config.Default()
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Merchants = []config.Merchant{merchantConfig("merchant-1", apiKey, config.RateLimit{}, 0)}
+	addr := runServer(t, cfg)
+	payments := gatewaypb.NewPaymentsClient(dialGRPC(t, cfg.GRPC.Addr))
+	ctx := withAPIKey(context.Background(), apiKey)
+
+	rest := func(method, path, key string, body any) int {
+		var reader io.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			require.NoError(t, err)
+			reader = bytes.NewReader(b)
+		}
+		req, err := http.NewRequest(method, "http://"+addr+path, reader)
+		require.NoError(t, err)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	payment := func(cardNumber string, expiryYear int) (*models.PostPaymentRequest, *gatewaypb.CreatePaymentRequest) {
+		return &models.PostPaymentRequest{CardNumber: cardNumber, ExpiryMonth: 4, ExpiryYear: expiryYear, Currency: "GBP", Amount: 100, CVV: "123"},
+			&gatewaypb.CreatePaymentRequest{CardNumber: cardNumber, ExpiryMonth: 4, ExpiryYear: int32(expiryYear), Currency: "GBP", Amount: 100, Cvv: "123"}
+	}
+	nextYear := time.Now().Year() + 1
+
+	tests := []struct {
+		name       string
+		restStatus int
+		grpcCode   codes.Code
+		rest       func() int
+		grpc       func() error
+	}{
+		{
+			name:       "authorized",
+			restStatus: http.StatusOK,
+			grpcCode:   codes.OK,
+			rest: func() int {
+				body, _ := payment("2222405343248877", nextYear)
+				return rest(http.MethodPost, "/api/payments", apiKey, body)
+			},
+			grpc: func() error {
+				_, req := payment("2222405343248877", nextYear)
+				p, err := payments.CreatePayment(ctx, req)
+				if err == nil {
+					assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED, p.GetStatus())
+					assert.Equal(t, "8877", p.GetCardNumberLastFour())
+				}
+				return err
+			},
+		},
+		{
+			name:       "expired card",
+			restStatus: http.StatusBadRequest,
+			grpcCode:   codes.InvalidArgument,
+			rest: func() int {
+				body, _ := payment("2222405343248877", 2020)
+				return rest(http.MethodPost, "/api/payments", apiKey, body)
+			},
+			grpc: func() error {
+				_, req := payment("2222405343248877", 2020)
+				_, err := payments.CreatePayment(ctx, req)
+				return err
+			},
+		},
+		{
+			name:       "bank unavailable",
+			restStatus: http.StatusBadGateway,
+			grpcCode:   codes.Unavailable,
+			rest: func() int {
+				body, _ := payment("2222405343248870", nextYear)
+				return rest(http.MethodPost, "/api/payments", apiKey, body)
+			},
+			grpc: func() error {
+				_, req := payment("2222405343248870", nextYear)
+				_, err := payments.CreatePayment(ctx, req)
+				return err
+			},
+		},
+		{
+			name:       "unknown payment",
+			restStatus: http.StatusNotFound,
+			grpcCode:   codes.NotFound,
+			rest:       func() int { return rest(http.MethodGet, "/api/payments/missing", apiKey, nil) },
+			grpc: func() error {
+				_, err := payments.GetPayment(ctx, &gatewaypb.GetPaymentRequest{Id: "missing"})
+				return err
+			},
+		},
+		{
+			name:       "page too large",
+			restStatus: http.StatusBadRequest,
+			grpcCode:   codes.InvalidArgument,
+			rest:       func() int { return rest(http.MethodGet, "/api/payments?limit=101", apiKey, nil) },
+			grpc: func() error {
+				_, err := payments.ListPayments(ctx, &gatewaypb.ListPaymentsRequest{Limit: 101})
+				return err
+			},
+		},
+		{
+			name:       "unknown API key",
+			restStatus: http.StatusUnauthorized,
+			grpcCode:   codes.Unauthenticated,
+			rest:       func() int { return rest(http.MethodGet, "/api/payments", "sk_unknown", nil) },
+			grpc: func() error {
+				_, err := payments.ListPayments(withAPIKey(context.Background(), "sk_unknown"), &gatewaypb.ListPaymentsRequest{})
+				return err
+			},
+		},
+		{
+			name:       "no API key",
+			restStatus: http.StatusOK,
+			grpcCode:   codes.OK,
+			rest:       func() int { return rest(http.MethodGet, "/api/payments", "", nil) },
+			grpc: func() error {
+				_, err := payments.ListPayments(context.Background(), &gatewaypb.ListPaymentsRequest{})
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.restStatus, tt.rest())
+			assert.Equal(t, tt.grpcCode, status.Code(tt.grpc()))
+		})
+	}
+
+	// Both APIs store payments in the same place
+	list, err := payments.ListPayments(ctx, &gatewaypb.ListPaymentsRequest{Status: gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED})
+	require.NoError(t, err)
+	assert.Len(t, list.GetPayments(), 2)
+}
+
+// TestGRPCFlow_RequestIDs checks a call is attributed to the merchant and
+// carries its IDs like a REST request
+func TestGRPCFlow_RequestIDs(t *testing.T) {
+	const apiKey = "sk_test_grpc"
+	cfg := config.Default()
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Merchants = []config.Merchant{merchantConfig("merchant-1", apiKey, config.RateLimit{}, 0)}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	payments := gatewaypb.NewPaymentsClient(dialGRPC(t, serveGRPC(t, testAPI)))
+
+	ctx := metadata.AppendToOutgoingContext(withAPIKey(context.Background(), apiKey),
+		"x-request-id", "req-1", "x-correlation-id", "order-1234")
+	var header metadata.MD
+	p, err := payments.CreatePayment(ctx, &gatewaypb.CreatePaymentRequest{
+		CardNumber: "2222405343248877", ExpiryMonth: 4, ExpiryYear: int32(time.Now().Year() + 1),
+		Currency: "GBP", Amount: 100, Cvv: "123",
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"req-1"}, header.Get("x-request-id"))
+	assert.Equal(t, []string{"order-1234"}, header.Get("x-correlation-id"))
+	assert.Equal(t, "req-1", p.GetRequestId())
+	assert.Equal(t, "order-1234", p.GetCorrelationId())
+
+	w := serve(testAPI, http.MethodGet, "/api/payments/"+p.GetId()+"/events", apiKey, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var events []models.PaymentEventResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&events))
+	require.NotEmpty(t, events)
+	assert.Equal(t, "api_client:merchant:merchant-1", events[0].Actor)
+}
+
+// TestGRPCFlow_WatchPayment watches a payment accepted as pending until a
+// worker has sent it to the bank
+func TestGRPCFlow_WatchPayment(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+	payments := gatewaypb.NewPaymentsClient(dialGRPC(t, serveGRPC(t, testAPI)))
+	ctx, cancel := context.WithTimeout(withAPIKey(context.Background(), apitest.APIKey), 10*time.Second)
+	defer cancel()
+
+	created, err := payments.CreatePayment(ctx, &gatewaypb.CreatePaymentRequest{
+		CardNumber: "2222405343248877", ExpiryMonth: 4, ExpiryYear: int32(time.Now().Year() + 1),
+		Currency: "GBP", Amount: 100, Cvv: "123", RespondAsync: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING, created.GetStatus())
+
+	stream, err := payments.WatchPayment(ctx, &gatewaypb.WatchPaymentRequest{Id: created.GetId()})
+	require.NoError(t, err)
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_PENDING, first.GetStatus())
+
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+	go testAPI.RunPaymentWorkers(workersCtx)
+
+	next, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, created.GetId(), next.GetId())
+	assert.Equal(t, gatewaypb.PaymentStatus_PAYMENT_STATUS_AUTHORIZED, next.GetStatus())
+
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err, "the stream ends once the status is final")
+}
+
+// TestGRPCFlow_HealthAndReflection checks the standard services are served
+// without an API key, like the REST API's probes
+func TestGRPCFlow_HealthAndReflection(t *testing.T) {
+	conn := dialGRPC(t, serveGRPC(t, apitest.New()))
+	ctx := context.Background()
+
+	for _, service := range []string{"", "gateway.v1.Payments"} {
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+
+	stream, err := grpc_reflection_v1.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&grpc_reflection_v1.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1.ServerReflectionRequest_ListServices{},
+	}))
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+
+	var services []string
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.GetName())
+	}
+	assert.Contains(t, services, "gateway.v1.Payments")
+	assert.Contains(t, services, "grpc.health.v1.Health")
+}
+
+// serveGRPC serves the gateway's gRPC API on a free port until the test
+// ends and returns the address
+func serveGRPC(t *testing.T, testAPI *api.Api) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go testAPI.GRPCServer().Serve(listener)
+	t.Cleanup(testAPI.GRPCServer().Stop)
+	return listener.Addr().String()
+}
@@ -0,0 +1,137 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func readiness(t *testing.T, handler http.Handler) (int, models.HealthResponse) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+	var resp models.HealthResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	return w.Code, resp
+}
+
+func TestHealthFlow_Ready(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer bank.Close()
+
+	testAPI := apitest.NewWithBankURL(bank.URL)
+
+	code, resp := readiness(t, testAPI.Router())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "up", resp.Status)
+	for _, name := range []string{"bank", "config", "repository"} {
+		assert.Equal(t, "up", resp.Components[name].Status, name)
+	}
+
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealthFlow_BankUnreachable(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	bank.Close()
+
+	testAPI := apitest.NewWithBankURL(bank.URL)
+
+	code, resp := readiness(t, testAPI.Router())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "down", resp.Status)
+	assert.Equal(t, "down", resp.Components["bank"].Status)
+	assert.Contains(t, resp.Components["bank"].Error, "bank unreachable")
+	assert.Equal(t, "up", resp.Components["repository"].Status)
+
+	// Liveness does not depend on the bank
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+// TestHealthFlow_DrainsBeforeShutdown checks readiness, and the gRPC health
+// service, fail while the servers are still accepting requests once
+// shutdown starts
+func TestHealthFlow_DrainsBeforeShutdown(t *testing.T) {
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer bank.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	cfg := config.Default()
+	cfg.Server.Addr = addr
+	cfg.GRPC.Addr = freeAddr(t)
+	cfg.Server.DrainDelay = 500 * time.Millisecond
+	cfg.Bank.URL = bank.URL
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- testAPI.Run(ctx) }()
+
+	ready := func() (int, models.HealthResponse) {
+		resp, err := http.Get("http://" + addr + "/health/ready")
+		if err != nil {
+			return 0, models.HealthResponse{}
+		}
+		defer resp.Body.Close()
+		var body models.HealthResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	conn, err := grpc.NewClient(cfg.GRPC.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	grpcHealth := func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		if err != nil {
+			return grpc_health_v1.HealthCheckResponse_UNKNOWN
+		}
+		return resp.GetStatus()
+	}
+
+	require.Eventually(t, func() bool {
+		code, _ := ready()
+		return code == http.StatusOK && grpcHealth() == grpc_health_v1.HealthCheckResponse_SERVING
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	require.Eventually(t, func() bool {
+		code, body := ready()
+		return code == http.StatusServiceUnavailable && body.Status == "draining" &&
+			grpcHealth() == grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond, "readiness should fail while the servers still listen")
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down after draining")
+	}
+
+	_, err = http.Get("http://" + addr + "/health/ready")
+	assert.Error(t, err, "server should have stopped listening")
+}
@@ -0,0 +1,78 @@
+package integration
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/iso8583"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestISO8583Flow sends payments to an acquirer that speaks ISO 8583 over
+// TCP. Cards ending in 2 lack the funds.
+func TestISO8583Flow(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host := iso8583.NewHost(iso8583.WithDecider(func(req *iso8583.Message) iso8583.Decision {
+		if strings.HasSuffix(req.Get(iso8583.FieldPAN), "2") {
+			return iso8583.Decision{ResponseCode: iso8583.ResponseInsufficientFunds}
+		}
+		return iso8583.DefaultDecider(req)
+	}))
+	go host.Serve(l)
+	t.Cleanup(func() { host.Close() })
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.Protocol = config.ProtocolISO8583
+	cfg.Bank.ISO8583.Addr = l.Addr().String()
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	code, health := readiness(t, testAPI.Router())
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "up", health.Components["bank"].Status, "answers echoes")
+
+	tests := []struct {
+		card           string
+		expectedStatus string
+		expectedReason string
+	}{
+		{card: "2222405343248877", expectedStatus: "Authorized"},
+		{card: "2222405343248112", expectedStatus: "Declined", expectedReason: "insufficient_funds"},
+		{card: "2222405343248878", expectedStatus: "Declined", expectedReason: "do_not_honour"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.card, func(t *testing.T) {
+			var created models.PostPaymentResponse
+			require.Equal(t, http.StatusOK, doJSON(t, testAPI, http.MethodPost, "/api/payments", models.PostPaymentRequest{
+				CardNumber:  tt.card,
+				ExpiryMonth: 4,
+				ExpiryYear:  time.Now().Year() + 1,
+				Currency:    "GBP",
+				Amount:      100,
+				CVV:         "123",
+			}, &created))
+			assert.Equal(t, tt.expectedStatus, created.Status)
+			assert.Equal(t, tt.expectedReason, created.DeclineReason)
+
+			var fetched models.GetPaymentResponse
+			require.Equal(t, http.StatusOK, doJSON(t, testAPI, http.MethodGet, "/api/payments/"+created.ID, nil, &fetched))
+			assert.Equal(t, tt.expectedReason, fetched.DeclineReason)
+		})
+	}
+
+	var mtis []string
+	for _, msg := range host.Received() {
+		mtis = append(mtis, msg.MTI)
+	}
+	assert.Equal(t, []string{"0800", "0100", "0100", "0100"}, mtis)
+}
@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoadSheddingFlow_SlowBank sends a burst of payments to a gateway whose
+// bank has slowed down. The gateway should keep the calls waiting on the
+// bank within its limit, cut the limit, and turn the rest away quickly with
+// a 503 rather than leave them all waiting out the bank timeout.
+func TestLoadSheddingFlow_SlowBank(t *testing.T) {
+	const bankDelay = 300 * time.Millisecond
+
+	var inFlight, peak atomic.Int32
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+
+		time.Sleep(bankDelay)
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = bank.URL
+	cfg.Bank.Concurrency = config.Concurrency{
+		InitialLimit:     4,
+		MinLimit:         2,
+		MaxLimit:         8,
+		LatencyThreshold: 100 * time.Millisecond,
+		BackoffRatio:     0.5,
+		MaxQueueLength:   8,
+		MaxQueueWait:     200 * time.Millisecond,
+	}
+	// Only the bank limit is under test here
+	cfg.RateLimits = config.RateLimits{}
+	addr := runServer(t, cfg)
+
+	const requests = 60
+	type result struct {
+		status     int
+		retryAfter string
+		took       time.Duration
+	}
+	results := make(chan result, requests)
+	body := paymentRequestBody(t)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sent := time.Now()
+			req := newRequest(http.MethodPost, "http://"+addr+"/api/payments", bytes.NewReader(body))
+			req.RequestURI = ""
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			results <- result{status: resp.StatusCode, retryAfter: resp.Header.Get("Retry-After"), took: time.Since(sent)}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	elapsed := time.Since(start)
+
+	counts := make(map[int]int)
+	for r := range results {
+		counts[r.status]++
+		if r.status == http.StatusServiceUnavailable {
+			assert.Equal(t, "1", r.retryAfter)
+			assert.Less(t, r.took, 2*time.Second, "shed requests should be answered quickly")
+		}
+	}
+	t.Logf("%d requests in %s: %v, peak bank concurrency %d", requests, elapsed, counts, peak.Load())
+
+	assert.Equal(t, requests, counts[http.StatusOK]+counts[http.StatusServiceUnavailable], "only successes and sheds are expected")
+	assert.NotZero(t, counts[http.StatusOK])
+	assert.NotZero(t, counts[http.StatusServiceUnavailable])
+	assert.LessOrEqual(t, peak.Load(), int32(4), "calls in flight must not exceed the limit")
+	assert.Less(t, elapsed, 5*time.Second, "the burst must not wait out the bank timeout")
+
+	// The limit came down and the shedding shows in the metrics
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	metrics, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, string(metrics), "gateway_bank_concurrency_limit 2")
+	assert.Regexp(t, `gateway_bank_requests_shed_total [1-9]`, string(metrics))
+	assert.Contains(t, string(metrics), "gateway_bank_requests_queued 0")
+}
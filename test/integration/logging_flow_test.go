@@ -0,0 +1,130 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of background
+// workers
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestLoggingFlow sends a full card number down every path that logs, then
+// checks it never reached the log output
+func TestLoggingFlow(t *testing.T) {
+	const pan = "2222405343248877"
+	const declinedPAN = "2222405343248878"
+	const rejectedPAN = "4111111111111111"
+
+	var logs syncBuffer
+	logger, err := logging.New(&logs, logging.Config{Level: slog.LevelDebug})
+	require.NoError(t, err)
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	// The bank echoes the request back in its errors, as some acquirers do
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case bytes.Contains(body, []byte(rejectedPAN)):
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write(body)
+		case bytes.Contains(body, []byte(declinedPAN)):
+			json.NewEncoder(w).Encode(client.BankResponse{Authorized: false})
+		default:
+			json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+		}
+	}))
+	defer bank.Close()
+
+	testAPI := apitest.NewWithBankURL(bank.URL)
+	futureYear := time.Now().Year() + 1
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, newRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+	payment := func(cardNumber string) string {
+		body, _ := json.Marshal(map[string]any{
+			"card_number":  cardNumber,
+			"expiry_month": 4,
+			"expiry_year":  futureYear,
+			"currency":     "GBP",
+			"amount":       100,
+			"cvv":          "123",
+		})
+		return string(body)
+	}
+
+	authorized := send(http.MethodPost, "/api/payments", payment(pan))
+	require.Equal(t, http.StatusOK, authorized.Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/api/payments", payment(declinedPAN)).Code)
+	assert.Equal(t, http.StatusBadGateway, send(http.MethodPost, "/api/payments", payment(rejectedPAN)).Code)
+
+	// Validation failures and malformed bodies
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/api/payments", `{"card_number":"`+pan+`","currency":"XXX"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/api/payments", `{"card_number":"`+pan+`",`).Code)
+
+	// Saving a card in the vault and paths and queries with a PAN in them
+	send(http.MethodPost, "/api/tokens", payment(pan))
+	send(http.MethodGet, "/api/payments/"+pan, "")
+	send(http.MethodGet, "/api/payments?card_number="+pan+"&cvv=123", "")
+
+	out := logs.String()
+	require.NotEmpty(t, out)
+	for _, number := range []string{pan, declinedPAN, rejectedPAN} {
+		assert.NotContains(t, out, number)
+	}
+	assert.NotContains(t, out, `\"cvv\":\"123\"`)
+	assert.NotContains(t, out, "cvv=123")
+
+	// The logs are still useful: one JSON object per line, with request and
+	// payment IDs
+	var processed map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		if entry["msg"] == "payment processed" && processed == nil {
+			processed = entry
+		}
+	}
+	require.NotNil(t, processed)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.NewDecoder(authorized.Body).Decode(&resp))
+	assert.Equal(t, resp.ID, processed["payment_id"])
+	assert.NotEmpty(t, processed["request_id"])
+	assert.Contains(t, out, `"msg":"bank call failed"`)
+}
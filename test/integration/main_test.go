@@ -0,0 +1,23 @@
+package integration
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/envelope"
+)
+
+// TestMain gives every gateway the tests start a master key, which the
+// gateway refuses to start without
+func TestMain(m *testing.M) {
+	key, err := envelope.GenerateKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("GATEWAY_MASTER_KEY_V1", base64.StdEncoding.EncodeToString(key))
+
+	os.Exit(m.Run())
+}
@@ -3,20 +3,44 @@ package integration
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/banksim"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
 	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// startBankSimulator serves the bank simulator for the length of the test
+// and returns its URL
+func startBankSimulator(t *testing.T, opts ...banksim.Option) string {
+	t.Helper()
+	bank := httptest.NewServer(banksim.New(opts...))
+	t.Cleanup(bank.Close)
+	return bank.URL
+}
+
+// newRequest builds a request with the API key of the merchant
+// apitest.NewWithBankURL configures, which the payment routes require
+func newRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer "+apitest.APIKey)
+	return req
+}
+
 // TestPaymentFlow_Authorized tests the full payment flow with a card ending in odd number (authorized)
 func TestPaymentFlow_Authorized(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 
 	futureYear := time.Now().Year() + 1
 
@@ -31,7 +55,7 @@ func TestPaymentFlow_Authorized(t *testing.T) {
 	}
 
 	body, _ := json.Marshal(reqBody)
-	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
 	w := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(w, req)
@@ -52,7 +76,7 @@ func TestPaymentFlow_Authorized(t *testing.T) {
 
 	// Step 2: Retrieve the payment by ID
 	paymentID := postResp.ID
-	getReq := httptest.NewRequest(http.MethodGet, "/api/payments/"+paymentID, nil)
+	getReq := newRequest(http.MethodGet, "/api/payments/"+paymentID, nil)
 	getW := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(getW, getReq)
@@ -74,7 +98,7 @@ func TestPaymentFlow_Authorized(t *testing.T) {
 
 // TestPaymentFlow_Declined tests the full payment flow with a card ending in even number (declined)
 func TestPaymentFlow_Declined(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 	futureYear := time.Now().Year() + 1
 
 	reqBody := models.PostPaymentRequest{
@@ -87,7 +111,7 @@ func TestPaymentFlow_Declined(t *testing.T) {
 	}
 
 	body, _ := json.Marshal(reqBody)
-	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
 	w := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(w, req)
@@ -103,7 +127,7 @@ func TestPaymentFlow_Declined(t *testing.T) {
 	assert.Equal(t, "8878", postResp.CardNumberLastFour)
 
 	// Retrieve the declined payment
-	getReq := httptest.NewRequest(http.MethodGet, "/api/payments/"+postResp.ID, nil)
+	getReq := newRequest(http.MethodGet, "/api/payments/"+postResp.ID, nil)
 	getW := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(getW, getReq)
@@ -119,7 +143,7 @@ func TestPaymentFlow_Declined(t *testing.T) {
 
 // TestPaymentFlow_BankUnavailable tests when bank returns 503
 func TestPaymentFlow_BankUnavailable(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 	futureYear := time.Now().Year() + 1
 
 	reqBody := models.PostPaymentRequest{
@@ -132,7 +156,7 @@ func TestPaymentFlow_BankUnavailable(t *testing.T) {
 	}
 
 	body, _ := json.Marshal(reqBody)
-	req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
 	w := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(w, req)
@@ -148,7 +172,7 @@ func TestPaymentFlow_BankUnavailable(t *testing.T) {
 
 // TestPaymentFlow_ValidationErrors tests various validation scenarios
 func TestPaymentFlow_ValidationErrors(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 	futureYear := time.Now().Year() + 1
 
 	tests := []struct {
@@ -233,7 +257,7 @@ func TestPaymentFlow_ValidationErrors(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			body, _ := json.Marshal(tt.request)
-			req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+			req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
 			w := httptest.NewRecorder()
 
 			testAPI.Router().ServeHTTP(w, req)
@@ -251,9 +275,9 @@ func TestPaymentFlow_ValidationErrors(t *testing.T) {
 
 // TestPaymentFlow_GetNonExistent tests retrieving a non-existent payment
 func TestPaymentFlow_GetNonExistent(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 
-	req := httptest.NewRequest(http.MethodGet, "/api/payments/non-existent-id", nil)
+	req := newRequest(http.MethodGet, "/api/payments/non-existent-id", nil)
 	w := httptest.NewRecorder()
 
 	testAPI.Router().ServeHTTP(w, req)
@@ -267,9 +291,104 @@ func TestPaymentFlow_GetNonExistent(t *testing.T) {
 	assert.Equal(t, "Payment not found", errResp.Error)
 }
 
+// TestPaymentFlow_MerchantScoped checks a merchant only ever sees its own
+// payments and batches, and that callers without an API key only see those
+// made without one
+func TestPaymentFlow_MerchantScoped(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Merchants = []config.Merchant{
+		merchantConfig("merchant-a", "gw_test_a", config.RateLimit{}, 0),
+		merchantConfig("merchant-b", "gw_test_b", config.RateLimit{}, 0),
+	}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	w := serve(testAPI, http.MethodPost, "/api/payments", "gw_test_a", paymentRequestBody(t))
+	require.Equal(t, http.StatusOK, w.Code)
+	var created models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+
+	assert.Equal(t, http.StatusOK, serve(testAPI, http.MethodGet, "/api/payments/"+created.ID, "gw_test_a", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/"+created.ID, "gw_test_b", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/"+created.ID+"/events", "gw_test_b", nil).Code)
+
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/"+created.ID, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/"+created.ID+"/events", "", nil).Code)
+
+	w = serve(testAPI, http.MethodPost, "/api/payments", "", paymentRequestBody(t))
+	require.Equal(t, http.StatusOK, w.Code)
+	var anonymous models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&anonymous))
+	assert.Equal(t, http.StatusOK, serve(testAPI, http.MethodGet, "/api/payments/"+anonymous.ID, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/"+anonymous.ID, "gw_test_a", nil).Code)
+
+	for key, want := range map[string]string{"gw_test_a": created.ID, "gw_test_b": "", "": anonymous.ID} {
+		w := serve(testAPI, http.MethodGet, "/api/payments", key, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var page models.PaymentListResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		if want == "" {
+			assert.Empty(t, page.Data, key)
+			continue
+		}
+		require.Len(t, page.Data, 1, key)
+		assert.Equal(t, want, page.Data[0].ID, key)
+	}
+
+	// Batches are scoped the same way
+	upload := fmt.Sprintf("card_number,expiry_month,expiry_year,currency,amount,cvv\n2222405343248877,4,%d,GBP,100,123\n", time.Now().Year()+1)
+	req := httptest.NewRequest(http.MethodPost, "/api/payment-batches", strings.NewReader(upload))
+	req.Header.Set("Content-Type", "text/csv")
+	w = httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var batch models.PaymentBatchResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&batch))
+	assert.Equal(t, http.StatusOK, serve(testAPI, http.MethodGet, "/api/payment-batches/"+batch.ID, "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payment-batches/"+batch.ID, "gw_test_a", nil).Code)
+}
+
+// TestPaymentFlow_TokenMerchantScoped checks a vault token can only be paid
+// with and deleted by the merchant that created it
+func TestPaymentFlow_TokenMerchantScoped(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Merchants = []config.Merchant{
+		merchantConfig("merchant-a", "gw_test_a", config.RateLimit{}, 0),
+		merchantConfig("merchant-b", "gw_test_b", config.RateLimit{}, 0),
+	}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	tokenBody, _ := json.Marshal(models.PostTokenRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	})
+	w := serve(testAPI, http.MethodPost, "/api/tokens", "gw_test_a", tokenBody)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var token models.PostTokenResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&token))
+
+	payBody, _ := json.Marshal(models.PostPaymentRequest{
+		Source:   &models.PaymentSource{Token: token.Token},
+		Currency: "GBP",
+		Amount:   100,
+		CVV:      "123",
+	})
+	w = serve(testAPI, http.MethodPost, "/api/payments", "gw_test_b", payBody)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "card token not found")
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodDelete, "/api/tokens/"+token.Token, "gw_test_b", nil).Code)
+
+	assert.Equal(t, http.StatusOK, serve(testAPI, http.MethodPost, "/api/payments", "gw_test_a", payBody).Code)
+	assert.Equal(t, http.StatusNoContent, serve(testAPI, http.MethodDelete, "/api/tokens/"+token.Token, "gw_test_a", nil).Code)
+}
+
 // TestPaymentFlow_MultipleCurrencies tests payments with different supported currencies
 func TestPaymentFlow_MultipleCurrencies(t *testing.T) {
-	testAPI := api.New()
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
 	futureYear := time.Now().Year() + 1
 
 	currencies := []string{"USD", "GBP", "EUR"}
@@ -286,7 +405,7 @@ func TestPaymentFlow_MultipleCurrencies(t *testing.T) {
 			}
 
 			body, _ := json.Marshal(reqBody)
-			req := httptest.NewRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+			req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
 			w := httptest.NewRecorder()
 
 			testAPI.Router().ServeHTTP(w, req)
@@ -301,3 +420,299 @@ func TestPaymentFlow_MultipleCurrencies(t *testing.T) {
 		})
 	}
 }
+
+// TestPaymentFlow_Token tests paying with a card saved in the vault
+func TestPaymentFlow_Token(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+	futureYear := time.Now().Year() + 1
+
+	// Step 1: Save the card in the vault
+	tokenBody, _ := json.Marshal(models.PostTokenRequest{
+		CardNumber:  "2222405343248877", // Ends in 7 (odd) - will be authorized
+		ExpiryMonth: 4,
+		ExpiryYear:  futureYear,
+	})
+	tokenReq := newRequest(http.MethodPost, "/api/tokens", bytes.NewBuffer(tokenBody))
+	tokenW := httptest.NewRecorder()
+
+	testAPI.Router().ServeHTTP(tokenW, tokenReq)
+
+	require.Equal(t, http.StatusCreated, tokenW.Code)
+	assert.NotContains(t, tokenW.Body.String(), "2222405343248877")
+
+	var tokenResp models.PostTokenResponse
+	err := json.NewDecoder(tokenW.Body).Decode(&tokenResp)
+	require.NoError(t, err)
+	assert.Equal(t, "8877", tokenResp.CardNumberLastFour)
+
+	// Step 2: Pay with the token; the vault supplies the card number to the bank
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		Source:   &models.PaymentSource{Token: tokenResp.Token},
+		Currency: "GBP",
+		Amount:   100,
+		CVV:      "123",
+	})
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	testAPI.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var postResp models.PostPaymentResponse
+	err = json.NewDecoder(w.Body).Decode(&postResp)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Authorized", postResp.Status)
+	assert.Equal(t, "8877", postResp.CardNumberLastFour)
+	assert.Equal(t, 4, postResp.ExpiryMonth)
+	assert.Equal(t, futureYear, postResp.ExpiryYear)
+
+	// Step 3: Unknown tokens are rejected without calling the bank
+	body, _ = json.Marshal(models.PostPaymentRequest{
+		Source:   &models.PaymentSource{Token: "tok_unknown"},
+		Currency: "GBP",
+		Amount:   100,
+		CVV:      "123",
+	})
+	req = newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+
+	testAPI.Router().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// TestPaymentFlow_MerchantInitiated tests a recurring charge without the cardholder present
+func TestPaymentFlow_MerchantInitiated(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+	futureYear := time.Now().Year() + 1
+
+	tokenBody, _ := json.Marshal(models.PostTokenRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  futureYear,
+	})
+	tokenReq := newRequest(http.MethodPost, "/api/tokens", bytes.NewBuffer(tokenBody))
+	tokenW := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(tokenW, tokenReq)
+	require.Equal(t, http.StatusCreated, tokenW.Code)
+
+	var tokenResp models.PostTokenResponse
+	require.NoError(t, json.NewDecoder(tokenW.Body).Decode(&tokenResp))
+
+	// Step 1: The customer sets up the subscription with their CVV
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		Source:   &models.PaymentSource{Token: tokenResp.Token},
+		Currency: "GBP",
+		Amount:   100,
+		CVV:      "123",
+		StoredCredential: &models.StoredCredentialRequest{
+			Usage: "first",
+			Type:  "recurring",
+		},
+	})
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var first models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&first))
+	assert.Equal(t, "Authorized", first.Status)
+	require.NotEmpty(t, first.NetworkTransactionID)
+
+	// Step 2: The merchant charges the next cycle without a CVV
+	renewalRequest := func(previous string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(models.PostPaymentRequest{
+			Source:    &models.PaymentSource{Token: tokenResp.Token},
+			Currency:  "GBP",
+			Amount:    100,
+			Initiator: "merchant",
+			StoredCredential: &models.StoredCredentialRequest{
+				Usage:                        "subsequent",
+				Type:                         "recurring",
+				PreviousNetworkTransactionID: previous,
+			},
+		})
+		req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, req)
+		return w
+	}
+
+	// A reference the bank never gave is not passed on to it
+	assert.Equal(t, http.StatusBadRequest, renewalRequest("123456789012345").Code)
+
+	w = renewalRequest(first.NetworkTransactionID)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var renewal models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&renewal))
+	assert.Equal(t, "Authorized", renewal.Status)
+
+	getReq := newRequest(http.MethodGet, "/api/payments/"+renewal.ID, nil)
+	getW := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(getW, getReq)
+
+	var getResp models.GetPaymentResponse
+	require.NoError(t, json.NewDecoder(getW.Body).Decode(&getResp))
+	assert.Equal(t, "merchant", getResp.Initiator)
+}
+
+// TestPaymentFlow_Events checks the audit trail of a payment, including who
+// made it and the request ID it came from
+func TestPaymentFlow_Events(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req.Header.Set("X-Request-Id", "audit-test-1")
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var postResp models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&postResp))
+
+	eventsReq := newRequest(http.MethodGet, "/api/payments/"+postResp.ID+"/events", nil)
+	eventsW := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(eventsW, eventsReq)
+	require.Equal(t, http.StatusOK, eventsW.Code)
+	assert.NotContains(t, eventsW.Body.String(), "2222405343248877")
+
+	var events []models.PaymentEventResponse
+	require.NoError(t, json.NewDecoder(eventsW.Body).Decode(&events))
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "payment.requested", events[0].Type)
+	assert.Equal(t, 100, events[0].Data.Amount)
+	assert.Equal(t, "payment.authorized", events[1].Type)
+	assert.Equal(t, "Authorized", events[1].Data.Status)
+	for _, e := range events {
+		assert.Equal(t, "audit-test-1", e.RequestID)
+		assert.Equal(t, "api_client:merchant:merchant-test", e.Actor)
+	}
+
+	missingReq := newRequest(http.MethodGet, "/api/payments/missing/events", nil)
+	missingW := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(missingW, missingReq)
+	assert.Equal(t, http.StatusNotFound, missingW.Code)
+}
+
+// TestPaymentFlow_Metrics checks payment outcomes and bank calls show up on
+// the metrics endpoint
+func TestPaymentFlow_Metrics(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+	futureYear := time.Now().Year() + 1
+
+	for _, cardNumber := range []string{"2222405343248877", "2222405343248878"} {
+		body, _ := json.Marshal(models.PostPaymentRequest{
+			CardNumber:  cardNumber,
+			ExpiryMonth: 4,
+			ExpiryYear:  futureYear,
+			Currency:    "GBP",
+			Amount:      100,
+			CVV:         "123",
+		})
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	metrics := w.Body.String()
+	assert.Contains(t, metrics, `gateway_payments_total{acquirer="simulator",currency="GBP",status="Authorized"} 1`)
+	assert.Contains(t, metrics, `gateway_payments_total{acquirer="simulator",currency="GBP",status="Declined"} 1`)
+	assert.Contains(t, metrics, `gateway_http_requests_total{method="POST",route="/api/payments",status="200"} 2`)
+	assert.Contains(t, metrics, `gateway_bank_request_duration_seconds_count{error_class="none"} 2`)
+	assert.Contains(t, metrics, "gateway_bank_requests_in_flight 0")
+	assert.Contains(t, metrics, "gateway_payments_stored 2")
+	assert.Contains(t, metrics, "gateway_bank_circuit_breaker_state 0")
+}
+
+// TestPaymentFlow_SurvivesRestart reads back a payment and pays with a token
+// from before a restart with the file storage backend
+func TestPaymentFlow_SurvivesRestart(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: filepath.Join(t.TempDir(), "data")}
+
+	first, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	var paid models.PostPaymentResponse
+	require.Equal(t, http.StatusOK, doJSON(t, first, http.MethodPost, "/api/payments", json.RawMessage(paymentRequestBody(t)), &paid))
+	var token models.PostTokenResponse
+	require.Equal(t, http.StatusCreated, doJSON(t, first, http.MethodPost, "/api/tokens", models.PostTokenRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+	}, &token))
+	require.NoError(t, first.Close())
+
+	second, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	defer second.Close()
+
+	var found models.GetPaymentResponse
+	require.Equal(t, http.StatusOK, doJSON(t, second, http.MethodGet, "/api/payments/"+paid.ID, nil, &found))
+	assert.Equal(t, "Authorized", found.Status)
+	assert.Equal(t, "8877", found.CardNumberLastFour)
+
+	var repeat models.PostPaymentResponse
+	require.Equal(t, http.StatusOK, doJSON(t, second, http.MethodPost, "/api/payments", models.PostPaymentRequest{
+		Source:   &models.PaymentSource{Token: token.Token},
+		Currency: "GBP",
+		Amount:   100,
+		CVV:      "123",
+	}, &repeat))
+	assert.Equal(t, "Authorized", repeat.Status)
+}
+
+// TestPaymentFlow_BlockedCard tests that a merchant's blocked card is turned
+// away, also after a restart, and taken again once unblocked
+func TestPaymentFlow_BlockedCard(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Storage = config.Storage{Backend: config.StorageFile, Dir: filepath.Join(t.TempDir(), "data")}
+
+	first, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	var blocked models.BlockedCardResponse
+	require.Equal(t, http.StatusCreated, doJSON(t, first, http.MethodPost, "/api/blocked-cards", models.PostBlockedCardRequest{
+		CardNumber: "2222405343248877",
+		Reason:     "chargeback",
+	}, &blocked))
+	assert.Equal(t, "8877", blocked.CardNumberLastFour)
+	require.NoError(t, first.Close())
+
+	second, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+	defer second.Close()
+
+	w := serve(second, http.MethodPost, "/api/payments", apitest.APIKey, paymentRequestBody(t))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "card is blocked")
+
+	var cards []models.BlockedCardResponse
+	require.Equal(t, http.StatusOK, doJSON(t, second, http.MethodGet, "/api/blocked-cards", nil, &cards))
+	require.Len(t, cards, 1)
+	assert.Equal(t, blocked.ID, cards[0].ID)
+
+	require.Equal(t, http.StatusNoContent, doJSON(t, second, http.MethodDelete, "/api/blocked-cards/"+blocked.ID, nil, nil))
+	assert.Equal(t, http.StatusOK, serve(second, http.MethodPost, "/api/payments", apitest.APIKey, paymentRequestBody(t)).Code)
+}
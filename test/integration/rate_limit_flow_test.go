@@ -0,0 +1,187 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/merchant"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func merchantConfig(id, apiKey string, rateLimit config.RateLimit, maxConcurrent int) config.Merchant {
+	return config.Merchant{
+		ID:                     id,
+		APIKeySHA256:           []string{merchant.HashAPIKey(apiKey)},
+		RateLimit:              rateLimit,
+		MaxConcurrentBankCalls: maxConcurrent,
+	}
+}
+
+// testMerchant is the merchant newRequest authenticates as
+func testMerchant() config.Merchant {
+	return merchantConfig("merchant-test", apitest.APIKey, config.RateLimit{}, 0)
+}
+
+func serve(testAPI *api.Api, method, path, apiKey string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitFlow_PerKeyAndPerIP(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	cfg := config.Default()
+	cfg.RateLimits.PerIP = config.RateLimit{RequestsPerSecond: 1, Burst: 2}
+	cfg.Merchants = []config.Merchant{
+		merchantConfig("big", "gw_test_big", config.RateLimit{RequestsPerSecond: 10, Burst: 5}, 0),
+		merchantConfig("small", "gw_test_small", config.RateLimit{}, 0),
+	}
+	cfg.RateLimits.PerAPIKey = config.RateLimit{RequestsPerSecond: 1, Burst: 1}
+	testAPI, err := api.NewFromConfig(cfg, api.WithClock(fakeClock))
+	require.NoError(t, err)
+
+	// Anonymous callers are limited before they are turned away for
+	// having no API key
+	get := func(apiKey string) *httptest.ResponseRecorder {
+		return serve(testAPI, http.MethodGet, "/api/plans/missing", apiKey, nil)
+	}
+
+	// Anonymous callers share their address's bucket
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusUnauthorized, get("").Code)
+	}
+	w := get("")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	var errResp models.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "Rate limit exceeded, retry later", errResp.Error)
+	assert.NotEmpty(t, errResp.RequestID)
+
+	// Each merchant has its own limit, unaffected by the address's
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusNotFound, get("gw_test_big").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, get("gw_test_big").Code)
+
+	// A merchant without its own limit gets the per-key default
+	w = get("gw_test_small")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, http.StatusTooManyRequests, get("gw_test_small").Code)
+
+	// Health checks and metrics are never limited
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+	}
+
+	fakeClock.Advance(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, get("").Code)
+	assert.Equal(t, http.StatusNotFound, get("gw_test_small").Code)
+}
+
+func TestRateLimitFlow_UnknownAPIKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{merchantConfig("merchant-1", "gw_test_key", config.RateLimit{}, 0)}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	for _, header := range []string{"Bearer gw_test_wrong", "Basic dXNlcjpwYXNz", "Bearer"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/payments/missing", nil)
+		req.Header.Set("Authorization", header)
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, header)
+		assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+	}
+
+	// The merchant's key is accepted, and a reload can revoke it
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/missing", "gw_test_key", nil).Code)
+
+	reloaded := config.Default()
+	reloaded.Merchants = []config.Merchant{merchantConfig("merchant-1", "gw_test_rotated", config.RateLimit{}, 0)}
+	testAPI.Reload(reloaded)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(testAPI, http.MethodGet, "/api/payments/missing", "gw_test_key", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/payments/missing", "gw_test_rotated", nil).Code)
+}
+
+// TestRateLimitFlow_BankConcurrencyPerMerchant checks a merchant with as
+// many payments waiting on the bank as it is allowed is turned away, while
+// another merchant's payments still go through
+func TestRateLimitFlow_BankConcurrencyPerMerchant(t *testing.T) {
+	entered := make(chan struct{}, 10)
+	release := make(chan struct{})
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+	defer close(release)
+
+	cfg := config.Default()
+	cfg.Bank.URL = bank.URL
+	cfg.Merchants = []config.Merchant{
+		merchantConfig("batch", "gw_test_batch", config.RateLimit{}, 2),
+		merchantConfig("shop", "gw_test_shop", config.RateLimit{}, 2),
+	}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	body := paymentRequestBody(t)
+	results := make(chan int, 10)
+	pay := func(apiKey string) {
+		go func() {
+			results <- serve(testAPI, http.MethodPost, "/api/payments", apiKey, body).Code
+		}()
+	}
+
+	pay("gw_test_batch")
+	pay("gw_test_batch")
+	<-entered
+	<-entered
+
+	w := serve(testAPI, http.MethodPost, "/api/payments", "gw_test_batch", body)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The other merchant is not held up by the batch
+	pay("gw_test_shop")
+	<-entered
+
+	release <- struct{}{}
+	release <- struct{}{}
+	release <- struct{}{}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, <-results)
+	}
+
+	// With its payments finished the batch merchant may pay again
+	pay("gw_test_batch")
+	<-entered
+	release <- struct{}{}
+	assert.Equal(t, http.StatusOK, <-results)
+}
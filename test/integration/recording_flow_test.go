@@ -0,0 +1,52 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRecordingFlow records the gateway's bank traffic to a cassette, as in
+// staging, and replays it without the bank
+func TestRecordingFlow(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Bank.RecordTo = filepath.Join(t.TempDir(), "bank.cassette.json")
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", bytes.NewReader(paymentRequestBody(t))))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	data, err := os.ReadFile(cfg.Bank.RecordTo)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "2222405343248877")
+	assert.Contains(t, string(data), "pan_tok_1")
+
+	cassette, err := client.LoadCassette(cfg.Bank.RecordTo)
+	require.NoError(t, err)
+	payments, err := cassette.Payments()
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+
+	replayer := client.NewReplayClient(cassette)
+	for _, p := range payments {
+		resp, err := replayer.ProcessPayment(context.Background(), p)
+		require.NoError(t, err)
+		assert.True(t, resp.Authorized)
+	}
+}
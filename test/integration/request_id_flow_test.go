@@ -0,0 +1,120 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func paymentRequestBody(t *testing.T) []byte {
+	body, err := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  "2222405343248877",
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	require.NoError(t, err)
+	return body
+}
+
+// TestRequestIDFlow follows the merchant's request and correlation IDs from
+// the request to the response, the stored payment and the bank
+func TestRequestIDFlow(t *testing.T) {
+	var bankHeaders http.Header
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bankHeaders = r.Header.Clone()
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+
+	testAPI := apitest.NewWithBankURL(bank.URL)
+
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(paymentRequestBody(t)))
+	req.Header.Set("X-Request-ID", "merchant-req-1")
+	req.Header.Set("X-Correlation-ID", "order-42")
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, "merchant-req-1", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "order-42", w.Header().Get("X-Correlation-ID"))
+	assert.Equal(t, "merchant-req-1", bankHeaders.Get("X-Request-ID"))
+	assert.Equal(t, "order-42", bankHeaders.Get("X-Correlation-ID"))
+
+	var created models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	assert.Equal(t, "merchant-req-1", created.RequestID)
+	assert.Equal(t, "order-42", created.CorrelationID)
+
+	// The stored payment keeps the IDs of the request that created it, not
+	// the one reading it
+	getW := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(getW, newRequest(http.MethodGet, "/api/payments/"+created.ID, nil))
+	require.Equal(t, http.StatusOK, getW.Code)
+	assert.NotEqual(t, "merchant-req-1", getW.Header().Get("X-Request-ID"))
+
+	var fetched models.GetPaymentResponse
+	require.NoError(t, json.NewDecoder(getW.Body).Decode(&fetched))
+	assert.Equal(t, "merchant-req-1", fetched.RequestID)
+	assert.Equal(t, "order-42", fetched.CorrelationID)
+}
+
+func TestRequestIDFlow_GeneratesID(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{name: "missing", requestID: ""},
+		{name: "too long", requestID: strings.Repeat("a", 129)},
+		{name: "unsafe characters", requestID: "req 1\r\nX-Evil: 1"},
+		{name: "looks like a card number", requestID: "2222405343248877"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testAPI := apitest.New()
+
+			req := newRequest(http.MethodGet, "/api/payments/missing", nil)
+			if tt.requestID != "" {
+				req.Header.Set("X-Request-ID", tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			testAPI.Router().ServeHTTP(w, req)
+
+			requestID := w.Header().Get("X-Request-ID")
+			_, err := uuid.Parse(requestID)
+			assert.NoError(t, err, "expected a generated UUID, got %q", requestID)
+		})
+	}
+}
+
+func TestRequestIDFlow_ErrorBody(t *testing.T) {
+	testAPI := apitest.New()
+
+	req := newRequest(http.MethodGet, "/api/payments/missing", nil)
+	req.Header.Set("X-Request-ID", "merchant-req-2")
+	req.Header.Set("X-Correlation-ID", "order 43") // not a usable ID
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("X-Correlation-ID"))
+
+	var errResp models.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "merchant-req-2", errResp.RequestID)
+	assert.NotEmpty(t, errResp.Error)
+}
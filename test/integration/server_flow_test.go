@@ -0,0 +1,231 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/tlsconfig/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// runServer serves cfg on a free port, and the gRPC API on another, until
+// the test ends and returns the REST API's address
+func runServer(t *testing.T, cfg *config.Config) string {
+	cfg.Server.Addr = freeAddr(t)
+	cfg.GRPC.Addr = freeAddr(t)
+	cfg.Server.DrainDelay = 0
+
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- testAPI.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	// Wait until both accept connections
+	for _, addr := range []string{cfg.Server.Addr, cfg.GRPC.Addr} {
+		require.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	return cfg.Server.Addr
+}
+
+// tlsClient trusts only ca, presents the given client certificate and
+// opens a new connection for every request
+func tlsClient(ca *tlstest.CA, certs ...tls.Certificate) *http.Client {
+	tlsConfig := &tls.Config{RootCAs: ca.Pool()}
+	if len(certs) > 0 {
+		// Offer the certificate even when the server asks for another CA's
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &certs[0], nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func tlsConfigFor(t *testing.T, ca *tlstest.CA, dir string) config.TLS {
+	certFile, keyFile := ca.ServerCert(t, "gateway").WriteFiles(t, dir)
+	caFile := filepath.Join(dir, "client-ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.CertPEM, 0o600))
+	return config.TLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: config.ClientAuthNone}
+}
+
+func TestServerFlow_TLSWithCertificateReload(t *testing.T) {
+	ca := tlstest.NewCA(t, "test CA")
+	dir := t.TempDir()
+
+	cfg := config.Default()
+	cfg.TLS = tlsConfigFor(t, ca, dir)
+	addr := runServer(t, cfg)
+
+	httpsClient := tlsClient(ca)
+	resp, err := httpsClient.Get("https://" + addr + "/health/live")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gateway", resp.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// Plain HTTP is not served
+	resp, err = http.Get("http://" + addr + "/health/live")
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// A renewed certificate is used for new connections without a restart
+	ca.ServerCert(t, "gateway-renewed").WriteFiles(t, dir)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cfg.TLS.CertFile, later, later))
+	require.NoError(t, os.Chtimes(cfg.TLS.KeyFile, later, later))
+
+	resp, err = httpsClient.Get("https://" + addr + "/health/live")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "gateway-renewed", resp.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestServerFlow_MutualTLS(t *testing.T) {
+	ca := tlstest.NewCA(t, "test CA")
+	otherCA := tlstest.NewCA(t, "someone else's CA")
+	merchantCert := ca.ClientCert(t, "merchant-1").TLSCertificate(t)
+	strangerCert := otherCA.ClientCert(t, "merchant-1").TLSCertificate(t)
+
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+
+	t.Run("required", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.Merchants = []config.Merchant{testMerchant()}
+		cfg.Bank.URL = bank.URL
+		cfg.TLS = tlsConfigFor(t, ca, t.TempDir())
+		cfg.TLS.ClientAuth = config.ClientAuthRequire
+		addr := runServer(t, cfg)
+
+		_, err := tlsClient(ca).Get("https://" + addr + "/health/live")
+		assert.Error(t, err, "a caller without a certificate must be turned away")
+
+		_, err = tlsClient(ca, strangerCert).Get("https://" + addr + "/health/live")
+		assert.Error(t, err, "a certificate from another CA must be turned away")
+
+		// The certificate lets the merchant connect, and its API key
+		// identifies it in the audit log
+		merchant := tlsClient(ca, merchantCert)
+		req := newRequest(http.MethodPost, "https://"+addr+"/api/payments", bytes.NewReader(paymentRequestBody(t)))
+		req.RequestURI = ""
+		resp, err := merchant.Do(req)
+		require.NoError(t, err)
+		var created models.PostPaymentResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		req = newRequest(http.MethodGet, "https://"+addr+"/api/payments/"+created.ID+"/events", nil)
+		req.RequestURI = ""
+		resp, err = merchant.Do(req)
+		require.NoError(t, err)
+		var events []models.PaymentEventResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+		resp.Body.Close()
+		require.NotEmpty(t, events)
+		assert.Equal(t, "api_client:merchant:merchant-test", events[0].Actor)
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.TLS = tlsConfigFor(t, ca, t.TempDir())
+		cfg.TLS.ClientAuth = config.ClientAuthOptional
+		addr := runServer(t, cfg)
+
+		for _, c := range []*http.Client{tlsClient(ca), tlsClient(ca, merchantCert)} {
+			resp, err := c.Get("https://" + addr + "/health/live")
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+
+		// A certificate that is offered must still be valid
+		_, err := tlsClient(ca, strangerCert).Get("https://" + addr + "/health/live")
+		assert.Error(t, err)
+	})
+}
+
+func TestServerFlow_BodyLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.Merchants = []config.Merchant{testMerchant()}
+	cfg.Server.MaxBodyBytes = 1024
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	body := `{"card_number":"2222405343248877","currency":"` + strings.Repeat("G", 2048) + `"}`
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, newRequest(http.MethodPost, "/api/payments", strings.NewReader(body)))
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	var errResp models.ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&errResp))
+	assert.Equal(t, "Request body must not be larger than 1024 bytes", errResp.Error)
+}
+
+// TestServerFlow_SlowClient checks a client that never finishes sending its
+// headers is disconnected rather than holding a connection open
+func TestServerFlow_SlowClient(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.ReadHeaderTimeout = 100 * time.Millisecond
+	addr := runServer(t, cfg)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /health/live HTTP/1.1\r\nHost: localhost\r\n"))
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	start := time.Now()
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err, "the server should close the connection")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,170 @@
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/config"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doJSON(t *testing.T, testAPI *api.Api, method, path string, reqBody, respBody interface{}) int {
+	t.Helper()
+
+	var body bytes.Buffer
+	if reqBody != nil {
+		require.NoError(t, json.NewEncoder(&body).Encode(reqBody))
+	}
+
+	req := newRequest(method, path, &body)
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+
+	if respBody != nil && w.Code < http.StatusBadRequest {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(respBody))
+	}
+	return w.Code
+}
+
+func createSubscription(t *testing.T, testAPI *api.Api, cardNumber string) (int, *models.SubscriptionResponse) {
+	t.Helper()
+
+	var token models.PostTokenResponse
+	code := doJSON(t, testAPI, http.MethodPost, "/api/tokens", models.PostTokenRequest{
+		CardNumber:  cardNumber,
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 2,
+	}, &token)
+	require.Equal(t, http.StatusCreated, code)
+
+	var plan models.PlanResponse
+	code = doJSON(t, testAPI, http.MethodPost, "/api/plans", models.PostPlanRequest{
+		Name:     "Pro monthly",
+		Amount:   999,
+		Currency: "GBP",
+		Interval: "month",
+	}, &plan)
+	require.Equal(t, http.StatusCreated, code)
+
+	var sub models.SubscriptionResponse
+	code = doJSON(t, testAPI, http.MethodPost, "/api/subscriptions", models.PostSubscriptionRequest{
+		PlanID: plan.ID,
+		Source: &models.PaymentSource{Token: token.Token},
+		CVV:    "123",
+	}, &sub)
+	return code, &sub
+}
+
+// TestSubscriptionFlow_Renewal subscribes an authorized card and moves the
+// clock forward so the scheduler renews it through the bank simulator
+func TestSubscriptionFlow_Renewal(t *testing.T) {
+	fakeClock := clock.NewFake(time.Date(2026, time.January, 31, 9, 0, 0, 0, time.UTC))
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t), api.WithClock(fakeClock))
+
+	code, sub := createSubscription(t, testAPI, "2222405343248877") // Ends in 7 (odd) - will be authorized
+	require.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "active", sub.Status)
+	assert.Equal(t, time.Date(2026, time.February, 28, 9, 0, 0, 0, time.UTC), sub.CurrentPeriodEnd)
+
+	// Nothing is due yet
+	assert.Equal(t, 0, testAPI.Scheduler().RunOnce(context.Background()))
+
+	fakeClock.Set(sub.CurrentPeriodEnd)
+	assert.Equal(t, 1, testAPI.Scheduler().RunOnce(context.Background()))
+
+	var renewed models.SubscriptionResponse
+	code = doJSON(t, testAPI, http.MethodGet, "/api/subscriptions/"+sub.ID, nil, &renewed)
+	require.Equal(t, http.StatusOK, code)
+
+	assert.Equal(t, "active", renewed.Status)
+	assert.Equal(t, time.Date(2026, time.March, 31, 9, 0, 0, 0, time.UTC), renewed.CurrentPeriodEnd)
+	require.Len(t, renewed.Charges, 2)
+	assert.Equal(t, "renewal", renewed.Charges[1].Reason)
+	assert.Equal(t, "Authorized", renewed.Charges[1].Status)
+
+	// The renewal is an ordinary merchant-initiated payment
+	var payment models.GetPaymentResponse
+	code = doJSON(t, testAPI, http.MethodGet, "/api/payments/"+renewed.Charges[1].PaymentID, nil, &payment)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "merchant", payment.Initiator)
+	assert.Equal(t, 999, payment.Amount)
+
+	// and the audit log shows the scheduler made it
+	var events []models.PaymentEventResponse
+	code = doJSON(t, testAPI, http.MethodGet, "/api/payments/"+payment.ID+"/events", nil, &events)
+	require.Equal(t, http.StatusOK, code)
+	require.NotEmpty(t, events)
+	assert.Equal(t, "system:subscription-scheduler", events[0].Actor)
+
+	// Cancelling at period end stops the next renewal
+	var canceled models.SubscriptionResponse
+	code = doJSON(t, testAPI, http.MethodPost, "/api/subscriptions/"+sub.ID+"/cancel", models.CancelSubscriptionRequest{AtPeriodEnd: true}, &canceled)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, canceled.CancelAtPeriodEnd)
+
+	fakeClock.Set(renewed.CurrentPeriodEnd)
+	testAPI.Scheduler().RunOnce(context.Background())
+
+	code = doJSON(t, testAPI, http.MethodGet, "/api/subscriptions/"+sub.ID, nil, &canceled)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "canceled", canceled.Status)
+	assert.Len(t, canceled.Charges, 2)
+}
+
+// TestSubscriptionFlow_Declined checks that a declined first payment does not
+// start a subscription
+func TestSubscriptionFlow_Declined(t *testing.T) {
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t))
+
+	code, _ := createSubscription(t, testAPI, "2222405343248112") // Ends in 2 (even) - will be declined
+	assert.Equal(t, http.StatusPaymentRequired, code)
+}
+
+// TestSubscriptionFlow_MerchantScoped checks that tokens, plans and
+// subscriptions need an API key and are hidden from other merchants
+func TestSubscriptionFlow_MerchantScoped(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bank.URL = startBankSimulator(t)
+	cfg.Merchants = []config.Merchant{
+		testMerchant(),
+		merchantConfig("merchant-b", "gw_test_b", config.RateLimit{}, 0),
+	}
+	testAPI, err := api.NewFromConfig(cfg)
+	require.NoError(t, err)
+
+	code, sub := createSubscription(t, testAPI, "2222405343248877")
+	require.Equal(t, http.StatusCreated, code)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/tokens"},
+		{http.MethodDelete, "/api/tokens/tok_abc"},
+		{http.MethodPost, "/api/plans"},
+		{http.MethodGet, "/api/plans/" + sub.PlanID},
+		{http.MethodPost, "/api/subscriptions"},
+		{http.MethodGet, "/api/subscriptions/" + sub.ID},
+		{http.MethodPost, "/api/subscriptions/" + sub.ID + "/pause"},
+		{http.MethodPost, "/api/subscriptions/" + sub.ID + "/resume"},
+		{http.MethodPost, "/api/subscriptions/" + sub.ID + "/cancel"},
+		{http.MethodPost, "/api/subscriptions/" + sub.ID + "/change-plan"},
+	} {
+		assert.Equal(t, http.StatusUnauthorized, serve(testAPI, route.method, route.path, "", []byte("{}")).Code, route.path)
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/plans/"+sub.PlanID, "gw_test_b", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodGet, "/api/subscriptions/"+sub.ID, "gw_test_b", nil).Code)
+	assert.Equal(t, http.StatusNotFound, serve(testAPI, http.MethodPost, "/api/subscriptions/"+sub.ID+"/cancel", "gw_test_b", nil).Code)
+
+	var found models.SubscriptionResponse
+	require.Equal(t, http.StatusOK, doJSON(t, testAPI, http.MethodGet, "/api/subscriptions/"+sub.ID, nil, &found))
+	assert.Equal(t, "active", found.Status)
+}
@@ -0,0 +1,98 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/client"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTracingFlow follows one payment from the handler to the bank and back,
+// checking every layer joins the caller's trace and no span holds the PAN
+func TestTracingFlow(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	const callerTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const cardNumber = "2222405343248877"
+
+	var bankTraceparent string
+	bank := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bankTraceparent = r.Header.Get("traceparent")
+		json.NewEncoder(w).Encode(client.BankResponse{Authorized: true, AuthorizationCode: "auth-1"})
+	}))
+	defer bank.Close()
+
+	testAPI := apitest.NewWithBankURL(bank.URL)
+
+	body, _ := json.Marshal(models.PostPaymentRequest{
+		CardNumber:  cardNumber,
+		ExpiryMonth: 4,
+		ExpiryYear:  time.Now().Year() + 1,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	})
+	req := newRequest(http.MethodPost, "/api/payments", bytes.NewBuffer(body))
+	req.Header.Set("traceparent", "00-"+callerTraceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	testAPI.Router().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var payment models.PostPaymentResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&payment))
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		assert.Equal(t, callerTraceID, span.SpanContext().TraceID().String(), span.Name())
+
+		for _, attr := range span.Attributes() {
+			assert.NotContains(t, attr.Value.Emit(), cardNumber, "%s has the PAN in %s", span.Name(), attr.Key)
+		}
+		for _, event := range span.Events() {
+			for _, attr := range event.Attributes {
+				assert.NotContains(t, attr.Value.Emit(), cardNumber, "%s has the PAN in an event", span.Name())
+			}
+		}
+	}
+
+	for _, name := range []string{
+		"POST /api/payments",
+		"PaymentService.ProcessPayment",
+		"HTTPBankClient.ProcessPayment",
+		"POST /payments",
+		"PaymentsRepository.Save",
+	} {
+		assert.Contains(t, spans, name)
+	}
+
+	serviceSpan := spans["PaymentService.ProcessPayment"]
+	require.NotNil(t, serviceSpan)
+	attrs := map[string]string{}
+	for _, attr := range serviceSpan.Attributes() {
+		attrs[string(attr.Key)] = attr.Value.Emit()
+	}
+	assert.Equal(t, payment.ID, attrs["payment.id"])
+	assert.Equal(t, "Authorized", attrs["payment.status"])
+	assert.Equal(t, "GBP", attrs["payment.currency"])
+
+	// The bank sees the outbound HTTP span as its parent
+	bankSpan := spans["POST /payments"]
+	require.NotNil(t, bankSpan)
+	assert.True(t, strings.HasPrefix(bankTraceparent, "00-"+callerTraceID+"-"+bankSpan.SpanContext().SpanID().String()))
+}
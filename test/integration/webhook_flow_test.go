@@ -0,0 +1,133 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/api"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/apitest"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/clock"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/models"
+	"github.com/cko-recruitment/payment-gateway-challenge-go/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWebhookFlow registers an endpoint, makes payments through the bank
+// simulator and checks the signed deliveries, including a failure that is
+// retried from the outbox
+func TestWebhookFlow(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	testAPI := apitest.NewWithBankURL(startBankSimulator(t), api.WithClock(fakeClock))
+	futureYear := time.Now().Year() + 1
+
+	var (
+		mu       sync.Mutex
+		bodies   [][]byte
+		failNext = true
+		secret   string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err := webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), body, fakeClock.Now(), 5*time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failNext {
+			failNext = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	var endpoint models.WebhookEndpointResponse
+	code := doJSON(t, testAPI, http.MethodPost, "/api/webhook-endpoints", models.PostWebhookEndpointRequest{
+		URL:        receiver.URL,
+		EventTypes: []string{"payment.authorized", "payment.declined"},
+	}, &endpoint)
+	require.Equal(t, http.StatusCreated, code)
+	require.NotEmpty(t, endpoint.Secret)
+
+	mu.Lock()
+	secret = endpoint.Secret
+	mu.Unlock()
+
+	var payment models.PostPaymentResponse
+	code = doJSON(t, testAPI, http.MethodPost, "/api/payments", models.PostPaymentRequest{
+		CardNumber:  "2222405343248877", // Ends in 7 (odd) - will be authorized
+		ExpiryMonth: 4,
+		ExpiryYear:  futureYear,
+		Currency:    "GBP",
+		Amount:      100,
+		CVV:         "123",
+	}, &payment)
+	require.Equal(t, http.StatusOK, code)
+
+	// The first attempt fails and is scheduled for a retry
+	assert.Equal(t, 1, testAPI.Dispatcher().RunOnce(context.Background()))
+
+	var pending []models.WebhookDeliveryResponse
+	code = doJSON(t, testAPI, http.MethodGet, "/api/webhook-endpoints/"+endpoint.ID+"/deliveries?status=pending", nil, &pending)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Failures)
+	require.Len(t, pending[0].Attempts, 1)
+	assert.Equal(t, http.StatusServiceUnavailable, pending[0].Attempts[0].StatusCode)
+
+	// A manual redelivery of a queued delivery is refused
+	code = doJSON(t, testAPI, http.MethodPost, "/api/webhook-deliveries/"+pending[0].ID+"/redeliver", nil, nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Once the backoff has passed the dispatcher sends it again
+	require.NotNil(t, pending[0].NextAttemptAt)
+	fakeClock.Set(*pending[0].NextAttemptAt)
+	require.Equal(t, 1, testAPI.Dispatcher().RunOnce(context.Background()))
+
+	mu.Lock()
+	require.Len(t, bodies, 1)
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			ID                 string `json:"id"`
+			Status             string `json:"status"`
+			CardNumberLastFour string `json:"card_number_last_four"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[0], &event))
+	assert.NotContains(t, string(bodies[0]), "2222405343248877")
+	mu.Unlock()
+
+	assert.Equal(t, "payment.authorized", event.Type)
+	assert.Equal(t, payment.ID, event.Data.ID)
+	assert.Equal(t, "Authorized", event.Data.Status)
+	assert.Equal(t, "8877", event.Data.CardNumberLastFour)
+
+	var delivered models.WebhookDeliveryResponse
+	code = doJSON(t, testAPI, http.MethodGet, "/api/webhook-deliveries/"+pending[0].ID, nil, &delivered)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "succeeded", delivered.Status)
+	assert.Len(t, delivered.Attempts, 2)
+
+	// Redelivering a delivered event sends it again
+	code = doJSON(t, testAPI, http.MethodPost, "/api/webhook-deliveries/"+delivered.ID+"/redeliver", nil, nil)
+	require.Equal(t, http.StatusAccepted, code)
+	require.Equal(t, 1, testAPI.Dispatcher().RunOnce(context.Background()))
+
+	mu.Lock()
+	assert.Len(t, bodies, 2)
+	assert.Equal(t, bodies[0], bodies[1])
+	mu.Unlock()
+}